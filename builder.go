@@ -0,0 +1,388 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Builder builds a module from declarations of types, imports, functions,
+// tables, memories, globals, exports and segments.
+//
+// Builder manages the index spaces of the module: imported entities are
+// laid out before defined ones, whatever the order of declaration.
+// The index of a defined entity is thus only known once all imports have
+// been declared; declaring an import after having retrieved the index of
+// a defined entity of the same kind is an error reported by Build.
+type Builder struct {
+	types   []FuncType
+	typeIdx map[string]uint32
+
+	imports []ImportEntry
+	funcs   []*Func
+	tables  []*Table
+	mems    []*Memory
+	globals []*Global
+	exports []export
+	start   *Func
+	elems   []elemSegment
+	data    []dataSegment
+
+	nimports [4]int  // number of imports, per ExternalKind
+	sealed   [4]bool // whether indices of defined entities were handed out, per ExternalKind
+	err      error
+}
+
+// NewBuilder returns a new module builder.
+func NewBuilder() *Builder {
+	return &Builder{typeIdx: make(map[string]uint32)}
+}
+
+// Extern is a function, table, memory or global of a module.
+type Extern interface {
+	Kind() ExternalKind // kind of the entity
+	Index() uint32      // index of the entity in the index space of its kind
+}
+
+// entity is the part common to all entities declared through a Builder.
+type entity struct {
+	b        *Builder
+	kind     ExternalKind
+	imported bool
+	pos      int // position among the imported or defined entities of the same kind
+}
+
+func (e *entity) Kind() ExternalKind { return e.kind }
+
+// Index returns the index of the entity in the index space of its kind.
+func (e *entity) Index() uint32 {
+	if e.imported {
+		return uint32(e.pos)
+	}
+	e.b.sealed[e.kind] = true
+	return uint32(e.b.nimports[e.kind] + e.pos)
+}
+
+func (b *Builder) newEntity(kind ExternalKind, imported bool) entity {
+	e := entity{b: b, kind: kind, imported: imported}
+	if imported {
+		if b.sealed[kind] && b.err == nil {
+			b.err = fmt.Errorf("wasm: import of kind %d declared after indices of defined entities were used", kind)
+		}
+		e.pos = b.nimports[kind]
+		b.nimports[kind]++
+		return e
+	}
+	switch kind {
+	case FunctionKind:
+		e.pos = len(b.funcs) - b.nimports[kind]
+	case TableKind:
+		e.pos = len(b.tables) - b.nimports[kind]
+	case MemoryKind:
+		e.pos = len(b.mems) - b.nimports[kind]
+	case GlobalKind:
+		e.pos = len(b.globals) - b.nimports[kind]
+	}
+	return e
+}
+
+// Func is a function declared by a Builder.
+type Func struct {
+	entity
+	Name string // name of the function, recorded in the name section if not empty
+
+	typ     uint32 // type index
+	body    *FunctionBody
+	emitter *Emitter // assembler of the body, if any
+}
+
+// Type returns the index of the signature of the function.
+func (f *Func) Type() uint32 { return f.typ }
+
+// SetBody sets the local variables and the code of a defined function.
+// The code must not include the terminating end opcode.
+func (f *Func) SetBody(locals []LocalEntry, code []byte) {
+	if f.imported {
+		panic("wasm: cannot set the body of an imported function")
+	}
+	f.body = &FunctionBody{
+		LocalCount: varuint32(len(locals)),
+		Locals:     locals,
+		Code:       Code{Code: code, End: Op_end},
+	}
+	f.emitter = nil
+}
+
+// Table is a table declared by a Builder.
+type Table struct {
+	entity
+	Type TableType
+}
+
+// Memory is a linear memory declared by a Builder.
+type Memory struct {
+	entity
+	Type MemoryType
+}
+
+// Global is a global variable declared by a Builder.
+type Global struct {
+	entity
+	Type GlobalType
+	Init InitExpr // initial value of a defined global
+}
+
+type export struct {
+	name string
+	x    Extern
+}
+
+type elemSegment struct {
+	table  Extern
+	offset InitExpr
+	funcs  []Extern
+}
+
+type dataSegment struct {
+	mem    Extern
+	offset InitExpr
+	data   []byte
+}
+
+// Type declares the function signature ft and returns its index in the
+// type section. Identical signatures share the same index.
+func (b *Builder) Type(ft FuncType) uint32 {
+	key := typeKey(ft)
+	if idx, ok := b.typeIdx[key]; ok {
+		return idx
+	}
+	idx := uint32(len(b.types))
+	b.types = append(b.types, FuncType{
+		Params:  append([]ValueType{}, ft.Params...),
+		Results: append([]ValueType{}, ft.Results...),
+	})
+	b.typeIdx[key] = idx
+	return idx
+}
+
+func typeKey(ft FuncType) string {
+	var o strings.Builder
+	for _, vt := range ft.Params {
+		o.WriteByte(byte(vt))
+	}
+	o.WriteByte(Op_func)
+	for _, vt := range ft.Results {
+		o.WriteByte(byte(vt))
+	}
+	return o.String()
+}
+
+func (b *Builder) addImport(module, field string, kind ExternalKind, typ interface{}) {
+	b.imports = append(b.imports, ImportEntry{
+		Module: module,
+		Field:  field,
+		Kind:   kind,
+		Type:   typ,
+	})
+}
+
+// ImportFunc declares a function with signature ft, imported from
+// module.field.
+func (b *Builder) ImportFunc(module, field string, ft FuncType) *Func {
+	f := &Func{entity: b.newEntity(FunctionKind, true), typ: b.Type(ft)}
+	b.addImport(module, field, FunctionKind, f.typ)
+	b.funcs = append(b.funcs, f)
+	return f
+}
+
+// Func declares a function with signature ft, defined in the module.
+// Its body must be provided before the module is built.
+func (b *Builder) Func(name string, ft FuncType) *Func {
+	f := &Func{entity: b.newEntity(FunctionKind, false), Name: name, typ: b.Type(ft)}
+	b.funcs = append(b.funcs, f)
+	return f
+}
+
+// ImportTable declares a table imported from module.field.
+func (b *Builder) ImportTable(module, field string, tt TableType) *Table {
+	t := &Table{entity: b.newEntity(TableKind, true), Type: tt}
+	b.addImport(module, field, TableKind, tt)
+	b.tables = append(b.tables, t)
+	return t
+}
+
+// Table declares a table defined in the module.
+func (b *Builder) Table(tt TableType) *Table {
+	t := &Table{entity: b.newEntity(TableKind, false), Type: tt}
+	b.tables = append(b.tables, t)
+	return t
+}
+
+// ImportMemory declares a linear memory imported from module.field.
+func (b *Builder) ImportMemory(module, field string, mt MemoryType) *Memory {
+	m := &Memory{entity: b.newEntity(MemoryKind, true), Type: mt}
+	b.addImport(module, field, MemoryKind, mt)
+	b.mems = append(b.mems, m)
+	return m
+}
+
+// Memory declares a linear memory defined in the module.
+func (b *Builder) Memory(mt MemoryType) *Memory {
+	m := &Memory{entity: b.newEntity(MemoryKind, false), Type: mt}
+	b.mems = append(b.mems, m)
+	return m
+}
+
+// ImportGlobal declares a global variable imported from module.field.
+func (b *Builder) ImportGlobal(module, field string, gt GlobalType) *Global {
+	g := &Global{entity: b.newEntity(GlobalKind, true), Type: gt}
+	b.addImport(module, field, GlobalKind, gt)
+	b.globals = append(b.globals, g)
+	return g
+}
+
+// Global declares a global variable defined in the module, with the
+// provided initial value.
+func (b *Builder) Global(gt GlobalType, init InitExpr) *Global {
+	g := &Global{entity: b.newEntity(GlobalKind, false), Type: gt, Init: init}
+	b.globals = append(b.globals, g)
+	return g
+}
+
+// Export exports x under the provided name.
+func (b *Builder) Export(name string, x Extern) {
+	b.exports = append(b.exports, export{name: name, x: x})
+}
+
+// Start declares f as the start function of the module.
+func (b *Builder) Start(f *Func) {
+	b.start = f
+}
+
+// Elements declares an element segment initializing the table at the
+// provided offset with references to funcs.
+func (b *Builder) Elements(table Extern, offset InitExpr, funcs ...Extern) {
+	b.elems = append(b.elems, elemSegment{table: table, offset: offset, funcs: funcs})
+}
+
+// Data declares a data segment initializing the linear memory at the
+// provided offset with data.
+func (b *Builder) Data(mem Extern, offset InitExpr, data []byte) {
+	b.data = append(b.data, dataSegment{mem: mem, offset: offset, data: data})
+}
+
+// Build returns the module made of all the declarations.
+func (b *Builder) Build() (*Module, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	m := &Module{Header: ModuleHeader{Magic: magicWASM, Version: Version}}
+	add := func(ok bool, s Section) {
+		if ok {
+			m.Sections = append(m.Sections, s)
+		}
+	}
+
+	add(len(b.types) > 0, TypeSection{Types: b.types})
+	add(len(b.imports) > 0, ImportSection{Imports: b.imports})
+
+	var (
+		funcs  FunctionSection
+		code   CodeSection
+		names  = NameSection{Name: "name"}
+		tables TableSection
+		mems   MemorySection
+		gvars  GlobalSection
+	)
+	for _, f := range b.funcs {
+		if f.Name != "" {
+			names.Funcs = append(names.Funcs, FunctionNames{Index: f.Index(), Name: f.Name})
+		}
+		if f.imported {
+			continue
+		}
+		body := f.body
+		if f.emitter != nil {
+			var err error
+			body, err = f.emitter.body()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if body == nil {
+			return nil, fmt.Errorf("wasm: function %d (%q) has no body", f.Index(), f.Name)
+		}
+		funcs.Types = append(funcs.Types, f.typ)
+		code.Bodies = append(code.Bodies, *body)
+	}
+	for _, t := range b.tables {
+		if !t.imported {
+			tables.Tables = append(tables.Tables, t.Type)
+		}
+	}
+	for _, mem := range b.mems {
+		if !mem.imported {
+			mems.Memories = append(mems.Memories, mem.Type)
+		}
+	}
+	for _, g := range b.globals {
+		if !g.imported {
+			gvars.Globals = append(gvars.Globals, GlobalVariable{Type: g.Type, Init: g.Init})
+		}
+	}
+	add(len(funcs.Types) > 0, funcs)
+	add(len(tables.Tables) > 0, tables)
+	add(len(mems.Memories) > 0, mems)
+	add(len(gvars.Globals) > 0, gvars)
+
+	var exports ExportSection
+	seen := make(map[string]bool, len(b.exports))
+	for _, e := range b.exports {
+		if seen[e.name] {
+			return nil, fmt.Errorf("wasm: duplicate export name %q", e.name)
+		}
+		seen[e.name] = true
+		exports.Exports = append(exports.Exports, ExportEntry{
+			Field: e.name,
+			Kind:  e.x.Kind(),
+			Index: e.x.Index(),
+		})
+	}
+	add(len(exports.Exports) > 0, exports)
+
+	if b.start != nil {
+		add(true, StartSection{Index: b.start.Index()})
+	}
+
+	var elems ElementSection
+	for _, seg := range b.elems {
+		es := ElemSegment{
+			Index:  seg.table.Index(),
+			Offset: seg.offset,
+			Elems:  make([]uint32, len(seg.funcs)),
+		}
+		for i, f := range seg.funcs {
+			es.Elems[i] = f.Index()
+		}
+		elems.Elements = append(elems.Elements, es)
+	}
+	add(len(elems.Elements) > 0, elems)
+	add(len(code.Bodies) > 0, code)
+
+	var data DataSection
+	for _, seg := range b.data {
+		data.Segments = append(data.Segments, DataSegment{
+			Index:  seg.mem.Index(),
+			Offset: seg.offset,
+			Data:   seg.data,
+		})
+	}
+	add(len(data.Segments) > 0, data)
+	add(len(names.Funcs) > 0, names)
+
+	return m, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestBuilderAdd(t *testing.T) {
+	want, err := os.ReadFile("testdata/add.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := wasm.NewBuilder()
+	add := b.Func("", wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.I32},
+		Results: []wasm.ValueType{wasm.I32},
+	})
+	add.SetBody(nil, []byte{
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_get_local), 1,
+		wasm.Op_i32_add,
+	})
+	b.Export("add", add)
+
+	mod, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Encode(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid module:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestBuilderIndexSpaces(t *testing.T) {
+	var (
+		b    = wasm.NewBuilder()
+		void = wasm.FuncType{}
+		i2i  = wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+	)
+
+	f1 := b.Func("f1", void)
+	f1.SetBody(nil, nil)
+	imp1 := b.ImportFunc("env", "imp1", i2i)
+	f2 := b.Func("f2", i2i)
+	f2.SetBody(nil, []byte{byte(wasm.Op_get_local), 0})
+	imp2 := b.ImportFunc("env", "imp2", void)
+
+	mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	gimp := b.ImportGlobal("env", "base", wasm.GlobalType{ContentType: wasm.I32})
+	gdef := b.Global(wasm.GlobalType{ContentType: wasm.I64, Mutability: 1}, wasm.ConstI64(-42))
+	tbl := b.ImportTable("env", "table", wasm.TableType{
+		ElemType: wasm.ElemType(wasm.Op_anyfunc),
+		Limits:   wasm.ResizableLimits{Initial: 2},
+	})
+
+	b.Export("f2", f2)
+	b.Export("mem", mem)
+	b.Export("g", gdef)
+	b.Start(f1)
+	b.Elements(tbl, wasm.ConstGlobal(gimp.Index()), f2, imp1)
+	b.Data(mem, wasm.ConstI32(16), []byte("hello"))
+
+	if got, want := b.Type(void), uint32(0); got != want {
+		t.Fatalf("invalid type index: got=%d, want=%d", got, want)
+	}
+	if got, want := b.Type(wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}), uint32(1); got != want {
+		t.Fatalf("invalid type index: got=%d, want=%d", got, want)
+	}
+
+	mod, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		x    wasm.Extern
+		want uint32
+	}{
+		{imp1, 0}, {imp2, 1}, {f1, 2}, {f2, 3},
+		{gimp, 0}, {gdef, 1}, {mem, 0}, {tbl, 0},
+	} {
+		if got := tc.x.Index(); got != tc.want {
+			t.Fatalf("invalid index: got=%d, want=%d", got, tc.want)
+		}
+	}
+
+	raw, err := wasm.Encode(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []wasm.SectionID
+	for _, s := range got.Sections {
+		ids = append(ids, s.ID())
+	}
+	want := []wasm.SectionID{
+		wasm.TypeID, wasm.ImportID, wasm.FunctionID, wasm.MemoryID,
+		wasm.GlobalID, wasm.ExportID, wasm.StartID, wasm.ElementID,
+		wasm.CodeID, wasm.DataID, wasm.UnknownID,
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("invalid sections:\ngot= %v\nwant=%v", ids, want)
+	}
+
+	for _, s := range got.Sections {
+		switch s := s.(type) {
+		case wasm.FunctionSection:
+			if want := []uint32{0, 1}; !reflect.DeepEqual(s.Types, want) {
+				t.Fatalf("invalid function types: got=%v, want=%v", s.Types, want)
+			}
+		case wasm.ExportSection:
+			want := []wasm.ExportEntry{
+				{Field: "f2", Kind: wasm.FunctionKind, Index: 3},
+				{Field: "mem", Kind: wasm.MemoryKind, Index: 0},
+				{Field: "g", Kind: wasm.GlobalKind, Index: 1},
+			}
+			if !reflect.DeepEqual(s.Exports, want) {
+				t.Fatalf("invalid exports:\ngot= %v\nwant=%v", s.Exports, want)
+			}
+		case wasm.StartSection:
+			if s.Index != 2 {
+				t.Fatalf("invalid start function: got=%d, want=2", s.Index)
+			}
+		case wasm.ElementSection:
+			if want := []uint32{3, 0}; !reflect.DeepEqual(s.Elements[0].Elems, want) {
+				t.Fatalf("invalid elements: got=%v, want=%v", s.Elements[0].Elems, want)
+			}
+		case wasm.NameSection:
+			want := []wasm.FunctionNames{{Index: 2, Name: "f1"}, {Index: 3, Name: "f2"}}
+			if !reflect.DeepEqual(s.Funcs, want) {
+				t.Fatalf("invalid names:\ngot= %v\nwant=%v", s.Funcs, want)
+			}
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	void := wasm.FuncType{}
+
+	b := wasm.NewBuilder()
+	f := b.Func("f", void)
+	f.SetBody(nil, nil)
+	_ = f.Index()
+	b.ImportFunc("env", "late", void)
+	if _, err := b.Build(); err == nil {
+		t.Fatalf("expected an error for an import declared after index use")
+	}
+
+	b = wasm.NewBuilder()
+	b.Func("f", void)
+	if _, err := b.Build(); err == nil {
+		t.Fatalf("expected an error for a function without body")
+	}
+
+	b = wasm.NewBuilder()
+	f = b.Func("f", void)
+	f.SetBody(nil, nil)
+	b.Export("f", f)
+	b.Export("f", f)
+	if _, err := b.Build(); err == nil {
+		t.Fatalf("expected an error for duplicate exports")
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wasm-validate decodes and validates WebAssembly modules.
+//
+// Usage:
+//
+//	wasm-validate [-enable features] [-disable features] [-json] file.wasm...
+//
+// Features are comma-separated lists of proposal names, as accepted by
+// wasm.ParseFeatures. The features of the WebAssembly 2.0 specification
+// are enabled by default.
+//
+// Diagnostics are annotated with the offset in the file of the invalid
+// section or instruction. wasm-validate exits with a status of 1 if any
+// module is invalid, and with a status of 2 on usage errors.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm-validate: ")
+
+	var (
+		enable  = flag.String("enable", "2.0", "comma-separated list of features to enable")
+		disable = flag.String("disable", "", "comma-separated list of features to disable")
+		asJSON  = flag.Bool("json", false, "print the results as JSON")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wasm-validate [options] file.wasm...\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	on, err := wasm.ParseFeatures(*enable)
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+	off, err := wasm.ParseFeatures(*disable)
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+	features := on &^ off
+
+	var (
+		results = make([]result, 0, flag.NArg())
+		status  = 0
+	)
+	for _, fname := range flag.Args() {
+		res := validate(fname, features)
+		if !res.Valid {
+			status = 1
+		}
+		results = append(results, res)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, res := range results {
+			if res.Valid {
+				continue
+			}
+			fmt.Fprintln(os.Stderr, res.Error)
+		}
+	}
+	os.Exit(status)
+}
+
+// result is the outcome of the validation of a module.
+type result struct {
+	File     string      `json:"file"`
+	Valid    bool        `json:"valid"`
+	Required string      `json:"required,omitempty"` // features used by the module
+	Error    *diagnostic `json:"error,omitempty"`
+}
+
+// diagnostic describes why a module is invalid.
+type diagnostic struct {
+	File    string `json:"file"`
+	Offset  int64  `json:"offset"`            // offset in the file, or -1
+	Func    int    `json:"func"`              // index of the invalid function, or -1
+	Op      string `json:"op,omitempty"`      // name of the invalid instruction
+	Feature string `json:"feature,omitempty"` // missing feature, if any
+	Message string `json:"message"`
+}
+
+func (d *diagnostic) String() string {
+	var loc string
+	if d.Offset >= 0 {
+		loc = fmt.Sprintf(":%#x", d.Offset)
+	}
+	switch {
+	case d.Func >= 0 && d.Op != "":
+		return fmt.Sprintf("%s%s: function %d: %s: %s", d.File, loc, d.Func, d.Op, d.Message)
+	case d.Func >= 0:
+		return fmt.Sprintf("%s%s: function %d: %s", d.File, loc, d.Func, d.Message)
+	}
+	return fmt.Sprintf("%s%s: %s", d.File, loc, d.Message)
+}
+
+func validate(fname string, features wasm.Features) result {
+	res := result{File: fname}
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		res.Error = &diagnostic{File: fname, Offset: -1, Func: -1, Message: err.Error()}
+		return res
+	}
+
+	r := &countingReader{r: bytes.NewReader(raw)}
+	m, err := wasm.DecodeWithOptions(r, wasm.DecodeOptions{Features: features})
+	if err != nil {
+		res.Error = newDiagnostic(fname, err)
+		res.Error.Offset = sectionStart(raw, r.n)
+		return res
+	}
+	res.Required = m.RequiredFeatures().String()
+
+	err = wasm.Validate(&m, features)
+	if err != nil {
+		res.Error = newDiagnostic(fname, err)
+		var verr *wasm.ValidationError
+		if errors.As(err, &verr) && verr.Func >= 0 {
+			res.Error.Offset = funcOffset(raw, verr.Func-numImportedFuncs(&m), verr.Offset)
+		}
+		return res
+	}
+	res.Valid = true
+	return res
+}
+
+func newDiagnostic(fname string, err error) *diagnostic {
+	d := &diagnostic{File: fname, Offset: -1, Func: -1, Message: err.Error()}
+	var verr *wasm.ValidationError
+	if errors.As(err, &verr) {
+		d.Func = verr.Func
+		d.Op = verr.Op
+		d.Message = verr.Err.Error()
+	}
+	var ferr *wasm.FeatureError
+	if errors.As(err, &ferr) {
+		d.Feature = ferr.Feature.String()
+	}
+	return d
+}
+
+func numImportedFuncs(m *wasm.Module) int {
+	n := 0
+	for _, sec := range m.Sections {
+		if s, ok := sec.(wasm.ImportSection); ok {
+			for _, e := range s.Imports {
+				if e.Kind == wasm.FunctionKind {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// sections calls fn with the id and the offsets of the header and of the
+// payload of every section of the encoded module raw, until fn returns
+// false.
+func sections(raw []byte, fn func(id byte, start, payload, end int64) bool) {
+	off := 8
+	for off < len(raw) {
+		id := raw[off]
+		sz, payload, err := instr.Uleb(raw, off+1, 32)
+		if err != nil || sz > uint64(len(raw)-payload) {
+			return
+		}
+		end := payload + int(sz)
+		if !fn(id, int64(off), int64(payload), int64(end)) {
+			return
+		}
+		off = end
+	}
+}
+
+// sectionStart returns the offset of the section containing the offset
+// pos-1, the last byte read by the decoder.
+func sectionStart(raw []byte, pos int64) int64 {
+	start := int64(-1)
+	if pos <= 8 {
+		return 0
+	}
+	sections(raw, func(id byte, off, payload, end int64) bool {
+		if off >= pos {
+			return false
+		}
+		start = off
+		return true
+	})
+	return start
+}
+
+// funcOffset returns the offset in the file of the instruction at offset
+// off of the code of the i-th function body, or the offset of the body if
+// off is negative.
+func funcOffset(raw []byte, i, off int) int64 {
+	pos := int64(-1)
+	sections(raw, func(id byte, start, payload, end int64) bool {
+		if wasm.SectionID(id) != wasm.CodeID {
+			return true
+		}
+		sec := raw[:end]
+		_, pc, err := instr.Uleb(sec, int(payload), 32)
+		if err != nil {
+			return false
+		}
+		for ; i >= 0; i-- {
+			sz, body, err := instr.Uleb(sec, pc, 32)
+			if err != nil || sz > uint64(len(sec)-body) {
+				return false
+			}
+			next := body + int(sz)
+			if i > 0 {
+				pc = next
+				continue
+			}
+			pos = int64(pc)
+			if off < 0 {
+				return false
+			}
+			// skip the declarations of locals.
+			code := sec[:next]
+			nlocals, p, err := instr.Uleb(code, body, 32)
+			if err != nil {
+				return false
+			}
+			for j := uint64(0); j < nlocals; j++ {
+				_, p, err = instr.Uleb(code, p, 32)
+				if err != nil || p >= len(code) {
+					return false
+				}
+				p++ // type of the locals
+			}
+			pos = int64(p + off)
+		}
+		return false
+	})
+	return pos
+}
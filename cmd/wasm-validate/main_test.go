@@ -0,0 +1,117 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the command instead of the tests when the test binary is
+// executed by runValidate.
+func TestMain(m *testing.M) {
+	if os.Getenv("WASM_VALIDATE_RUN_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// runValidate runs the command with the provided arguments, and returns
+// its standard output and error and its exit status.
+func runValidate(t *testing.T, args ...string) (stdout, stderr string, status int) {
+	t.Helper()
+	var o, e bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "WASM_VALIDATE_RUN_MAIN=1")
+	cmd.Stdout = &o
+	cmd.Stderr = &e
+	err := cmd.Run()
+	var eerr *exec.ExitError
+	switch {
+	case errors.As(err, &eerr):
+		status = eerr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return o.String(), e.String(), status
+}
+
+func TestValidate(t *testing.T) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want diagnostic
+	}{
+		{
+			name: "stack.wasm",
+			raw: append(header,
+				0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f, // type: () -> i32
+				0x03, 0x02, 0x01, 0x00, // function: type 0
+				0x0a, 0x09, 0x01, 0x07, // code: 1 body of 7 bytes
+				0x01, 0x01, 0x7e, // local i64
+				0x41, 0x00, // i32.const 0
+				0x6a, // i32.add, at 0x1c
+				0x0b,
+			),
+			want: diagnostic{Offset: 0x1c, Func: 0, Op: "i32.add"},
+		},
+		{
+			name: "form.wasm",
+			raw: append(header,
+				0x01, 0x05, 0x01, 0x61, 0x00, 0x01, 0x7f, // type: invalid form
+			),
+			want: diagnostic{Offset: 0x8, Func: -1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), tc.name)
+			if err := os.WriteFile(fname, tc.raw, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			stdout, _, status := runValidate(t, "-json", fname)
+			if status != 1 {
+				t.Fatalf("invalid exit status: got=%d, want=1", status)
+			}
+			var res []result
+			if err := json.Unmarshal([]byte(stdout), &res); err != nil {
+				t.Fatalf("could not decode the json output: %v\n%s", err, stdout)
+			}
+			if len(res) != 1 || res[0].Valid || res[0].Error == nil {
+				t.Fatalf("invalid results:\n%s", stdout)
+			}
+			got := res[0].Error
+			if got.File != fname || got.Offset != tc.want.Offset || got.Func != tc.want.Func || got.Op != tc.want.Op {
+				t.Fatalf("invalid diagnostic:\ngot= %+v\nwant=%+v", *got, tc.want)
+			}
+
+			_, stderr, status := runValidate(t, fname)
+			if status != 1 {
+				t.Fatalf("invalid exit status: got=%d, want=1", status)
+			}
+			if want := got.String() + "\n"; stderr != want {
+				t.Fatalf("invalid output:\ngot= %q\nwant=%q", stderr, want)
+			}
+		})
+	}
+
+	stdout, _, status := runValidate(t, "-json", "../../testdata/add.wasm")
+	if status != 0 {
+		t.Fatalf("invalid exit status: got=%d, want=0", status)
+	}
+	var res []result
+	if err := json.Unmarshal([]byte(stdout), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || !res[0].Valid || res[0].Error != nil {
+		t.Fatalf("invalid results:\n%s", stdout)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wasm2c translates a WebAssembly module to C.
+//
+// Usage:
+//
+//	wasm2c [-p prefix] [-o dir] file.wasm
+//
+// The generated header and source file, <prefix>.h and <prefix>.c, are
+// written to the output directory with the runtime header, wasm-rt.h. See
+// the documentation of the wasm2c package for the API of the generated
+// code.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/wasm2c"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm2c: ")
+
+	var (
+		prefix = flag.String("p", "module", "prefix of the names of the generated code")
+		dir    = flag.String("o", ".", "output directory")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wasm2c [options] file.wasm\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := wasm.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := wasm2c.Translate(&m, wasm2c.Options{Prefix: *prefix})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		*prefix + ".h":       out.Header,
+		*prefix + ".c":       out.Source,
+		wasm2c.RuntimeHeader: wasm2c.Runtime(),
+	} {
+		if err := os.WriteFile(filepath.Join(*dir, name), data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
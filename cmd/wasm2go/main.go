@@ -0,0 +1,62 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command wasm2go translates a WebAssembly module to a Go package.
+//
+// Usage:
+//
+//	wasm2go [-p package] [-o file.go] file.wasm
+//
+// The generated code is written to the standard output, unless the -o flag
+// is given. See the documentation of the wasm2go package for the API of
+// the generated package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/wasm2go"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm2go: ")
+
+	var (
+		pkg = flag.String("p", "module", "name of the generated package")
+		out = flag.String("o", "", "output file (default: standard output)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wasm2go [options] file.wasm\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := wasm.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := wasm2go.Translate(&m, wasm2go.Options{Package: *pkg})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
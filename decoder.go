@@ -9,17 +9,16 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"sort"
 )
 
 type decoder struct {
-	r   io.Reader
-	err error
-}
+	r        io.Reader
+	err      error
+	version  uint32   // version of the module being decoded
+	features Features // enabled features
 
-func (d *decoder) readVarI7(r io.Reader, v *int32) {
-	// FIXME(sbinet) ?
-	d.readVarI32(r, v)
+	raw *rawEncoding // original encoding of the module, if retained
 }
 
 func (d *decoder) readVarI32(r io.Reader, v *int32) {
@@ -29,14 +28,25 @@ func (d *decoder) readVarI32(r io.Reader, v *int32) {
 	*v, _, d.err = varint(r)
 }
 
+func (d *decoder) readVarI64(r io.Reader, v *int64) {
+	if d.err != nil {
+		return
+	}
+	*v, _, d.err = varintN(r, 64)
+}
+
 func (d *decoder) readVarU1(r io.Reader, v *uint32) {
-	// FIXME(sbinet) ?
 	d.readVarU32(r, v)
+	if d.err == nil && *v > 1 {
+		d.err = fmt.Errorf("wasm: invalid varuint1 value (%d)", *v)
+	}
 }
 
 func (d *decoder) readVarU7(r io.Reader, v *uint32) {
-	// FIXME(sbinet) ?
 	d.readVarU32(r, v)
+	if d.err == nil && *v > 0x7f {
+		d.err = fmt.Errorf("wasm: invalid varuint7 value (%d)", *v)
+	}
 }
 
 func (d *decoder) readVarU32(r io.Reader, v *uint32) {
@@ -46,12 +56,45 @@ func (d *decoder) readVarU32(r io.Reader, v *uint32) {
 	*v, _, d.err = uvarint(r)
 }
 
+func (d *decoder) readVarU64(r io.Reader, v *uint64) {
+	if d.err != nil {
+		return
+	}
+	*v, _, d.err = uvarint64(r)
+}
+
+// require records an error if the feature f is not enabled.
+func (d *decoder) require(f Features, what string, args ...interface{}) {
+	if d.err != nil || d.features.Has(f) {
+		return
+	}
+	d.err = fmt.Errorf("wasm: %w", &FeatureError{Feature: f, What: fmt.Sprintf(what, args...)})
+}
+
+func (d *decoder) readByte(r io.Reader, v *byte) {
+	var buf [1]byte
+	d.read(r, buf[:])
+	*v = buf[0]
+}
+
+// readCount reads the number of elements of a vector, making sure the
+// remaining input could possibly hold that many elements.
+func (d *decoder) readCount(r io.Reader, n *uint32) {
+	d.readVarU32(r, n)
+	if d.err != nil {
+		return
+	}
+	if int64(*n) > remaining(r) {
+		d.err = io.ErrUnexpectedEOF
+	}
+}
+
 func (d *decoder) readString(r io.Reader, s *string) {
 	if d.err != nil {
 		return
 	}
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readCount(r, &sz)
 	var buf = make([]byte, sz)
 	d.read(r, buf)
 	*s = string(buf)
@@ -61,7 +104,23 @@ func (d *decoder) read(r io.Reader, buf []byte) {
 	if d.err != nil || len(buf) == 0 {
 		return
 	}
-	_, d.err = r.Read(buf)
+	_, d.err = io.ReadFull(r, buf)
+	if d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+}
+
+// remaining returns the number of bytes left in r, if known.
+func remaining(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		return int64(r.Len())
+	case *bytes.Buffer:
+		return int64(r.Len())
+	case *io.LimitedReader:
+		return r.N
+	}
+	return 1<<63 - 1
 }
 
 func (d *decoder) readModule() (Module, error) {
@@ -76,6 +135,7 @@ func (d *decoder) readModule() (Module, error) {
 	}
 
 	d.readHeader(d.r, &m.Header)
+	d.version = m.Header.Version
 	for {
 		s := d.readSection()
 		if s == nil {
@@ -83,7 +143,6 @@ func (d *decoder) readModule() (Module, error) {
 		}
 		m.Sections = append(m.Sections, s)
 	}
-	return m, d.err
 }
 
 func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
@@ -103,154 +162,256 @@ func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
 
 func (d *decoder) readSection() Section {
 	var (
-		id  uint32
+		id  [1]byte
 		sz  uint32
 		sec Section
 	)
 
-	d.readVarU32(d.r, &id)
+	if d.err != nil {
+		return nil
+	}
+	_, d.err = io.ReadFull(d.r, id[:])
 	if d.err != nil {
 		if d.err == io.EOF {
 			d.err = nil
 		}
 		return nil
 	}
-	d.readVarU32(d.r, &sz)
+	size := new(bytes.Buffer)
+	d.readVarU32(io.TeeReader(d.r, size), &sz)
+	if d.err != nil {
+		return nil
+	}
+	payload := new(bytes.Buffer)
+	_, d.err = io.CopyN(payload, d.r, int64(sz))
+	if d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return nil
+	}
 
-	r := &io.LimitedReader{R: d.r, N: int64(sz)}
-	switch SectionID(id) {
+	r := bytes.NewReader(payload.Bytes())
+	switch SectionID(id[0]) {
 	case UnknownID:
-		var s NameSection
-		d.readNameSection(r, &s)
-		// fmt.Printf("--- name: %q, funcs: %d\n", s.name, len(s.funcs))
-		sec = s
+		sec = d.readCustomSection(r)
 
 	case TypeID:
 		var s TypeSection
 		d.readTypeSection(r, &s)
-		// fmt.Printf("--- types: %d\n", len(s.types))
 		sec = s
 
 	case ImportID:
 		var s ImportSection
 		d.readImportSection(r, &s)
-		// fmt.Printf("--- imports: %d\n", len(s.imports))
-		/*
-			for ii, imp := range s.imports {
-				fmt.Printf("    entry[%d]: %q|%q|%x\n", ii, imp.module, imp.field, imp.kind)
-			}
-		*/
 		sec = s
 
 	case FunctionID:
 		var s FunctionSection
 		d.readFunctionSection(r, &s)
-		// fmt.Printf("--- functions: %d\n", len(s.types))
 		sec = s
 
 	case TableID:
 		var s TableSection
 		d.readTableSection(r, &s)
-		// fmt.Printf("--- tables: %d\n", len(s.tables))
 		sec = s
 
 	case MemoryID:
 		var s MemorySection
 		d.readMemorySection(r, &s)
-		// fmt.Printf("--- memories: %d\n", len(s.memories))
 		sec = s
 
 	case GlobalID:
 		var s GlobalSection
 		d.readGlobalSection(r, &s)
-		// fmt.Printf("--- globals: %d\n", len(s.globals))
-		/*
-			for ii, ge := range s.globals {
-				fmt.Printf("   ge[%d]: type={%x, 0x%x} init=%d\n",
-					ii, ge.Type.ContentType, ge.Type.Mutability, len(ge.Init.Expr),
-				)
-			}
-		*/
 		sec = s
 
 	case ExportID:
 		var s ExportSection
 		d.readExportSection(r, &s)
-		// fmt.Printf("--- exports: %d\n", len(s.exports))
 		sec = s
 
 	case StartID:
 		var s StartSection
 		d.readStartSection(r, &s)
-		// fmt.Printf("--- start: 0x%x\n", s.Index)
 		sec = s
 
 	case ElementID:
 		var s ElementSection
 		d.readElementSection(r, &s)
-		// fmt.Printf("--- elements: %d\n", len(s.elements))
 		sec = s
 
 	case CodeID:
 		var s CodeSection
 		d.readCodeSection(r, &s)
-		// fmt.Printf("--- func-bodies: %d\n", len(s.Bodies))
 		sec = s
 
 	case DataID:
 		var s DataSection
 		d.readDataSection(r, &s)
-		// fmt.Printf("--- data-segments: %d\n", len(s.segments))
+		sec = s
+
+	case DataCountID:
+		d.require(FeatureBulkMemory, "data count section")
+		var s DataCountSection
+		d.readVarU32(r, &s.Count)
+		sec = s
+
+	case TagID:
+		d.require(FeatureExceptions, "tag section")
+		var s TagSection
+		d.readTagSection(r, &s)
 		sec = s
 
 	default:
-		d.err = fmt.Errorf("wasm: invalid section ID")
+		d.err = fmt.Errorf("wasm: invalid section ID (%d)", id[0])
+	}
+
+	if d.err != nil {
+		return nil
+	}
 
+	if r.Len() != 0 {
+		d.err = fmt.Errorf("wasm: section %d: %d bytes unread", id[0], r.Len())
+		return nil
 	}
 
-	if r.N != 0 {
-		log.Printf("wasm: N=%d bytes unread! (section=%d)\n", r.N, sec.ID())
-		buf := make([]byte, r.N)
-		d.read(r, buf)
+	if d.raw != nil {
+		d.raw.addSection(d.version, sec, size.Bytes(), payload.Bytes())
 	}
 
 	return sec
 }
 
-func (d *decoder) readNameSection(r io.Reader, s *NameSection) {
+func (d *decoder) readCustomSection(r *bytes.Reader) Section {
+	var s CustomSection
+	d.readString(r, &s.Name)
 	if d.err != nil {
-		return
+		return nil
 	}
-
-	d.readString(r, &s.name)
-	var n uint32
-	d.readVarU32(r, &n)
-	s.funcs = make([]FunctionNames, int(n))
-	for i := range s.funcs {
-		d.readFunctionNames(r, &s.funcs[i])
+	s.Data = make([]byte, r.Len())
+	d.read(r, s.Data)
+
+	if s.Name == "name" {
+		// malformed name sections are not an error: they are kept as a
+		// regular custom section.
+		dec := decoder{version: d.version}
+		ns := NameSection{Name: s.Name}
+		dec.readNameSection(bytes.NewReader(s.Data), &ns)
+		if dec.err == nil {
+			return ns
+		}
 	}
+	return s
 }
 
-func (d *decoder) readFunctionNames(r io.Reader, f *FunctionNames) {
+func (d *decoder) readNameSection(r *bytes.Reader, s *NameSection) {
 	if d.err != nil {
 		return
 	}
 
-	d.readString(r, &f.name)
+	if d.version == legacyVersion {
+		d.readLegacyNames(r, s)
+		return
+	}
+
+	var prev int = -1
+	for r.Len() > 0 && d.err == nil {
+		var (
+			id byte
+			sz uint32
+		)
+		d.readByte(r, &id)
+		d.readCount(r, &sz)
+		if d.err != nil {
+			return
+		}
+		if int(id) <= prev {
+			d.err = fmt.Errorf("wasm: name subsection %d out of order", id)
+			return
+		}
+		prev = int(id)
+
+		data := make([]byte, sz)
+		d.read(r, data)
+		sr := bytes.NewReader(data)
+		switch id {
+		case nameModuleID:
+			d.readString(sr, &s.Module)
+		case nameFunctionID:
+			d.readFunctionNames(sr, s)
+		case nameLocalID:
+			d.readLocalNames(sr, s)
+		default:
+			s.Subsections = append(s.Subsections, NameSubsection{ID: id, Data: data})
+			sr.Reset(nil)
+		}
+		if d.err == nil && sr.Len() != 0 {
+			d.err = fmt.Errorf("wasm: name subsection %d: %d bytes unread", id, sr.Len())
+		}
+	}
+}
+
+// readLegacyNames reads the positional name section of pre-MVP modules.
+func (d *decoder) readLegacyNames(r io.Reader, s *NameSection) {
 	var n uint32
-	d.readVarU32(r, &n)
-	f.locals = make([]LocalName, int(n))
-	for i := range f.locals {
-		d.readLocalName(r, &f.locals[i])
+	d.readCount(r, &n)
+	s.Funcs = make([]FunctionNames, int(n))
+	for i := range s.Funcs {
+		f := &s.Funcs[i]
+		f.Index = uint32(i)
+		d.readString(r, &f.Name)
+		var nlocals uint32
+		d.readCount(r, &nlocals)
+		f.Locals = make([]LocalName, int(nlocals))
+		for j := range f.Locals {
+			f.Locals[j].Index = uint32(j)
+			d.readString(r, &f.Locals[j].Name)
+		}
+		if d.err != nil {
+			return
+		}
 	}
 }
 
-func (d *decoder) readLocalName(r io.Reader, local *LocalName) {
-	if d.err != nil {
-		return
+func (d *decoder) readFunctionNames(r io.Reader, s *NameSection) {
+	var n uint32
+	d.readCount(r, &n)
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		var idx uint32
+		d.readVarU32(r, &idx)
+		f := s.funcNames(idx)
+		d.readString(r, &f.Name)
+	}
+}
+
+func (d *decoder) readLocalNames(r io.Reader, s *NameSection) {
+	var n uint32
+	d.readCount(r, &n)
+	for i := 0; i < int(n) && d.err == nil; i++ {
+		var idx, nlocals uint32
+		d.readVarU32(r, &idx)
+		d.readCount(r, &nlocals)
+		f := s.funcNames(idx)
+		f.Locals = make([]LocalName, int(nlocals))
+		for j := range f.Locals {
+			d.readVarU32(r, &f.Locals[j].Index)
+			d.readString(r, &f.Locals[j].Name)
+		}
 	}
+}
 
-	d.readString(r, &local.name)
+// funcNames returns the names entry of the i-th function, creating it if
+// needed.
+func (s *NameSection) funcNames(i uint32) *FunctionNames {
+	j := sort.Search(len(s.Funcs), func(j int) bool { return s.Funcs[j].Index >= i })
+	if j < len(s.Funcs) && s.Funcs[j].Index == i {
+		return &s.Funcs[j]
+	}
+	s.Funcs = append(s.Funcs, FunctionNames{})
+	copy(s.Funcs[j+1:], s.Funcs[j:])
+	s.Funcs[j] = FunctionNames{Index: i}
+	return &s.Funcs[j]
 }
 
 func (d *decoder) readTypeSection(r io.Reader, s *TypeSection) {
@@ -259,10 +420,10 @@ func (d *decoder) readTypeSection(r io.Reader, s *TypeSection) {
 	}
 
 	var n uint32
-	d.readVarU32(r, &n)
-	s.types = make([]FuncType, int(n))
-	for i := range s.types {
-		d.readFuncType(r, &s.types[i])
+	d.readCount(r, &n)
+	s.Types = make([]FuncType, int(n))
+	for i := range s.Types {
+		d.readFuncType(r, &s.Types[i])
 	}
 }
 
@@ -271,20 +432,39 @@ func (d *decoder) readFuncType(r io.Reader, ft *FuncType) {
 		return
 	}
 
-	d.readVarU7(r, &ft.form)
+	var form uint32
+	d.readVarU7(r, &form)
+	if d.err != nil {
+		return
+	}
+	switch form {
+	case uint32(Op_func):
+	case gcRecType, gcSubType, gcSubFinalType, gcStructType, gcArrayType:
+		d.require(FeatureGC, "type form 0x%x", form)
+		if d.err == nil {
+			d.err = fmt.Errorf("wasm: %v", errUnsupported("gc types"))
+		}
+		return
+	default:
+		d.err = fmt.Errorf("wasm: invalid function type form (0x%x)", form)
+		return
+	}
 
 	var params uint32
-	d.readVarU32(r, &params)
-	ft.params = make([]ValueType, int(params))
-	for i := range ft.params {
-		d.readValueType(r, &ft.params[i])
+	d.readCount(r, &params)
+	ft.Params = make([]ValueType, int(params))
+	for i := range ft.Params {
+		d.readValueType(r, &ft.Params[i])
 	}
 
 	var results uint32
-	d.readVarU32(r, &results)
-	ft.results = make([]ValueType, int(results))
-	for i := range ft.results {
-		d.readValueType(r, &ft.results[i])
+	d.readCount(r, &results)
+	if results > 1 {
+		d.require(FeatureMultiValue, "function type with %d results", results)
+	}
+	ft.Results = make([]ValueType, int(results))
+	for i := range ft.Results {
+		d.readValueType(r, &ft.Results[i])
 	}
 }
 
@@ -293,9 +473,23 @@ func (d *decoder) readValueType(r io.Reader, vt *ValueType) {
 		return
 	}
 
-	var v int32
-	d.readVarI7(r, &v)
+	var v byte
+	d.readByte(r, &v)
 	*vt = ValueType(v)
+	if d.err != nil {
+		return
+	}
+	switch {
+	case vt.valid():
+		d.require(vt.feature(), "value type %v", *vt)
+	case v == gcRefNull || v == gcRef:
+		d.require(FeatureGC, "reference type 0x%x", v)
+		if d.err == nil {
+			d.err = fmt.Errorf("wasm: %v", errUnsupported("gc reference types"))
+		}
+	default:
+		d.err = fmt.Errorf("wasm: invalid value type (0x%x)", v)
+	}
 }
 
 func (d *decoder) readImportSection(r io.Reader, s *ImportSection) {
@@ -304,10 +498,10 @@ func (d *decoder) readImportSection(r io.Reader, s *ImportSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.imports = make([]ImportEntry, int(sz))
-	for i := range s.imports {
-		d.readImportEntry(r, &s.imports[i])
+	d.readCount(r, &sz)
+	s.Imports = make([]ImportEntry, int(sz))
+	for i := range s.Imports {
+		d.readImportEntry(r, &s.Imports[i])
 	}
 }
 
@@ -316,34 +510,42 @@ func (d *decoder) readImportEntry(r io.Reader, ie *ImportEntry) {
 		return
 	}
 
-	d.readString(r, &ie.module)
-	d.readString(r, &ie.field)
-	d.readExternalKind(r, &ie.kind)
+	d.readString(r, &ie.Module)
+	d.readString(r, &ie.Field)
+	d.readExternalKind(r, &ie.Kind)
+	if d.err != nil {
+		return
+	}
 
-	switch ie.kind {
+	switch ie.Kind {
 	case FunctionKind:
 		var idx uint32
 		d.readVarU32(r, &idx)
-		ie.typ = idx
+		ie.Type = idx
 
 	case TableKind:
 		var tt TableType
 		d.readTableType(r, &tt)
-		ie.typ = tt
+		ie.Type = tt
 
 	case MemoryKind:
 		var mt MemoryType
 		d.readMemoryType(r, &mt)
-		ie.typ = mt
+		ie.Type = mt
 
 	case GlobalKind:
 		var gt GlobalType
 		d.readGlobalType(r, &gt)
-		ie.typ = gt
+		ie.Type = gt
+
+	case TagKind:
+		d.require(FeatureExceptions, "tag import %q.%q", ie.Module, ie.Field)
+		var tt TagType
+		d.readTagType(r, &tt)
+		ie.Type = tt
 
 	default:
-		fmt.Printf("module=%q field=%q\n", ie.module, ie.field)
-		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d)", byte(ie.kind))
+		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d) for import %q.%q", byte(ie.Kind), ie.Module, ie.Field)
 	}
 }
 
@@ -352,9 +554,9 @@ func (d *decoder) readExternalKind(r io.Reader, ek *ExternalKind) {
 		return
 	}
 
-	var v [1]byte
-	d.read(r, v[:])
-	*ek = ExternalKind(v[0])
+	var v byte
+	d.readByte(r, &v)
+	*ek = ExternalKind(v)
 }
 
 func (d *decoder) readTableType(r io.Reader, tt *TableType) {
@@ -364,6 +566,9 @@ func (d *decoder) readTableType(r io.Reader, tt *TableType) {
 
 	d.readElemType(r, &tt.ElemType)
 	d.readResizableLimits(r, &tt.Limits)
+	if d.err == nil && tt.Limits.Flags&^LimitsMax != 0 {
+		d.err = fmt.Errorf("wasm: invalid table limits flags (0x%x)", tt.Limits.Flags)
+	}
 }
 
 func (d *decoder) readElemType(r io.Reader, et *ElemType) {
@@ -371,9 +576,24 @@ func (d *decoder) readElemType(r io.Reader, et *ElemType) {
 		return
 	}
 
-	var v int32
-	d.readVarI7(r, &v)
+	var v byte
+	d.readByte(r, &v)
+	d.elemType(v, et)
+}
+
+func (d *decoder) elemType(v byte, et *ElemType) {
+	if d.err != nil {
+		return
+	}
+
 	*et = ElemType(v)
+	switch vt := ValueType(v); vt {
+	case FuncRef:
+	case ExternRef:
+		d.require(vt.feature(), "element type %v", vt)
+	default:
+		d.err = fmt.Errorf("wasm: invalid element type (0x%x)", v)
+	}
 }
 
 func (d *decoder) readResizableLimits(r io.Reader, tl *ResizableLimits) {
@@ -382,9 +602,19 @@ func (d *decoder) readResizableLimits(r io.Reader, tl *ResizableLimits) {
 	}
 
 	d.readVarU32(r, &tl.Flags)
-	d.readVarU32(r, &tl.Initial)
-	if tl.Flags&0x1 != 0 {
-		d.readVarU32(r, &tl.Maximum)
+	if tl.Flags&LimitsI64 != 0 {
+		d.readVarU64(r, &tl.Initial)
+		if tl.Flags&LimitsMax != 0 {
+			d.readVarU64(r, &tl.Maximum)
+		}
+		return
+	}
+	var v uint32
+	d.readVarU32(r, &v)
+	tl.Initial = uint64(v)
+	if tl.Flags&LimitsMax != 0 {
+		d.readVarU32(r, &v)
+		tl.Maximum = uint64(v)
 	}
 }
 
@@ -394,6 +624,20 @@ func (d *decoder) readMemoryType(r io.Reader, mt *MemoryType) {
 	}
 
 	d.readResizableLimits(r, &mt.Limits)
+	if d.err != nil {
+		return
+	}
+	flags := mt.Limits.Flags
+	if flags&^(LimitsMax|LimitsShared|LimitsI64) != 0 {
+		d.err = fmt.Errorf("wasm: invalid memory limits flags (0x%x)", flags)
+		return
+	}
+	if flags&LimitsShared != 0 {
+		d.require(FeatureThreads, "shared memory")
+	}
+	if flags&LimitsI64 != 0 {
+		d.require(FeatureMemory64, "64-bit memory")
+	}
 }
 
 func (d *decoder) readGlobalType(r io.Reader, gt *GlobalType) {
@@ -407,16 +651,28 @@ func (d *decoder) readGlobalType(r io.Reader, gt *GlobalType) {
 	gt.Mutability = varuint1(mut)
 }
 
+func (d *decoder) readTagType(r io.Reader, tt *TagType) {
+	if d.err != nil {
+		return
+	}
+
+	d.readByte(r, &tt.Attribute)
+	d.readVarU32(r, &tt.Type)
+	if d.err == nil && tt.Attribute != 0 {
+		d.err = fmt.Errorf("wasm: invalid tag attribute (0x%x)", tt.Attribute)
+	}
+}
+
 func (d *decoder) readFunctionSection(r io.Reader, s *FunctionSection) {
 	if d.err != nil {
 		return
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.types = make([]uint32, int(sz))
-	for i := range s.types {
-		d.readVarU32(r, &s.types[i])
+	d.readCount(r, &sz)
+	s.Types = make([]uint32, int(sz))
+	for i := range s.Types {
+		d.readVarU32(r, &s.Types[i])
 	}
 }
 
@@ -426,10 +682,10 @@ func (d *decoder) readTableSection(r io.Reader, s *TableSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.tables = make([]TableType, int(sz))
-	for i := range s.tables {
-		d.readTableType(r, &s.tables[i])
+	d.readCount(r, &sz)
+	s.Tables = make([]TableType, int(sz))
+	for i := range s.Tables {
+		d.readTableType(r, &s.Tables[i])
 	}
 }
 
@@ -439,10 +695,10 @@ func (d *decoder) readMemorySection(r io.Reader, s *MemorySection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.memories = make([]MemoryType, int(sz))
-	for i := range s.memories {
-		d.readMemoryType(r, &s.memories[i])
+	d.readCount(r, &sz)
+	s.Memories = make([]MemoryType, int(sz))
+	for i := range s.Memories {
+		d.readMemoryType(r, &s.Memories[i])
 	}
 }
 
@@ -452,10 +708,23 @@ func (d *decoder) readGlobalSection(r io.Reader, s *GlobalSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.globals = make([]GlobalVariable, int(sz))
-	for i := range s.globals {
-		d.readGlobalVariable(r, &s.globals[i])
+	d.readCount(r, &sz)
+	s.Globals = make([]GlobalVariable, int(sz))
+	for i := range s.Globals {
+		d.readGlobalVariable(r, &s.Globals[i])
+	}
+}
+
+func (d *decoder) readTagSection(r io.Reader, s *TagSection) {
+	if d.err != nil {
+		return
+	}
+
+	var sz uint32
+	d.readCount(r, &sz)
+	s.Tags = make([]TagType, int(sz))
+	for i := range s.Tags {
+		d.readTagType(r, &s.Tags[i])
 	}
 }
 
@@ -464,37 +733,67 @@ func (d *decoder) readGlobalVariable(r io.Reader, gv *GlobalVariable) {
 		return
 	}
 
-	out := new(bytes.Buffer)
-	r = io.TeeReader(r, out)
 	d.readGlobalType(r, &gv.Type)
 	d.readInitExpr(r, &gv.Init)
 }
 
+// readInitExpr reads a constant initializer expression, up to and including
+// its terminating end opcode.
 func (d *decoder) readInitExpr(r io.Reader, ie *InitExpr) {
 	if d.err != nil {
 		return
 	}
 
-	var err error
-	var n int
-	var buf [1]byte
-	for {
-		n, err = r.Read(buf[:])
-		if err != nil || n <= 0 {
+	expr := new(bytes.Buffer)
+	tr := io.TeeReader(r, expr)
+	for d.err == nil {
+		var op byte
+		d.readByte(r, &op)
+		if d.err != nil {
 			break
 		}
-		v := buf[0]
-		if v == Op_end {
-			ie.End = byte(Op_end)
-			break
+		if op == Op_end {
+			ie.Expr = expr.Bytes()
+			ie.End = op
+			return
+		}
+		expr.WriteByte(op)
+		switch op {
+		case byte(Op_i32_const):
+			var v int32
+			d.readVarI32(tr, &v)
+		case Op_i64_const:
+			var v int64
+			d.readVarI64(tr, &v)
+		case Op_f32_const:
+			var v [4]byte
+			d.read(tr, v[:])
+		case Op_f64_const:
+			var v [8]byte
+			d.read(tr, v[:])
+		case Op_get_global, Op_ref_func:
+			d.require(opcodes[op].feature, "%v in initializer expression", Opcode(op))
+			var v uint32
+			d.readVarU32(tr, &v)
+		case byte(Op_ref_null):
+			d.require(FeatureReferenceTypes, "%v in initializer expression", Opcode(op))
+			var v byte
+			d.readByte(tr, &v)
+		case Op_i32_add, Op_i32_sub, Op_i32_mul, Op_i64_add, Op_i64_sub, Op_i64_mul:
+			d.require(FeatureExtendedConst, "%v in initializer expression", Opcode(op))
+		case Op_simd_prefix:
+			var sub uint32
+			d.readVarU32(tr, &sub)
+			if d.err == nil && sub != simdConst {
+				d.err = fmt.Errorf("wasm: invalid opcode %s in initializer expression", prefixedString(Opcode(op), sub))
+				break
+			}
+			d.require(FeatureSIMD, "%s in initializer expression", simdOpcodes[sub].name)
+			var v [16]byte
+			d.read(tr, v[:])
+		default:
+			d.err = fmt.Errorf("wasm: invalid opcode 0x%x in initializer expression", op)
 		}
-		ie.Expr = append(ie.Expr, v)
-	}
-	if err == io.EOF {
-		err = nil
-	}
-	if err != nil {
-		d.err = err
 	}
 }
 
@@ -504,10 +803,10 @@ func (d *decoder) readExportSection(r io.Reader, s *ExportSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.exports = make([]ExportEntry, int(sz))
-	for i := range s.exports {
-		d.readExportEntry(r, &s.exports[i])
+	d.readCount(r, &sz)
+	s.Exports = make([]ExportEntry, int(sz))
+	for i := range s.Exports {
+		d.readExportEntry(r, &s.Exports[i])
 	}
 }
 
@@ -516,9 +815,15 @@ func (d *decoder) readExportEntry(r io.Reader, ee *ExportEntry) {
 		return
 	}
 
-	d.readString(r, &ee.field)
-	d.readExternalKind(r, &ee.kind)
-	d.readVarU32(r, &ee.index)
+	d.readString(r, &ee.Field)
+	d.readExternalKind(r, &ee.Kind)
+	d.readVarU32(r, &ee.Index)
+	if d.err == nil && ee.Kind > TagKind {
+		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d) for export %q", byte(ee.Kind), ee.Field)
+	}
+	if ee.Kind == TagKind {
+		d.require(FeatureExceptions, "tag export %q", ee.Field)
+	}
 }
 
 func (d *decoder) readStartSection(r io.Reader, s *StartSection) {
@@ -535,10 +840,10 @@ func (d *decoder) readElementSection(r io.Reader, s *ElementSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.elements = make([]ElemSegment, int(sz))
-	for i := range s.elements {
-		d.readElemSegment(r, &s.elements[i])
+	d.readCount(r, &sz)
+	s.Elements = make([]ElemSegment, int(sz))
+	for i := range s.Elements {
+		d.readElemSegment(r, &s.Elements[i])
 	}
 }
 
@@ -547,11 +852,47 @@ func (d *decoder) readElemSegment(r io.Reader, es *ElemSegment) {
 		return
 	}
 
-	d.readVarU32(r, &es.Index)
-	d.readInitExpr(r, &es.Offset)
+	d.readVarU32(r, &es.Flags)
+	if d.err != nil {
+		return
+	}
+	switch {
+	case es.Flags > ElemPassive|ElemExplicit|ElemExprs:
+		d.err = fmt.Errorf("wasm: invalid element segment flags (0x%x)", es.Flags)
+		return
+	case es.Flags&ElemExprs != 0 || es.isDeclarative():
+		d.require(FeatureReferenceTypes, "element segment with flags 0x%x", es.Flags)
+	case es.Flags != 0:
+		d.require(FeatureBulkMemory, "element segment with flags 0x%x", es.Flags)
+	}
+	if es.Flags&(ElemPassive|ElemExplicit) == ElemExplicit {
+		d.readVarU32(r, &es.Index)
+	}
+	if es.isActive() {
+		d.readInitExpr(r, &es.Offset)
+	}
+	if es.Flags != 0 && es.Flags != ElemExprs {
+		var v byte
+		d.readByte(r, &v)
+		switch {
+		case es.Flags&ElemExprs != 0:
+			d.elemType(v, &es.Type)
+		case v != elemKindFunc:
+			d.err = fmt.Errorf("wasm: invalid element kind (0x%x)", v)
+		default:
+			es.Type = ElemType(Op_anyfunc)
+		}
+	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readCount(r, &sz)
+	if es.Flags&ElemExprs != 0 {
+		es.Exprs = make([]InitExpr, int(sz))
+		for i := range es.Exprs {
+			d.readInitExpr(r, &es.Exprs[i])
+		}
+		return
+	}
 	es.Elems = make([]uint32, int(sz))
 	for i := range es.Elems {
 		d.readVarU32(r, &es.Elems[i])
@@ -564,7 +905,7 @@ func (d *decoder) readCodeSection(r io.Reader, s *CodeSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readCount(r, &sz)
 	s.Bodies = make([]FunctionBody, int(sz))
 	for i := range s.Bodies {
 		d.readFunctionBody(r, &s.Bodies[i])
@@ -576,46 +917,47 @@ func (d *decoder) readFunctionBody(r io.Reader, fb *FunctionBody) {
 		return
 	}
 
-	d.readVarU32(r, &fb.BodySize)
-	r = io.LimitReader(r, int64(fb.BodySize))
+	size := new(bytes.Buffer)
+	d.readVarU32(io.TeeReader(r, size), &fb.BodySize)
+	if d.err == nil && int64(fb.BodySize) > remaining(r) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	body := make([]byte, fb.BodySize)
+	d.read(r, body)
+	br := bytes.NewReader(body)
+
 	var locals uint32
-	d.readVarU32(r, &locals)
+	d.readCount(br, &locals)
+	fb.LocalCount = varuint32(locals)
 	fb.Locals = make([]LocalEntry, int(locals))
 	for i := range fb.Locals {
-		d.readLocalEntry(r, &fb.Locals[i])
+		d.readLocalEntry(br, &fb.Locals[i])
+	}
+	if d.err != nil {
+		return
 	}
 
-	rcode := new(bytes.Buffer)
-	io.Copy(rcode, r)
-	d.readCode(rcode, &fb.Code)
+	d.readCode(body[len(body)-br.Len():], &fb.Code)
+
+	if d.raw != nil && d.err == nil {
+		d.raw.addBody(d.version, *fb, size.Bytes(), body)
+	}
 }
 
-func (d *decoder) readCode(r io.Reader, code *Code) {
+// readCode splits the function body code into its instructions and its
+// terminating end opcode.
+func (d *decoder) readCode(body []byte, code *Code) {
 	if d.err != nil {
 		return
 	}
 
-	var err error
-	var n int
-	var buf [1]byte
-	for {
-		n, err = r.Read(buf[:])
-		if err != nil || n <= 0 {
-			break
-		}
-		v := buf[0]
-		if v == Op_end {
-			code.End = byte(Op_end)
-			break
-		}
-		code.Code = append(code.Code, v)
-	}
-	if err == io.EOF {
-		err = nil
-	}
-	if err != nil {
-		d.err = err
+	n := len(body)
+	if n == 0 || body[n-1] != Op_end {
+		d.err = fmt.Errorf("wasm: function body must end with an end opcode")
+		return
 	}
+	code.Code = body[:n-1]
+	code.End = body[n-1]
 }
 
 func (d *decoder) readLocalEntry(r io.Reader, le *LocalEntry) {
@@ -633,10 +975,10 @@ func (d *decoder) readDataSection(r io.Reader, s *DataSection) {
 	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
-	s.segments = make([]DataSegment, int(sz))
-	for i := range s.segments {
-		d.readDataSegment(r, &s.segments[i])
+	d.readCount(r, &sz)
+	s.Segments = make([]DataSegment, int(sz))
+	for i := range s.Segments {
+		d.readDataSegment(r, &s.Segments[i])
 	}
 }
 
@@ -645,11 +987,27 @@ func (d *decoder) readDataSegment(r io.Reader, ds *DataSegment) {
 		return
 	}
 
-	d.readVarU32(r, &ds.Index)
-	d.readInitExpr(r, &ds.Offset)
+	d.readVarU32(r, &ds.Flags)
+	if d.err != nil {
+		return
+	}
+	switch ds.Flags {
+	case 0:
+	case DataPassive, DataExplicit:
+		d.require(FeatureBulkMemory, "data segment with flags 0x%x", ds.Flags)
+	default:
+		d.err = fmt.Errorf("wasm: invalid data segment flags (0x%x)", ds.Flags)
+		return
+	}
+	if ds.Flags == DataExplicit {
+		d.readVarU32(r, &ds.Index)
+	}
+	if ds.Flags != DataPassive {
+		d.readInitExpr(r, &ds.Offset)
+	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readCount(r, &sz)
 	ds.Data = make([]byte, int(sz))
 	d.read(r, ds.Data)
 }
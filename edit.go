@@ -0,0 +1,716 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+)
+
+// This file provides methods to edit a decoded module.
+//
+// Edits shifting an index space rewrite all the indices referring to it:
+// exports, the start function, element and data segments, initializer
+// expressions, instruction immediates and the name section.
+// Rewritten immediates keep their encoded width whenever possible, so that
+// code offsets, such as those of DWARF debugging sections, remain valid as
+// long as no function body is removed or replaced.
+// Other custom sections are kept unchanged.
+
+// section returns the position and value of the known section with the
+// provided id, or -1 and nil.
+func (m *Module) section(id SectionID) (int, Section) {
+	for i, s := range m.Sections {
+		if s.ID() == id && !isCustom(s) {
+			return i, s
+		}
+	}
+	return -1, nil
+}
+
+func isCustom(s Section) bool {
+	switch s.(type) {
+	case NameSection, CustomSection:
+		return true
+	}
+	return false
+}
+
+// setSection replaces the known section with the ID of s, or inserts s
+// before the first known section following it in the module.
+func (m *Module) setSection(s Section) {
+	id := s.ID()
+	if i, _ := m.section(id); i >= 0 {
+		m.Sections[i] = s
+		return
+	}
+	pos := len(m.Sections)
+	last := -1
+	for i, o := range m.Sections {
+		if isCustom(o) {
+			continue
+		}
+		if sectionOrder(o.ID()) > sectionOrder(id) {
+			pos = i
+			break
+		}
+		last = i
+	}
+	if pos == len(m.Sections) {
+		// keep trailing custom sections last.
+		pos = last + 1
+	}
+	m.Sections = append(m.Sections, nil)
+	copy(m.Sections[pos+1:], m.Sections[pos:])
+	m.Sections[pos] = s
+}
+
+func (m *Module) types() TypeSection {
+	_, s := m.section(TypeID)
+	ts, _ := s.(TypeSection)
+	return ts
+}
+
+func (m *Module) imports() ImportSection {
+	_, s := m.section(ImportID)
+	is, _ := s.(ImportSection)
+	return is
+}
+
+func (m *Module) functions() FunctionSection {
+	_, s := m.section(FunctionID)
+	fs, _ := s.(FunctionSection)
+	return fs
+}
+
+func (m *Module) code() CodeSection {
+	_, s := m.section(CodeID)
+	cs, _ := s.(CodeSection)
+	return cs
+}
+
+func (m *Module) globals() GlobalSection {
+	_, s := m.section(GlobalID)
+	gs, _ := s.(GlobalSection)
+	return gs
+}
+
+func (m *Module) exports() ExportSection {
+	_, s := m.section(ExportID)
+	es, _ := s.(ExportSection)
+	return es
+}
+
+// numImports returns the number of imported entities of the provided kind.
+func (m *Module) numImports(kind ExternalKind) int {
+	n := 0
+	for _, imp := range m.imports().Imports {
+		if imp.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// numEntities returns the number of entities in the index space of the
+// provided kind.
+func (m *Module) numEntities(kind ExternalKind) int {
+	n := m.numImports(kind)
+	switch kind {
+	case FunctionKind:
+		n += len(m.functions().Types)
+	case TableKind:
+		if _, s := m.section(TableID); s != nil {
+			n += len(s.(TableSection).Tables)
+		}
+	case MemoryKind:
+		if _, s := m.section(MemoryID); s != nil {
+			n += len(s.(MemorySection).Memories)
+		}
+	case GlobalKind:
+		n += len(m.globals().Globals)
+	case TagKind:
+		if _, s := m.section(TagID); s != nil {
+			n += len(s.(TagSection).Tags)
+		}
+	}
+	return n
+}
+
+// AddType adds the function signature ft to the type section and returns
+// its index. The index of an identical signature is returned if there is
+// one already.
+func (m *Module) AddType(ft FuncType) uint32 {
+	ts := m.types()
+	for i, t := range ts.Types {
+		if equalTypes(t.Params, ft.Params) && equalTypes(t.Results, ft.Results) {
+			return uint32(i)
+		}
+	}
+	ts.Types = append(ts.Types[:len(ts.Types):len(ts.Types)], FuncType{
+		Params:  append([]ValueType{}, ft.Params...),
+		Results: append([]ValueType{}, ft.Results...),
+	})
+	m.setSection(ts)
+	return uint32(len(ts.Types) - 1)
+}
+
+// AddImport adds an import to the module and returns the index of the
+// imported entity in the index space of its kind.
+// Defined entities of the same kind are shifted by one.
+func (m *Module) AddImport(imp ImportEntry) (uint32, error) {
+	switch imp.Kind {
+	case FunctionKind:
+		idx, ok := imp.Type.(uint32)
+		if !ok {
+			return 0, fmt.Errorf("wasm: invalid type %T for a function import", imp.Type)
+		}
+		if int(idx) >= len(m.types().Types) {
+			return 0, fmt.Errorf("wasm: unknown type %d", idx)
+		}
+	case TableKind:
+		if _, ok := imp.Type.(TableType); !ok {
+			return 0, fmt.Errorf("wasm: invalid type %T for a table import", imp.Type)
+		}
+	case MemoryKind:
+		if _, ok := imp.Type.(MemoryType); !ok {
+			return 0, fmt.Errorf("wasm: invalid type %T for a memory import", imp.Type)
+		}
+	case GlobalKind:
+		if _, ok := imp.Type.(GlobalType); !ok {
+			return 0, fmt.Errorf("wasm: invalid type %T for a global import", imp.Type)
+		}
+	default:
+		return 0, fmt.Errorf("wasm: invalid import kind %d", imp.Kind)
+	}
+	is := m.imports()
+	for _, o := range is.Imports {
+		if o.Module == imp.Module && o.Field == imp.Field {
+			return 0, fmt.Errorf("wasm: duplicate import %s.%s", imp.Module, imp.Field)
+		}
+	}
+
+	n := uint32(m.numImports(imp.Kind))
+	err := m.remap(imp.Kind, func(i uint32) (uint32, bool) {
+		if i >= n {
+			i++
+		}
+		return i, true
+	})
+	if err != nil {
+		return 0, err
+	}
+	is.Imports = append(is.Imports[:len(is.Imports):len(is.Imports)], imp)
+	m.setSection(is)
+	return n, nil
+}
+
+// findImport returns the position of the import module.field in the
+// import section, and the index of the imported entity.
+func (m *Module) findImport(module, field string) (int, uint32, error) {
+	idx := make(map[ExternalKind]uint32)
+	for i, imp := range m.imports().Imports {
+		if imp.Module == module && imp.Field == field {
+			return i, idx[imp.Kind], nil
+		}
+		idx[imp.Kind]++
+	}
+	return -1, 0, fmt.Errorf("wasm: unknown import %s.%s", module, field)
+}
+
+// RemoveImport removes the import module.field from the module.
+// The imported entity must not be referenced.
+func (m *Module) RemoveImport(module, field string) error {
+	pos, idx, err := m.findImport(module, field)
+	if err != nil {
+		return err
+	}
+	is := m.imports()
+	kind := is.Imports[pos].Kind
+	err = m.remap(kind, removeIndex(idx))
+	if err != nil {
+		return err
+	}
+	imports := make([]ImportEntry, 0, len(is.Imports)-1)
+	imports = append(imports, is.Imports[:pos]...)
+	is.Imports = append(imports, is.Imports[pos+1:]...)
+	m.setSection(is)
+	return nil
+}
+
+// RenameImport renames the import module.field into newModule.newField.
+func (m *Module) RenameImport(module, field, newModule, newField string) error {
+	pos, _, err := m.findImport(module, field)
+	if err != nil {
+		return err
+	}
+	if module == newModule && field == newField {
+		return nil
+	}
+	if _, _, err := m.findImport(newModule, newField); err == nil {
+		return fmt.Errorf("wasm: duplicate import %s.%s", newModule, newField)
+	}
+	is := m.imports()
+	is.Imports = append([]ImportEntry{}, is.Imports...)
+	is.Imports[pos].Module = newModule
+	is.Imports[pos].Field = newField
+	m.setSection(is)
+	return nil
+}
+
+// AddFunc adds a function with signature ft and the provided body to the
+// module, and returns its index.
+func (m *Module) AddFunc(ft FuncType, body FunctionBody) uint32 {
+	typ := m.AddType(ft)
+	fs := m.functions()
+	fs.Types = append(fs.Types[:len(fs.Types):len(fs.Types)], typ)
+	m.setSection(fs)
+
+	cs := m.code()
+	body.LocalCount = varuint32(len(body.Locals))
+	body.Code.End = Op_end
+	body.BodySize = bodySize(body)
+	cs.Bodies = append(cs.Bodies[:len(cs.Bodies):len(cs.Bodies)], body)
+	m.setSection(cs)
+
+	return uint32(m.numEntities(FunctionKind) - 1)
+}
+
+// definedFunc returns the position of the function with the provided
+// index among the functions defined in the module.
+func (m *Module) definedFunc(idx uint32) (int, error) {
+	n := m.numImports(FunctionKind)
+	if int(idx) < n {
+		return -1, fmt.Errorf("wasm: function %d is imported", idx)
+	}
+	if int(idx) >= n+len(m.functions().Types) {
+		return -1, fmt.Errorf("wasm: unknown function %d", idx)
+	}
+	return int(idx) - n, nil
+}
+
+// RemoveFunc removes the function with the provided index from the module.
+// Removing an imported function removes its import.
+// The function must not be referenced.
+func (m *Module) RemoveFunc(idx uint32) error {
+	return m.removeEntity(FunctionKind, idx)
+}
+
+// SetFuncBody replaces the body of the defined function with the provided
+// index.
+func (m *Module) SetFuncBody(idx uint32, body FunctionBody) error {
+	pos, err := m.definedFunc(idx)
+	if err != nil {
+		return err
+	}
+	cs := m.code()
+	if pos >= len(cs.Bodies) {
+		return fmt.Errorf("wasm: function %d has no body", idx)
+	}
+	body.LocalCount = varuint32(len(body.Locals))
+	body.Code.End = Op_end
+	body.BodySize = bodySize(body)
+	cs.Bodies = append([]FunctionBody{}, cs.Bodies...)
+	cs.Bodies[pos] = body
+	m.setSection(cs)
+	return nil
+}
+
+// AddGlobal adds a global variable to the module and returns its index.
+func (m *Module) AddGlobal(gv GlobalVariable) uint32 {
+	gs := m.globals()
+	gs.Globals = append(gs.Globals[:len(gs.Globals):len(gs.Globals)], gv)
+	m.setSection(gs)
+	return uint32(m.numEntities(GlobalKind) - 1)
+}
+
+// RemoveGlobal removes the global variable with the provided index from
+// the module. Removing an imported global removes its import.
+// The global must not be referenced.
+func (m *Module) RemoveGlobal(idx uint32) error {
+	return m.removeEntity(GlobalKind, idx)
+}
+
+func (m *Module) removeEntity(kind ExternalKind, idx uint32) error {
+	if int(idx) >= m.numEntities(kind) {
+		return fmt.Errorf("wasm: unknown %v %d", kind, idx)
+	}
+	n := m.numImports(kind)
+	if int(idx) < n {
+		is := m.imports()
+		var k uint32
+		for _, imp := range is.Imports {
+			if imp.Kind != kind {
+				continue
+			}
+			if k == idx {
+				return m.RemoveImport(imp.Module, imp.Field)
+			}
+			k++
+		}
+	}
+
+	err := m.remap(kind, removeIndex(idx))
+	if err != nil {
+		return err
+	}
+	pos := int(idx) - n
+	switch kind {
+	case FunctionKind:
+		fs := m.functions()
+		fs.Types = removeAt(fs.Types, pos)
+		m.setSection(fs)
+		cs := m.code()
+		if pos < len(cs.Bodies) {
+			bodies := make([]FunctionBody, 0, len(cs.Bodies)-1)
+			bodies = append(bodies, cs.Bodies[:pos]...)
+			cs.Bodies = append(bodies, cs.Bodies[pos+1:]...)
+			m.setSection(cs)
+		}
+	case GlobalKind:
+		gs := m.globals()
+		globals := make([]GlobalVariable, 0, len(gs.Globals)-1)
+		globals = append(globals, gs.Globals[:pos]...)
+		gs.Globals = append(globals, gs.Globals[pos+1:]...)
+		m.setSection(gs)
+	}
+	return nil
+}
+
+func removeAt(s []uint32, i int) []uint32 {
+	o := make([]uint32, 0, len(s)-1)
+	o = append(o, s[:i]...)
+	return append(o, s[i+1:]...)
+}
+
+// removeIndex returns the index mapping of an index space where idx is
+// removed.
+func removeIndex(idx uint32) func(uint32) (uint32, bool) {
+	return func(i uint32) (uint32, bool) {
+		switch {
+		case i == idx:
+			return 0, false
+		case i > idx:
+			return i - 1, true
+		}
+		return i, true
+	}
+}
+
+// AddExport exports the entity of the provided kind and index under name.
+func (m *Module) AddExport(name string, kind ExternalKind, idx uint32) error {
+	es := m.exports()
+	for _, e := range es.Exports {
+		if e.Field == name {
+			return fmt.Errorf("wasm: duplicate export name %q", name)
+		}
+	}
+	if int(idx) >= m.numEntities(kind) {
+		return fmt.Errorf("wasm: unknown %v %d", kind, idx)
+	}
+	es.Exports = append(es.Exports[:len(es.Exports):len(es.Exports)], ExportEntry{
+		Field: name,
+		Kind:  kind,
+		Index: idx,
+	})
+	m.setSection(es)
+	return nil
+}
+
+func (m *Module) findExport(name string) (int, error) {
+	for i, e := range m.exports().Exports {
+		if e.Field == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("wasm: unknown export %q", name)
+}
+
+// RemoveExport removes the export with the provided name.
+func (m *Module) RemoveExport(name string) error {
+	pos, err := m.findExport(name)
+	if err != nil {
+		return err
+	}
+	es := m.exports()
+	exports := make([]ExportEntry, 0, len(es.Exports)-1)
+	exports = append(exports, es.Exports[:pos]...)
+	es.Exports = append(exports, es.Exports[pos+1:]...)
+	m.setSection(es)
+	return nil
+}
+
+// RenameExport renames the export name into newName.
+func (m *Module) RenameExport(name, newName string) error {
+	pos, err := m.findExport(name)
+	if err != nil {
+		return err
+	}
+	if name == newName {
+		return nil
+	}
+	if _, err := m.findExport(newName); err == nil {
+		return fmt.Errorf("wasm: duplicate export name %q", newName)
+	}
+	es := m.exports()
+	es.Exports = append([]ExportEntry{}, es.Exports...)
+	es.Exports[pos].Field = newName
+	m.setSection(es)
+	return nil
+}
+
+// bodySize returns the encoded size of a function body, its size
+// excluded.
+func bodySize(fb FunctionBody) uint32 {
+	n := len(appendUvarint(nil, uint64(len(fb.Locals))))
+	for _, le := range fb.Locals {
+		n += len(appendUvarint(nil, uint64(le.Count))) + 1
+	}
+	return uint32(n + len(fb.Code.Code) + 1)
+}
+
+// remapper rewrites the indices of an index space.
+type remapper struct {
+	kind  ExternalKind
+	remap func(uint32) (uint32, bool) // new index of an entity, false if removed
+	err   error
+}
+
+// index returns the new index of idx, recording an error if the entity
+// was removed while referenced from where.
+func (r *remapper) index(idx uint32, where string, args ...interface{}) uint32 {
+	v, ok := r.remap(idx)
+	if !ok {
+		if r.err == nil {
+			r.err = fmt.Errorf("wasm: %v %d is referenced by %s", r.kind, idx, fmt.Sprintf(where, args...))
+		}
+		return idx
+	}
+	return v
+}
+
+// remap rewrites all the references to entities of the provided kind
+// following remap. The module is left unchanged if a removed entity is
+// referenced.
+func (m *Module) remap(kind ExternalKind, remap func(uint32) (uint32, bool)) error {
+	r := &remapper{kind: kind, remap: remap}
+	nfuncs := m.numImports(FunctionKind)
+	secs := make([]Section, len(m.Sections))
+	for i, sec := range m.Sections {
+		switch s := sec.(type) {
+		case ExportSection:
+			exports := make([]ExportEntry, len(s.Exports))
+			for j, e := range s.Exports {
+				if e.Kind == kind {
+					e.Index = r.index(e.Index, "export %q", e.Field)
+				}
+				exports[j] = e
+			}
+			s.Exports = exports
+			sec = s
+		case StartSection:
+			if kind == FunctionKind {
+				s.Index = r.index(s.Index, "the start section")
+			}
+			sec = s
+		case GlobalSection:
+			globals := make([]GlobalVariable, len(s.Globals))
+			for j, g := range s.Globals {
+				g.Init = r.initExpr(g.Init, "the initializer of global variable %d", j)
+				globals[j] = g
+			}
+			s.Globals = globals
+			sec = s
+		case ElementSection:
+			elems := make([]ElemSegment, len(s.Elements))
+			for j, es := range s.Elements {
+				if es.isActive() {
+					if kind == TableKind {
+						es.Index = r.index(es.Index, "element segment %d", j)
+					}
+					es.Offset = r.initExpr(es.Offset, "the offset of element segment %d", j)
+				}
+				if kind == FunctionKind {
+					funcs := make([]uint32, len(es.Elems))
+					for k, f := range es.Elems {
+						funcs[k] = r.index(f, "element segment %d", j)
+					}
+					es.Elems = funcs
+				}
+				if len(es.Exprs) > 0 {
+					exprs := make([]InitExpr, len(es.Exprs))
+					for k, expr := range es.Exprs {
+						exprs[k] = r.initExpr(expr, "element %d of element segment %d", k, j)
+					}
+					es.Exprs = exprs
+				}
+				elems[j] = es
+			}
+			s.Elements = elems
+			sec = s
+		case DataSection:
+			segs := make([]DataSegment, len(s.Segments))
+			for j, ds := range s.Segments {
+				if ds.Flags&DataPassive == 0 {
+					if kind == MemoryKind {
+						ds.Index = r.index(ds.Index, "data segment %d", j)
+					}
+					ds.Offset = r.initExpr(ds.Offset, "the offset of data segment %d", j)
+				}
+				segs[j] = ds
+			}
+			s.Segments = segs
+			sec = s
+		case CodeSection:
+			bodies := make([]FunctionBody, len(s.Bodies))
+			for j, fb := range s.Bodies {
+				code := r.code(fb.Code.Code, "function %d", nfuncs+j)
+				if len(code) != len(fb.Code.Code) {
+					fb.BodySize += uint32(len(code) - len(fb.Code.Code))
+				}
+				fb.Code.Code = code
+				bodies[j] = fb
+			}
+			s.Bodies = bodies
+			sec = s
+		case NameSection:
+			if kind != FunctionKind {
+				break
+			}
+			funcs := make([]FunctionNames, 0, len(s.Funcs))
+			for _, fn := range s.Funcs {
+				idx, ok := remap(fn.Index)
+				if !ok {
+					continue
+				}
+				fn.Index = idx
+				funcs = append(funcs, fn)
+			}
+			s.Funcs = funcs
+			sec = s
+		}
+		if r.err != nil {
+			return r.err
+		}
+		secs[i] = sec
+	}
+	m.Sections = secs
+	return nil
+}
+
+// initExpr rewrites the global and function indices of an initializer
+// expression.
+func (r *remapper) initExpr(ie InitExpr, where string, args ...interface{}) InitExpr {
+	if r.kind != GlobalKind && r.kind != FunctionKind {
+		return ie
+	}
+	ie.Expr = r.code(ie.Expr, where, args...)
+	return ie
+}
+
+// code rewrites the indices in the immediates of the instructions of code.
+// The code is copied if modified.
+func (r *remapper) code(code []byte, where string, args ...interface{}) []byte {
+	var (
+		cr  = newCodeReader(code)
+		in  instruction
+		out []byte // rewritten code, if modified
+		pos = 0    // offset in code of the bytes not yet copied to out
+	)
+	for cr.next(&in) {
+		for _, imm := range r.immediates(&in) {
+			idx := r.index(imm.old, where+" at offset %#x", append(args, in.off)...)
+			if idx == imm.old {
+				continue
+			}
+
+			// locate the immediate to rewrite: it follows n other LEB128
+			// immediates.
+			start := in.immOff
+			for i := 0; i < imm.n; i++ {
+				for code[start]&0x80 != 0 {
+					start++
+				}
+				start++
+			}
+			end := start
+			for code[end]&0x80 != 0 {
+				end++
+			}
+			end++
+
+			if out == nil {
+				out = make([]byte, 0, len(code)+4)
+			}
+			out = append(out, code[pos:start]...)
+			if imm.implicit {
+				// make the memory index explicit, after the flags.
+				out = appendPaddedUvarint(out, uint64(in.idx|memArgExplicit), end-start)
+				out = appendUvarint(out, uint64(idx))
+			} else {
+				out = appendPaddedUvarint(out, uint64(idx), end-start)
+			}
+			pos = end
+		}
+	}
+	if cr.err != nil && r.err == nil {
+		r.err = fmt.Errorf("wasm: %s: offset %#x: %v", fmt.Sprintf(where, args...), cr.off, cr.err)
+	}
+	if out == nil {
+		return code
+	}
+	return append(out, code[pos:]...)
+}
+
+// immediate is an index immediate of an instruction, following n other
+// LEB128 immediates.
+type immediate struct {
+	n        int
+	old      uint32
+	implicit bool // whether the index is the implicit memory 0 of a memory access
+}
+
+// immediates returns the immediates of the instruction referring to the
+// remapped index space, in the order of their encoding.
+func (r *remapper) immediates(in *instruction) []immediate {
+	info := in.info()
+	switch r.kind {
+	case FunctionKind:
+		switch in.op {
+		case Op_call, Op_return_call, Op_ref_func:
+			return []immediate{{n: 0, old: in.idx}}
+		}
+	case GlobalKind:
+		switch in.op {
+		case Op_get_global, Op_set_global:
+			return []immediate{{n: 0, old: in.idx}}
+		}
+	case TableKind:
+		switch {
+		case in.op == Op_call_indirect || in.op == Op_return_call_indirect:
+			return []immediate{{n: 1, old: in.idx2}}
+		case info.imm == immTable:
+			return []immediate{{n: 0, old: in.idx}}
+		case in.op == Op_misc_prefix && in.sub == miscTableInit:
+			return []immediate{{n: 1, old: in.idx2}}
+		case in.op == Op_misc_prefix && in.sub == miscTableCopy:
+			return []immediate{{n: 0, old: in.idx}, {n: 1, old: in.idx2}}
+		}
+	case MemoryKind:
+		switch {
+		case info.imm == immMemory:
+			return []immediate{{n: 0, old: in.idx}}
+		case info.imm == immMemArg || info.imm == immMemArgLane:
+			if in.idx&memArgExplicit != 0 {
+				return []immediate{{n: 1, old: in.mem}}
+			}
+			return []immediate{{n: 0, old: 0, implicit: true}}
+		case in.op == Op_misc_prefix && in.sub == miscMemoryInit:
+			return []immediate{{n: 1, old: in.idx2}}
+		case in.op == Op_misc_prefix && in.sub == miscMemoryCopy:
+			return []immediate{{n: 0, old: in.idx}, {n: 1, old: in.idx2}}
+		}
+	}
+	return nil
+}
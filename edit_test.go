@@ -0,0 +1,310 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+// editModule adds to b an imported function, two defined functions
+// calling it and each other, and a global read by one of them.
+func editModule(b *wasm.Builder) {
+	void := wasm.FuncType{}
+	imp := b.ImportFunc("env", "log", void)
+	g := b.Global(wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}, wasm.ConstI32(42))
+	f1 := b.Func("f1", void)
+	f2 := b.Func("f2", void)
+	f1.Body().Call(imp).Call(f2).End()
+	f2.Body().GlobalGet(g).Drop().Call(imp).End()
+	tbl := b.Table(wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 2}})
+	b.Elements(tbl, wasm.ConstI32(0), f2, f1)
+	b.Export("f1", f1)
+	b.Export("g", g)
+	b.Start(f2)
+}
+
+func roundTrip(t *testing.T, m *wasm.Module) *wasm.Module {
+	t.Helper()
+	raw, err := wasm.Encode(m)
+	if err != nil {
+		t.Fatalf("could not encode module: %+v", err)
+	}
+	o, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("could not decode module: %+v", err)
+	}
+	return &o
+}
+
+func TestEditAddImport(t *testing.T) {
+	m := wasmtest.Build(t, editModule)
+	typ := m.AddType(wasm.FuncType{Params: []wasm.ValueType{wasm.I32}})
+	idx, err := m.AddImport(wasm.ImportEntry{Module: "env", Field: "abort", Kind: wasm.FunctionKind, Type: typ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 1 {
+		t.Fatalf("invalid import index: got=%d, want=1", idx)
+	}
+	gidx, err := m.AddImport(wasm.ImportEntry{Module: "env", Field: "base", Kind: wasm.GlobalKind, Type: wasm.GlobalType{ContentType: wasm.I32}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gidx != 0 {
+		t.Fatalf("invalid import index: got=%d, want=0", gidx)
+	}
+
+	m = roundTrip(t, m)
+	for _, s := range m.Sections {
+		switch s := s.(type) {
+		case wasm.ExportSection:
+			want := []wasm.ExportEntry{
+				{Field: "f1", Kind: wasm.FunctionKind, Index: 2},
+				{Field: "g", Kind: wasm.GlobalKind, Index: 1},
+			}
+			if !reflect.DeepEqual(s.Exports, want) {
+				t.Fatalf("invalid exports:\ngot= %v\nwant=%v", s.Exports, want)
+			}
+		case wasm.StartSection:
+			if s.Index != 3 {
+				t.Fatalf("invalid start function: got=%d, want=3", s.Index)
+			}
+		case wasm.ElementSection:
+			if want := []uint32{3, 2}; !reflect.DeepEqual(s.Elements[0].Elems, want) {
+				t.Fatalf("invalid elements: got=%v, want=%v", s.Elements[0].Elems, want)
+			}
+		case wasm.CodeSection:
+			want := [][]byte{
+				{byte(wasm.Op_call), 0, byte(wasm.Op_call), 3},
+				{wasm.Op_get_global, 1, byte(wasm.Op_drop), byte(wasm.Op_call), 0},
+			}
+			for i, fb := range s.Bodies {
+				if !bytes.Equal(fb.Code.Code, want[i]) {
+					t.Fatalf("invalid code for function %d:\ngot= %x\nwant=%x", i, fb.Code.Code, want[i])
+				}
+			}
+		case wasm.NameSection:
+			want := []wasm.FunctionNames{{Index: 2, Name: "f1"}, {Index: 3, Name: "f2"}}
+			if !reflect.DeepEqual(s.Funcs, want) {
+				t.Fatalf("invalid names:\ngot= %v\nwant=%v", s.Funcs, want)
+			}
+		}
+	}
+
+	if _, err := m.AddImport(wasm.ImportEntry{Module: "env", Field: "abort", Kind: wasm.FunctionKind, Type: typ}); err == nil {
+		t.Fatalf("expected an error for a duplicate import")
+	}
+	if _, err := m.AddImport(wasm.ImportEntry{Module: "env", Field: "x", Kind: wasm.FunctionKind, Type: uint32(42)}); err == nil {
+		t.Fatalf("expected an error for an unknown type")
+	}
+}
+
+func TestEditRemove(t *testing.T) {
+	m := wasmtest.Build(t, editModule)
+
+	if err := m.RemoveImport("env", "log"); err == nil {
+		t.Fatalf("expected an error removing a called function")
+	}
+	if err := m.RemoveFunc(1); err == nil {
+		t.Fatalf("expected an error removing an exported function")
+	}
+	if err := m.RemoveGlobal(0); err == nil {
+		t.Fatalf("expected an error removing a referenced global")
+	}
+	ref := wasmtest.Build(t, editModule)
+	if !reflect.DeepEqual(m, ref) {
+		t.Fatalf("module modified by failed edits")
+	}
+
+	// remove all references to f1 and remove it.
+	if err := m.RemoveExport("f1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveExport("f1"); err == nil {
+		t.Fatalf("expected an error removing an unknown export")
+	}
+	err := m.SetFuncBody(2, wasm.FunctionBody{
+		Locals: []wasm.LocalEntry{{Count: 1, Type: wasm.I32}},
+		Code:   wasm.Code{Code: []byte{byte(wasm.Op_call), 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveFunc(1); err == nil {
+		t.Fatalf("expected an error removing a function referenced by an element segment")
+	}
+	for i, s := range m.Sections {
+		if s, ok := s.(wasm.ElementSection); ok {
+			s.Elements[0].Elems = s.Elements[0].Elems[:1]
+			m.Sections[i] = s
+		}
+	}
+	if err := m.RemoveFunc(1); err != nil {
+		t.Fatal(err)
+	}
+
+	m = roundTrip(t, m)
+	for _, s := range m.Sections {
+		switch s := s.(type) {
+		case wasm.FunctionSection:
+			if len(s.Types) != 1 {
+				t.Fatalf("invalid number of functions: %d", len(s.Types))
+			}
+		case wasm.StartSection:
+			if s.Index != 1 {
+				t.Fatalf("invalid start function: got=%d, want=1", s.Index)
+			}
+		case wasm.ElementSection:
+			if want := []uint32{1}; !reflect.DeepEqual(s.Elements[0].Elems, want) {
+				t.Fatalf("invalid elements: got=%v, want=%v", s.Elements[0].Elems, want)
+			}
+		case wasm.CodeSection:
+			fb := s.Bodies[0]
+			if want := []byte{byte(wasm.Op_call), 0}; !bytes.Equal(fb.Code.Code, want) {
+				t.Fatalf("invalid code:\ngot= %x\nwant=%x", fb.Code.Code, want)
+			}
+			if want := []wasm.LocalEntry{{Count: 1, Type: wasm.I32}}; !reflect.DeepEqual(fb.Locals, want) {
+				t.Fatalf("invalid locals: got=%v, want=%v", fb.Locals, want)
+			}
+		case wasm.NameSection:
+			want := []wasm.FunctionNames{{Index: 1, Name: "f2"}}
+			if !reflect.DeepEqual(s.Funcs, want) {
+				t.Fatalf("invalid names:\ngot= %v\nwant=%v", s.Funcs, want)
+			}
+		}
+	}
+}
+
+func TestEditRemovePassive(t *testing.T) {
+	b := wasm.NewBuilder()
+	tbl := b.ImportTable("env", "table", wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 1}})
+	mem := b.ImportMemory("env", "memory", wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	f := b.Func("f", wasm.FuncType{})
+	f.Body().End()
+	b.Elements(tbl, wasm.ConstI32(0), f)
+	b.Data(mem, wasm.ConstI32(0), []byte("data"))
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range m.Sections {
+		switch s := s.(type) {
+		case wasm.ElementSection:
+			s.Elements[0].Flags = wasm.ElemPassive
+			s.Elements[0].Offset = wasm.InitExpr{}
+			s.Elements[0].Type = wasm.ElemType(wasm.Op_anyfunc)
+			m.Sections[i] = s
+		case wasm.DataSection:
+			s.Segments[0].Flags = wasm.DataPassive
+			s.Segments[0].Offset = wasm.InitExpr{}
+			m.Sections[i] = s
+		}
+	}
+
+	// the passive segments do not refer to the table and the memory.
+	if err := m.RemoveImport("env", "table"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveImport("env", "memory"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wasm.Validate(m, wasm.FeatureBulkMemory); err != nil {
+		t.Fatalf("invalid module: %+v", err)
+	}
+}
+
+func TestEditAddFunc(t *testing.T) {
+	m := wasmtest.Build(t, editModule)
+	gidx := m.AddGlobal(wasm.GlobalVariable{
+		Type: wasm.GlobalType{ContentType: wasm.I64},
+		Init: wasm.ConstI64(-1),
+	})
+	if gidx != 1 {
+		t.Fatalf("invalid global index: got=%d, want=1", gidx)
+	}
+	fidx := m.AddFunc(wasm.FuncType{Results: []wasm.ValueType{wasm.I64}}, wasm.FunctionBody{
+		Code: wasm.Code{Code: []byte{wasm.Op_get_global, byte(gidx)}},
+	})
+	if fidx != 3 {
+		t.Fatalf("invalid function index: got=%d, want=3", fidx)
+	}
+	if err := m.AddExport("get", wasm.FunctionKind, fidx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddExport("get", wasm.FunctionKind, fidx); err == nil {
+		t.Fatalf("expected an error for a duplicate export")
+	}
+	if err := m.AddExport("nope", wasm.GlobalKind, 2); err == nil {
+		t.Fatalf("expected an error for an unknown global")
+	}
+	if err := m.RenameExport("get", "g"); err == nil {
+		t.Fatalf("expected an error for a duplicate export")
+	}
+	if err := m.RenameExport("get", "get64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RenameImport("env", "log", "console", "log"); err != nil {
+		t.Fatal(err)
+	}
+
+	m = roundTrip(t, m)
+	for _, s := range m.Sections {
+		switch s := s.(type) {
+		case wasm.TypeSection:
+			if len(s.Types) != 2 {
+				t.Fatalf("invalid number of types: %d", len(s.Types))
+			}
+		case wasm.ImportSection:
+			if imp := s.Imports[0]; imp.Module != "console" || imp.Field != "log" {
+				t.Fatalf("invalid import: %s.%s", imp.Module, imp.Field)
+			}
+		case wasm.ExportSection:
+			want := wasm.ExportEntry{Field: "get64", Kind: wasm.FunctionKind, Index: 3}
+			if got := s.Exports[len(s.Exports)-1]; got != want {
+				t.Fatalf("invalid export: got=%v, want=%v", got, want)
+			}
+		}
+	}
+}
+
+func TestEditWidth(t *testing.T) {
+	// a call to function 1, encoded with a padded immediate.
+	m := wasmtest.Build(t, editModule)
+	err := m.SetFuncBody(2, wasm.FunctionBody{
+		Code: wasm.Code{Code: []byte{byte(wasm.Op_call), 0x81, 0x80, 0x00}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		_, err := m.AddImport(wasm.ImportEntry{
+			Module: "env",
+			Field:  string(rune('a'+i%26)) + string(rune('a'+i/26)),
+			Kind:   wasm.FunctionKind,
+			Type:   uint32(0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m = roundTrip(t, m)
+	for _, s := range m.Sections {
+		if s, ok := s.(wasm.CodeSection); ok {
+			// 201 = 0xc9: padded to 3 bytes.
+			if got, want := s.Bodies[1].Code.Code, []byte{byte(wasm.Op_call), 0xc9, 0x81, 0x00}; !bytes.Equal(got, want) {
+				t.Fatalf("invalid code:\ngot= %x\nwant=%x", got, want)
+			}
+			// 202 does not fit in the original width of 1 byte.
+			if got, want := s.Bodies[0].Code.Code, []byte{byte(wasm.Op_call), 0, byte(wasm.Op_call), 0xca, 0x01}; !bytes.Equal(got, want) {
+				t.Fatalf("invalid code:\ngot= %x\nwant=%x", got, want)
+			}
+		}
+	}
+}
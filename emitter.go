@@ -0,0 +1,508 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Emitter assembles the code of a function body, one instruction at a time.
+//
+// Emitter tracks the types of the operand stack and of the enclosing
+// control constructs: the first emitted instruction that would not
+// validate is recorded as an error, reported by Err and by Builder.Build.
+// The body is complete once End closed the implicit block of the function.
+type Emitter struct {
+	f      *Func
+	locals []LocalEntry
+	types  []ValueType // types of the parameters and locals
+	code   []byte
+	relocs []reloc
+
+	tc     typeChecker
+	labels []Label // labels of the control frames
+	nlbl   int     // number of labels created so far
+
+	ninstr int // number of emitted instructions
+	done   bool
+	err    error
+}
+
+// reloc records the position in the code of the index of an entity,
+// resolved when the body is finalized.
+type reloc struct {
+	off int    // offset in the code where the index is inserted
+	x   Extern // entity whose index is inserted
+}
+
+// Label identifies a block, loop or if construct as the target of
+// branch instructions.
+type Label struct {
+	id int
+}
+
+// Body returns the emitter assembling the code of a defined function.
+func (f *Func) Body() *Emitter {
+	if f.imported {
+		panic("wasm: cannot emit the body of an imported function")
+	}
+	if f.emitter != nil {
+		return f.emitter
+	}
+	ft := f.b.types[f.typ]
+	e := &Emitter{
+		f:     f,
+		types: append([]ValueType{}, ft.Params...),
+	}
+	e.tc.pushCtrl(Op_block, nil, ft.Results)
+	e.labels = append(e.labels, e.newLabel())
+	f.emitter = e
+	f.body = nil
+	return e
+}
+
+// Err returns the first error encountered while emitting instructions.
+func (e *Emitter) Err() error {
+	return e.err
+}
+
+// Local declares a new local variable of type vt and returns its index.
+// Consecutive locals of the same type share the same local entry.
+func (e *Emitter) Local(vt ValueType) uint32 {
+	idx := uint32(len(e.types))
+	e.types = append(e.types, vt)
+	if n := len(e.locals); n > 0 && e.locals[n-1].Type == vt {
+		e.locals[n-1].Count++
+	} else {
+		e.locals = append(e.locals, LocalEntry{Count: 1, Type: vt})
+	}
+	return idx
+}
+
+func (e *Emitter) newLabel() Label {
+	e.nlbl++
+	return Label{id: e.nlbl}
+}
+
+// begin starts the emission of an instruction, returning false if it
+// should not be emitted.
+func (e *Emitter) begin(op Opcode) bool {
+	if e.err != nil {
+		return false
+	}
+	e.ninstr++
+	if e.done {
+		e.fail(op, fmt.Errorf("instruction after the end of the function"))
+		return false
+	}
+	return true
+}
+
+func (e *Emitter) fail(op Opcode, err error) {
+	if e.err == nil && err != nil {
+		e.err = fmt.Errorf("wasm: function %q: instruction %d (%v): %v", e.f.Name, e.ninstr, op, err)
+	}
+}
+
+func (e *Emitter) check(op Opcode, err error) bool {
+	e.fail(op, err)
+	return e.err == nil
+}
+
+func (e *Emitter) writeU32(v uint32) {
+	e.code = appendUvarint(e.code, uint64(v))
+}
+
+// writeIndex writes the index of x, once resolved.
+func (e *Emitter) writeIndex(x Extern) {
+	e.relocs = append(e.relocs, reloc{off: len(e.code), x: x})
+}
+
+// depth returns the relative depth of the frame identified by l.
+func (e *Emitter) depth(l Label) (uint32, error) {
+	for i := len(e.labels) - 1; i >= 0; i-- {
+		if e.labels[i] == l {
+			return uint32(len(e.labels) - 1 - i), nil
+		}
+	}
+	return 0, fmt.Errorf("label %d is not in scope", l.id)
+}
+
+// Op emits an instruction without immediates, such as numeric
+// instructions.
+func (e *Emitter) Op(op Opcode) *Emitter {
+	if !e.begin(op) {
+		return e
+	}
+	info := &opcodes[op]
+	if info.name == "" || info.special || info.imm != immNone {
+		e.fail(op, fmt.Errorf("opcode cannot be emitted without immediates"))
+		return e
+	}
+	if e.check(op, e.tc.simple(info.in, info.out)) {
+		e.code = append(e.code, byte(op))
+	}
+	return e
+}
+
+func (e *Emitter) memOp(op Opcode, align, offset uint32) *Emitter {
+	if !e.begin(op) {
+		return e
+	}
+	info := &opcodes[op]
+	if e.check(op, e.tc.simple(info.in, info.out)) {
+		e.code = append(e.code, byte(op))
+		e.writeU32(align)
+		e.writeU32(offset)
+	}
+	return e
+}
+
+func (e *Emitter) memoryOp(op Opcode) *Emitter {
+	if !e.begin(op) {
+		return e
+	}
+	info := &opcodes[op]
+	if e.check(op, e.tc.simple(info.in, info.out)) {
+		e.code = append(e.code, byte(op), 0)
+	}
+	return e
+}
+
+// Unreachable emits an unreachable instruction.
+func (e *Emitter) Unreachable() *Emitter {
+	if e.begin(Op_unreachable) {
+		e.code = append(e.code, byte(Op_unreachable))
+		e.tc.setUnreachable()
+	}
+	return e
+}
+
+// Block opens a block construct with the provided result types.
+func (e *Emitter) Block(results ...ValueType) Label {
+	return e.block(Op_block, FuncType{Results: results})
+}
+
+// BlockFunc opens a block construct with the provided signature.
+func (e *Emitter) BlockFunc(ft FuncType) Label {
+	return e.block(Op_block, ft)
+}
+
+// Loop opens a loop construct with the provided result types.
+// Branches to the label of a loop jump back to its beginning.
+func (e *Emitter) Loop(results ...ValueType) Label {
+	return e.block(Op_loop, FuncType{Results: results})
+}
+
+// LoopFunc opens a loop construct with the provided signature.
+func (e *Emitter) LoopFunc(ft FuncType) Label {
+	return e.block(Op_loop, ft)
+}
+
+// If opens an if construct with the provided result types, consuming
+// an i32 condition.
+func (e *Emitter) If(results ...ValueType) Label {
+	return e.block(Op_if, FuncType{Results: results})
+}
+
+// IfFunc opens an if construct with the provided signature.
+func (e *Emitter) IfFunc(ft FuncType) Label {
+	return e.block(Op_if, ft)
+}
+
+func (e *Emitter) block(op Opcode, ft FuncType) Label {
+	l := e.newLabel()
+	if !e.begin(op) {
+		return l
+	}
+	if op == Op_if {
+		if _, err := e.tc.popExpect(I32); !e.check(op, err) {
+			return l
+		}
+	}
+	if !e.check(op, e.tc.popN(ft.Params)) {
+		return l
+	}
+	e.code = append(e.code, byte(op))
+	switch {
+	case len(ft.Params) == 0 && len(ft.Results) == 0:
+		e.code = append(e.code, Op_empty)
+	case len(ft.Params) == 0 && len(ft.Results) == 1:
+		e.code = append(e.code, byte(ft.Results[0]))
+	default:
+		e.code = appendVarint(e.code, int64(e.f.b.Type(ft)))
+	}
+	e.tc.pushCtrl(op, ft.Params, ft.Results)
+	e.labels = append(e.labels, l)
+	return l
+}
+
+// Else starts the else branch of the innermost if construct.
+func (e *Emitter) Else() *Emitter {
+	if e.begin(Op_else) && e.check(Op_else, e.tc.elseOp()) {
+		e.code = append(e.code, Op_else)
+	}
+	return e
+}
+
+// End closes the innermost block, loop or if construct, or the body of
+// the function.
+func (e *Emitter) End() *Emitter {
+	if !e.begin(Op_end) {
+		return e
+	}
+	if _, err := e.tc.end(); !e.check(Op_end, err) {
+		return e
+	}
+	e.labels = e.labels[:len(e.labels)-1]
+	if len(e.labels) == 0 {
+		e.done = true
+		return e
+	}
+	e.code = append(e.code, Op_end)
+	return e
+}
+
+// Br emits an unconditional branch to l.
+func (e *Emitter) Br(l Label) *Emitter {
+	return e.branch(Op_br, l, e.tc.br)
+}
+
+// BrIf emits a branch to l, taken if the i32 operand is not zero.
+func (e *Emitter) BrIf(l Label) *Emitter {
+	return e.branch(Op_br_if, l, e.tc.brIf)
+}
+
+func (e *Emitter) branch(op Opcode, l Label, check func(uint32) error) *Emitter {
+	if !e.begin(op) {
+		return e
+	}
+	n, err := e.depth(l)
+	if e.check(op, err) && e.check(op, check(n)) {
+		e.code = append(e.code, byte(op))
+		e.writeU32(n)
+	}
+	return e
+}
+
+// BrTable emits a branch to the label indexed by the i32 operand in
+// labels, or to def if the operand is out of range.
+func (e *Emitter) BrTable(labels []Label, def Label) *Emitter {
+	if !e.begin(Op_br_table) {
+		return e
+	}
+	ns := make([]uint32, len(labels))
+	for i, l := range labels {
+		n, err := e.depth(l)
+		if !e.check(Op_br_table, err) {
+			return e
+		}
+		ns[i] = n
+	}
+	ndef, err := e.depth(def)
+	if !e.check(Op_br_table, err) || !e.check(Op_br_table, e.tc.brTable(ns, ndef)) {
+		return e
+	}
+	e.code = append(e.code, Op_br_table)
+	e.writeU32(uint32(len(ns)))
+	for _, n := range ns {
+		e.writeU32(n)
+	}
+	e.writeU32(ndef)
+	return e
+}
+
+// Return emits a return instruction.
+func (e *Emitter) Return() *Emitter {
+	if e.begin(Op_return) && e.check(Op_return, e.tc.ret()) {
+		e.code = append(e.code, Op_return)
+	}
+	return e
+}
+
+// Call emits a call to f.
+func (e *Emitter) Call(f *Func) *Emitter {
+	if !e.begin(Op_call) {
+		return e
+	}
+	ft := f.b.types[f.typ]
+	if e.check(Op_call, e.tc.simple(ft.Params, ft.Results)) {
+		e.code = append(e.code, byte(Op_call))
+		e.writeIndex(f)
+	}
+	return e
+}
+
+// CallIndirect emits an indirect call, through the table, to a function
+// of signature ft.
+func (e *Emitter) CallIndirect(ft FuncType) *Emitter {
+	if !e.begin(Op_call_indirect) {
+		return e
+	}
+	if _, err := e.tc.popExpect(I32); !e.check(Op_call_indirect, err) {
+		return e
+	}
+	if e.check(Op_call_indirect, e.tc.simple(ft.Params, ft.Results)) {
+		e.code = append(e.code, Op_call_indirect)
+		e.writeU32(e.f.b.Type(ft))
+		e.code = append(e.code, 0)
+	}
+	return e
+}
+
+// Drop emits a drop instruction.
+func (e *Emitter) Drop() *Emitter {
+	if !e.begin(Op_drop) {
+		return e
+	}
+	if _, err := e.tc.pop(); e.check(Op_drop, err) {
+		e.code = append(e.code, byte(Op_drop))
+	}
+	return e
+}
+
+// Select emits a select instruction.
+func (e *Emitter) Select() *Emitter {
+	if e.begin(Op_select) && e.check(Op_select, e.tc.selectOp()) {
+		e.code = append(e.code, Op_select)
+	}
+	return e
+}
+
+func (e *Emitter) localType(op Opcode, i uint32) (ValueType, bool) {
+	if int(i) >= len(e.types) {
+		e.fail(op, fmt.Errorf("unknown local %d", i))
+		return unknownType, false
+	}
+	return e.types[i], true
+}
+
+// LocalGet pushes the value of the i-th local onto the stack.
+func (e *Emitter) LocalGet(i uint32) *Emitter {
+	if !e.begin(Op_get_local) {
+		return e
+	}
+	if vt, ok := e.localType(Op_get_local, i); ok {
+		e.tc.push(vt)
+		e.code = append(e.code, byte(Op_get_local))
+		e.writeU32(i)
+	}
+	return e
+}
+
+// LocalSet pops a value from the stack into the i-th local.
+func (e *Emitter) LocalSet(i uint32) *Emitter {
+	if !e.begin(Op_set_local) {
+		return e
+	}
+	if vt, ok := e.localType(Op_set_local, i); ok && e.check(Op_set_local, e.tc.popN([]ValueType{vt})) {
+		e.code = append(e.code, Op_set_local)
+		e.writeU32(i)
+	}
+	return e
+}
+
+// LocalTee copies the value on top of the stack into the i-th local.
+func (e *Emitter) LocalTee(i uint32) *Emitter {
+	if !e.begin(Op_tee_local) {
+		return e
+	}
+	if vt, ok := e.localType(Op_tee_local, i); ok && e.check(Op_tee_local, e.tc.simple([]ValueType{vt}, []ValueType{vt})) {
+		e.code = append(e.code, Op_tee_local)
+		e.writeU32(i)
+	}
+	return e
+}
+
+// GlobalGet pushes the value of the global g onto the stack.
+func (e *Emitter) GlobalGet(g *Global) *Emitter {
+	if e.begin(Op_get_global) {
+		e.tc.push(g.Type.ContentType)
+		e.code = append(e.code, Op_get_global)
+		e.writeIndex(g)
+	}
+	return e
+}
+
+// GlobalSet pops a value from the stack into the mutable global g.
+func (e *Emitter) GlobalSet(g *Global) *Emitter {
+	if !e.begin(Op_set_global) {
+		return e
+	}
+	if g.Type.Mutability == 0 {
+		e.fail(Op_set_global, fmt.Errorf("global is immutable"))
+		return e
+	}
+	if e.check(Op_set_global, e.tc.popN([]ValueType{g.Type.ContentType})) {
+		e.code = append(e.code, Op_set_global)
+		e.writeIndex(g)
+	}
+	return e
+}
+
+// I32Const pushes the constant v onto the stack.
+func (e *Emitter) I32Const(v int32) *Emitter {
+	if e.begin(Op_i32_const) {
+		e.tc.push(I32)
+		e.code = appendVarint(append(e.code, byte(Op_i32_const)), int64(v))
+	}
+	return e
+}
+
+// I64Const pushes the constant v onto the stack.
+func (e *Emitter) I64Const(v int64) *Emitter {
+	if e.begin(Op_i64_const) {
+		e.tc.push(I64)
+		e.code = appendVarint(append(e.code, Op_i64_const), v)
+	}
+	return e
+}
+
+// F32Const pushes the constant v onto the stack.
+func (e *Emitter) F32Const(v float32) *Emitter {
+	if e.begin(Op_f32_const) {
+		e.tc.push(F32)
+		e.code = append(e.code, Op_f32_const, 0, 0, 0, 0)
+		order.PutUint32(e.code[len(e.code)-4:], math.Float32bits(v))
+	}
+	return e
+}
+
+// F64Const pushes the constant v onto the stack.
+func (e *Emitter) F64Const(v float64) *Emitter {
+	if e.begin(Op_f64_const) {
+		e.tc.push(F64)
+		e.code = append(e.code, Op_f64_const, 0, 0, 0, 0, 0, 0, 0, 0)
+		order.PutUint64(e.code[len(e.code)-8:], math.Float64bits(v))
+	}
+	return e
+}
+
+// body returns the assembled function body, with the indices of the
+// referenced entities resolved.
+func (e *Emitter) body() (*FunctionBody, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if !e.done {
+		return nil, fmt.Errorf("wasm: function %q: body is not terminated (%d unclosed block(s))", e.f.Name, len(e.labels))
+	}
+
+	code := make([]byte, 0, len(e.code)+5*len(e.relocs))
+	beg := 0
+	for _, r := range e.relocs {
+		code = append(code, e.code[beg:r.off]...)
+		code = appendUvarint(code, uint64(r.x.Index()))
+		beg = r.off
+	}
+	code = append(code, e.code[beg:]...)
+
+	return &FunctionBody{
+		LocalCount: varuint32(len(e.locals)),
+		Locals:     e.locals,
+		Code:       Code{Code: code, End: Op_end},
+	}, nil
+}
@@ -0,0 +1,452 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+// Nop emits a nop instruction.
+func (e *Emitter) Nop() *Emitter { return e.Op(Op_nop) }
+
+// I32Load emits an i32.load instruction, with natural alignment.
+func (e *Emitter) I32Load(offset uint32) *Emitter { return e.memOp(Op_i32_load, 2, offset) }
+
+// I64Load emits an i64.load instruction, with natural alignment.
+func (e *Emitter) I64Load(offset uint32) *Emitter { return e.memOp(Op_i64_load, 3, offset) }
+
+// F32Load emits an f32.load instruction, with natural alignment.
+func (e *Emitter) F32Load(offset uint32) *Emitter { return e.memOp(Op_f32_load, 2, offset) }
+
+// F64Load emits an f64.load instruction, with natural alignment.
+func (e *Emitter) F64Load(offset uint32) *Emitter { return e.memOp(Op_f64_load, 3, offset) }
+
+// I32Load8S emits an i32.load8_s instruction, with natural alignment.
+func (e *Emitter) I32Load8S(offset uint32) *Emitter { return e.memOp(Op_i32_load8_s, 0, offset) }
+
+// I32Load8U emits an i32.load8_u instruction, with natural alignment.
+func (e *Emitter) I32Load8U(offset uint32) *Emitter { return e.memOp(Op_i32_load8_u, 0, offset) }
+
+// I32Load16S emits an i32.load16_s instruction, with natural alignment.
+func (e *Emitter) I32Load16S(offset uint32) *Emitter { return e.memOp(Op_i32_load16_s, 1, offset) }
+
+// I32Load16U emits an i32.load16_u instruction, with natural alignment.
+func (e *Emitter) I32Load16U(offset uint32) *Emitter { return e.memOp(Op_i32_load16_u, 1, offset) }
+
+// I64Load8S emits an i64.load8_s instruction, with natural alignment.
+func (e *Emitter) I64Load8S(offset uint32) *Emitter { return e.memOp(Op_i64_load8_s, 0, offset) }
+
+// I64Load8U emits an i64.load8_u instruction, with natural alignment.
+func (e *Emitter) I64Load8U(offset uint32) *Emitter { return e.memOp(Op_i64_load8_u, 0, offset) }
+
+// I64Load16S emits an i64.load16_s instruction, with natural alignment.
+func (e *Emitter) I64Load16S(offset uint32) *Emitter { return e.memOp(Op_i64_load16_s, 1, offset) }
+
+// I64Load16U emits an i64.load16_u instruction, with natural alignment.
+func (e *Emitter) I64Load16U(offset uint32) *Emitter { return e.memOp(Op_i64_load16_u, 1, offset) }
+
+// I64Load32S emits an i64.load32_s instruction, with natural alignment.
+func (e *Emitter) I64Load32S(offset uint32) *Emitter { return e.memOp(Op_i64_load32_s, 2, offset) }
+
+// I64Load32U emits an i64.load32_u instruction, with natural alignment.
+func (e *Emitter) I64Load32U(offset uint32) *Emitter { return e.memOp(Op_i64_load32_u, 2, offset) }
+
+// I32Store emits an i32.store instruction, with natural alignment.
+func (e *Emitter) I32Store(offset uint32) *Emitter { return e.memOp(Op_i32_store, 2, offset) }
+
+// I64Store emits an i64.store instruction, with natural alignment.
+func (e *Emitter) I64Store(offset uint32) *Emitter { return e.memOp(Op_i64_store, 3, offset) }
+
+// F32Store emits an f32.store instruction, with natural alignment.
+func (e *Emitter) F32Store(offset uint32) *Emitter { return e.memOp(Op_f32_store, 2, offset) }
+
+// F64Store emits an f64.store instruction, with natural alignment.
+func (e *Emitter) F64Store(offset uint32) *Emitter { return e.memOp(Op_f64_store, 3, offset) }
+
+// I32Store8 emits an i32.store8 instruction, with natural alignment.
+func (e *Emitter) I32Store8(offset uint32) *Emitter { return e.memOp(Op_i32_store8, 0, offset) }
+
+// I32Store16 emits an i32.store16 instruction, with natural alignment.
+func (e *Emitter) I32Store16(offset uint32) *Emitter { return e.memOp(Op_i32_store16, 1, offset) }
+
+// I64Store8 emits an i64.store8 instruction, with natural alignment.
+func (e *Emitter) I64Store8(offset uint32) *Emitter { return e.memOp(Op_i64_store8, 0, offset) }
+
+// I64Store16 emits an i64.store16 instruction, with natural alignment.
+func (e *Emitter) I64Store16(offset uint32) *Emitter { return e.memOp(Op_i64_store16, 1, offset) }
+
+// I64Store32 emits an i64.store32 instruction, with natural alignment.
+func (e *Emitter) I64Store32(offset uint32) *Emitter { return e.memOp(Op_i64_store32, 2, offset) }
+
+// MemorySize emits a memory.size instruction.
+func (e *Emitter) MemorySize() *Emitter { return e.memoryOp(Op_current_memory) }
+
+// MemoryGrow emits a memory.grow instruction.
+func (e *Emitter) MemoryGrow() *Emitter { return e.memoryOp(Op_grow_memory) }
+
+// I32Eqz emits an i32.eqz instruction.
+func (e *Emitter) I32Eqz() *Emitter { return e.Op(Op_i32_eqz) }
+
+// I32Eq emits an i32.eq instruction.
+func (e *Emitter) I32Eq() *Emitter { return e.Op(Op_i32_eq) }
+
+// I32Ne emits an i32.ne instruction.
+func (e *Emitter) I32Ne() *Emitter { return e.Op(Op_i32_ne) }
+
+// I32LtS emits an i32.lt_s instruction.
+func (e *Emitter) I32LtS() *Emitter { return e.Op(Op_i32_lt_s) }
+
+// I32LtU emits an i32.lt_u instruction.
+func (e *Emitter) I32LtU() *Emitter { return e.Op(Op_i32_lt_u) }
+
+// I32GtS emits an i32.gt_s instruction.
+func (e *Emitter) I32GtS() *Emitter { return e.Op(Op_i32_gt_s) }
+
+// I32GtU emits an i32.gt_u instruction.
+func (e *Emitter) I32GtU() *Emitter { return e.Op(Op_i32_gt_u) }
+
+// I32LeS emits an i32.le_s instruction.
+func (e *Emitter) I32LeS() *Emitter { return e.Op(Op_i32_le_s) }
+
+// I32LeU emits an i32.le_u instruction.
+func (e *Emitter) I32LeU() *Emitter { return e.Op(Op_i32_le_u) }
+
+// I32GeS emits an i32.ge_s instruction.
+func (e *Emitter) I32GeS() *Emitter { return e.Op(Op_i32_ge_s) }
+
+// I32GeU emits an i32.ge_u instruction.
+func (e *Emitter) I32GeU() *Emitter { return e.Op(Op_i32_ge_u) }
+
+// I64Eqz emits an i64.eqz instruction.
+func (e *Emitter) I64Eqz() *Emitter { return e.Op(Op_i64_eqz) }
+
+// I64Eq emits an i64.eq instruction.
+func (e *Emitter) I64Eq() *Emitter { return e.Op(Op_i64_eq) }
+
+// I64Ne emits an i64.ne instruction.
+func (e *Emitter) I64Ne() *Emitter { return e.Op(Op_i64_ne) }
+
+// I64LtS emits an i64.lt_s instruction.
+func (e *Emitter) I64LtS() *Emitter { return e.Op(Op_i64_lt_s) }
+
+// I64LtU emits an i64.lt_u instruction.
+func (e *Emitter) I64LtU() *Emitter { return e.Op(Op_i64_lt_u) }
+
+// I64GtS emits an i64.gt_s instruction.
+func (e *Emitter) I64GtS() *Emitter { return e.Op(Op_i64_gt_s) }
+
+// I64GtU emits an i64.gt_u instruction.
+func (e *Emitter) I64GtU() *Emitter { return e.Op(Op_i64_gt_u) }
+
+// I64LeS emits an i64.le_s instruction.
+func (e *Emitter) I64LeS() *Emitter { return e.Op(Op_i64_le_s) }
+
+// I64LeU emits an i64.le_u instruction.
+func (e *Emitter) I64LeU() *Emitter { return e.Op(Op_i64_le_u) }
+
+// I64GeS emits an i64.ge_s instruction.
+func (e *Emitter) I64GeS() *Emitter { return e.Op(Op_i64_ge_s) }
+
+// I64GeU emits an i64.ge_u instruction.
+func (e *Emitter) I64GeU() *Emitter { return e.Op(Op_i64_ge_u) }
+
+// F32Eq emits an f32.eq instruction.
+func (e *Emitter) F32Eq() *Emitter { return e.Op(Op_f32_eq) }
+
+// F32Ne emits an f32.ne instruction.
+func (e *Emitter) F32Ne() *Emitter { return e.Op(Op_f32_ne) }
+
+// F32Lt emits an f32.lt instruction.
+func (e *Emitter) F32Lt() *Emitter { return e.Op(Op_f32_lt) }
+
+// F32Gt emits an f32.gt instruction.
+func (e *Emitter) F32Gt() *Emitter { return e.Op(Op_f32_gt) }
+
+// F32Le emits an f32.le instruction.
+func (e *Emitter) F32Le() *Emitter { return e.Op(Op_f32_le) }
+
+// F32Ge emits an f32.ge instruction.
+func (e *Emitter) F32Ge() *Emitter { return e.Op(Op_f32_ge) }
+
+// F64Eq emits an f64.eq instruction.
+func (e *Emitter) F64Eq() *Emitter { return e.Op(Op_f64_eq) }
+
+// F64Ne emits an f64.ne instruction.
+func (e *Emitter) F64Ne() *Emitter { return e.Op(Op_f64_ne) }
+
+// F64Lt emits an f64.lt instruction.
+func (e *Emitter) F64Lt() *Emitter { return e.Op(Op_f64_lt) }
+
+// F64Gt emits an f64.gt instruction.
+func (e *Emitter) F64Gt() *Emitter { return e.Op(Op_f64_gt) }
+
+// F64Le emits an f64.le instruction.
+func (e *Emitter) F64Le() *Emitter { return e.Op(Op_f64_le) }
+
+// F64Ge emits an f64.ge instruction.
+func (e *Emitter) F64Ge() *Emitter { return e.Op(Op_f64_ge) }
+
+// I32Clz emits an i32.clz instruction.
+func (e *Emitter) I32Clz() *Emitter { return e.Op(Op_i32_clz) }
+
+// I32Ctz emits an i32.ctz instruction.
+func (e *Emitter) I32Ctz() *Emitter { return e.Op(Op_i32_ctz) }
+
+// I32Popcnt emits an i32.popcnt instruction.
+func (e *Emitter) I32Popcnt() *Emitter { return e.Op(Op_i32_popcnt) }
+
+// I32Add emits an i32.add instruction.
+func (e *Emitter) I32Add() *Emitter { return e.Op(Op_i32_add) }
+
+// I32Sub emits an i32.sub instruction.
+func (e *Emitter) I32Sub() *Emitter { return e.Op(Op_i32_sub) }
+
+// I32Mul emits an i32.mul instruction.
+func (e *Emitter) I32Mul() *Emitter { return e.Op(Op_i32_mul) }
+
+// I32DivS emits an i32.div_s instruction.
+func (e *Emitter) I32DivS() *Emitter { return e.Op(Op_i32_div_s) }
+
+// I32DivU emits an i32.div_u instruction.
+func (e *Emitter) I32DivU() *Emitter { return e.Op(Op_i32_div_u) }
+
+// I32RemS emits an i32.rem_s instruction.
+func (e *Emitter) I32RemS() *Emitter { return e.Op(Op_i32_rem_s) }
+
+// I32RemU emits an i32.rem_u instruction.
+func (e *Emitter) I32RemU() *Emitter { return e.Op(Op_i32_rem_u) }
+
+// I32And emits an i32.and instruction.
+func (e *Emitter) I32And() *Emitter { return e.Op(Op_i32_and) }
+
+// I32Or emits an i32.or instruction.
+func (e *Emitter) I32Or() *Emitter { return e.Op(Op_i32_or) }
+
+// I32Xor emits an i32.xor instruction.
+func (e *Emitter) I32Xor() *Emitter { return e.Op(Op_i32_xor) }
+
+// I32Shl emits an i32.shl instruction.
+func (e *Emitter) I32Shl() *Emitter { return e.Op(Op_i32_shl) }
+
+// I32ShrS emits an i32.shr_s instruction.
+func (e *Emitter) I32ShrS() *Emitter { return e.Op(Op_i32_shr_s) }
+
+// I32ShrU emits an i32.shr_u instruction.
+func (e *Emitter) I32ShrU() *Emitter { return e.Op(Op_i32_shr_u) }
+
+// I32Rotl emits an i32.rotl instruction.
+func (e *Emitter) I32Rotl() *Emitter { return e.Op(Op_i32_rotl) }
+
+// I32Rotr emits an i32.rotr instruction.
+func (e *Emitter) I32Rotr() *Emitter { return e.Op(Op_i32_rotr) }
+
+// I64Clz emits an i64.clz instruction.
+func (e *Emitter) I64Clz() *Emitter { return e.Op(Op_i64_clz) }
+
+// I64Ctz emits an i64.ctz instruction.
+func (e *Emitter) I64Ctz() *Emitter { return e.Op(Op_i64_ctz) }
+
+// I64Popcnt emits an i64.popcnt instruction.
+func (e *Emitter) I64Popcnt() *Emitter { return e.Op(Op_i64_popcnt) }
+
+// I64Add emits an i64.add instruction.
+func (e *Emitter) I64Add() *Emitter { return e.Op(Op_i64_add) }
+
+// I64Sub emits an i64.sub instruction.
+func (e *Emitter) I64Sub() *Emitter { return e.Op(Op_i64_sub) }
+
+// I64Mul emits an i64.mul instruction.
+func (e *Emitter) I64Mul() *Emitter { return e.Op(Op_i64_mul) }
+
+// I64DivS emits an i64.div_s instruction.
+func (e *Emitter) I64DivS() *Emitter { return e.Op(Op_i64_div_s) }
+
+// I64DivU emits an i64.div_u instruction.
+func (e *Emitter) I64DivU() *Emitter { return e.Op(Op_i64_div_u) }
+
+// I64RemS emits an i64.rem_s instruction.
+func (e *Emitter) I64RemS() *Emitter { return e.Op(Op_i64_rem_s) }
+
+// I64RemU emits an i64.rem_u instruction.
+func (e *Emitter) I64RemU() *Emitter { return e.Op(Op_i64_rem_u) }
+
+// I64And emits an i64.and instruction.
+func (e *Emitter) I64And() *Emitter { return e.Op(Op_i64_and) }
+
+// I64Or emits an i64.or instruction.
+func (e *Emitter) I64Or() *Emitter { return e.Op(Op_i64_or) }
+
+// I64Xor emits an i64.xor instruction.
+func (e *Emitter) I64Xor() *Emitter { return e.Op(Op_i64_xor) }
+
+// I64Shl emits an i64.shl instruction.
+func (e *Emitter) I64Shl() *Emitter { return e.Op(Op_i64_shl) }
+
+// I64ShrS emits an i64.shr_s instruction.
+func (e *Emitter) I64ShrS() *Emitter { return e.Op(Op_i64_shr_s) }
+
+// I64ShrU emits an i64.shr_u instruction.
+func (e *Emitter) I64ShrU() *Emitter { return e.Op(Op_i64_shr_u) }
+
+// I64Rotl emits an i64.rotl instruction.
+func (e *Emitter) I64Rotl() *Emitter { return e.Op(Op_i64_rotl) }
+
+// I64Rotr emits an i64.rotr instruction.
+func (e *Emitter) I64Rotr() *Emitter { return e.Op(Op_i64_rotr) }
+
+// F32Abs emits an f32.abs instruction.
+func (e *Emitter) F32Abs() *Emitter { return e.Op(Op_f32_abs) }
+
+// F32Neg emits an f32.neg instruction.
+func (e *Emitter) F32Neg() *Emitter { return e.Op(Op_f32_neg) }
+
+// F32Ceil emits an f32.ceil instruction.
+func (e *Emitter) F32Ceil() *Emitter { return e.Op(Op_f32_ceil) }
+
+// F32Floor emits an f32.floor instruction.
+func (e *Emitter) F32Floor() *Emitter { return e.Op(Op_f32_floor) }
+
+// F32Trunc emits an f32.trunc instruction.
+func (e *Emitter) F32Trunc() *Emitter { return e.Op(Op_f32_trunc) }
+
+// F32Nearest emits an f32.nearest instruction.
+func (e *Emitter) F32Nearest() *Emitter { return e.Op(Op_f32_nearest) }
+
+// F32Sqrt emits an f32.sqrt instruction.
+func (e *Emitter) F32Sqrt() *Emitter { return e.Op(Op_f32_sqrt) }
+
+// F32Add emits an f32.add instruction.
+func (e *Emitter) F32Add() *Emitter { return e.Op(Op_f32_add) }
+
+// F32Sub emits an f32.sub instruction.
+func (e *Emitter) F32Sub() *Emitter { return e.Op(Op_f32_sub) }
+
+// F32Mul emits an f32.mul instruction.
+func (e *Emitter) F32Mul() *Emitter { return e.Op(Op_f32_mul) }
+
+// F32Div emits an f32.div instruction.
+func (e *Emitter) F32Div() *Emitter { return e.Op(Op_f32_div) }
+
+// F32Min emits an f32.min instruction.
+func (e *Emitter) F32Min() *Emitter { return e.Op(Op_f32_min) }
+
+// F32Max emits an f32.max instruction.
+func (e *Emitter) F32Max() *Emitter { return e.Op(Op_f32_max) }
+
+// F32Copysign emits an f32.copysign instruction.
+func (e *Emitter) F32Copysign() *Emitter { return e.Op(Op_f32_copysign) }
+
+// F64Abs emits an f64.abs instruction.
+func (e *Emitter) F64Abs() *Emitter { return e.Op(Op_f64_abs) }
+
+// F64Neg emits an f64.neg instruction.
+func (e *Emitter) F64Neg() *Emitter { return e.Op(Op_f64_neg) }
+
+// F64Ceil emits an f64.ceil instruction.
+func (e *Emitter) F64Ceil() *Emitter { return e.Op(Op_f64_ceil) }
+
+// F64Floor emits an f64.floor instruction.
+func (e *Emitter) F64Floor() *Emitter { return e.Op(Op_f64_floor) }
+
+// F64Trunc emits an f64.trunc instruction.
+func (e *Emitter) F64Trunc() *Emitter { return e.Op(Op_f64_trunc) }
+
+// F64Nearest emits an f64.nearest instruction.
+func (e *Emitter) F64Nearest() *Emitter { return e.Op(Op_f64_nearest) }
+
+// F64Sqrt emits an f64.sqrt instruction.
+func (e *Emitter) F64Sqrt() *Emitter { return e.Op(Op_f64_sqrt) }
+
+// F64Add emits an f64.add instruction.
+func (e *Emitter) F64Add() *Emitter { return e.Op(Op_f64_add) }
+
+// F64Sub emits an f64.sub instruction.
+func (e *Emitter) F64Sub() *Emitter { return e.Op(Op_f64_sub) }
+
+// F64Mul emits an f64.mul instruction.
+func (e *Emitter) F64Mul() *Emitter { return e.Op(Op_f64_mul) }
+
+// F64Div emits an f64.div instruction.
+func (e *Emitter) F64Div() *Emitter { return e.Op(Op_f64_div) }
+
+// F64Min emits an f64.min instruction.
+func (e *Emitter) F64Min() *Emitter { return e.Op(Op_f64_min) }
+
+// F64Max emits an f64.max instruction.
+func (e *Emitter) F64Max() *Emitter { return e.Op(Op_f64_max) }
+
+// F64Copysign emits an f64.copysign instruction.
+func (e *Emitter) F64Copysign() *Emitter { return e.Op(Op_f64_copysign) }
+
+// I32WrapI64 emits an i32.wrap_i64 instruction.
+func (e *Emitter) I32WrapI64() *Emitter { return e.Op(Op_i32_wrap_i64) }
+
+// I32TruncF32S emits an i32.trunc_f32_s instruction.
+func (e *Emitter) I32TruncF32S() *Emitter { return e.Op(Op_i32_trunc_s_f32) }
+
+// I32TruncF32U emits an i32.trunc_f32_u instruction.
+func (e *Emitter) I32TruncF32U() *Emitter { return e.Op(Op_i32_trunc_u_f32) }
+
+// I32TruncF64S emits an i32.trunc_f64_s instruction.
+func (e *Emitter) I32TruncF64S() *Emitter { return e.Op(Op_i32_trunc_s_f64) }
+
+// I32TruncF64U emits an i32.trunc_f64_u instruction.
+func (e *Emitter) I32TruncF64U() *Emitter { return e.Op(Op_i32_trunc_u_f64) }
+
+// I64ExtendI32S emits an i64.extend_i32_s instruction.
+func (e *Emitter) I64ExtendI32S() *Emitter { return e.Op(Op_i64_extend_s_i32) }
+
+// I64ExtendI32U emits an i64.extend_i32_u instruction.
+func (e *Emitter) I64ExtendI32U() *Emitter { return e.Op(Op_i64_extend_u_i32) }
+
+// I64TruncF32S emits an i64.trunc_f32_s instruction.
+func (e *Emitter) I64TruncF32S() *Emitter { return e.Op(Op_i64_trunc_s_f32) }
+
+// I64TruncF32U emits an i64.trunc_f32_u instruction.
+func (e *Emitter) I64TruncF32U() *Emitter { return e.Op(Op_i64_trunc_u_f32) }
+
+// I64TruncF64S emits an i64.trunc_f64_s instruction.
+func (e *Emitter) I64TruncF64S() *Emitter { return e.Op(Op_i64_trunc_s_f64) }
+
+// I64TruncF64U emits an i64.trunc_f64_u instruction.
+func (e *Emitter) I64TruncF64U() *Emitter { return e.Op(Op_i64_trunc_u_f64) }
+
+// F32ConvertI32S emits an f32.convert_i32_s instruction.
+func (e *Emitter) F32ConvertI32S() *Emitter { return e.Op(Op_f32_convert_s_i32) }
+
+// F32ConvertI32U emits an f32.convert_i32_u instruction.
+func (e *Emitter) F32ConvertI32U() *Emitter { return e.Op(Op_f32_convert_u_i32) }
+
+// F32ConvertI64S emits an f32.convert_i64_s instruction.
+func (e *Emitter) F32ConvertI64S() *Emitter { return e.Op(Op_f32_convert_s_i64) }
+
+// F32ConvertI64U emits an f32.convert_i64_u instruction.
+func (e *Emitter) F32ConvertI64U() *Emitter { return e.Op(Op_f32_convert_u_i64) }
+
+// F32DemoteF64 emits an f32.demote_f64 instruction.
+func (e *Emitter) F32DemoteF64() *Emitter { return e.Op(Op_f32_demote_f64) }
+
+// F64ConvertI32S emits an f64.convert_i32_s instruction.
+func (e *Emitter) F64ConvertI32S() *Emitter { return e.Op(Op_f64_convert_s_i32) }
+
+// F64ConvertI32U emits an f64.convert_i32_u instruction.
+func (e *Emitter) F64ConvertI32U() *Emitter { return e.Op(Op_f64_convert_u_i32) }
+
+// F64ConvertI64S emits an f64.convert_i64_s instruction.
+func (e *Emitter) F64ConvertI64S() *Emitter { return e.Op(Op_f64_convert_s_i64) }
+
+// F64ConvertI64U emits an f64.convert_i64_u instruction.
+func (e *Emitter) F64ConvertI64U() *Emitter { return e.Op(Op_f64_convert_u_i64) }
+
+// F64PromoteF32 emits an f64.promote_f32 instruction.
+func (e *Emitter) F64PromoteF32() *Emitter { return e.Op(Op_f64_promote_f32) }
+
+// I32ReinterpretF32 emits an i32.reinterpret_f32 instruction.
+func (e *Emitter) I32ReinterpretF32() *Emitter { return e.Op(Op_i32_reinterpret_f32) }
+
+// I64ReinterpretF64 emits an i64.reinterpret_f64 instruction.
+func (e *Emitter) I64ReinterpretF64() *Emitter { return e.Op(Op_i64_reinterpret_f64) }
+
+// F32ReinterpretI32 emits an f32.reinterpret_i32 instruction.
+func (e *Emitter) F32ReinterpretI32() *Emitter { return e.Op(Op_f32_reinterpret_i32) }
+
+// F64ReinterpretI64 emits an f64.reinterpret_i64 instruction.
+func (e *Emitter) F64ReinterpretI64() *Emitter { return e.Op(Op_f64_reinterpret_i64) }
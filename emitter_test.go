@@ -0,0 +1,244 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestEmitterAdd(t *testing.T) {
+	want, err := os.ReadFile("testdata/add.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := wasm.NewBuilder()
+	add := b.Func("", wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.I32},
+		Results: []wasm.ValueType{wasm.I32},
+	})
+	add.Body().LocalGet(0).LocalGet(1).I32Add().End()
+	b.Export("add", add)
+
+	mod, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Encode(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid module:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestEmitterControl(t *testing.T) {
+	b := wasm.NewBuilder()
+	log := b.ImportFunc("env", "log", wasm.FuncType{Params: []wasm.ValueType{wasm.I64}})
+	fact := b.Func("fact", wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32},
+		Results: []wasm.ValueType{wasm.I64},
+	})
+
+	e := fact.Body()
+	acc := e.Local(wasm.I64)
+	tmp := e.Local(wasm.I64)
+	i := e.Local(wasm.I32)
+	if acc != 1 || tmp != 2 || i != 3 {
+		t.Fatalf("invalid local indices: %d %d %d", acc, tmp, i)
+	}
+
+	e.I64Const(1).LocalSet(acc)
+	e.LocalGet(0).LocalSet(i)
+	done := e.Block()
+	loop := e.Loop()
+	e.LocalGet(i).I32Eqz().BrIf(done)
+	e.LocalGet(acc).LocalGet(i).I64ExtendI32U().I64Mul().LocalTee(acc).Call(log)
+	e.LocalGet(i).I32Const(1).I32Sub().LocalSet(i)
+	e.Br(loop)
+	e.End() // loop
+	e.End() // block
+	e.LocalGet(0).I32Const(20).I32GtU()
+	e.If(wasm.I64)
+	e.I64Const(-1)
+	e.Else()
+	e.LocalGet(acc)
+	e.End()
+	e.End()
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := wasm.Encode(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var code wasm.CodeSection
+	for _, s := range got.Sections {
+		if s, ok := s.(wasm.CodeSection); ok {
+			code = s
+		}
+	}
+	body := code.Bodies[0]
+	if want := []wasm.LocalEntry{{Count: 2, Type: wasm.I64}, {Count: 1, Type: wasm.I32}}; !reflect.DeepEqual(body.Locals, want) {
+		t.Fatalf("invalid locals:\ngot= %v\nwant=%v", body.Locals, want)
+	}
+
+	want := []byte{
+		0x42, 0x01, 0x21, 0x01, // i64.const 1; local.set 1
+		0x20, 0x00, 0x21, 0x03, // local.get 0; local.set 3
+		0x02, 0x40, // block
+		0x03, 0x40, // loop
+		0x20, 0x03, 0x45, 0x0d, 0x01, // local.get 3; i32.eqz; br_if 1
+		0x20, 0x01, 0x20, 0x03, 0xad, 0x7e, 0x22, 0x01, 0x10, 0x00, // acc*i; call log
+		0x20, 0x03, 0x41, 0x01, 0x6b, 0x21, 0x03, // i--
+		0x0c, 0x00, // br 0
+		0x0b, 0x0b, // end; end
+		0x20, 0x00, 0x41, 0x14, 0x4b, // local.get 0; i32.const 20; i32.gt_u
+		0x04, 0x7e, 0x42, 0x7f, 0x05, 0x20, 0x01, 0x0b, // if (result i64) ... else ... end
+	}
+	if !bytes.Equal(body.Code.Code, want) {
+		t.Fatalf("invalid code:\ngot= %x\nwant=%x", body.Code.Code, want)
+	}
+}
+
+func TestEmitterMultiValue(t *testing.T) {
+	b := wasm.NewBuilder()
+	f := b.Func("swap", wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.F64},
+		Results: []wasm.ValueType{wasm.F64, wasm.I32},
+	})
+	e := f.Body()
+	tmp := e.Local(wasm.I32)
+	e.LocalGet(0).LocalGet(1)
+	e.BlockFunc(wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.F64},
+		Results: []wasm.ValueType{wasm.F64, wasm.I32},
+	})
+	e.LocalSet(tmp) // f64 on top of the stack
+	e.End()
+	if e.Err() == nil {
+		t.Fatalf("expected a type error")
+	}
+
+	b = wasm.NewBuilder()
+	sig := wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32, wasm.F64},
+		Results: []wasm.ValueType{wasm.F64, wasm.I32},
+	}
+	f = b.Func("swap", sig)
+	e = f.Body()
+	x := e.Local(wasm.F64)
+	e.LocalGet(0).LocalGet(1)
+	e.BlockFunc(sig)
+	e.LocalSet(x).LocalGet(x).I32Const(0).Drop()
+	e.Br(e.Block()) // branch out of an empty block: no values carried
+	e.End()
+	e.Unreachable()
+	e.End()
+	e.End()
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Type(sig), uint32(0); got != want {
+		t.Fatalf("block type not shared with function type: got=%d, want=%d", got, want)
+	}
+}
+
+func TestEmitterErrors(t *testing.T) {
+	i2i := wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+	for _, tc := range []struct {
+		name string
+		emit func(e *wasm.Emitter)
+		want string
+	}{
+		{
+			name: "unbalanced",
+			emit: func(e *wasm.Emitter) { e.LocalGet(0).LocalGet(0).End() },
+			want: "extra value(s)",
+		},
+		{
+			name: "underflow",
+			emit: func(e *wasm.Emitter) { e.I32Add().End() },
+			want: "i32.add",
+		},
+		{
+			name: "mismatch",
+			emit: func(e *wasm.Emitter) { e.LocalGet(0).I64Const(1).I32Add().End() },
+			want: "expected i32, got i64",
+		},
+		{
+			name: "label",
+			emit: func(e *wasm.Emitter) {
+				l := e.Block()
+				e.End()
+				e.LocalGet(0).Br(l).End()
+			},
+			want: "not in scope",
+		},
+		{
+			name: "else",
+			emit: func(e *wasm.Emitter) { e.Block(); e.Else() },
+			want: "else without matching if",
+		},
+		{
+			name: "if-without-else",
+			emit: func(e *wasm.Emitter) { e.LocalGet(0).If(wasm.I32); e.I32Const(1).End().End() },
+			want: "if without else",
+		},
+		{
+			name: "local",
+			emit: func(e *wasm.Emitter) { e.LocalGet(2).End() },
+			want: "unknown local 2",
+		},
+		{
+			name: "after-end",
+			emit: func(e *wasm.Emitter) { e.LocalGet(0).End().Nop() },
+			want: "after the end",
+		},
+		{
+			name: "unterminated",
+			emit: func(e *wasm.Emitter) { e.LocalGet(0) },
+			want: "not terminated",
+		},
+		{
+			name: "op",
+			emit: func(e *wasm.Emitter) { e.Op(wasm.Op_i32_const) },
+			want: "without immediates",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			f := b.Func("f", i2i)
+			tc.emit(f.Body())
+			_, err := b.Build()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error: got=%q, want=%q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Encode returns the binary encoding of the module.
+func Encode(m *Module) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_, err := m.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes the binary encoding of the module to w.
+//
+// Known sections are written in the order mandated by the specification,
+// custom sections are kept right after the known section they followed.
+// All sizes and integers are written in their canonical LEB128 encoding.
+func (m *Module) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	enc := encoder{w: cw, version: m.Header.Version}
+	if enc.version == 0 {
+		enc.version = Version
+	}
+	if m.raw != nil {
+		enc.raw = newRawUsage(m.raw)
+	}
+	enc.writeModule(m)
+	return cw.n, enc.err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+type encoder struct {
+	w       io.Writer
+	err     error
+	version uint32 // version of the module being encoded
+	buf     [binary.MaxVarintLen64]byte
+
+	raw *rawUsage // original encoding of the module, if retained
+}
+
+func (e *encoder) write(w io.Writer, p []byte) {
+	if e.err != nil || len(p) == 0 {
+		return
+	}
+	_, e.err = w.Write(p)
+}
+
+func (e *encoder) writeByte(w io.Writer, v byte) {
+	e.buf[0] = v
+	e.write(w, e.buf[:1])
+}
+
+func (e *encoder) writeVarU1(w io.Writer, v uint32) {
+	if v > 1 {
+		if e.err == nil {
+			e.err = fmt.Errorf("wasm: invalid varuint1 value (%d)", v)
+		}
+		return
+	}
+	e.writeVarU32(w, v)
+}
+
+func (e *encoder) writeVarU32(w io.Writer, v uint32) {
+	e.write(w, appendUvarint(e.buf[:0], uint64(v)))
+}
+
+func (e *encoder) writeVarU64(w io.Writer, v uint64) {
+	e.write(w, appendUvarint(e.buf[:0], v))
+}
+
+func (e *encoder) writeVarI32(w io.Writer, v int32) {
+	e.write(w, appendVarint(e.buf[:0], int64(v)))
+}
+
+func (e *encoder) writeVarI64(w io.Writer, v int64) {
+	e.write(w, appendVarint(e.buf[:0], v))
+}
+
+func (e *encoder) writeString(w io.Writer, s string) {
+	e.writeVarU32(w, uint32(len(s)))
+	e.write(w, []byte(s))
+}
+
+// writeBlob writes the size of p followed by p itself.
+func (e *encoder) writeBlob(w io.Writer, p []byte) {
+	e.writeVarU32(w, uint32(len(p)))
+	e.write(w, p)
+}
+
+func (e *encoder) writeModule(m *Module) {
+	e.writeHeader(e.w)
+
+	sections, err := sortSections(m.Sections)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	payload := new(bytes.Buffer)
+	for _, s := range sections {
+		payload.Reset()
+		e.writeSection(e.w, s, payload)
+		if e.err != nil {
+			return
+		}
+	}
+}
+
+func (e *encoder) writeSection(w io.Writer, s Section, payload *bytes.Buffer) {
+	id := s.ID()
+	if e.raw == nil {
+		e.writeSectionPayload(payload, s)
+		e.writeByte(w, byte(id))
+		e.writeBlob(w, payload.Bytes())
+		return
+	}
+
+	// look the original encoding of the section up by its canonical one.
+	raw := e.raw
+	e.raw = nil
+	e.writeSectionPayload(payload, s)
+	e.raw = raw
+	if orig, ok := raw.section(id, payload.Bytes()); ok {
+		e.write(w, orig)
+		return
+	}
+
+	if id == CodeID {
+		// re-use the original encoding of untouched function bodies.
+		payload.Reset()
+		e.writeSectionPayload(payload, s)
+	}
+	e.writeByte(w, byte(id))
+	e.write(w, appendPaddedUvarint(e.buf[:0], uint64(payload.Len()), raw.raw.widths[id]))
+	e.write(w, payload.Bytes())
+}
+
+func (e *encoder) writeHeader(w io.Writer) {
+	e.write(w, magicWASM[:])
+	var v [4]byte
+	order.PutUint32(v[:], e.version)
+	e.write(w, v[:])
+}
+
+// sectionOrder returns the rank of a known section in a module.
+func sectionOrder(id SectionID) int {
+	switch id {
+	case TagID:
+		return int(MemoryID)*2 + 1
+	case DataCountID:
+		return int(ElementID)*2 + 1
+	}
+	return int(id) * 2
+}
+
+// sortSections returns the sections in the order mandated by the
+// specification. Custom sections stay right after the known section
+// preceding them.
+func sortSections(secs []Section) ([]Section, error) {
+	type item struct {
+		rank int
+		sec  Section
+	}
+	var (
+		items = make([]item, len(secs))
+		rank  = 0
+		seen  = make(map[SectionID]bool)
+	)
+	for i, s := range secs {
+		if s == nil {
+			return nil, fmt.Errorf("wasm: nil section")
+		}
+		id := s.ID()
+		if id != UnknownID {
+			if seen[id] {
+				return nil, fmt.Errorf("wasm: duplicate section (id=%d)", id)
+			}
+			seen[id] = true
+			rank = sectionOrder(id)
+		}
+		items[i] = item{rank: rank, sec: s}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].rank < items[j].rank })
+
+	o := make([]Section, len(items))
+	for i, it := range items {
+		o[i] = it.sec
+	}
+	return o, nil
+}
+
+func (e *encoder) writeSectionPayload(w io.Writer, sec Section) {
+	switch s := sec.(type) {
+	case CustomSection:
+		e.writeString(w, s.Name)
+		e.write(w, s.Data)
+	case NameSection:
+		e.writeNameSection(w, s)
+	case TypeSection:
+		e.writeTypeSection(w, s)
+	case ImportSection:
+		e.writeImportSection(w, s)
+	case FunctionSection:
+		e.writeFunctionSection(w, s)
+	case TableSection:
+		e.writeTableSection(w, s)
+	case MemorySection:
+		e.writeMemorySection(w, s)
+	case GlobalSection:
+		e.writeGlobalSection(w, s)
+	case ExportSection:
+		e.writeExportSection(w, s)
+	case StartSection:
+		e.writeVarU32(w, s.Index)
+	case ElementSection:
+		e.writeElementSection(w, s)
+	case CodeSection:
+		e.writeCodeSection(w, s)
+	case DataSection:
+		e.writeDataSection(w, s)
+	case DataCountSection:
+		e.writeVarU32(w, s.Count)
+	case TagSection:
+		e.writeVarU32(w, uint32(len(s.Tags)))
+		for _, tt := range s.Tags {
+			e.writeTagType(w, tt)
+		}
+	default:
+		e.err = fmt.Errorf("wasm: invalid section type %T", sec)
+	}
+}
+
+func (e *encoder) writeNameSection(w io.Writer, s NameSection) {
+	name := s.Name
+	if name == "" {
+		name = "name"
+	}
+	e.writeString(w, name)
+
+	if e.version == legacyVersion {
+		e.writeVarU32(w, uint32(len(s.Funcs)))
+		for _, f := range s.Funcs {
+			e.writeString(w, f.Name)
+			e.writeVarU32(w, uint32(len(f.Locals)))
+			for _, l := range f.Locals {
+				e.writeString(w, l.Name)
+			}
+		}
+		return
+	}
+
+	sub := new(bytes.Buffer)
+	if s.Module != "" {
+		e.writeString(sub, s.Module)
+		e.writeByte(w, nameModuleID)
+		e.writeBlob(w, sub.Bytes())
+	}
+
+	var n uint32
+	for _, f := range s.Funcs {
+		if f.Name != "" {
+			n++
+		}
+	}
+	if n > 0 {
+		sub.Reset()
+		e.writeVarU32(sub, n)
+		for _, f := range s.Funcs {
+			if f.Name == "" {
+				continue
+			}
+			e.writeVarU32(sub, f.Index)
+			e.writeString(sub, f.Name)
+		}
+		e.writeByte(w, nameFunctionID)
+		e.writeBlob(w, sub.Bytes())
+	}
+
+	n = 0
+	for _, f := range s.Funcs {
+		if len(f.Locals) > 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		sub.Reset()
+		e.writeVarU32(sub, n)
+		for _, f := range s.Funcs {
+			if len(f.Locals) == 0 {
+				continue
+			}
+			e.writeVarU32(sub, f.Index)
+			e.writeVarU32(sub, uint32(len(f.Locals)))
+			for _, l := range f.Locals {
+				e.writeVarU32(sub, l.Index)
+				e.writeString(sub, l.Name)
+			}
+		}
+		e.writeByte(w, nameLocalID)
+		e.writeBlob(w, sub.Bytes())
+	}
+
+	for _, sub := range s.Subsections {
+		e.writeByte(w, sub.ID)
+		e.writeBlob(w, sub.Data)
+	}
+}
+
+func (e *encoder) writeTypeSection(w io.Writer, s TypeSection) {
+	e.writeVarU32(w, uint32(len(s.Types)))
+	for _, ft := range s.Types {
+		e.writeFuncType(w, ft)
+	}
+}
+
+func (e *encoder) writeFuncType(w io.Writer, ft FuncType) {
+	e.writeByte(w, Op_func)
+	e.writeVarU32(w, uint32(len(ft.Params)))
+	for _, vt := range ft.Params {
+		e.writeValueType(w, vt)
+	}
+	e.writeVarU32(w, uint32(len(ft.Results)))
+	for _, vt := range ft.Results {
+		e.writeValueType(w, vt)
+	}
+}
+
+func (e *encoder) writeValueType(w io.Writer, vt ValueType) {
+	e.writeByte(w, byte(vt))
+}
+
+func (e *encoder) writeImportSection(w io.Writer, s ImportSection) {
+	e.writeVarU32(w, uint32(len(s.Imports)))
+	for _, ie := range s.Imports {
+		e.writeImportEntry(w, ie)
+	}
+}
+
+func (e *encoder) writeImportEntry(w io.Writer, ie ImportEntry) {
+	e.writeString(w, ie.Module)
+	e.writeString(w, ie.Field)
+	e.writeByte(w, byte(ie.Kind))
+	switch typ := ie.Type.(type) {
+	case uint32:
+		e.writeVarU32(w, typ)
+	case TableType:
+		e.writeTableType(w, typ)
+	case MemoryType:
+		e.writeMemoryType(w, typ)
+	case GlobalType:
+		e.writeGlobalType(w, typ)
+	case TagType:
+		e.writeTagType(w, typ)
+	default:
+		e.err = fmt.Errorf("wasm: invalid type %T for import %q.%q", ie.Type, ie.Module, ie.Field)
+	}
+}
+
+func (e *encoder) writeTableType(w io.Writer, tt TableType) {
+	e.writeByte(w, byte(tt.ElemType))
+	e.writeResizableLimits(w, tt.Limits)
+}
+
+func (e *encoder) writeResizableLimits(w io.Writer, rl ResizableLimits) {
+	e.writeVarU32(w, rl.Flags)
+	if rl.Flags&LimitsI64 == 0 && (rl.Initial > 0xffffffff || rl.Maximum > 0xffffffff) {
+		if e.err == nil {
+			e.err = fmt.Errorf("wasm: limits {min %d, max %d} overflow 32 bits", rl.Initial, rl.Maximum)
+		}
+		return
+	}
+	e.writeVarU64(w, rl.Initial)
+	if rl.Flags&LimitsMax != 0 {
+		e.writeVarU64(w, rl.Maximum)
+	}
+}
+
+func (e *encoder) writeMemoryType(w io.Writer, mt MemoryType) {
+	e.writeResizableLimits(w, mt.Limits)
+}
+
+func (e *encoder) writeGlobalType(w io.Writer, gt GlobalType) {
+	e.writeValueType(w, gt.ContentType)
+	e.writeVarU1(w, uint32(gt.Mutability))
+}
+
+func (e *encoder) writeTagType(w io.Writer, tt TagType) {
+	e.writeByte(w, tt.Attribute)
+	e.writeVarU32(w, tt.Type)
+}
+
+func (e *encoder) writeFunctionSection(w io.Writer, s FunctionSection) {
+	e.writeVarU32(w, uint32(len(s.Types)))
+	for _, idx := range s.Types {
+		e.writeVarU32(w, idx)
+	}
+}
+
+func (e *encoder) writeTableSection(w io.Writer, s TableSection) {
+	e.writeVarU32(w, uint32(len(s.Tables)))
+	for _, tt := range s.Tables {
+		e.writeTableType(w, tt)
+	}
+}
+
+func (e *encoder) writeMemorySection(w io.Writer, s MemorySection) {
+	e.writeVarU32(w, uint32(len(s.Memories)))
+	for _, mt := range s.Memories {
+		e.writeMemoryType(w, mt)
+	}
+}
+
+func (e *encoder) writeGlobalSection(w io.Writer, s GlobalSection) {
+	e.writeVarU32(w, uint32(len(s.Globals)))
+	for _, gv := range s.Globals {
+		e.writeGlobalType(w, gv.Type)
+		e.writeInitExpr(w, gv.Init)
+	}
+}
+
+func (e *encoder) writeInitExpr(w io.Writer, ie InitExpr) {
+	e.write(w, ie.Expr)
+	e.writeByte(w, Op_end)
+}
+
+func (e *encoder) writeExportSection(w io.Writer, s ExportSection) {
+	e.writeVarU32(w, uint32(len(s.Exports)))
+	for _, ee := range s.Exports {
+		e.writeString(w, ee.Field)
+		e.writeByte(w, byte(ee.Kind))
+		e.writeVarU32(w, ee.Index)
+	}
+}
+
+func (e *encoder) writeElementSection(w io.Writer, s ElementSection) {
+	e.writeVarU32(w, uint32(len(s.Elements)))
+	for _, es := range s.Elements {
+		flags := es.Flags
+		if es.isActive() && es.Index != 0 {
+			// the index of tables other than 0 must be explicit.
+			flags |= ElemExplicit
+		}
+		e.writeVarU32(w, flags)
+		if flags&(ElemPassive|ElemExplicit) == ElemExplicit {
+			e.writeVarU32(w, es.Index)
+		}
+		if es.isActive() {
+			e.writeInitExpr(w, es.Offset)
+		}
+		switch {
+		case flags == 0 || flags == ElemExprs:
+		case flags&ElemExprs != 0:
+			e.writeByte(w, byte(es.elemType()))
+		default:
+			e.writeByte(w, elemKindFunc)
+		}
+		if flags&ElemExprs != 0 {
+			e.writeVarU32(w, uint32(len(es.Exprs)))
+			for _, expr := range es.Exprs {
+				e.writeInitExpr(w, expr)
+			}
+			continue
+		}
+		e.writeVarU32(w, uint32(len(es.Elems)))
+		for _, idx := range es.Elems {
+			e.writeVarU32(w, idx)
+		}
+	}
+}
+
+func (e *encoder) writeCodeSection(w io.Writer, s CodeSection) {
+	e.writeVarU32(w, uint32(len(s.Bodies)))
+	body := new(bytes.Buffer)
+	for _, fb := range s.Bodies {
+		body.Reset()
+		e.writeFunctionBody(body, fb)
+		if e.raw != nil {
+			canonical := appendUvarint(nil, uint64(body.Len()))
+			canonical = append(canonical, body.Bytes()...)
+			if orig, ok := e.raw.body(canonical); ok {
+				e.write(w, orig)
+				continue
+			}
+			e.write(w, appendPaddedUvarint(e.buf[:0], uint64(body.Len()), e.raw.raw.width))
+			e.write(w, body.Bytes())
+			continue
+		}
+		e.writeBlob(w, body.Bytes())
+	}
+}
+
+// writeFunctionBody writes the locals and code of a function body.
+// The size of the body is written by the caller.
+func (e *encoder) writeFunctionBody(w io.Writer, fb FunctionBody) {
+	e.writeVarU32(w, uint32(len(fb.Locals)))
+	for _, le := range fb.Locals {
+		e.writeVarU32(w, le.Count)
+		e.writeValueType(w, le.Type)
+	}
+	e.write(w, fb.Code.Code)
+	e.writeByte(w, Op_end)
+}
+
+func (e *encoder) writeDataSection(w io.Writer, s DataSection) {
+	e.writeVarU32(w, uint32(len(s.Segments)))
+	for _, ds := range s.Segments {
+		flags := ds.Flags
+		if flags == 0 && ds.Index != 0 {
+			// the index of memories other than 0 must be explicit.
+			flags = DataExplicit
+		}
+		e.writeVarU32(w, flags)
+		if flags == DataExplicit {
+			e.writeVarU32(w, ds.Index)
+		}
+		if flags != DataPassive {
+			e.writeInitExpr(w, ds.Offset)
+		}
+		e.writeBlob(w, ds.Data)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, fname := range []string{
+		"testdata/empty.wasm",
+		"testdata/add.wasm",
+		"testdata/hello.wasm",
+	} {
+		t.Run(fname, func(t *testing.T) {
+			testRoundTrip(t, fname)
+		})
+	}
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	raw, err := os.ReadFile("testdata/add.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := wasm.Encode(&mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, out) {
+		t.Fatalf("encoding differs:\ngot= %x\nwant=%x", out, raw)
+	}
+}
+
+func TestEncodeInvalidVarU1(t *testing.T) {
+	b := wasm.NewBuilder()
+	b.Global(wasm.GlobalType{ContentType: wasm.I32, Mutability: 2}, wasm.ConstI32(0))
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wasm.Encode(m)
+	if err == nil || !strings.Contains(err.Error(), "invalid varuint1 value (2)") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
+
+func TestEncodeSectionOrder(t *testing.T) {
+	mod, err := wasm.Open("testdata/add.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reverse the sections: the encoder must restore their order.
+	var secs []wasm.Section
+	for i := len(mod.Sections) - 1; i >= 0; i-- {
+		secs = append(secs, mod.Sections[i])
+	}
+	want := []wasm.SectionID{wasm.TypeID, wasm.FunctionID, wasm.ExportID, wasm.CodeID}
+	mod.Sections = secs
+
+	raw, err := wasm.Encode(&mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Sections) != len(want) {
+		t.Fatalf("got %d sections, want %d", len(got.Sections), len(want))
+	}
+	for i, s := range got.Sections {
+		if s.ID() != want[i] {
+			t.Fatalf("section[%d]: got id=%d, want=%d", i, s.ID(), want[i])
+		}
+	}
+
+	mod.Sections = append(mod.Sections, mod.Sections[0])
+	_, err = wasm.Encode(&mod)
+	if err == nil {
+		t.Fatalf("expected an error for duplicate sections")
+	}
+}
+
+func TestEncodeCustomSections(t *testing.T) {
+	mod, err := wasm.Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := mod.Sections[len(mod.Sections)-1]
+	if _, ok := last.(wasm.NameSection); !ok {
+		t.Fatalf("last section is %T, want a wasm.NameSection", last)
+	}
+
+	// move the name section right after the type section.
+	secs := append([]wasm.Section{mod.Sections[0], last}, mod.Sections[1:len(mod.Sections)-1]...)
+	mod.Sections = secs
+	raw, err := wasm.Encode(&mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Sections, secs) {
+		t.Fatalf("custom section not kept in position")
+	}
+}
+
+// TestEncodeCorpus round-trips modules produced by the Go toolchain.
+func TestEncodeCorpus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping corpus test in short mode")
+	}
+
+	for _, tc := range []struct {
+		goos string
+		src  string
+	}{
+		{"js", "package main\nfunc main() {}\n"},
+		{"wasip1", "package main\nfunc main() {}\n"},
+		{"wasip1", "package main\nimport \"fmt\"\nfunc main() { fmt.Println(\"hello\") }\n"},
+	} {
+		fname := buildGoWasm(t, tc.goos, tc.src)
+		t.Run(tc.goos, func(t *testing.T) {
+			testRoundTrip(t, fname)
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, fname string) {
+	t.Helper()
+
+	want, err := wasm.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := wasm.Encode(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := wasm.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Header != want.Header {
+		t.Fatalf("headers differ:\ngot= %v\nwant=%v", got.Header, want.Header)
+	}
+	if len(got.Sections) != len(want.Sections) {
+		t.Fatalf("got %d sections, want %d", len(got.Sections), len(want.Sections))
+	}
+	for i := range got.Sections {
+		if !reflect.DeepEqual(got.Sections[i], want.Sections[i]) {
+			t.Fatalf("section[%d] (id=%d) differs", i, want.Sections[i].ID())
+		}
+	}
+
+	// encoding must be stable.
+	raw2, err := wasm.Encode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, raw2) {
+		t.Fatalf("re-encoding is not stable")
+	}
+}
+
+// buildGoWasm compiles the provided Go program to a wasm module.
+func buildGoWasm(t *testing.T, goos, src string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "main.wasm")
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-o", out, "main.go")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=wasm", "GO111MODULE=off", "GOFLAGS=")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("could not build wasm module: %v\n%s", err, msg)
+	}
+	return out
+}
+
+func TestEncodeKeepRaw(t *testing.T) {
+	fnames := []string{
+		"testdata/empty.wasm",
+		"testdata/add.wasm",
+		"testdata/hello.wasm",
+	}
+	if !testing.Short() {
+		fnames = append(fnames,
+			buildGoWasm(t, "js", "package main\nfunc main() {}\n"),
+			buildGoWasm(t, "wasip1", "package main\nimport \"fmt\"\nfunc main() { fmt.Println(\"hello\") }\n"),
+		)
+	}
+
+	for _, fname := range fnames {
+		raw, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod, err := wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatalf("%s: %+v", fname, err)
+		}
+		out, err := wasm.Encode(&mod)
+		if err != nil {
+			t.Fatalf("%s: %+v", fname, err)
+		}
+		if !bytes.Equal(raw, out) {
+			t.Fatalf("%s: encoding is not byte-exact", fname)
+		}
+	}
+}
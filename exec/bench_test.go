@@ -0,0 +1,75 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func TestBenchModule(t *testing.T) {
+	m := wasmtest.Build(t, wasmtest.NewModule, wasmtest.Sieve)
+	for _, engine := range engines {
+		inst, err := exec.InstantiateWithOptions(context.Background(), m, exec.InstantiateOptions{Engine: engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := inst.Call(context.Background(), "sieve", 65536)
+		if err != nil {
+			t.Fatalf("%v: %+v", engine, err)
+		}
+		if got[0] != 6542 {
+			t.Fatalf("%v: got=%d primes, want=6542", engine, got[0])
+		}
+	}
+}
+
+func BenchmarkEngines(b *testing.B) {
+	m := wasmtest.Build(b, wasmtest.NewModule, wasmtest.Sieve)
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name string
+		args []uint64
+	}{
+		{"fac", []uint64{20}},
+		{"sum", []uint64{1000}},
+		{"fib", []uint64{1000}},
+		{"sieve", []uint64{10000}},
+	} {
+		for _, engine := range append(engines, exec.EngineJIT) {
+			b.Run(bc.name+"/"+engine.String(), func(b *testing.B) {
+				inst, err := compileEngine(b, m, engine).Instantiate(ctx, exec.InstantiateOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				f := inst.Function(bc.name)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := f.Call(ctx, bc.args...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	m := wasmtest.Build(b, wasmtest.NewModule, wasmtest.Sieve)
+	for _, engine := range append(engines, exec.EngineJIT) {
+		b.Run(engine.String(), func(b *testing.B) {
+			compileEngine(b, m, engine)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := exec.CompileWithOptions(m, exec.CompileOptions{Engine: engine}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
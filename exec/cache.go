@@ -0,0 +1,315 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/sbinet/wasm"
+)
+
+// cacheVersion is the version of the compiled form of the modules stored
+// by Cache. It must be incremented whenever the internal representation,
+// the machine code or their encoding change.
+const cacheVersion = 1
+
+// cacheMagic starts the files of a cache.
+const cacheMagic = "\x00wasmexec"
+
+// Cache is an on-disk cache of compiled modules, saving the translation of
+// their function bodies when they are compiled again, possibly by another
+// process.
+//
+// The compiled modules are stored in a directory, in files named after a
+// SHA-256 hash of the encoded module, of the version of the runtime and of
+// the compilation options. The files hold a checksum of their content:
+// files that are corrupted are ignored and replaced. They are written to a
+// temporary file renamed once complete, so that several processes may
+// share the directory.
+//
+// The directory must only be writable by trusted users: with EngineJIT,
+// the machine code it holds is executed as is.
+type Cache struct {
+	dir string
+}
+
+// NewCache returns a cache storing the compiled modules in the directory
+// dir, which is created if needed.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("exec: could not create the cache: %w", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Compile is like CompileWithOptions, but loads the translated function
+// bodies from the cache if the module was already compiled with the same
+// options, and stores them in the cache otherwise.
+// Errors storing the compiled module are not reported: the module is
+// compiled again by the next call.
+func (c *Cache) Compile(m *wasm.Module, opts CompileOptions) (*CompiledModule, error) {
+	if err := checkEngine(opts.Engine); err != nil {
+		return nil, err
+	}
+	raw, err := wasm.Encode(m)
+	if err != nil {
+		return nil, fmt.Errorf("exec: could not encode the module: %w", err)
+	}
+	key := cacheKey(raw, opts)
+	name := filepath.Join(c.dir, hex.EncodeToString(key[:]))
+
+	// the module is validated and translated only if it is not cached.
+	if data, err := os.ReadFile(name); err == nil {
+		if cm, err := loadCompiled(m, opts, key, data); err == nil {
+			return cm, nil
+		}
+	}
+	cm, err := CompileWithOptions(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.store(name, cm.encode(key))
+	return cm, nil
+}
+
+// cacheKey returns the key of the module encoded as raw, compiled with the
+// options opts.
+func cacheKey(raw []byte, opts CompileOptions) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %d %s %s/%s %v %v\n", cacheMagic, cacheVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH, compileFeatures, opts.Engine)
+	h.Write(raw)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// store writes the file name of the cache atomically.
+func (c *Cache) store(name string, data []byte) {
+	f, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// encode encodes the translated function bodies of c, in a file of the
+// cache of key key: the magic, the key, the SHA-256 checksum of the
+// payload, then the payload.
+func (c *CompiledModule) encode(key [sha256.Size]byte) []byte {
+	var p []byte
+	p = binary.AppendUvarint(p, uint64(c.engine))
+	p = binary.AppendUvarint(p, uint64(len(c.bodies)))
+	switch c.engine {
+	case EngineIR:
+		for _, b := range c.bodies {
+			p = b.ir.encode(p)
+		}
+	case EngineJIT:
+		p = encodeJIT(p, c)
+	}
+
+	sum := sha256.Sum256(p)
+	buf := make([]byte, 0, len(cacheMagic)+len(key)+len(sum)+len(p))
+	buf = append(buf, cacheMagic...)
+	buf = append(buf, key[:]...)
+	buf = append(buf, sum[:]...)
+	return append(buf, p...)
+}
+
+// loadCompiled returns the module m compiled with the options opts, whose
+// function bodies are translated in the file data of the cache of key key.
+func loadCompiled(m *wasm.Module, opts CompileOptions, key [sha256.Size]byte, data []byte) (*CompiledModule, error) {
+	hdr := len(cacheMagic) + 2*sha256.Size
+	if len(data) < hdr || string(data[:len(cacheMagic)]) != cacheMagic {
+		return nil, errors.New("exec: invalid cache file")
+	}
+	data = data[len(cacheMagic):]
+	sum := sha256.Sum256(data[2*sha256.Size:])
+	if !bytes.Equal(data[:sha256.Size], key[:]) || !bytes.Equal(data[sha256.Size:2*sha256.Size], sum[:]) {
+		return nil, errors.New("exec: corrupted cache file")
+	}
+
+	// the module was validated when it was stored.
+	c, err := newCompiledModule(m, opts.Engine)
+	if err != nil {
+		return nil, err
+	}
+	r := &cacheReader{buf: data[2*sha256.Size:]}
+	if Engine(r.uvarint()) != c.engine || r.uvarint() != uint64(len(c.bodies)) {
+		return nil, errors.New("exec: invalid cache file")
+	}
+	switch c.engine {
+	case EngineIR:
+		for _, b := range c.bodies {
+			b.ir = r.irCode()
+		}
+	case EngineJIT:
+		if err := decodeJIT(r, c); err != nil {
+			return nil, err
+		}
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		return nil, errors.New("exec: invalid cache file")
+	}
+	return c, nil
+}
+
+// encode appends the encoding of the internal representation ir to p.
+func (ir *irCode) encode(p []byte) []byte {
+	p = binary.AppendUvarint(p, uint64(ir.nslots))
+	p = binary.AppendUvarint(p, uint64(len(ir.code)))
+	for _, in := range ir.code {
+		p = binary.AppendUvarint(p, uint64(in.op))
+		for _, v := range [...]int32{in.a, in.b, in.c, in.d, in.pos} {
+			p = binary.AppendVarint(p, int64(v))
+		}
+		p = binary.AppendUvarint(p, in.imm)
+		p = binary.AppendUvarint(p, uint64(in.f0))
+		p = binary.AppendUvarint(p, uint64(in.f1))
+	}
+	p = binary.AppendUvarint(p, uint64(len(ir.ops)))
+	for _, op := range ir.ops {
+		p = append(p, byte(op))
+	}
+	p = binary.AppendUvarint(p, uint64(len(ir.tables)))
+	for _, t := range ir.tables {
+		p = binary.AppendUvarint(p, uint64(len(t)))
+		for _, e := range t {
+			p = binary.AppendVarint(p, int64(e.ip))
+			p = binary.AppendVarint(p, int64(e.dst))
+			move := byte(0)
+			if e.move {
+				move = 1
+			}
+			p = append(p, move)
+		}
+	}
+	return p
+}
+
+// cacheReader decodes the payload of a file of the cache.
+// Its first error is sticky: the values read afterwards are zero.
+type cacheReader struct {
+	buf []byte
+	err error
+}
+
+func (r *cacheReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("exec: invalid cache file")
+	}
+	r.buf = nil
+}
+
+func (r *cacheReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *cacheReader) varint() int64 {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *cacheReader) int32() int32 {
+	v := r.varint()
+	if int64(int32(v)) != v {
+		r.fail()
+	}
+	return int32(v)
+}
+
+func (r *cacheReader) uint32() uint32 {
+	v := r.uvarint()
+	if uint64(uint32(v)) != v {
+		r.fail()
+	}
+	return uint32(v)
+}
+
+// count reads a number of elements, encoded in at least size bytes each.
+func (r *cacheReader) count(size int) int {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)/size) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *cacheReader) byte() byte {
+	if len(r.buf) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+// bytes reads n bytes.
+func (r *cacheReader) bytes(n int) []byte {
+	if n > len(r.buf) {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// irCode reads an internal representation encoded by irCode.encode.
+func (r *cacheReader) irCode() *irCode {
+	ir := &irCode{nslots: int(r.uint32())}
+	ir.code = make([]irInstr, r.count(9))
+	for i := range ir.code {
+		in := &ir.code[i]
+		in.op = irOp(r.uint32())
+		in.a, in.b, in.c, in.d, in.pos = r.int32(), r.int32(), r.int32(), r.int32(), r.int32()
+		in.imm = r.uvarint()
+		in.f0, in.f1 = r.uint32(), r.uint32()
+	}
+	for _, op := range r.bytes(r.count(1)) {
+		ir.ops = append(ir.ops, wasm.Opcode(op))
+	}
+	ir.tables = make([][]irTarget, r.count(1))
+	for i := range ir.tables {
+		t := make([]irTarget, r.count(3))
+		for j := range t {
+			t[j].ip = r.int32()
+			t[j].dst = r.int32()
+			t[j].move = r.byte() != 0
+		}
+		ir.tables[i] = t
+	}
+	return ir
+}
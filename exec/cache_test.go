@@ -0,0 +1,180 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+// cacheFiles returns the files of the cache directory dir.
+func cacheFiles(t *testing.T, dir string) []os.FileInfo {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "tmp-") {
+			t.Fatalf("temporary file %s left in the cache", e.Name())
+		}
+		fi, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fi)
+	}
+	return files
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	m := wasmtest.Build(t, wasmtest.NewModule)
+
+	for _, engine := range []exec.Engine{exec.EngineIR, exec.EngineBytecode, exec.EngineJIT} {
+		t.Run(engine.String(), func(t *testing.T) {
+			want, err := exec.CompileWithOptions(m, exec.CompileOptions{Engine: engine})
+			if err != nil {
+				t.Skip(err)
+			}
+			ref, err := want.Instantiate(ctx, exec.InstantiateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(t.TempDir(), "cache")
+			cache, err := exec.NewCache(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// check compiles the module with the cache, and compares its
+			// instance to the reference one.
+			check := func() {
+				t.Helper()
+				c, err := cache.Compile(m, exec.CompileOptions{Engine: engine})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.Engine() != engine {
+					t.Fatalf("invalid engine: got=%v, want=%v", c.Engine(), engine)
+				}
+				inst, err := c.Instantiate(ctx, exec.InstantiateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, tc := range []struct {
+					name string
+					args []uint64
+				}{
+					{"fib", []uint64{90}},
+					{"fac", []uint64{20}},
+					{"switch", []uint64{2}},
+					{"dispatch", []uint64{1, 7, 3}},
+					{"dispatch", []uint64{2, 0, 0}},
+					{"load", []uint64{0xffffffff}},
+					{"trap", []uint64{1}},
+				} {
+					want, werr := ref.Call(ctx, tc.name, tc.args...)
+					got, err := inst.Call(ctx, tc.name, tc.args...)
+					if w, g := fmt.Sprintf("%+v", werr), fmt.Sprintf("%+v", err); w != g {
+						t.Fatalf("%s%v: invalid error:\ngot:  %s\nwant: %s", tc.name, tc.args, g, w)
+					}
+					if !reflect.DeepEqual(got, want) {
+						t.Fatalf("%s%v: got=%#x, want=%#x", tc.name, tc.args, got, want)
+					}
+				}
+			}
+
+			check()
+			files := cacheFiles(t, dir)
+			if len(files) != 1 {
+				t.Fatalf("invalid number of files: %d", len(files))
+			}
+			name := filepath.Join(dir, files[0].Name())
+
+			// the module is loaded from the cache, which is left untouched.
+			check()
+			if fi := cacheFiles(t, dir); len(fi) != 1 || !os.SameFile(fi[0], files[0]) {
+				t.Fatalf("the cache file was replaced")
+			}
+
+			// corrupted files are replaced.
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 1
+			if err := os.WriteFile(name, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			files = cacheFiles(t, dir)
+			check()
+			if fi := cacheFiles(t, dir); len(fi) != 1 || os.SameFile(fi[0], files[0]) {
+				t.Fatalf("the corrupted cache file was not replaced")
+			}
+			if err := os.WriteFile(name, data[:10], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			check()
+
+			// concurrent compilations share the cache.
+			os.Remove(name)
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					other, err := exec.NewCache(dir)
+					if err == nil {
+						_, err = other.Compile(m, exec.CompileOptions{Engine: engine})
+					}
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if files := cacheFiles(t, dir); len(files) != 1 {
+				t.Fatalf("invalid number of files: %d", len(files))
+			}
+			check()
+		})
+	}
+}
+
+func TestCacheInvalid(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := exec.NewCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := wasm.NewBuilder()
+	f := b.Func("f", wasm.FuncType{Results: i32})
+	f.SetBody(nil, []byte{byte(wasm.Op_i64_const), 0})
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Compile(m, exec.CompileOptions{}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, err := cache.Compile(m, exec.CompileOptions{Engine: 42}); err == nil || !strings.Contains(err.Error(), "invalid engine") {
+		t.Fatalf("invalid error: %v", err)
+	}
+	if files := cacheFiles(t, dir); len(files) != 0 {
+		t.Fatalf("invalid module stored in the cache")
+	}
+}
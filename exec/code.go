@@ -0,0 +1,102 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// body is a function body prepared for interpretation.
+type body struct {
+	code    []byte         // instructions, including the final end
+	nlocals int            // number of locals, parameters excluded
+	height  int            // bound of the height of the operand stack of the function
+	targets map[int]target // targets of the structured instructions, by offset
+	ir      *irCode        // internal representation, with EngineIR
+	jit     *jitFunc       // machine code, with EngineJIT
+}
+
+// target holds the offsets of the else and end instructions matching a
+// block, loop or if instruction, or an else instruction.
+type target struct {
+	els int // offset of the else instruction, or -1
+	end int // offset of the end instruction
+}
+
+// newBody prepares the body fb of a function of type ft for interpretation.
+func newBody(ft wasm.FuncType, fb wasm.FunctionBody) (*body, error) {
+	b := &body{
+		code:    append(fb.Code.Code[:len(fb.Code.Code):len(fb.Code.Code)], fb.Code.End),
+		targets: make(map[int]target),
+	}
+	for _, le := range fb.Locals {
+		b.nlocals += int(le.Count)
+	}
+
+	var (
+		code  = b.code
+		open  []int // offsets of the open structured instructions
+		ifs   = make(map[int]int)
+		pc    = 0
+		start int
+	)
+	for pc < len(code) {
+		start = pc
+		op := wasm.Opcode(code[pc])
+		pc++
+		// MVP instructions push at most one value.
+		b.height++
+		switch op {
+		case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+			open = append(open, start)
+			b.targets[start] = target{els: -1}
+		case wasm.Op_else:
+			if len(open) == 0 {
+				return nil, fmt.Errorf("offset %#x: else without matching if", start)
+			}
+			ifs[start] = open[len(open)-1]
+			t := b.targets[open[len(open)-1]]
+			t.els = start
+			b.targets[open[len(open)-1]] = t
+		case wasm.Op_end:
+			if len(open) == 0 {
+				continue
+			}
+			t := b.targets[open[len(open)-1]]
+			t.end = start
+			b.targets[open[len(open)-1]] = t
+			open = open[:len(open)-1]
+		}
+		var err error
+		pc, err = instr.Skip(op, code, pc)
+		if err != nil {
+			return nil, fmt.Errorf("offset %#x: %w", start, err)
+		}
+	}
+	if len(open) != 0 {
+		return nil, fmt.Errorf("unterminated block")
+	}
+	for els, blk := range ifs {
+		b.targets[els] = target{els: els, end: b.targets[blk].end}
+	}
+	return b, nil
+}
+
+// blockType decodes the block type at offset pc and returns the number of
+// parameters and results of the block.
+func (inst *Instance) blockType(code []byte, pc int) (params, results, next int) {
+	bt, next := instr.ReadS64(code, pc)
+	switch {
+	case bt == -0x40:
+		return 0, 0, next
+	case bt < 0:
+		return 0, 1, next
+	}
+	ft := inst.types[bt]
+	return len(ft.Params), len(ft.Results), next
+}
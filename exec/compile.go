@@ -0,0 +1,318 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/sbinet/wasm"
+)
+
+// CompiledModule is a module validated and prepared for instantiation.
+//
+// A CompiledModule is immutable and may be instantiated concurrently by
+// multiple goroutines. Its instances share its prepared code.
+type CompiledModule struct {
+	module *wasm.Module
+	engine Engine
+
+	types   []wasm.FuncType
+	ftypes  []wasm.FuncType // types of the functions, imported ones first
+	imports []wasm.ImportEntry
+	funcs   []uint32 // type indices of the defined functions
+	bodies  []*body  // bodies of the defined functions
+	tables  []wasm.TableType
+	mems    []wasm.MemoryType
+	globals []wasm.GlobalVariable
+	exports map[string]wasm.ExportEntry
+	names   map[uint32]string
+	start   *uint32
+	elems   []wasm.ElemSegment
+	data    []wasm.DataSegment
+}
+
+// Engine selects how the functions of a module are executed.
+type Engine int
+
+const (
+	// EngineIR translates the function bodies into a compact internal
+	// representation, operating on the slots of the frames instead of
+	// an operand stack, with precomputed branch targets and fused
+	// instructions, and interprets it.
+	EngineIR Engine = iota
+
+	// EngineBytecode interprets the function bodies as they are encoded
+	// in the module. It is slower than EngineIR, but has no translation
+	// cost.
+	EngineBytecode
+
+	// EngineJIT compiles the function bodies to machine code, executed
+	// natively. It is only supported on linux/amd64, and does not support
+	// fuel metering.
+	EngineJIT
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineIR:
+		return "ir"
+	case EngineBytecode:
+		return "bytecode"
+	case EngineJIT:
+		return "jit"
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+// CompileOptions configures the compilation of a module.
+type CompileOptions struct {
+	// Engine is the engine executing the functions of the module,
+	// EngineIR by default.
+	Engine Engine
+}
+
+// Compile validates the module m and prepares it for instantiation.
+// m must not be modified afterwards.
+func Compile(m *wasm.Module) (*CompiledModule, error) {
+	return CompileWithOptions(m, CompileOptions{})
+}
+
+// CompileWithOptions is like Compile but with configurable options.
+func CompileWithOptions(m *wasm.Module, opts CompileOptions) (*CompiledModule, error) {
+	if err := checkEngine(opts.Engine); err != nil {
+		return nil, err
+	}
+	if err := wasm.Validate(m, compileFeatures); err != nil {
+		return nil, err
+	}
+	c, err := newCompiledModule(m, opts.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.translate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compileFeatures is the set of features of the modules compiled by
+// CompileWithOptions.
+const compileFeatures = wasm.FeaturesMVP
+
+// checkEngine checks that the engine e is supported.
+func checkEngine(e Engine) error {
+	switch e {
+	case EngineIR, EngineBytecode:
+	case EngineJIT:
+		if !jitSupported {
+			return fmt.Errorf("exec: the %v engine is not supported on %s/%s", e, runtime.GOOS, runtime.GOARCH)
+		}
+	default:
+		return fmt.Errorf("exec: invalid engine %v", e)
+	}
+	return nil
+}
+
+// newCompiledModule prepares the validated module m for the engine,
+// without translating its function bodies.
+func newCompiledModule(m *wasm.Module, engine Engine) (*CompiledModule, error) {
+	c := &CompiledModule{
+		module:  m,
+		engine:  engine,
+		exports: make(map[string]wasm.ExportEntry),
+		names:   make(map[uint32]string),
+	}
+	for _, sec := range m.Sections {
+		switch s := sec.(type) {
+		case wasm.TypeSection:
+			c.types = s.Types
+		case wasm.ImportSection:
+			c.imports = s.Imports
+			for _, e := range s.Imports {
+				if e.Kind == wasm.FunctionKind {
+					c.ftypes = append(c.ftypes, c.types[e.Type.(uint32)])
+				}
+			}
+		case wasm.FunctionSection:
+			c.funcs = s.Types
+			for _, t := range s.Types {
+				c.ftypes = append(c.ftypes, c.types[t])
+			}
+		case wasm.TableSection:
+			c.tables = s.Tables
+		case wasm.MemorySection:
+			c.mems = s.Memories
+		case wasm.GlobalSection:
+			c.globals = s.Globals
+		case wasm.ExportSection:
+			for _, e := range s.Exports {
+				c.exports[e.Field] = e
+			}
+		case wasm.StartSection:
+			idx := s.Index
+			c.start = &idx
+		case wasm.ElementSection:
+			c.elems = s.Elements
+		case wasm.CodeSection:
+			nimports := len(c.ftypes) - len(c.funcs)
+			for i, fb := range s.Bodies {
+				b, err := newBody(c.types[c.funcs[i]], fb)
+				if err != nil {
+					return nil, fmt.Errorf("exec: function %d: %w", nimports+i, err)
+				}
+				c.bodies = append(c.bodies, b)
+			}
+		case wasm.DataSection:
+			c.data = s.Segments
+		case wasm.NameSection:
+			for _, fn := range s.Funcs {
+				c.names[fn.Index] = fn.Name
+			}
+		}
+	}
+	return c, nil
+}
+
+// translate translates the function bodies of c for its engine.
+func (c *CompiledModule) translate() error {
+	switch c.engine {
+	case EngineIR:
+		nimports := len(c.ftypes) - len(c.funcs)
+		for i, b := range c.bodies {
+			var err error
+			b.ir, err = translate(c.types, c.ftypes, c.types[c.funcs[i]], b)
+			if err != nil {
+				return fmt.Errorf("exec: function %d: %w", nimports+i, err)
+			}
+		}
+	case EngineJIT:
+		return compileJIT(c)
+	}
+	return nil
+}
+
+// Module returns the compiled module.
+func (c *CompiledModule) Module() *wasm.Module { return c.module }
+
+// Engine returns the engine executing the functions of the module.
+func (c *CompiledModule) Engine() Engine { return c.engine }
+
+// Instantiate instantiates the compiled module, then runs its start
+// function, if any.
+func (c *CompiledModule) Instantiate(ctx context.Context, opts InstantiateOptions) (*Instance, error) {
+	return c.instantiate(ctx, opts, nil)
+}
+
+func (c *CompiledModule) instantiate(ctx context.Context, opts InstantiateOptions, store *Store) (*Instance, error) {
+	inst := &Instance{
+		compiled: c,
+		module:   c.module,
+		types:    c.types,
+		exports:  c.exports,
+		names:    c.names,
+
+		callFuel:  opts.CallFuel,
+		resumable: opts.Resumable,
+		maxDepth:  opts.MaxCallDepth,
+		maxStack:  opts.MaxStackSize,
+	}
+	if inst.maxDepth <= 0 {
+		inst.maxDepth = DefaultMaxCallDepth
+	}
+	if inst.maxStack <= 0 {
+		inst.maxStack = DefaultMaxStackSize
+	}
+	if opts.Metering {
+		if c.engine == EngineJIT {
+			return nil, fmt.Errorf("exec: fuel metering is not supported by the %v engine", c.engine)
+		}
+		inst.meter = &meter{fuel: opts.Fuel, costs: opts.Costs}
+		if inst.meter.costs == nil {
+			inst.meter.costs = DefaultCosts()
+		}
+	}
+
+	for _, e := range c.imports {
+		x, err := inst.resolve(e, store, opts.Imports)
+		if err != nil {
+			return nil, err
+		}
+		switch x := x.(type) {
+		case *Function:
+			inst.funcs = append(inst.funcs, x)
+		case *table:
+			inst.tables = append(inst.tables, x)
+		case *Memory:
+			inst.mems = append(inst.mems, x)
+		case *Global:
+			inst.globals = append(inst.globals, x)
+		}
+	}
+	for i, t := range c.funcs {
+		inst.funcs = append(inst.funcs, &Function{
+			typ:  c.types[t],
+			inst: inst,
+			idx:  uint32(len(inst.funcs)),
+			body: c.bodies[i],
+		})
+	}
+	for _, tt := range c.tables {
+		inst.tables = append(inst.tables, newTable(tt))
+	}
+	for _, mt := range c.mems {
+		mem, err := NewMemoryWithOptions(mt, MemoryOptions{MaxPages: opts.MaxMemoryPages, Engine: c.engine})
+		if err != nil {
+			return nil, err
+		}
+		inst.mems = append(inst.mems, mem)
+	}
+	if c.engine == EngineJIT && len(inst.mems) > 0 && inst.mems[0].res == nil {
+		// the machine code relies on the guard pages of the memory.
+		return nil, fmt.Errorf("exec: the %v engine requires a memory backed by guard pages, see MemoryOptions", c.engine)
+	}
+	for _, g := range c.globals {
+		inst.globals = append(inst.globals, &Global{
+			typ: g.Type,
+			val: inst.eval(g.Init),
+		})
+	}
+
+	if err := inst.init(ctx); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// init applies the element and data segments of the instance, then runs
+// its start function.
+func (inst *Instance) init(ctx context.Context) error {
+	c := inst.compiled
+	for i, es := range c.elems {
+		t := inst.tables[es.Index]
+		off := uint64(uint32(inst.eval(es.Offset)))
+		if off+uint64(len(es.Elems)) > uint64(len(t.elems)) {
+			return fmt.Errorf("exec: element segment %d: %w", i, newTrap(TrapOutOfBoundsTable))
+		}
+		for j, f := range es.Elems {
+			t.elems[off+uint64(j)] = inst.funcs[f]
+		}
+	}
+
+	for i, ds := range c.data {
+		mem := inst.mems[ds.Index]
+		if err := mem.Write(uint32(inst.eval(ds.Offset)), ds.Data); err != nil {
+			return fmt.Errorf("exec: data segment %d: %w", i, err)
+		}
+	}
+
+	if c.start != nil {
+		if _, err := inst.funcs[*c.start].Call(ctx); err != nil {
+			return fmt.Errorf("exec: start function: %w", err)
+		}
+	}
+	return nil
+}
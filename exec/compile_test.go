@@ -0,0 +1,134 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func compile(t *testing.T, mk func(b *wasm.Builder)) *exec.CompiledModule {
+	t.Helper()
+	c, err := exec.Compile(wasmtest.Build(t, mk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCompiledModule(t *testing.T) {
+	c := compile(t, wasmtest.NewModule)
+	if _, err := exec.Compile(&wasm.Module{Sections: []wasm.Section{wasm.FunctionSection{Types: []uint32{0}}}}); err == nil {
+		t.Fatalf("expected a validation error")
+	}
+
+	var (
+		ctx  = context.Background()
+		wg   sync.WaitGroup
+		errc = make(chan error, 16)
+	)
+	for i := 0; i < cap(errc); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				inst, err := c.Instantiate(ctx, exec.InstantiateOptions{})
+				if err != nil {
+					errc <- err
+					return
+				}
+				n := uint64(i*20 + j)
+				got, err := inst.Call(ctx, "sum", n)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if want := n * (n - 1) / 2; n > 0 && got[0] != want {
+					errc <- fmt.Errorf("sum(%d): got=%d, want=%d", n, got[0], want)
+					return
+				}
+				// instances do not share their state.
+				if got, err := inst.Call(ctx, "incr"); err != nil || got[0] != 43 {
+					errc <- fmt.Errorf("incr: got=%v, %v", got, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	c := compile(t, wasmtest.NewModule)
+	pool := exec.NewPool(c, exec.InstantiateOptions{Metering: true, Fuel: 1000})
+
+	inst, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fuel := inst.Fuel()
+	for _, call := range []struct {
+		name string
+		args []uint64
+	}{
+		{"incr", nil},
+		{"store", []uint64{16, 0xffff}},
+		{"grow", []uint64{1}},
+	} {
+		if _, err := inst.Call(ctx, call.name, call.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Put(inst)
+
+	// the instance is either reused, and reset, or a new one.
+	for i := 0; i < 3; i++ {
+		inst, err := pool.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := inst.Fuel(); got != fuel {
+			t.Fatalf("invalid fuel: got=%d, want=%d", got, fuel)
+		}
+		if got, err := inst.Call(ctx, "incr"); err != nil || got[0] != 43 {
+			t.Fatalf("incr: got=%v, %v", got, err)
+		}
+		if got, err := inst.Call(ctx, "load", 16); err != nil || got[0] != 'h' {
+			t.Fatalf("load: got=%v, %v", got, err)
+		}
+		if got, err := inst.Call(ctx, "grow", 0); err != nil || got[0] != 1<<16+1 {
+			t.Fatalf("grow: got=%#x, %v", got, err)
+		}
+		if got, err := inst.Call(ctx, "dispatch", 1, 7, 3); err != nil || got[0] != 4 {
+			t.Fatalf("dispatch: got=%v, %v", got, err)
+		}
+		if _, err := inst.Call(ctx, "store", 16, 0); err != nil {
+			t.Fatal(err)
+		}
+		pool.Put(inst)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic")
+		}
+	}()
+	other, err := exec.Instantiate(ctx, c.Module())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(other)
+}
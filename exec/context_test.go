@@ -0,0 +1,92 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func TestContext(t *testing.T) {
+	b := wasm.NewBuilder()
+	spin := b.Func("spin", wasm.FuncType{})
+	e := spin.Body()
+	loop := e.Loop()
+	e.Br(loop)
+	e.End()
+	e.End()
+	b.Export("spin", spin)
+
+	// ping and pong call each other until the call stack is exhausted.
+	ping := b.Func("ping", wasm.FuncType{Params: i32, Results: i32})
+	pong := b.Func("pong", wasm.FuncType{Params: i32, Results: i32})
+	ping.Body().LocalGet(0).I32Const(1).I32Add().Call(pong).End()
+	pong.Body().LocalGet(0).Call(ping).End()
+	b.Export("ping", ping)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := exec.Instantiate(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"spin", "spin"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		args := make([]uint64, len(inst.Function(name).Type().Params))
+
+		start := time.Now()
+		_, err := inst.Call(ctx, name, args...)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: invalid error: %v", name, err)
+		}
+		if !errors.Is(err, &exec.Trap{Kind: exec.TrapInterrupted}) {
+			t.Fatalf("%s: expected an interruption trap: %v", name, err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Fatalf("%s: call interrupted after %v", name, d)
+		}
+	}
+
+	// the engines are interrupted on the branch back to the loop.
+	const want = "exec: interrupted: context canceled in function 0 (spin) at offset 0x2"
+	for _, engine := range engines {
+		inst, err := exec.InstantiateWithOptions(context.Background(), m, exec.InstantiateOptions{
+			Engine: engine,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		_, err = inst.Call(ctx, "spin")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("%v: invalid error: %v", engine, err)
+		}
+		if err.Error() != want {
+			t.Fatalf("%v: invalid message:\ngot= %s\nwant=%s", engine, err, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// calls with a cancelled context do not start.
+	_, err = inst.Call(ctx, "ping", 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
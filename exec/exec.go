@@ -0,0 +1,285 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package exec implements an interpreter for WebAssembly modules.
+//
+// A decoded module is validated and instantiated with Instantiate: its
+// memories, tables and globals are allocated, its element and data
+// segments are applied and its start function is run. The exported
+// functions of the resulting Instance may then be called with Call, with
+// arguments and results encoded as uint64 values (see EncodeI32 and
+// friends), or with Invoke, with Go values.
+//
+// Modules instantiated many times are compiled once with Compile: the
+// resulting CompiledModule may be instantiated concurrently, and its
+// instances reused through a Pool.
+//
+// Imported functions are provided by the host, as Go functions declared in
+// the Imports of InstantiateWithOptions. Modules importing each other are
+// linked in a Store.
+//
+// Calls aborted by a trap fail with a *Trap error, holding the cause of
+// the trap and the wasm stack trace. Calls are also aborted, with a trap
+// wrapping the error of the context, when their context is cancelled.
+//
+// By default, function bodies are translated at compilation into a compact
+// internal representation, with precomputed branch targets and operands
+// addressing the slots of the frames, which is faster to interpret than
+// the wasm bytecode. The bytecode may also be interpreted directly, see
+// Engine.
+package exec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// Instance is an instance of a module.
+type Instance struct {
+	compiled *CompiledModule
+	module   *wasm.Module
+
+	types   []wasm.FuncType
+	funcs   []*Function
+	tables  []*table
+	mems    []*Memory
+	globals []*Global
+	exports map[string]wasm.ExportEntry
+	names   map[uint32]string // names of the functions, from the name section
+
+	meter     *meter   // fuel tank, or nil if fuel metering is disabled
+	callFuel  uint64   // fuel of each call from the host, or 0
+	resumable bool     // whether calls running out of fuel may be resumed
+	suspended *machine // last call that ran out of fuel
+	active    int      // number of calls in progress
+
+	maxDepth int // maximum depth of the call stack
+	maxStack int // maximum height of the operand stack
+	outer    struct {
+		frames int // frames of the calls into host functions in progress
+		stack  int // height of their operand stacks
+	}
+
+	jit *jitState // stacks of the calls to machine code, with EngineJIT
+}
+
+// Function is a function of an instance.
+type Function struct {
+	typ  wasm.FuncType
+	inst *Instance
+	idx  uint32 // index of the function in the module
+	body *body
+	host *HostFunction // implementation of an imported function
+}
+
+// Type returns the signature of the function.
+func (f *Function) Type() wasm.FuncType { return f.typ }
+
+// InstantiateOptions configures the instantiation of a module.
+type InstantiateOptions struct {
+	// Engine is the engine executing the functions of the module, when
+	// it is compiled by InstantiateWithOptions or Store.Instantiate.
+	// The engine of a CompiledModule is set by CompileWithOptions.
+	Engine Engine
+
+	// Imports holds the host functions satisfying the imports of the
+	// module.
+	// Instantiation fails with a *LinkError if an import is missing or
+	// if its type does not match the one declared by the module.
+	Imports Imports
+
+	// MaxMemoryPages, if not zero, caps the size in pages of the memories
+	// of the instance, on top of the maximum declared by their type.
+	MaxMemoryPages uint32
+
+	// Metering enables fuel metering: the execution of instructions
+	// consumes the fuel of the instance, according to Costs, and calls
+	// fail with a trap matching ErrOutOfFuel when it runs out of fuel.
+	Metering bool
+
+	// Fuel is the initial fuel of the instance, when metering is enabled.
+	Fuel uint64
+
+	// CallFuel, if not zero, is the fuel of each call made by the host:
+	// the fuel of the instance is set to CallFuel when such a call starts,
+	// so that each call has its own budget. Calls made by host functions
+	// share the budget of the call in progress.
+	CallFuel uint64
+
+	// Costs is the cost table of the instructions. DefaultCosts is used if
+	// nil.
+	Costs *Costs
+
+	// Resumable makes the calls running out of fuel resumable: they are
+	// suspended instead of aborted, and may be resumed with Resume after
+	// refuelling.
+	// Calls made by host functions are never resumable.
+	Resumable bool
+
+	// MaxCallDepth is the maximum depth of the call stack of the calls
+	// into the instance, DefaultMaxCallDepth if zero.
+	// Calls exceeding it fail with a trap of kind TrapCallStackExhausted.
+	MaxCallDepth int
+
+	// MaxStackSize is the maximum number of values of the operand stack of
+	// the calls into the instance, locals included, DefaultMaxStackSize if
+	// zero.
+	// Calls exceeding it fail with a trap of kind TrapCallStackExhausted.
+	MaxStackSize int
+}
+
+// Default limits of the calls into an instance.
+const (
+	DefaultMaxCallDepth = 10000
+	DefaultMaxStackSize = 1 << 20
+)
+
+// Instantiate validates and instantiates the module m, then runs its start
+// function, if any.
+//
+// Only modules using the MVP feature set and without imports can be
+// instantiated. Use InstantiateWithOptions to provide host functions.
+func Instantiate(ctx context.Context, m *wasm.Module) (*Instance, error) {
+	return InstantiateWithOptions(ctx, m, InstantiateOptions{})
+}
+
+// InstantiateWithOptions is like Instantiate but with configurable
+// options.
+// The imports of the module are resolved against opts.Imports. Use a Store
+// to link modules together.
+func InstantiateWithOptions(ctx context.Context, m *wasm.Module, opts InstantiateOptions) (*Instance, error) {
+	c, err := CompileWithOptions(m, CompileOptions{Engine: opts.Engine})
+	if err != nil {
+		return nil, err
+	}
+	return c.instantiate(ctx, opts, nil)
+}
+
+// eval evaluates a constant expression.
+func (inst *Instance) eval(ie wasm.InitExpr) uint64 {
+	code := ie.Expr
+	if len(code) == 0 {
+		return 0
+	}
+	switch op := wasm.Opcode(code[0]); op {
+	case wasm.Op_i32_const:
+		v, _ := instr.ReadS32(code, 1)
+		return uint64(uint32(v))
+	case wasm.Op_i64_const:
+		v, _ := instr.ReadS64(code, 1)
+		return uint64(v)
+	case wasm.Op_f32_const:
+		return uint64(order.Uint32(code[1:]))
+	case wasm.Op_f64_const:
+		return order.Uint64(code[1:])
+	case wasm.Op_get_global:
+		idx, _ := instr.ReadU32(code, 1)
+		return inst.globals[idx].val
+	default:
+		panic(fmt.Errorf("exec: invalid constant instruction %v", op))
+	}
+}
+
+// Module returns the module of the instance.
+func (inst *Instance) Module() *wasm.Module { return inst.module }
+
+// Function returns the exported function with the provided name, or nil.
+func (inst *Instance) Function(name string) *Function {
+	e, ok := inst.exports[name]
+	if !ok || e.Kind != wasm.FunctionKind {
+		return nil
+	}
+	return inst.funcs[e.Index]
+}
+
+// Memory returns the exported memory with the provided name, or nil.
+func (inst *Instance) Memory(name string) *Memory {
+	e, ok := inst.exports[name]
+	if !ok || e.Kind != wasm.MemoryKind {
+		return nil
+	}
+	return inst.mems[e.Index]
+}
+
+// Global returns the exported global with the provided name, or nil.
+func (inst *Instance) Global(name string) *Global {
+	e, ok := inst.exports[name]
+	if !ok || e.Kind != wasm.GlobalKind {
+		return nil
+	}
+	return inst.globals[e.Index]
+}
+
+// Call calls the exported function with the provided name.
+func (inst *Instance) Call(ctx context.Context, name string, args ...uint64) ([]uint64, error) {
+	f := inst.Function(name)
+	if f == nil {
+		return nil, fmt.Errorf("exec: unknown exported function %q", name)
+	}
+	return f.Call(ctx, args...)
+}
+
+// Invoke calls the exported function with the provided name, converting
+// its arguments from and its results to Go values.
+// Values of type i32, i64, f32 and f64 are represented as int32, int64,
+// float32 and float64 values. Arguments of type i32 and i64 may also be
+// passed as uint32 and uint64 values.
+func (inst *Instance) Invoke(ctx context.Context, name string, args ...interface{}) ([]interface{}, error) {
+	f := inst.Function(name)
+	if f == nil {
+		return nil, fmt.Errorf("exec: unknown exported function %q", name)
+	}
+	if len(args) != len(f.typ.Params) {
+		return nil, fmt.Errorf("exec: %s: got %d arguments, want %d", name, len(args), len(f.typ.Params))
+	}
+	raw := make([]uint64, len(args))
+	for i, arg := range args {
+		v, err := encode(f.typ.Params[i], arg)
+		if err != nil {
+			return nil, fmt.Errorf("exec: %s: argument %d: %w", name, i, err)
+		}
+		raw[i] = v
+	}
+	res, err := f.Call(ctx, raw...)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(res))
+	for i, v := range res {
+		out[i] = decode(f.typ.Results[i], v)
+	}
+	return out, nil
+}
+
+// Call calls the function with the provided arguments.
+func (f *Function) Call(ctx context.Context, args ...uint64) (res []uint64, err error) {
+	if len(args) != len(f.typ.Params) {
+		return nil, fmt.Errorf("exec: got %d arguments, want %d", len(args), len(f.typ.Params))
+	}
+	inst := f.inst
+	m := &machine{
+		ctx:      ctx,
+		meter:    inst.meter,
+		maxDepth: inst.maxDepth - inst.outer.frames,
+		maxStack: inst.maxStack - inst.outer.stack,
+	}
+	if inst.active == 0 {
+		// call from the host.
+		m.resumable = inst.resumable
+		if inst.meter != nil && inst.callFuel != 0 {
+			inst.meter.fuel = inst.callFuel
+		}
+	}
+	inst.active++
+	defer func() { inst.active-- }()
+
+	m.stack = append(m.stack, args...)
+	if err := m.run(f); err != nil {
+		return nil, err
+	}
+	return m.stack, nil
+}
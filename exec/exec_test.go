@@ -0,0 +1,282 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+var (
+	i32   = []wasm.ValueType{wasm.I32}
+	i64   = []wasm.ValueType{wasm.I64}
+	i32x2 = []wasm.ValueType{wasm.I32, wasm.I32}
+)
+
+// instantiate builds the module made by mk and instantiates it.
+func instantiate(t *testing.T, mk func(b *wasm.Builder)) *exec.Instance {
+	t.Helper()
+	inst, err := exec.Instantiate(context.Background(), wasmtest.Build(t, mk))
+	if err != nil {
+		t.Fatalf("could not instantiate module: %+v", err)
+	}
+	return inst
+}
+
+func TestCall(t *testing.T) {
+	inst := instantiate(t, wasmtest.NewModule)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		args []uint64
+		want []uint64
+	}{
+		{"fac", []uint64{0}, []uint64{1}},
+		{"fac", []uint64{20}, []uint64{2432902008176640000}},
+		{"sum", []uint64{0}, []uint64{0}},
+		{"sum", []uint64{100}, []uint64{4950}},
+		{"switch", []uint64{0}, []uint64{100}},
+		{"switch", []uint64{1}, []uint64{101}},
+		{"switch", []uint64{2}, []uint64{102}},
+		{"switch", []uint64{3}, []uint64{199}},
+		{"switch", []uint64{0xffffffff}, []uint64{199}},
+		{"load", []uint64{16}, []uint64{'h'}},
+		{"load", []uint64{28}, []uint64{0xffffffff}},
+		{"store", []uint64{32, 0x12345678}, []uint64{0x567800}},
+		{"dispatch", []uint64{0, 7, 3}, []uint64{10}},
+		{"dispatch", []uint64{1, 7, 3}, []uint64{4}},
+		{"incr", nil, []uint64{43}},
+		{"incr", nil, []uint64{44}},
+		{"grow", []uint64{1}, []uint64{2<<16 + 1}},
+		{"grow", []uint64{1}, []uint64{2<<16 - 1}},
+		{"trap", []uint64{0}, []uint64{}},
+	} {
+		got, err := inst.Call(ctx, tc.name, tc.args...)
+		if err != nil {
+			t.Fatalf("%s%v: %+v", tc.name, tc.args, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s%v: got=%#x, want=%#x", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	inst := instantiate(t, wasmtest.NewModule)
+	ctx := context.Background()
+
+	got, err := inst.Invoke(ctx, "fac", int64(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(120)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	got, err = inst.Invoke(ctx, "switch", uint32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(101)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v, want=%v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		want string
+	}{
+		{"fac", []interface{}{int32(5)}, "cannot use int32 value as i64"},
+		{"fac", nil, "got 0 arguments, want 1"},
+		{"nope", nil, `unknown exported function "nope"`},
+	} {
+		_, err := inst.Invoke(ctx, tc.name, tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: invalid error: got=%v, want=%s", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	inst := instantiate(t, wasmtest.NewModule)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		args []uint64
+		want string
+	}{
+		{"trap", []uint64{1}, "unreachable executed"},
+		{"load", []uint64{65536}, "out of bounds memory access"},
+		{"load", []uint64{0xffffffff}, "out of bounds memory access"},
+		{"store", []uint64{65534, 0}, "out of bounds memory access"},
+		{"dispatch", []uint64{3, 0, 0}, "uninitialized element"},
+		{"dispatch", []uint64{4, 0, 0}, "undefined element"},
+		{"dispatch", []uint64{2, 0, 0}, "indirect call type mismatch"},
+	} {
+		_, err := inst.Call(ctx, tc.name, tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s%v: invalid error: got=%v, want=%s", tc.name, tc.args, err, tc.want)
+		}
+	}
+
+	// the instance is still usable after a trap.
+	got, err := inst.Call(ctx, "sum", 10)
+	if err != nil || got[0] != 45 {
+		t.Fatalf("invalid result after trap: %v, %v", got, err)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	var (
+		nan32 = exec.EncodeF32(float32(math.NaN()))
+		nan64 = exec.EncodeF64(math.NaN())
+		f32   = exec.EncodeF32
+		f64   = exec.EncodeF64
+	)
+	for _, tc := range []struct {
+		op   func(e *wasm.Emitter) *wasm.Emitter
+		in   []wasm.ValueType
+		out  wasm.ValueType
+		args []uint64
+		want uint64
+		trap string
+	}{
+		{op: (*wasm.Emitter).I32DivS, in: i32x2, out: wasm.I32, args: []uint64{exec.EncodeI32(-7), 2}, want: exec.EncodeI32(-3)},
+		{op: (*wasm.Emitter).I32DivS, in: i32x2, out: wasm.I32, args: []uint64{0x80000000, 0xffffffff}, trap: "integer overflow"},
+		{op: (*wasm.Emitter).I32RemS, in: i32x2, out: wasm.I32, args: []uint64{0x80000000, 0xffffffff}, want: 0},
+		{op: (*wasm.Emitter).I32RemS, in: i32x2, out: wasm.I32, args: []uint64{exec.EncodeI32(-7), 2}, want: exec.EncodeI32(-1)},
+		{op: (*wasm.Emitter).I32DivU, in: i32x2, out: wasm.I32, args: []uint64{1, 0}, trap: "integer divide by zero"},
+		{op: (*wasm.Emitter).I64RemU, in: []wasm.ValueType{wasm.I64, wasm.I64}, out: wasm.I64, args: []uint64{1, 0}, trap: "integer divide by zero"},
+		{op: (*wasm.Emitter).I32Shl, in: i32x2, out: wasm.I32, args: []uint64{1, 33}, want: 2},
+		{op: (*wasm.Emitter).I32ShrS, in: i32x2, out: wasm.I32, args: []uint64{0x80000000, 31}, want: 0xffffffff},
+		{op: (*wasm.Emitter).I32Rotr, in: i32x2, out: wasm.I32, args: []uint64{1, 1}, want: 0x80000000},
+		{op: (*wasm.Emitter).I64Rotl, in: []wasm.ValueType{wasm.I64, wasm.I64}, out: wasm.I64, args: []uint64{1 << 63, 65}, want: 1},
+		{op: (*wasm.Emitter).I32Clz, in: i32, out: wasm.I32, args: []uint64{0}, want: 32},
+		{op: (*wasm.Emitter).I64Ctz, in: i64, out: wasm.I64, args: []uint64{0}, want: 64},
+		{op: (*wasm.Emitter).I32Popcnt, in: i32, out: wasm.I32, args: []uint64{0xf0f0}, want: 8},
+		{op: (*wasm.Emitter).I64ExtendI32S, in: i32, out: wasm.I64, args: []uint64{0xffffffff}, want: math.MaxUint64},
+		{op: (*wasm.Emitter).I32WrapI64, in: i64, out: wasm.I32, args: []uint64{0x123456789}, want: 0x23456789},
+		{op: (*wasm.Emitter).F32Min, in: []wasm.ValueType{wasm.F32, wasm.F32}, out: wasm.F32, args: []uint64{f32(0), 1 << 31}, want: 1 << 31},
+		{op: (*wasm.Emitter).F64Max, in: []wasm.ValueType{wasm.F64, wasm.F64}, out: wasm.F64, args: []uint64{f64(1), nan64}, want: nan64},
+		{op: (*wasm.Emitter).F32Nearest, in: []wasm.ValueType{wasm.F32}, out: wasm.F32, args: []uint64{f32(2.5)}, want: f32(2)},
+		{op: (*wasm.Emitter).F64Nearest, in: []wasm.ValueType{wasm.F64}, out: wasm.F64, args: []uint64{f64(-3.5)}, want: f64(-4)},
+		{op: (*wasm.Emitter).F32Neg, in: []wasm.ValueType{wasm.F32}, out: wasm.F32, args: []uint64{nan32}, want: nan32 | 1<<31},
+		{op: (*wasm.Emitter).F64Copysign, in: []wasm.ValueType{wasm.F64, wasm.F64}, out: wasm.F64, args: []uint64{f64(2), f64(-1)}, want: f64(-2)},
+		{op: (*wasm.Emitter).I32TruncF32S, in: []wasm.ValueType{wasm.F32}, out: wasm.I32, args: []uint64{f32(-2147483648)}, want: 0x80000000},
+		{op: (*wasm.Emitter).I32TruncF32S, in: []wasm.ValueType{wasm.F32}, out: wasm.I32, args: []uint64{f32(2147483648)}, trap: "integer overflow"},
+		{op: (*wasm.Emitter).I32TruncF64U, in: []wasm.ValueType{wasm.F64}, out: wasm.I32, args: []uint64{f64(-0.9)}, want: 0},
+		{op: (*wasm.Emitter).I64TruncF64U, in: []wasm.ValueType{wasm.F64}, out: wasm.I64, args: []uint64{f64(1 << 63)}, want: 1 << 63},
+		{op: (*wasm.Emitter).I64TruncF64S, in: []wasm.ValueType{wasm.F64}, out: wasm.I64, args: []uint64{nan64}, trap: "invalid conversion to integer"},
+		{op: (*wasm.Emitter).F32ConvertI64U, in: i64, out: wasm.F32, args: []uint64{0x8000008000000001}, want: f32(9223373136366403584)},
+		{op: (*wasm.Emitter).F64ConvertI64U, in: i64, out: wasm.F64, args: []uint64{math.MaxUint64}, want: f64(18446744073709551616)},
+		{op: (*wasm.Emitter).F32DemoteF64, in: []wasm.ValueType{wasm.F64}, out: wasm.F32, args: []uint64{f64(1e300)}, want: f32(float32(math.Inf(1)))},
+		{op: (*wasm.Emitter).I64ReinterpretF64, in: []wasm.ValueType{wasm.F64}, out: wasm.I64, args: []uint64{f64(-0.0) | 1<<63}, want: 1 << 63},
+	} {
+		inst := instantiate(t, func(b *wasm.Builder) {
+			f := b.Func("f", wasm.FuncType{Params: tc.in, Results: []wasm.ValueType{tc.out}})
+			e := f.Body()
+			for i := range tc.in {
+				e.LocalGet(uint32(i))
+			}
+			tc.op(e).End()
+			b.Export("f", f)
+		})
+		got, err := inst.Call(context.Background(), "f", tc.args...)
+		switch {
+		case tc.trap != "":
+			if err == nil || !strings.Contains(err.Error(), tc.trap) {
+				t.Fatalf("%#x: invalid error: got=%v, want=%s", tc.args, err, tc.trap)
+			}
+		case err != nil:
+			t.Fatalf("%#x: %+v", tc.args, err)
+		case got[0] != tc.want:
+			t.Fatalf("%#x: got=%#x, want=%#x", tc.args, got[0], tc.want)
+		}
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		mk   func(b *wasm.Builder)
+		want string
+	}{
+		{
+			name: "import",
+			mk: func(b *wasm.Builder) {
+				b.ImportFunc("env", "f", wasm.FuncType{})
+			},
+			want: `import "env"."f" (function): unknown module "env"`,
+		},
+		{
+			name: "data",
+			mk: func(b *wasm.Builder) {
+				mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Data(mem, wasm.ConstI32(65535), []byte{1, 2})
+			},
+			want: "data segment 0: exec: out of bounds memory access",
+		},
+		{
+			name: "elem",
+			mk: func(b *wasm.Builder) {
+				table := b.Table(wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc)})
+				f := b.Func("f", wasm.FuncType{})
+				f.Body().End()
+				b.Elements(table, wasm.ConstI32(0), f)
+			},
+			want: "element segment 0: exec: out of bounds table access",
+		},
+		{
+			name: "start",
+			mk: func(b *wasm.Builder) {
+				f := b.Func("f", wasm.FuncType{})
+				f.Body().Unreachable().End()
+				b.Start(f)
+			},
+			want: "start function: exec: unreachable executed",
+		},
+		{
+			name: "invalid",
+			mk: func(b *wasm.Builder) {
+				f := b.Func("f", wasm.FuncType{Results: i32})
+				f.SetBody(nil, []byte{byte(wasm.Op_i64_const), 0})
+			},
+			want: "type mismatch",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			tc.mk(b)
+			m, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = exec.Instantiate(ctx, m)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error: got=%v, want=%s", err, tc.want)
+			}
+		})
+	}
+
+	var verr *wasm.ValidationError
+	b := wasm.NewBuilder()
+	b.Func("f", wasm.FuncType{Results: i32}).SetBody(nil, nil)
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Instantiate(ctx, m); !errors.As(err, &verr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
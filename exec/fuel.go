@@ -0,0 +1,100 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+// ErrOutOfFuel matches, with errors.Is, the traps of calls that ran out of
+// fuel.
+var ErrOutOfFuel error = &Trap{Kind: TrapOutOfFuel}
+
+// Costs holds the fuel consumed by the execution of instructions, indexed
+// by opcode.
+type Costs [256]uint32
+
+// DefaultCosts returns the default cost table.
+//
+// The table is derived from the opcode metadata: the structural
+// instructions (nop, block, loop, else and end) are free, memory accesses
+// cost 2, calls and integer divisions cost 5, memory.grow costs 100 and all
+// other instructions cost 1.
+func DefaultCosts() *Costs {
+	var c Costs
+	for i := range c {
+		op := wasm.Opcode(i)
+		name := op.String()
+		switch {
+		case strings.HasPrefix(name, "Opcode("):
+			// undefined opcode.
+		case op == wasm.Op_nop, op == wasm.Op_block, op == wasm.Op_loop,
+			op == wasm.Op_else, op == wasm.Op_end:
+			c[i] = 0
+		case op == wasm.Op_grow_memory:
+			c[i] = 100
+		case op == wasm.Op_call, op == wasm.Op_call_indirect,
+			strings.Contains(name, ".div_"), strings.Contains(name, ".rem_"):
+			c[i] = 5
+		case strings.Contains(name, ".load"), strings.Contains(name, ".store"):
+			c[i] = 2
+		default:
+			c[i] = 1
+		}
+	}
+	return &c
+}
+
+// meter is the fuel tank of an instance.
+type meter struct {
+	fuel  uint64
+	costs *Costs
+}
+
+// Fuel returns the remaining fuel of the instance, or 0 if fuel metering
+// is disabled.
+func (inst *Instance) Fuel() uint64 {
+	if inst.meter == nil {
+		return 0
+	}
+	return inst.meter.fuel
+}
+
+// AddFuel adds n units of fuel to the instance.
+// It fails if fuel metering is disabled.
+func (inst *Instance) AddFuel(n uint64) error {
+	if inst.meter == nil {
+		return errors.New("exec: fuel metering is disabled")
+	}
+	if inst.meter.fuel > math.MaxUint64-n {
+		inst.meter.fuel = math.MaxUint64
+		return nil
+	}
+	inst.meter.fuel += n
+	return nil
+}
+
+// Resume resumes the last call that ran out of fuel, if the instance was
+// instantiated with the Resumable option, and returns its results.
+// The call may run out of fuel again, and be resumed again.
+func (inst *Instance) Resume(ctx context.Context) ([]uint64, error) {
+	m := inst.suspended
+	if m == nil {
+		return nil, errors.New("exec: no suspended call")
+	}
+	inst.suspended = nil
+	m.ctx = ctx
+	inst.active++
+	defer func() { inst.active-- }()
+	if err := m.resume(); err != nil {
+		return nil, err
+	}
+	return m.stack, nil
+}
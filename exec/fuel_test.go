@@ -0,0 +1,171 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func TestFuel(t *testing.T) {
+	ctx := context.Background()
+	m := wasmtest.Build(t, wasmtest.Add, wasmtest.NewModule)
+
+	// the start function of the test module consumes 4 units of fuel:
+	// global.get, i32.const, i32.add and global.set.
+	inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{
+		Metering: true,
+		Fuel:     4 + 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inst.Fuel(), uint64(3); got != want {
+		t.Fatalf("invalid fuel: got=%d, want=%d", got, want)
+	}
+	got, err := inst.Call(ctx, "add", 1, 2)
+	if err != nil || got[0] != 3 {
+		t.Fatalf("add: got=%v, %v", got, err)
+	}
+	if got := inst.Fuel(); got != 0 {
+		t.Fatalf("invalid fuel: got=%d, want=0", got)
+	}
+	_, err = inst.Call(ctx, "add", 1, 2)
+	if !errors.Is(err, exec.ErrOutOfFuel) {
+		t.Fatalf("invalid error: %v", err)
+	}
+	var trap *exec.Trap
+	if !errors.As(err, &trap) || trap.Kind != exec.TrapOutOfFuel || len(trap.Stack) != 1 {
+		t.Fatalf("invalid trap: %+v", err)
+	}
+	if _, err := inst.Resume(ctx); err == nil {
+		t.Fatalf("expected non-resumable calls")
+	}
+
+	if err := inst.AddFuel(1000); err != nil {
+		t.Fatal(err)
+	}
+	got, err = inst.Call(ctx, "sum", 10)
+	if err != nil || got[0] != 45 {
+		t.Fatalf("sum: got=%v, %v", got, err)
+	}
+	used := 1000 - inst.Fuel()
+
+	// the fuel consumed by a call does not depend on the fuel left.
+	for fuel := uint64(0); fuel < used; fuel += 7 {
+		inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{
+			Metering: true,
+			Fuel:     4 + fuel,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := inst.Call(ctx, "sum", 10); !errors.Is(err, exec.ErrOutOfFuel) {
+			t.Fatalf("fuel=%d: invalid error: %v", fuel, err)
+		}
+	}
+
+	if _, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{Metering: true, Fuel: 3}); !errors.Is(err, exec.ErrOutOfFuel) {
+		t.Fatalf("invalid start error: %v", err)
+	}
+
+	inst, err = exec.Instantiate(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Fuel() != 0 || inst.AddFuel(1) == nil {
+		t.Fatalf("expected disabled fuel metering")
+	}
+}
+
+func TestFuelCosts(t *testing.T) {
+	costs := exec.DefaultCosts()
+	for _, tc := range []struct {
+		op   wasm.Opcode
+		want uint32
+	}{
+		{wasm.Op_block, 0},
+		{wasm.Op_end, 0},
+		{wasm.Op_br, 1},
+		{wasm.Op_i32_add, 1},
+		{wasm.Op_f64_div, 1},
+		{wasm.Op_i64_div_u, 5},
+		{wasm.Op_i32_rem_s, 5},
+		{wasm.Op_call_indirect, 5},
+		{wasm.Op_i64_load16_s, 2},
+		{wasm.Op_f32_store, 2},
+		{wasm.Op_grow_memory, 100},
+		{0xff, 0},
+	} {
+		if got := costs[tc.op]; got != tc.want {
+			t.Fatalf("%v: got=%d, want=%d", tc.op, got, tc.want)
+		}
+	}
+
+	ctx := context.Background()
+	costs[wasm.Op_i32_add] = 10
+	inst, err := exec.InstantiateWithOptions(ctx, wasmtest.Build(t, wasmtest.Add, wasmtest.NewModule), exec.InstantiateOptions{
+		Metering: true,
+		Costs:    costs,
+		CallFuel: 13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each call has its own budget.
+	for i := 0; i < 3; i++ {
+		if _, err := inst.Call(ctx, "add", 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		if got := inst.Fuel(); got != 1 {
+			t.Fatalf("invalid fuel: got=%d, want=1", got)
+		}
+	}
+}
+
+func TestFuelResume(t *testing.T) {
+	ctx := context.Background()
+	inst, err := exec.InstantiateWithOptions(ctx, wasmtest.Build(t, wasmtest.Add, wasmtest.NewModule), exec.InstantiateOptions{
+		Metering:  true,
+		Fuel:      100,
+		Resumable: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.Resume(ctx); err == nil {
+		t.Fatalf("expected no suspended call")
+	}
+
+	var (
+		res []uint64
+		n   = 0
+	)
+	res, err = inst.Call(ctx, "fac", 20)
+	for errors.Is(err, exec.ErrOutOfFuel) {
+		n++
+		if err := inst.AddFuel(10); err != nil {
+			t.Fatal(err)
+		}
+		res, err = inst.Resume(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatalf("expected the call to run out of fuel")
+	}
+	if got, want := res[0], uint64(2432902008176640000); got != want {
+		t.Fatalf("got=%d, want=%d", got, want)
+	}
+	if _, err := inst.Resume(ctx); err == nil {
+		t.Fatalf("expected no suspended call")
+	}
+}
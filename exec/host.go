@@ -0,0 +1,203 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sbinet/wasm"
+)
+
+// Imports holds the host modules satisfying the imports of a module, by
+// module name.
+type Imports map[string]HostModule
+
+// HostModule holds the definitions of a host module, by field name.
+//
+// Functions are defined either with a *HostFunction or with a Go function,
+// whose signature is mapped to a function type as described for
+// NewHostFunction. Memories and globals are defined with a *Memory and a
+// *Global.
+type HostModule map[string]interface{}
+
+// HostFunc is the low-level form of a function implemented in Go.
+//
+// The arguments of the function are passed in stack, encoded as by
+// EncodeI32 and friends, and the function stores its results at the start
+// of stack, which is large enough to hold both.
+// mod is the instance calling the function.
+//
+// A host function aborts the execution of its caller by panicking with
+// an error value.
+type HostFunc func(ctx context.Context, mod *Instance, stack []uint64)
+
+// HostFunction is a function implemented in Go.
+type HostFunction struct {
+	Type wasm.FuncType
+	Func HostFunc
+}
+
+var (
+	ctxType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	instType  = reflect.TypeOf((*Instance)(nil))
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewHostFunction returns a host function calling the Go function fn.
+//
+// The parameters and results of fn may be of any type whose kind is one of
+// int32 or uint32 (i32), int64 or uint64 (i64), float32 (f32) and float64
+// (f64). The parameters may be preceded by a context.Context, then by an
+// *Instance, receiving the calling instance.
+// The results may be followed by an error: a non-nil error aborts the
+// execution of the caller.
+func NewHostFunction(fn interface{}) (*HostFunction, error) {
+	rv := reflect.ValueOf(fn)
+	rt := rv.Type()
+	if rt.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("exec: invalid host function type %T", fn)
+	}
+	if rt.IsVariadic() {
+		return nil, fmt.Errorf("exec: invalid variadic host function type %T", fn)
+	}
+
+	var (
+		ft      wasm.FuncType
+		in      = 0
+		withCtx = false
+		withMod = false
+		withErr = false
+	)
+	if in < rt.NumIn() && rt.In(in) == ctxType {
+		withCtx = true
+		in++
+	}
+	if in < rt.NumIn() && rt.In(in) == instType {
+		withMod = true
+		in++
+	}
+	for i := in; i < rt.NumIn(); i++ {
+		vt, ok := valueTypeOf(rt.In(i))
+		if !ok {
+			return nil, fmt.Errorf("exec: invalid host function type %T: invalid parameter type %v", fn, rt.In(i))
+		}
+		ft.Params = append(ft.Params, vt)
+	}
+	out := rt.NumOut()
+	if out > 0 && rt.Out(out-1) == errorType {
+		withErr = true
+		out--
+	}
+	for i := 0; i < out; i++ {
+		vt, ok := valueTypeOf(rt.Out(i))
+		if !ok {
+			return nil, fmt.Errorf("exec: invalid host function type %T: invalid result type %v", fn, rt.Out(i))
+		}
+		ft.Results = append(ft.Results, vt)
+	}
+
+	params := make([]reflect.Type, rt.NumIn())
+	for i := range params {
+		params[i] = rt.In(i)
+	}
+	call := func(ctx context.Context, mod *Instance, stack []uint64) {
+		args := make([]reflect.Value, 0, len(params))
+		if withCtx {
+			args = append(args, reflect.ValueOf(&ctx).Elem())
+		}
+		if withMod {
+			args = append(args, reflect.ValueOf(mod))
+		}
+		for i, t := range params[in:] {
+			v := reflect.New(t).Elem()
+			switch t.Kind() {
+			case reflect.Int32, reflect.Int64:
+				v.SetInt(int64(stack[i]))
+			case reflect.Uint32, reflect.Uint64:
+				v.SetUint(stack[i])
+			case reflect.Float32:
+				v.SetFloat(float64(DecodeF32(stack[i])))
+			case reflect.Float64:
+				v.SetFloat(DecodeF64(stack[i]))
+			}
+			args = append(args, v)
+		}
+		res := rv.Call(args)
+		if withErr {
+			if err := res[len(res)-1]; !err.IsNil() {
+				panic(err.Interface().(error))
+			}
+			res = res[:len(res)-1]
+		}
+		for i, v := range res {
+			switch v.Kind() {
+			case reflect.Int32:
+				stack[i] = EncodeI32(int32(v.Int()))
+			case reflect.Int64:
+				stack[i] = EncodeI64(v.Int())
+			case reflect.Uint32, reflect.Uint64:
+				stack[i] = v.Uint()
+			case reflect.Float32:
+				stack[i] = EncodeF32(float32(v.Float()))
+			case reflect.Float64:
+				stack[i] = EncodeF64(v.Float())
+			}
+		}
+	}
+	return &HostFunction{Type: ft, Func: call}, nil
+}
+
+// valueTypeOf returns the value type representing values of type t.
+func valueTypeOf(t reflect.Type) (wasm.ValueType, bool) {
+	switch t.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return wasm.I32, true
+	case reflect.Int64, reflect.Uint64:
+		return wasm.I64, true
+	case reflect.Float32:
+		return wasm.F32, true
+	case reflect.Float64:
+		return wasm.F64, true
+	}
+	return 0, false
+}
+
+// hostFunction returns the host function defined by the value v of a host
+// module.
+func hostFunction(v interface{}) (*HostFunction, error) {
+	var hf *HostFunction
+	switch v := v.(type) {
+	case *HostFunction:
+		hf = v
+	case HostFunction:
+		hf = &v
+	case HostFunc, func(context.Context, *Instance, []uint64):
+		return nil, fmt.Errorf("low-level host function without a type, use a *HostFunction")
+	default:
+		var err error
+		hf, err = NewHostFunction(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hf == nil || hf.Func == nil {
+		return nil, fmt.Errorf("nil host function")
+	}
+	return hf, nil
+}
+
+func funcTypeString(ft wasm.FuncType) string {
+	str := func(vts []wasm.ValueType) string {
+		s := make([]string, len(vts))
+		for i, vt := range vts {
+			s[i] = vt.String()
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	}
+	return str(ft.Params) + " -> " + str(ft.Results)
+}
@@ -0,0 +1,221 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func TestNewHostFunction(t *testing.T) {
+	type fd int32
+	for _, tc := range []struct {
+		fn   interface{}
+		want wasm.FuncType
+		err  string
+	}{
+		{fn: func() {}, want: wasm.FuncType{}},
+		{
+			fn:   func(int32, float64) int64 { return 0 },
+			want: wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.F64}, Results: i64},
+		},
+		{
+			fn:   func(context.Context, *exec.Instance, fd, uint64) (float32, uint32, error) { return 0, 0, nil },
+			want: wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.I64}, Results: []wasm.ValueType{wasm.F32, wasm.I32}},
+		},
+		{fn: 42, err: "invalid host function type int"},
+		{fn: func(...int32) {}, err: "invalid variadic host function type"},
+		{fn: func(int) {}, err: "invalid parameter type int"},
+		{fn: func() string { return "" }, err: "invalid result type string"},
+		{fn: func(int32, context.Context) {}, err: "invalid parameter type context.Context"},
+	} {
+		hf, err := exec.NewHostFunction(tc.fn)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%T: invalid error: got=%v, want=%s", tc.fn, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%T: %+v", tc.fn, err)
+		}
+		if !reflect.DeepEqual(hf.Type, tc.want) {
+			t.Fatalf("%T: got=%v, want=%v", tc.fn, hf.Type, tc.want)
+		}
+	}
+}
+
+// hostFuncs adds to b functions calling imported host functions.
+func hostFuncs(b *wasm.Builder) {
+	fma := b.ImportFunc("env", "fma", wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.F64}, Results: []wasm.ValueType{wasm.F64}})
+	rsub := b.ImportFunc("env", "rsub", wasm.FuncType{Params: i32x2, Results: i32})
+	fail := b.ImportFunc("env", "fail", wasm.FuncType{Params: i32})
+
+	f := b.Func("f", wasm.FuncType{Params: i32, Results: []wasm.ValueType{wasm.F64}})
+	f.Body().LocalGet(0).F64Const(0.5).Call(fma).End()
+	b.Export("f", f)
+
+	g := b.Func("g", wasm.FuncType{Params: i32x2, Results: i32})
+	g.Body().LocalGet(0).LocalGet(1).Call(rsub).End()
+	b.Export("g", g)
+
+	h := b.Func("h", wasm.FuncType{Params: i32, Results: i32})
+	h.Body().LocalGet(0).Call(fail).I32Const(1).End()
+	b.Export("h", h)
+
+	b.Export("fail", fail)
+}
+
+func TestHostFunctions(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		errFail = errors.New("failure")
+		caller  *exec.Instance
+	)
+	imports := exec.Imports{
+		"env": exec.HostModule{
+			"fma": func(x int32, y float64) float64 {
+				return float64(x)*y + 1
+			},
+			"rsub": &exec.HostFunction{
+				Type: wasm.FuncType{Params: i32x2, Results: i32},
+				Func: func(ctx context.Context, mod *exec.Instance, stack []uint64) {
+					caller = mod
+					stack[0] = uint64(uint32(stack[1]) - uint32(stack[0]))
+				},
+			},
+			"fail": func(ctx context.Context, v uint32) error {
+				if v != 0 {
+					return errFail
+				}
+				return nil
+			},
+		},
+	}
+
+	m := wasmtest.Build(t, hostFuncs)
+	inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{Imports: imports})
+	if err != nil {
+		t.Fatalf("could not instantiate module: %+v", err)
+	}
+
+	got, err := inst.Call(ctx, "f", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exec.DecodeF64(got[0]), 2.5; got != want {
+		t.Fatalf("f: got=%v, want=%v", got, want)
+	}
+
+	got, err = inst.Call(ctx, "g", 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exec.DecodeI32(got[0]), int32(-7); got != want {
+		t.Fatalf("g: got=%v, want=%v", got, want)
+	}
+	if caller != inst {
+		t.Fatalf("invalid calling instance")
+	}
+
+	got, err = inst.Call(ctx, "h", 0)
+	if err != nil || got[0] != 1 {
+		t.Fatalf("h: got=%v, %v", got, err)
+	}
+	if _, err := inst.Call(ctx, "h", 1); !errors.Is(err, errFail) {
+		t.Fatalf("h: invalid error: got=%v, want=%v", err, errFail)
+	}
+	if _, err := inst.Call(ctx, "fail", math.MaxUint32); !errors.Is(err, errFail) {
+		t.Fatalf("fail: invalid error: got=%v, want=%v", err, errFail)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	valid := exec.HostModule{
+		"fma":  func(int32, float64) float64 { return 0 },
+		"rsub": func(a, b int32) int32 { return b - a },
+		"fail": func(int32) {},
+	}
+	with := func(field string, v interface{}) exec.Imports {
+		mod := exec.HostModule{}
+		for k, v := range valid {
+			mod[k] = v
+		}
+		if v == nil {
+			delete(mod, field)
+		} else {
+			mod[field] = v
+		}
+		return exec.Imports{"env": mod}
+	}
+
+	m := wasmtest.Build(t, hostFuncs)
+
+	for _, tc := range []struct {
+		name    string
+		imports exec.Imports
+		field   string
+		want    string
+	}{
+		{
+			name:  "no-module",
+			field: "fma",
+			want:  `exec: import "env"."fma" (function): unknown module "env"`,
+		},
+		{
+			name:    "no-field",
+			imports: with("rsub", nil),
+			field:   "rsub",
+			want:    `exec: import "env"."rsub" (function): unknown import "rsub" in module "env"`,
+		},
+		{
+			name:    "mismatch",
+			imports: with("fma", func(int64, float64) float64 { return 0 }),
+			field:   "fma",
+			want:    `exec: import "env"."fma" (function): incompatible import type: got [i64 f64] -> [f64], want [i32 f64] -> [f64]`,
+		},
+		{
+			name:    "mismatch-results",
+			imports: with("fail", func(int32) int32 { return 0 }),
+			field:   "fail",
+			want:    `incompatible import type: got [i32] -> [i32], want [i32] -> []`,
+		},
+		{
+			name:    "invalid-func",
+			imports: with("fail", func(string) {}),
+			field:   "fail",
+			want:    `exec: import "env"."fail" (function): exec: invalid host function type func(string): invalid parameter type string`,
+		},
+		{
+			name: "untyped",
+			imports: with("fail", exec.HostFunc(func(context.Context, *exec.Instance, []uint64) {
+			})),
+			field: "fail",
+			want:  "low-level host function without a type",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := exec.InstantiateWithOptions(context.Background(), m, exec.InstantiateOptions{Imports: tc.imports})
+			var lerr *exec.LinkError
+			if !errors.As(err, &lerr) {
+				t.Fatalf("expected a link error, got %v", err)
+			}
+			if lerr.Module != "env" || lerr.Field != tc.field || lerr.Kind != wasm.FunctionKind {
+				t.Fatalf("invalid link error: %#v", lerr)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%s", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// machine is the state of a call to a function: its operand, call and
+// label stacks.
+// Calls between functions push frames on the call stack, they are not
+// implemented with Go recursion.
+type machine struct {
+	ctx       context.Context
+	maxDepth  int    // maximum number of frames
+	maxStack  int    // maximum height of the operand stack
+	meter     *meter // fuel tank, or nil
+	resumable bool   // whether the call is suspended when it runs out of fuel
+
+	stack  []uint64
+	frames []frame
+	labels []label
+}
+
+// frame is an activation of a function.
+type frame struct {
+	fn     *Function
+	pc     int // offset of the next instruction
+	at     int // offset of the executing call or trapping instruction
+	locals int // index of the first local in the operand stack
+	labels int // index of the label of the function body in the label stack
+}
+
+// label is the target of the branches out of a structured instruction.
+type label struct {
+	cont   int // offset of the continuation, or -1 for the function body
+	height int // height of the operand stack below the block operands
+	arity  int // number of values carried by a branch to the label
+}
+
+func (m *machine) push(v uint64) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *machine) pop() uint64 {
+	n := len(m.stack) - 1
+	v := m.stack[n]
+	m.stack = m.stack[:n]
+	return v
+}
+
+func (m *machine) pop2() (uint64, uint64) {
+	n := len(m.stack) - 2
+	a, b := m.stack[n], m.stack[n+1]
+	m.stack = m.stack[:n]
+	return a, b
+}
+
+func (m *machine) top() *uint64 {
+	return &m.stack[len(m.stack)-1]
+}
+
+// enter pushes a frame for a call to f, whose arguments are on the operand
+// stack.
+func (m *machine) enter(f *Function) {
+	if len(m.frames) >= m.maxDepth || len(m.stack)+f.body.nlocals+f.body.height > m.maxStack {
+		trap(TrapCallStackExhausted)
+	}
+	locals := len(m.stack) - len(f.typ.Params)
+	for i := 0; i < f.body.nlocals; i++ {
+		m.stack = append(m.stack, 0)
+	}
+	m.frames = append(m.frames, frame{
+		fn:     f,
+		locals: locals,
+		labels: len(m.labels),
+	})
+	m.labels = append(m.labels, label{
+		cont:   -1,
+		height: len(m.stack),
+		arity:  len(f.typ.Results),
+	})
+}
+
+// leave pops the frame of the current function, leaving its results on
+// the operand stack.
+func (m *machine) leave() {
+	fr := &m.frames[len(m.frames)-1]
+	n := len(fr.fn.typ.Results)
+	copy(m.stack[fr.locals:], m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:fr.locals+n]
+	m.labels = m.labels[:fr.labels]
+	m.frames = m.frames[:len(m.frames)-1]
+}
+
+// branch branches to the label at the provided depth and returns the
+// offset of the continuation, or -1 if the function returns.
+func (m *machine) branch(depth uint32) int {
+	i := len(m.labels) - 1 - int(depth)
+	l := m.labels[i]
+	if l.cont < 0 {
+		m.leave()
+		return -1
+	}
+	copy(m.stack[l.height:], m.stack[len(m.stack)-l.arity:])
+	m.stack = m.stack[:l.height+l.arity]
+	m.labels = m.labels[:i]
+	return l.cont
+}
+
+// run calls the function f, whose arguments are on the operand stack,
+// and leaves its results on the stack.
+func (m *machine) run(f *Function) (err error) {
+	defer m.recover(f.inst, &err)
+
+	if m.ctx != nil && m.ctx.Err() != nil {
+		m.interrupt()
+	}
+	if f.host != nil {
+		m.charge(wasm.Op_call)
+		m.callOut(f)
+		return nil
+	}
+	if f.body.ir != nil {
+		m.enterIR(f, 0)
+		m.execIR(0)
+		return nil
+	}
+	if f.body.jit != nil {
+		m.runJIT(f)
+		return nil
+	}
+	m.enter(f)
+	m.exec(0)
+	return nil
+}
+
+// resume resumes the execution of a call suspended by run.
+func (m *machine) resume() (err error) {
+	defer m.recover(m.frames[0].fn.inst, &err)
+	if m.ctx != nil && m.ctx.Err() != nil {
+		m.interrupt()
+	}
+	if m.frames[0].fn.body.ir != nil {
+		m.execIR(0)
+		return nil
+	}
+	m.exec(0)
+	return nil
+}
+
+// recover recovers from the panics of traps and stores their error in
+// err. Calls running out of fuel are suspended in inst if they are
+// resumable.
+func (m *machine) recover(inst *Instance, err *error) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	te, ok := e.(trapError)
+	if !ok {
+		panic(e)
+	}
+	*err = te.err
+	var t *Trap
+	if errors.As(*err, &t) {
+		t.Stack = append(t.Stack, m.stackTrace()...)
+		if t.Kind == TrapOutOfFuel && m.resumable && len(m.frames) > 0 {
+			inst.suspended = m
+		}
+	}
+}
+
+// pollInterval is the number of calls and loop iterations between two
+// checks of the cancellation of a call.
+const pollInterval = 64
+
+// interrupt aborts the execution of the call with a trap wrapping the error
+// of its context.
+func (m *machine) interrupt() {
+	panic(trapError{&Trap{Kind: TrapInterrupted, Err: m.ctx.Err()}})
+}
+
+// charge consumes the fuel of the instruction op, or traps if there is not
+// enough fuel left.
+func (m *machine) charge(op wasm.Opcode) {
+	if m.meter == nil {
+		return
+	}
+	c := uint64(m.meter.costs[op])
+	if m.meter.fuel < c {
+		trap(TrapOutOfFuel)
+	}
+	m.meter.fuel -= c
+}
+
+// callOut calls the host function, or the function executed by another
+// engine, f, whose arguments are on the operand stack, and leaves its
+// results on the stack.
+func (m *machine) callOut(f *Function) {
+	var (
+		params  = len(f.typ.Params)
+		results = len(f.typ.Results)
+		base    = len(m.stack) - params
+	)
+	for i := params; i < results; i++ {
+		m.stack = append(m.stack, 0)
+	}
+	if f.host != nil {
+		m.callHost(f, base)
+	} else {
+		m.callNested(f, base)
+	}
+	m.stack = m.stack[:base+results]
+}
+
+// callNested calls the function f, executed by another engine than its
+// caller, on a new machine sharing the limits of the call. Its arguments
+// are in the operand stack starting at base, where its results are stored.
+func (m *machine) callNested(f *Function, base int) {
+	n := &machine{
+		ctx:      m.ctx,
+		meter:    m.meter,
+		maxDepth: m.maxDepth - len(m.frames),
+		maxStack: m.maxStack - len(m.stack),
+	}
+	n.stack = append(n.stack, m.stack[base:base+len(f.typ.Params)]...)
+	if err := n.run(f); err != nil {
+		panic(trapError{err})
+	}
+	copy(m.stack[base:], n.stack)
+}
+
+// callHost calls the host function f, whose arguments are in the operand
+// stack starting at base, where its results are stored.
+func (m *machine) callHost(f *Function, base int) {
+	defer func() {
+		if e := recover(); e != nil {
+			if err, ok := e.(error); ok {
+				panic(trapError{err})
+			}
+			panic(e)
+		}
+	}()
+	caller := f.inst
+	if len(m.frames) > 0 {
+		caller = m.frames[len(m.frames)-1].fn.inst
+	}
+	// calls made by the host function share the limits of the call.
+	caller.outer.frames += len(m.frames)
+	caller.outer.stack += len(m.stack)
+	defer func() {
+		caller.outer.frames -= len(m.frames)
+		caller.outer.stack -= len(m.stack)
+	}()
+	f.host.Func(m.ctx, caller, m.stack[base:])
+}
+
+// stackTrace returns the frames of the call stack, innermost first.
+func (m *machine) stackTrace() []Frame {
+	frames := make([]Frame, 0, len(m.frames))
+	for i := len(m.frames) - 1; i >= 0; i-- {
+		fr := &m.frames[i]
+		frames = append(frames, Frame{
+			Func:   fr.fn.idx,
+			Name:   fr.fn.inst.names[fr.fn.idx],
+			Offset: fr.at,
+		})
+	}
+	return frames
+}
+
+// exec executes instructions until the call stack is back to the depth
+// base.
+func (m *machine) exec(base int) {
+	var (
+		fr   = &m.frames[len(m.frames)-1]
+		inst = fr.fn.inst
+		code = fr.fn.body.code
+		pc   = fr.pc
+	)
+	// reload updates the cached state after a call or a return.
+	reload := func() bool {
+		if len(m.frames) == base {
+			return false
+		}
+		fr = &m.frames[len(m.frames)-1]
+		inst = fr.fn.inst
+		code = fr.fn.body.code
+		pc = fr.pc
+		return true
+	}
+	var (
+		start int // offset of the executing instruction
+		done  <-chan struct{}
+		polls uint
+	)
+	if m.ctx != nil {
+		done = m.ctx.Done()
+	}
+	// poll checks periodically, on calls and iterations of loops, whether
+	// the call was cancelled.
+	poll := func() {
+		polls++
+		if polls%pollInterval != 0 {
+			return
+		}
+		select {
+		case <-done:
+			m.interrupt()
+		default:
+		}
+	}
+	// jump branches to the label at the provided depth, polling on the
+	// iterations of loops, and reports whether the execution continues.
+	jump := func(depth uint32) bool {
+		if pc = m.branch(depth); pc < 0 {
+			return reload()
+		}
+		if pc < start && done != nil {
+			poll()
+		}
+		return true
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			m.frames[len(m.frames)-1].at = start
+			panic(e)
+		}
+	}()
+
+	for {
+		start = pc
+		op := wasm.Opcode(code[pc])
+		if m.meter != nil {
+			fr.pc = start // resume at the current instruction
+			m.charge(op)
+		}
+		pc++
+		switch op {
+		case wasm.Op_unreachable:
+			trap(TrapUnreachable)
+
+		case wasm.Op_nop:
+
+		case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+			var params, results int
+			params, results, pc = inst.blockType(code, pc)
+			l := label{height: len(m.stack) - params, arity: results}
+			t := fr.fn.body.targets[start]
+			switch op {
+			case wasm.Op_block:
+				l.cont = t.end + 1
+			case wasm.Op_loop:
+				l.cont = start
+				l.arity = params
+			case wasm.Op_if:
+				l.cont = t.end + 1
+				l.height--
+				if uint32(m.pop()) == 0 {
+					pc = t.end
+					if t.els >= 0 {
+						pc = t.els + 1
+					}
+				}
+			}
+			m.labels = append(m.labels, l)
+
+		case wasm.Op_else:
+			// end of the then branch of an if.
+			pc = fr.fn.body.targets[start].end
+
+		case wasm.Op_end:
+			if len(m.labels)-1 > fr.labels {
+				m.labels = m.labels[:len(m.labels)-1]
+				break
+			}
+			m.leave()
+			if !reload() {
+				return
+			}
+
+		case wasm.Op_br:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			if !jump(depth) {
+				return
+			}
+
+		case wasm.Op_br_if:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			if uint32(m.pop()) != 0 {
+				if !jump(depth) {
+					return
+				}
+			}
+
+		case wasm.Op_br_table:
+			var n uint32
+			n, pc = instr.ReadU32(code, pc)
+			i := uint32(m.pop())
+			var depth uint32
+			for j := uint32(0); j <= n; j++ {
+				var d uint32
+				d, pc = instr.ReadU32(code, pc)
+				if j == i || j == n {
+					depth = d
+					break
+				}
+			}
+			if !jump(depth) {
+				return
+			}
+
+		case wasm.Op_return:
+			m.leave()
+			if !reload() {
+				return
+			}
+
+		case wasm.Op_call:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			fr.pc, fr.at = pc, start
+			if done != nil {
+				poll()
+			}
+			if f := inst.funcs[idx]; f.host != nil || f.body.ir != nil || f.body.jit != nil {
+				m.callOut(f)
+			} else {
+				m.enter(f)
+				reload()
+			}
+
+		case wasm.Op_call_indirect:
+			var typ, tab uint32
+			typ, pc = instr.ReadU32(code, pc)
+			tab, pc = instr.ReadU32(code, pc)
+			t := inst.tables[tab]
+			i := uint32(m.pop())
+			if uint64(i) >= uint64(len(t.elems)) {
+				trapMsg(TrapOutOfBoundsTable, "undefined element")
+			}
+			f := t.elems[i]
+			if f == nil {
+				trapMsg(TrapNullReference, "uninitialized element")
+			}
+			if !sameType(f.typ, inst.types[typ]) {
+				trap(TrapIndirectCallTypeMismatch)
+			}
+			fr.pc, fr.at = pc, start
+			if done != nil {
+				poll()
+			}
+			if f.host != nil || f.body.ir != nil || f.body.jit != nil {
+				m.callOut(f)
+			} else {
+				m.enter(f)
+				reload()
+			}
+
+		case wasm.Op_drop:
+			m.stack = m.stack[:len(m.stack)-1]
+
+		case wasm.Op_select:
+			c := uint32(m.pop())
+			a, b := m.pop2()
+			if c == 0 {
+				a = b
+			}
+			m.push(a)
+
+		case wasm.Op_get_local:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			m.push(m.stack[fr.locals+int(idx)])
+
+		case wasm.Op_set_local:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			m.stack[fr.locals+int(idx)] = m.pop()
+
+		case wasm.Op_tee_local:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			m.stack[fr.locals+int(idx)] = *m.top()
+
+		case wasm.Op_get_global:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			m.push(inst.globals[idx].val)
+
+		case wasm.Op_set_global:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			inst.globals[idx].val = m.pop()
+
+		case wasm.Op_i32_load, wasm.Op_i64_load, wasm.Op_f32_load, wasm.Op_f64_load,
+			wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i32_load16_s, wasm.Op_i32_load16_u,
+			wasm.Op_i64_load8_s, wasm.Op_i64_load8_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+			wasm.Op_i64_load32_s, wasm.Op_i64_load32_u:
+			var off uint32
+			_, pc = instr.ReadU32(code, pc)
+			off, pc = instr.ReadU32(code, pc)
+			mem := inst.mems[0]
+			p := m.top()
+			*p = load(mem, op, *p, off)
+
+		case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+			wasm.Op_i32_store8, wasm.Op_i32_store16,
+			wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+			var off uint32
+			_, pc = instr.ReadU32(code, pc)
+			off, pc = instr.ReadU32(code, pc)
+			mem := inst.mems[0]
+			addr, v := m.pop2()
+			store(mem, op, addr, off, v)
+
+		case wasm.Op_current_memory:
+			_, pc = instr.ReadU32(code, pc)
+			m.push(uint64(inst.mems[0].Size()))
+
+		case wasm.Op_grow_memory:
+			_, pc = instr.ReadU32(code, pc)
+			p := m.top()
+			*p = uint64(uint32(inst.mems[0].grow(uint32(*p))))
+
+		case wasm.Op_i32_const:
+			var v int32
+			v, pc = instr.ReadS32(code, pc)
+			m.push(uint64(uint32(v)))
+
+		case wasm.Op_i64_const:
+			var v int64
+			v, pc = instr.ReadS64(code, pc)
+			m.push(uint64(v))
+
+		case wasm.Op_f32_const:
+			m.push(uint64(order.Uint32(code[pc:])))
+			pc += 4
+
+		case wasm.Op_f64_const:
+			m.push(order.Uint64(code[pc:]))
+			pc += 8
+
+		default:
+			if isUnary(op) {
+				p := m.top()
+				*p = unop(op, *p)
+				break
+			}
+			a, b := m.pop2()
+			m.push(binop(op, a, b))
+		}
+	}
+}
+
+// sameType reports whether two function types are equal.
+func sameType(a, b wasm.FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	for i := range a.Results {
+		if a.Results[i] != b.Results[i] {
+			return false
+		}
+	}
+	return true
+}
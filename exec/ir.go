@@ -0,0 +1,686 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// irOp is an operation of the internal representation of function bodies.
+//
+// The operations below 0x100 are the wasm instructions of the same opcode,
+// reading their operands from and storing their result in the slots of the
+// frame instead of the operand stack. The others are specific to the
+// internal representation.
+type irOp uint16
+
+// Operations specific to the internal representation, with the semantics
+// of their operands.
+const (
+	irConst     irOp = 0x100 + iota // a = imm
+	irCopy                          // a = b
+	irBr                            // c = b if imm != 0; goto a
+	irBrIf                          // if d != 0 { c = b if imm != 0; goto a }
+	irBrUnless                      // if d == 0 { goto a }
+	irBrTable                       // goto entry d of table a, carrying b
+	irI32AddImm                     // a = b + imm, as i32
+	irI64AddImm                     // a = b + imm, as i64
+)
+
+// irInstr is an instruction of the internal representation.
+//
+// The operands a, b, c and d are slots of the frame, or indices, depending
+// on the operation. The slots of a frame hold the locals of the function,
+// parameters first, followed by its operand stack, whose height is known
+// statically at each instruction.
+//
+// The operands of the wasm instructions are:
+//
+//	unreachable, nop:
+//	return:             results in b, c results
+//	call:               function a, arguments and results in slots from b
+//	call_indirect:      type a, table imm, element c, arguments and results in slots from b
+//	select:             a = b if d != 0, c otherwise
+//	get_global:         a = global b
+//	set_global:         global a = b
+//	loads:              a = memory[b+imm]
+//	stores:             memory[b+imm] = c
+//	current_memory:     a = size
+//	grow_memory:        a = grow by b
+//	numeric:            a = op b, or a = b op c
+type irInstr struct {
+	op         irOp
+	a, b, c, d int32
+	imm        uint64
+	pos        int32  // offset of the translated wasm instruction in the code
+	f0, f1     uint32 // wasm instructions charged by the instruction, in irCode.ops
+}
+
+// irCode is the internal representation of a function body.
+type irCode struct {
+	code   []irInstr
+	ops    []wasm.Opcode // translated wasm instructions, for fuel metering
+	tables [][]irTarget  // targets of the irBrTable instructions
+	nslots int           // number of slots of a frame
+}
+
+// irTarget is an entry of the table of an irBrTable instruction.
+type irTarget struct {
+	ip   int32 // index of the target instruction, or -1 to return
+	dst  int32 // slot of the value carried by the branch
+	move bool  // whether the value carried by the branch must be moved to dst
+}
+
+// irOperand is an operand of the operand stack during the translation.
+// Operands read from locals and constants are not stored in their own
+// slot until needed, so that the instructions consuming them read the
+// local or embed the constant directly.
+type irOperand struct {
+	slot  int32  // slot holding the operand, unless konst
+	konst bool   // whether the operand is the constant imm
+	imm   uint64 // value of a constant operand
+}
+
+// irLabel is a structured instruction during the translation.
+type irLabel struct {
+	height int       // height of the operand stack below the block operands
+	params int       // number of operands of the block
+	arity  int       // number of values carried by a branch to the label
+	result int       // number of results of the block
+	loop   int       // index of the first instruction of a loop, or -1
+	els    int       // index of the branch to the else branch of an if, or -1
+	fixups []irFixup // forward branches to the label
+}
+
+// irFixup is a forward branch, resolved at the end of its target block:
+// the operand a of the instruction ip, or the entry of the branch table.
+type irFixup struct {
+	ip    int
+	table int // index of the branch table, or -1
+	entry int
+}
+
+// translator translates a function body into its internal representation.
+type translator struct {
+	types []wasm.FuncType // types of the module
+	funcs []wasm.FuncType // types of the functions of the module
+	typ   wasm.FuncType   // type of the function
+	code  []byte
+
+	ir     *irCode
+	locals int // number of locals, parameters included
+	stack  []irOperand
+	labels []irLabel
+	pend   int  // index in ir.ops of the first wasm instruction not charged yet
+	mark   int  // index of the last branch target
+	fuse   int  // index of the last instruction whose result may be redirected, or -1
+	dead   bool // whether the code being translated is unreachable
+	depth  int  // depth of the blocks nested in the unreachable code
+}
+
+// translate translates the body b of a function of type ft into its
+// internal representation.
+// types and funcs are the types and the function types of the module.
+func translate(types, funcs []wasm.FuncType, ft wasm.FuncType, b *body) (*irCode, error) {
+	t := &translator{
+		types:  types,
+		funcs:  funcs,
+		typ:    ft,
+		code:   b.code,
+		ir:     &irCode{},
+		locals: len(ft.Params) + b.nlocals,
+		fuse:   -1,
+	}
+	t.ir.nslots = t.locals
+	t.labels = append(t.labels, irLabel{
+		arity:  len(ft.Results),
+		result: len(ft.Results),
+		loop:   -1,
+		els:    -1,
+	})
+	if err := t.translate(); err != nil {
+		return nil, err
+	}
+	return t.ir, nil
+}
+
+func (t *translator) translate() error {
+	code := t.code
+	pc := 0
+	for pc < len(code) {
+		start := pc
+		op := wasm.Opcode(code[pc])
+		pc++
+		if t.dead {
+			// unreachable code is skipped up to the else or end
+			// instruction closing its block, which are not executed.
+			next, err := instr.Skip(op, code, pc)
+			if err != nil {
+				return fmt.Errorf("offset %#x: %w", start, err)
+			}
+			switch op {
+			case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+				t.depth++
+				pc = next
+				continue
+			case wasm.Op_else:
+				if t.depth > 0 {
+					pc = next
+					continue
+				}
+			case wasm.Op_end:
+				if t.depth > 0 {
+					t.depth--
+					pc = next
+					continue
+				}
+			default:
+				pc = next
+				continue
+			}
+		} else {
+			if op == wasm.Op_loop {
+				// the loop instruction is charged on each iteration.
+				t.materialize(start)
+				t.flush(start)
+			}
+			t.ir.ops = append(t.ir.ops, op)
+		}
+
+		switch op {
+		case wasm.Op_unreachable:
+			t.emit(irInstr{op: irOp(op)}, start)
+			t.dead = true
+
+		case wasm.Op_nop:
+
+		case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+			var bt int64
+			bt, pc = instr.ReadS64(code, pc)
+			params, results := 0, 0
+			switch {
+			case bt == -0x40:
+			case bt < 0:
+				results = 1
+			default:
+				params, results = len(t.types[bt].Params), len(t.types[bt].Results)
+			}
+			l := irLabel{params: params, arity: results, result: results, loop: -1, els: -1}
+			switch op {
+			case wasm.Op_block:
+				t.materialize(start)
+			case wasm.Op_loop:
+				l.arity = params
+				l.loop = t.here()
+			case wasm.Op_if:
+				cond := t.slot(len(t.stack)-1, start)
+				t.pop()
+				t.materialize(start)
+				l.els = t.emit(irInstr{op: irBrUnless, d: cond}, start)
+			}
+			l.height = len(t.stack) - params
+			t.labels = append(t.labels, l)
+
+		case wasm.Op_else:
+			l := &t.labels[len(t.labels)-1]
+			if !t.dead {
+				t.materialize(start)
+				ip := t.emit(irInstr{op: irBr}, start)
+				l.fixups = append(l.fixups, irFixup{ip: ip, table: -1})
+			}
+			t.dead = false
+			t.ir.code[l.els].a = int32(t.here())
+			l.els = -1
+			t.reset(l.height, l.params)
+
+		case wasm.Op_end:
+			l := t.labels[len(t.labels)-1]
+			t.labels = t.labels[:len(t.labels)-1]
+			if len(t.labels) == 0 {
+				// end of the function body.
+				if !t.dead {
+					t.ret(start)
+				}
+				break
+			}
+			if !t.dead {
+				t.materialize(start)
+				if l.loop < 0 {
+					t.flush(start)
+				}
+			}
+			t.dead = false
+			if l.loop < 0 {
+				ip := int32(t.here())
+				if l.els >= 0 {
+					t.ir.code[l.els].a = ip
+				}
+				for _, f := range l.fixups {
+					if f.table < 0 {
+						t.ir.code[f.ip].a = ip
+					} else {
+						t.ir.tables[f.table][f.entry].ip = ip
+					}
+				}
+			}
+			t.reset(l.height, l.result)
+
+		case wasm.Op_br:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			i := len(t.labels) - 1 - int(depth)
+			if i == 0 {
+				t.ret(start)
+			} else {
+				in := irInstr{op: irBr}
+				t.carry(&in, i, start)
+				t.jump(i, t.emit(in, start))
+			}
+			t.dead = true
+
+		case wasm.Op_br_if:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			i := len(t.labels) - 1 - int(depth)
+			cond := t.slot(len(t.stack)-1, start)
+			t.pop()
+			if i == 0 {
+				skip := t.emit(irInstr{op: irBrUnless, d: cond}, start)
+				t.ret(start)
+				t.ir.code[skip].a = int32(t.here())
+				break
+			}
+			if n := len(t.ir.code); t.labels[i].arity == 0 && n > 0 && t.fuse == n-1 &&
+				t.ir.code[n-1].op == irOp(wasm.Op_i32_eqz) && t.ir.code[n-1].a == cond {
+				// i32.eqz followed by br_if branches if the operand of
+				// i32.eqz is zero.
+				eqz := t.ir.code[n-1]
+				t.ir.code = t.ir.code[:n-1]
+				t.pend = int(eqz.f0)
+				t.jump(i, t.emit(irInstr{op: irBrUnless, d: eqz.b}, start))
+				break
+			}
+			in := irInstr{op: irBrIf, d: cond}
+			t.carry(&in, i, start)
+			t.jump(i, t.emit(in, start))
+
+		case wasm.Op_br_table:
+			var n uint32
+			n, pc = instr.ReadU32(code, pc)
+			idx := t.slot(len(t.stack)-1, start)
+			t.pop()
+			var (
+				tab = make([]irTarget, n+1)
+				ti  = len(t.ir.tables)
+				src int32
+			)
+			for j := range tab {
+				var depth uint32
+				depth, pc = instr.ReadU32(code, pc)
+				i := len(t.labels) - 1 - int(depth)
+				if i == 0 {
+					tab[j].ip = -1
+					if len(t.typ.Results) > 0 {
+						src = t.slot(len(t.stack)-1, start)
+					}
+					continue
+				}
+				var in irInstr
+				t.carry(&in, i, start)
+				src = in.b
+				tab[j].dst, tab[j].move = in.c, in.imm != 0
+				if l := &t.labels[i]; l.loop >= 0 {
+					tab[j].ip = int32(l.loop)
+				} else {
+					l.fixups = append(l.fixups, irFixup{table: ti, entry: j})
+				}
+			}
+			t.ir.tables = append(t.ir.tables, tab)
+			t.emit(irInstr{op: irBrTable, a: int32(ti), b: src, d: idx}, start)
+			t.dead = true
+
+		case wasm.Op_return:
+			t.ret(start)
+			t.dead = true
+
+		case wasm.Op_call:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			t.call(irInstr{op: irOp(op), a: int32(idx)}, t.funcs[idx], start)
+
+		case wasm.Op_call_indirect:
+			var typ, tab uint32
+			typ, pc = instr.ReadU32(code, pc)
+			tab, pc = instr.ReadU32(code, pc)
+			t.materialize(start)
+			elem := t.slot(len(t.stack)-1, start)
+			t.pop()
+			t.call(irInstr{op: irOp(op), a: int32(typ), c: elem, imm: uint64(tab)}, t.types[typ], start)
+
+		case wasm.Op_drop:
+			t.pop()
+
+		case wasm.Op_select:
+			n := len(t.stack)
+			cond := t.slot(n-1, start)
+			y := t.slot(n-2, start)
+			x := t.slot(n-3, start)
+			t.stack = t.stack[:n-3]
+			t.result(irInstr{op: irOp(op), b: x, c: y, d: cond}, start)
+
+		case wasm.Op_get_local:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			t.push(irOperand{slot: int32(idx)})
+
+		case wasm.Op_set_local, wasm.Op_tee_local:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			t.setLocal(int32(idx), op == wasm.Op_tee_local, start)
+
+		case wasm.Op_get_global:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			t.result(irInstr{op: irOp(op), b: int32(idx)}, start)
+
+		case wasm.Op_set_global:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			v := t.slot(len(t.stack)-1, start)
+			t.pop()
+			t.emit(irInstr{op: irOp(op), a: int32(idx), b: v}, start)
+
+		case wasm.Op_i32_load, wasm.Op_i64_load, wasm.Op_f32_load, wasm.Op_f64_load,
+			wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i32_load16_s, wasm.Op_i32_load16_u,
+			wasm.Op_i64_load8_s, wasm.Op_i64_load8_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+			wasm.Op_i64_load32_s, wasm.Op_i64_load32_u:
+			var off uint32
+			_, pc = instr.ReadU32(code, pc)
+			off, pc = instr.ReadU32(code, pc)
+			addr := t.slot(len(t.stack)-1, start)
+			t.pop()
+			t.result(irInstr{op: irOp(op), b: addr, imm: uint64(off)}, start)
+
+		case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+			wasm.Op_i32_store8, wasm.Op_i32_store16,
+			wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+			var off uint32
+			_, pc = instr.ReadU32(code, pc)
+			off, pc = instr.ReadU32(code, pc)
+			n := len(t.stack)
+			v := t.slot(n-1, start)
+			addr := t.slot(n-2, start)
+			t.stack = t.stack[:n-2]
+			t.emit(irInstr{op: irOp(op), b: addr, c: v, imm: uint64(off)}, start)
+
+		case wasm.Op_current_memory:
+			_, pc = instr.ReadU32(code, pc)
+			t.result(irInstr{op: irOp(op)}, start)
+
+		case wasm.Op_grow_memory:
+			_, pc = instr.ReadU32(code, pc)
+			delta := t.slot(len(t.stack)-1, start)
+			t.pop()
+			t.result(irInstr{op: irOp(op), b: delta}, start)
+
+		case wasm.Op_i32_const:
+			var v int32
+			v, pc = instr.ReadS32(code, pc)
+			t.push(irOperand{konst: true, imm: uint64(uint32(v))})
+
+		case wasm.Op_i64_const:
+			var v int64
+			v, pc = instr.ReadS64(code, pc)
+			t.push(irOperand{konst: true, imm: uint64(v)})
+
+		case wasm.Op_f32_const:
+			t.push(irOperand{konst: true, imm: uint64(order.Uint32(code[pc:]))})
+			pc += 4
+
+		case wasm.Op_f64_const:
+			t.push(irOperand{konst: true, imm: order.Uint64(code[pc:])})
+			pc += 8
+
+		default:
+			if op < wasm.Op_i32_eqz || op > wasm.Op_f64_reinterpret_i64 {
+				return fmt.Errorf("offset %#x: unsupported instruction %v", start, op)
+			}
+			if isUnary(op) {
+				x := t.slot(len(t.stack)-1, start)
+				t.pop()
+				t.result(irInstr{op: irOp(op), b: x}, start)
+				break
+			}
+			t.binary(op, start)
+		}
+	}
+	return nil
+}
+
+// binary translates the binary numeric instruction op.
+// Additions and subtractions of constants embed the constant.
+func (t *translator) binary(op wasm.Opcode, pos int) {
+	var (
+		n    = len(t.stack)
+		x, y = t.stack[n-2], t.stack[n-1]
+	)
+	switch op {
+	case wasm.Op_i32_add, wasm.Op_i32_sub, wasm.Op_i64_add, wasm.Op_i64_sub:
+		if x.konst && !y.konst && (op == wasm.Op_i32_add || op == wasm.Op_i64_add) {
+			x, y = y, x
+		}
+		if x.konst || !y.konst {
+			break
+		}
+		in := irInstr{op: irI32AddImm, b: x.slot, imm: y.imm}
+		switch op {
+		case wasm.Op_i32_sub:
+			in.imm = uint64(-uint32(y.imm))
+		case wasm.Op_i64_add:
+			in.op = irI64AddImm
+		case wasm.Op_i64_sub:
+			in.op = irI64AddImm
+			in.imm = -y.imm
+		}
+		t.stack = t.stack[:n-2]
+		t.result(in, pos)
+		return
+	}
+	a := t.slot(n-2, pos)
+	b := t.slot(n-1, pos)
+	t.stack = t.stack[:n-2]
+	t.result(irInstr{op: irOp(op), b: a, c: b}, pos)
+}
+
+// setLocal translates the local.set and local.tee instructions of the
+// local x.
+// The instruction computing the value stores it directly in the local
+// when possible.
+func (t *translator) setLocal(x int32, tee bool, pos int) {
+	o := t.pop()
+	t.spill(x, pos)
+	n := len(t.ir.code)
+	switch {
+	case o.konst:
+		t.emit(irInstr{op: irConst, a: x, imm: o.imm}, pos)
+	case o.slot == x:
+	case n > 0 && t.fuse == n-1 && t.ir.code[n-1].a == o.slot && o.slot == t.home(len(t.stack)):
+		t.ir.code[n-1].a = x
+		t.fuse = -1
+	default:
+		t.emit(irInstr{op: irCopy, a: x, b: o.slot}, pos)
+	}
+	if tee {
+		if !o.konst {
+			o = irOperand{slot: x}
+		}
+		t.push(o)
+	}
+}
+
+// call translates a call to a function of type ft.
+func (t *translator) call(in irInstr, ft wasm.FuncType, pos int) {
+	t.materialize(pos)
+	n := len(t.stack) - len(ft.Params)
+	in.b = t.home(n)
+	t.emit(in, pos)
+	t.stack = t.stack[:n]
+	for range ft.Results {
+		t.push(irOperand{slot: t.home(len(t.stack))})
+	}
+}
+
+// carry sets the operands of the branch in to the label i moving the
+// value carried by the branch, if any, to the slot expected by the label.
+func (t *translator) carry(in *irInstr, i, pos int) {
+	l := &t.labels[i]
+	if l.arity == 0 {
+		return
+	}
+	src := t.slot(len(t.stack)-1, pos)
+	dst := t.home(l.height)
+	in.b, in.c = src, dst
+	if src != dst {
+		in.imm = 1
+	}
+}
+
+// jump sets the target of the branch ip to the label i.
+func (t *translator) jump(i, ip int) {
+	l := &t.labels[i]
+	if l.loop >= 0 {
+		t.ir.code[ip].a = int32(l.loop)
+		return
+	}
+	l.fixups = append(l.fixups, irFixup{ip: ip, table: -1})
+}
+
+// ret translates a return from the function.
+func (t *translator) ret(pos int) {
+	in := irInstr{op: irOp(wasm.Op_return), c: int32(len(t.typ.Results))}
+	if in.c > 0 {
+		in.b = t.slot(len(t.stack)-1, pos)
+	}
+	t.emit(in, pos)
+}
+
+// reset resets the operand stack to the height h, followed by n operands
+// stored in their slot.
+func (t *translator) reset(h, n int) {
+	t.stack = t.stack[:h]
+	for i := 0; i < n; i++ {
+		t.push(irOperand{slot: t.home(len(t.stack))})
+	}
+}
+
+// home returns the slot of the operand i of the stack.
+func (t *translator) home(i int) int32 {
+	return int32(t.locals + i)
+}
+
+func (t *translator) push(o irOperand) {
+	t.stack = append(t.stack, o)
+	if n := t.locals + len(t.stack); n > t.ir.nslots {
+		t.ir.nslots = n
+	}
+}
+
+func (t *translator) pop() irOperand {
+	o := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	return o
+}
+
+// slot returns the slot holding the operand i of the stack, storing it in
+// its slot if it is a constant.
+func (t *translator) slot(i, pos int) int32 {
+	if t.stack[i].konst {
+		t.store(i, pos)
+	}
+	return t.stack[i].slot
+}
+
+// store stores the operand i of the stack in its slot.
+func (t *translator) store(i, pos int) {
+	o := &t.stack[i]
+	h := t.home(i)
+	switch {
+	case o.konst:
+		t.emit(irInstr{op: irConst, a: h, imm: o.imm}, pos)
+	case o.slot != h:
+		t.emit(irInstr{op: irCopy, a: h, b: o.slot}, pos)
+	default:
+		return
+	}
+	*o = irOperand{slot: h}
+}
+
+// materialize stores all the operands of the stack in their slot, as
+// expected at the boundaries of blocks and by calls.
+func (t *translator) materialize(pos int) {
+	for i := range t.stack {
+		t.store(i, pos)
+	}
+}
+
+// spill stores the operands of the stack read from the local x in their
+// slot, before x is modified.
+func (t *translator) spill(x int32, pos int) {
+	for i, o := range t.stack {
+		if !o.konst && o.slot == x {
+			t.store(i, pos)
+		}
+	}
+}
+
+// emit appends the instruction in, translated from the wasm instruction at
+// offset pos, and returns its index.
+// The instruction charges the pending wasm instructions.
+func (t *translator) emit(in irInstr, pos int) int {
+	in.pos = int32(pos)
+	in.f0, in.f1 = uint32(t.pend), uint32(len(t.ir.ops))
+	t.pend = len(t.ir.ops)
+	t.ir.code = append(t.ir.code, in)
+	t.fuse = -1
+	return len(t.ir.code) - 1
+}
+
+// result appends the instruction in, storing its result on the top of the
+// stack.
+func (t *translator) result(in irInstr, pos int) {
+	in.a = t.home(len(t.stack))
+	t.push(irOperand{slot: in.a})
+	t.fuse = t.emit(in, pos)
+}
+
+// here marks the next instruction as a branch target and returns its
+// index.
+func (t *translator) here() int {
+	t.mark = len(t.ir.code)
+	t.fuse = -1
+	return t.mark
+}
+
+// flush charges the pending wasm instructions before a branch target,
+// which must not charge them: they are charged by the previous instruction
+// if it falls through to the target, or by a nop.
+func (t *translator) flush(pos int) {
+	if t.pend == len(t.ir.ops) {
+		return
+	}
+	if n := len(t.ir.code); n > t.mark {
+		switch t.ir.code[n-1].op {
+		case irBrIf, irBrUnless:
+		default:
+			t.ir.code[n-1].f1 = uint32(len(t.ir.ops))
+			t.pend = len(t.ir.ops)
+			return
+		}
+	}
+	t.emit(irInstr{op: irOp(wasm.Op_nop)}, pos)
+}
@@ -0,0 +1,243 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+var engines = []exec.Engine{exec.EngineIR, exec.EngineBytecode}
+
+func TestEngines(t *testing.T) {
+	ctx := context.Background()
+	m := wasmtest.Build(t, wasmtest.NewModule)
+
+	var insts []*exec.Instance
+	for _, engine := range engines {
+		inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{
+			Engine:   engine,
+			Metering: true,
+			Fuel:     1 << 20,
+		})
+		if err != nil {
+			t.Fatalf("%v: %+v", engine, err)
+		}
+		insts = append(insts, inst)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []uint64
+		want []uint64 // nil for traps
+	}{
+		{"swap", []uint64{1, 2}, []uint64{21}},
+		{"tee", []uint64{3}, []uint64{12}},
+		{"brvalue", []uint64{1}, []uint64{5}},
+		{"brvalue", []uint64{0}, []uint64{7}},
+		{"select", []uint64{1}, []uint64{10}},
+		{"select", []uint64{0}, []uint64{20}},
+		{"orminus", []uint64{4}, []uint64{4}},
+		{"orminus", []uint64{0}, []uint64{0xffffffff}},
+		{"fib", []uint64{0}, []uint64{0}},
+		{"fib", []uint64{90}, []uint64{2880067194370816120}},
+		{"dead", nil, []uint64{3}},
+		{"fac", []uint64{20}, []uint64{2432902008176640000}},
+		{"sum", []uint64{100}, []uint64{4950}},
+		{"switch", []uint64{0}, []uint64{100}},
+		{"switch", []uint64{2}, []uint64{102}},
+		{"switch", []uint64{7}, []uint64{199}},
+		{"store", []uint64{32, 0x12345678}, []uint64{0x567800}},
+		{"dispatch", []uint64{1, 7, 3}, []uint64{4}},
+		{"incr", nil, []uint64{43}},
+		{"grow", []uint64{1}, []uint64{2<<16 + 1}},
+		{"trap", []uint64{1}, nil},
+		{"load", []uint64{0xffffffff}, nil},
+		{"dispatch", []uint64{3, 0, 0}, nil},
+		{"dispatch", []uint64{2, 0, 0}, nil},
+	} {
+		var (
+			res  []string
+			fuel []uint64
+		)
+		for i, inst := range insts {
+			before := inst.Fuel()
+			got, err := inst.Call(ctx, tc.name, tc.args...)
+			switch {
+			case tc.want == nil && err == nil:
+				t.Fatalf("%v: %s%v: expected a trap", engines[i], tc.name, tc.args)
+			case tc.want != nil && err != nil:
+				t.Fatalf("%v: %s%v: %+v", engines[i], tc.name, tc.args, err)
+			case tc.want != nil && !reflect.DeepEqual(got, tc.want):
+				t.Fatalf("%v: %s%v: got=%#x, want=%#x", engines[i], tc.name, tc.args, got, tc.want)
+			}
+			res = append(res, fmt.Sprintf("%+v", err))
+			fuel = append(fuel, before-inst.Fuel())
+		}
+		if res[0] != res[1] {
+			t.Fatalf("%s%v: engines disagree:\n%v: %s\n%v: %s", tc.name, tc.args, engines[0], res[0], engines[1], res[1])
+		}
+		if fuel[0] != fuel[1] {
+			t.Fatalf("%s%v: engines consumed %d and %d units of fuel", tc.name, tc.args, fuel[0], fuel[1])
+		}
+	}
+}
+
+func TestEnginesLinking(t *testing.T) {
+	ctx := context.Background()
+	for _, engine := range engines {
+		other := exec.EngineBytecode
+		if engine == exec.EngineBytecode {
+			other = exec.EngineIR
+		}
+		store := exec.NewStore()
+		lib, err := store.Instantiate(ctx, wasmtest.Build(t, wasmtest.NewLibModule), exec.InstantiateOptions{Engine: other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Register("lib", lib); err != nil {
+			t.Fatal(err)
+		}
+
+		b := wasm.NewBuilder()
+		load := b.ImportFunc("lib", "load", wasm.FuncType{Params: i32, Results: i32})
+		b.ImportTable("lib", "table", wasm.TableType{ElemType: funcref, Limits: wasm.ResizableLimits{Initial: 2}})
+		f := b.Func("f", wasm.FuncType{Params: i32x2, Results: i32})
+		f.Body().
+			LocalGet(0).Call(load).
+			LocalGet(1).I32Const(0).CallIndirect(wasm.FuncType{Params: i32, Results: i32}).
+			I32Add().End()
+		b.Export("f", f)
+		m, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := exec.CompileWithOptions(m, exec.CompileOptions{Engine: engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Engine() != engine {
+			t.Fatalf("invalid engine: got=%v, want=%v", c.Engine(), engine)
+		}
+		inst, err := store.InstantiateCompiled(ctx, c, exec.InstantiateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lib.Memory("memory").WriteUint32Le(8, 40); err != nil {
+			t.Fatal(err)
+		}
+		got, err := inst.Call(ctx, "f", 8, 1)
+		if err != nil {
+			t.Fatalf("%v: %+v", engine, err)
+		}
+		if got[0] != 42 {
+			t.Fatalf("%v: got=%d, want=42", engine, got[0])
+		}
+
+		_, err = inst.Call(ctx, "f", 65536, 1)
+		if err == nil {
+			t.Fatalf("%v: expected a trap", engine)
+		}
+		want := "exec: out of bounds memory access in function 1 (load) at offset 0x2\n" +
+			"wasm stack trace:\n" +
+			"\t0: function 1 (load) at offset 0x2\n" +
+			"\t1: function 1 (f) at offset 0x2"
+		if got := fmt.Sprintf("%+v", err); got != want {
+			t.Fatalf("%v: invalid trap:\ngot:\n%s\nwant:\n%s", engine, got, want)
+		}
+	}
+}
+
+func TestCompileEngine(t *testing.T) {
+	m, err := wasm.NewBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exec.CompileWithOptions(m, exec.CompileOptions{Engine: 42})
+	if err == nil || !strings.Contains(err.Error(), "invalid engine Engine(42)") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
+
+func TestIRFusionAtEntry(t *testing.T) {
+	ctx := context.Background()
+	b := wasm.NewBuilder()
+
+	// copy copies its argument to a local before any instruction is
+	// translated.
+	cp := b.Func("copy", wasm.FuncType{Params: i32, Results: i32})
+	cp.SetBody([]wasm.LocalEntry{{Count: 1, Type: wasm.I32}}, []byte{
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_set_local), 1,
+		byte(wasm.Op_get_local), 1,
+	})
+	b.Export("copy", cp)
+
+	// unless returns 1 if its argument is zero, 2 otherwise, with an
+	// i32.eqz followed by a br_if at the start of the function.
+	unless := b.Func("unless", wasm.FuncType{Params: i32, Results: i32})
+	unless.SetBody(nil, []byte{
+		byte(wasm.Op_block), 0x40,
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_i32_eqz),
+		byte(wasm.Op_br_if), 0,
+		byte(wasm.Op_i32_const), 2,
+		byte(wasm.Op_return),
+		byte(wasm.Op_end),
+		byte(wasm.Op_i32_const), 1,
+	})
+	b.Export("unless", unless)
+
+	// when branches on its argument at the start of the function.
+	when := b.Func("when", wasm.FuncType{Params: i32, Results: i32})
+	when.SetBody(nil, []byte{
+		byte(wasm.Op_block), 0x40,
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_br_if), 0,
+		byte(wasm.Op_i32_const), 2,
+		byte(wasm.Op_return),
+		byte(wasm.Op_end),
+		byte(wasm.Op_i32_const), 1,
+	})
+	b.Export("when", when)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range engines {
+		inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{Engine: engine})
+		if err != nil {
+			t.Fatalf("%v: %+v", engine, err)
+		}
+		for _, tc := range []struct {
+			name string
+			arg  uint64
+			want uint64
+		}{
+			{"copy", 42, 42},
+			{"unless", 0, 1},
+			{"unless", 5, 2},
+			{"when", 0, 2},
+			{"when", 5, 1},
+		} {
+			got, err := inst.Call(ctx, tc.name, tc.arg)
+			if err != nil {
+				t.Fatalf("%v: %s(%d): %+v", engine, tc.name, tc.arg, err)
+			}
+			if got[0] != tc.want {
+				t.Fatalf("%v: %s(%d): got=%d, want=%d", engine, tc.name, tc.arg, got[0], tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+
+	"github.com/sbinet/wasm"
+)
+
+// enterIR pushes a frame for a call to f, translated to the internal
+// representation, whose arguments are in the operand stack starting at
+// base.
+// The operand stack holds the slots of the frames.
+func (m *machine) enterIR(f *Function, base int) {
+	top := base + f.body.ir.nslots
+	if len(m.frames) >= m.maxDepth || top > m.maxStack {
+		trap(TrapCallStackExhausted)
+	}
+	if top > cap(m.stack) {
+		stack := make([]uint64, top, 2*top)
+		copy(stack, m.stack)
+		m.stack = stack
+	}
+	m.stack = m.stack[:top]
+	locals := m.stack[base+len(f.typ.Params) : base+len(f.typ.Params)+f.body.nlocals]
+	for i := range locals {
+		locals[i] = 0
+	}
+	m.frames = append(m.frames, frame{fn: f, locals: base})
+}
+
+// chargeIR consumes the fuel of the wasm instructions ops, or traps if
+// there is not enough fuel left for all of them.
+func (m *machine) chargeIR(ops []wasm.Opcode) {
+	var c uint64
+	for _, op := range ops {
+		c += uint64(m.meter.costs[op])
+	}
+	if m.meter.fuel < c {
+		trap(TrapOutOfFuel)
+	}
+	m.meter.fuel -= c
+}
+
+// execIR executes instructions of the internal representation until the
+// call stack is back to the depth base.
+func (m *machine) execIR(base int) {
+	var (
+		fr   = &m.frames[len(m.frames)-1]
+		inst = fr.fn.inst
+		ir   = fr.fn.body.ir
+		code = ir.code
+		ip   = fr.pc
+		r    = m.stack[fr.locals:] // slots of the frame
+	)
+	// reload updates the cached state after a call or a return.
+	reload := func() {
+		fr = &m.frames[len(m.frames)-1]
+		inst = fr.fn.inst
+		ir = fr.fn.body.ir
+		code = ir.code
+		ip = fr.pc
+		r = m.stack[fr.locals:]
+	}
+	// leave pops the frame of the current function, whose results are in
+	// its first slots, and reports whether the execution continues.
+	leave := func() bool {
+		n := len(m.frames) - 1
+		m.frames = m.frames[:n]
+		if n == base {
+			m.stack = m.stack[:fr.locals+len(fr.fn.typ.Results)]
+			return false
+		}
+		reload()
+		m.stack = m.stack[:fr.locals+ir.nslots]
+		return true
+	}
+	var (
+		in    *irInstr // executing instruction
+		done  <-chan struct{}
+		polls uint
+	)
+	if m.ctx != nil {
+		done = m.ctx.Done()
+	}
+	// poll checks periodically, on calls and backward branches, whether
+	// the call was cancelled.
+	poll := func() {
+		polls++
+		if polls%pollInterval != 0 {
+			return
+		}
+		select {
+		case <-done:
+			m.interrupt()
+		default:
+		}
+	}
+	// jump branches to the instruction target.
+	jump := func(target int32) {
+		if int(target) < ip && done != nil {
+			poll()
+		}
+		ip = int(target)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if in != nil {
+				m.frames[len(m.frames)-1].at = int(in.pos)
+			}
+			panic(e)
+		}
+	}()
+
+	for {
+		in = &code[ip]
+		if m.meter != nil {
+			fr.pc = ip // resume at the current instruction
+			m.chargeIR(ir.ops[in.f0:in.f1])
+		}
+		ip++
+		switch in.op {
+		case irConst:
+			r[in.a] = in.imm
+
+		case irCopy:
+			r[in.a] = r[in.b]
+
+		case irI32AddImm:
+			r[in.a] = uint64(uint32(r[in.b]) + uint32(in.imm))
+
+		case irI64AddImm:
+			r[in.a] = r[in.b] + in.imm
+
+		case irBr:
+			if in.imm != 0 {
+				r[in.c] = r[in.b]
+			}
+			jump(in.a)
+
+		case irBrIf:
+			if uint32(r[in.d]) != 0 {
+				if in.imm != 0 {
+					r[in.c] = r[in.b]
+				}
+				jump(in.a)
+			}
+
+		case irBrUnless:
+			if uint32(r[in.d]) == 0 {
+				jump(in.a)
+			}
+
+		case irBrTable:
+			tab := ir.tables[in.a]
+			i := uint32(r[in.d])
+			if uint64(i) >= uint64(len(tab)) {
+				i = uint32(len(tab) - 1)
+			}
+			t := &tab[i]
+			if t.ip < 0 {
+				if len(fr.fn.typ.Results) > 0 {
+					r[0] = r[in.b]
+				}
+				if !leave() {
+					return
+				}
+				break
+			}
+			if t.move {
+				r[t.dst] = r[in.b]
+			}
+			jump(t.ip)
+
+		case irOp(wasm.Op_return):
+			if in.c > 0 {
+				r[0] = r[in.b]
+			}
+			if !leave() {
+				return
+			}
+
+		case irOp(wasm.Op_unreachable):
+			trap(TrapUnreachable)
+
+		case irOp(wasm.Op_nop):
+
+		case irOp(wasm.Op_call):
+			f := inst.funcs[in.a]
+			fr.pc, fr.at = ip, int(in.pos)
+			if done != nil {
+				poll()
+			}
+			if m.callIR(f, fr.locals+int(in.b)) {
+				reload()
+			}
+
+		case irOp(wasm.Op_call_indirect):
+			t := inst.tables[in.imm]
+			i := uint32(r[in.c])
+			if uint64(i) >= uint64(len(t.elems)) {
+				trapMsg(TrapOutOfBoundsTable, "undefined element")
+			}
+			f := t.elems[i]
+			if f == nil {
+				trapMsg(TrapNullReference, "uninitialized element")
+			}
+			if !sameType(f.typ, inst.types[in.a]) {
+				trap(TrapIndirectCallTypeMismatch)
+			}
+			fr.pc, fr.at = ip, int(in.pos)
+			if done != nil {
+				poll()
+			}
+			if m.callIR(f, fr.locals+int(in.b)) {
+				reload()
+			}
+
+		case irOp(wasm.Op_select):
+			if uint32(r[in.d]) != 0 {
+				r[in.a] = r[in.b]
+			} else {
+				r[in.a] = r[in.c]
+			}
+
+		case irOp(wasm.Op_get_global):
+			r[in.a] = inst.globals[in.b].val
+
+		case irOp(wasm.Op_set_global):
+			inst.globals[in.a].val = r[in.b]
+
+		case irOp(wasm.Op_i32_load):
+			mem := inst.mems[0]
+			r[in.a] = uint64(order.Uint32(mem.buf[mem.addr(r[in.b], uint32(in.imm), 4):]))
+
+		case irOp(wasm.Op_i32_store):
+			mem := inst.mems[0]
+			order.PutUint32(mem.buf[mem.addr(r[in.b], uint32(in.imm), 4):], uint32(r[in.c]))
+
+		case irOp(wasm.Op_i64_load), irOp(wasm.Op_f32_load), irOp(wasm.Op_f64_load),
+			irOp(wasm.Op_i32_load8_s), irOp(wasm.Op_i32_load8_u), irOp(wasm.Op_i32_load16_s), irOp(wasm.Op_i32_load16_u),
+			irOp(wasm.Op_i64_load8_s), irOp(wasm.Op_i64_load8_u), irOp(wasm.Op_i64_load16_s), irOp(wasm.Op_i64_load16_u),
+			irOp(wasm.Op_i64_load32_s), irOp(wasm.Op_i64_load32_u):
+			r[in.a] = load(inst.mems[0], wasm.Opcode(in.op), r[in.b], uint32(in.imm))
+
+		case irOp(wasm.Op_i64_store), irOp(wasm.Op_f32_store), irOp(wasm.Op_f64_store),
+			irOp(wasm.Op_i32_store8), irOp(wasm.Op_i32_store16),
+			irOp(wasm.Op_i64_store8), irOp(wasm.Op_i64_store16), irOp(wasm.Op_i64_store32):
+			store(inst.mems[0], wasm.Opcode(in.op), r[in.b], uint32(in.imm), r[in.c])
+
+		case irOp(wasm.Op_current_memory):
+			r[in.a] = uint64(inst.mems[0].Size())
+
+		case irOp(wasm.Op_grow_memory):
+			r[in.a] = uint64(uint32(inst.mems[0].grow(uint32(r[in.b]))))
+
+		// the most common numeric instructions are executed inline.
+		case irOp(wasm.Op_i32_eqz):
+			r[in.a] = b2u(uint32(r[in.b]) == 0)
+		case irOp(wasm.Op_i32_eq):
+			r[in.a] = b2u(uint32(r[in.b]) == uint32(r[in.c]))
+		case irOp(wasm.Op_i32_ne):
+			r[in.a] = b2u(uint32(r[in.b]) != uint32(r[in.c]))
+		case irOp(wasm.Op_i32_lt_s):
+			r[in.a] = b2u(int32(r[in.b]) < int32(r[in.c]))
+		case irOp(wasm.Op_i32_lt_u):
+			r[in.a] = b2u(uint32(r[in.b]) < uint32(r[in.c]))
+		case irOp(wasm.Op_i32_gt_s):
+			r[in.a] = b2u(int32(r[in.b]) > int32(r[in.c]))
+		case irOp(wasm.Op_i32_gt_u):
+			r[in.a] = b2u(uint32(r[in.b]) > uint32(r[in.c]))
+		case irOp(wasm.Op_i32_le_s):
+			r[in.a] = b2u(int32(r[in.b]) <= int32(r[in.c]))
+		case irOp(wasm.Op_i32_ge_s):
+			r[in.a] = b2u(int32(r[in.b]) >= int32(r[in.c]))
+		case irOp(wasm.Op_i32_add):
+			r[in.a] = uint64(uint32(r[in.b]) + uint32(r[in.c]))
+		case irOp(wasm.Op_i32_sub):
+			r[in.a] = uint64(uint32(r[in.b]) - uint32(r[in.c]))
+		case irOp(wasm.Op_i32_mul):
+			r[in.a] = uint64(uint32(r[in.b]) * uint32(r[in.c]))
+		case irOp(wasm.Op_i32_and):
+			r[in.a] = uint64(uint32(r[in.b]) & uint32(r[in.c]))
+		case irOp(wasm.Op_i32_or):
+			r[in.a] = uint64(uint32(r[in.b]) | uint32(r[in.c]))
+		case irOp(wasm.Op_i32_xor):
+			r[in.a] = uint64(uint32(r[in.b]) ^ uint32(r[in.c]))
+		case irOp(wasm.Op_i32_shl):
+			r[in.a] = uint64(uint32(r[in.b]) << (r[in.c] & 31))
+		case irOp(wasm.Op_i32_shr_u):
+			r[in.a] = uint64(uint32(r[in.b]) >> (r[in.c] & 31))
+		case irOp(wasm.Op_i64_eqz):
+			r[in.a] = b2u(r[in.b] == 0)
+		case irOp(wasm.Op_i64_lt_s):
+			r[in.a] = b2u(int64(r[in.b]) < int64(r[in.c]))
+		case irOp(wasm.Op_i64_add):
+			r[in.a] = r[in.b] + r[in.c]
+		case irOp(wasm.Op_i64_sub):
+			r[in.a] = r[in.b] - r[in.c]
+		case irOp(wasm.Op_i64_mul):
+			r[in.a] = r[in.b] * r[in.c]
+		case irOp(wasm.Op_f64_add):
+			r[in.a] = math.Float64bits(f64(r[in.b]) + f64(r[in.c]))
+		case irOp(wasm.Op_f64_sub):
+			r[in.a] = math.Float64bits(f64(r[in.b]) - f64(r[in.c]))
+		case irOp(wasm.Op_f64_mul):
+			r[in.a] = math.Float64bits(f64(r[in.b]) * f64(r[in.c]))
+
+		default:
+			op := wasm.Opcode(in.op)
+			if isUnary(op) {
+				r[in.a] = unop(op, r[in.b])
+				break
+			}
+			r[in.a] = binop(op, r[in.b], r[in.c])
+		}
+	}
+}
+
+// callIR calls the function f, whose arguments are in the operand stack
+// starting at base.
+// Functions translated to the internal representation are entered, and
+// callIR reports true. The others are called to completion, and store
+// their results at base.
+func (m *machine) callIR(f *Function, base int) bool {
+	switch {
+	case f.host != nil:
+		m.callHost(f, base)
+	case f.body.ir == nil:
+		m.callNested(f, base)
+	default:
+		m.enterIR(f, base)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,395 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "encoding/binary"
+
+// General purpose registers, numbered as in their encoding.
+const (
+	rax = 0
+	rcx = 1
+	rdx = 2
+	rsp = 4
+	rbp = 5
+	rdi = 7
+	r12 = 12
+	r13 = 13
+	r15 = 15
+)
+
+// SSE registers.
+const (
+	xmm0 = 0
+	xmm1 = 1
+)
+
+// Condition codes of the conditional jumps, moves and sets.
+const (
+	ccB  = 0x2 // below
+	ccAE = 0x3 // above or equal
+	ccE  = 0x4 // equal
+	ccNE = 0x5 // not equal
+	ccBE = 0x6 // below or equal
+	ccA  = 0x7 // above
+	ccP  = 0xa // parity
+	ccNP = 0xb // no parity
+	ccL  = 0xc // less
+	ccGE = 0xd // greater or equal
+	ccLE = 0xe // less or equal
+	ccG  = 0xf // greater
+)
+
+// mem is a memory operand, addressing base+index<<scale+disp.
+type mem struct {
+	base  int
+	index int // -1 if none
+	scale int
+	disp  int32
+}
+
+// at returns the memory operand addressing base+disp.
+func at(base int, disp int32) mem {
+	return mem{base: base, index: -1, disp: disp}
+}
+
+// asmLabel is a position in the machine code, the target of jumps and
+// calls.
+type asmLabel struct {
+	pos  int   // offset of the label, or -1 if it is not bound yet
+	refs []int // offsets of the 32-bit displacements to patch when bound
+}
+
+func newLabel() *asmLabel {
+	return &asmLabel{pos: -1}
+}
+
+// assembler encodes amd64 instructions.
+// Memory operands are always encoded with a 32-bit displacement.
+type assembler struct {
+	code []byte
+}
+
+func (a *assembler) emit(b ...byte) {
+	a.code = append(a.code, b...)
+}
+
+func (a *assembler) imm32(v int32) {
+	a.code = binary.LittleEndian.AppendUint32(a.code, uint32(v))
+}
+
+func (a *assembler) imm64(v uint64) {
+	a.code = binary.LittleEndian.AppendUint64(a.code, v)
+}
+
+// rex emits the REX prefix of an instruction operating on 64 bits if w is
+// set, with the register r in its reg field, and the index x and base b of
+// its memory operand, or the register b in its r/m field. It is omitted if
+// it is not needed.
+func (a *assembler) rex(w bool, r, x, b int) {
+	p := byte(0x40)
+	if w {
+		p |= 8
+	}
+	p |= byte(r>>3&1)<<2 | byte(x>>3&1)<<1 | byte(b>>3&1)
+	if p != 0x40 {
+		a.emit(p)
+	}
+}
+
+// opm emits the instruction with the mandatory prefix pfx (if not zero)
+// and the opcode op, operating on the register or opcode extension r and
+// the memory operand m.
+func (a *assembler) opm(pfx byte, w bool, op []byte, r int, m mem) {
+	if pfx != 0 {
+		a.emit(pfx)
+	}
+	x := 0
+	if m.index >= 0 {
+		x = m.index
+	}
+	a.rex(w, r, x, m.base)
+	a.emit(op...)
+	if m.index < 0 && m.base&7 != rsp {
+		a.emit(0x80 | byte(r&7)<<3 | byte(m.base&7))
+	} else {
+		index := rsp // no index
+		if m.index >= 0 {
+			index = m.index
+		}
+		a.emit(0x80|byte(r&7)<<3|rsp, byte(m.scale)<<6|byte(index&7)<<3|byte(m.base&7))
+	}
+	a.imm32(m.disp)
+}
+
+// opr is like opm with the register rm instead of a memory operand.
+func (a *assembler) opr(pfx byte, w bool, op []byte, r, rm int) {
+	if pfx != 0 {
+		a.emit(pfx)
+	}
+	a.rex(w, r, 0, rm)
+	a.emit(op...)
+	a.emit(0xc0 | byte(r&7)<<3 | byte(rm&7))
+}
+
+// load loads the 32- or 64-bit value at m in the register r.
+func (a *assembler) load(w bool, r int, m mem) { a.opm(0, w, []byte{0x8b}, r, m) }
+
+// store stores the 32- or 64-bit register r at m.
+func (a *assembler) store(w bool, m mem, r int) { a.opm(0, w, []byte{0x89}, r, m) }
+
+// lea loads the address m in r.
+func (a *assembler) lea(r int, m mem) { a.opm(0, true, []byte{0x8d}, r, m) }
+
+// movImm sets the register r to v, zero-extended if it fits in 32 bits.
+func (a *assembler) movImm(r int, v uint64) {
+	if v <= 0xffffffff {
+		a.rex(false, 0, 0, r)
+		a.emit(0xb8 + byte(r&7))
+		a.imm32(int32(uint32(v)))
+		return
+	}
+	a.rex(true, 0, 0, r)
+	a.emit(0xb8 + byte(r&7))
+	a.imm64(v)
+}
+
+// storeImm stores the 32-bit value v, sign-extended to 64 bits, at m.
+func (a *assembler) storeImm(m mem, v int32) {
+	a.opm(0, true, []byte{0xc7}, 0, m)
+	a.imm32(v)
+}
+
+// Opcodes of the arithmetic instructions operating on a register and a
+// register or memory operand, and extensions of their forms with an
+// immediate operand.
+const (
+	aluAdd = 0x03
+	aluOr  = 0x0b
+	aluAnd = 0x23
+	aluSub = 0x2b
+	aluXor = 0x33
+	aluCmp = 0x3b
+)
+
+// aluExt returns the opcode extension of the form of the arithmetic
+// instruction op with an immediate operand.
+func aluExt(op byte) int {
+	return int(op >> 3)
+}
+
+// alu applies the arithmetic instruction op to the register r and the
+// value at m.
+func (a *assembler) alu(op byte, w bool, r int, m mem) { a.opm(0, w, []byte{op}, r, m) }
+
+// aluReg applies the arithmetic instruction op to the registers r and rm.
+func (a *assembler) aluReg(op byte, w bool, r, rm int) { a.opr(0, w, []byte{op}, r, rm) }
+
+// aluImm applies the arithmetic instruction op to the register r and the
+// immediate v.
+func (a *assembler) aluImm(op byte, w bool, r int, v int32) {
+	a.opr(0, w, []byte{0x81}, aluExt(op), r)
+	a.imm32(v)
+}
+
+// aluMemImm applies the arithmetic instruction op to the 64-bit value at m
+// and the immediate v.
+func (a *assembler) aluMemImm(op byte, m mem, v int8) {
+	a.opm(0, true, []byte{0x83}, aluExt(op), m)
+	a.emit(byte(v))
+}
+
+// imul multiplies the register r by the value at m.
+func (a *assembler) imul(w bool, r int, m mem) { a.opm(0, w, []byte{0x0f, 0xaf}, r, m) }
+
+// Opcode extensions of the shifts and rotations.
+const (
+	shRol = 0
+	shRor = 1
+	shShl = 4
+	shShr = 5
+	shSar = 7
+)
+
+// shift shifts or rotates the register r by cl.
+func (a *assembler) shift(ext int, w bool, r int) { a.opr(0, w, []byte{0xd3}, ext, r) }
+
+// shiftImm shifts or rotates the register r by n.
+func (a *assembler) shiftImm(ext int, w bool, r int, n byte) {
+	a.opr(0, w, []byte{0xc1}, ext, r)
+	a.emit(n)
+}
+
+// div divides rdx:rax, or edx:eax, by the register r, signed if signed is
+// set.
+func (a *assembler) div(w, signed bool, r int) {
+	ext := 6
+	if signed {
+		ext = 7
+	}
+	a.opr(0, w, []byte{0xf7}, ext, r)
+}
+
+// signExtend sign-extends rax, or eax, into rdx, or edx (cqo or cdq).
+func (a *assembler) signExtend(w bool) {
+	a.rex(w, 0, 0, 0)
+	a.emit(0x99)
+}
+
+// test sets the flags according to r&rm.
+func (a *assembler) test(w bool, r, rm int) { a.opr(0, w, []byte{0x85}, r, rm) }
+
+// setcc sets the register r to 1 if the condition cc holds, 0 otherwise.
+func (a *assembler) setcc(cc byte, r int) {
+	a.opr(0, false, []byte{0x0f, 0x90 + cc}, 0, r)
+	a.opr(0, false, []byte{0x0f, 0xb6}, r, r) // movzx
+}
+
+// cmov moves rm to r if the condition cc holds.
+func (a *assembler) cmov(cc byte, w bool, r, rm int) { a.opr(0, w, []byte{0x0f, 0x40 + cc}, r, rm) }
+
+// loadExt loads the value at m in r, extended with the two-byte opcode
+// 0x0f op (movzx or movsx), or with movsxd if op is zero.
+func (a *assembler) loadExt(w bool, op byte, r int, m mem) {
+	if op == 0 {
+		a.opm(0, true, []byte{0x63}, r, m)
+		return
+	}
+	a.opm(0, w, []byte{0x0f, op}, r, m)
+}
+
+// storeN stores the n low bytes of the register r at m.
+func (a *assembler) storeN(n int, m mem, r int) {
+	switch n {
+	case 1:
+		a.opm(0, false, []byte{0x88}, r, m)
+	case 2:
+		a.opm(0x66, false, []byte{0x89}, r, m)
+	case 4:
+		a.store(false, m, r)
+	default:
+		a.store(true, m, r)
+	}
+}
+
+// sse emits the scalar SSE instruction 0x0f op, operating on single
+// precision values if single is set, double precision ones otherwise, with
+// the register x and the memory operand m.
+func (a *assembler) sse(single bool, op byte, x int, m mem) {
+	pfx := byte(0xf2)
+	if single {
+		pfx = 0xf3
+	}
+	a.opm(pfx, false, []byte{0x0f, op}, x, m)
+}
+
+// Opcodes of the scalar SSE instructions.
+const (
+	sseLoad  = 0x10
+	sseStore = 0x11
+	sseSqrt  = 0x51
+	sseAdd   = 0x58
+	sseMul   = 0x59
+	sseCvt   = 0x5a // cvtss2sd or cvtsd2ss
+	sseSub   = 0x5c
+	sseDiv   = 0x5e
+)
+
+// cvtsi converts the 32- or 64-bit signed integer at m to a floating-point
+// value in x.
+func (a *assembler) cvtsi(single, w bool, x int, m mem) {
+	pfx := byte(0xf2)
+	if single {
+		pfx = 0xf3
+	}
+	a.opm(pfx, w, []byte{0x0f, 0x2a}, x, m)
+}
+
+// cvtsiReg is like cvtsi with the register r.
+func (a *assembler) cvtsiReg(single, w bool, x, r int) {
+	pfx := byte(0xf2)
+	if single {
+		pfx = 0xf3
+	}
+	a.opr(pfx, w, []byte{0x0f, 0x2a}, x, r)
+}
+
+// ucomis compares the floating-point value in x to the one at m.
+func (a *assembler) ucomis(single bool, x int, m mem) {
+	pfx := byte(0x66)
+	if single {
+		pfx = 0
+	}
+	a.opm(pfx, false, []byte{0x0f, 0x2e}, x, m)
+}
+
+// movdFrom moves the low 32 bits of x to r, zero-extended.
+func (a *assembler) movdFrom(r, x int) { a.opr(0x66, false, []byte{0x0f, 0x7e}, x, r) }
+
+// pushReg pushes the register r.
+func (a *assembler) pushReg(r int) {
+	a.rex(false, 0, 0, r)
+	a.emit(0x50 + byte(r&7))
+}
+
+// popReg pops the register r.
+func (a *assembler) popReg(r int) {
+	a.rex(false, 0, 0, r)
+	a.emit(0x58 + byte(r&7))
+}
+
+func (a *assembler) ret() { a.emit(0xc3) }
+
+// rel32 emits the 32-bit displacement from the end of the instruction to
+// the label l.
+func (a *assembler) rel32(l *asmLabel) {
+	if l.pos >= 0 {
+		a.imm32(int32(l.pos - len(a.code) - 4))
+		return
+	}
+	l.refs = append(l.refs, len(a.code))
+	a.imm32(0)
+}
+
+// bind binds the label l to the current position.
+func (a *assembler) bind(l *asmLabel) {
+	l.pos = len(a.code)
+	for _, ref := range l.refs {
+		binary.LittleEndian.PutUint32(a.code[ref:], uint32(int32(l.pos-ref-4)))
+	}
+	l.refs = nil
+}
+
+func (a *assembler) jmp(l *asmLabel) {
+	a.emit(0xe9)
+	a.rel32(l)
+}
+
+func (a *assembler) jcc(cc byte, l *asmLabel) {
+	a.emit(0x0f, 0x80+cc)
+	a.rel32(l)
+}
+
+// skip jumps over the next n bytes if the condition cc holds.
+func (a *assembler) skip(cc byte, n byte) {
+	a.emit(0x70+cc, n)
+}
+
+func (a *assembler) call(l *asmLabel) {
+	a.emit(0xe8)
+	a.rel32(l)
+}
+
+// callReg calls the address in the register r.
+func (a *assembler) callReg(r int) { a.opr(0, false, []byte{0xff}, 2, r) }
+
+// callMem calls the address at m.
+func (a *assembler) callMem(m mem) { a.opm(0, false, []byte{0xff}, 2, m) }
+
+// repStos stores rax, or eax, rcx times at rdi.
+func (a *assembler) repStos(w bool) {
+	a.emit(0xf3)
+	a.rex(w, 0, 0, 0)
+	a.emit(0xab)
+}
@@ -0,0 +1,1050 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// The machine code of a function operates on the slots of its frame in the
+// value stack: its locals, parameters first, then its operand stack. The
+// operands and the destination of an instruction live at fixed slots,
+// determined by the height of the operand stack.
+//
+// Registers:
+//
+//	r15  context of the call (*jitContext)
+//	r12  frame of the function, slot i at r12+8*i
+//	r13  base address of the memory
+//	rsp  native stack, holding the return addresses and the saved frames
+//
+// A call pushes the frame of its caller, points r12 to its arguments, which
+// are the first slots of the frame of the callee, and calls the callee,
+// which stores its results in the same slots.
+// Other registers are scratch registers.
+//
+// The machine code exits to Go, for anything it does not implement itself,
+// by calling a stub storing the cause of the exit in the context and
+// switching back to the Go stack (see jitEnter). Go resumes the execution
+// by switching back to the native stack and returning from the call.
+//
+// The accesses to the memory are not checked: those beyond the memory
+// fault on the guard pages of its reservation, and the signal handler
+// turns the faults into calls to the trap stub (see jitSignal).
+
+// Offsets of the fields of the context.
+var (
+	ctxSP       = at(r15, int32(unsafe.Offsetof(jitContext{}.sp)))
+	ctxFrame    = at(r15, int32(unsafe.Offsetof(jitContext{}.frame)))
+	ctxGoSP     = at(r15, int32(unsafe.Offsetof(jitContext{}.goSP)))
+	ctxGoBP     = at(r15, int32(unsafe.Offsetof(jitContext{}.goBP)))
+	ctxMemLen   = at(r15, int32(unsafe.Offsetof(jitContext{}.memLen)))
+	ctxStatus   = at(r15, int32(unsafe.Offsetof(jitContext{}.status)))
+	ctxArg      = at(r15, int32(unsafe.Offsetof(jitContext{}.arg)))
+	ctxSlots    = at(r15, int32(unsafe.Offsetof(jitContext{}.slots)))
+	ctxTarget   = at(r15, int32(unsafe.Offsetof(jitContext{}.target)))
+	ctxGlobals  = at(r15, int32(unsafe.Offsetof(jitContext{}.globals)))
+	ctxTable    = at(r15, int32(unsafe.Offsetof(jitContext{}.table)))
+	ctxTableLen = at(r15, int32(unsafe.Offsetof(jitContext{}.tableLen)))
+	ctxInst     = at(r15, int32(unsafe.Offsetof(jitContext{}.inst)))
+	ctxStackLo  = at(r15, int32(unsafe.Offsetof(jitContext{}.stackLo)))
+	ctxSlotsHi  = at(r15, int32(unsafe.Offsetof(jitContext{}.slotsHi)))
+	ctxDepth    = at(r15, int32(unsafe.Offsetof(jitContext{}.depth)))
+	ctxBudget   = at(r15, int32(unsafe.Offsetof(jitContext{}.budget)))
+)
+
+// Offsets of the fields read by indirect calls.
+var (
+	offFuncInst = int32(unsafe.Offsetof(Function{}.inst))
+	offFuncBody = int32(unsafe.Offsetof(Function{}.body))
+	offBodyJIT  = int32(unsafe.Offsetof(body{}.jit))
+	offJITEntry = int32(unsafe.Offsetof(jitFunc{}.entry))
+	offJITSig   = int32(unsafe.Offsetof(jitFunc{}.sig))
+)
+
+// jitCompiler compiles the function bodies of a module to machine code.
+type jitCompiler struct {
+	assembler
+
+	types    []wasm.FuncType
+	sigs     []int64         // identifiers of the types, see jitFunc.sig
+	ftypes   []wasm.FuncType // types of the functions, imported ones first
+	nimports int             // number of imported functions
+	entries  []*asmLabel     // entries of the defined functions
+	exit     *asmLabel       // switch to the Go stack
+	exits    [jitNumExits]*asmLabel
+	traps    [len(jitTraps)]*asmLabel
+	thunk    int // offset of the entry thunk
+	thunkRet int // offset of the return address of the calls made by the thunk
+	sites    []jitSite
+
+	// function being compiled
+	fn      uint32
+	nlocals int // number of locals, parameters included
+	height  int // height of the operand stack
+	max     int // maximum height of the operand stack
+	blocks  []jitBlock
+	dead    bool // whether the code being compiled is unreachable
+	at      int  // offset of the instruction being compiled
+}
+
+// jitBlock is a structured instruction being compiled.
+type jitBlock struct {
+	op      wasm.Opcode
+	height  int       // height of the operand stack below the block operands
+	params  int       // number of parameters of the block
+	results int       // number of results of the block
+	arity   int       // number of values carried by a branch to the block
+	label   *asmLabel // target of the branches to the block
+	els     *asmLabel // start of the else branch of an if, or nil
+	dead    bool      // whether the block is unreachable
+}
+
+// compileJIT compiles the function bodies of c to machine code.
+func compileJIT(c *CompiledModule) error {
+	jc := &jitCompiler{
+		types:    c.types,
+		ftypes:   c.ftypes,
+		nimports: len(c.ftypes) - len(c.bodies),
+	}
+	// identical types share the index of the first of them.
+	for i, ft := range c.types {
+		sig := int64(i)
+		for j := range i {
+			if sameType(c.types[j], ft) {
+				sig = int64(j)
+				break
+			}
+		}
+		jc.sigs = append(jc.sigs, sig)
+	}
+	jc.stubs()
+	for range c.bodies {
+		jc.entries = append(jc.entries, newLabel())
+	}
+	for i, b := range c.bodies {
+		if err := jc.function(i, b); err != nil {
+			return fmt.Errorf("exec: function %d: %w", jc.nimports+i, err)
+		}
+	}
+
+	mod, err := newJITModule(jc.code, jc.sites)
+	if err != nil {
+		return err
+	}
+	mod.thunk = mod.addr + uintptr(jc.thunk)
+	mod.thunkRet = mod.addr + uintptr(jc.thunkRet)
+	mod.fault = mod.addr + uintptr(jc.traps[jitTrapOutOfBoundsMemory].pos)
+	for i, b := range c.bodies {
+		b.jit = &jitFunc{
+			entry:  mod.addr + uintptr(jc.entries[i].pos),
+			sig:    jc.sigs[c.funcs[i]],
+			module: mod,
+		}
+	}
+	return nil
+}
+
+// stubs emits the entry thunk and the exit stubs shared by the functions.
+func (c *jitCompiler) stubs() {
+	c.exit = newLabel()
+	for i := range c.exits {
+		c.exits[i] = newLabel()
+	}
+	for i := range c.traps {
+		c.traps[i] = newLabel()
+	}
+
+	// the thunk calls the function at ctx.target, and exits when it
+	// returns.
+	c.thunk = len(c.code)
+	c.callMem(ctxTarget)
+	c.thunkRet = len(c.code)
+	c.storeImm(ctxStatus, jitExitReturn)
+	c.call(c.exit)
+
+	// the exit saves the state of the machine code, whose return address
+	// is on top of the native stack, and returns from jitEnter.
+	c.bind(c.exit)
+	c.store(true, ctxSP, rsp)
+	c.store(true, ctxFrame, r12)
+	c.load(true, rsp, ctxGoSP)
+	c.load(true, rbp, ctxGoBP)
+	c.ret()
+
+	for status := jitExitReturn + 1; status < jitNumExits; status++ {
+		if status == jitExitTrap {
+			continue
+		}
+		c.bind(c.exits[status])
+		c.storeImm(ctxStatus, int32(status))
+		c.jmp(c.exit)
+	}
+	for i := range jitTraps {
+		c.bind(c.traps[i])
+		c.storeImm(ctxStatus, jitExitTrap)
+		c.storeImm(ctxArg, int32(i))
+		c.jmp(c.exit)
+	}
+}
+
+// site records the call ending at the current position, made by the
+// instruction being compiled, or by the prologue of the function if
+// hidden is set.
+func (c *jitCompiler) site(hidden bool) {
+	c.sites = append(c.sites, jitSite{
+		ret:    uint32(len(c.code)),
+		fn:     c.fn,
+		at:     c.at,
+		hidden: hidden,
+	})
+}
+
+// exitTo exits with the provided status.
+func (c *jitCompiler) exitTo(status int) {
+	c.call(c.exits[status])
+	c.site(false)
+}
+
+// trapIf traps with the trap t of jitTraps if the condition cc holds.
+func (c *jitCompiler) trapIf(cc byte, t int, hidden bool) {
+	c.skip(cc^1, 5) // size of the call
+	c.call(c.traps[t])
+	c.site(hidden)
+}
+
+// poll exits every jitPollInterval calls and loop iterations, to check
+// whether the call was cancelled.
+func (c *jitCompiler) poll(hidden bool) {
+	c.aluMemImm(aluSub, ctxBudget, 1)
+	c.skip(ccG, 5) // size of the call
+	c.call(c.exits[jitExitYield])
+	c.site(hidden)
+}
+
+// slot returns the slot of the operand at height h.
+func (c *jitCompiler) slot(h int) mem {
+	return at(r12, int32(8*(c.nlocals+h)))
+}
+
+// local returns the slot of the local i.
+func (c *jitCompiler) local(i uint32) mem {
+	return at(r12, int32(8*i))
+}
+
+// push pushes an operand and returns its slot.
+func (c *jitCompiler) push() mem {
+	c.height++
+	if c.height > c.max {
+		c.max = c.height
+	}
+	return c.slot(c.height - 1)
+}
+
+// pop pops an operand and returns its slot.
+func (c *jitCompiler) pop() mem {
+	c.height--
+	return c.slot(c.height)
+}
+
+// top returns the slot of the operand on top of the stack.
+func (c *jitCompiler) top() mem {
+	return c.slot(c.height - 1)
+}
+
+// move copies the slot src to dst.
+func (c *jitCompiler) move(dst, src mem) {
+	if dst == src {
+		return
+	}
+	c.load(true, rcx, src)
+	c.store(true, dst, rcx)
+}
+
+// storeConst stores the constant v at m.
+func (c *jitCompiler) storeConst(m mem, v uint64) {
+	if int64(v) == int64(int32(v)) {
+		c.storeImm(m, int32(v))
+		return
+	}
+	c.movImm(rax, v)
+	c.store(true, m, rax)
+}
+
+// function compiles the body b of the i-th defined function.
+func (c *jitCompiler) function(i int, b *body) error {
+	ft := c.ftypes[c.nimports+i]
+	c.fn = uint32(c.nimports + i)
+	c.nlocals = len(ft.Params) + b.nlocals
+	c.height, c.max, c.dead, c.at = 0, 0, false, 0
+	c.bind(c.entries[i])
+
+	// prologue: check the limits of the call, then zero the locals.
+	c.aluMemImm(aluSub, ctxDepth, 1)
+	c.trapIf(ccL, jitTrapExhausted, true)
+	c.lea(rax, at(r12, 0))
+	frameEnd := len(c.code) - 4 // patched with the size of the frame
+	c.alu(aluCmp, true, rax, ctxSlotsHi)
+	c.trapIf(ccA, jitTrapExhausted, true)
+	c.alu(aluCmp, true, rsp, ctxStackLo)
+	c.trapIf(ccB, jitTrapExhausted, true)
+	if n := b.nlocals; n > 0 {
+		c.aluReg(aluXor, false, rax, rax)
+		if n <= 8 {
+			for j := 0; j < n; j++ {
+				c.store(true, c.local(uint32(len(ft.Params)+j)), rax)
+			}
+		} else {
+			c.lea(rdi, c.local(uint32(len(ft.Params))))
+			c.movImm(rcx, uint64(n))
+			c.repStos(true)
+		}
+	}
+	c.poll(true)
+
+	c.blocks = append(c.blocks[:0], jitBlock{
+		results: len(ft.Results),
+		arity:   len(ft.Results),
+		label:   newLabel(),
+	})
+	code := b.code
+	for pc := 0; pc < len(code); {
+		c.at = pc
+		op := wasm.Opcode(code[pc])
+		var err error
+		if pc, err = c.instr(op, code, pc+1); err != nil {
+			return fmt.Errorf("offset %#x: %w", c.at, err)
+		}
+	}
+	if len(c.blocks) != 0 {
+		return fmt.Errorf("unterminated block")
+	}
+	size := 8 * (c.nlocals + c.max)
+	c.code[frameEnd] = byte(size)
+	c.code[frameEnd+1] = byte(size >> 8)
+	c.code[frameEnd+2] = byte(size >> 16)
+	c.code[frameEnd+3] = byte(size >> 24)
+	return nil
+}
+
+// blockType decodes the block type at offset pc and returns the number of
+// parameters and results of the block.
+func (c *jitCompiler) blockType(code []byte, pc int) (params, results, next int) {
+	bt, next := instr.ReadS64(code, pc)
+	switch {
+	case bt == -0x40:
+		return 0, 0, next
+	case bt < 0:
+		return 0, 1, next
+	}
+	ft := c.types[bt]
+	return len(ft.Params), len(ft.Results), next
+}
+
+// leave returns from the function, with its results on top of the operand
+// stack.
+func (c *jitCompiler) leave() {
+	n := c.blocks[0].results
+	for j := 0; j < n; j++ {
+		c.move(c.local(uint32(j)), c.slot(c.height-n+j))
+	}
+	c.aluMemImm(aluAdd, ctxDepth, 1)
+	c.ret()
+}
+
+// branch branches to the block at the provided depth, polling on the
+// iterations of loops.
+func (c *jitCompiler) branch(depth uint32) {
+	i := len(c.blocks) - 1 - int(depth)
+	if i == 0 {
+		c.leave()
+		return
+	}
+	b := &c.blocks[i]
+	if b.op == wasm.Op_loop {
+		c.poll(false)
+	}
+	for j := 0; j < b.arity; j++ {
+		c.move(c.slot(b.height+j), c.slot(c.height-b.arity+j))
+	}
+	c.jmp(b.label)
+}
+
+// instr compiles the instruction op, whose immediates start at offset pc,
+// and returns the offset of the next instruction.
+func (c *jitCompiler) instr(op wasm.Opcode, code []byte, pc int) (int, error) {
+	switch op {
+	case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+		var params, results int
+		params, results, pc = c.blockType(code, pc)
+		b := jitBlock{
+			op:      op,
+			params:  params,
+			results: results,
+			arity:   results,
+			label:   newLabel(),
+			dead:    c.dead,
+		}
+		if !c.dead {
+			switch op {
+			case wasm.Op_loop:
+				b.arity = params
+				c.bind(b.label)
+			case wasm.Op_if:
+				c.load(false, rax, c.pop())
+				c.test(false, rax, rax)
+				b.els = newLabel()
+				c.jcc(ccE, b.els)
+			}
+			b.height = c.height - params
+		}
+		c.blocks = append(c.blocks, b)
+		return pc, nil
+
+	case wasm.Op_else:
+		b := &c.blocks[len(c.blocks)-1]
+		if b.dead {
+			return pc, nil
+		}
+		if !c.dead {
+			c.jmp(b.label)
+		}
+		c.bind(b.els)
+		b.els = nil
+		c.dead = false
+		c.height = b.height + b.params
+		return pc, nil
+
+	case wasm.Op_end:
+		if len(c.blocks) == 1 {
+			// end of the function.
+			if !c.dead {
+				c.leave()
+			}
+			c.blocks = c.blocks[:0]
+			return pc, nil
+		}
+		b := c.blocks[len(c.blocks)-1]
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		if b.dead {
+			return pc, nil
+		}
+		if b.els != nil {
+			c.bind(b.els)
+		}
+		if b.op != wasm.Op_loop {
+			c.bind(b.label)
+		}
+		c.dead = false
+		c.height = b.height + b.results
+		return pc, nil
+	}
+
+	if c.dead {
+		return instr.Skip(op, code, pc)
+	}
+
+	switch op {
+	case wasm.Op_unreachable:
+		c.call(c.traps[jitTrapUnreachable])
+		c.site(false)
+		c.dead = true
+
+	case wasm.Op_nop:
+
+	case wasm.Op_br:
+		var depth uint32
+		depth, pc = instr.ReadU32(code, pc)
+		c.branch(depth)
+		c.dead = true
+
+	case wasm.Op_br_if:
+		var depth uint32
+		depth, pc = instr.ReadU32(code, pc)
+		c.load(false, rax, c.pop())
+		c.test(false, rax, rax)
+		next := newLabel()
+		c.jcc(ccE, next)
+		c.branch(depth)
+		c.bind(next)
+
+	case wasm.Op_br_table:
+		var n uint32
+		n, pc = instr.ReadU32(code, pc)
+		c.load(false, rax, c.pop())
+		for j := uint32(0); j <= n; j++ {
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			if j == n {
+				c.branch(depth)
+				break
+			}
+			next := newLabel()
+			c.aluImm(aluCmp, false, rax, int32(j))
+			c.jcc(ccNE, next)
+			c.branch(depth)
+			c.bind(next)
+		}
+		c.dead = true
+
+	case wasm.Op_return:
+		c.leave()
+		c.dead = true
+
+	case wasm.Op_call:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		ft := c.ftypes[idx]
+		base := c.height - len(ft.Params)
+		if int(idx) < c.nimports {
+			c.lea(rax, c.slot(base))
+			c.store(true, ctxSlots, rax)
+			c.storeImm(ctxArg, int32(idx))
+			c.exitTo(jitExitCall)
+		} else {
+			c.pushReg(r12)
+			c.lea(r12, c.slot(base))
+			c.call(c.entries[int(idx)-c.nimports])
+			c.site(false)
+			c.popReg(r12)
+		}
+		c.height = base
+		for range ft.Results {
+			c.push()
+		}
+
+	case wasm.Op_call_indirect:
+		var typ uint32
+		typ, pc = instr.ReadU32(code, pc)
+		_, pc = instr.ReadU32(code, pc)
+		ft := c.types[typ]
+		idx := c.pop()
+		base := c.height - len(ft.Params)
+		slow, done := newLabel(), newLabel()
+
+		// functions of the instance compiled to machine code with the
+		// expected type are called directly, other calls exit.
+		c.load(false, rax, idx)
+		c.alu(aluCmp, true, rax, ctxTableLen)
+		c.jcc(ccAE, slow)
+		c.load(true, rcx, ctxTable)
+		c.load(true, rcx, mem{base: rcx, index: rax, scale: 3})
+		c.test(true, rcx, rcx)
+		c.jcc(ccE, slow)
+		c.load(true, rdx, ctxInst)
+		c.alu(aluCmp, true, rdx, at(rcx, offFuncInst))
+		c.jcc(ccNE, slow)
+		c.load(true, rcx, at(rcx, offFuncBody))
+		c.test(true, rcx, rcx)
+		c.jcc(ccE, slow)
+		c.load(true, rcx, at(rcx, offBodyJIT))
+		c.test(true, rcx, rcx)
+		c.jcc(ccE, slow)
+		c.load(true, rdx, at(rcx, offJITSig))
+		c.aluImm(aluCmp, true, rdx, int32(c.sigs[typ]))
+		c.jcc(ccNE, slow)
+		c.load(true, rcx, at(rcx, offJITEntry))
+		c.pushReg(r12)
+		c.lea(r12, c.slot(base))
+		c.callReg(rcx)
+		c.site(false)
+		c.popReg(r12)
+		c.jmp(done)
+
+		c.bind(slow)
+		c.lea(rax, c.slot(base))
+		c.store(true, ctxSlots, rax)
+		c.storeImm(ctxArg, int32(typ))
+		c.exitTo(jitExitCallIndirect)
+		c.bind(done)
+
+		c.height = base
+		for range ft.Results {
+			c.push()
+		}
+
+	case wasm.Op_drop:
+		c.pop()
+
+	case wasm.Op_select:
+		cond, b := c.pop(), c.pop()
+		a := c.top()
+		c.load(true, rax, a)
+		c.load(true, rcx, b)
+		c.load(false, rdx, cond)
+		c.test(false, rdx, rdx)
+		c.cmov(ccE, true, rax, rcx)
+		c.store(true, a, rax)
+
+	case wasm.Op_get_local:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		c.move(c.push(), c.local(idx))
+
+	case wasm.Op_set_local:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		c.move(c.local(idx), c.pop())
+
+	case wasm.Op_tee_local:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		c.move(c.local(idx), c.top())
+
+	case wasm.Op_get_global:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		c.load(true, rax, ctxGlobals)
+		c.load(true, rax, at(rax, int32(8*idx)))
+		c.load(true, rcx, at(rax, 0))
+		c.store(true, c.push(), rcx)
+
+	case wasm.Op_set_global:
+		var idx uint32
+		idx, pc = instr.ReadU32(code, pc)
+		c.load(true, rax, ctxGlobals)
+		c.load(true, rax, at(rax, int32(8*idx)))
+		c.load(true, rcx, c.pop())
+		c.store(true, at(rax, 0), rcx)
+
+	case wasm.Op_i32_load, wasm.Op_i64_load, wasm.Op_f32_load, wasm.Op_f64_load,
+		wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i32_load16_s, wasm.Op_i32_load16_u,
+		wasm.Op_i64_load8_s, wasm.Op_i64_load8_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+		wasm.Op_i64_load32_s, wasm.Op_i64_load32_u:
+		var off uint32
+		_, pc = instr.ReadU32(code, pc)
+		off, pc = instr.ReadU32(code, pc)
+		a := c.top()
+		src := c.address(a, off)
+		switch op {
+		case wasm.Op_i32_load, wasm.Op_f32_load, wasm.Op_i64_load32_u:
+			c.load(false, rax, src)
+		case wasm.Op_i64_load, wasm.Op_f64_load:
+			c.load(true, rax, src)
+		case wasm.Op_i32_load8_s:
+			c.loadExt(false, 0xbe, rax, src)
+		case wasm.Op_i32_load8_u, wasm.Op_i64_load8_u:
+			c.loadExt(false, 0xb6, rax, src)
+		case wasm.Op_i32_load16_s:
+			c.loadExt(false, 0xbf, rax, src)
+		case wasm.Op_i32_load16_u, wasm.Op_i64_load16_u:
+			c.loadExt(false, 0xb7, rax, src)
+		case wasm.Op_i64_load8_s:
+			c.loadExt(true, 0xbe, rax, src)
+		case wasm.Op_i64_load16_s:
+			c.loadExt(true, 0xbf, rax, src)
+		case wasm.Op_i64_load32_s:
+			c.loadExt(true, 0, rax, src)
+		}
+		c.store(true, a, rax)
+
+	case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+		wasm.Op_i32_store8, wasm.Op_i32_store16,
+		wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+		var off uint32
+		_, pc = instr.ReadU32(code, pc)
+		off, pc = instr.ReadU32(code, pc)
+		v := c.pop()
+		c.load(true, rdx, v)
+		dst := c.address(c.pop(), off)
+		c.storeN(accessSize(op), dst, rdx)
+
+	case wasm.Op_current_memory:
+		_, pc = instr.ReadU32(code, pc)
+		c.load(true, rax, ctxMemLen)
+		c.shiftImm(shShr, true, rax, 16)
+		c.store(true, c.push(), rax)
+
+	case wasm.Op_grow_memory:
+		_, pc = instr.ReadU32(code, pc)
+		c.lea(rax, c.top())
+		c.store(true, ctxSlots, rax)
+		c.exitTo(jitExitGrow)
+
+	case wasm.Op_i32_const:
+		var v int32
+		v, pc = instr.ReadS32(code, pc)
+		c.storeConst(c.push(), uint64(uint32(v)))
+
+	case wasm.Op_i64_const:
+		var v int64
+		v, pc = instr.ReadS64(code, pc)
+		c.storeConst(c.push(), uint64(v))
+
+	case wasm.Op_f32_const:
+		if pc+4 > len(code) {
+			return pc, fmt.Errorf("truncated instruction %v", op)
+		}
+		c.storeConst(c.push(), uint64(order.Uint32(code[pc:])))
+		pc += 4
+
+	case wasm.Op_f64_const:
+		if pc+8 > len(code) {
+			return pc, fmt.Errorf("truncated instruction %v", op)
+		}
+		c.storeConst(c.push(), order.Uint64(code[pc:]))
+		pc += 8
+
+	default:
+		if op < wasm.Op_i32_eqz || op > wasm.Op_i64_extend32_s {
+			return pc, fmt.Errorf("unsupported instruction %v", op)
+		}
+		c.numeric(op)
+	}
+	return pc, nil
+}
+
+// accessSize returns the number of bytes accessed by the load or store
+// instruction op.
+func accessSize(op wasm.Opcode) int {
+	switch op {
+	case wasm.Op_i32_load8_s, wasm.Op_i32_load8_u, wasm.Op_i64_load8_s, wasm.Op_i64_load8_u,
+		wasm.Op_i32_store8, wasm.Op_i64_store8:
+		return 1
+	case wasm.Op_i32_load16_s, wasm.Op_i32_load16_u, wasm.Op_i64_load16_s, wasm.Op_i64_load16_u,
+		wasm.Op_i32_store16, wasm.Op_i64_store16:
+		return 2
+	case wasm.Op_i64_load, wasm.Op_f64_load, wasm.Op_i64_store, wasm.Op_f64_store:
+		return 8
+	}
+	return 4
+}
+
+// address computes in rax the effective address of an access at the
+// offset off from the address in the slot a, and returns the memory
+// operand of the access, which must be the next instruction.
+// The bounds of the access are not checked: accesses beyond the memory
+// fault on its guard pages, see jitSignal.
+func (c *jitCompiler) address(a mem, off uint32) mem {
+	c.load(false, rax, a)
+	switch {
+	case off == 0:
+	case off <= math.MaxInt32:
+		c.aluImm(aluAdd, true, rax, int32(off))
+	default:
+		c.movImm(rcx, uint64(off))
+		c.aluReg(aluAdd, true, rax, rcx)
+	}
+	c.site(false)
+	return mem{base: r13, index: rax}
+}
+
+// storeFloat stores the floating-point value in xmm0 at m.
+func (c *jitCompiler) storeFloat(single bool, m mem) {
+	if !single {
+		c.sse(false, sseStore, xmm0, m)
+		return
+	}
+	c.movdFrom(rax, xmm0)
+	c.store(true, m, rax)
+}
+
+// Condition codes of the integer comparisons.
+var jitConds = map[wasm.Opcode]byte{
+	wasm.Op_i32_eq: ccE, wasm.Op_i32_ne: ccNE,
+	wasm.Op_i32_lt_s: ccL, wasm.Op_i32_lt_u: ccB,
+	wasm.Op_i32_gt_s: ccG, wasm.Op_i32_gt_u: ccA,
+	wasm.Op_i32_le_s: ccLE, wasm.Op_i32_le_u: ccBE,
+	wasm.Op_i32_ge_s: ccGE, wasm.Op_i32_ge_u: ccAE,
+	wasm.Op_i64_eq: ccE, wasm.Op_i64_ne: ccNE,
+	wasm.Op_i64_lt_s: ccL, wasm.Op_i64_lt_u: ccB,
+	wasm.Op_i64_gt_s: ccG, wasm.Op_i64_gt_u: ccA,
+	wasm.Op_i64_le_s: ccLE, wasm.Op_i64_le_u: ccBE,
+	wasm.Op_i64_ge_s: ccGE, wasm.Op_i64_ge_u: ccAE,
+}
+
+// Arithmetic instructions of the integer operations.
+var jitALU = map[wasm.Opcode]byte{
+	wasm.Op_i32_add: aluAdd, wasm.Op_i32_sub: aluSub,
+	wasm.Op_i32_and: aluAnd, wasm.Op_i32_or: aluOr, wasm.Op_i32_xor: aluXor,
+	wasm.Op_i64_add: aluAdd, wasm.Op_i64_sub: aluSub,
+	wasm.Op_i64_and: aluAnd, wasm.Op_i64_or: aluOr, wasm.Op_i64_xor: aluXor,
+}
+
+// Shifts and rotations.
+var jitShifts = map[wasm.Opcode]int{
+	wasm.Op_i32_shl: shShl, wasm.Op_i32_shr_s: shSar, wasm.Op_i32_shr_u: shShr,
+	wasm.Op_i32_rotl: shRol, wasm.Op_i32_rotr: shRor,
+	wasm.Op_i64_shl: shShl, wasm.Op_i64_shr_s: shSar, wasm.Op_i64_shr_u: shShr,
+	wasm.Op_i64_rotl: shRol, wasm.Op_i64_rotr: shRor,
+}
+
+// SSE instructions of the floating-point arithmetic.
+var jitSSE = map[wasm.Opcode]byte{
+	wasm.Op_f32_add: sseAdd, wasm.Op_f32_sub: sseSub,
+	wasm.Op_f32_mul: sseMul, wasm.Op_f32_div: sseDiv,
+	wasm.Op_f64_add: sseAdd, wasm.Op_f64_sub: sseSub,
+	wasm.Op_f64_mul: sseMul, wasm.Op_f64_div: sseDiv,
+}
+
+// is64 reports whether the integer instruction op operates on i64 values.
+func is64(op wasm.Opcode) bool {
+	return op >= wasm.Op_i64_eqz && op <= wasm.Op_i64_ge_u ||
+		op >= wasm.Op_i64_clz && op <= wasm.Op_i64_rotr
+}
+
+// isF32 reports whether the floating-point instruction op operates on f32
+// values.
+func isF32(op wasm.Opcode) bool {
+	return op >= wasm.Op_f32_eq && op <= wasm.Op_f32_ge ||
+		op >= wasm.Op_f32_abs && op <= wasm.Op_f32_copysign
+}
+
+// numeric compiles the numeric instruction op. The instructions not
+// implemented in machine code exit to Go.
+func (c *jitCompiler) numeric(op wasm.Opcode) {
+	w, single := is64(op), isF32(op)
+	if cc, ok := jitConds[op]; ok {
+		b := c.pop()
+		a := c.top()
+		c.load(w, rax, a)
+		c.alu(aluCmp, w, rax, b)
+		c.setcc(cc, rax)
+		c.store(true, a, rax)
+		return
+	}
+	if alu, ok := jitALU[op]; ok {
+		b := c.pop()
+		a := c.top()
+		c.load(w, rax, a)
+		c.alu(alu, w, rax, b)
+		c.store(true, a, rax)
+		return
+	}
+	if sh, ok := jitShifts[op]; ok {
+		b := c.pop()
+		a := c.top()
+		c.load(w, rax, a)
+		c.load(false, rcx, b)
+		c.shift(sh, w, rax)
+		c.store(true, a, rax)
+		return
+	}
+	if sse, ok := jitSSE[op]; ok {
+		b := c.pop()
+		a := c.top()
+		c.sse(single, sseLoad, xmm0, a)
+		c.sse(single, sse, xmm0, b)
+		c.storeFloat(single, a)
+		return
+	}
+
+	switch op {
+	case wasm.Op_i32_eqz, wasm.Op_i64_eqz:
+		a := c.top()
+		c.load(w, rax, a)
+		c.test(w, rax, rax)
+		c.setcc(ccE, rax)
+		c.store(true, a, rax)
+
+	case wasm.Op_i32_mul, wasm.Op_i64_mul:
+		b := c.pop()
+		a := c.top()
+		c.load(w, rax, a)
+		c.imul(w, rax, b)
+		c.store(true, a, rax)
+
+	case wasm.Op_i32_div_s, wasm.Op_i32_div_u, wasm.Op_i32_rem_s, wasm.Op_i32_rem_u,
+		wasm.Op_i64_div_s, wasm.Op_i64_div_u, wasm.Op_i64_rem_s, wasm.Op_i64_rem_u:
+		c.divide(op, w)
+
+	case wasm.Op_f32_eq, wasm.Op_f32_ne, wasm.Op_f32_lt, wasm.Op_f32_gt, wasm.Op_f32_le, wasm.Op_f32_ge,
+		wasm.Op_f64_eq, wasm.Op_f64_ne, wasm.Op_f64_lt, wasm.Op_f64_gt, wasm.Op_f64_le, wasm.Op_f64_ge:
+		c.compareFloat(op, single)
+
+	case wasm.Op_f32_sqrt, wasm.Op_f64_sqrt:
+		a := c.top()
+		c.sse(single, sseSqrt, xmm0, a)
+		c.storeFloat(single, a)
+
+	case wasm.Op_f32_abs, wasm.Op_f32_neg:
+		a := c.top()
+		c.load(false, rax, a)
+		if op == wasm.Op_f32_abs {
+			c.aluImm(aluAnd, false, rax, math.MaxInt32)
+		} else {
+			c.aluImm(aluXor, false, rax, math.MinInt32)
+		}
+		c.store(true, a, rax)
+
+	case wasm.Op_f64_abs, wasm.Op_f64_neg:
+		a := c.top()
+		c.load(true, rax, a)
+		if op == wasm.Op_f64_abs {
+			c.movImm(rcx, math.MaxInt64)
+			c.aluReg(aluAnd, true, rax, rcx)
+		} else {
+			c.movImm(rcx, 1<<63)
+			c.aluReg(aluXor, true, rax, rcx)
+		}
+		c.store(true, a, rax)
+
+	case wasm.Op_f32_copysign:
+		b := c.pop()
+		a := c.top()
+		c.load(false, rax, a)
+		c.load(false, rdx, b)
+		c.aluImm(aluAnd, false, rax, math.MaxInt32)
+		c.aluImm(aluAnd, false, rdx, math.MinInt32)
+		c.aluReg(aluOr, false, rax, rdx)
+		c.store(true, a, rax)
+
+	case wasm.Op_f64_copysign:
+		b := c.pop()
+		a := c.top()
+		c.load(true, rax, a)
+		c.load(true, rdx, b)
+		c.movImm(rcx, math.MaxInt64)
+		c.aluReg(aluAnd, true, rax, rcx)
+		c.movImm(rcx, 1<<63)
+		c.aluReg(aluAnd, true, rdx, rcx)
+		c.aluReg(aluOr, true, rax, rdx)
+		c.store(true, a, rax)
+
+	case wasm.Op_i32_wrap_i64, wasm.Op_i64_extend_u_i32:
+		a := c.top()
+		c.load(false, rax, a)
+		c.store(true, a, rax)
+
+	case wasm.Op_i64_extend_s_i32:
+		a := c.top()
+		c.loadExt(true, 0, rax, a)
+		c.store(true, a, rax)
+
+	case wasm.Op_f32_convert_s_i32, wasm.Op_f32_convert_s_i64,
+		wasm.Op_f64_convert_s_i32, wasm.Op_f64_convert_s_i64:
+		a := c.top()
+		single := op <= wasm.Op_f32_convert_u_i64
+		w := op == wasm.Op_f32_convert_s_i64 || op == wasm.Op_f64_convert_s_i64
+		c.cvtsi(single, w, xmm0, a)
+		c.storeFloat(single, a)
+
+	case wasm.Op_f32_convert_u_i32, wasm.Op_f64_convert_u_i32:
+		// the zero-extended value is converted exactly as an i64.
+		a := c.top()
+		single := op == wasm.Op_f32_convert_u_i32
+		c.load(false, rax, a)
+		c.cvtsiReg(single, true, xmm0, rax)
+		c.storeFloat(single, a)
+
+	case wasm.Op_f32_demote_f64:
+		a := c.top()
+		c.sse(false, sseCvt, xmm0, a)
+		c.storeFloat(true, a)
+
+	case wasm.Op_f64_promote_f32:
+		a := c.top()
+		c.sse(true, sseCvt, xmm0, a)
+		c.storeFloat(false, a)
+
+	case wasm.Op_i32_reinterpret_f32, wasm.Op_i64_reinterpret_f64,
+		wasm.Op_f32_reinterpret_i32, wasm.Op_f64_reinterpret_i64:
+		// values are stored as their bits.
+
+	default:
+		a := c.top()
+		if !isUnary(op) {
+			a = c.slot(c.height - 2)
+			c.pop()
+		}
+		c.lea(rax, a)
+		c.store(true, ctxSlots, rax)
+		c.storeImm(ctxArg, int32(op))
+		c.exitTo(jitExitNumeric)
+	}
+}
+
+// divide compiles the integer division or remainder op.
+func (c *jitCompiler) divide(op wasm.Opcode, w bool) {
+	b := c.pop()
+	a := c.top()
+	c.load(w, rax, a)
+	c.load(w, rcx, b)
+	c.test(w, rcx, rcx)
+	c.trapIf(ccE, jitTrapDivideByZero, false)
+
+	res := rax
+	if op == wasm.Op_i32_rem_s || op == wasm.Op_i32_rem_u || op == wasm.Op_i64_rem_s || op == wasm.Op_i64_rem_u {
+		res = rdx
+	}
+	switch op {
+	case wasm.Op_i32_div_s, wasm.Op_i64_div_s:
+		// the quotient of the smallest integer by -1 overflows.
+		normal := newLabel()
+		c.aluImm(aluCmp, w, rcx, -1)
+		c.jcc(ccNE, normal)
+		if w {
+			c.movImm(rdx, 1<<63)
+			c.aluReg(aluCmp, true, rax, rdx)
+		} else {
+			c.aluImm(aluCmp, false, rax, math.MinInt32)
+		}
+		c.trapIf(ccE, jitTrapOverflow, false)
+		c.bind(normal)
+		c.signExtend(w)
+		c.div(w, true, rcx)
+	case wasm.Op_i32_rem_s, wasm.Op_i64_rem_s:
+		// the remainder by -1 is 0, which idiv computes for any dividend
+		// but the smallest integer.
+		normal, done := newLabel(), newLabel()
+		c.aluImm(aluCmp, w, rcx, -1)
+		c.jcc(ccNE, normal)
+		c.aluReg(aluXor, false, rdx, rdx)
+		c.jmp(done)
+		c.bind(normal)
+		c.signExtend(w)
+		c.div(w, true, rcx)
+		c.bind(done)
+	default:
+		c.aluReg(aluXor, false, rdx, rdx)
+		c.div(w, false, rcx)
+	}
+	c.store(true, a, res)
+}
+
+// compareFloat compiles the floating-point comparison op.
+// ucomis sets the zero, parity and carry flags when its operands are
+// unordered, so that the comparisons are false when one of them is NaN.
+func (c *jitCompiler) compareFloat(op wasm.Opcode, single bool) {
+	b := c.pop()
+	a := c.top()
+	switch op {
+	case wasm.Op_f32_eq, wasm.Op_f64_eq, wasm.Op_f32_ne, wasm.Op_f64_ne:
+		c.sse(single, sseLoad, xmm0, a)
+		c.ucomis(single, xmm0, b)
+		if op == wasm.Op_f32_eq || op == wasm.Op_f64_eq {
+			c.setcc(ccE, rax)
+			c.setcc(ccNP, rcx)
+			c.aluReg(aluAnd, false, rax, rcx)
+		} else {
+			c.setcc(ccNE, rax)
+			c.setcc(ccP, rcx)
+			c.aluReg(aluOr, false, rax, rcx)
+		}
+	case wasm.Op_f32_lt, wasm.Op_f64_lt, wasm.Op_f32_le, wasm.Op_f64_le:
+		// a < b is b > a.
+		c.sse(single, sseLoad, xmm0, b)
+		c.ucomis(single, xmm0, a)
+		if op == wasm.Op_f32_lt || op == wasm.Op_f64_lt {
+			c.setcc(ccA, rax)
+		} else {
+			c.setcc(ccAE, rax)
+		}
+	default:
+		c.sse(single, sseLoad, xmm0, a)
+		c.ucomis(single, xmm0, b)
+		if op == wasm.Op_f32_gt || op == wasm.Op_f64_gt {
+			c.setcc(ccA, rax)
+		} else {
+			c.setcc(ccAE, rax)
+		}
+	}
+	c.store(true, a, rax)
+}
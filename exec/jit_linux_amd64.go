@@ -0,0 +1,632 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/sbinet/wasm"
+)
+
+// jitSupported reports whether EngineJIT is supported on this platform.
+const jitSupported = true
+
+// jitPollInterval is the number of calls and loop iterations of machine
+// code between two checks of the cancellation of a call.
+// Checks are expensive exits to Go, they also give a chance to the
+// goroutine to be preempted.
+const jitPollInterval = 1 << 12
+
+// jitStackGuard is the number of bytes of the native stack reserved for
+// the exits and the nested calls.
+const jitStackGuard = 4096
+
+// jitContext is the state shared by Go and the machine code of a call.
+// jitEnter and jitSignal depend on the offsets of its first fields.
+type jitContext struct {
+	goSP  uintptr // stack pointer of the caller of jitEnter
+	goBP  uintptr // frame pointer of the caller of jitEnter
+	sp    uintptr // stack pointer of the machine code, at the last exit
+	frame uintptr // frame of the machine code, at the last exit
+	mem   uintptr // base address of the memory
+	fault uintptr // address of the trap of the out of bounds accesses
+
+	memLen   uint64  // size of the memory in bytes
+	status   uint64  // cause of the last exit
+	arg      uint64  // argument of the last exit
+	slots    uintptr // address of the operands of the last exit
+	target   uintptr // address of the function called by the thunk
+	globals  uintptr // address of the addresses of the values of the globals
+	table    uintptr // address of the elements of the table
+	tableLen uint64  // number of elements of the table
+	inst     uintptr // address of the instance
+	stackLo  uintptr // lowest address of the native stack
+	slotsHi  uintptr // highest address of the value stack
+	depth    int64   // number of calls left before the call stack is exhausted
+	budget   int64   // number of calls and loop iterations left before a poll
+
+	top uintptr // address of the value stack above the slots of a call out
+}
+
+// Causes of the exits of the machine code.
+const (
+	jitExitReturn       = iota // the called function returned
+	jitExitTrap                // the function trapped, with the trap arg of jitTraps
+	jitExitYield               // the function polls the cancellation of the call
+	jitExitCall                // the function calls the imported function arg
+	jitExitCallIndirect        // the function makes an indirect call of type arg
+	jitExitGrow                // the function grows the memory
+	jitExitNumeric             // the function executes the numeric instruction arg
+	jitNumExits
+)
+
+// Traps raised by the machine code.
+const (
+	jitTrapUnreachable = iota
+	jitTrapOutOfBoundsMemory
+	jitTrapDivideByZero
+	jitTrapOverflow
+	jitTrapExhausted
+)
+
+var jitTraps = [...]TrapKind{
+	jitTrapUnreachable:       TrapUnreachable,
+	jitTrapOutOfBoundsMemory: TrapOutOfBoundsMemory,
+	jitTrapDivideByZero:      TrapIntegerDivideByZero,
+	jitTrapOverflow:          TrapIntegerOverflow,
+	jitTrapExhausted:         TrapCallStackExhausted,
+}
+
+// jitEnter switches to the native stack of the machine code, and resumes
+// its execution until its next exit.
+//
+//go:noescape
+func jitEnter(ctx *jitContext)
+
+// jitSignal is the handler of SIGSEGV. It turns the faults of the
+// machine code on the guard pages of the memory into traps, and forwards
+// the other signals to jitSignalNext. It must not be called from Go.
+func jitSignal()
+
+// jitSignalAddr returns the address of jitSignal.
+func jitSignalAddr() uintptr
+
+// jitSignalNext is the address of the previous handler of SIGSEGV.
+var jitSignalNext uintptr
+
+// jitCodeLo and jitCodeHi bound the address space reserved for the
+// machine code, see jitCode.
+var jitCodeLo, jitCodeHi uintptr
+
+// jitCodeSize is the size of the address space reserved for the machine
+// code.
+const jitCodeSize = 1 << 30
+
+// jitCode is the address space reserved for the machine code of all the
+// modules, so that jitSignal recognizes the faults of the machine code by
+// their address.
+var jitCode struct {
+	once sync.Once
+	err  error
+
+	sync.Mutex
+	mem  []byte
+	free []jitSpan // free spans, by offset
+}
+
+// jitSpan is a span of jitCode.mem.
+type jitSpan struct {
+	off, n int
+}
+
+// jitInit reserves the address space of the machine code, and installs
+// jitSignal.
+func jitInit() error {
+	jitCode.once.Do(func() {
+		mem, err := syscall.Mmap(-1, 0, jitCodeSize, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON|syscall.MAP_NORESERVE)
+		if err != nil {
+			jitCode.err = fmt.Errorf("exec: could not reserve executable memory: %w", err)
+			return
+		}
+		jitCode.mem = mem
+		jitCode.free = []jitSpan{{0, len(mem)}}
+		jitCodeLo = uintptr(unsafe.Pointer(&mem[0]))
+		jitCodeHi = jitCodeLo + uintptr(len(mem))
+
+		// struct kernel_sigaction
+		type sigaction struct {
+			handler  uintptr
+			flags    uint64
+			restorer uintptr
+			mask     uint64
+		}
+		var act sigaction
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(syscall.SIGSEGV), 0, uintptr(unsafe.Pointer(&act)), 8, 0, 0)
+		if errno == 0 && act.handler <= 1 {
+			errno = syscall.EINVAL // SIG_DFL or SIG_IGN: SIGSEGV is not handled by Go
+		}
+		if errno == 0 {
+			jitSignalNext = act.handler
+			act.handler = jitSignalAddr()
+			_, _, errno = syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(syscall.SIGSEGV), uintptr(unsafe.Pointer(&act)), 0, 8, 0, 0)
+		}
+		if errno != 0 {
+			jitCode.err = fmt.Errorf("exec: could not install the handler of SIGSEGV: %w", errno)
+		}
+	})
+	return jitCode.err
+}
+
+// jitAlloc allocates n bytes of jitCode.mem, n being a multiple of the
+// page size.
+func jitAlloc(n int) ([]byte, error) {
+	jitCode.Lock()
+	defer jitCode.Unlock()
+	for i, s := range jitCode.free {
+		if s.n < n {
+			continue
+		}
+		if s.n == n {
+			jitCode.free = append(jitCode.free[:i], jitCode.free[i+1:]...)
+		} else {
+			jitCode.free[i] = jitSpan{s.off + n, s.n - n}
+		}
+		return jitCode.mem[s.off : s.off+n : s.off+n], nil
+	}
+	return nil, fmt.Errorf("exec: out of executable memory")
+}
+
+// jitRelease returns the memory buf allocated by jitAlloc.
+func jitRelease(buf []byte) {
+	syscall.Mprotect(buf, syscall.PROT_NONE)
+	syscall.Madvise(buf, syscall.MADV_DONTNEED)
+
+	jitCode.Lock()
+	defer jitCode.Unlock()
+	s := jitSpan{int(uintptr(unsafe.Pointer(&buf[0])) - jitCodeLo), len(buf)}
+	free := jitCode.free
+	i := sort.Search(len(free), func(i int) bool { return free[i].off > s.off })
+	if i < len(free) && s.off+s.n == free[i].off {
+		s.n += free[i].n
+		free = append(free[:i], free[i+1:]...)
+	}
+	if i > 0 && free[i-1].off+free[i-1].n == s.off {
+		free[i-1].n += s.n
+	} else {
+		free = append(free[:i], append([]jitSpan{s}, free[i:]...)...)
+	}
+	jitCode.free = free
+}
+
+// jitFunc is a function compiled to machine code.
+// The machine code of indirect calls depends on its fields: it only calls
+// the functions of the same instance directly, comparing their types by
+// sig.
+type jitFunc struct {
+	entry  uintptr    // address of the machine code of the function
+	sig    int64      // index of the first identical type of the module
+	module *jitModule // executable memory holding the machine code
+}
+
+// jitModule is the machine code of the functions of a module.
+type jitModule struct {
+	code     []byte  // executable memory
+	addr     uintptr // address of the code
+	thunk    uintptr // address of the entry thunk
+	thunkRet uintptr // return address of the calls made by the thunk
+	fault    uintptr // address of the trap of the out of bounds accesses
+	sites    []jitSite
+}
+
+// jitSite is a call made by the machine code of a function, an exit, or
+// an access to the memory, which jitSignal turns into a call to the trap
+// of the out of bounds accesses when it faults.
+type jitSite struct {
+	ret    uint32 // offset of the return address of the call
+	fn     uint32 // index of the function making the call
+	at     int    // offset of the instruction making the call
+	hidden bool   // whether the call is made before the function is entered
+}
+
+// newJITModule copies the machine code to executable memory.
+func newJITModule(code []byte, sites []jitSite) (*jitModule, error) {
+	if err := jitInit(); err != nil {
+		return nil, err
+	}
+	n := (len(code) + syscall.Getpagesize() - 1) &^ (syscall.Getpagesize() - 1)
+	buf, err := jitAlloc(n)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Mprotect(buf, syscall.PROT_READ|syscall.PROT_WRITE)
+	if err == nil {
+		copy(buf, code)
+		err = syscall.Mprotect(buf, syscall.PROT_READ|syscall.PROT_EXEC)
+	}
+	if err != nil {
+		jitRelease(buf)
+		return nil, fmt.Errorf("exec: could not allocate executable memory: %w", err)
+	}
+	mod := &jitModule{
+		code:  buf,
+		addr:  uintptr(unsafe.Pointer(&buf[0])),
+		sites: sites,
+	}
+	runtime.SetFinalizer(mod, func(mod *jitModule) { jitRelease(mod.code) })
+	return mod, nil
+}
+
+// encodeJIT appends the encoding of the machine code of the function
+// bodies of c to p.
+func encodeJIT(p []byte, c *CompiledModule) []byte {
+	if len(c.bodies) == 0 {
+		return p
+	}
+	mod := c.bodies[0].jit.module
+	p = binary.AppendUvarint(p, uint64(len(mod.code)))
+	p = append(p, mod.code...)
+	for _, addr := range [...]uintptr{mod.thunk, mod.thunkRet, mod.fault} {
+		p = binary.AppendUvarint(p, uint64(addr-mod.addr))
+	}
+	p = binary.AppendUvarint(p, uint64(len(mod.sites)))
+	for _, s := range mod.sites {
+		p = binary.AppendUvarint(p, uint64(s.ret))
+		p = binary.AppendUvarint(p, uint64(s.fn))
+		p = binary.AppendUvarint(p, uint64(s.at))
+		hidden := byte(0)
+		if s.hidden {
+			hidden = 1
+		}
+		p = append(p, hidden)
+	}
+	for _, b := range c.bodies {
+		p = binary.AppendUvarint(p, uint64(b.jit.entry-mod.addr))
+		p = binary.AppendUvarint(p, uint64(b.jit.sig))
+	}
+	return p
+}
+
+// decodeJIT decodes the machine code of the function bodies of c encoded
+// by encodeJIT.
+func decodeJIT(r *cacheReader, c *CompiledModule) error {
+	if len(c.bodies) == 0 {
+		return nil
+	}
+	code := r.bytes(r.count(1))
+	offset := func() uintptr {
+		off := r.uvarint()
+		if off >= uint64(len(code)) {
+			r.fail()
+		}
+		return uintptr(off)
+	}
+	thunk, thunkRet, fault := offset(), offset(), offset()
+	sites := make([]jitSite, r.count(4))
+	for i := range sites {
+		sites[i] = jitSite{
+			ret:    r.uint32(),
+			fn:     r.uint32(),
+			at:     int(r.uint32()),
+			hidden: r.byte() != 0,
+		}
+	}
+	entries := make([]uintptr, len(c.bodies))
+	sigs := make([]int64, len(c.bodies))
+	for i := range c.bodies {
+		entries[i] = offset()
+		sigs[i] = int64(r.uint32())
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	mod, err := newJITModule(code, sites)
+	if err != nil {
+		return err
+	}
+	mod.thunk = mod.addr + thunk
+	mod.thunkRet = mod.addr + thunkRet
+	mod.fault = mod.addr + fault
+	for i, b := range c.bodies {
+		b.jit = &jitFunc{
+			entry:  mod.addr + entries[i],
+			sig:    sigs[i],
+			module: mod,
+		}
+	}
+	return nil
+}
+
+// site returns the call whose return address is ret, or nil.
+func (mod *jitModule) site(ret uintptr) *jitSite {
+	off := uint32(ret - mod.addr)
+	i := sort.Search(len(mod.sites), func(i int) bool { return mod.sites[i].ret >= off })
+	if i == len(mod.sites) || mod.sites[i].ret != off {
+		return nil
+	}
+	return &mod.sites[i]
+}
+
+// jitState holds the stacks of the calls to the machine code of an
+// instance.
+type jitState struct {
+	ctx       jitContext
+	stack     []byte // native stack
+	stackAddr uintptr
+	slots     []byte // value stack
+	slotsAddr uintptr
+	globals   []uintptr // addresses of the values of the globals
+	active    int       // number of calls in progress
+}
+
+// jitState returns the state of the calls to the machine code of the
+// instance, allocating it on first use.
+func (inst *Instance) jitState() (*jitState, error) {
+	if inst.jit != nil {
+		return inst.jit, nil
+	}
+	st := &jitState{}
+	var err error
+	flags := syscall.MAP_PRIVATE | syscall.MAP_ANON | syscall.MAP_NORESERVE
+	// calls use 16 bytes of the native stack: a return address and a frame.
+	st.stack, err = syscall.Mmap(-1, 0, 16*inst.maxDepth+4*jitStackGuard, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+	if err != nil {
+		return nil, fmt.Errorf("exec: could not allocate the native stack: %w", err)
+	}
+	st.slots, err = syscall.Mmap(-1, 0, 8*inst.maxStack, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+	if err != nil {
+		syscall.Munmap(st.stack)
+		return nil, fmt.Errorf("exec: could not allocate the value stack: %w", err)
+	}
+	st.stackAddr = uintptr(unsafe.Pointer(&st.stack[0]))
+	st.slotsAddr = uintptr(unsafe.Pointer(&st.slots[0]))
+	for _, g := range inst.globals {
+		st.globals = append(st.globals, uintptr(unsafe.Pointer(&g.val)))
+	}
+	runtime.SetFinalizer(st, func(st *jitState) {
+		syscall.Munmap(st.stack)
+		syscall.Munmap(st.slots)
+	})
+	inst.jit = st
+	return st, nil
+}
+
+// values returns the n slots of the value stack starting at the address
+// addr.
+func (st *jitState) values(addr uintptr, n int) []uint64 {
+	off := addr - st.slotsAddr
+	if n == 0 || off >= uintptr(len(st.slots)) {
+		return nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&st.slots[off])), n)
+}
+
+// refresh updates the context after the memory or the table of the
+// instance may have changed.
+func (st *jitState) refresh(inst *Instance) {
+	ctx := &st.ctx
+	if len(inst.mems) > 0 {
+		buf := inst.mems[0].buf
+		ctx.mem = uintptr(unsafe.Pointer(unsafe.SliceData(inst.mems[0].res)))
+		ctx.memLen = uint64(len(buf))
+	}
+	if len(inst.tables) > 0 {
+		elems := inst.tables[0].elems
+		ctx.table = uintptr(unsafe.Pointer(unsafe.SliceData(elems)))
+		ctx.tableLen = uint64(len(elems))
+	}
+}
+
+// trace returns the frames of the machine code at the last exit,
+// innermost first.
+func (st *jitState) trace(inst *Instance, mod *jitModule) []Frame {
+	var (
+		frames []Frame
+		off    = int(st.ctx.sp - st.stackAddr)
+		ret    = func(off int) uintptr { return uintptr(order.Uint64(st.stack[off:])) }
+	)
+	add := func(ret uintptr) {
+		s := mod.site(ret)
+		if s == nil || s.hidden {
+			return
+		}
+		frames = append(frames, Frame{
+			Func:   s.fn,
+			Name:   inst.names[s.fn],
+			Offset: s.at,
+		})
+	}
+	// the return address of the exit is followed by the return addresses
+	// and saved frames of the calls.
+	add(ret(off))
+	for off += 8; off+8 <= len(st.stack); off += 16 {
+		r := ret(off)
+		if r == mod.thunkRet {
+			break
+		}
+		add(r)
+	}
+	return frames
+}
+
+// runJIT calls the function f, compiled to machine code, whose arguments
+// are on the operand stack, and leaves its results on the stack.
+func (m *machine) runJIT(f *Function) {
+	inst := f.inst
+	st, err := inst.jitState()
+	if err != nil {
+		panic(trapError{err})
+	}
+	var (
+		mod   = f.body.jit.module
+		ctx   = &st.ctx
+		saved = *ctx
+		np    = len(f.typ.Params)
+		nr    = len(f.typ.Results)
+		n     = max(np, nr)
+		base  = st.slotsAddr
+		sp    = st.stackAddr + uintptr(len(st.stack)) - 8
+	)
+	if st.active > 0 {
+		// call made while machine code of the instance is calling out:
+		// it runs on top of the stacks of its caller.
+		base = saved.top
+		sp = (saved.sp - 16) &^ 15
+	}
+	hi := st.slotsAddr + uintptr(len(st.slots))
+	if limit := base + 8*uintptr(max(m.maxStack, 0)); limit < hi {
+		hi = limit
+	}
+	if base+8*uintptr(n) > hi || sp < st.stackAddr+2*jitStackGuard {
+		trap(TrapCallStackExhausted)
+	}
+
+	st.active++
+	entered := false
+	defer func() {
+		e := recover()
+		if te, ok := e.(trapError); ok && entered {
+			var t *Trap
+			if errors.As(te.err, &t) {
+				t.Stack = append(t.Stack, st.trace(inst, mod)...)
+			}
+		}
+		*ctx = saved
+		st.active--
+		if e != nil {
+			panic(e)
+		}
+	}()
+
+	s := st.values(base, n)
+	copy(s, m.stack[len(m.stack)-np:])
+	order.PutUint64(st.stack[sp-st.stackAddr:], uint64(mod.thunk))
+	*ctx = jitContext{
+		sp:      sp,
+		frame:   base,
+		fault:   mod.fault,
+		target:  f.body.jit.entry,
+		inst:    uintptr(unsafe.Pointer(inst)),
+		stackLo: st.stackAddr + jitStackGuard,
+		slotsHi: hi,
+		depth:   int64(m.maxDepth),
+		budget:  jitPollInterval,
+	}
+	if len(st.globals) > 0 {
+		ctx.globals = uintptr(unsafe.Pointer(&st.globals[0]))
+	}
+	st.refresh(inst)
+
+	var done <-chan struct{}
+	if m.ctx != nil {
+		done = m.ctx.Done()
+	}
+	entered = true
+	for {
+		jitEnter(ctx)
+		switch ctx.status {
+		case jitExitReturn:
+			m.stack = append(m.stack[:len(m.stack)-np], s[:nr]...)
+			runtime.KeepAlive(mod)
+			runtime.KeepAlive(inst) // the context points into its memory.
+			return
+
+		case jitExitTrap:
+			trap(jitTraps[ctx.arg])
+
+		case jitExitYield:
+			ctx.budget = jitPollInterval
+			select {
+			case <-done:
+				m.interrupt()
+			default:
+			}
+
+		case jitExitCall:
+			m.callOutJIT(st, inst, inst.funcs[ctx.arg], ctx.slots, base)
+
+		case jitExitCallIndirect:
+			ft := inst.types[ctx.arg]
+			i := uint32(st.values(ctx.slots, len(ft.Params)+1)[len(ft.Params)])
+			t := inst.tables[0]
+			if uint64(i) >= uint64(len(t.elems)) {
+				trapMsg(TrapOutOfBoundsTable, "undefined element")
+			}
+			g := t.elems[i]
+			if g == nil {
+				trapMsg(TrapNullReference, "uninitialized element")
+			}
+			if !sameType(g.typ, ft) {
+				trap(TrapIndirectCallTypeMismatch)
+			}
+			m.callOutJIT(st, inst, g, ctx.slots, base)
+
+		case jitExitGrow:
+			v := st.values(ctx.slots, 1)
+			v[0] = uint64(uint32(inst.mems[0].grow(uint32(v[0]))))
+
+		case jitExitNumeric:
+			op := wasm.Opcode(ctx.arg)
+			if isUnary(op) {
+				v := st.values(ctx.slots, 1)
+				v[0] = unop(op, v[0])
+			} else {
+				v := st.values(ctx.slots, 2)
+				v[0] = binop(op, v[0], v[1])
+			}
+		}
+		st.refresh(inst)
+	}
+}
+
+// callOutJIT calls the function f, which is not compiled to machine code
+// of the instance inst, for the machine code of the call whose frame
+// starts at the address base. The arguments of f are in the value stack
+// at the address addr, where its results are stored.
+func (m *machine) callOutJIT(st *jitState, inst *Instance, f *Function, addr, base uintptr) {
+	n := max(len(f.typ.Params), len(f.typ.Results))
+	s := st.values(addr, n)
+	st.ctx.top = addr + 8*uintptr(n)
+	// calls made by f share the limits of the call.
+	frames := m.maxDepth - int(st.ctx.depth)
+	height := int(st.ctx.top-base) / 8
+
+	if f.host == nil {
+		nm := &machine{
+			ctx:      m.ctx,
+			maxDepth: m.maxDepth - frames,
+			maxStack: m.maxStack - height,
+		}
+		nm.stack = append(nm.stack, s[:len(f.typ.Params)]...)
+		if err := nm.run(f); err != nil {
+			panic(trapError{err})
+		}
+		copy(s, nm.stack)
+		return
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			if err, ok := e.(error); ok {
+				panic(trapError{err})
+			}
+			panic(e)
+		}
+	}()
+	inst.outer.frames += frames
+	inst.outer.stack += height
+	defer func() {
+		inst.outer.frames -= frames
+		inst.outer.stack -= height
+	}()
+	f.host.Func(m.ctx, inst, s)
+}
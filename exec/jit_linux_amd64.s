@@ -0,0 +1,58 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func jitEnter(ctx *jitContext)
+//
+// jitEnter saves the stack and frame pointers of its caller in ctx.goSP
+// and ctx.goBP, then switches to the native stack at ctx.sp and returns to
+// the address on top of it, with the registers of the machine code set
+// from ctx. The machine code exits by restoring the saved pointers and
+// returning to the caller of jitEnter.
+TEXT ·jitEnter(SB), NOSPLIT, $0-8
+	MOVQ ctx+0(FP), R15
+	MOVQ SP, 0(R15)  // ctx.goSP
+	MOVQ BP, 8(R15)  // ctx.goBP
+	MOVQ 16(R15), SP // ctx.sp
+	MOVQ 24(R15), R12 // ctx.frame
+	MOVQ 32(R15), R13 // ctx.mem
+	RET
+
+// func jitSignal()
+//
+// jitSignal is called by the kernel as a SA_SIGINFO handler of SIGSEGV,
+// with the signal number, the siginfo_t and the ucontext_t in DI, SI and
+// DX. If the fault is an access of the machine code, whose context is in
+// R15, to the reservation of its memory, it makes the machine code call
+// the trap ctx.fault as if the faulting instruction was a call, and
+// returns to it. Otherwise, it jumps to the previous handler.
+TEXT ·jitSignal(SB), NOSPLIT|NOFRAME, $0
+	MOVQ 168(DX), AX // uc_mcontext.rip
+	CMPQ AX, ·jitCodeLo(SB)
+	JB   next
+	CMPQ AX, ·jitCodeHi(SB)
+	JAE  next
+	MOVQ 96(DX), CX  // uc_mcontext.r15: ctx
+	MOVQ 16(SI), R8  // si_addr
+	SUBQ 32(CX), R8  // ctx.mem
+	CMPQ R8, ·memoryReservation(SB)
+	JAE  next
+	MOVQ 160(DX), R8 // uc_mcontext.rsp
+	SUBQ $8, R8
+	MOVQ AX, 0(R8)
+	MOVQ R8, 160(DX)
+	MOVQ 40(CX), AX  // ctx.fault
+	MOVQ AX, 168(DX)
+	RET
+
+next:
+	MOVQ ·jitSignalNext(SB), AX
+	JMP  AX
+
+// func jitSignalAddr() uintptr
+TEXT ·jitSignalAddr(SB), NOSPLIT, $0-8
+	LEAQ ·jitSignal(SB), AX
+	MOVQ AX, ret+0(FP)
+	RET
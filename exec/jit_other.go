@@ -0,0 +1,31 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux || !amd64
+
+package exec
+
+// jitSupported reports whether EngineJIT is supported on this platform.
+const jitSupported = false
+
+type (
+	jitFunc  struct{}
+	jitState struct{}
+)
+
+func compileJIT(c *CompiledModule) error {
+	panic("unreachable")
+}
+
+func encodeJIT(p []byte, c *CompiledModule) []byte {
+	panic("unreachable")
+}
+
+func decodeJIT(r *cacheReader, c *CompiledModule) error {
+	panic("unreachable")
+}
+
+func (m *machine) runJIT(f *Function) {
+	panic("unreachable")
+}
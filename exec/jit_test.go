@@ -0,0 +1,517 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+// compileJIT compiles the module m with the jit engine, or skips the test
+// if the engine is not supported.
+func compileJIT(t *testing.T, m *wasm.Module) *exec.CompiledModule {
+	t.Helper()
+	return compileEngine(t, m, exec.EngineJIT)
+}
+
+// compileEngine compiles the module m with the provided engine, or skips
+// the test or benchmark if the engine is not supported.
+func compileEngine(tb testing.TB, m *wasm.Module, engine exec.Engine) *exec.CompiledModule {
+	tb.Helper()
+	c, err := exec.CompileWithOptions(m, exec.CompileOptions{Engine: engine})
+	if err != nil && strings.Contains(err.Error(), "not supported") {
+		tb.Skip(err)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// jitInstances instantiates the module m with the ir and jit engines.
+func jitInstances(t *testing.T, m *wasm.Module, opts exec.InstantiateOptions) (ir, jit *exec.Instance) {
+	t.Helper()
+	ctx := context.Background()
+	c := compileJIT(t, m)
+	jit, err := c.Instantiate(ctx, opts)
+	if err != nil {
+		t.Fatalf("jit: %+v", err)
+	}
+	opts.Engine = exec.EngineIR
+	ir, err = exec.InstantiateWithOptions(ctx, m, opts)
+	if err != nil {
+		t.Fatalf("ir: %+v", err)
+	}
+	return ir, jit
+}
+
+// sameCall checks that the call of the function name of the two instances
+// returns the same results, or fails with the same trap.
+func sameCall(t *testing.T, ir, jit *exec.Instance, name string, args ...uint64) {
+	t.Helper()
+	ctx := context.Background()
+	want, werr := ir.Call(ctx, name, args...)
+	got, err := jit.Call(ctx, name, args...)
+	if w, g := fmt.Sprintf("%+v", werr), fmt.Sprintf("%+v", err); w != g {
+		t.Fatalf("%s%#x: invalid error:\ngot:  %s\nwant: %s", name, args, g, w)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s%#x: got=%#x, want=%#x", name, args, got, want)
+	}
+}
+
+func TestJIT(t *testing.T) {
+	m := wasmtest.Build(t, wasmtest.NewModule)
+	ir, jit := jitInstances(t, m, exec.InstantiateOptions{})
+
+	for _, tc := range []struct {
+		name string
+		args []uint64
+	}{
+		{"swap", []uint64{1, 2}},
+		{"tee", []uint64{3}},
+		{"brvalue", []uint64{1}},
+		{"brvalue", []uint64{0}},
+		{"select", []uint64{1}},
+		{"select", []uint64{0}},
+		{"orminus", []uint64{4}},
+		{"orminus", []uint64{0}},
+		{"fib", []uint64{0}},
+		{"fib", []uint64{90}},
+		{"dead", nil},
+		{"fac", []uint64{20}},
+		{"sum", []uint64{100}},
+		{"switch", []uint64{0}},
+		{"switch", []uint64{1}},
+		{"switch", []uint64{2}},
+		{"switch", []uint64{7}},
+		{"load", []uint64{16}},
+		{"load", []uint64{28}},
+		{"load", []uint64{0xffff}},
+		{"load", []uint64{0x10000}},
+		{"load", []uint64{0xffffffff}},
+		{"store", []uint64{32, 0x12345678}},
+		{"store", []uint64{0xfffe, 0x12345678}},
+		{"dispatch", []uint64{0, 7, 3}},
+		{"dispatch", []uint64{1, 7, 3}},
+		{"dispatch", []uint64{2, 7, 3}},
+		{"dispatch", []uint64{3, 7, 3}},
+		{"dispatch", []uint64{4, 7, 3}},
+		{"incr", nil},
+		{"incr", nil},
+		{"grow", []uint64{1}},
+		{"load", []uint64{0x1ffff}},
+		{"grow", []uint64{1}},
+		{"trap", []uint64{0}},
+		{"trap", []uint64{1}},
+	} {
+		sameCall(t, ir, jit, tc.name, tc.args...)
+	}
+}
+
+// jitOps holds the signatures of the numeric instructions.
+var jitOps = func() map[wasm.Opcode][]wasm.ValueType {
+	ops := make(map[wasm.Opcode][]wasm.ValueType) // parameters, then result
+	add := func(first, last wasm.Opcode, sig ...wasm.ValueType) {
+		for op := first; op <= last; op++ {
+			ops[op] = sig
+		}
+	}
+	const (
+		I32 = wasm.I32
+		I64 = wasm.I64
+		F32 = wasm.F32
+		F64 = wasm.F64
+	)
+	add(wasm.Op_i32_eqz, wasm.Op_i32_eqz, I32, I32)
+	add(wasm.Op_i32_eq, wasm.Op_i32_ge_u, I32, I32, I32)
+	add(wasm.Op_i64_eqz, wasm.Op_i64_eqz, I64, I32)
+	add(wasm.Op_i64_eq, wasm.Op_i64_ge_u, I64, I64, I32)
+	add(wasm.Op_f32_eq, wasm.Op_f32_ge, F32, F32, I32)
+	add(wasm.Op_f64_eq, wasm.Op_f64_ge, F64, F64, I32)
+	add(wasm.Op_i32_clz, wasm.Op_i32_popcnt, I32, I32)
+	add(wasm.Op_i32_add, wasm.Op_i32_rotr, I32, I32, I32)
+	add(wasm.Op_i64_clz, wasm.Op_i64_popcnt, I64, I64)
+	add(wasm.Op_i64_add, wasm.Op_i64_rotr, I64, I64, I64)
+	add(wasm.Op_f32_abs, wasm.Op_f32_sqrt, F32, F32)
+	add(wasm.Op_f32_add, wasm.Op_f32_copysign, F32, F32, F32)
+	add(wasm.Op_f64_abs, wasm.Op_f64_sqrt, F64, F64)
+	add(wasm.Op_f64_add, wasm.Op_f64_copysign, F64, F64, F64)
+	add(wasm.Op_i32_wrap_i64, wasm.Op_i32_wrap_i64, I64, I32)
+	add(wasm.Op_i32_trunc_s_f32, wasm.Op_i32_trunc_u_f32, F32, I32)
+	add(wasm.Op_i32_trunc_s_f64, wasm.Op_i32_trunc_u_f64, F64, I32)
+	add(wasm.Op_i64_extend_s_i32, wasm.Op_i64_extend_u_i32, I32, I64)
+	add(wasm.Op_i64_trunc_s_f32, wasm.Op_i64_trunc_u_f32, F32, I64)
+	add(wasm.Op_i64_trunc_s_f64, wasm.Op_i64_trunc_u_f64, F64, I64)
+	add(wasm.Op_f32_convert_s_i32, wasm.Op_f32_convert_u_i32, I32, F32)
+	add(wasm.Op_f32_convert_s_i64, wasm.Op_f32_convert_u_i64, I64, F32)
+	add(wasm.Op_f32_demote_f64, wasm.Op_f32_demote_f64, F64, F32)
+	add(wasm.Op_f64_convert_s_i32, wasm.Op_f64_convert_u_i32, I32, F64)
+	add(wasm.Op_f64_convert_s_i64, wasm.Op_f64_convert_u_i64, I64, F64)
+	add(wasm.Op_f64_promote_f32, wasm.Op_f64_promote_f32, F32, F64)
+	add(wasm.Op_i32_reinterpret_f32, wasm.Op_i32_reinterpret_f32, F32, I32)
+	add(wasm.Op_i64_reinterpret_f64, wasm.Op_i64_reinterpret_f64, F64, I64)
+	add(wasm.Op_f32_reinterpret_i32, wasm.Op_f32_reinterpret_i32, I32, F32)
+	add(wasm.Op_f64_reinterpret_i64, wasm.Op_f64_reinterpret_i64, I64, F64)
+	return ops
+}()
+
+// jitValues holds interesting operands of each type.
+var jitValues = map[wasm.ValueType][]uint64{
+	wasm.I32: {0, 1, 2, 7, 31, 32, 0x7fffffff, 0x80000000, 0xfffffffe, 0xffffffff, 0x12345678},
+	wasm.I64: {0, 1, 63, 64, 0xffffffff, 0x7fffffffffffffff, 1 << 63, 0xfffffffffffffffe, 0xffffffffffffffff, 0x123456789abcdef0},
+	wasm.F32: func() []uint64 {
+		var vs []uint64
+		for _, v := range []float32{0, 1, -1.5, 2.5, 3e9, -3e9, 16777217, 1e-45, float32(math.Inf(1)), float32(math.Inf(-1))} {
+			vs = append(vs, uint64(math.Float32bits(v)))
+		}
+		return append(vs, 0x80000000, 0x7fc00000, 0xffa00001)
+	}(),
+	wasm.F64: func() []uint64 {
+		var vs []uint64
+		for _, v := range []float64{0, 1, -1.5, 2.5, 3e9, -3e9, 1 << 53, 5e-324, 1e300, math.Inf(1), math.Inf(-1)} {
+			vs = append(vs, math.Float64bits(v))
+		}
+		return append(vs, 1<<63, 0x7ff8000000000000, 0xfff4000000000001)
+	}(),
+}
+
+func TestJITNumeric(t *testing.T) {
+	b := wasm.NewBuilder()
+	for op, sig := range jitOps {
+		params := sig[:len(sig)-1]
+		f := b.Func(fmt.Sprintf("op%#x", int(op)), wasm.FuncType{Params: params, Results: sig[len(sig)-1:]})
+		var code []byte
+		for i := range params {
+			code = append(code, byte(wasm.Op_get_local), byte(i))
+		}
+		f.SetBody(nil, append(code, byte(op)))
+		b.Export(fmt.Sprintf("op%#x", int(op)), f)
+	}
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, jit := jitInstances(t, m, exec.InstantiateOptions{})
+
+	for op, sig := range jitOps {
+		name := fmt.Sprintf("op%#x", int(op))
+		params := sig[:len(sig)-1]
+		for _, a := range jitValues[params[0]] {
+			if len(params) == 1 {
+				sameCall(t, ir, jit, name, a)
+				continue
+			}
+			for _, b := range jitValues[params[1]] {
+				sameCall(t, ir, jit, name, a, b)
+			}
+		}
+	}
+}
+
+func TestJITCalls(t *testing.T) {
+	ctx := context.Background()
+	b := wasm.NewBuilder()
+	twice := b.ImportFunc("env", "twice", wasm.FuncType{Params: i32, Results: i32})
+	// callback calls back the exported function named by its argument.
+	callback := b.ImportFunc("env", "callback", wasm.FuncType{Params: i32, Results: i32})
+	table := b.Table(wasm.TableType{ElemType: funcref, Limits: wasm.ResizableLimits{Initial: 2}})
+
+	// quad calls the host function twice twice.
+	quad := b.Func("quad", wasm.FuncType{Params: i32, Results: i32})
+	quad.Body().LocalGet(0).Call(twice).Call(twice).End()
+	b.Export("quad", quad)
+
+	// reenter returns 1 plus the result of the call back to quad.
+	reenter := b.Func("reenter", wasm.FuncType{Params: i32, Results: i32})
+	reenter.Body().LocalGet(0).Call(callback).I32Const(1).I32Add().End()
+	b.Export("reenter", reenter)
+
+	// indirect calls the host function twice through the table.
+	b.Elements(table, wasm.ConstI32(0), twice, quad)
+	indirect := b.Func("indirect", wasm.FuncType{Params: i32x2, Results: i32})
+	indirect.Body().LocalGet(1).LocalGet(0).CallIndirect(wasm.FuncType{Params: i32, Results: i32}).End()
+	b.Export("indirect", indirect)
+
+	// deep recurses until the call stack is exhausted.
+	deep := b.Func("deep", wasm.FuncType{Params: i32, Results: i32})
+	deep.Body().LocalGet(0).I32Const(1).I32Add().Call(deep).End()
+	b.Export("deep", deep)
+
+	// spin loops forever.
+	spin := b.Func("spin", wasm.FuncType{})
+	e := spin.Body()
+	loop := e.Loop()
+	e.Br(loop)
+	e.End()
+	e.End()
+	b.Export("spin", spin)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jit *exec.Instance
+	imports := exec.Imports{
+		"env": {
+			"twice": func(x int32) int32 { return 2 * x },
+			"callback": func(ctx context.Context, inst *exec.Instance, x int32) (int32, error) {
+				res, err := inst.Invoke(ctx, "quad", x)
+				if err != nil {
+					return 0, err
+				}
+				return res[0].(int32), nil
+			},
+		},
+	}
+	opts := exec.InstantiateOptions{Imports: imports, MaxCallDepth: 1000}
+	ir, jit := jitInstances(t, m, opts)
+
+	for _, tc := range []struct {
+		name string
+		args []uint64
+	}{
+		{"quad", []uint64{3}},
+		{"reenter", []uint64{5}},
+		{"indirect", []uint64{0, 21}},
+		{"indirect", []uint64{1, 21}},
+		{"indirect", []uint64{2, 21}},
+		{"deep", []uint64{0}},
+	} {
+		sameCall(t, ir, jit, tc.name, tc.args...)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = jit.Call(ctx, "spin")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("invalid error: %v", err)
+	}
+	want := "exec: interrupted: context deadline exceeded in function 6 (spin) at offset 0x2"
+	if err.Error() != want {
+		t.Fatalf("invalid message:\ngot= %s\nwant=%s", err, want)
+	}
+
+	// the instance is still usable after a trap.
+	got, err := jit.Call(context.Background(), "quad", 1)
+	if err != nil || got[0] != 4 {
+		t.Fatalf("got=%v, %v", got, err)
+	}
+}
+
+func TestJITMetering(t *testing.T) {
+	m, err := wasm.NewBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compileJIT(t, m)
+	if c.Engine() != exec.EngineJIT || c.Engine().String() != "jit" {
+		t.Fatalf("invalid engine: %v", c.Engine())
+	}
+	_, err = c.Instantiate(context.Background(), exec.InstantiateOptions{Metering: true})
+	if err == nil || !strings.Contains(err.Error(), "fuel metering is not supported") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
+
+func TestJITMemory(t *testing.T) {
+	const (
+		I32 = wasm.I32
+		I64 = wasm.I64
+		F32 = wasm.F32
+		F64 = wasm.F64
+	)
+	loads := map[wasm.Opcode]wasm.ValueType{
+		wasm.Op_i32_load: I32, wasm.Op_i64_load: I64, wasm.Op_f32_load: F32, wasm.Op_f64_load: F64,
+		wasm.Op_i32_load8_s: I32, wasm.Op_i32_load8_u: I32, wasm.Op_i32_load16_s: I32, wasm.Op_i32_load16_u: I32,
+		wasm.Op_i64_load8_s: I64, wasm.Op_i64_load8_u: I64, wasm.Op_i64_load16_s: I64, wasm.Op_i64_load16_u: I64,
+		wasm.Op_i64_load32_s: I64, wasm.Op_i64_load32_u: I64,
+	}
+	stores := map[wasm.Opcode]wasm.ValueType{
+		wasm.Op_i32_store: I32, wasm.Op_i64_store: I64, wasm.Op_f32_store: F32, wasm.Op_f64_store: F64,
+		wasm.Op_i32_store8: I32, wasm.Op_i32_store16: I32,
+		wasm.Op_i64_store8: I64, wasm.Op_i64_store16: I64, wasm.Op_i64_store32: I64,
+	}
+
+	b := wasm.NewBuilder()
+	b.Memory(memType(1, 0))
+	for op, typ := range loads {
+		f := b.Func(fmt.Sprintf("load%#x", int(op)), wasm.FuncType{Params: i32, Results: []wasm.ValueType{typ}})
+		f.SetBody(nil, []byte{byte(wasm.Op_get_local), 0, byte(op), 0, 3})
+		b.Export(fmt.Sprintf("load%#x", int(op)), f)
+	}
+	for op, typ := range stores {
+		f := b.Func(fmt.Sprintf("store%#x", int(op)), wasm.FuncType{Params: []wasm.ValueType{I32, typ}})
+		f.SetBody(nil, []byte{byte(wasm.Op_get_local), 0, byte(wasm.Op_get_local), 1, byte(op), 0, 3})
+		b.Export(fmt.Sprintf("store%#x", int(op)), f)
+	}
+
+	// locals returns the sum of its argument and of its zeroed locals.
+	locals := b.Func("locals", wasm.FuncType{Params: []wasm.ValueType{I64}, Results: []wasm.ValueType{I64}})
+	code := []byte{byte(wasm.Op_get_local), 0}
+	for i := 1; i <= 12; i++ {
+		code = append(code, byte(wasm.Op_get_local), byte(i), byte(wasm.Op_i64_add))
+	}
+	locals.SetBody([]wasm.LocalEntry{{Count: 12, Type: I64}}, code)
+	b.Export("locals", locals)
+
+	// far loads at the largest offset, beyond any memory.
+	far := b.Func("far", wasm.FuncType{Params: i32, Results: []wasm.ValueType{I64}})
+	far.SetBody(nil, []byte{byte(wasm.Op_get_local), 0, byte(wasm.Op_i64_load), 3, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	b.Export("far", far)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, jit := jitInstances(t, m, exec.InstantiateOptions{})
+
+	for op, typ := range stores {
+		for _, addr := range []uint64{0, 1, 0xfff5, 0xfff9, 0xfffb, 0xfffc, 0xfffd, 0x10000, 0xffffffff} {
+			for _, v := range jitValues[typ] {
+				sameCall(t, ir, jit, fmt.Sprintf("store%#x", int(op)), addr, v)
+				for op := range loads {
+					sameCall(t, ir, jit, fmt.Sprintf("load%#x", int(op)), addr)
+				}
+			}
+		}
+	}
+	sameCall(t, ir, jit, "locals", 42)
+	sameCall(t, ir, jit, "locals", 7)
+	sameCall(t, ir, jit, "far", 0)
+	sameCall(t, ir, jit, "far", 0xffffffff)
+
+	// the faults of Go code still panic.
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatalf("expected a panic")
+		}
+	}()
+	var p *int
+	*p = 1
+}
+
+func TestJITImportedMemory(t *testing.T) {
+	ctx := context.Background()
+	b := wasm.NewBuilder()
+	b.ImportMemory("env", "memory", memType(1, 0))
+	f := b.Func("f", wasm.FuncType{Params: i32, Results: i32})
+	f.Body().LocalGet(0).I32Load8U(0).End()
+	b.Export("f", f)
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compileJIT(t, m)
+
+	for _, tc := range []struct {
+		engine exec.Engine
+		want   string // error of the instantiation
+	}{
+		{exec.EngineIR, "requires a memory backed by guard pages"},
+		{exec.EngineJIT, ""},
+	} {
+		mem, err := exec.NewMemoryWithOptions(memType(1, 0), exec.MemoryOptions{Engine: tc.engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.WriteUint8(3, 42); err != nil {
+			t.Fatal(err)
+		}
+		inst, err := c.Instantiate(ctx, exec.InstantiateOptions{
+			Imports: exec.Imports{"env": exec.HostModule{"memory": mem}},
+		})
+		if tc.want != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("%v: invalid error: got=%v, want=%s", tc.engine, err, tc.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %+v", tc.engine, err)
+		}
+		if got, err := inst.Call(ctx, "f", 3); err != nil || got[0] != 42 {
+			t.Fatalf("%v: got=%v, %v", tc.engine, got, err)
+		}
+	}
+}
+
+func TestJITLinking(t *testing.T) {
+	ctx := context.Background()
+	for _, engine := range engines {
+		for _, jitLib := range []bool{false, true} {
+			store := exec.NewStore()
+			libc, err := exec.CompileWithOptions(wasmtest.Build(t, wasmtest.NewLibModule), exec.CompileOptions{Engine: engine})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jitLib {
+				libc = compileJIT(t, wasmtest.Build(t, wasmtest.NewLibModule))
+			}
+			lib, err := store.InstantiateCompiled(ctx, libc, exec.InstantiateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Register("lib", lib); err != nil {
+				t.Fatal(err)
+			}
+
+			b := wasm.NewBuilder()
+			load := b.ImportFunc("lib", "load", wasm.FuncType{Params: i32, Results: i32})
+			b.ImportTable("lib", "table", wasm.TableType{ElemType: funcref, Limits: wasm.ResizableLimits{Initial: 2}})
+			f := b.Func("f", wasm.FuncType{Params: i32x2, Results: i32})
+			f.Body().
+				LocalGet(0).Call(load).
+				LocalGet(1).I32Const(0).CallIndirect(wasm.FuncType{Params: i32, Results: i32}).
+				I32Add().End()
+			b.Export("f", f)
+			m, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := exec.CompileWithOptions(m, exec.CompileOptions{Engine: engine})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jitLib {
+				c = compileJIT(t, m)
+			}
+			inst, err := store.InstantiateCompiled(ctx, c, exec.InstantiateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := lib.Memory("memory").WriteUint32Le(8, 40); err != nil {
+				t.Fatal(err)
+			}
+			got, err := inst.Call(ctx, "f", 8, 1)
+			if err != nil {
+				t.Fatalf("%v: %+v", engine, err)
+			}
+			if got[0] != 42 {
+				t.Fatalf("%v: got=%d, want=42", engine, got[0])
+			}
+
+			_, err = inst.Call(ctx, "f", 65536, 1)
+			want := "exec: out of bounds memory access in function 1 (load) at offset 0x2\n" +
+				"wasm stack trace:\n" +
+				"\t0: function 1 (load) at offset 0x2\n" +
+				"\t1: function 1 (f) at offset 0x2"
+			if got := fmt.Sprintf("%+v", err); got != want {
+				t.Fatalf("%v: invalid trap:\ngot:\n%s\nwant:\n%s", engine, got, want)
+			}
+		}
+	}
+}
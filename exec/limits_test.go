@@ -0,0 +1,99 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	b := wasm.NewBuilder()
+	reenter := b.ImportFunc("env", "reenter", wasm.FuncType{Params: i32, Results: i32})
+
+	// depth returns its argument after recursing as many times, each
+	// frame holding 4 locals.
+	depth := b.Func("depth", wasm.FuncType{Params: i32, Results: i32})
+	e := depth.Body()
+	e.Local(wasm.I64)
+	e.Local(wasm.I64)
+	e.Local(wasm.I64)
+	e.LocalGet(0).I32Eqz()
+	e.If(wasm.I32)
+	e.I32Const(0)
+	e.Else()
+	e.LocalGet(0).I32Const(1).I32Sub().Call(depth).I32Const(1).I32Add()
+	e.End()
+	e.End()
+	b.Export("depth", depth)
+
+	// host recurses through the host function reenter.
+	host := b.Func("host", wasm.FuncType{Params: i32, Results: i32})
+	e = host.Body()
+	e.LocalGet(0).I32Eqz()
+	e.If(wasm.I32)
+	e.I32Const(0)
+	e.Else()
+	e.LocalGet(0).I32Const(1).I32Sub().Call(reenter).I32Const(1).I32Add()
+	e.End()
+	e.End()
+	b.Export("host", host)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := exec.Imports{"env": exec.HostModule{
+		"reenter": func(ctx context.Context, mod *exec.Instance, n int32) (int32, error) {
+			res, err := mod.Call(ctx, "host", uint64(n))
+			if err != nil {
+				return 0, err
+			}
+			return int32(res[0]), nil
+		},
+	}}
+
+	for _, tc := range []struct {
+		name  string
+		opts  exec.InstantiateOptions
+		fn    string
+		ok    uint64
+		fails uint64
+	}{
+		{"default", exec.InstantiateOptions{}, "depth", exec.DefaultMaxCallDepth - 1, exec.DefaultMaxCallDepth},
+		{"depth", exec.InstantiateOptions{MaxCallDepth: 100}, "depth", 99, 100},
+		{"stack", exec.InstantiateOptions{MaxStackSize: 1000}, "depth", 100, 300},
+		{"host-depth", exec.InstantiateOptions{MaxCallDepth: 100}, "host", 99, 100},
+		{"host-stack", exec.InstantiateOptions{MaxStackSize: 1000}, "host", 100, 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Imports = imports
+			inst, err := exec.InstantiateWithOptions(ctx, m, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := inst.Call(ctx, tc.fn, tc.ok)
+			if err != nil {
+				t.Fatalf("%d: %v", tc.ok, err)
+			}
+			if got[0] != tc.ok {
+				t.Fatalf("got=%d, want=%d", got[0], tc.ok)
+			}
+			_, err = inst.Call(ctx, tc.fn, tc.fails)
+			if !errors.Is(err, &exec.Trap{Kind: exec.TrapCallStackExhausted}) {
+				t.Fatalf("%d: invalid error: %v", tc.fails, err)
+			}
+			// the limits are restored after a trap.
+			if _, err := inst.Call(ctx, tc.fn, tc.ok); err != nil {
+				t.Fatalf("%d: %v", tc.ok, err)
+			}
+		})
+	}
+}
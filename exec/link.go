@@ -0,0 +1,237 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbinet/wasm"
+)
+
+// Store links modules together: it holds instances and host modules,
+// registered under module names, against which the imports of the modules
+// instantiated in the store are resolved.
+//
+// Imported functions, tables, memories and globals are shared with the
+// instances exporting them: the updates of an instance are seen by the
+// others.
+type Store struct {
+	instances map[string]*Instance
+	hosts     map[string]HostModule
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{
+		instances: make(map[string]*Instance),
+		hosts:     make(map[string]HostModule),
+	}
+}
+
+func (s *Store) registered(name string) error {
+	_, inst := s.instances[name]
+	_, host := s.hosts[name]
+	if inst || host {
+		return fmt.Errorf("exec: module %q already registered", name)
+	}
+	return nil
+}
+
+// Register registers the exports of the instance under the module name.
+func (s *Store) Register(name string, inst *Instance) error {
+	if err := s.registered(name); err != nil {
+		return err
+	}
+	s.instances[name] = inst
+	return nil
+}
+
+// RegisterHost registers the host module mod under the module name.
+func (s *Store) RegisterHost(name string, mod HostModule) error {
+	if err := s.registered(name); err != nil {
+		return err
+	}
+	s.hosts[name] = mod
+	return nil
+}
+
+// Instance returns the instance registered under the module name, or nil.
+func (s *Store) Instance(name string) *Instance {
+	return s.instances[name]
+}
+
+// Instantiate is like InstantiateWithOptions, but resolves the imports of
+// the module against the modules registered in the store, then against
+// opts.Imports.
+// The instance is not registered.
+func (s *Store) Instantiate(ctx context.Context, m *wasm.Module, opts InstantiateOptions) (*Instance, error) {
+	c, err := CompileWithOptions(m, CompileOptions{Engine: opts.Engine})
+	if err != nil {
+		return nil, err
+	}
+	return c.instantiate(ctx, opts, s)
+}
+
+// InstantiateCompiled is like Instantiate, for a compiled module.
+func (s *Store) InstantiateCompiled(ctx context.Context, c *CompiledModule, opts InstantiateOptions) (*Instance, error) {
+	return c.instantiate(ctx, opts, s)
+}
+
+// LinkError describes an import that could not be resolved.
+type LinkError struct {
+	Module string
+	Field  string
+	Kind   wasm.ExternalKind
+	Err    error
+}
+
+func (e *LinkError) Error() string {
+	return fmt.Sprintf("exec: import %q.%q (%v): %v", e.Module, e.Field, e.Kind, e.Err)
+}
+
+func (e *LinkError) Unwrap() error { return e.Err }
+
+// resolve returns the entity satisfying the import e: a *Function, a
+// *table, a *Memory or a *Global.
+func (inst *Instance) resolve(e wasm.ImportEntry, store *Store, imports Imports) (interface{}, error) {
+	lerr := func(format string, args ...interface{}) error {
+		return &LinkError{Module: e.Module, Field: e.Field, Kind: e.Kind, Err: fmt.Errorf(format, args...)}
+	}
+
+	var (
+		x   interface{}
+		mod HostModule
+		ok  bool
+	)
+	if store != nil {
+		if src, found := store.instances[e.Module]; found {
+			exp, found := src.exports[e.Field]
+			if !found {
+				return nil, lerr("unknown import %q in module %q", e.Field, e.Module)
+			}
+			if exp.Kind != e.Kind {
+				return nil, lerr("incompatible import kind %v", exp.Kind)
+			}
+			x = src.extern(exp)
+		} else {
+			mod, ok = store.hosts[e.Module]
+		}
+	}
+	if x == nil {
+		if !ok {
+			mod, ok = imports[e.Module]
+		}
+		if !ok {
+			return nil, lerr("unknown module %q", e.Module)
+		}
+		v, found := mod[e.Field]
+		if !found {
+			return nil, lerr("unknown import %q in module %q", e.Field, e.Module)
+		}
+		switch v := v.(type) {
+		case *Memory:
+			x = v
+		case *Global:
+			x = v
+		default:
+			if e.Kind != wasm.FunctionKind {
+				return nil, lerr("incompatible host definition %T", v)
+			}
+			hf, err := hostFunction(v)
+			if err != nil {
+				return nil, lerr("%w", err)
+			}
+			x = &Function{typ: hf.Type, inst: inst, idx: uint32(len(inst.funcs)), host: hf}
+		}
+	}
+
+	switch e.Kind {
+	case wasm.FunctionKind:
+		f, ok := x.(*Function)
+		if !ok {
+			return nil, lerr("incompatible host definition %T", x)
+		}
+		ft := inst.types[e.Type.(uint32)]
+		if !sameType(f.typ, ft) {
+			return nil, lerr("incompatible import type: got %s, want %s", funcTypeString(f.typ), funcTypeString(ft))
+		}
+	case wasm.TableKind:
+		t, ok := x.(*table)
+		if !ok {
+			return nil, lerr("incompatible host definition %T", x)
+		}
+		tt := e.Type.(wasm.TableType)
+		got := t.typ
+		got.Limits.Initial = uint64(len(t.elems))
+		if got.ElemType != tt.ElemType || !limitsMatch(got.Limits, tt.Limits) {
+			return nil, lerr("incompatible import type: got table %s, want table %s", limitsString(got.Limits), limitsString(tt.Limits))
+		}
+	case wasm.MemoryKind:
+		mem, ok := x.(*Memory)
+		if !ok {
+			return nil, lerr("incompatible host definition %T", x)
+		}
+		mt := e.Type.(wasm.MemoryType)
+		got := mem.limits
+		got.Initial = uint64(mem.Size())
+		if !limitsMatch(got, mt.Limits) {
+			return nil, lerr("incompatible import type: got memory %s, want memory %s", limitsString(got), limitsString(mt.Limits))
+		}
+	case wasm.GlobalKind:
+		g, ok := x.(*Global)
+		if !ok {
+			return nil, lerr("incompatible host definition %T", x)
+		}
+		gt := e.Type.(wasm.GlobalType)
+		if g.typ != gt {
+			return nil, lerr("incompatible import type: got %s, want %s", globalTypeString(g.typ), globalTypeString(gt))
+		}
+	default:
+		return nil, lerr("%v imports are not supported", e.Kind)
+	}
+	return x, nil
+}
+
+// extern returns the entity exported by e.
+func (inst *Instance) extern(e wasm.ExportEntry) interface{} {
+	switch e.Kind {
+	case wasm.FunctionKind:
+		return inst.funcs[e.Index]
+	case wasm.TableKind:
+		return inst.tables[e.Index]
+	case wasm.MemoryKind:
+		return inst.mems[e.Index]
+	case wasm.GlobalKind:
+		return inst.globals[e.Index]
+	}
+	return nil
+}
+
+// limitsMatch reports whether the limits got of a table or memory match the
+// limits want of an import.
+func limitsMatch(got, want wasm.ResizableLimits) bool {
+	if got.Initial < want.Initial {
+		return false
+	}
+	if want.Flags&wasm.LimitsMax == 0 {
+		return true
+	}
+	return got.Flags&wasm.LimitsMax != 0 && got.Maximum <= want.Maximum
+}
+
+func limitsString(l wasm.ResizableLimits) string {
+	if l.Flags&wasm.LimitsMax == 0 {
+		return fmt.Sprintf("{min %d}", l.Initial)
+	}
+	return fmt.Sprintf("{min %d, max %d}", l.Initial, l.Maximum)
+}
+
+func globalTypeString(gt wasm.GlobalType) string {
+	if gt.Mutability != 0 {
+		return "mut " + gt.ContentType.String()
+	}
+	return gt.ContentType.String()
+}
@@ -0,0 +1,298 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+var (
+	funcref  = wasm.ElemType(wasm.Op_anyfunc)
+	mutI32   = wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}
+	constI32 = wasm.GlobalType{ContentType: wasm.I32}
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store := exec.NewStore()
+	lib, err := store.Instantiate(ctx, wasmtest.Build(t, wasmtest.NewLibModule), exec.InstantiateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Register("lib", lib); err != nil {
+		t.Fatal(err)
+	}
+	if store.Instance("lib") != lib {
+		t.Fatalf("invalid registered instance")
+	}
+
+	b := wasm.NewBuilder()
+	mem := b.ImportMemory("lib", "memory", memType(1, 0))
+	counter := b.ImportGlobal("lib", "counter", mutI32)
+	answer := b.ImportGlobal("lib", "answer", constI32)
+	table := b.ImportTable("lib", "table", wasm.TableType{ElemType: funcref, Limits: wasm.ResizableLimits{Initial: 1}})
+	incr := b.ImportFunc("lib", "incr", wasm.FuncType{Results: i32})
+	b.Export("memory", mem)
+
+	// store stores the value of answer at its argument.
+	store42 := b.Func("store", wasm.FuncType{Params: i32})
+	store42.Body().LocalGet(0).GlobalGet(answer).I32Store(0).End()
+	b.Export("store", store42)
+
+	// bump adds 10 to the counter, then calls incr.
+	bump := b.Func("bump", wasm.FuncType{Results: i32})
+	bump.Body().GlobalGet(counter).I32Const(10).I32Add().GlobalSet(counter).Call(incr).End()
+	b.Export("bump", bump)
+
+	// call calls the function at index 1 of the table.
+	triple := b.Func("triple", wasm.FuncType{Params: i32, Results: i32})
+	triple.Body().LocalGet(0).I32Const(3).I32Mul().End()
+	b.Elements(table, wasm.ConstI32(1), triple)
+	call := b.Func("call", wasm.FuncType{Params: i32x2, Results: i32})
+	call.Body().LocalGet(0).LocalGet(1).CallIndirect(wasm.FuncType{Params: i32, Results: i32}).End()
+	b.Export("call", call)
+
+	grow := b.Func("grow", wasm.FuncType{Results: i32})
+	grow.Body().I32Const(1).MemoryGrow().End()
+	b.Export("grow", grow)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := store.Instantiate(ctx, m, exec.InstantiateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.Call(ctx, "store", 8); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lib.Call(ctx, "load", 8); err != nil || got[0] != 42 {
+		t.Fatalf("memory not shared: got=%v, %v", got, err)
+	}
+
+	if got, err := app.Call(ctx, "bump"); err != nil || got[0] != 11 {
+		t.Fatalf("bump: got=%v, %v", got, err)
+	}
+	if got, err := lib.Call(ctx, "incr"); err != nil || got[0] != 12 {
+		t.Fatalf("global not shared: got=%v, %v", got, err)
+	}
+	lib.Global("counter").Set(100)
+	if got, err := app.Call(ctx, "bump"); err != nil || got[0] != 111 {
+		t.Fatalf("bump: got=%v, %v", got, err)
+	}
+
+	for _, tc := range []struct{ idx, arg, want uint64 }{
+		{0, 5, 10}, // lib's double
+		{1, 5, 15}, // app's triple, stored in lib's table
+	} {
+		got, err := app.Call(ctx, "call", tc.arg, tc.idx)
+		if err != nil || got[0] != tc.want {
+			t.Fatalf("call(%d): got=%v, %v, want=%d", tc.idx, got, err, tc.want)
+		}
+	}
+
+	if got, err := app.Call(ctx, "grow"); err != nil || got[0] != 1 {
+		t.Fatalf("grow: got=%v, %v", got, err)
+	}
+	if got := lib.Memory("memory").Size(); got != 2 {
+		t.Fatalf("memory growth not shared: size=%d", got)
+	}
+	if app.Memory("memory") != lib.Memory("memory") {
+		t.Fatalf("memory not shared")
+	}
+
+	// traps of the functions of an instance called from another one
+	// report their frames.
+	_, err = lib.Call(ctx, "load", wasm.PageSize*2)
+	var trap *exec.Trap
+	if !errors.As(err, &trap) || trap.Stack[0].Name != "load" {
+		t.Fatalf("invalid trap: %+v", err)
+	}
+
+	if err := store.Register("lib", app); err == nil {
+		t.Fatalf("expected a duplicate registration error")
+	}
+	if err := store.RegisterHost("lib", exec.HostModule{}); err == nil {
+		t.Fatalf("expected a duplicate registration error")
+	}
+}
+
+func TestStoreHost(t *testing.T) {
+	ctx := context.Background()
+	mem, err := exec.NewMemory(memType(1, 2), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := exec.NewGlobal(mutI32, 7)
+
+	store := exec.NewStore()
+	err = store.RegisterHost("env", exec.HostModule{
+		"memory": mem,
+		"g":      g,
+		"add":    func(a, b int32) int32 { return a + b },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := wasm.NewBuilder()
+	b.ImportMemory("env", "memory", memType(1, 2))
+	gi := b.ImportGlobal("env", "g", mutI32)
+	add := b.ImportFunc("env", "add", wasm.FuncType{Params: i32x2, Results: i32})
+	// f increments g with add, then stores it at address 3.
+	f := b.Func("f", wasm.FuncType{})
+	f.Body().I32Const(3).GlobalGet(gi).I32Const(1).Call(add).GlobalSet(gi).GlobalGet(gi).I32Store8(0).End()
+	b.Export("f", f)
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := store.Instantiate(ctx, m, exec.InstantiateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.Call(ctx, "f"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Get() != 8 {
+		t.Fatalf("invalid global: %d", g.Get())
+	}
+	if v, _ := mem.ReadUint8(3); v != 8 {
+		t.Fatalf("invalid memory: %d", v)
+	}
+}
+
+func TestStoreLinkErrors(t *testing.T) {
+	ctx := context.Background()
+	store := exec.NewStore()
+	lib, err := store.Instantiate(ctx, wasmtest.Build(t, wasmtest.NewLibModule), exec.InstantiateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Register("lib", lib); err != nil {
+		t.Fatal(err)
+	}
+	mem, _ := exec.NewMemory(memType(1, 0), 0)
+	if err := store.RegisterHost("env", exec.HostModule{"memory": mem, "g": exec.NewGlobal(constI32, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		mk   func(b *wasm.Builder)
+		want string
+	}{
+		{
+			name: "func-type",
+			mk: func(b *wasm.Builder) {
+				b.ImportFunc("lib", "incr", wasm.FuncType{Results: i64})
+			},
+			want: `exec: import "lib"."incr" (function): incompatible import type: got [] -> [i32], want [] -> [i64]`,
+		},
+		{
+			name: "kind",
+			mk: func(b *wasm.Builder) {
+				b.ImportFunc("lib", "memory", wasm.FuncType{})
+			},
+			want: `exec: import "lib"."memory" (function): incompatible import kind memory`,
+		},
+		{
+			name: "unknown",
+			mk: func(b *wasm.Builder) {
+				b.ImportFunc("lib", "nope", wasm.FuncType{})
+			},
+			want: `exec: import "lib"."nope" (function): unknown import "nope" in module "lib"`,
+		},
+		{
+			name: "memory-min",
+			mk: func(b *wasm.Builder) {
+				b.ImportMemory("lib", "memory", memType(2, 0))
+			},
+			want: `exec: import "lib"."memory" (memory): incompatible import type: got memory {min 1, max 4}, want memory {min 2}`,
+		},
+		{
+			name: "memory-max",
+			mk: func(b *wasm.Builder) {
+				b.ImportMemory("lib", "memory", memType(1, 3))
+			},
+			want: `incompatible import type: got memory {min 1, max 4}, want memory {min 1, max 3}`,
+		},
+		{
+			name: "memory-no-max",
+			mk: func(b *wasm.Builder) {
+				b.ImportMemory("env", "memory", memType(1, 3))
+			},
+			want: `incompatible import type: got memory {min 1}, want memory {min 1, max 3}`,
+		},
+		{
+			name: "table-min",
+			mk: func(b *wasm.Builder) {
+				b.ImportTable("lib", "table", wasm.TableType{ElemType: funcref, Limits: wasm.ResizableLimits{Initial: 3}})
+			},
+			want: `incompatible import type: got table {min 2}, want table {min 3}`,
+		},
+		{
+			name: "global-mut",
+			mk: func(b *wasm.Builder) {
+				b.ImportGlobal("lib", "counter", constI32)
+			},
+			want: `exec: import "lib"."counter" (global): incompatible import type: got mut i32, want i32`,
+		},
+		{
+			name: "global-type",
+			mk: func(b *wasm.Builder) {
+				b.ImportGlobal("env", "g", wasm.GlobalType{ContentType: wasm.I64})
+			},
+			want: `incompatible import type: got i32, want i64`,
+		},
+		{
+			name: "host-kind",
+			mk: func(b *wasm.Builder) {
+				b.ImportGlobal("env", "memory", constI32)
+			},
+			want: `exec: import "env"."memory" (global): incompatible host definition *exec.Memory`,
+		},
+		{
+			name: "host-table",
+			mk: func(b *wasm.Builder) {
+				b.ImportTable("env", "memory", wasm.TableType{ElemType: funcref})
+			},
+			want: `exec: import "env"."memory" (table): incompatible host definition *exec.Memory`,
+		},
+		{
+			name: "module",
+			mk: func(b *wasm.Builder) {
+				b.ImportMemory("other", "memory", memType(1, 0))
+			},
+			want: `exec: import "other"."memory" (memory): unknown module "other"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			tc.mk(b)
+			m, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.Instantiate(ctx, m, exec.InstantiateOptions{})
+			var lerr *exec.LinkError
+			if !errors.As(err, &lerr) {
+				t.Fatalf("expected a link error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%s", err, tc.want)
+			}
+		})
+	}
+}
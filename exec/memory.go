@@ -0,0 +1,408 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+var order = binary.LittleEndian
+
+// Memory is a linear memory.
+//
+// All accesses are bounds-checked: they fail with an error, and do not
+// modify the memory, if they are not entirely within the memory.
+// Memory implements io.ReaderAt and io.WriterAt.
+//
+// The memories of EngineJIT are backed, on Linux, by a reservation of
+// address space followed by guard pages: growing them does not copy them,
+// and the machine code does not check the bounds of its accesses. The
+// other memories are allocated by Go.
+type Memory struct {
+	buf    []byte
+	res    []byte               // reservation backing buf, or nil
+	max    uint32               // maximum number of pages
+	limits wasm.ResizableLimits // declared limits
+}
+
+// MemoryOptions configures a memory.
+type MemoryOptions struct {
+	// MaxPages, if not zero, caps the size in pages of the memory, even
+	// if its type allows it to grow further.
+	MaxPages uint32
+
+	// Engine is the engine of the instances using the memory, EngineIR
+	// by default. Only EngineJIT memories reserve address space and guard
+	// pages.
+	Engine Engine
+}
+
+// NewMemory returns a zeroed memory of type mt, for the instances of
+// EngineIR and EngineBytecode.
+// If maxPages is not zero, the memory cannot grow beyond maxPages pages,
+// even if its type allows it.
+func NewMemory(mt wasm.MemoryType, maxPages uint32) (*Memory, error) {
+	return NewMemoryWithOptions(mt, MemoryOptions{MaxPages: maxPages})
+}
+
+// NewMemoryWithOptions is like NewMemory but with configurable options.
+func NewMemoryWithOptions(mt wasm.MemoryType, opts MemoryOptions) (*Memory, error) {
+	max := uint32(wasm.MaxPages)
+	if mt.Limits.Flags&wasm.LimitsMax != 0 && mt.Limits.Maximum < uint64(max) {
+		max = uint32(mt.Limits.Maximum)
+	}
+	if opts.MaxPages != 0 && opts.MaxPages < max {
+		max = opts.MaxPages
+	}
+	if mt.Limits.Initial > uint64(max) {
+		return nil, fmt.Errorf("exec: memory of %d pages exceeds the maximum of %d pages", mt.Limits.Initial, max)
+	}
+	mem := &Memory{
+		max:    max,
+		limits: mt.Limits,
+	}
+	n := int(mt.Limits.Initial) * wasm.PageSize
+	if opts.Engine == EngineJIT {
+		mem.res = reserveMemory(n)
+	}
+	if mem.res != nil {
+		mem.buf = mem.res[:n:n]
+		runtime.SetFinalizer(mem, func(mem *Memory) { releaseMemory(mem.res) })
+	} else {
+		mem.buf = make([]byte, n)
+	}
+	return mem, nil
+}
+
+// InitialMemory returns the i-th memory of the module m, initialized with
+// its active data segments, without instantiating the module.
+//
+// The offsets of the data segments must be constant expressions that do
+// not depend on imported globals.
+func InitialMemory(m *wasm.Module, i uint32) (*Memory, error) {
+	var (
+		mems    []wasm.MemoryType
+		globals []wasm.GlobalVariable
+		nimport int // number of imported globals
+		data    []wasm.DataSegment
+	)
+	for _, sec := range m.Sections {
+		switch s := sec.(type) {
+		case wasm.ImportSection:
+			for _, e := range s.Imports {
+				switch e.Kind {
+				case wasm.MemoryKind:
+					mems = append(mems, e.Type.(wasm.MemoryType))
+				case wasm.GlobalKind:
+					nimport++
+				}
+			}
+		case wasm.MemorySection:
+			mems = append(mems, s.Memories...)
+		case wasm.GlobalSection:
+			globals = s.Globals
+		case wasm.DataSection:
+			data = s.Segments
+		}
+	}
+	if uint64(i) >= uint64(len(mems)) {
+		return nil, fmt.Errorf("exec: invalid memory index %d", i)
+	}
+	mem, err := NewMemory(mems[i], 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset func(ie wasm.InitExpr, depth int) (uint32, error)
+	offset = func(ie wasm.InitExpr, depth int) (uint32, error) {
+		code := ie.Expr
+		switch {
+		case len(code) > 0 && wasm.Opcode(code[0]) == wasm.Op_i32_const:
+			v, _ := instr.ReadS32(code, 1)
+			return uint32(v), nil
+		case len(code) > 0 && wasm.Opcode(code[0]) == wasm.Op_get_global && depth == 0:
+			idx, _ := instr.ReadU32(code, 1)
+			if int(idx) < nimport || int(idx)-nimport >= len(globals) {
+				return 0, fmt.Errorf("offset depends on imported global %d", idx)
+			}
+			return offset(globals[int(idx)-nimport].Init, depth+1)
+		}
+		return 0, fmt.Errorf("unsupported offset expression")
+	}
+	for j, ds := range data {
+		if ds.Index != i || ds.Flags&wasm.DataPassive != 0 {
+			continue
+		}
+		off, err := offset(ds.Offset, 0)
+		if err != nil {
+			return nil, fmt.Errorf("exec: data segment %d: %w", j, err)
+		}
+		if err := mem.Write(off, ds.Data); err != nil {
+			return nil, fmt.Errorf("exec: data segment %d: %w", j, err)
+		}
+	}
+	return mem, nil
+}
+
+// Size returns the size of the memory in pages.
+func (mem *Memory) Size() uint32 {
+	return uint32(len(mem.buf) / wasm.PageSize)
+}
+
+// Len returns the size of the memory in bytes.
+func (mem *Memory) Len() int {
+	return len(mem.buf)
+}
+
+// Max returns the maximum size of the memory in pages.
+func (mem *Memory) Max() uint32 {
+	return mem.max
+}
+
+// Bytes returns the content of the memory.
+// The returned slice aliases the memory until it grows, and must not be
+// used once the memory is unreachable.
+func (mem *Memory) Bytes() []byte {
+	return mem.buf
+}
+
+// Grow grows the memory by delta pages and returns its previous size in
+// pages. It reports false, and leaves the memory unchanged, if the memory
+// cannot grow.
+func (mem *Memory) Grow(delta uint32) (uint32, bool) {
+	old := mem.Size()
+	if uint64(old)+uint64(delta) > uint64(mem.max) {
+		return old, false
+	}
+	if delta == 0 {
+		return old, true
+	}
+	n := (int(old) + int(delta)) * wasm.PageSize
+	if mem.res != nil {
+		if err := commitMemory(mem.res, len(mem.buf), n); err != nil {
+			return old, false
+		}
+		mem.buf = mem.res[:n:n]
+		return old, true
+	}
+	buf := make([]byte, n)
+	copy(buf, mem.buf)
+	mem.buf = buf
+	return old, true
+}
+
+// reset resets the memory to its initial size, and zeroes it.
+func (mem *Memory) reset() {
+	n := int(mem.limits.Initial) * wasm.PageSize
+	switch {
+	case mem.res != nil:
+		resetMemory(mem.res, len(mem.buf), n)
+		mem.buf = mem.res[:n:n]
+	case len(mem.buf) != n:
+		mem.buf = make([]byte, n)
+	default:
+		buf := mem.buf
+		for i := range buf {
+			buf[i] = 0
+		}
+	}
+}
+
+// grow implements the memory.grow instruction: it returns the previous
+// size of the memory, or -1 if it cannot grow.
+func (mem *Memory) grow(delta uint32) int32 {
+	old, ok := mem.Grow(delta)
+	if !ok {
+		return -1
+	}
+	return int32(old)
+}
+
+// addr returns the address of an access of n bytes at the offset off from
+// the address base, or traps if it is out of bounds.
+func (mem *Memory) addr(base uint64, off uint32, n uint64) uint64 {
+	ea := uint64(uint32(base)) + uint64(off)
+	if ea+n > uint64(len(mem.buf)) {
+		trap(TrapOutOfBoundsMemory)
+	}
+	return ea
+}
+
+// slice returns the n bytes at offset off.
+// The returned slice aliases the memory until it grows: the memory must be
+// kept alive while it is used, see runtime.KeepAlive.
+func (mem *Memory) slice(off uint32, n uint64) ([]byte, error) {
+	if uint64(off)+n > uint64(len(mem.buf)) {
+		return nil, newTrap(TrapOutOfBoundsMemory)
+	}
+	return mem.buf[off : uint64(off)+n : uint64(off)+n], nil
+}
+
+// Read returns the n bytes at offset off.
+// The returned slice aliases the memory until it grows, and must not be
+// used once the memory is unreachable.
+func (mem *Memory) Read(off, n uint32) ([]byte, error) {
+	return mem.slice(off, uint64(n))
+}
+
+// Write writes p at offset off.
+func (mem *Memory) Write(off uint32, p []byte) error {
+	b, err := mem.slice(off, uint64(len(p)))
+	if err != nil {
+		return err
+	}
+	copy(b, p)
+	runtime.KeepAlive(mem)
+	return nil
+}
+
+// ReadUint8 returns the byte at offset off.
+func (mem *Memory) ReadUint8(off uint32) (uint8, error) {
+	b, err := mem.slice(off, 1)
+	if err != nil {
+		return 0, err
+	}
+	v := b[0]
+	runtime.KeepAlive(mem)
+	return v, nil
+}
+
+// ReadUint16Le returns the little-endian uint16 at offset off.
+func (mem *Memory) ReadUint16Le(off uint32) (uint16, error) {
+	b, err := mem.slice(off, 2)
+	if err != nil {
+		return 0, err
+	}
+	v := order.Uint16(b)
+	runtime.KeepAlive(mem)
+	return v, nil
+}
+
+// ReadUint32Le returns the little-endian uint32 at offset off.
+func (mem *Memory) ReadUint32Le(off uint32) (uint32, error) {
+	b, err := mem.slice(off, 4)
+	if err != nil {
+		return 0, err
+	}
+	v := order.Uint32(b)
+	runtime.KeepAlive(mem)
+	return v, nil
+}
+
+// ReadUint64Le returns the little-endian uint64 at offset off.
+func (mem *Memory) ReadUint64Le(off uint32) (uint64, error) {
+	b, err := mem.slice(off, 8)
+	if err != nil {
+		return 0, err
+	}
+	v := order.Uint64(b)
+	runtime.KeepAlive(mem)
+	return v, nil
+}
+
+// ReadFloat32Le returns the little-endian float32 at offset off.
+func (mem *Memory) ReadFloat32Le(off uint32) (float32, error) {
+	v, err := mem.ReadUint32Le(off)
+	return math.Float32frombits(v), err
+}
+
+// ReadFloat64Le returns the little-endian float64 at offset off.
+func (mem *Memory) ReadFloat64Le(off uint32) (float64, error) {
+	v, err := mem.ReadUint64Le(off)
+	return math.Float64frombits(v), err
+}
+
+// WriteUint8 writes the byte v at offset off.
+func (mem *Memory) WriteUint8(off uint32, v uint8) error {
+	b, err := mem.slice(off, 1)
+	if err != nil {
+		return err
+	}
+	b[0] = v
+	runtime.KeepAlive(mem)
+	return nil
+}
+
+// WriteUint16Le writes v in little-endian order at offset off.
+func (mem *Memory) WriteUint16Le(off uint32, v uint16) error {
+	b, err := mem.slice(off, 2)
+	if err != nil {
+		return err
+	}
+	order.PutUint16(b, v)
+	runtime.KeepAlive(mem)
+	return nil
+}
+
+// WriteUint32Le writes v in little-endian order at offset off.
+func (mem *Memory) WriteUint32Le(off uint32, v uint32) error {
+	b, err := mem.slice(off, 4)
+	if err != nil {
+		return err
+	}
+	order.PutUint32(b, v)
+	runtime.KeepAlive(mem)
+	return nil
+}
+
+// WriteUint64Le writes v in little-endian order at offset off.
+func (mem *Memory) WriteUint64Le(off uint32, v uint64) error {
+	b, err := mem.slice(off, 8)
+	if err != nil {
+		return err
+	}
+	order.PutUint64(b, v)
+	runtime.KeepAlive(mem)
+	return nil
+}
+
+// WriteFloat32Le writes v in little-endian order at offset off.
+func (mem *Memory) WriteFloat32Le(off uint32, v float32) error {
+	return mem.WriteUint32Le(off, math.Float32bits(v))
+}
+
+// WriteFloat64Le writes v in little-endian order at offset off.
+func (mem *Memory) WriteFloat64Le(off uint32, v float64) error {
+	return mem.WriteUint64Le(off, math.Float64bits(v))
+}
+
+// ReadAt implements io.ReaderAt.
+func (mem *Memory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("exec: negative offset")
+	}
+	if off >= int64(len(mem.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, mem.buf[off:])
+	runtime.KeepAlive(mem)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+// WriteAt writes nothing if p does not fit in the memory.
+func (mem *Memory) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(mem.buf)) {
+		return 0, newTrap(TrapOutOfBoundsMemory)
+	}
+	n := copy(mem.buf[off:], p)
+	runtime.KeepAlive(mem)
+	return n, nil
+}
+
+var (
+	_ io.ReaderAt = (*Memory)(nil)
+	_ io.WriterAt = (*Memory)(nil)
+)
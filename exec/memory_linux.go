@@ -0,0 +1,62 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package exec
+
+import (
+	"syscall"
+
+	"github.com/sbinet/wasm"
+)
+
+// memoryReservation is the size of the address space reserved for a
+// memory. It covers any access at a 32-bit address plus a 32-bit offset,
+// so that the accesses beyond the memory fault on its guard pages.
+var memoryReservation = uint64(8<<30 + wasm.PageSize)
+
+// reserveMemory reserves the address space of a memory, and makes its
+// first n bytes accessible. It returns nil if the address space cannot be
+// reserved.
+func reserveMemory(n int) []byte {
+	size := int(memoryReservation)
+	if uint64(size) != memoryReservation {
+		return nil // 32-bit platform
+	}
+	res, err := syscall.Mmap(-1, 0, size, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON|syscall.MAP_NORESERVE)
+	if err != nil {
+		return nil
+	}
+	if err := commitMemory(res, 0, n); err != nil {
+		syscall.Munmap(res)
+		return nil
+	}
+	return res
+}
+
+// commitMemory makes the bytes res[old:n] of a reservation accessible.
+func commitMemory(res []byte, old, n int) error {
+	if n <= old {
+		return nil
+	}
+	return syscall.Mprotect(res[old:n], syscall.PROT_READ|syscall.PROT_WRITE)
+}
+
+// resetMemory zeroes the first n accessible bytes of a reservation, and
+// makes the bytes res[n:old] inaccessible.
+func resetMemory(res []byte, old, n int) {
+	if old > 0 {
+		// discarded pages read as zero.
+		syscall.Madvise(res[:old], syscall.MADV_DONTNEED)
+	}
+	if n < old {
+		syscall.Mprotect(res[n:old], syscall.PROT_NONE)
+	}
+}
+
+// releaseMemory releases a reservation.
+func releaseMemory(res []byte) {
+	syscall.Munmap(res)
+}
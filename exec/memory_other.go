@@ -0,0 +1,16 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package exec
+
+// reserveMemory returns nil: memories are allocated by Go.
+func reserveMemory(n int) []byte { return nil }
+
+func commitMemory(res []byte, old, n int) error { panic("unreachable") }
+
+func resetMemory(res []byte, old, n int) { panic("unreachable") }
+
+func releaseMemory(res []byte) { panic("unreachable") }
@@ -0,0 +1,235 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func memType(initial, max uint64) wasm.MemoryType {
+	mt := wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: initial}}
+	if max != 0 {
+		mt.Limits.Flags = wasm.LimitsMax
+		mt.Limits.Maximum = max
+	}
+	return mt
+}
+
+func TestMemory(t *testing.T) {
+	for _, engine := range []exec.Engine{exec.EngineIR, exec.EngineJIT} {
+		t.Run(engine.String(), func(t *testing.T) {
+			mem, err := exec.NewMemoryWithOptions(memType(1, 0), exec.MemoryOptions{MaxPages: 3, Engine: engine})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mem.Size(), uint32(1); got != want {
+				t.Fatalf("invalid size: got=%d, want=%d", got, want)
+			}
+			if got, want := mem.Len(), wasm.PageSize; got != want {
+				t.Fatalf("invalid length: got=%d, want=%d", got, want)
+			}
+			if got, want := mem.Max(), uint32(3); got != want {
+				t.Fatalf("invalid maximum: got=%d, want=%d", got, want)
+			}
+
+			const end = wasm.PageSize
+			if err := mem.WriteUint32Le(end-4, 0xdeadbeef); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := mem.ReadUint32Le(end - 4); err != nil || v != 0xdeadbeef {
+				t.Fatalf("invalid uint32: got=%#x, %v", v, err)
+			}
+			if v, err := mem.ReadUint16Le(end - 2); err != nil || v != 0xdead {
+				t.Fatalf("invalid uint16: got=%#x, %v", v, err)
+			}
+			if v, err := mem.ReadUint8(end - 4); err != nil || v != 0xef {
+				t.Fatalf("invalid uint8: got=%#x, %v", v, err)
+			}
+			if err := mem.WriteFloat64Le(8, 1.5); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := mem.ReadFloat64Le(8); err != nil || v != 1.5 {
+				t.Fatalf("invalid float64: got=%v, %v", v, err)
+			}
+			if err := mem.Write(16, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := mem.Read(16, 5); err != nil || string(b) != "hello" {
+				t.Fatalf("invalid read: got=%q, %v", b, err)
+			}
+
+			for _, tc := range []struct {
+				name string
+				f    func() error
+			}{
+				{"read-uint32", func() error { _, err := mem.ReadUint32Le(end - 3); return err }},
+				{"read-uint64", func() error { _, err := mem.ReadUint64Le(0xffffffff); return err }},
+				{"read", func() error { _, err := mem.Read(end-4, 5); return err }},
+				{"write", func() error { return mem.Write(end-1, []byte("ab")) }},
+				{"write-uint16", func() error { return mem.WriteUint16Le(end-1, 0xffff) }},
+			} {
+				if err := tc.f(); err == nil || !strings.Contains(err.Error(), "out of bounds memory access") {
+					t.Fatalf("%s: invalid error: %v", tc.name, err)
+				}
+			}
+			if v, _ := mem.ReadUint8(end - 1); v != 0xde {
+				t.Fatalf("out of bounds write modified the memory")
+			}
+
+			buf := make([]byte, 8)
+			n, err := mem.ReadAt(buf, end-4)
+			if n != 4 || err != io.EOF {
+				t.Fatalf("invalid ReadAt: n=%d, err=%v", n, err)
+			}
+			if _, err := mem.WriteAt([]byte("abc"), end-2); err == nil {
+				t.Fatalf("expected an error")
+			}
+			r := io.NewSectionReader(mem, 16, 5)
+			if b, err := io.ReadAll(r); err != nil || string(b) != "hello" {
+				t.Fatalf("invalid section: got=%q, %v", b, err)
+			}
+
+			base := &mem.Bytes()[0]
+			for _, tc := range []struct {
+				delta uint32
+				old   uint32
+				ok    bool
+			}{
+				{0, 1, true},
+				{3, 1, false},
+				{2, 1, true},
+				{1, 3, false},
+			} {
+				old, ok := mem.Grow(tc.delta)
+				if old != tc.old || ok != tc.ok {
+					t.Fatalf("grow(%d): got=(%d, %v), want=(%d, %v)", tc.delta, old, ok, tc.old, tc.ok)
+				}
+			}
+			if b, _ := mem.Read(16, 5); string(b) != "hello" {
+				t.Fatalf("grow lost the content of the memory")
+			}
+			if engine == exec.EngineJIT && runtime.GOOS == "linux" && &mem.Bytes()[0] != base {
+				// the memory is backed by a reservation of address space.
+				t.Fatalf("grow moved the memory")
+			}
+
+			if _, err := exec.NewMemory(memType(4, 0), 3); err == nil {
+				t.Fatalf("expected an error")
+			}
+			mem, err = exec.NewMemory(memType(1, 2), 3)
+			if err != nil || mem.Max() != 2 {
+				t.Fatalf("invalid maximum: %v", err)
+			}
+		})
+	}
+}
+
+func TestInitialMemory(t *testing.T) {
+	b := wasm.NewBuilder()
+	b.ImportGlobal("env", "base", wasm.GlobalType{ContentType: wasm.I32})
+	mem := b.Memory(memType(1, 0))
+	b.Global(wasm.GlobalType{ContentType: wasm.I32}, wasm.ConstI32(32))
+	b.Data(mem, wasm.ConstI32(8), []byte("abc"))
+	b.Data(mem, wasm.ConstGlobal(1), []byte("def"))
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := exec.InitialMemory(m, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := got.Read(8, 3); string(b) != "abc" {
+		t.Fatalf("invalid data: %q", b)
+	}
+	if b, _ := got.Read(32, 3); string(b) != "def" {
+		t.Fatalf("invalid data: %q", b)
+	}
+	if _, err := exec.InitialMemory(m, 1); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	b.Data(mem, wasm.ConstGlobal(0), []byte("ghi"))
+	m, err = b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exec.InitialMemory(m, 0)
+	if err == nil || !strings.Contains(err.Error(), "data segment 2: offset depends on imported global 0") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
+
+func TestInstanceMemory(t *testing.T) {
+	b := wasm.NewBuilder()
+	puts := b.ImportFunc("env", "puts", wasm.FuncType{Params: i32x2, Results: i32})
+	mem := b.Memory(memType(1, 0))
+	b.Export("memory", mem)
+	b.Data(mem, wasm.ConstI32(100), []byte("hello, world"))
+	f := b.Func("f", wasm.FuncType{Results: i32})
+	f.Body().I32Const(100).I32Const(12).Call(puts).End()
+	b.Export("f", f)
+	bad := b.Func("bad", wasm.FuncType{Results: i32})
+	bad.Body().I32Const(2*wasm.PageSize - 4).I32Const(12).Call(puts).End()
+	b.Export("bad", bad)
+	grow := b.Func("grow", wasm.FuncType{Params: i32, Results: i32})
+	grow.Body().LocalGet(0).MemoryGrow().End()
+	b.Export("grow", grow)
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out string
+	imports := exec.Imports{
+		"env": exec.HostModule{
+			"puts": func(mod *exec.Instance, ptr, n uint32) (uint32, error) {
+				b, err := mod.Memory("memory").Read(ptr, n)
+				if err != nil {
+					return 0, err
+				}
+				out = string(b)
+				return n, mod.Memory("memory").WriteUint32Le(0, n)
+			},
+		},
+	}
+	ctx := context.Background()
+	inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{
+		Imports:        imports,
+		MaxMemoryPages: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.Call(ctx, "f"); err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello, world" {
+		t.Fatalf("invalid output: %q", out)
+	}
+	if v, _ := inst.Memory("memory").ReadUint32Le(0); v != 12 {
+		t.Fatalf("invalid result: %d", v)
+	}
+	if inst.Memory("f") != nil {
+		t.Fatalf("expected no memory")
+	}
+
+	for _, want := range []uint64{1, 0xffffffff} {
+		got, err := inst.Call(ctx, "grow", 1)
+		if err != nil || got[0] != want {
+			t.Fatalf("grow: got=%v, %v, want=%#x", got, err, want)
+		}
+	}
+	if _, err := inst.Call(ctx, "bad"); err == nil || !strings.Contains(err.Error(), "out of bounds memory access") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
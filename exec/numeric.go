@@ -0,0 +1,477 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/sbinet/wasm"
+)
+
+// load executes the load instruction op from the address addr.
+func load(mem *Memory, op wasm.Opcode, addr uint64, off uint32) uint64 {
+	switch op {
+	case wasm.Op_i32_load, wasm.Op_f32_load:
+		return uint64(order.Uint32(mem.buf[mem.addr(addr, off, 4):]))
+	case wasm.Op_i64_load, wasm.Op_f64_load:
+		return order.Uint64(mem.buf[mem.addr(addr, off, 8):])
+	case wasm.Op_i32_load8_s:
+		return uint64(uint32(int8(mem.buf[mem.addr(addr, off, 1)])))
+	case wasm.Op_i32_load8_u, wasm.Op_i64_load8_u:
+		return uint64(mem.buf[mem.addr(addr, off, 1)])
+	case wasm.Op_i32_load16_s:
+		return uint64(uint32(int16(order.Uint16(mem.buf[mem.addr(addr, off, 2):]))))
+	case wasm.Op_i32_load16_u, wasm.Op_i64_load16_u:
+		return uint64(order.Uint16(mem.buf[mem.addr(addr, off, 2):]))
+	case wasm.Op_i64_load8_s:
+		return uint64(int8(mem.buf[mem.addr(addr, off, 1)]))
+	case wasm.Op_i64_load16_s:
+		return uint64(int16(order.Uint16(mem.buf[mem.addr(addr, off, 2):])))
+	case wasm.Op_i64_load32_s:
+		return uint64(int32(order.Uint32(mem.buf[mem.addr(addr, off, 4):])))
+	case wasm.Op_i64_load32_u:
+		return uint64(order.Uint32(mem.buf[mem.addr(addr, off, 4):]))
+	}
+	panic("unreachable")
+}
+
+// store executes the store instruction op of the value v at the address
+// addr.
+func store(mem *Memory, op wasm.Opcode, addr uint64, off uint32, v uint64) {
+	switch op {
+	case wasm.Op_i32_store, wasm.Op_f32_store, wasm.Op_i64_store32:
+		order.PutUint32(mem.buf[mem.addr(addr, off, 4):], uint32(v))
+	case wasm.Op_i64_store, wasm.Op_f64_store:
+		order.PutUint64(mem.buf[mem.addr(addr, off, 8):], v)
+	case wasm.Op_i32_store8, wasm.Op_i64_store8:
+		mem.buf[mem.addr(addr, off, 1)] = byte(v)
+	case wasm.Op_i32_store16, wasm.Op_i64_store16:
+		order.PutUint16(mem.buf[mem.addr(addr, off, 2):], uint16(v))
+	}
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// isUnary reports whether the numeric instruction op takes one operand.
+func isUnary(op wasm.Opcode) bool {
+	switch {
+	case op == wasm.Op_i32_eqz, op == wasm.Op_i64_eqz,
+		op >= wasm.Op_i32_clz && op <= wasm.Op_i32_popcnt,
+		op >= wasm.Op_i64_clz && op <= wasm.Op_i64_popcnt,
+		op >= wasm.Op_f32_abs && op <= wasm.Op_f32_sqrt,
+		op >= wasm.Op_f64_abs && op <= wasm.Op_f64_sqrt,
+		op >= wasm.Op_i32_wrap_i64:
+		return true
+	}
+	return false
+}
+
+// unop executes the numeric instruction op, taking one operand.
+func unop(op wasm.Opcode, v uint64) uint64 {
+	const (
+		sign32 = 1 << 31
+		sign64 = 1 << 63
+	)
+	switch op {
+	case wasm.Op_i32_eqz:
+		return b2u(uint32(v) == 0)
+	case wasm.Op_i64_eqz:
+		return b2u(v == 0)
+	case wasm.Op_i32_clz:
+		return uint64(bits.LeadingZeros32(uint32(v)))
+	case wasm.Op_i32_ctz:
+		return uint64(bits.TrailingZeros32(uint32(v)))
+	case wasm.Op_i32_popcnt:
+		return uint64(bits.OnesCount32(uint32(v)))
+	case wasm.Op_i64_clz:
+		return uint64(bits.LeadingZeros64(v))
+	case wasm.Op_i64_ctz:
+		return uint64(bits.TrailingZeros64(v))
+	case wasm.Op_i64_popcnt:
+		return uint64(bits.OnesCount64(v))
+	case wasm.Op_f32_abs:
+		return uint64(uint32(v) &^ sign32)
+	case wasm.Op_f32_neg:
+		return uint64(uint32(v) ^ sign32)
+	case wasm.Op_f32_ceil:
+		return uint64(math.Float32bits(float32(math.Ceil(float64(f32(v))))))
+	case wasm.Op_f32_floor:
+		return uint64(math.Float32bits(float32(math.Floor(float64(f32(v))))))
+	case wasm.Op_f32_trunc:
+		return uint64(math.Float32bits(float32(math.Trunc(float64(f32(v))))))
+	case wasm.Op_f32_nearest:
+		return uint64(math.Float32bits(float32(math.RoundToEven(float64(f32(v))))))
+	case wasm.Op_f32_sqrt:
+		return uint64(math.Float32bits(float32(math.Sqrt(float64(f32(v))))))
+	case wasm.Op_f64_abs:
+		return v &^ sign64
+	case wasm.Op_f64_neg:
+		return v ^ sign64
+	case wasm.Op_f64_ceil:
+		return math.Float64bits(math.Ceil(f64(v)))
+	case wasm.Op_f64_floor:
+		return math.Float64bits(math.Floor(f64(v)))
+	case wasm.Op_f64_trunc:
+		return math.Float64bits(math.Trunc(f64(v)))
+	case wasm.Op_f64_nearest:
+		return math.Float64bits(math.RoundToEven(f64(v)))
+	case wasm.Op_f64_sqrt:
+		return math.Float64bits(math.Sqrt(f64(v)))
+	case wasm.Op_i32_wrap_i64:
+		return uint64(uint32(v))
+	case wasm.Op_i32_trunc_s_f32:
+		return uint64(uint32(int32(truncS(float64(f32(v)), -1<<31, 1<<31))))
+	case wasm.Op_i32_trunc_u_f32:
+		return uint64(uint32(truncU(float64(f32(v)), 1<<32)))
+	case wasm.Op_i32_trunc_s_f64:
+		return uint64(uint32(int32(truncS(f64(v), -1<<31, 1<<31))))
+	case wasm.Op_i32_trunc_u_f64:
+		return uint64(uint32(truncU(f64(v), 1<<32)))
+	case wasm.Op_i64_extend_s_i32:
+		return uint64(int64(int32(v)))
+	case wasm.Op_i64_extend_u_i32:
+		return uint64(uint32(v))
+	case wasm.Op_i64_trunc_s_f32:
+		return uint64(truncS(float64(f32(v)), -1<<63, 1<<63))
+	case wasm.Op_i64_trunc_u_f32:
+		return truncU(float64(f32(v)), 1<<64)
+	case wasm.Op_i64_trunc_s_f64:
+		return uint64(truncS(f64(v), -1<<63, 1<<63))
+	case wasm.Op_i64_trunc_u_f64:
+		return truncU(f64(v), 1<<64)
+	case wasm.Op_f32_convert_s_i32:
+		return uint64(math.Float32bits(float32(int32(v))))
+	case wasm.Op_f32_convert_u_i32:
+		return uint64(math.Float32bits(float32(uint32(v))))
+	case wasm.Op_f32_convert_s_i64:
+		return uint64(math.Float32bits(float32(int64(v))))
+	case wasm.Op_f32_convert_u_i64:
+		return uint64(math.Float32bits(u64ToF32(v)))
+	case wasm.Op_f32_demote_f64:
+		return uint64(math.Float32bits(float32(f64(v))))
+	case wasm.Op_f64_convert_s_i32:
+		return math.Float64bits(float64(int32(v)))
+	case wasm.Op_f64_convert_u_i32:
+		return math.Float64bits(float64(uint32(v)))
+	case wasm.Op_f64_convert_s_i64:
+		return math.Float64bits(float64(int64(v)))
+	case wasm.Op_f64_convert_u_i64:
+		return math.Float64bits(float64(v))
+	case wasm.Op_f64_promote_f32:
+		return math.Float64bits(float64(f32(v)))
+	}
+	// reinterpretations: values are stored as their bits.
+	return v
+}
+
+// binop executes the numeric instruction op, taking two operands.
+func binop(op wasm.Opcode, a, b uint64) uint64 {
+	switch {
+	case op <= wasm.Op_f64_ge:
+		return compare(op, a, b)
+	case op <= wasm.Op_i32_rotr:
+		return uint64(i32(op, uint32(a), uint32(b)))
+	case op <= wasm.Op_i64_rotr:
+		return i64(op, a, b)
+	case op <= wasm.Op_f32_copysign:
+		return f32op(op, a, b)
+	default:
+		return f64op(op, a, b)
+	}
+}
+
+// compare executes the comparison instructions.
+func compare(op wasm.Opcode, a, b uint64) uint64 {
+	switch op {
+	case wasm.Op_i32_eq:
+		return b2u(uint32(a) == uint32(b))
+	case wasm.Op_i32_ne:
+		return b2u(uint32(a) != uint32(b))
+	case wasm.Op_i32_lt_s:
+		return b2u(int32(a) < int32(b))
+	case wasm.Op_i32_lt_u:
+		return b2u(uint32(a) < uint32(b))
+	case wasm.Op_i32_gt_s:
+		return b2u(int32(a) > int32(b))
+	case wasm.Op_i32_gt_u:
+		return b2u(uint32(a) > uint32(b))
+	case wasm.Op_i32_le_s:
+		return b2u(int32(a) <= int32(b))
+	case wasm.Op_i32_le_u:
+		return b2u(uint32(a) <= uint32(b))
+	case wasm.Op_i32_ge_s:
+		return b2u(int32(a) >= int32(b))
+	case wasm.Op_i32_ge_u:
+		return b2u(uint32(a) >= uint32(b))
+	case wasm.Op_i64_eq:
+		return b2u(a == b)
+	case wasm.Op_i64_ne:
+		return b2u(a != b)
+	case wasm.Op_i64_lt_s:
+		return b2u(int64(a) < int64(b))
+	case wasm.Op_i64_lt_u:
+		return b2u(a < b)
+	case wasm.Op_i64_gt_s:
+		return b2u(int64(a) > int64(b))
+	case wasm.Op_i64_gt_u:
+		return b2u(a > b)
+	case wasm.Op_i64_le_s:
+		return b2u(int64(a) <= int64(b))
+	case wasm.Op_i64_le_u:
+		return b2u(a <= b)
+	case wasm.Op_i64_ge_s:
+		return b2u(int64(a) >= int64(b))
+	case wasm.Op_i64_ge_u:
+		return b2u(a >= b)
+	case wasm.Op_f32_eq:
+		return b2u(f32(a) == f32(b))
+	case wasm.Op_f32_ne:
+		return b2u(f32(a) != f32(b))
+	case wasm.Op_f32_lt:
+		return b2u(f32(a) < f32(b))
+	case wasm.Op_f32_gt:
+		return b2u(f32(a) > f32(b))
+	case wasm.Op_f32_le:
+		return b2u(f32(a) <= f32(b))
+	case wasm.Op_f32_ge:
+		return b2u(f32(a) >= f32(b))
+	case wasm.Op_f64_eq:
+		return b2u(f64(a) == f64(b))
+	case wasm.Op_f64_ne:
+		return b2u(f64(a) != f64(b))
+	case wasm.Op_f64_lt:
+		return b2u(f64(a) < f64(b))
+	case wasm.Op_f64_gt:
+		return b2u(f64(a) > f64(b))
+	case wasm.Op_f64_le:
+		return b2u(f64(a) <= f64(b))
+	case wasm.Op_f64_ge:
+		return b2u(f64(a) >= f64(b))
+	}
+	panic("unreachable")
+}
+
+// i32 executes the i32 arithmetic instructions.
+func i32(op wasm.Opcode, a, b uint32) uint32 {
+	switch op {
+	case wasm.Op_i32_add:
+		return a + b
+	case wasm.Op_i32_sub:
+		return a - b
+	case wasm.Op_i32_mul:
+		return a * b
+	case wasm.Op_i32_div_s:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap(TrapIntegerOverflow)
+		}
+		return uint32(int32(a) / int32(b))
+	case wasm.Op_i32_div_u:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		return a / b
+	case wasm.Op_i32_rem_s:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case wasm.Op_i32_rem_u:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		return a % b
+	case wasm.Op_i32_and:
+		return a & b
+	case wasm.Op_i32_or:
+		return a | b
+	case wasm.Op_i32_xor:
+		return a ^ b
+	case wasm.Op_i32_shl:
+		return a << (b & 31)
+	case wasm.Op_i32_shr_s:
+		return uint32(int32(a) >> (b & 31))
+	case wasm.Op_i32_shr_u:
+		return a >> (b & 31)
+	case wasm.Op_i32_rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	case wasm.Op_i32_rotr:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+	panic("unreachable")
+}
+
+// i64 executes the i64 arithmetic instructions.
+func i64(op wasm.Opcode, a, b uint64) uint64 {
+	switch op {
+	case wasm.Op_i64_add:
+		return a + b
+	case wasm.Op_i64_sub:
+		return a - b
+	case wasm.Op_i64_mul:
+		return a * b
+	case wasm.Op_i64_div_s:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap(TrapIntegerOverflow)
+		}
+		return uint64(int64(a) / int64(b))
+	case wasm.Op_i64_div_u:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		return a / b
+	case wasm.Op_i64_rem_s:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case wasm.Op_i64_rem_u:
+		if b == 0 {
+			trap(TrapIntegerDivideByZero)
+		}
+		return a % b
+	case wasm.Op_i64_and:
+		return a & b
+	case wasm.Op_i64_or:
+		return a | b
+	case wasm.Op_i64_xor:
+		return a ^ b
+	case wasm.Op_i64_shl:
+		return a << (b & 63)
+	case wasm.Op_i64_shr_s:
+		return uint64(int64(a) >> (b & 63))
+	case wasm.Op_i64_shr_u:
+		return a >> (b & 63)
+	case wasm.Op_i64_rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	case wasm.Op_i64_rotr:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+	panic("unreachable")
+}
+
+// f32op executes the binary f32 arithmetic instructions.
+func f32op(op wasm.Opcode, x, y uint64) uint64 {
+	const sign = 1 << 31
+	a, b := f32(x), f32(y)
+	var r float32
+	switch op {
+	case wasm.Op_f32_add:
+		r = a + b
+	case wasm.Op_f32_sub:
+		r = a - b
+	case wasm.Op_f32_mul:
+		r = a * b
+	case wasm.Op_f32_div:
+		r = a / b
+	case wasm.Op_f32_min:
+		r = float32(fmin(float64(a), float64(b)))
+	case wasm.Op_f32_max:
+		r = float32(fmax(float64(a), float64(b)))
+	case wasm.Op_f32_copysign:
+		return x&^sign | y&sign
+	}
+	return uint64(math.Float32bits(r))
+}
+
+// f64op executes the binary f64 arithmetic instructions.
+func f64op(op wasm.Opcode, x, y uint64) uint64 {
+	const sign = 1 << 63
+	a, b := f64(x), f64(y)
+	var r float64
+	switch op {
+	case wasm.Op_f64_add:
+		r = a + b
+	case wasm.Op_f64_sub:
+		r = a - b
+	case wasm.Op_f64_mul:
+		r = a * b
+	case wasm.Op_f64_div:
+		r = a / b
+	case wasm.Op_f64_min:
+		r = fmin(a, b)
+	case wasm.Op_f64_max:
+		r = fmax(a, b)
+	case wasm.Op_f64_copysign:
+		return x&^sign | y&sign
+	}
+	return math.Float64bits(r)
+}
+
+func f32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+func f64(v uint64) float64 { return math.Float64frombits(v) }
+
+// fmin returns the minimum of a and b, following the semantics of the min
+// instructions: NaN if either is NaN, and -0 is less than +0.
+func fmin(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Min(a, b)
+}
+
+// fmax returns the maximum of a and b, following the semantics of the max
+// instructions.
+func fmax(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Max(a, b)
+}
+
+// truncS truncates v to a signed integer in the range [min, max), or traps.
+func truncS(v, min, max float64) int64 {
+	if v != v {
+		trap(TrapInvalidConversion)
+	}
+	v = math.Trunc(v)
+	if v < min || v >= max {
+		trap(TrapIntegerOverflow)
+	}
+	return int64(v)
+}
+
+// truncU truncates v to an unsigned integer in the range [0, max), or traps.
+func truncU(v, max float64) uint64 {
+	if v != v {
+		trap(TrapInvalidConversion)
+	}
+	v = math.Trunc(v)
+	if v <= -1 || v >= max {
+		trap(TrapIntegerOverflow)
+	}
+	if v >= 1<<63 {
+		return uint64(v-(1<<63)) | 1<<63
+	}
+	return uint64(v)
+}
+
+// u64ToF32 converts v to the nearest float32, rounding ties to even.
+// Converting to float64 first would round twice.
+func u64ToF32(v uint64) float32 {
+	if v < 1<<63 {
+		return float32(int64(v))
+	}
+	// halve v, keeping the lowest bit sticky for the rounding.
+	f := float32(int64(v>>1 | v&1))
+	return f * 2
+}
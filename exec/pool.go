@@ -0,0 +1,76 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool is a pool of instances of a compiled module.
+//
+// The instances returned to the pool are reused: their memories, tables and
+// globals are reset to their initial state, and their start function is run
+// again, instead of instantiating the module again. Imported memories,
+// tables and globals are left untouched.
+//
+// A Pool is safe for concurrent use by multiple goroutines.
+type Pool struct {
+	c    *CompiledModule
+	opts InstantiateOptions
+	pool sync.Pool
+}
+
+// NewPool returns a pool of instances of the compiled module c,
+// instantiated with the options opts.
+func NewPool(c *CompiledModule, opts InstantiateOptions) *Pool {
+	return &Pool{c: c, opts: opts}
+}
+
+// Get returns an instance from the pool, reset to its initial state, or a
+// new instance if the pool is empty.
+func (p *Pool) Get(ctx context.Context) (*Instance, error) {
+	if inst, ok := p.pool.Get().(*Instance); ok {
+		if err := inst.reset(ctx, p.opts); err != nil {
+			return nil, err
+		}
+		return inst, nil
+	}
+	return p.c.instantiate(ctx, p.opts, nil)
+}
+
+// Put returns the instance inst, obtained from Get, to the pool.
+// The instance must not be used afterwards.
+func (p *Pool) Put(inst *Instance) {
+	if inst.compiled != p.c {
+		panic("exec: instance of another module returned to the pool")
+	}
+	p.pool.Put(inst)
+}
+
+// reset resets the defined memories, tables and globals of the instance to
+// their initial state, then runs its start function.
+func (inst *Instance) reset(ctx context.Context, opts InstantiateOptions) error {
+	c := inst.compiled
+	for _, mem := range inst.mems[len(inst.mems)-len(c.mems):] {
+		mem.reset()
+	}
+	for i, t := range inst.tables[len(inst.tables)-len(c.tables):] {
+		t.elems = make([]*Function, c.tables[i].Limits.Initial)
+	}
+	globals := inst.globals[len(inst.globals)-len(c.globals):]
+	for i, g := range globals {
+		g.val = inst.eval(c.globals[i].Init)
+	}
+
+	if inst.meter != nil {
+		inst.meter.fuel = opts.Fuel
+	}
+	inst.suspended = nil
+	inst.active = 0
+	inst.outer.frames = 0
+	inst.outer.stack = 0
+	return inst.init(ctx)
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"github.com/sbinet/wasm"
+)
+
+// table is a table of function references.
+type table struct {
+	typ   wasm.TableType
+	elems []*Function // nil for uninitialized elements
+	max   uint32
+}
+
+func newTable(tt wasm.TableType) *table {
+	max := uint32(0xffffffff)
+	if tt.Limits.Flags&wasm.LimitsMax != 0 {
+		max = uint32(tt.Limits.Maximum)
+	}
+	return &table{
+		typ:   tt,
+		elems: make([]*Function, tt.Limits.Initial),
+		max:   max,
+	}
+}
+
+// Global is a global variable.
+// Its value is encoded as by EncodeI32 and friends.
+type Global struct {
+	typ wasm.GlobalType
+	val uint64
+}
+
+// NewGlobal returns a global of type gt, with the value v.
+func NewGlobal(gt wasm.GlobalType, v uint64) *Global {
+	return &Global{typ: gt, val: v}
+}
+
+// Type returns the type of the global.
+func (g *Global) Type() wasm.GlobalType { return g.typ }
+
+// Get returns the value of the global.
+func (g *Global) Get() uint64 { return g.val }
+
+// Set sets the value of the global.
+// Immutable globals may be set by the host.
+func (g *Global) Set(v uint64) { g.val = v }
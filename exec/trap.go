@@ -0,0 +1,144 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TrapKind identifies the cause of a trap.
+type TrapKind int
+
+// Causes of traps.
+const (
+	TrapUnreachable              TrapKind = iota + 1 // unreachable executed
+	TrapOutOfBoundsMemory                            // out of bounds memory access
+	TrapOutOfBoundsTable                             // out of bounds table access
+	TrapIntegerDivideByZero                          // integer divide by zero
+	TrapIntegerOverflow                              // integer overflow
+	TrapInvalidConversion                            // invalid conversion to integer
+	TrapIndirectCallTypeMismatch                     // indirect call type mismatch
+	TrapNullReference                                // null reference, such as an uninitialized table element
+	TrapCallStackExhausted                           // call stack exhausted
+	TrapOutOfFuel                                    // out of fuel
+	TrapInterrupted                                  // interrupted by the cancellation of the context of the call
+)
+
+var trapNames = [...]string{
+	TrapUnreachable:              "unreachable executed",
+	TrapOutOfBoundsMemory:        "out of bounds memory access",
+	TrapOutOfBoundsTable:         "out of bounds table access",
+	TrapIntegerDivideByZero:      "integer divide by zero",
+	TrapIntegerOverflow:          "integer overflow",
+	TrapInvalidConversion:        "invalid conversion to integer",
+	TrapIndirectCallTypeMismatch: "indirect call type mismatch",
+	TrapNullReference:            "null reference",
+	TrapCallStackExhausted:       "call stack exhausted",
+	TrapOutOfFuel:                "out of fuel",
+	TrapInterrupted:              "interrupted",
+}
+
+func (k TrapKind) String() string {
+	if k > 0 && int(k) < len(trapNames) {
+		return trapNames[k]
+	}
+	return fmt.Sprintf("TrapKind(%d)", int(k))
+}
+
+// Trap is the error returned when the execution of a function traps.
+//
+// Traps compare equal with errors.Is when they have the same kind, e.g.:
+//
+//	errors.Is(err, &exec.Trap{Kind: exec.TrapOutOfBoundsMemory})
+//
+// The stack trace of a trap is printed by the %+v verb.
+type Trap struct {
+	Kind  TrapKind
+	Stack []Frame // wasm stack at the time of the trap, innermost frame first
+	Err   error   // cause of the trap, such as the error of a cancelled context
+
+	msg string // description overriding the one of the kind
+}
+
+// Frame is a frame of the stack trace of a trap.
+type Frame struct {
+	Func   uint32 // index of the function in its module
+	Name   string // name of the function, from the name section
+	Offset int    // offset of the executing instruction in the code of the function
+}
+
+func (f Frame) String() string {
+	if f.Name != "" {
+		return fmt.Sprintf("function %d (%s) at offset %#x", f.Func, f.Name, f.Offset)
+	}
+	return fmt.Sprintf("function %d at offset %#x", f.Func, f.Offset)
+}
+
+func newTrap(kind TrapKind) *Trap {
+	return &Trap{Kind: kind}
+}
+
+func (t *Trap) Error() string {
+	msg := t.msg
+	if msg == "" {
+		msg = t.Kind.String()
+	}
+	if t.Err != nil {
+		msg += ": " + t.Err.Error()
+	}
+	if len(t.Stack) == 0 {
+		return "exec: " + msg
+	}
+	return "exec: " + msg + " in " + t.Stack[0].String()
+}
+
+// Is reports whether target is a trap of the same kind.
+func (t *Trap) Is(target error) bool {
+	o, ok := target.(*Trap)
+	return ok && o.Kind == t.Kind
+}
+
+// Unwrap returns the cause of the trap.
+func (t *Trap) Unwrap() error { return t.Err }
+
+// Format implements fmt.Formatter.
+// The %+v verb prints the message of the trap followed by its stack trace.
+func (t *Trap) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		io.WriteString(s, t.Error())
+		if len(t.Stack) > 0 {
+			var b strings.Builder
+			b.WriteString("\nwasm stack trace:")
+			for i, f := range t.Stack {
+				fmt.Fprintf(&b, "\n\t%d: %v", i, f)
+			}
+			io.WriteString(s, b.String())
+		}
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", t.Error())
+	default:
+		io.WriteString(s, t.Error())
+	}
+}
+
+// trapError is the value of the panics raised by traps and by host
+// functions.
+type trapError struct {
+	err error
+}
+
+// trap aborts the execution of the current call with a trap of the
+// provided kind.
+func trap(kind TrapKind) {
+	panic(trapError{newTrap(kind)})
+}
+
+// trapMsg is like trap, with a specific description.
+func trapMsg(kind TrapKind, msg string) {
+	panic(trapError{&Trap{Kind: kind, msg: msg}})
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+func TestTrap(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		host func(ctx context.Context, mod *exec.Instance) error
+	)
+	b := wasm.NewBuilder()
+	callback := b.ImportFunc("env", "callback", wasm.FuncType{})
+	mem := b.Memory(memType(1, 0))
+	b.Export("memory", mem)
+
+	// inner traps with the kind selected by its argument.
+	inner := b.Func("inner", wasm.FuncType{Params: i32, Results: i32})
+	e := inner.Body()
+	l2 := e.Block()
+	l1 := e.Block()
+	l0 := e.Block()
+	e.LocalGet(0).BrTable([]wasm.Label{l0, l1}, l2)
+	e.End()
+	e.Unreachable() // offset 0x0e
+	e.End()
+	e.I32Const(-1).I32Load(0) // load at offset 0x12
+	e.Return()
+	e.End()
+	e.I32Const(1).LocalGet(0).I32Const(2).I32Sub().I32DivU() // div at offset 0x1e
+	e.End()
+
+	middle := b.Func("", wasm.FuncType{Params: i32, Results: i32})
+	middle.Body().Nop().LocalGet(0).Call(inner).End() // call at offset 0x03
+	outer := b.Func("outer", wasm.FuncType{Params: i32, Results: i32})
+	outer.Body().LocalGet(0).Call(middle).End() // call at offset 0x02
+	b.Export("outer", outer)
+
+	viaHost := b.Func("via-host", wasm.FuncType{})
+	viaHost.Body().Call(callback).End()
+	b.Export("via-host", viaHost)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := exec.Imports{"env": exec.HostModule{
+		"callback": func(ctx context.Context, mod *exec.Instance) error { return host(ctx, mod) },
+	}}
+	inst, err := exec.InstantiateWithOptions(ctx, m, exec.InstantiateOptions{Imports: imports})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack := func(off int) []exec.Frame {
+		return []exec.Frame{
+			{Func: 1, Name: "inner", Offset: off},
+			{Func: 2, Offset: 0x03},
+			{Func: 3, Name: "outer", Offset: 0x02},
+		}
+	}
+	for _, tc := range []struct {
+		arg   uint64
+		kind  exec.TrapKind
+		stack []exec.Frame
+		msg   string
+	}{
+		{0, exec.TrapUnreachable, stack(0x0e), "exec: unreachable executed in function 1 (inner) at offset 0xe"},
+		{1, exec.TrapOutOfBoundsMemory, stack(0x12), "exec: out of bounds memory access in function 1 (inner) at offset 0x12"},
+		{2, exec.TrapIntegerDivideByZero, stack(0x1e), "exec: integer divide by zero in function 1 (inner) at offset 0x1e"},
+	} {
+		_, err := inst.Call(ctx, "outer", tc.arg)
+		var trap *exec.Trap
+		if !errors.As(err, &trap) {
+			t.Fatalf("%d: expected a trap, got %v", tc.arg, err)
+		}
+		if trap.Kind != tc.kind {
+			t.Fatalf("%d: invalid kind: got=%v, want=%v", tc.arg, trap.Kind, tc.kind)
+		}
+		if !errors.Is(err, &exec.Trap{Kind: tc.kind}) || errors.Is(err, &exec.Trap{Kind: exec.TrapNullReference}) {
+			t.Fatalf("%d: invalid errors.Is", tc.arg)
+		}
+		if !reflect.DeepEqual(trap.Stack, tc.stack) {
+			t.Fatalf("%d: invalid stack:\ngot= %v\nwant=%v", tc.arg, trap.Stack, tc.stack)
+		}
+		if got := err.Error(); got != tc.msg {
+			t.Fatalf("%d: invalid message:\ngot= %s\nwant=%s", tc.arg, got, tc.msg)
+		}
+	}
+
+	_, err = inst.Call(ctx, "outer", 0)
+	want := `exec: unreachable executed in function 1 (inner) at offset 0xe
+wasm stack trace:
+	0: function 1 (inner) at offset 0xe
+	1: function 2 at offset 0x3
+	2: function 3 (outer) at offset 0x2`
+	if got := fmt.Sprintf("%+v", err); got != want {
+		t.Fatalf("invalid stack trace:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// traps of calls made by host functions are reported with the
+	// frames of the calls into the host functions.
+	host = func(ctx context.Context, mod *exec.Instance) error {
+		_, err := mod.Call(ctx, "outer", 2)
+		return err
+	}
+	_, err = inst.Call(ctx, "via-host")
+	var trap *exec.Trap
+	if !errors.As(err, &trap) {
+		t.Fatalf("expected a trap, got %v", err)
+	}
+	if got, want := trap.Stack, append(stack(0x1e), exec.Frame{Func: 4, Name: "via-host"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid stack:\ngot= %v\nwant=%v", got, want)
+	}
+
+	// so are the out of bounds accesses of host functions.
+	host = func(ctx context.Context, mod *exec.Instance) error {
+		_, err := mod.Memory("memory").ReadUint32Le(wasm.PageSize)
+		return err
+	}
+	_, err = inst.Call(ctx, "via-host")
+	if got, want := err.Error(), "exec: out of bounds memory access in function 4 (via-host) at offset 0x0"; got != want {
+		t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+	}
+
+	// other errors are returned as is.
+	errHost := errors.New("host error")
+	host = func(ctx context.Context, mod *exec.Instance) error { return errHost }
+	if _, err := inst.Call(ctx, "via-host"); err != errHost {
+		t.Fatalf("invalid error: got=%v, want=%v", err, errHost)
+	}
+}
+
+func TestTrapKind(t *testing.T) {
+	for _, tc := range []struct {
+		kind exec.TrapKind
+		want string
+	}{
+		{exec.TrapUnreachable, "unreachable executed"},
+		{exec.TrapIndirectCallTypeMismatch, "indirect call type mismatch"},
+		{exec.TrapCallStackExhausted, "call stack exhausted"},
+		{0, "TrapKind(0)"},
+		{42, "TrapKind(42)"},
+	} {
+		if got := tc.kind.String(); got != tc.want {
+			t.Fatalf("got=%q, want=%q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sbinet/wasm"
+)
+
+// Values are represented as uint64 values: integers are stored in the
+// low bits, zero-extended for i32 values, and floating-point numbers as
+// their IEEE 754 bits.
+
+// EncodeI32 encodes an i32 value.
+func EncodeI32(v int32) uint64 { return uint64(uint32(v)) }
+
+// EncodeI64 encodes an i64 value.
+func EncodeI64(v int64) uint64 { return uint64(v) }
+
+// EncodeF32 encodes an f32 value.
+func EncodeF32(v float32) uint64 { return uint64(math.Float32bits(v)) }
+
+// EncodeF64 encodes an f64 value.
+func EncodeF64(v float64) uint64 { return math.Float64bits(v) }
+
+// DecodeI32 decodes an i32 value.
+func DecodeI32(v uint64) int32 { return int32(v) }
+
+// DecodeI64 decodes an i64 value.
+func DecodeI64(v uint64) int64 { return int64(v) }
+
+// DecodeF32 decodes an f32 value.
+func DecodeF32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+
+// DecodeF64 decodes an f64 value.
+func DecodeF64(v uint64) float64 { return math.Float64frombits(v) }
+
+// encode encodes the Go value v as a value of type vt.
+func encode(vt wasm.ValueType, v interface{}) (uint64, error) {
+	switch vt {
+	case wasm.I32:
+		switch v := v.(type) {
+		case int32:
+			return EncodeI32(v), nil
+		case uint32:
+			return uint64(v), nil
+		}
+	case wasm.I64:
+		switch v := v.(type) {
+		case int64:
+			return EncodeI64(v), nil
+		case uint64:
+			return v, nil
+		}
+	case wasm.F32:
+		if v, ok := v.(float32); ok {
+			return EncodeF32(v), nil
+		}
+	case wasm.F64:
+		if v, ok := v.(float64); ok {
+			return EncodeF64(v), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot use %T value as %v", v, vt)
+}
+
+// decode decodes a value of type vt to a Go value.
+func decode(vt wasm.ValueType, v uint64) interface{} {
+	switch vt {
+	case wasm.I32:
+		return DecodeI32(v)
+	case wasm.I64:
+		return DecodeI64(v)
+	case wasm.F32:
+		return DecodeF32(v)
+	case wasm.F64:
+		return DecodeF64(v)
+	}
+	return v
+}
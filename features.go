@@ -0,0 +1,169 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Features is a set of WebAssembly proposals enabled on top of the MVP.
+//
+// The decoder rejects the encodings of the types, sections and segments
+// of disabled proposals. Instructions of function bodies are checked by
+// Validate.
+type Features uint64
+
+const (
+	// FeatureSignExt enables the sign-extension operators.
+	FeatureSignExt Features = 1 << iota
+
+	// FeatureSatTrunc enables the non-trapping float-to-int conversions.
+	FeatureSatTrunc
+
+	// FeatureMultiValue allows functions and blocks with several results,
+	// and blocks with parameters.
+	FeatureMultiValue
+
+	// FeatureBulkMemory enables the bulk memory operations, passive
+	// segments and the data count section.
+	FeatureBulkMemory
+
+	// FeatureReferenceTypes enables the funcref and externref value types,
+	// the table instructions and multiple tables.
+	FeatureReferenceTypes
+
+	// FeatureSIMD enables the v128 value type and the fixed-width SIMD
+	// instructions.
+	FeatureSIMD
+
+	// FeatureThreads enables shared memories and the atomic instructions.
+	FeatureThreads
+
+	// FeatureTailCall enables the return_call instructions.
+	FeatureTailCall
+
+	// FeatureExceptions enables tags and the exception handling
+	// instructions.
+	FeatureExceptions
+
+	// FeatureGC enables the garbage-collected types and instructions.
+	FeatureGC
+
+	// FeatureMemory64 enables linear memories indexed by i64 values.
+	FeatureMemory64
+
+	// FeatureMultiMemory allows several linear memories.
+	FeatureMultiMemory
+
+	// FeatureExtendedConst allows integer additions, subtractions and
+	// multiplications in constant expressions.
+	FeatureExtendedConst
+
+	featureEnd
+)
+
+const (
+	// FeaturesMVP is the feature set of the MVP, without any proposal.
+	FeaturesMVP Features = 0
+
+	// FeaturesV2 is the feature set of the WebAssembly 2.0 specification.
+	FeaturesV2 = FeatureSignExt | FeatureSatTrunc | FeatureMultiValue |
+		FeatureBulkMemory | FeatureReferenceTypes | FeatureSIMD
+
+	// FeaturesAll is the set of all the known features.
+	FeaturesAll = featureEnd - 1
+)
+
+var featureNames = [...]string{
+	"sign-ext",
+	"sat-trunc",
+	"multi-value",
+	"bulk-memory",
+	"reference-types",
+	"simd",
+	"threads",
+	"tail-call",
+	"exceptions",
+	"gc",
+	"memory64",
+	"multi-memory",
+	"extended-const",
+}
+
+// Has returns whether all the features of g are enabled in f.
+func (f Features) Has(g Features) bool {
+	return f&g == g
+}
+
+// String returns the comma-separated names of the features of f, or "mvp"
+// if f is empty.
+func (f Features) String() string {
+	if f == FeaturesMVP {
+		return "mvp"
+	}
+	var names []string
+	for i, name := range featureNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if f&^FeaturesAll != 0 {
+		names = append(names, fmt.Sprintf("Features(%#x)", uint64(f&^FeaturesAll)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFeatures parses a comma-separated list of feature names, as
+// returned by Features.String.
+// The names "mvp", "2.0" and "all" stand for FeaturesMVP, FeaturesV2 and
+// FeaturesAll.
+func ParseFeatures(s string) (Features, error) {
+	var f Features
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "mvp":
+			continue
+		case "2.0":
+			f |= FeaturesV2
+			continue
+		case "all":
+			f |= FeaturesAll
+			continue
+		}
+		g := featureByName(name)
+		if g == 0 {
+			return 0, fmt.Errorf("wasm: unknown feature %q", name)
+		}
+		f |= g
+	}
+	return f, nil
+}
+
+func featureByName(name string) Features {
+	for i, n := range featureNames {
+		if n == name {
+			return 1 << uint(i)
+		}
+	}
+	return 0
+}
+
+// FeatureError reports the use of a disabled feature.
+type FeatureError struct {
+	Feature Features // the required feature
+	What    string   // the construct requiring the feature
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("%s requires the %v feature", e.What, e.Feature)
+}
+
+// errUnsupported returns the error reported for an enabled feature that
+// is not supported.
+func errUnsupported(what string) error {
+	return fmt.Errorf("%s are not supported", what)
+}
@@ -0,0 +1,382 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func TestParseFeatures(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want wasm.Features
+	}{
+		{"", wasm.FeaturesMVP},
+		{"mvp", wasm.FeaturesMVP},
+		{"sign-ext", wasm.FeatureSignExt},
+		{"simd, threads", wasm.FeatureSIMD | wasm.FeatureThreads},
+		{"2.0", wasm.FeaturesV2},
+		{"2.0,tail-call", wasm.FeaturesV2 | wasm.FeatureTailCall},
+		{"all", wasm.FeaturesAll},
+	} {
+		got, err := wasm.ParseFeatures(tc.s)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.s, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%q: got=%v, want=%v", tc.s, got, tc.want)
+		}
+		back, err := wasm.ParseFeatures(got.String())
+		if err != nil || back != got {
+			t.Fatalf("%q: round-trip through %q failed: %v, %v", tc.s, got.String(), back, err)
+		}
+	}
+
+	if _, err := wasm.ParseFeatures("sign-ext,gcc"); err == nil {
+		t.Fatalf("expected an error for an unknown feature")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	var (
+		c32  = byte(wasm.Op_i32_const)
+		drop = byte(wasm.Op_drop)
+		misc = byte(wasm.Op_misc_prefix)
+		simd = byte(wasm.Op_simd_prefix)
+	)
+	for _, tc := range []struct {
+		name    string
+		feature wasm.Features
+		build   func(b *wasm.Builder)
+		mem     bool // whether the module has a memory
+		decode  bool // whether the decoder rejects the module without the feature
+	}{
+		{
+			name:    "sign-ext",
+			feature: wasm.FeatureSignExt,
+			build:   wasmtest.Func(c32, 0, byte(wasm.Op_i32_extend8_s), drop),
+		},
+		{
+			name:    "sat-trunc",
+			feature: wasm.FeatureSatTrunc,
+			build:   wasmtest.Func(byte(wasm.Op_f32_const), 0, 0, 0, 0, misc, 0x00, drop),
+		},
+		{
+			name:    "multi-value",
+			feature: wasm.FeatureMultiValue,
+			build: func(b *wasm.Builder) {
+				b.Type(wasm.FuncType{Results: []wasm.ValueType{wasm.I32, wasm.I64}})
+			},
+			decode: true,
+		},
+		{
+			name:    "bulk-memory",
+			feature: wasm.FeatureBulkMemory,
+			build:   wasmtest.Func(c32, 0, c32, 0, c32, 0, misc, 0x0b, 0x00),
+			mem:     true,
+		},
+		{
+			name:    "reference-types",
+			feature: wasm.FeatureReferenceTypes,
+			build:   wasmtest.Func(byte(wasm.Op_ref_null), byte(wasm.FuncRef), byte(wasm.Op_ref_is_null), drop),
+		},
+		{
+			name:    "externref",
+			feature: wasm.FeatureReferenceTypes,
+			build: func(b *wasm.Builder) {
+				b.Func("f", wasm.FuncType{Params: []wasm.ValueType{wasm.ExternRef}}).SetBody(nil, nil)
+			},
+			decode: true,
+		},
+		{
+			name:    "simd",
+			feature: wasm.FeatureSIMD,
+			build: wasmtest.Func(append(append([]byte{simd, 0x0c}, make([]byte, 16)...),
+				simd, 0x62, // i8x16.popcnt
+				drop,
+			)...),
+		},
+		{
+			name:    "threads",
+			feature: wasm.FeatureThreads,
+			build:   wasmtest.Func(c32, 0, byte(wasm.Op_threads_prefix), 0x10, 2, 0, drop),
+			mem:     true,
+		},
+		{
+			name:    "shared-memory",
+			feature: wasm.FeatureThreads,
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{
+					Flags: wasm.LimitsMax | wasm.LimitsShared, Initial: 1, Maximum: 2,
+				}})
+			},
+			decode: true,
+		},
+		{
+			name:    "tail-call",
+			feature: wasm.FeatureTailCall,
+			build:   wasmtest.Func(byte(wasm.Op_return_call), 0),
+		},
+		{
+			name:    "memory64",
+			feature: wasm.FeatureMemory64,
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Flags: wasm.LimitsI64, Initial: 1}})
+			},
+			decode: true,
+		},
+		{
+			name:    "multi-memory",
+			feature: wasm.FeatureMultiMemory,
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Func("f", wasm.FuncType{}).SetBody(nil, []byte{
+					c32, 0, byte(wasm.Op_i32_load), 0x42, 1, 0, drop,
+				})
+			},
+		},
+		{
+			name:    "extended-const",
+			feature: wasm.FeatureExtendedConst,
+			build: func(b *wasm.Builder) {
+				b.Global(wasm.GlobalType{ContentType: wasm.I32}, wasm.InitExpr{
+					Expr: []byte{c32, 1, c32, 2, byte(wasm.Op_i32_add)},
+					End:  byte(wasm.Op_end),
+				})
+			},
+			decode: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			build := []func(b *wasm.Builder){tc.build}
+			if tc.mem {
+				build = append([]func(b *wasm.Builder){wasmtest.Memory}, build...)
+			}
+			m := wasmtest.Build(t, build...)
+
+			if got := m.RequiredFeatures(); got != tc.feature {
+				t.Fatalf("invalid required features: got=%v, want=%v", got, tc.feature)
+			}
+
+			err := wasm.Validate(m, wasm.FeaturesAll&^tc.feature)
+			var ferr *wasm.FeatureError
+			if !errors.As(err, &ferr) {
+				t.Fatalf("expected a feature error, got %v", err)
+			}
+			if ferr.Feature != tc.feature {
+				t.Fatalf("invalid feature: got=%v, want=%v (%v)", ferr.Feature, tc.feature, err)
+			}
+			if err := wasm.Validate(m, tc.feature); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			raw, err := wasm.Encode(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{Features: tc.feature})
+			if err != nil {
+				t.Fatalf("could not decode module: %+v", err)
+			}
+			_, err = wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{})
+			switch {
+			case !tc.decode && err != nil:
+				t.Fatalf("could not decode module: %+v", err)
+			case tc.decode && !errors.As(err, &ferr):
+				t.Fatalf("expected a feature error, got %v", err)
+			case tc.decode && ferr.Feature != tc.feature:
+				t.Fatalf("invalid feature: got=%v, want=%v (%v)", ferr.Feature, tc.feature, err)
+			}
+		})
+	}
+}
+
+func TestRequiredFeatures(t *testing.T) {
+	// segments sets the flags of the data and element segments of m.
+	segments := func(m *wasm.Module, flags uint32) {
+		for _, sec := range m.Sections {
+			switch s := sec.(type) {
+			case wasm.DataSection:
+				s.Segments[0].Flags = flags
+			case wasm.ElementSection:
+				s.Elements[0].Flags = flags
+			}
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		build func(b *wasm.Builder)
+		edit  func(m *wasm.Module)
+	}{
+		{name: "test-module", build: wasmtest.NewModule},
+		{
+			name: "data-explicit",
+			build: func(b *wasm.Builder) {
+				mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Data(mem, wasm.ConstI32(0), []byte("data"))
+			},
+			edit: func(m *wasm.Module) { segments(m, wasm.DataExplicit) },
+		},
+		{
+			name: "data-passive",
+			build: func(b *wasm.Builder) {
+				mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Data(mem, wasm.ConstI32(0), []byte("data"))
+			},
+			edit: func(m *wasm.Module) { segments(m, wasm.DataPassive) },
+		},
+		{
+			name: "data-memory-1",
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+				b.Data(mem, wasm.ConstI32(0), []byte("data"))
+			},
+		},
+		{
+			name: "elem-table-1",
+			build: func(b *wasm.Builder) {
+				tt := wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 1}}
+				b.Table(tt)
+				table := b.Table(tt)
+				f := b.Func("f", wasm.FuncType{})
+				f.Body().End()
+				b.Elements(table, wasm.ConstI32(0), f)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := wasmtest.Build(t, tc.build)
+			if tc.edit != nil {
+				tc.edit(m)
+			}
+			checkRequiredFeatures(t, m)
+		})
+	}
+
+	for _, fname := range []string{
+		"testdata/empty.wasm",
+		"testdata/add.wasm",
+		"testdata/hello.wasm",
+	} {
+		t.Run(fname, func(t *testing.T) {
+			m, err := wasm.Open(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRequiredFeatures(t, &m)
+		})
+	}
+}
+
+// checkRequiredFeatures checks that the module m is valid, and decodes
+// once encoded, with the features it requires.
+func checkRequiredFeatures(t *testing.T, m *wasm.Module) {
+	t.Helper()
+	f := m.RequiredFeatures()
+	if err := wasm.Validate(m, f); err != nil {
+		t.Fatalf("invalid module with features %v: %+v", f, err)
+	}
+	raw, err := wasm.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{Features: f}); err != nil {
+		t.Fatalf("could not decode module with features %v: %+v", f, err)
+	}
+}
+
+func TestMemory64Limits(t *testing.T) {
+	want := wasm.ResizableLimits{Flags: wasm.LimitsI64 | wasm.LimitsMax, Initial: 1 << 33, Maximum: 1 << 40}
+	b := wasm.NewBuilder()
+	b.Memory(wasm.MemoryType{Limits: want})
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := wasm.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{Features: wasm.FeatureMemory64})
+	if err != nil {
+		t.Fatalf("could not decode module: %+v", err)
+	}
+	if got := dec.Sections[0].(wasm.MemorySection).Memories[0].Limits; got != want {
+		t.Fatalf("invalid limits: got=%+v, want=%+v", got, want)
+	}
+	if err := wasm.Validate(&dec, wasm.FeatureMemory64); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// the limits of 32-bit memories and tables are varuint32 values.
+	b = wasm.NewBuilder()
+	b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1 << 32}})
+	if m, err = b.Build(); err == nil {
+		_, err = wasm.Encode(m)
+	}
+	if err == nil {
+		t.Fatalf("expected an error encoding 32-bit limits overflowing 32 bits")
+	}
+
+	hdr := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	for _, tc := range []struct {
+		name string
+		sec  []byte
+		want string
+	}{
+		{"memory", []byte{byte(wasm.MemoryID), 0x03, 0x01, 0x08, 0x01}, "invalid memory limits flags (0x8)"},
+		{"table", []byte{byte(wasm.TableID), 0x04, 0x01, 0x70, 0x04, 0x01}, "invalid table limits flags (0x4)"},
+		{"memory32", []byte{byte(wasm.MemoryID), 0x08, 0x01, 0x00, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, "overflow"},
+	} {
+		_, err := wasm.DecodeWithOptions(bytes.NewReader(append(hdr, tc.sec...)), wasm.DecodeOptions{Features: wasm.FeaturesAll})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: invalid error: got=%v, want=%q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestFeaturesUnsupported(t *testing.T) {
+	// a struct type of the gc proposal.
+	raw := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		byte(wasm.TypeID), 0x03, 0x01, 0x5f, 0x00,
+	}
+	_, err := wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{})
+	var ferr *wasm.FeatureError
+	if !errors.As(err, &ferr) || ferr.Feature != wasm.FeatureGC {
+		t.Fatalf("expected a gc feature error, got %v", err)
+	}
+	_, err = wasm.DecodeWithOptions(bytes.NewReader(raw), wasm.DecodeOptions{Features: wasm.FeaturesAll})
+	if err == nil || errors.As(err, &ferr) {
+		t.Fatalf("expected an unsupported error, got %v", err)
+	}
+}
+
+func TestFeaturesCorpus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping corpus test in short mode")
+	}
+
+	fname := buildGoWasm(t, "wasip1", "package main\nimport \"fmt\"\nfunc main() { fmt.Println(\"hello\") }\n")
+	m, err := wasm.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := m.RequiredFeatures()
+	if !(wasm.FeatureSignExt | wasm.FeatureSatTrunc | wasm.FeatureBulkMemory).Has(f) {
+		t.Fatalf("unexpected features required by a Go module: %v", f)
+	}
+	if err := wasm.Validate(&m, f); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
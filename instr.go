@@ -0,0 +1,229 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// instruction is an instruction decoded from the code of a function body
+// or of an initializer expression.
+type instruction struct {
+	op     Opcode
+	sub    uint32 // opcode following a prefix
+	off    int    // offset of the opcode in the code
+	immOff int    // offset of the immediates in the code
+	end    int    // offset of the next instruction in the code
+
+	block  int64     // block type: blockTypeEmpty, a negative value type code, or a type index
+	idx    uint32    // index of a label, function, type, local, global, table, memory, tag or segment; flags of memory accesses
+	idx2   uint32    // table index of call_indirect; second index of instructions with two
+	mem    uint32    // memory index of memory accesses
+	offset uint64    // offset of memory accesses
+	labels []uint32  // labels of br_table, the default label excluded
+	vt     ValueType // type of select and ref.null
+	i64    int64     // value of an integer constant
+	bits   uint64    // bits of a floating-point constant
+	lane   byte      // lane index of vector instructions
+	v128   [16]byte  // value of v128.const, lane indices of i8x16.shuffle
+}
+
+// memArgExplicit is the bit of the flags of memory accesses indicating an
+// explicit memory index.
+const memArgExplicit = 0x40
+
+// blockTypeEmpty is the block type of blocks without results.
+// Value types are encoded as the negative signed LEB128 value of their
+// code: ValueType(block & 0x7f) is the type of the single result.
+const blockTypeEmpty = -0x40
+
+// codeReader decodes the instructions of a function body.
+type codeReader struct {
+	code []byte
+	off  int
+	r    bytes.Reader
+	err  error
+
+	// unsupported is the feature of the instruction that could not be
+	// decoded because it is not supported, if any.
+	unsupported Features
+}
+
+func newCodeReader(code []byte) *codeReader {
+	return &codeReader{code: code}
+}
+
+// next decodes the next instruction into in and reports whether there was
+// one. The error, if any, is available from cr.err, the offset of the
+// invalid instruction from cr.off.
+func (cr *codeReader) next(in *instruction) bool {
+	if cr.err != nil || cr.off >= len(cr.code) {
+		return false
+	}
+	cr.err = cr.decode(in)
+	if cr.err != nil {
+		return false
+	}
+	cr.off = in.end
+	return true
+}
+
+func (cr *codeReader) decode(in *instruction) error {
+	op := Opcode(cr.code[cr.off])
+	info := &opcodes[op]
+	if info.name == "" {
+		return fmt.Errorf("invalid opcode 0x%02x", byte(op))
+	}
+	*in = instruction{op: op, off: cr.off, immOff: cr.off + 1, labels: in.labels[:0]}
+	cr.r.Reset(cr.code[in.immOff:])
+
+	if info.imm == immPrefix {
+		sub, _, err := uvarint(&cr.r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", op, err)
+		}
+		ops := prefixedOpcodes(op)
+		if ops == nil {
+			cr.unsupported = info.feature
+			return fmt.Errorf("%s: %v", prefixedString(op, sub), errUnsupported(info.name+" instructions"))
+		}
+		if int(sub) >= len(ops) || ops[sub].name == "" {
+			return fmt.Errorf("invalid opcode %s", prefixedString(op, sub))
+		}
+		in.sub = sub
+		in.immOff = len(cr.code) - cr.r.Len()
+		info = &ops[sub]
+	}
+
+	var err error
+	switch info.imm {
+	case immNone:
+	case immBlockType:
+		in.block, _, err = varintN(&cr.r, 33)
+	case immLabel, immFunc, immLocal, immGlobal, immMemory, immType, immTable, immTag, immIndex:
+		in.idx, _, err = uvarint(&cr.r)
+	case immLabels:
+		var n uint32
+		n, _, err = uvarint(&cr.r)
+		if err != nil {
+			break
+		}
+		if int(n) > cr.r.Len() {
+			return io.ErrUnexpectedEOF
+		}
+		for i := 0; i < int(n) && err == nil; i++ {
+			var l uint32
+			l, _, err = uvarint(&cr.r)
+			in.labels = append(in.labels, l)
+		}
+		if err == nil {
+			in.idx, _, err = uvarint(&cr.r)
+		}
+	case immCallIndirect, immTwoIndices:
+		in.idx, _, err = uvarint(&cr.r)
+		if err == nil {
+			in.idx2, _, err = uvarint(&cr.r)
+		}
+	case immMemArg, immMemArgLane:
+		err = cr.memArg(in)
+		if err == nil && info.imm == immMemArgLane {
+			in.lane, err = cr.r.ReadByte()
+		}
+	case immLane:
+		in.lane, err = cr.r.ReadByte()
+	case immV128:
+		_, err = io.ReadFull(&cr.r, in.v128[:])
+	case immSelectT:
+		// the number of types is kept in idx, the first type in vt.
+		in.idx, _, err = uvarint(&cr.r)
+		if err == nil && int(in.idx) > cr.r.Len() {
+			return io.ErrUnexpectedEOF
+		}
+		for i := 0; i < int(in.idx) && err == nil; i++ {
+			var b byte
+			b, err = cr.r.ReadByte()
+			if i == 0 {
+				in.vt = ValueType(b)
+			}
+		}
+	case immRefType:
+		var b byte
+		b, err = cr.r.ReadByte()
+		in.vt = ValueType(b)
+	case immReserved:
+		var b byte
+		b, err = cr.r.ReadByte()
+		in.idx = uint32(b)
+	case immTryTable:
+		err = cr.tryTable(in)
+	case immI32:
+		in.i64, _, err = varintN(&cr.r, 32)
+	case immI64:
+		in.i64, _, err = varintN(&cr.r, 64)
+	case immF32:
+		var b [4]byte
+		_, err = io.ReadFull(&cr.r, b[:])
+		in.bits = uint64(order.Uint32(b[:]))
+	case immF64:
+		var b [8]byte
+		_, err = io.ReadFull(&cr.r, b[:])
+		in.bits = order.Uint64(b[:])
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", in.name(), err)
+	}
+	in.end = len(cr.code) - cr.r.Len()
+	return nil
+}
+
+// memArg decodes the flags, memory index and offset of a memory access.
+func (cr *codeReader) memArg(in *instruction) error {
+	var err error
+	in.idx, _, err = uvarint(&cr.r)
+	if err == nil && in.idx&memArgExplicit != 0 {
+		in.mem, _, err = uvarint(&cr.r)
+	}
+	if err == nil {
+		in.offset, _, err = uvarint64(&cr.r)
+	}
+	return err
+}
+
+// tryTable decodes the immediates of try_table: its block type and its
+// catch clauses, which are skipped.
+func (cr *codeReader) tryTable(in *instruction) error {
+	var err error
+	in.block, _, err = varintN(&cr.r, 33)
+	if err != nil {
+		return err
+	}
+	n, _, err := uvarint(&cr.r)
+	for i := 0; i < int(n) && err == nil; i++ {
+		var kind byte
+		kind, err = cr.r.ReadByte()
+		if err != nil {
+			break
+		}
+		switch kind {
+		case 0x00, 0x01: // catch, catch_ref
+			_, _, err = uvarint(&cr.r)
+		case 0x02, 0x03: // catch_all, catch_all_ref
+		default:
+			return fmt.Errorf("invalid catch clause 0x%02x", kind)
+		}
+		if err == nil {
+			_, _, err = uvarint(&cr.r)
+		}
+	}
+	return err
+}
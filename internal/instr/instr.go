@@ -0,0 +1,162 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package instr decodes the immediates of the instructions of function
+// bodies, for the execution engines and the translators of modules.
+//
+// The instructions are those of the MVP: the modules are expected to be
+// validated with wasm.FeaturesMVP beforehand.
+package instr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sbinet/wasm"
+)
+
+var (
+	errTruncated = errors.New("truncated immediate")
+	errOverflow  = errors.New("integer overflow")
+)
+
+// Uleb decodes the unsigned LEB128 integer of at most size bits at offset
+// pc of code, and returns it with the offset of the next byte.
+func Uleb(code []byte, pc int, size uint) (uint64, int, error) {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		if pc >= len(code) {
+			return 0, pc, errTruncated
+		}
+		c := code[pc]
+		pc++
+		// the last byte holds the remaining bits, without continuation.
+		if rem := size - shift; rem < 7 && c >= 1<<rem {
+			return 0, pc, errOverflow
+		}
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, pc, nil
+		}
+	}
+}
+
+// Sleb decodes the signed LEB128 integer of at most size bits at offset pc
+// of code, and returns it with the offset of the next byte.
+func Sleb(code []byte, pc int, size uint) (int64, int, error) {
+	var v int64
+	for shift := uint(0); ; shift += 7 {
+		if pc >= len(code) {
+			return 0, pc, errTruncated
+		}
+		c := code[pc]
+		pc++
+		// the unused bits of the last byte are copies of the sign bit.
+		if rem := size - shift; rem < 7 {
+			mask := byte(0x7f) &^ (1<<(rem-1) - 1)
+			if c&0x80 != 0 || (c&mask != 0 && c&mask != mask) {
+				return 0, pc, errOverflow
+			}
+		}
+		v |= int64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			if shift+7 < 64 && c&0x40 != 0 {
+				v |= -1 << (shift + 7)
+			}
+			return v, pc, nil
+		}
+	}
+}
+
+// ReadU32 decodes the unsigned LEB128 integer at offset pc of validated
+// code and returns it with the offset of the next byte. Unlike Uleb, it
+// does not check the encoding, and is cheap enough to be inlined in the
+// interpreters.
+func ReadU32(code []byte, pc int) (uint32, int) {
+	var (
+		v     uint32
+		shift uint
+	)
+	for pc < len(code) {
+		c := code[pc]
+		pc++
+		v |= uint32(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return v, pc
+}
+
+// ReadS32 decodes the signed LEB128 integer at offset pc of validated code
+// and returns it with the offset of the next byte.
+func ReadS32(code []byte, pc int) (int32, int) {
+	v, pc := ReadS64(code, pc)
+	return int32(v), pc
+}
+
+// ReadS64 decodes the signed LEB128 integer at offset pc of validated code
+// and returns it with the offset of the next byte. Unlike Sleb, it does
+// not check the encoding.
+func ReadS64(code []byte, pc int) (int64, int) {
+	var (
+		v     int64
+		shift uint
+		c     byte = 0x80
+	)
+	for pc < len(code) && c&0x80 != 0 {
+		c = code[pc]
+		pc++
+		v |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	if shift < 64 && c&0x40 != 0 {
+		v |= -1 << shift
+	}
+	return v, pc
+}
+
+// Skip returns the offset following the immediates of the instruction op,
+// starting at offset pc of code.
+func Skip(op wasm.Opcode, code []byte, pc int) (int, error) {
+	var err error
+	switch {
+	case op == wasm.Op_block || op == wasm.Op_loop || op == wasm.Op_if:
+		_, pc, err = Sleb(code, pc, 33)
+	case op == wasm.Op_br || op == wasm.Op_br_if || op == wasm.Op_call,
+		op >= wasm.Op_get_local && op <= wasm.Op_set_global,
+		op == wasm.Op_current_memory || op == wasm.Op_grow_memory:
+		_, pc, err = Uleb(code, pc, 32)
+	case op == wasm.Op_br_table:
+		var n uint64
+		n, pc, err = Uleb(code, pc, 32)
+		for i := uint64(0); i <= n && err == nil; i++ {
+			_, pc, err = Uleb(code, pc, 32)
+		}
+	case op == wasm.Op_call_indirect,
+		op >= wasm.Op_i32_load && op <= wasm.Op_i64_store32:
+		_, pc, err = Uleb(code, pc, 32)
+		if err == nil {
+			_, pc, err = Uleb(code, pc, 32)
+		}
+	case op == wasm.Op_i32_const:
+		_, pc, err = Sleb(code, pc, 32)
+	case op == wasm.Op_i64_const:
+		_, pc, err = Sleb(code, pc, 64)
+	case op == wasm.Op_f32_const:
+		pc += 4
+	case op == wasm.Op_f64_const:
+		pc += 8
+	case op > wasm.Op_f64_reinterpret_i64:
+		return pc, fmt.Errorf("unsupported instruction %v", op)
+	}
+	if err == nil && pc > len(code) {
+		err = errTruncated
+	}
+	if err != nil {
+		return pc, fmt.Errorf("instruction %v: %w", op, err)
+	}
+	return pc, nil
+}
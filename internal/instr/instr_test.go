@@ -0,0 +1,109 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package instr_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+func TestLeb(t *testing.T) {
+	for _, tc := range []struct {
+		code   []byte
+		size   uint
+		signed bool
+		want   int64
+		err    string
+	}{
+		{code: []byte{0x00}, size: 32, want: 0},
+		{code: []byte{0xe5, 0x8e, 0x26}, size: 32, want: 624485},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, size: 32, want: 0xffffffff},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0x1f}, size: 32, err: "integer overflow"},
+		{code: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, size: 32, err: "integer overflow"},
+		{code: []byte{0x80, 0x80}, size: 32, err: "truncated immediate"},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, size: 64, want: -1},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x03}, size: 64, err: "integer overflow"},
+		{code: []byte{0x7f}, size: 32, signed: true, want: -1},
+		{code: []byte{0xc0, 0xbb, 0x78}, size: 32, signed: true, want: -123456},
+		{code: []byte{0x80, 0x80, 0x80, 0x80, 0x78}, size: 32, signed: true, want: -1 << 31},
+		{code: []byte{0x80, 0x80, 0x80, 0x80, 0x70}, size: 32, signed: true, err: "integer overflow"},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0x07}, size: 32, signed: true, want: 1<<31 - 1},
+		{code: []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, size: 32, signed: true, err: "integer overflow"},
+		{code: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, size: 64, signed: true, want: -1 << 63},
+		{code: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, size: 64, signed: true, err: "integer overflow"},
+		{code: []byte{0xc0}, size: 64, signed: true, err: "truncated immediate"},
+	} {
+		var (
+			got  int64
+			next int
+			err  error
+		)
+		if tc.signed {
+			got, next, err = instr.Sleb(tc.code, 0, tc.size)
+		} else {
+			var v uint64
+			v, next, err = instr.Uleb(tc.code, 0, tc.size)
+			got = int64(v)
+		}
+		switch {
+		case tc.err != "":
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%x: invalid error: got=%v, want=%s", tc.code, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%x: unexpected error: %v", tc.code, err)
+		case got != tc.want || next != len(tc.code):
+			t.Errorf("%x: got=(%d, %d), want=(%d, %d)", tc.code, got, next, tc.want, len(tc.code))
+		}
+	}
+
+	// the fast paths of the readers of validated code match the
+	// decoders.
+	for _, b := range []byte{0x00, 0x01, 0x3f, 0x40, 0x7f} {
+		if v, next := instr.ReadU32([]byte{b}, 0); v != uint32(b) || next != 1 {
+			t.Errorf("ReadU32(%#x): got=(%d, %d)", b, v, next)
+		}
+		want, _, _ := instr.Sleb([]byte{b}, 0, 64)
+		if v, next := instr.ReadS64([]byte{b}, 0); v != want || next != 1 {
+			t.Errorf("ReadS64(%#x): got=(%d, %d), want=%d", b, v, next, want)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	for _, tc := range []struct {
+		code []byte
+		next int
+		err  string
+	}{
+		{code: []byte{byte(wasm.Op_nop)}, next: 1},
+		{code: []byte{byte(wasm.Op_block), 0x40}, next: 2},
+		{code: []byte{byte(wasm.Op_br_table), 0x02, 0x00, 0x01, 0x02}, next: 5},
+		{code: []byte{byte(wasm.Op_i32_load), 0x02, 0x80, 0x01}, next: 4},
+		{code: []byte{byte(wasm.Op_i64_const), 0x80, 0x80, 0x01}, next: 4},
+		{code: []byte{byte(wasm.Op_f64_const), 0, 0, 0, 0, 0, 0, 0, 0}, next: 9},
+		{code: []byte{byte(wasm.Op_br_table), 0x02, 0x00}, err: "truncated immediate"},
+		{code: []byte{byte(wasm.Op_f32_const), 0, 0}, err: "truncated immediate"},
+		{code: []byte{byte(wasm.Op_call), 0x80, 0x80, 0x80, 0x80, 0x10}, err: "integer overflow"},
+		{code: []byte{byte(wasm.Op_i32_const), 0xff, 0xff, 0xff, 0xff, 0x4f}, err: "integer overflow"},
+		{code: []byte{0xfc, 0x00}, err: "unsupported instruction"},
+	} {
+		op := wasm.Opcode(tc.code[0])
+		next, err := instr.Skip(op, tc.code, 1)
+		switch {
+		case tc.err != "":
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%x: invalid error: got=%v, want=%s", tc.code, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%x: unexpected error: %v", tc.code, err)
+		case next != tc.next:
+			t.Errorf("%x: got=%d, want=%d", tc.code, next, tc.next)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package translate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// Backend prints the code of a target language.
+//
+// Statements are printed on lines of their own. The statements opened by
+// If and Switch are continued by "} else {", "case n:" and "default:"
+// lines, and closed by a "}" line.
+type Backend interface {
+	// Stmt returns the simple statement s, terminated if needed.
+	Stmt(s string) string
+	// If returns the opening of an if statement, executed if the i32
+	// value cond is not zero.
+	If(cond string) string
+	// Switch returns the opening of a switch statement on the i32 value
+	// x.
+	Switch(x string) string
+	// Label returns the definition of the label name, preceding a
+	// statement.
+	Label(name string) string
+
+	// Unreachable returns the statement trapping on the unreachable
+	// instruction.
+	Unreachable() string
+	// Abort returns the statement ending a function whose end is not
+	// reachable.
+	Abort() string
+	// Return returns the statements returning from a function, with the
+	// value v if it is not empty.
+	Return(v string) []string
+
+	// Call returns the expression calling the function idx with the
+	// arguments args.
+	Call(idx uint32, args []string) string
+	// CallIndirect emits the call of the function of type index idx at
+	// the index elem of the table, with the arguments args.
+	CallIndirect(f *Func, idx uint32, elem string, args []string)
+	// Select emits the assignment of a to dst if the i32 value cond is
+	// not zero, of b otherwise. a may be dst itself.
+	Select(f *Func, dst, cond, a, b string)
+
+	// Global returns the variable of the global idx.
+	Global(idx uint32) string
+	// Const returns the expression of the constant of type t whose bits
+	// are v.
+	Const(t wasm.ValueType, v uint64) string
+	// MemorySize returns the expression of the size of the memory, in
+	// pages.
+	MemorySize() string
+	// MemoryGrow returns the expression growing the memory by delta
+	// pages.
+	MemoryGrow(delta string) string
+	// Load returns the expression of the value loaded by the instruction
+	// op at the address addr plus the offset off.
+	Load(op wasm.Opcode, addr string, off uint32) string
+	// Store returns the expression storing the value v with the
+	// instruction op at the address addr plus the offset off.
+	Store(op wasm.Opcode, addr string, off uint32, v string) string
+	// Numeric returns the translation of the numeric instruction op.
+	Numeric(op wasm.Opcode) (Numeric, bool)
+}
+
+// Numeric describes the translation of a numeric instruction.
+type Numeric struct {
+	Type wasm.ValueType // type of the result
+	N    int            // number of operands
+	Expr string         // expression, formatted with the operands
+}
+
+// Func translates the body of a function to statements.
+//
+// The operand stack is held in variables named after the height and the
+// type of the operands: the i32 operand at height 2 is s2_i32. Reading a
+// local is deferred until its value is used, or the local is set.
+// Blocks are translated to labels and goto statements, and if
+// instructions to if statements.
+type Func struct {
+	m      *Module
+	b      Backend
+	typ    wasm.FuncType
+	locals []wasm.ValueType // types of the parameters, then of the locals
+	code   []byte
+
+	lines  []string
+	indent int
+	stack  []operand
+	labels []label
+	vars   []string                  // declared stack variables, in order
+	types  map[string]wasm.ValueType // types of the declared stack variables
+	read   map[string]bool           // variables whose value is read
+	dead   bool                      // whether the code is unreachable
+	depth  int                       // depth of the skipped blocks in unreachable code
+	nlabel int
+}
+
+// operand is a value of the operand stack.
+type operand struct {
+	name  string // variable holding the value
+	typ   wasm.ValueType
+	local int // index of the local holding the value, or -1
+}
+
+// label is the target of the branches to a block.
+type label struct {
+	name    string
+	typ     wasm.ValueType // type of the result, if results is 1
+	arity   int            // number of values carried by the branches
+	results int            // number of results of the block
+	height  int            // height of the operand stack at the start of the block
+	loop    bool
+	line    int  // line of the label of a loop
+	used    bool // whether a branch targets the label
+	isIf    bool
+}
+
+// Var is a variable declared by the translation of a function.
+type Var struct {
+	Name string
+	Type wasm.ValueType
+	Read bool // whether its value is read
+}
+
+// Translate translates the body of the i-th defined function of the
+// module to statements printed by the back-end b.
+func (m *Module) Translate(b Backend, i int) (*Func, error) {
+	fb := m.Bodies[i]
+	f := &Func{
+		m:     m,
+		b:     b,
+		typ:   m.Funcs[m.NumImports+i],
+		code:  append(fb.Code.Code[:len(fb.Code.Code):len(fb.Code.Code)], fb.Code.End),
+		types: make(map[string]wasm.ValueType),
+		read:  make(map[string]bool),
+	}
+	f.locals = append(f.locals, f.typ.Params...)
+	for _, le := range fb.Locals {
+		for i := uint32(0); i < le.Count; i++ {
+			f.locals = append(f.locals, le.Type)
+		}
+	}
+
+	f.indent = 1
+	f.labels = append(f.labels, label{arity: len(f.typ.Results), results: len(f.typ.Results)})
+	if len(f.typ.Results) > 0 {
+		f.labels[0].typ = f.typ.Results[0]
+	}
+	if err := f.body(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lines returns the statements of the body of the function, indented by
+// at least one tab.
+func (f *Func) Lines() []string {
+	var lines []string
+	for _, l := range f.lines {
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// Vars returns the variables of the body of the function: its locals, the
+// parameters excluded, then the variables of the operand stack.
+func (f *Func) Vars() []Var {
+	var vars []Var
+	for i, t := range f.locals[len(f.typ.Params):] {
+		l := LocalName(len(f.typ.Params) + i)
+		vars = append(vars, Var{Name: l, Type: t, Read: f.read[l]})
+	}
+	for _, v := range f.vars {
+		vars = append(vars, Var{Name: v, Type: f.types[v], Read: f.read[v]})
+	}
+	return vars
+}
+
+// Emit appends a line of code.
+func (f *Func) Emit(format string, args ...interface{}) {
+	f.lines = append(f.lines, strings.Repeat("\t", f.indent)+fmt.Sprintf(format, args...))
+}
+
+// Indent changes the indentation of the following lines by n levels.
+func (f *Func) Indent(n int) { f.indent += n }
+
+// Call emits the evaluation of the expression expr, calling a function
+// with the results results.
+func (f *Func) Call(results []wasm.ValueType, expr string) {
+	if len(results) == 0 {
+		f.Emit("%s", f.b.Stmt(expr))
+		return
+	}
+	f.assign(results[0], "%s", expr)
+}
+
+func (f *Func) body() error {
+	code := f.code
+	pc := 0
+	for pc < len(code) {
+		start := pc
+		op := wasm.Opcode(code[pc])
+		pc++
+		if f.dead {
+			// unreachable code is skipped up to the else or end
+			// instruction closing its block.
+			next, err := instr.Skip(op, code, pc)
+			if err != nil {
+				return fmt.Errorf("offset %#x: %w", start, err)
+			}
+			switch op {
+			case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+				f.depth++
+				pc = next
+				continue
+			case wasm.Op_else:
+				if f.depth > 0 {
+					pc = next
+					continue
+				}
+			case wasm.Op_end:
+				if f.depth > 0 {
+					f.depth--
+					pc = next
+					continue
+				}
+			default:
+				pc = next
+				continue
+			}
+		}
+
+		switch op {
+		case wasm.Op_unreachable:
+			f.Emit("%s", f.b.Unreachable())
+			f.dead = true
+
+		case wasm.Op_nop:
+
+		case wasm.Op_block, wasm.Op_loop, wasm.Op_if:
+			var bt int64
+			bt, pc = instr.ReadS64(code, pc)
+			l := label{height: len(f.stack), loop: op == wasm.Op_loop, isIf: op == wasm.Op_if}
+			if bt != -0x40 {
+				l.arity = 1
+				l.results = 1
+				l.typ = wasm.ValueType(bt & 0x7f)
+			}
+			if op == wasm.Op_if {
+				cond := f.pop()
+				l.height--
+				f.materialize()
+				f.Emit("%s", f.b.If(f.use(cond)))
+				f.indent++
+			} else {
+				f.materialize()
+			}
+			f.nlabel++
+			l.name = fmt.Sprintf("L%d", f.nlabel)
+			if l.loop {
+				l.arity = 0 // branches to a loop carry no values
+				l.line = len(f.lines)
+				f.lines = append(f.lines, "")
+			}
+			f.labels = append(f.labels, l)
+
+		case wasm.Op_else:
+			l := &f.labels[len(f.labels)-1]
+			if !f.dead {
+				f.materialize()
+			}
+			f.dead = false
+			f.stack = f.stack[:l.height]
+			f.indent--
+			f.Emit("} else {")
+			f.indent++
+
+		case wasm.Op_end:
+			l := f.labels[len(f.labels)-1]
+			f.labels = f.labels[:len(f.labels)-1]
+			if len(f.labels) == 0 {
+				// end of the function body.
+				if f.dead {
+					f.Emit("%s", f.b.Abort())
+				} else {
+					f.ret()
+				}
+				break
+			}
+			if !f.dead {
+				f.materialize()
+			}
+			f.dead = false
+			if l.isIf {
+				f.indent--
+				f.Emit("}")
+			}
+			switch {
+			case l.loop && l.used:
+				f.lines[l.line] = strings.Repeat("\t", f.indent-1) + f.b.Label(l.name)
+			case !l.loop && l.used:
+				f.lines = append(f.lines, strings.Repeat("\t", f.indent-1)+f.b.Label(l.name))
+			}
+			f.stack = f.stack[:l.height]
+			if l.results > 0 {
+				f.push(operand{name: f.stackVar(l.height, l.typ), typ: l.typ, local: -1})
+			}
+
+		case wasm.Op_br:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			f.branch(len(f.labels) - 1 - int(depth))
+			f.dead = true
+
+		case wasm.Op_br_if:
+			var depth uint32
+			depth, pc = instr.ReadU32(code, pc)
+			cond := f.pop()
+			f.Emit("%s", f.b.If(f.use(cond)))
+			f.indent++
+			f.branch(len(f.labels) - 1 - int(depth))
+			f.indent--
+			f.Emit("}")
+
+		case wasm.Op_br_table:
+			var n uint32
+			n, pc = instr.ReadU32(code, pc)
+			idx := f.pop()
+			f.Emit("%s", f.b.Switch(f.use(idx)))
+			for j := uint32(0); j <= n; j++ {
+				var depth uint32
+				depth, pc = instr.ReadU32(code, pc)
+				if j < n {
+					f.Emit("case %d:", j)
+				} else {
+					f.Emit("default:")
+				}
+				f.indent++
+				f.branch(len(f.labels) - 1 - int(depth))
+				f.indent--
+			}
+			f.Emit("}")
+			f.dead = true
+
+		case wasm.Op_return:
+			f.ret()
+			f.dead = true
+
+		case wasm.Op_call:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			ft := f.m.Funcs[idx]
+			f.Call(ft.Results, f.b.Call(idx, f.args(len(ft.Params))))
+
+		case wasm.Op_call_indirect:
+			var idx uint32
+			idx, pc = instr.ReadU32(code, pc)
+			_, pc = instr.ReadU32(code, pc) // table index
+			elem := f.use(f.pop())
+			f.b.CallIndirect(f, idx, elem, f.args(len(f.m.Types[idx].Params)))
+
+		case wasm.Op_drop:
+			f.pop()
+
+		case wasm.Op_select:
+			cond := f.pop()
+			b := f.pop()
+			a := f.pop()
+			v := f.stackVar(len(f.stack), a.typ)
+			x := v
+			if a.name != v {
+				x = f.use(a)
+			}
+			f.b.Select(f, v, f.use(cond), x, f.use(b))
+			f.push(operand{name: v, typ: a.typ, local: -1})
+
+		case wasm.Op_get_local:
+			var x uint32
+			x, pc = instr.ReadU32(code, pc)
+			f.push(operand{name: LocalName(int(x)), typ: f.locals[x], local: int(x)})
+
+		case wasm.Op_set_local, wasm.Op_tee_local:
+			var x uint32
+			x, pc = instr.ReadU32(code, pc)
+			v := f.pop()
+			if v.local == int(x) {
+				if op == wasm.Op_tee_local {
+					f.push(v)
+				}
+				break
+			}
+			f.spill(int(x))
+			f.set(LocalName(int(x)), v)
+			if op == wasm.Op_tee_local {
+				f.push(operand{name: LocalName(int(x)), typ: f.locals[x], local: int(x)})
+			}
+
+		case wasm.Op_get_global:
+			var x uint32
+			x, pc = instr.ReadU32(code, pc)
+			f.assign(f.m.Globals[x].ContentType, "%s", f.b.Global(x))
+
+		case wasm.Op_set_global:
+			var x uint32
+			x, pc = instr.ReadU32(code, pc)
+			f.set(f.b.Global(x), f.pop())
+
+		case wasm.Op_current_memory:
+			_, pc = instr.ReadU32(code, pc)
+			f.assign(wasm.I32, "%s", f.b.MemorySize())
+
+		case wasm.Op_grow_memory:
+			_, pc = instr.ReadU32(code, pc)
+			f.assign(wasm.I32, "%s", f.b.MemoryGrow(f.use(f.pop())))
+
+		case wasm.Op_i32_const:
+			var v int32
+			v, pc = instr.ReadS32(code, pc)
+			f.assign(wasm.I32, "%s", f.b.Const(wasm.I32, uint64(uint32(v))))
+
+		case wasm.Op_i64_const:
+			var v int64
+			v, pc = instr.ReadS64(code, pc)
+			f.assign(wasm.I64, "%s", f.b.Const(wasm.I64, uint64(v)))
+
+		case wasm.Op_f32_const:
+			if pc+4 > len(code) {
+				return fmt.Errorf("offset %#x: truncated instruction %v", start, op)
+			}
+			f.assign(wasm.F32, "%s", f.b.Const(wasm.F32, uint64(binary.LittleEndian.Uint32(code[pc:]))))
+			pc += 4
+
+		case wasm.Op_f64_const:
+			if pc+8 > len(code) {
+				return fmt.Errorf("offset %#x: truncated instruction %v", start, op)
+			}
+			f.assign(wasm.F64, "%s", f.b.Const(wasm.F64, binary.LittleEndian.Uint64(code[pc:])))
+			pc += 8
+
+		case wasm.Op_i32_store, wasm.Op_i64_store, wasm.Op_f32_store, wasm.Op_f64_store,
+			wasm.Op_i32_store8, wasm.Op_i32_store16,
+			wasm.Op_i64_store8, wasm.Op_i64_store16, wasm.Op_i64_store32:
+			var off uint32
+			_, pc = instr.ReadU32(code, pc) // alignment
+			off, pc = instr.ReadU32(code, pc)
+			v := f.pop()
+			addr := f.pop()
+			f.Emit("%s", f.b.Stmt(f.b.Store(op, f.use(addr), off, f.use(v))))
+
+		default:
+			if t, ok := loads[op]; ok {
+				var off uint32
+				_, pc = instr.ReadU32(code, pc) // alignment
+				off, pc = instr.ReadU32(code, pc)
+				f.assign(t, "%s", f.b.Load(op, f.use(f.pop()), off))
+				break
+			}
+			n, ok := f.b.Numeric(op)
+			if !ok {
+				return fmt.Errorf("offset %#x: unsupported instruction %v", start, op)
+			}
+			args := make([]interface{}, n.N)
+			for i := n.N - 1; i >= 0; i-- {
+				args[i] = f.use(f.pop())
+			}
+			f.assign(n.Type, n.Expr, args...)
+		}
+	}
+	return nil
+}
+
+// loads holds the types of the values loaded by the load instructions.
+var loads = map[wasm.Opcode]wasm.ValueType{
+	wasm.Op_i32_load:     wasm.I32,
+	wasm.Op_i64_load:     wasm.I64,
+	wasm.Op_f32_load:     wasm.F32,
+	wasm.Op_f64_load:     wasm.F64,
+	wasm.Op_i32_load8_s:  wasm.I32,
+	wasm.Op_i32_load8_u:  wasm.I32,
+	wasm.Op_i32_load16_s: wasm.I32,
+	wasm.Op_i32_load16_u: wasm.I32,
+	wasm.Op_i64_load8_s:  wasm.I64,
+	wasm.Op_i64_load8_u:  wasm.I64,
+	wasm.Op_i64_load16_s: wasm.I64,
+	wasm.Op_i64_load16_u: wasm.I64,
+	wasm.Op_i64_load32_s: wasm.I64,
+	wasm.Op_i64_load32_u: wasm.I64,
+}
+
+// branch branches to the i-th label, carrying the values on top of the
+// operand stack.
+func (f *Func) branch(i int) {
+	if i == 0 {
+		f.ret()
+		return
+	}
+	l := &f.labels[i]
+	l.used = true
+	if l.arity > 0 {
+		v := f.stack[len(f.stack)-1]
+		if dst := f.stackVar(l.height, l.typ); v.name != dst {
+			f.set(dst, v)
+		}
+	}
+	f.Emit("%s", f.b.Stmt("goto "+l.name))
+}
+
+// ret returns from the function, with the value on top of the operand
+// stack if it has a result.
+func (f *Func) ret() {
+	v := ""
+	if len(f.typ.Results) > 0 {
+		v = f.use(f.stack[len(f.stack)-1])
+	}
+	for _, s := range f.b.Return(v) {
+		f.Emit("%s", s)
+	}
+}
+
+// args pops the n arguments of a call from the operand stack.
+func (f *Func) args(n int) []string {
+	args := make([]string, n)
+	for i := n - 1; i >= 0; i-- {
+		args[i] = f.use(f.pop())
+	}
+	return args
+}
+
+// set assigns the value v to the variable dst.
+func (f *Func) set(dst string, v operand) {
+	f.Emit("%s", f.b.Stmt(dst+" = "+f.use(v)))
+}
+
+// assign pushes a value of type typ, computed by the formatted
+// expression.
+func (f *Func) assign(typ wasm.ValueType, format string, args ...interface{}) {
+	v := f.stackVar(len(f.stack), typ)
+	f.Emit("%s", f.b.Stmt(v+" = "+fmt.Sprintf(format, args...)))
+	f.push(operand{name: v, typ: typ, local: -1})
+}
+
+func (f *Func) push(v operand) { f.stack = append(f.stack, v) }
+
+func (f *Func) pop() operand {
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return v
+}
+
+// use returns the name of the variable holding v, whose value is read.
+func (f *Func) use(v operand) string {
+	f.read[v.name] = true
+	return v.name
+}
+
+// stackVar returns the name of the stack variable holding the operand of
+// type typ at height h, and declares it if needed.
+func (f *Func) stackVar(h int, typ wasm.ValueType) string {
+	v := fmt.Sprintf("s%d_%s", h, typ)
+	if _, ok := f.types[v]; !ok {
+		f.types[v] = typ
+		f.vars = append(f.vars, v)
+	}
+	return v
+}
+
+// materialize copies the deferred reads of locals into stack variables,
+// before the control flow merges.
+func (f *Func) materialize() {
+	for i, v := range f.stack {
+		if v.local >= 0 {
+			f.store(i)
+		}
+	}
+}
+
+// spill copies the deferred reads of the local x into stack variables,
+// before it is set.
+func (f *Func) spill(x int) {
+	for i, v := range f.stack {
+		if v.local == x {
+			f.store(i)
+		}
+	}
+}
+
+// store copies the i-th operand into its stack variable.
+func (f *Func) store(i int) {
+	v := f.stack[i]
+	dst := f.stackVar(i, v.typ)
+	f.set(dst, v)
+	f.stack[i] = operand{name: dst, typ: v.typ, local: -1}
+}
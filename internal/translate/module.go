@@ -0,0 +1,150 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package translate is the front-end of the translators of modules to
+// source code, wasm2go and wasm2c.
+//
+// It gathers the entities of a module, and translates the bodies of its
+// functions to statements on variables, whose syntax is provided by the
+// Backend of the target language. The modules must use only the features
+// of the MVP, and may not import memories or tables.
+package translate
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/instr"
+)
+
+// Module holds the entities of a module.
+type Module struct {
+	Types      []wasm.FuncType
+	Imports    []wasm.ImportEntry
+	Funcs      []wasm.FuncType   // types of the functions, imported first
+	FuncTypes  []uint32          // type indices of the functions, imported first
+	Globals    []wasm.GlobalType // types of the globals, imported first
+	NumImports int               // number of imported functions
+	Bodies     []wasm.FunctionBody
+	Table      *wasm.TableType
+	Mem        *wasm.MemoryType
+	Inits      []wasm.GlobalVariable
+	Exports    []wasm.ExportEntry
+	Start      *uint32
+	Elems      []wasm.ElemSegment
+	Data       []wasm.DataSegment
+	Names      map[uint32]string // names of the functions
+}
+
+// NewModule returns the entities of the module m, which must be valid.
+func NewModule(m *wasm.Module) (*Module, error) {
+	mod := &Module{Names: make(map[uint32]string)}
+	for _, sec := range m.Sections {
+		switch s := sec.(type) {
+		case wasm.TypeSection:
+			mod.Types = s.Types
+		case wasm.ImportSection:
+			mod.Imports = s.Imports
+			for _, e := range s.Imports {
+				switch e.Kind {
+				case wasm.FunctionKind:
+					mod.Funcs = append(mod.Funcs, mod.Types[e.Type.(uint32)])
+					mod.FuncTypes = append(mod.FuncTypes, e.Type.(uint32))
+					mod.NumImports++
+				case wasm.GlobalKind:
+					mod.Globals = append(mod.Globals, e.Type.(wasm.GlobalType))
+				default:
+					return nil, fmt.Errorf("unsupported import of %v %s.%s", e.Kind, e.Module, e.Field)
+				}
+			}
+		case wasm.FunctionSection:
+			for _, t := range s.Types {
+				mod.Funcs = append(mod.Funcs, mod.Types[t])
+				mod.FuncTypes = append(mod.FuncTypes, t)
+			}
+		case wasm.TableSection:
+			if len(s.Tables) > 0 {
+				mod.Table = &s.Tables[0]
+			}
+		case wasm.MemorySection:
+			if len(s.Memories) > 0 {
+				mod.Mem = &s.Memories[0]
+			}
+		case wasm.GlobalSection:
+			mod.Inits = s.Globals
+			for _, gv := range s.Globals {
+				mod.Globals = append(mod.Globals, gv.Type)
+			}
+		case wasm.ExportSection:
+			mod.Exports = s.Exports
+		case wasm.StartSection:
+			idx := s.Index
+			mod.Start = &idx
+		case wasm.ElementSection:
+			mod.Elems = s.Elements
+		case wasm.CodeSection:
+			mod.Bodies = s.Bodies
+		case wasm.DataSection:
+			mod.Data = s.Segments
+		case wasm.NameSection:
+			for _, fn := range s.Funcs {
+				mod.Names[fn.Index] = fn.Name
+			}
+		}
+	}
+	return mod, nil
+}
+
+// ConstExpr returns the expression of the value of a constant expression,
+// printed by the back-end b.
+func ConstExpr(b Backend, ie wasm.InitExpr) (string, error) {
+	code := ie.Expr
+	if len(code) == 0 {
+		return "0", nil
+	}
+	switch op := wasm.Opcode(code[0]); op {
+	case wasm.Op_i32_const:
+		v, _ := instr.ReadS32(code, 1)
+		return b.Const(wasm.I32, uint64(uint32(v))), nil
+	case wasm.Op_i64_const:
+		v, _ := instr.ReadS64(code, 1)
+		return b.Const(wasm.I64, uint64(v)), nil
+	case wasm.Op_f32_const:
+		if len(code) < 5 {
+			break
+		}
+		return b.Const(wasm.F32, uint64(binary.LittleEndian.Uint32(code[1:]))), nil
+	case wasm.Op_f64_const:
+		if len(code) < 9 {
+			break
+		}
+		return b.Const(wasm.F64, binary.LittleEndian.Uint64(code[1:])), nil
+	case wasm.Op_get_global:
+		idx, _ := instr.ReadU32(code, 1)
+		return b.Global(idx), nil
+	}
+	return "", fmt.Errorf("invalid constant expression %x", code)
+}
+
+// LocalName returns the name of the variable of the i-th local, the
+// parameters first.
+func LocalName(i int) string { return fmt.Sprintf("l%d", i) }
+
+// GlobalName returns the name of the variable of the i-th global.
+func GlobalName(i uint32) string { return fmt.Sprintf("g%d", i) }
+
+// FuncName returns the name of the i-th function.
+func FuncName(i uint32) string { return fmt.Sprintf("f%d", i) }
+
+// Unique returns name, or name followed by a number if it is already in
+// names, and adds it to names.
+func Unique(names map[string]bool, name string) string {
+	v := name
+	for i := 2; names[v]; i++ {
+		v = fmt.Sprintf("%s%d", name, i)
+	}
+	names[v] = true
+	return v
+}
@@ -0,0 +1,392 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasmtest provides the modules shared by the tests of the
+// engines and of the translators.
+//
+// The modules are made of functions adding entities to a wasm.Builder, so
+// that each test builds, with Build, a module holding only what it needs.
+package wasmtest
+
+import (
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/exec"
+)
+
+var (
+	i32   = []wasm.ValueType{wasm.I32}
+	i64   = []wasm.ValueType{wasm.I64}
+	f32   = []wasm.ValueType{wasm.F32}
+	f64   = []wasm.ValueType{wasm.F64}
+	i32x2 = []wasm.ValueType{wasm.I32, wasm.I32}
+	i64x2 = []wasm.ValueType{wasm.I64, wasm.I64}
+	f64x2 = []wasm.ValueType{wasm.F64, wasm.F64}
+)
+
+// Build returns the module made of the entities added, in order, by fns,
+// or fails the test if the module cannot be built.
+func Build(tb testing.TB, fns ...func(b *wasm.Builder)) *wasm.Module {
+	tb.Helper()
+	b := wasm.NewBuilder()
+	for _, fn := range fns {
+		fn(b)
+	}
+	m, err := b.Build()
+	if err != nil {
+		tb.Fatalf("could not build module: %+v", err)
+	}
+	return m
+}
+
+// Memory adds to b a memory of one page.
+func Memory(b *wasm.Builder) {
+	b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+}
+
+// Table adds to b a table of one function reference.
+func Table(b *wasm.Builder) {
+	b.Table(wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 1}})
+}
+
+// Func returns a function adding to b the function f, without parameters
+// nor results, with the provided code.
+func Func(code ...byte) func(b *wasm.Builder) {
+	return func(b *wasm.Builder) {
+		b.Func("f", wasm.FuncType{}).SetBody(nil, code)
+	}
+}
+
+// Add adds to b and exports add, returning the sum of its arguments.
+func Add(b *wasm.Builder) {
+	add := b.Func("add", wasm.FuncType{Params: i32x2, Results: i32})
+	add.Body().LocalGet(0).LocalGet(1).I32Add().End()
+	b.Export("add", add)
+}
+
+// NewModule adds to b the functions of the test module, exercising the
+// control instructions, calls, memories, tables, globals and numeric
+// instructions of the MVP. It exports them, with its memory and its
+// mutable global, counter.
+func NewModule(b *wasm.Builder) {
+	mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Flags: wasm.LimitsMax, Initial: 1, Maximum: 2}})
+	b.Data(mem, wasm.ConstI32(16), []byte("hello, world\xff\xfe"))
+	b.Export("memory", mem)
+	counter := b.Global(wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}, wasm.ConstI32(40))
+	b.Export("counter", counter)
+
+	// fac computes the factorial of its argument, recursively.
+	fac := b.Func("fac", wasm.FuncType{Params: i64, Results: i64})
+	e := fac.Body()
+	e.LocalGet(0).I64Eqz()
+	e.If(wasm.I64)
+	e.I64Const(1)
+	e.Else()
+	e.LocalGet(0).LocalGet(0).I64Const(1).I64Sub().Call(fac).I64Mul()
+	e.End()
+	e.End()
+	b.Export("fac", fac)
+
+	// sum computes the sum of the integers below its argument, with a
+	// loop.
+	sum := b.Func("sum", wasm.FuncType{Params: i32, Results: i32})
+	e = sum.Body()
+	acc := e.Local(wasm.I32)
+	done := e.Block()
+	loop := e.Loop()
+	e.LocalGet(0).I32Eqz().BrIf(done)
+	e.LocalGet(0).I32Const(1).I32Sub().LocalTee(0).LocalGet(acc).I32Add().LocalSet(acc)
+	e.Br(loop)
+	e.End()
+	e.End()
+	e.LocalGet(acc)
+	e.End()
+	b.Export("sum", sum)
+
+	// switch maps 0, 1 and 2 to 100, 101 and 102, and the other values
+	// to 199, with a br_table branching out of nested blocks with values.
+	sw := b.Func("switch", wasm.FuncType{Params: i32, Results: i32})
+	e = sw.Body()
+	out := e.Block(wasm.I32)
+	def := e.Block()
+	c2 := e.Block()
+	c1 := e.Block()
+	c0 := e.Block()
+	e.I32Const(7) // left on the stack below the branch operands.
+	e.LocalGet(0).BrTable([]wasm.Label{c0, c1, c2}, def)
+	e.End()
+	e.I32Const(100).Br(out)
+	e.End()
+	e.I32Const(101).Br(out)
+	e.End()
+	e.I32Const(102).Return()
+	e.End()
+	e.I32Const(199)
+	e.End()
+	e.End()
+	b.Export("switch", sw)
+
+	// load returns the byte at its argument, sign-extended.
+	load := b.Func("load", wasm.FuncType{Params: i32, Results: i32})
+	load.Body().LocalGet(0).I32Load8S(0).End()
+	b.Export("load", load)
+
+	// store stores its second argument at its first one and returns the
+	// i64 value loaded at the same address.
+	store := b.Func("store", wasm.FuncType{Params: i32x2, Results: i64})
+	store.Body().LocalGet(0).LocalGet(1).I32Store16(1).LocalGet(0).I64Load(0).End()
+	b.Export("store", store)
+
+	grow := b.Func("grow", wasm.FuncType{Params: i32, Results: i32})
+	grow.Body().LocalGet(0).MemoryGrow().MemorySize().I32Const(16).I32Shl().I32Add().End()
+	b.Export("grow", grow)
+
+	// dispatch calls the function at its index in the table.
+	table := b.Table(wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 4}})
+	add := b.Func("add", wasm.FuncType{Params: i32x2, Results: i32})
+	add.Body().LocalGet(0).LocalGet(1).I32Add().End()
+	sub := b.Func("sub", wasm.FuncType{Params: i32x2, Results: i32})
+	sub.Body().LocalGet(0).LocalGet(1).I32Sub().End()
+	b.Elements(table, wasm.ConstI32(0), add, sub, fac)
+	dispatch := b.Func("dispatch", wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.I32, wasm.I32}, Results: i32})
+	dispatch.Body().LocalGet(1).LocalGet(2).LocalGet(0).CallIndirect(wasm.FuncType{Params: i32x2, Results: i32}).End()
+	b.Export("dispatch", dispatch)
+
+	// the start function increments the counter.
+	start := b.Func("start", wasm.FuncType{})
+	start.Body().GlobalGet(counter).I32Const(2).I32Add().GlobalSet(counter).End()
+	b.Start(start)
+	incr := b.Func("incr", wasm.FuncType{Results: i32})
+	incr.Body().GlobalGet(counter).I32Const(1).I32Add().GlobalSet(counter).GlobalGet(counter).End()
+	b.Export("incr", incr)
+
+	trap := b.Func("trap", wasm.FuncType{Params: i32})
+	e = trap.Body()
+	e.LocalGet(0).If()
+	e.Unreachable()
+	e.End()
+	e.End()
+	b.Export("trap", trap)
+
+	// swap swaps its arguments through the operand stack and returns
+	// 10*a+b.
+	swap := b.Func("swap", wasm.FuncType{Params: i32x2, Results: i32})
+	swap.Body().
+		LocalGet(0).LocalGet(1).LocalSet(0).LocalSet(1).
+		LocalGet(0).I32Const(10).I32Mul().LocalGet(1).I32Add().End()
+	b.Export("swap", swap)
+
+	// tee returns a*(a+1), reading a before it is modified.
+	tee := b.Func("tee", wasm.FuncType{Params: i32, Results: i32})
+	tee.Body().LocalGet(0).LocalGet(0).I32Const(1).I32Add().LocalTee(0).I32Mul().End()
+	b.Export("tee", tee)
+
+	// brvalue returns 5 if its argument is not zero, 7 otherwise.
+	brvalue := b.Func("brvalue", wasm.FuncType{Params: i32, Results: i32})
+	e = brvalue.Body()
+	blk := e.Block(wasm.I32)
+	e.I32Const(5).LocalGet(0).BrIf(blk).Drop().I32Const(7)
+	e.End()
+	e.End()
+	b.Export("brvalue", brvalue)
+
+	// select returns 10 if its argument is not zero, 20 otherwise.
+	sel := b.Func("select", wasm.FuncType{Params: i32, Results: i32})
+	sel.Body().I32Const(10).I32Const(20).LocalGet(0).Select().End()
+	b.Export("select", sel)
+
+	// orminus returns its argument if it is not zero, -1 otherwise, with
+	// a conditional branch out of the function.
+	orminus := b.Func("orminus", wasm.FuncType{Params: i32, Results: i32})
+	orminus.SetBody(nil, []byte{
+		byte(wasm.Op_block), 0x40,
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_get_local), 0,
+		byte(wasm.Op_br_if), 1,
+		byte(wasm.Op_drop),
+		byte(wasm.Op_end),
+		byte(wasm.Op_i32_const), 0x7f,
+	})
+	b.Export("orminus", orminus)
+
+	// fib computes the Fibonacci numbers with a loop.
+	fib := b.Func("fib", wasm.FuncType{Params: i32, Results: i64})
+	e = fib.Body()
+	x := e.Local(wasm.I64)
+	y := e.Local(wasm.I64)
+	e.I64Const(1).LocalSet(y)
+	done = e.Block()
+	loop = e.Loop()
+	e.LocalGet(0).I32Eqz().BrIf(done)
+	e.LocalGet(x).LocalGet(y).LocalGet(x).I64Add().LocalSet(x).LocalSet(y)
+	e.LocalGet(0).I32Const(1).I32Sub().LocalSet(0)
+	e.Br(loop)
+	e.End()
+	e.End()
+	e.LocalGet(x).End()
+	b.Export("fib", fib)
+
+	// dead returns 3, skipping unreachable code.
+	dead := b.Func("dead", wasm.FuncType{Results: i32})
+	e = dead.Body()
+	blk = e.Block()
+	e.Br(blk)
+	e.I32Const(1).Drop()
+	e.End()
+	e.I32Const(3).Return()
+	e.I32Const(4)
+	e.End()
+	b.Export("dead", dead)
+
+	// ping recurses forever.
+	ping := b.Func("ping", wasm.FuncType{})
+	ping.Body().Call(ping).End()
+	b.Export("ping", ping)
+
+	divs := b.Func("divs", wasm.FuncType{Params: i64x2, Results: i64})
+	divs.Body().LocalGet(0).LocalGet(1).I64DivS().End()
+	b.Export("divs", divs)
+
+	remu := b.Func("remu", wasm.FuncType{Params: i32x2, Results: i32})
+	remu.Body().LocalGet(0).LocalGet(1).I32RemU().End()
+	b.Export("remu", remu)
+
+	bits := b.Func("bits", wasm.FuncType{Params: i32x2, Results: i32})
+	bits.Body().
+		LocalGet(0).LocalGet(1).I32Rotl().
+		LocalGet(0).LocalGet(1).I32ShrU().I32Xor().
+		LocalGet(0).I32Clz().I32Add().
+		LocalGet(0).LocalGet(1).I32ShrS().I32Sub().End()
+	b.Export("bits", bits)
+
+	fops := b.Func("fops", wasm.FuncType{Params: f64x2, Results: f64})
+	fops.Body().
+		LocalGet(0).F64Sqrt().
+		LocalGet(0).LocalGet(1).F64Min().F64Add().
+		LocalGet(1).LocalGet(0).F64Copysign().F64Mul().
+		LocalGet(0).F64Nearest().F64Sub().End()
+	b.Export("fops", fops)
+
+	trunc := b.Func("trunc", wasm.FuncType{Params: f64, Results: i32})
+	trunc.Body().LocalGet(0).I32TruncF64S().End()
+	b.Export("trunc", trunc)
+
+	conv := b.Func("conv", wasm.FuncType{Params: i64, Results: f32})
+	conv.Body().LocalGet(0).F32ConvertI64U().LocalGet(0).I32WrapI64().F32ConvertI32S().F32Add().End()
+	b.Export("conv", conv)
+
+	neg := b.Func("neg", wasm.FuncType{Params: f32, Results: i32})
+	neg.Body().LocalGet(0).F32Neg().I32ReinterpretF32().End()
+	b.Export("neg", neg)
+
+	// loopvalue counts its argument down to zero in a loop yielding 42,
+	// after a loop whose value is dropped.
+	loopvalue := b.Func("loopvalue", wasm.FuncType{Params: i32, Results: i32})
+	e = loopvalue.Body()
+	e.Loop(wasm.I32)
+	e.I32Const(1)
+	e.End()
+	e.Drop()
+	again := e.Loop(wasm.I32)
+	e.LocalGet(0).I32Const(1).I32Sub().LocalTee(0).BrIf(again)
+	e.I32Const(42)
+	e.End()
+	e.LocalGet(0).I32Add()
+	e.End()
+	b.Export("loopvalue", loopvalue)
+}
+
+// Sieve adds to b and exports sieve, counting the primes below its
+// argument with the sieve of Eratosthenes, in the first memory of b.
+func Sieve(b *wasm.Builder) {
+	sieve := b.Func("sieve", wasm.FuncType{Params: i32, Results: i32})
+	e := sieve.Body()
+	var (
+		i     = e.Local(wasm.I32)
+		j     = e.Local(wasm.I32)
+		count = e.Local(wasm.I32)
+	)
+	// clear the sieve.
+	done := e.Block()
+	loop := e.Loop()
+	e.LocalGet(i).LocalGet(0).I32GeU().BrIf(done)
+	e.LocalGet(i).I32Const(0).I32Store8(0)
+	e.LocalGet(i).I32Const(1).I32Add().LocalSet(i)
+	e.Br(loop)
+	e.End()
+	e.End()
+
+	e.I32Const(2).LocalSet(i)
+	done = e.Block()
+	loop = e.Loop()
+	e.LocalGet(i).LocalGet(0).I32GeU().BrIf(done)
+	e.LocalGet(i).I32Load8U(0).I32Eqz()
+	e.If()
+	e.LocalGet(count).I32Const(1).I32Add().LocalSet(count)
+	e.LocalGet(i).LocalGet(i).I32Add().LocalSet(j)
+	marked := e.Block()
+	mark := e.Loop()
+	e.LocalGet(j).LocalGet(0).I32GeU().BrIf(marked)
+	e.LocalGet(j).I32Const(1).I32Store8(0)
+	e.LocalGet(j).LocalGet(i).I32Add().LocalSet(j)
+	e.Br(mark)
+	e.End()
+	e.End()
+	e.End()
+	e.LocalGet(i).I32Const(1).I32Add().LocalSet(i)
+	e.Br(loop)
+	e.End()
+	e.End()
+	e.LocalGet(count).End()
+	b.Export("sieve", sieve)
+}
+
+// NewLibModule adds to b and exports a memory, a mutable global, counter,
+// an immutable global, answer, a table holding double and the functions
+// incr and load.
+func NewLibModule(b *wasm.Builder) {
+	mem := b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Flags: wasm.LimitsMax, Initial: 1, Maximum: 4}})
+	b.Export("memory", mem)
+	counter := b.Global(wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}, wasm.ConstI32(0))
+	b.Export("counter", counter)
+	b.Export("answer", b.Global(wasm.GlobalType{ContentType: wasm.I32}, wasm.ConstI32(42)))
+
+	incr := b.Func("incr", wasm.FuncType{Results: i32})
+	incr.Body().GlobalGet(counter).I32Const(1).I32Add().GlobalSet(counter).GlobalGet(counter).End()
+	b.Export("incr", incr)
+
+	load := b.Func("load", wasm.FuncType{Params: i32, Results: i32})
+	load.Body().LocalGet(0).I32Load(0).End()
+	b.Export("load", load)
+
+	double := b.Func("double", wasm.FuncType{Params: i32, Results: i32})
+	double.Body().LocalGet(0).I32Const(1).I32Shl().End()
+	table := b.Table(wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: wasm.ResizableLimits{Initial: 2}})
+	b.Export("table", table)
+	b.Elements(table, wasm.ConstI32(0), double)
+}
+
+// NewHostModule adds to b the imports of the host module, env.add3 and
+// env.base, provided by Imports, and exports host, returning
+// add3(add3(x))+base. It must be called before the functions of b are
+// referenced.
+func NewHostModule(b *wasm.Builder) {
+	add3 := b.ImportFunc("env", "add3", wasm.FuncType{Params: i32, Results: i32})
+	base := b.ImportGlobal("env", "base", wasm.GlobalType{ContentType: wasm.I32})
+
+	host := b.Func("host", wasm.FuncType{Params: i32, Results: i32})
+	host.Body().LocalGet(0).Call(add3).Call(add3).GlobalGet(base).I32Add().End()
+	b.Export("host", host)
+}
+
+// Imports returns the imports of the host module.
+func Imports() exec.Imports {
+	return exec.Imports{
+		"env": exec.HostModule{
+			"add3": func(x int32) int32 { return x + 3 },
+			"base": exec.NewGlobal(wasm.GlobalType{ContentType: wasm.I32}, exec.EncodeI32(2)),
+		},
+	}
+}
@@ -5,6 +5,7 @@
 package wasm
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
@@ -14,8 +15,11 @@ import (
 type Module struct {
 	Header   ModuleHeader
 	Sections []Section
+
+	raw *rawEncoding // original encoding of the module, if retained
 }
 
+// Open decodes the named wasm module file.
 func Open(name string) (Module, error) {
 	f, err := os.Open(name)
 	if err != nil {
@@ -23,8 +27,14 @@ func Open(name string) (Module, error) {
 	}
 	defer f.Close()
 
-	dec := decoder{r: f}
-	return dec.readModule()
+	return Decode(bufio.NewReader(f))
+}
+
+// Decode decodes a wasm module from r.
+// The module may only use the features of the MVP: DecodeWithOptions
+// decodes modules using other features.
+func Decode(r io.Reader) (Module, error) {
+	return DecodeWithOptions(r, DecodeOptions{})
 }
 
 type ModuleHeader struct {
@@ -45,35 +55,40 @@ type Section interface {
 type SectionID byte
 
 const (
-	UnknownID  SectionID = 0  // User section ID
-	TypeID               = 1  // Function signature declarations
-	ImportID             = 2  // Import declarations
-	FunctionID           = 3  // Function declarations
-	TableID              = 4  // Indirect function table and other tables
-	MemoryID             = 5  // Memory attributes
-	GlobalID             = 6  // Global declarations
-	ExportID             = 7  // Exports
-	StartID              = 8  // Start function declaration
-	ElementID            = 9  // Elements section
-	CodeID               = 10 // Function bodies (code)
-	DataID               = 11 // Data segments
+	UnknownID   SectionID = 0  // User section ID
+	TypeID                = 1  // Function signature declarations
+	ImportID              = 2  // Import declarations
+	FunctionID            = 3  // Function declarations
+	TableID               = 4  // Indirect function table and other tables
+	MemoryID              = 5  // Memory attributes
+	GlobalID              = 6  // Global declarations
+	ExportID              = 7  // Exports
+	StartID               = 8  // Start function declaration
+	ElementID             = 9  // Elements section
+	CodeID                = 10 // Function bodies (code)
+	DataID                = 11 // Data segments
+	DataCountID           = 12 // Number of data segments (bulk memory proposal)
+	TagID                 = 13 // Tag declarations (exception handling proposal)
 )
 
-func (TypeSection) ID() SectionID     { return TypeID }
-func (ImportSection) ID() SectionID   { return ImportID }
-func (FunctionSection) ID() SectionID { return FunctionID }
-func (TableSection) ID() SectionID    { return TableID }
-func (MemorySection) ID() SectionID   { return MemoryID }
-func (GlobalSection) ID() SectionID   { return GlobalID }
-func (ExportSection) ID() SectionID   { return ExportID }
-func (StartSection) ID() SectionID    { return StartID }
-func (ElementSection) ID() SectionID  { return ElementID }
-func (CodeSection) ID() SectionID     { return CodeID }
-func (DataSection) ID() SectionID     { return DataID }
-func (NameSection) ID() SectionID     { return UnknownID }
+func (TypeSection) ID() SectionID      { return TypeID }
+func (ImportSection) ID() SectionID    { return ImportID }
+func (FunctionSection) ID() SectionID  { return FunctionID }
+func (TableSection) ID() SectionID     { return TableID }
+func (MemorySection) ID() SectionID    { return MemoryID }
+func (GlobalSection) ID() SectionID    { return GlobalID }
+func (ExportSection) ID() SectionID    { return ExportID }
+func (StartSection) ID() SectionID     { return StartID }
+func (ElementSection) ID() SectionID   { return ElementID }
+func (CodeSection) ID() SectionID      { return CodeID }
+func (DataSection) ID() SectionID      { return DataID }
+func (DataCountSection) ID() SectionID { return DataCountID }
+func (TagSection) ID() SectionID       { return TagID }
+func (NameSection) ID() SectionID      { return UnknownID }
+func (CustomSection) ID() SectionID    { return UnknownID }
 
 type TypeSection struct {
-	types []FuncType // type entries
+	Types []FuncType // type entries
 }
 
 func (s *TypeSection) readWasm(r io.Reader) error {
@@ -82,42 +97,41 @@ func (s *TypeSection) readWasm(r io.Reader) error {
 }
 
 type ImportSection struct {
-	imports []ImportEntry
+	Imports []ImportEntry
 }
 
 type ImportEntry struct {
-	module string
-	field  string
-	kind   ExternalKind // the kind of definition being imported
-
-	typ interface{} // imported value
-
-	/*
-		FunctionType varuint32  // type index of the function signature (if Kind is Function)
-		TableType    TableType  // type of imported table (if Kind==Table)
-		MemoryType   MemoryType // type of imported memory (if Kind==Memory)
-		GlobalType   GlobalType // type of importer global
-	*/
+	Module string
+	Field  string
+	Kind   ExternalKind // the kind of definition being imported
+
+	// Type is the type of the imported value:
+	//  - uint32: type index of the function signature (if Kind==FunctionKind)
+	//  - TableType: type of the imported table (if Kind==TableKind)
+	//  - MemoryType: type of the imported memory (if Kind==MemoryKind)
+	//  - GlobalType: type of the imported global (if Kind==GlobalKind)
+	//  - TagType: type of the imported tag (if Kind==TagKind)
+	Type interface{}
 }
 
 // FunctionSection declares the signature of all functions in the module
 type FunctionSection struct {
-	types []uint32 // indices into the type sections
+	Types []uint32 // indices into the type sections
 }
 
 // TableSection encodes a table
 type TableSection struct {
-	tables []TableType
+	Tables []TableType
 }
 
 // MemorySection encodes a memory
 type MemorySection struct {
-	memories []MemoryType
+	Memories []MemoryType
 }
 
 // GlobalSection encodes the global section
 type GlobalSection struct {
-	globals []GlobalVariable
+	Globals []GlobalVariable
 }
 
 // GlobalVariable represents a single global variable of a given type,
@@ -129,14 +143,14 @@ type GlobalVariable struct {
 
 // ExportSection encodes the export section
 type ExportSection struct {
-	exports []ExportEntry
+	Exports []ExportEntry
 }
 
 // ExportEntry represents an exported entity.
 type ExportEntry struct {
-	field string
-	kind  ExternalKind // kind of definition being exported
-	index uint32       // index into the corresponding index space
+	Field string
+	Kind  ExternalKind // kind of definition being exported
+	Index uint32       // index into the corresponding index space
 }
 
 // StartSection declares the start function
@@ -146,13 +160,41 @@ type StartSection struct {
 
 // ElementSection encodes the elements section
 type ElementSection struct {
-	elements []ElemSegment
+	Elements []ElemSegment
 }
 
 type ElemSegment struct {
-	Index  uint32   // the table index
-	Offset InitExpr // an i32 initializer expression that computes the offset at which to place the elements
-	Elems  []uint32 // sequence of function indices
+	Flags  uint32     // kind of segment and encoding of its elements, 0 for MVP segments (see ElemPassive, ElemExplicit and ElemExprs)
+	Index  uint32     // the table index
+	Offset InitExpr   // an i32 initializer expression that computes the offset at which to place the elements
+	Type   ElemType   // the type of the elements, if Flags is not 0 nor ElemExprs
+	Elems  []uint32   // sequence of function indices, if Flags&ElemExprs == 0
+	Exprs  []InitExpr // sequence of reference expressions, if Flags&ElemExprs != 0
+}
+
+// Flags of element segments.
+const (
+	ElemPassive  = 0x1 // the segment is passive, or declarative with ElemExplicit
+	ElemExplicit = 0x2 // the table index is explicit, or the segment is declarative with ElemPassive
+	ElemExprs    = 0x4 // the elements are reference expressions
+)
+
+// isActive returns whether the segment is copied into its table at
+// instantiation.
+func (es *ElemSegment) isActive() bool { return es.Flags&ElemPassive == 0 }
+
+// isDeclarative returns whether the segment only forward-declares the
+// functions referenced by ref.func instructions.
+func (es *ElemSegment) isDeclarative() bool {
+	return es.Flags&(ElemPassive|ElemExplicit) == ElemPassive|ElemExplicit
+}
+
+// elemType returns the type of the elements of the segment.
+func (es *ElemSegment) elemType() ValueType {
+	if es.Flags == 0 || es.Flags == ElemExprs {
+		return FuncRef
+	}
+	return ValueType(es.Type)
 }
 
 // CodeSection contains a body for every function in the module.
@@ -165,28 +207,62 @@ type CodeSection struct {
 
 // DataSection declares the initialized data that is loaded into linear memory
 type DataSection struct {
-	segments []DataSegment
+	Segments []DataSegment
 }
 
 type DataSegment struct {
+	Flags  uint32   // 0: active segment of memory 0, DataPassive or DataExplicit
 	Index  uint32   // the linear memory index
 	Offset InitExpr // an i32 initializer expression that computes the offset at which to place the data
 	Data   []byte
 }
 
-// NameSection describes user-defined sections
+// Flags of data segments.
+const (
+	DataPassive  = 0x1 // the segment is passive
+	DataExplicit = 0x2 // the segment is active, with an explicit memory index
+)
+
+// DataCountSection declares the number of data segments, for the bulk
+// memory instructions to be validated before the data section is decoded.
+type DataCountSection struct {
+	Count uint32
+}
+
+// TagSection declares the tags of the exception handling proposal.
+type TagSection struct {
+	Tags []TagType
+}
+
+// NameSection describes the names of the module, its functions and their locals.
 type NameSection struct {
-	name  string
-	funcs []FunctionNames
+	Name        string           // name of the custom section ("name")
+	Module      string           // name of the module
+	Funcs       []FunctionNames  // names of functions, sorted by function index
+	Subsections []NameSubsection // name subsections not otherwise decoded
 }
 
 type FunctionNames struct {
-	name   string
-	locals []LocalName
+	Index  uint32      // function index
+	Name   string      // function name
+	Locals []LocalName // local names, sorted by local index
 }
 
 type LocalName struct {
-	name string
+	Index uint32 // local index
+	Name  string // local name
+}
+
+// NameSubsection is an undecoded subsection of the name section.
+type NameSubsection struct {
+	ID   byte
+	Data []byte
+}
+
+// CustomSection is a custom section holding uninterpreted data.
+type CustomSection struct {
+	Name string
+	Data []byte
 }
 
 type FunctionBody struct {
@@ -4,21 +4,40 @@
 
 package wasm
 
+import "fmt"
+
 // Opcode is a wasm opcode.
 type Opcode byte
 
 // Language types opcodes as defined by:
 // http://webassembly_org/docs/binary-encoding/#language-types
 const (
-	Op_i32     Opcode = 0x7f
-	Op_i64            = 0x7e
-	Op_f32            = 0x7d
-	Op_f64            = 0x7c
-	Op_anyfunc        = 0x70
-	Op_func           = 0x60
-	Op_empty          = 0x40
+	Op_i32       Opcode = 0x7f
+	Op_i64              = 0x7e
+	Op_f32              = 0x7d
+	Op_f64              = 0x7c
+	Op_v128             = 0x7b
+	Op_anyfunc          = 0x70
+	Op_externref        = 0x6f
+	Op_func             = 0x60
+	Op_empty            = 0x40
+)
+
+// Type constructors of the gc proposal.
+const (
+	gcRecType      = 0x4e
+	gcSubFinalType = 0x4f
+	gcSubType      = 0x50
+	gcArrayType    = 0x5e
+	gcStructType   = 0x5f
+	gcRefNull      = 0x63
+	gcRef          = 0x64
 )
 
+// elemKindFunc is the element kind of element segments of function
+// indices.
+const elemKindFunc = 0x00
+
 // Control flow operators
 const (
 	Op_unreachable Opcode = 0x00
@@ -34,16 +53,33 @@ const (
 	Op_return             = 0x0f
 )
 
+// Exception handling operators
+const (
+	Op_try       Opcode = 0x06
+	Op_catch            = 0x07
+	Op_throw            = 0x08
+	Op_rethrow          = 0x09
+	Op_throw_ref        = 0x0a
+	Op_delegate         = 0x18
+	Op_catch_all        = 0x19
+	Op_try_table        = 0x1f
+)
+
 // Call operators
 const (
-	Op_call          Opcode = 0x10
-	Op_call_indirect        = 0x11
+	Op_call                 Opcode = 0x10
+	Op_call_indirect               = 0x11
+	Op_return_call                 = 0x12
+	Op_return_call_indirect        = 0x13
+	Op_call_ref                    = 0x14
+	Op_return_call_ref             = 0x15
 )
 
 // Parametric operators
 const (
-	Op_drop   Opcode = 0x1a
-	Op_select        = 0x1b
+	Op_drop     Opcode = 0x1a
+	Op_select          = 0x1b
+	Op_select_t        = 0x1c
 )
 
 // Variable access
@@ -55,6 +91,12 @@ const (
 	Op_set_global        = 0x24
 )
 
+// Table access
+const (
+	Op_table_get Opcode = 0x25
+	Op_table_set        = 0x26
+)
+
 // Memory-related operators
 const (
 	Op_i32_load       Opcode = 0x28
@@ -230,3 +272,309 @@ const (
 	Op_f32_reinterpret_i32        = 0xbe
 	Op_f64_reinterpret_i64        = 0xbf
 )
+
+// Sign-extension operators
+const (
+	Op_i32_extend8_s  Opcode = 0xc0
+	Op_i32_extend16_s        = 0xc1
+	Op_i64_extend8_s         = 0xc2
+	Op_i64_extend16_s        = 0xc3
+	Op_i64_extend32_s        = 0xc4
+)
+
+// Reference operators
+const (
+	Op_ref_null        Opcode = 0xd0
+	Op_ref_is_null            = 0xd1
+	Op_ref_func               = 0xd2
+	Op_ref_eq                 = 0xd3
+	Op_ref_as_non_null        = 0xd4
+	Op_br_on_null             = 0xd5
+	Op_br_on_non_null         = 0xd6
+)
+
+// Prefixes of multi-byte opcodes. The prefix is followed by the LEB128
+// encoding of the opcode.
+const (
+	Op_gc_prefix      Opcode = 0xfb
+	Op_misc_prefix           = 0xfc
+	Op_simd_prefix           = 0xfd
+	Op_threads_prefix        = 0xfe
+)
+
+// immKind describes the immediates following an opcode.
+type immKind byte
+
+const (
+	immNone         immKind = iota
+	immBlockType            // block type
+	immLabel                // label index
+	immLabels               // vector of label indices followed by the default label index
+	immFunc                 // function index
+	immCallIndirect         // type index and table index
+	immLocal                // local index
+	immGlobal               // global index
+	immMemArg               // alignment and offset of a memory access
+	immMemory               // memory index (reserved byte)
+	immI32                  // i32 constant
+	immI64                  // i64 constant
+	immF32                  // f32 constant
+	immF64                  // f64 constant
+	immPrefix               // opcode following a prefix
+	immSelectT              // vector of value types
+	immRefType              // reference type
+	immType                 // type index
+	immTable                // table index
+	immTag                  // tag index
+	immTryTable             // block type, vector of catch clauses
+	immTwoIndices           // two indices: data and memory, element and table, or destination and source
+	immIndex                // element or data segment index
+	immMemArgLane           // memory access and lane index
+	immLane                 // lane index
+	immV128                 // v128 constant or shuffle lanes
+	immReserved             // reserved byte
+)
+
+// opcodeInfo describes an opcode.
+type opcodeInfo struct {
+	name    string      // name of the instruction in the text format
+	imm     immKind     // kind of the immediates of the instruction
+	special bool        // whether the typing of the instruction depends on its context
+	in      []ValueType // types of the operands
+	out     []ValueType // types of the results
+	feature Features    // proposal introducing the instruction
+	align   uint32      // natural alignment of memory accesses of prefixed instructions
+}
+
+// opcodes describes all single-byte opcodes.
+var opcodes = [256]opcodeInfo{
+	Op_unreachable:         {name: "unreachable", special: true},
+	Op_nop:                 {name: "nop"},
+	Op_block:               {name: "block", imm: immBlockType, special: true},
+	Op_loop:                {name: "loop", imm: immBlockType, special: true},
+	Op_if:                  {name: "if", imm: immBlockType, special: true},
+	Op_else:                {name: "else", special: true},
+	Op_end:                 {name: "end", special: true},
+	Op_br:                  {name: "br", imm: immLabel, special: true},
+	Op_br_if:               {name: "br_if", imm: immLabel, special: true},
+	Op_br_table:            {name: "br_table", imm: immLabels, special: true},
+	Op_return:              {name: "return", special: true},
+	Op_call:                {name: "call", imm: immFunc, special: true},
+	Op_call_indirect:       {name: "call_indirect", imm: immCallIndirect, special: true},
+	Op_drop:                {name: "drop", special: true},
+	Op_select:              {name: "select", special: true},
+	Op_get_local:           {name: "local.get", imm: immLocal, special: true},
+	Op_set_local:           {name: "local.set", imm: immLocal, special: true},
+	Op_tee_local:           {name: "local.tee", imm: immLocal, special: true},
+	Op_get_global:          {name: "global.get", imm: immGlobal, special: true},
+	Op_set_global:          {name: "global.set", imm: immGlobal, special: true},
+	Op_i32_load:            {name: "i32.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i64_load:            {name: "i64.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_f32_load:            {name: "f32.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{F32}},
+	Op_f64_load:            {name: "f64.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{F64}},
+	Op_i32_load8_s:         {name: "i32.load8_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_load8_u:         {name: "i32.load8_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_load16_s:        {name: "i32.load16_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_load16_u:        {name: "i32.load16_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i64_load8_s:         {name: "i64.load8_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_load8_u:         {name: "i64.load8_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_load16_s:        {name: "i64.load16_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_load16_u:        {name: "i64.load16_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_load32_s:        {name: "i64.load32_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_load32_u:        {name: "i64.load32_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i32_store:           {name: "i32.store", imm: immMemArg, in: []ValueType{I32, I32}},
+	Op_i64_store:           {name: "i64.store", imm: immMemArg, in: []ValueType{I32, I64}},
+	Op_f32_store:           {name: "f32.store", imm: immMemArg, in: []ValueType{I32, F32}},
+	Op_f64_store:           {name: "f64.store", imm: immMemArg, in: []ValueType{I32, F64}},
+	Op_i32_store8:          {name: "i32.store8", imm: immMemArg, in: []ValueType{I32, I32}},
+	Op_i32_store16:         {name: "i32.store16", imm: immMemArg, in: []ValueType{I32, I32}},
+	Op_i64_store8:          {name: "i64.store8", imm: immMemArg, in: []ValueType{I32, I64}},
+	Op_i64_store16:         {name: "i64.store16", imm: immMemArg, in: []ValueType{I32, I64}},
+	Op_i64_store32:         {name: "i64.store32", imm: immMemArg, in: []ValueType{I32, I64}},
+	Op_current_memory:      {name: "memory.size", imm: immMemory, out: []ValueType{I32}},
+	Op_grow_memory:         {name: "memory.grow", imm: immMemory, in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_const:           {name: "i32.const", imm: immI32, out: []ValueType{I32}},
+	Op_i64_const:           {name: "i64.const", imm: immI64, out: []ValueType{I64}},
+	Op_f32_const:           {name: "f32.const", imm: immF32, out: []ValueType{F32}},
+	Op_f64_const:           {name: "f64.const", imm: immF64, out: []ValueType{F64}},
+	Op_i32_eqz:             {name: "i32.eqz", in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_eq:              {name: "i32.eq", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_ne:              {name: "i32.ne", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_lt_s:            {name: "i32.lt_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_lt_u:            {name: "i32.lt_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_gt_s:            {name: "i32.gt_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_gt_u:            {name: "i32.gt_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_le_s:            {name: "i32.le_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_le_u:            {name: "i32.le_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_ge_s:            {name: "i32.ge_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_ge_u:            {name: "i32.ge_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i64_eqz:             {name: "i64.eqz", in: []ValueType{I64}, out: []ValueType{I32}},
+	Op_i64_eq:              {name: "i64.eq", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_ne:              {name: "i64.ne", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_lt_s:            {name: "i64.lt_s", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_lt_u:            {name: "i64.lt_u", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_gt_s:            {name: "i64.gt_s", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_gt_u:            {name: "i64.gt_u", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_le_s:            {name: "i64.le_s", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_le_u:            {name: "i64.le_u", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_ge_s:            {name: "i64.ge_s", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_i64_ge_u:            {name: "i64.ge_u", in: []ValueType{I64, I64}, out: []ValueType{I32}},
+	Op_f32_eq:              {name: "f32.eq", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f32_ne:              {name: "f32.ne", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f32_lt:              {name: "f32.lt", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f32_gt:              {name: "f32.gt", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f32_le:              {name: "f32.le", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f32_ge:              {name: "f32.ge", in: []ValueType{F32, F32}, out: []ValueType{I32}},
+	Op_f64_eq:              {name: "f64.eq", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_f64_ne:              {name: "f64.ne", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_f64_lt:              {name: "f64.lt", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_f64_gt:              {name: "f64.gt", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_f64_le:              {name: "f64.le", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_f64_ge:              {name: "f64.ge", in: []ValueType{F64, F64}, out: []ValueType{I32}},
+	Op_i32_clz:             {name: "i32.clz", in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_ctz:             {name: "i32.ctz", in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_popcnt:          {name: "i32.popcnt", in: []ValueType{I32}, out: []ValueType{I32}},
+	Op_i32_add:             {name: "i32.add", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_sub:             {name: "i32.sub", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_mul:             {name: "i32.mul", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_div_s:           {name: "i32.div_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_div_u:           {name: "i32.div_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_rem_s:           {name: "i32.rem_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_rem_u:           {name: "i32.rem_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_and:             {name: "i32.and", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_or:              {name: "i32.or", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_xor:             {name: "i32.xor", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_shl:             {name: "i32.shl", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_shr_s:           {name: "i32.shr_s", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_shr_u:           {name: "i32.shr_u", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_rotl:            {name: "i32.rotl", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i32_rotr:            {name: "i32.rotr", in: []ValueType{I32, I32}, out: []ValueType{I32}},
+	Op_i64_clz:             {name: "i64.clz", in: []ValueType{I64}, out: []ValueType{I64}},
+	Op_i64_ctz:             {name: "i64.ctz", in: []ValueType{I64}, out: []ValueType{I64}},
+	Op_i64_popcnt:          {name: "i64.popcnt", in: []ValueType{I64}, out: []ValueType{I64}},
+	Op_i64_add:             {name: "i64.add", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_sub:             {name: "i64.sub", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_mul:             {name: "i64.mul", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_div_s:           {name: "i64.div_s", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_div_u:           {name: "i64.div_u", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_rem_s:           {name: "i64.rem_s", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_rem_u:           {name: "i64.rem_u", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_and:             {name: "i64.and", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_or:              {name: "i64.or", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_xor:             {name: "i64.xor", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_shl:             {name: "i64.shl", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_shr_s:           {name: "i64.shr_s", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_shr_u:           {name: "i64.shr_u", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_rotl:            {name: "i64.rotl", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_i64_rotr:            {name: "i64.rotr", in: []ValueType{I64, I64}, out: []ValueType{I64}},
+	Op_f32_abs:             {name: "f32.abs", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_neg:             {name: "f32.neg", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_ceil:            {name: "f32.ceil", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_floor:           {name: "f32.floor", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_trunc:           {name: "f32.trunc", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_nearest:         {name: "f32.nearest", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_sqrt:            {name: "f32.sqrt", in: []ValueType{F32}, out: []ValueType{F32}},
+	Op_f32_add:             {name: "f32.add", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_sub:             {name: "f32.sub", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_mul:             {name: "f32.mul", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_div:             {name: "f32.div", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_min:             {name: "f32.min", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_max:             {name: "f32.max", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f32_copysign:        {name: "f32.copysign", in: []ValueType{F32, F32}, out: []ValueType{F32}},
+	Op_f64_abs:             {name: "f64.abs", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_neg:             {name: "f64.neg", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_ceil:            {name: "f64.ceil", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_floor:           {name: "f64.floor", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_trunc:           {name: "f64.trunc", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_nearest:         {name: "f64.nearest", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_sqrt:            {name: "f64.sqrt", in: []ValueType{F64}, out: []ValueType{F64}},
+	Op_f64_add:             {name: "f64.add", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_sub:             {name: "f64.sub", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_mul:             {name: "f64.mul", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_div:             {name: "f64.div", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_min:             {name: "f64.min", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_max:             {name: "f64.max", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_f64_copysign:        {name: "f64.copysign", in: []ValueType{F64, F64}, out: []ValueType{F64}},
+	Op_i32_wrap_i64:        {name: "i32.wrap_i64", in: []ValueType{I64}, out: []ValueType{I32}},
+	Op_i32_trunc_s_f32:     {name: "i32.trunc_f32_s", in: []ValueType{F32}, out: []ValueType{I32}},
+	Op_i32_trunc_u_f32:     {name: "i32.trunc_f32_u", in: []ValueType{F32}, out: []ValueType{I32}},
+	Op_i32_trunc_s_f64:     {name: "i32.trunc_f64_s", in: []ValueType{F64}, out: []ValueType{I32}},
+	Op_i32_trunc_u_f64:     {name: "i32.trunc_f64_u", in: []ValueType{F64}, out: []ValueType{I32}},
+	Op_i64_extend_s_i32:    {name: "i64.extend_i32_s", in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_extend_u_i32:    {name: "i64.extend_i32_u", in: []ValueType{I32}, out: []ValueType{I64}},
+	Op_i64_trunc_s_f32:     {name: "i64.trunc_f32_s", in: []ValueType{F32}, out: []ValueType{I64}},
+	Op_i64_trunc_u_f32:     {name: "i64.trunc_f32_u", in: []ValueType{F32}, out: []ValueType{I64}},
+	Op_i64_trunc_s_f64:     {name: "i64.trunc_f64_s", in: []ValueType{F64}, out: []ValueType{I64}},
+	Op_i64_trunc_u_f64:     {name: "i64.trunc_f64_u", in: []ValueType{F64}, out: []ValueType{I64}},
+	Op_f32_convert_s_i32:   {name: "f32.convert_i32_s", in: []ValueType{I32}, out: []ValueType{F32}},
+	Op_f32_convert_u_i32:   {name: "f32.convert_i32_u", in: []ValueType{I32}, out: []ValueType{F32}},
+	Op_f32_convert_s_i64:   {name: "f32.convert_i64_s", in: []ValueType{I64}, out: []ValueType{F32}},
+	Op_f32_convert_u_i64:   {name: "f32.convert_i64_u", in: []ValueType{I64}, out: []ValueType{F32}},
+	Op_f32_demote_f64:      {name: "f32.demote_f64", in: []ValueType{F64}, out: []ValueType{F32}},
+	Op_f64_convert_s_i32:   {name: "f64.convert_i32_s", in: []ValueType{I32}, out: []ValueType{F64}},
+	Op_f64_convert_u_i32:   {name: "f64.convert_i32_u", in: []ValueType{I32}, out: []ValueType{F64}},
+	Op_f64_convert_s_i64:   {name: "f64.convert_i64_s", in: []ValueType{I64}, out: []ValueType{F64}},
+	Op_f64_convert_u_i64:   {name: "f64.convert_i64_u", in: []ValueType{I64}, out: []ValueType{F64}},
+	Op_f64_promote_f32:     {name: "f64.promote_f32", in: []ValueType{F32}, out: []ValueType{F64}},
+	Op_i32_reinterpret_f32: {name: "i32.reinterpret_f32", in: []ValueType{F32}, out: []ValueType{I32}},
+	Op_i64_reinterpret_f64: {name: "i64.reinterpret_f64", in: []ValueType{F64}, out: []ValueType{I64}},
+	Op_f32_reinterpret_i32: {name: "f32.reinterpret_i32", in: []ValueType{I32}, out: []ValueType{F32}},
+	Op_f64_reinterpret_i64: {name: "f64.reinterpret_i64", in: []ValueType{I64}, out: []ValueType{F64}},
+
+	// proposals
+	Op_try:                  {name: "try", imm: immBlockType, special: true, feature: FeatureExceptions},
+	Op_catch:                {name: "catch", imm: immTag, special: true, feature: FeatureExceptions},
+	Op_throw:                {name: "throw", imm: immTag, special: true, feature: FeatureExceptions},
+	Op_rethrow:              {name: "rethrow", imm: immLabel, special: true, feature: FeatureExceptions},
+	Op_throw_ref:            {name: "throw_ref", special: true, feature: FeatureExceptions},
+	Op_return_call:          {name: "return_call", imm: immFunc, special: true, feature: FeatureTailCall},
+	Op_return_call_indirect: {name: "return_call_indirect", imm: immCallIndirect, special: true, feature: FeatureTailCall},
+	Op_call_ref:             {name: "call_ref", imm: immType, special: true, feature: FeatureGC},
+	Op_return_call_ref:      {name: "return_call_ref", imm: immType, special: true, feature: FeatureGC},
+	Op_delegate:             {name: "delegate", imm: immLabel, special: true, feature: FeatureExceptions},
+	Op_catch_all:            {name: "catch_all", special: true, feature: FeatureExceptions},
+	Op_select_t:             {name: "select", imm: immSelectT, special: true, feature: FeatureReferenceTypes},
+	Op_try_table:            {name: "try_table", imm: immTryTable, special: true, feature: FeatureExceptions},
+	Op_table_get:            {name: "table.get", imm: immTable, special: true, feature: FeatureReferenceTypes},
+	Op_table_set:            {name: "table.set", imm: immTable, special: true, feature: FeatureReferenceTypes},
+	Op_i32_extend8_s:        {name: "i32.extend8_s", in: []ValueType{I32}, out: []ValueType{I32}, feature: FeatureSignExt},
+	Op_i32_extend16_s:       {name: "i32.extend16_s", in: []ValueType{I32}, out: []ValueType{I32}, feature: FeatureSignExt},
+	Op_i64_extend8_s:        {name: "i64.extend8_s", in: []ValueType{I64}, out: []ValueType{I64}, feature: FeatureSignExt},
+	Op_i64_extend16_s:       {name: "i64.extend16_s", in: []ValueType{I64}, out: []ValueType{I64}, feature: FeatureSignExt},
+	Op_i64_extend32_s:       {name: "i64.extend32_s", in: []ValueType{I64}, out: []ValueType{I64}, feature: FeatureSignExt},
+	Op_ref_null:             {name: "ref.null", imm: immRefType, special: true, feature: FeatureReferenceTypes},
+	Op_ref_is_null:          {name: "ref.is_null", special: true, feature: FeatureReferenceTypes},
+	Op_ref_func:             {name: "ref.func", imm: immFunc, special: true, feature: FeatureReferenceTypes},
+	Op_ref_eq:               {name: "ref.eq", special: true, feature: FeatureGC},
+	Op_ref_as_non_null:      {name: "ref.as_non_null", special: true, feature: FeatureGC},
+	Op_br_on_null:           {name: "br_on_null", imm: immLabel, special: true, feature: FeatureGC},
+	Op_br_on_non_null:       {name: "br_on_non_null", imm: immLabel, special: true, feature: FeatureGC},
+	Op_gc_prefix:            {name: "gc", imm: immPrefix, special: true, feature: FeatureGC},
+	Op_misc_prefix:          {name: "misc", imm: immPrefix, special: true},
+	Op_simd_prefix:          {name: "simd", imm: immPrefix, special: true, feature: FeatureSIMD},
+	Op_threads_prefix:       {name: "threads", imm: immPrefix, special: true, feature: FeatureThreads},
+}
+
+func (op Opcode) String() string {
+	if name := opcodes[op].name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("Opcode(0x%02x)", byte(op))
+}
+
+// naturalAlignment returns the base-2 logarithm of the number of bytes
+// accessed by the memory instruction op.
+func naturalAlignment(op Opcode) uint32 {
+	switch op {
+	case Op_i32_load8_s, Op_i32_load8_u, Op_i64_load8_s, Op_i64_load8_u,
+		Op_i32_store8, Op_i64_store8:
+		return 0
+	case Op_i32_load16_s, Op_i32_load16_u, Op_i64_load16_s, Op_i64_load16_u,
+		Op_i32_store16, Op_i64_store16:
+		return 1
+	case Op_i32_load, Op_f32_load, Op_i64_load32_s, Op_i64_load32_u,
+		Op_i32_store, Op_f32_store, Op_i64_store32:
+		return 2
+	}
+	return 3
+}
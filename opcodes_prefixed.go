@@ -0,0 +1,420 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "fmt"
+
+// Opcodes following Op_misc_prefix.
+const (
+	miscMemoryInit = 0x08
+	miscDataDrop   = 0x09
+	miscMemoryCopy = 0x0a
+	miscMemoryFill = 0x0b
+	miscTableInit  = 0x0c
+	miscElemDrop   = 0x0d
+	miscTableCopy  = 0x0e
+	miscTableGrow  = 0x0f
+	miscTableSize  = 0x10
+	miscTableFill  = 0x11
+)
+
+// miscOpcodes describes the opcodes following Op_misc_prefix.
+var miscOpcodes = [...]opcodeInfo{
+	0x00: {name: "i32.trunc_sat_f32_s", in: []ValueType{F32}, out: []ValueType{I32}, feature: FeatureSatTrunc},
+	0x01: {name: "i32.trunc_sat_f32_u", in: []ValueType{F32}, out: []ValueType{I32}, feature: FeatureSatTrunc},
+	0x02: {name: "i32.trunc_sat_f64_s", in: []ValueType{F64}, out: []ValueType{I32}, feature: FeatureSatTrunc},
+	0x03: {name: "i32.trunc_sat_f64_u", in: []ValueType{F64}, out: []ValueType{I32}, feature: FeatureSatTrunc},
+	0x04: {name: "i64.trunc_sat_f32_s", in: []ValueType{F32}, out: []ValueType{I64}, feature: FeatureSatTrunc},
+	0x05: {name: "i64.trunc_sat_f32_u", in: []ValueType{F32}, out: []ValueType{I64}, feature: FeatureSatTrunc},
+	0x06: {name: "i64.trunc_sat_f64_s", in: []ValueType{F64}, out: []ValueType{I64}, feature: FeatureSatTrunc},
+	0x07: {name: "i64.trunc_sat_f64_u", in: []ValueType{F64}, out: []ValueType{I64}, feature: FeatureSatTrunc},
+	0x08: {name: "memory.init", imm: immTwoIndices, special: true, feature: FeatureBulkMemory},
+	0x09: {name: "data.drop", imm: immIndex, special: true, feature: FeatureBulkMemory},
+	0x0a: {name: "memory.copy", imm: immTwoIndices, special: true, feature: FeatureBulkMemory},
+	0x0b: {name: "memory.fill", imm: immMemory, special: true, feature: FeatureBulkMemory},
+	0x0c: {name: "table.init", imm: immTwoIndices, special: true, feature: FeatureBulkMemory},
+	0x0d: {name: "elem.drop", imm: immIndex, special: true, feature: FeatureBulkMemory},
+	0x0e: {name: "table.copy", imm: immTwoIndices, special: true, feature: FeatureBulkMemory},
+	0x0f: {name: "table.grow", imm: immTable, special: true, feature: FeatureReferenceTypes},
+	0x10: {name: "table.size", imm: immTable, special: true, feature: FeatureReferenceTypes},
+	0x11: {name: "table.fill", imm: immTable, special: true, feature: FeatureReferenceTypes},
+}
+
+// Opcodes following Op_threads_prefix.
+const (
+	threadsNotify = 0x00
+	threadsFence  = 0x03
+)
+
+// threadsOpcodes describes the opcodes following Op_threads_prefix.
+var threadsOpcodes = [...]opcodeInfo{
+	0x00: {name: "memory.atomic.notify", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x01: {name: "memory.atomic.wait32", imm: immMemArg, in: []ValueType{I32, I32, I64}, out: []ValueType{I32}, align: 2},
+	0x02: {name: "memory.atomic.wait64", imm: immMemArg, in: []ValueType{I32, I64, I64}, out: []ValueType{I32}, align: 3},
+	0x03: {name: "atomic.fence", imm: immReserved},
+	0x10: {name: "i32.atomic.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}, align: 2},
+	0x11: {name: "i64.atomic.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}, align: 3},
+	0x12: {name: "i32.atomic.load8_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}, align: 0},
+	0x13: {name: "i32.atomic.load16_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I32}, align: 1},
+	0x14: {name: "i64.atomic.load8_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}, align: 0},
+	0x15: {name: "i64.atomic.load16_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}, align: 1},
+	0x16: {name: "i64.atomic.load32_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{I64}, align: 2},
+	0x17: {name: "i32.atomic.store", imm: immMemArg, in: []ValueType{I32, I32}, align: 2},
+	0x18: {name: "i64.atomic.store", imm: immMemArg, in: []ValueType{I32, I64}, align: 3},
+	0x19: {name: "i32.atomic.store8", imm: immMemArg, in: []ValueType{I32, I32}, align: 0},
+	0x1a: {name: "i32.atomic.store16", imm: immMemArg, in: []ValueType{I32, I32}, align: 1},
+	0x1b: {name: "i64.atomic.store8", imm: immMemArg, in: []ValueType{I32, I64}, align: 0},
+	0x1c: {name: "i64.atomic.store16", imm: immMemArg, in: []ValueType{I32, I64}, align: 1},
+	0x1d: {name: "i64.atomic.store32", imm: immMemArg, in: []ValueType{I32, I64}, align: 2},
+	0x1e: {name: "i32.atomic.rmw.add", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x1f: {name: "i64.atomic.rmw.add", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x20: {name: "i32.atomic.rmw8.add_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x21: {name: "i32.atomic.rmw16.add_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x22: {name: "i64.atomic.rmw8.add_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x23: {name: "i64.atomic.rmw16.add_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x24: {name: "i64.atomic.rmw32.add_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x25: {name: "i32.atomic.rmw.sub", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x26: {name: "i64.atomic.rmw.sub", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x27: {name: "i32.atomic.rmw8.sub_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x28: {name: "i32.atomic.rmw16.sub_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x29: {name: "i64.atomic.rmw8.sub_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x2a: {name: "i64.atomic.rmw16.sub_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x2b: {name: "i64.atomic.rmw32.sub_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x2c: {name: "i32.atomic.rmw.and", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x2d: {name: "i64.atomic.rmw.and", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x2e: {name: "i32.atomic.rmw8.and_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x2f: {name: "i32.atomic.rmw16.and_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x30: {name: "i64.atomic.rmw8.and_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x31: {name: "i64.atomic.rmw16.and_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x32: {name: "i64.atomic.rmw32.and_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x33: {name: "i32.atomic.rmw.or", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x34: {name: "i64.atomic.rmw.or", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x35: {name: "i32.atomic.rmw8.or_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x36: {name: "i32.atomic.rmw16.or_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x37: {name: "i64.atomic.rmw8.or_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x38: {name: "i64.atomic.rmw16.or_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x39: {name: "i64.atomic.rmw32.or_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x3a: {name: "i32.atomic.rmw.xor", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x3b: {name: "i64.atomic.rmw.xor", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x3c: {name: "i32.atomic.rmw8.xor_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x3d: {name: "i32.atomic.rmw16.xor_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x3e: {name: "i64.atomic.rmw8.xor_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x3f: {name: "i64.atomic.rmw16.xor_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x40: {name: "i64.atomic.rmw32.xor_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x41: {name: "i32.atomic.rmw.xchg", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 2},
+	0x42: {name: "i64.atomic.rmw.xchg", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 3},
+	0x43: {name: "i32.atomic.rmw8.xchg_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 0},
+	0x44: {name: "i32.atomic.rmw16.xchg_u", imm: immMemArg, in: []ValueType{I32, I32}, out: []ValueType{I32}, align: 1},
+	0x45: {name: "i64.atomic.rmw8.xchg_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 0},
+	0x46: {name: "i64.atomic.rmw16.xchg_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 1},
+	0x47: {name: "i64.atomic.rmw32.xchg_u", imm: immMemArg, in: []ValueType{I32, I64}, out: []ValueType{I64}, align: 2},
+	0x48: {name: "i32.atomic.rmw.cmpxchg", imm: immMemArg, in: []ValueType{I32, I32, I32}, out: []ValueType{I32}, align: 2},
+	0x49: {name: "i64.atomic.rmw.cmpxchg", imm: immMemArg, in: []ValueType{I32, I64, I64}, out: []ValueType{I64}, align: 3},
+	0x4a: {name: "i32.atomic.rmw8.cmpxchg_u", imm: immMemArg, in: []ValueType{I32, I32, I32}, out: []ValueType{I32}, align: 0},
+	0x4b: {name: "i32.atomic.rmw16.cmpxchg_u", imm: immMemArg, in: []ValueType{I32, I32, I32}, out: []ValueType{I32}, align: 1},
+	0x4c: {name: "i64.atomic.rmw8.cmpxchg_u", imm: immMemArg, in: []ValueType{I32, I64, I64}, out: []ValueType{I64}, align: 0},
+	0x4d: {name: "i64.atomic.rmw16.cmpxchg_u", imm: immMemArg, in: []ValueType{I32, I64, I64}, out: []ValueType{I64}, align: 1},
+	0x4e: {name: "i64.atomic.rmw32.cmpxchg_u", imm: immMemArg, in: []ValueType{I32, I64, I64}, out: []ValueType{I64}, align: 2},
+}
+
+// Opcodes following Op_simd_prefix.
+const (
+	simdConst   = 0x0c
+	simdShuffle = 0x0d
+)
+
+// simdOpcodes describes the opcodes following Op_simd_prefix.
+var simdOpcodes = [256]opcodeInfo{
+	0x00: {name: "v128.load", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 4},
+	0x01: {name: "v128.load8x8_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x02: {name: "v128.load8x8_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x03: {name: "v128.load16x4_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x04: {name: "v128.load16x4_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x05: {name: "v128.load32x2_s", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x06: {name: "v128.load32x2_u", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x07: {name: "v128.load8_splat", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 0},
+	0x08: {name: "v128.load16_splat", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 1},
+	0x09: {name: "v128.load32_splat", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 2},
+	0x0a: {name: "v128.load64_splat", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x0b: {name: "v128.store", imm: immMemArg, in: []ValueType{I32, V128}, align: 4},
+	0x0c: {name: "v128.const", imm: immV128, out: []ValueType{V128}},
+	0x0d: {name: "i8x16.shuffle", imm: immV128, in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x0e: {name: "i8x16.swizzle", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x0f: {name: "i8x16.splat", in: []ValueType{I32}, out: []ValueType{V128}},
+	0x10: {name: "i16x8.splat", in: []ValueType{I32}, out: []ValueType{V128}},
+	0x11: {name: "i32x4.splat", in: []ValueType{I32}, out: []ValueType{V128}},
+	0x12: {name: "i64x2.splat", in: []ValueType{I64}, out: []ValueType{V128}},
+	0x13: {name: "f32x4.splat", in: []ValueType{F32}, out: []ValueType{V128}},
+	0x14: {name: "f64x2.splat", in: []ValueType{F64}, out: []ValueType{V128}},
+	0x15: {name: "i8x16.extract_lane_s", imm: immLane, in: []ValueType{V128}, out: []ValueType{I32}},
+	0x16: {name: "i8x16.extract_lane_u", imm: immLane, in: []ValueType{V128}, out: []ValueType{I32}},
+	0x17: {name: "i8x16.replace_lane", imm: immLane, in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x18: {name: "i16x8.extract_lane_s", imm: immLane, in: []ValueType{V128}, out: []ValueType{I32}},
+	0x19: {name: "i16x8.extract_lane_u", imm: immLane, in: []ValueType{V128}, out: []ValueType{I32}},
+	0x1a: {name: "i16x8.replace_lane", imm: immLane, in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x1b: {name: "i32x4.extract_lane", imm: immLane, in: []ValueType{V128}, out: []ValueType{I32}},
+	0x1c: {name: "i32x4.replace_lane", imm: immLane, in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x1d: {name: "i64x2.extract_lane", imm: immLane, in: []ValueType{V128}, out: []ValueType{I64}},
+	0x1e: {name: "i64x2.replace_lane", imm: immLane, in: []ValueType{V128, I64}, out: []ValueType{V128}},
+	0x1f: {name: "f32x4.extract_lane", imm: immLane, in: []ValueType{V128}, out: []ValueType{F32}},
+	0x20: {name: "f32x4.replace_lane", imm: immLane, in: []ValueType{V128, F32}, out: []ValueType{V128}},
+	0x21: {name: "f64x2.extract_lane", imm: immLane, in: []ValueType{V128}, out: []ValueType{F64}},
+	0x22: {name: "f64x2.replace_lane", imm: immLane, in: []ValueType{V128, F64}, out: []ValueType{V128}},
+	0x23: {name: "i8x16.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x24: {name: "i8x16.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x25: {name: "i8x16.lt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x26: {name: "i8x16.lt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x27: {name: "i8x16.gt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x28: {name: "i8x16.gt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x29: {name: "i8x16.le_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2a: {name: "i8x16.le_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2b: {name: "i8x16.ge_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2c: {name: "i8x16.ge_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2d: {name: "i16x8.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2e: {name: "i16x8.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x2f: {name: "i16x8.lt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x30: {name: "i16x8.lt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x31: {name: "i16x8.gt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x32: {name: "i16x8.gt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x33: {name: "i16x8.le_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x34: {name: "i16x8.le_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x35: {name: "i16x8.ge_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x36: {name: "i16x8.ge_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x37: {name: "i32x4.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x38: {name: "i32x4.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x39: {name: "i32x4.lt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3a: {name: "i32x4.lt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3b: {name: "i32x4.gt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3c: {name: "i32x4.gt_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3d: {name: "i32x4.le_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3e: {name: "i32x4.le_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x3f: {name: "i32x4.ge_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x40: {name: "i32x4.ge_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x41: {name: "f32x4.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x42: {name: "f32x4.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x43: {name: "f32x4.lt", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x44: {name: "f32x4.gt", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x45: {name: "f32x4.le", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x46: {name: "f32x4.ge", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x47: {name: "f64x2.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x48: {name: "f64x2.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x49: {name: "f64x2.lt", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x4a: {name: "f64x2.gt", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x4b: {name: "f64x2.le", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x4c: {name: "f64x2.ge", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x4d: {name: "v128.not", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x4e: {name: "v128.and", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x4f: {name: "v128.andnot", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x50: {name: "v128.or", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x51: {name: "v128.xor", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x52: {name: "v128.bitselect", in: []ValueType{V128, V128, V128}, out: []ValueType{V128}},
+	0x53: {name: "v128.any_true", in: []ValueType{V128}, out: []ValueType{I32}},
+	0x54: {name: "v128.load8_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, out: []ValueType{V128}, align: 0},
+	0x55: {name: "v128.load16_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, out: []ValueType{V128}, align: 1},
+	0x56: {name: "v128.load32_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, out: []ValueType{V128}, align: 2},
+	0x57: {name: "v128.load64_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, out: []ValueType{V128}, align: 3},
+	0x58: {name: "v128.store8_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, align: 0},
+	0x59: {name: "v128.store16_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, align: 1},
+	0x5a: {name: "v128.store32_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, align: 2},
+	0x5b: {name: "v128.store64_lane", imm: immMemArgLane, in: []ValueType{I32, V128}, align: 3},
+	0x5c: {name: "v128.load32_zero", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 2},
+	0x5d: {name: "v128.load64_zero", imm: immMemArg, in: []ValueType{I32}, out: []ValueType{V128}, align: 3},
+	0x5e: {name: "f32x4.demote_f64x2_zero", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x5f: {name: "f64x2.promote_low_f32x4", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x60: {name: "i8x16.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x61: {name: "i8x16.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x62: {name: "i8x16.popcnt", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x63: {name: "i8x16.all_true", in: []ValueType{V128}, out: []ValueType{I32}},
+	0x64: {name: "i8x16.bitmask", in: []ValueType{V128}, out: []ValueType{I32}},
+	0x65: {name: "i8x16.narrow_i16x8_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x66: {name: "i8x16.narrow_i16x8_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x67: {name: "f32x4.ceil", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x68: {name: "f32x4.floor", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x69: {name: "f32x4.trunc", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x6a: {name: "f32x4.nearest", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x6b: {name: "i8x16.shl", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x6c: {name: "i8x16.shr_s", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x6d: {name: "i8x16.shr_u", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x6e: {name: "i8x16.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x6f: {name: "i8x16.add_sat_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x70: {name: "i8x16.add_sat_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x71: {name: "i8x16.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x72: {name: "i8x16.sub_sat_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x73: {name: "i8x16.sub_sat_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x74: {name: "f64x2.ceil", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x75: {name: "f64x2.floor", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x76: {name: "i8x16.min_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x77: {name: "i8x16.min_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x78: {name: "i8x16.max_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x79: {name: "i8x16.max_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x7a: {name: "f64x2.trunc", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x7b: {name: "i8x16.avgr_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x7c: {name: "i16x8.extadd_pairwise_i8x16_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x7d: {name: "i16x8.extadd_pairwise_i8x16_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x7e: {name: "i32x4.extadd_pairwise_i16x8_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x7f: {name: "i32x4.extadd_pairwise_i16x8_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x80: {name: "i16x8.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x81: {name: "i16x8.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x82: {name: "i16x8.q15mulr_sat_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x83: {name: "i16x8.all_true", in: []ValueType{V128}, out: []ValueType{I32}},
+	0x84: {name: "i16x8.bitmask", in: []ValueType{V128}, out: []ValueType{I32}},
+	0x85: {name: "i16x8.narrow_i32x4_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x86: {name: "i16x8.narrow_i32x4_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x87: {name: "i16x8.extend_low_i8x16_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x88: {name: "i16x8.extend_high_i8x16_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x89: {name: "i16x8.extend_low_i8x16_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x8a: {name: "i16x8.extend_high_i8x16_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x8b: {name: "i16x8.shl", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x8c: {name: "i16x8.shr_s", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x8d: {name: "i16x8.shr_u", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0x8e: {name: "i16x8.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x8f: {name: "i16x8.add_sat_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x90: {name: "i16x8.add_sat_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x91: {name: "i16x8.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x92: {name: "i16x8.sub_sat_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x93: {name: "i16x8.sub_sat_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x94: {name: "f64x2.nearest", in: []ValueType{V128}, out: []ValueType{V128}},
+	0x95: {name: "i16x8.mul", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x96: {name: "i16x8.min_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x97: {name: "i16x8.min_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x98: {name: "i16x8.max_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x99: {name: "i16x8.max_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x9b: {name: "i16x8.avgr_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x9c: {name: "i16x8.extmul_low_i8x16_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x9d: {name: "i16x8.extmul_high_i8x16_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x9e: {name: "i16x8.extmul_low_i8x16_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0x9f: {name: "i16x8.extmul_high_i8x16_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xa0: {name: "i32x4.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xa1: {name: "i32x4.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xa3: {name: "i32x4.all_true", in: []ValueType{V128}, out: []ValueType{I32}},
+	0xa4: {name: "i32x4.bitmask", in: []ValueType{V128}, out: []ValueType{I32}},
+	0xa7: {name: "i32x4.extend_low_i16x8_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xa8: {name: "i32x4.extend_high_i16x8_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xa9: {name: "i32x4.extend_low_i16x8_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xaa: {name: "i32x4.extend_high_i16x8_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xab: {name: "i32x4.shl", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xac: {name: "i32x4.shr_s", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xad: {name: "i32x4.shr_u", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xae: {name: "i32x4.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb1: {name: "i32x4.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb5: {name: "i32x4.mul", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb6: {name: "i32x4.min_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb7: {name: "i32x4.min_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb8: {name: "i32x4.max_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xb9: {name: "i32x4.max_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xba: {name: "i32x4.dot_i16x8_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xbc: {name: "i32x4.extmul_low_i16x8_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xbd: {name: "i32x4.extmul_high_i16x8_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xbe: {name: "i32x4.extmul_low_i16x8_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xbf: {name: "i32x4.extmul_high_i16x8_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xc0: {name: "i64x2.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xc1: {name: "i64x2.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xc3: {name: "i64x2.all_true", in: []ValueType{V128}, out: []ValueType{I32}},
+	0xc4: {name: "i64x2.bitmask", in: []ValueType{V128}, out: []ValueType{I32}},
+	0xc7: {name: "i64x2.extend_low_i32x4_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xc8: {name: "i64x2.extend_high_i32x4_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xc9: {name: "i64x2.extend_low_i32x4_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xca: {name: "i64x2.extend_high_i32x4_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xcb: {name: "i64x2.shl", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xcc: {name: "i64x2.shr_s", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xcd: {name: "i64x2.shr_u", in: []ValueType{V128, I32}, out: []ValueType{V128}},
+	0xce: {name: "i64x2.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd1: {name: "i64x2.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd5: {name: "i64x2.mul", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd6: {name: "i64x2.eq", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd7: {name: "i64x2.ne", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd8: {name: "i64x2.lt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xd9: {name: "i64x2.gt_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xda: {name: "i64x2.le_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xdb: {name: "i64x2.ge_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xdc: {name: "i64x2.extmul_low_i32x4_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xdd: {name: "i64x2.extmul_high_i32x4_s", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xde: {name: "i64x2.extmul_low_i32x4_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xdf: {name: "i64x2.extmul_high_i32x4_u", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe0: {name: "f32x4.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xe1: {name: "f32x4.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xe3: {name: "f32x4.sqrt", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xe4: {name: "f32x4.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe5: {name: "f32x4.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe6: {name: "f32x4.mul", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe7: {name: "f32x4.div", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe8: {name: "f32x4.min", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xe9: {name: "f32x4.max", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xea: {name: "f32x4.pmin", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xeb: {name: "f32x4.pmax", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xec: {name: "f64x2.abs", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xed: {name: "f64x2.neg", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xef: {name: "f64x2.sqrt", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xf0: {name: "f64x2.add", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf1: {name: "f64x2.sub", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf2: {name: "f64x2.mul", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf3: {name: "f64x2.div", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf4: {name: "f64x2.min", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf5: {name: "f64x2.max", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf6: {name: "f64x2.pmin", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf7: {name: "f64x2.pmax", in: []ValueType{V128, V128}, out: []ValueType{V128}},
+	0xf8: {name: "i32x4.trunc_sat_f32x4_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xf9: {name: "i32x4.trunc_sat_f32x4_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xfa: {name: "f32x4.convert_i32x4_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xfb: {name: "f32x4.convert_i32x4_u", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xfc: {name: "i32x4.trunc_sat_f64x2_s_zero", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xfd: {name: "i32x4.trunc_sat_f64x2_u_zero", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xfe: {name: "f64x2.convert_low_i32x4_s", in: []ValueType{V128}, out: []ValueType{V128}},
+	0xff: {name: "f64x2.convert_low_i32x4_u", in: []ValueType{V128}, out: []ValueType{V128}},
+}
+
+func init() {
+	for i := range threadsOpcodes {
+		threadsOpcodes[i].feature = FeatureThreads
+	}
+	for i := range simdOpcodes {
+		simdOpcodes[i].feature = FeatureSIMD
+	}
+}
+
+// prefixedOpcodes returns the descriptions of the opcodes following the
+// provided prefix.
+func prefixedOpcodes(prefix Opcode) []opcodeInfo {
+	switch prefix {
+	case Op_misc_prefix:
+		return miscOpcodes[:]
+	case Op_simd_prefix:
+		return simdOpcodes[:]
+	case Op_threads_prefix:
+		return threadsOpcodes[:]
+	}
+	return nil
+}
+
+// info returns the description of the opcode of the instruction.
+func (in *instruction) info() *opcodeInfo {
+	if opcodes[in.op].imm != immPrefix {
+		return &opcodes[in.op]
+	}
+	return &prefixedOpcodes(in.op)[in.sub]
+}
+
+// name returns the name of the instruction in the text format.
+func (in *instruction) name() string {
+	if opcodes[in.op].imm != immPrefix {
+		return in.op.String()
+	}
+	return in.info().name
+}
+
+// naturalAlignment returns the base-2 logarithm of the number of bytes
+// accessed by the memory instruction.
+func (in *instruction) naturalAlignment() uint32 {
+	if opcodes[in.op].imm != immPrefix {
+		return naturalAlignment(in.op)
+	}
+	return in.info().align
+}
+
+// prefixedString returns the hexadecimal representation of a prefixed
+// opcode.
+func prefixedString(prefix Opcode, sub uint32) string {
+	return fmt.Sprintf("0x%02x 0x%02x", byte(prefix), sub)
+}
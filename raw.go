@@ -0,0 +1,163 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// DecodeOptions configures the decoding of a module.
+type DecodeOptions struct {
+	// KeepRaw retains the original encoding of the sections and function
+	// bodies of the module, including non-canonical (padded) LEB128
+	// integers.
+	// Encoding such a module re-emits untouched sections and function
+	// bodies verbatim and only re-serializes the modified ones, so that
+	// the output is byte-identical to the input apart from the edits.
+	KeepRaw bool
+
+	// Features is the set of features the module may use.
+	// Decoding fails with a *FeatureError if the types, sections or
+	// segments of the module use a disabled feature.
+	Features Features
+}
+
+// DecodeWithOptions decodes a wasm module from r, using the provided
+// options.
+func DecodeWithOptions(r io.Reader, opts DecodeOptions) (Module, error) {
+	dec := decoder{r: r, features: opts.Features}
+	if opts.KeepRaw {
+		dec.raw = newRawEncoding()
+	}
+	m, err := dec.readModule()
+	m.raw = dec.raw
+	return m, err
+}
+
+type rawKey [sha256.Size]byte
+
+// rawEncoding records the original encoding of the parts of a decoded
+// module whose canonical encoding differs from it.
+// Parts are indexed by their canonical encoding, so the encoder can
+// tell whether they were modified since the module was decoded.
+type rawEncoding struct {
+	sections map[rawKey][][]byte // id, size and payload of sections
+	bodies   map[rawKey][][]byte // size and content of function bodies
+	widths   map[SectionID]int   // width of padded section sizes
+	width    int                 // width of padded function body sizes
+}
+
+func newRawEncoding() *rawEncoding {
+	return &rawEncoding{
+		sections: make(map[rawKey][][]byte),
+		bodies:   make(map[rawKey][][]byte),
+		widths:   make(map[SectionID]int),
+	}
+}
+
+func newRawKey(id SectionID, canonical []byte) rawKey {
+	h := sha256.New()
+	h.Write([]byte{byte(id)})
+	h.Write(canonical)
+	var k rawKey
+	h.Sum(k[:0])
+	return k
+}
+
+// addSection records the original encoding of a decoded section.
+func (raw *rawEncoding) addSection(version uint32, sec Section, size, payload []byte) {
+	var (
+		id  = sec.ID()
+		buf = new(bytes.Buffer)
+		enc = encoder{version: version}
+	)
+	enc.writeSectionPayload(buf, sec)
+	if enc.err != nil {
+		return
+	}
+	canonical := buf.Bytes()
+	width := len(appendUvarint(nil, uint64(len(canonical))))
+	if len(size) > width && id != UnknownID {
+		raw.widths[id] = len(size)
+	}
+	if len(size) == width && bytes.Equal(canonical, payload) {
+		return
+	}
+
+	orig := make([]byte, 0, 1+len(size)+len(payload))
+	orig = append(orig, byte(id))
+	orig = append(orig, size...)
+	orig = append(orig, payload...)
+	k := newRawKey(id, canonical)
+	raw.sections[k] = append(raw.sections[k], orig)
+}
+
+// addBody records the original encoding of a decoded function body.
+func (raw *rawEncoding) addBody(version uint32, fb FunctionBody, size, body []byte) {
+	var (
+		buf = new(bytes.Buffer)
+		enc = encoder{version: version}
+	)
+	enc.writeFunctionBody(buf, fb)
+	if enc.err != nil {
+		return
+	}
+	canonical := appendUvarint(nil, uint64(buf.Len()))
+	if len(size) > len(canonical) {
+		raw.width = len(size)
+	}
+	if len(size) == len(canonical) && bytes.Equal(buf.Bytes(), body) {
+		return
+	}
+	canonical = append(canonical, buf.Bytes()...)
+	orig := make([]byte, 0, len(size)+len(body))
+	orig = append(orig, size...)
+	orig = append(orig, body...)
+	k := newRawKey(CodeID, canonical)
+	raw.bodies[k] = append(raw.bodies[k], orig)
+}
+
+// rawUsage keeps track of the original encodings already emitted by an
+// encoder, so that identical parts are each given their own encoding.
+type rawUsage struct {
+	raw      *rawEncoding
+	sections map[rawKey]int
+	bodies   map[rawKey]int
+}
+
+func newRawUsage(raw *rawEncoding) *rawUsage {
+	return &rawUsage{
+		raw:      raw,
+		sections: make(map[rawKey]int),
+		bodies:   make(map[rawKey]int),
+	}
+}
+
+// section returns the original encoding of the section with the provided
+// canonical payload, if any.
+func (u *rawUsage) section(id SectionID, canonical []byte) ([]byte, bool) {
+	return u.lookup(u.raw.sections, u.sections, newRawKey(id, canonical))
+}
+
+// body returns the original encoding of the function body with the
+// provided canonical encoding (size and content), if any.
+func (u *rawUsage) body(canonical []byte) ([]byte, bool) {
+	return u.lookup(u.raw.bodies, u.bodies, newRawKey(CodeID, canonical))
+}
+
+func (u *rawUsage) lookup(db map[rawKey][][]byte, used map[rawKey]int, k rawKey) ([]byte, bool) {
+	origs := db[k]
+	if len(origs) == 0 {
+		return nil, false
+	}
+	i := used[k]
+	used[k]++
+	if i >= len(origs) {
+		i = len(origs) - 1
+	}
+	return origs[i], true
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestKeepRawEdit(t *testing.T) {
+	raw, err := os.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := DecodeWithOptions(bytes.NewReader(raw), DecodeOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rename the first export.
+	var (
+		iexp int
+		exp  ExportSection
+	)
+	for i, s := range mod.Sections {
+		if s, ok := s.(ExportSection); ok {
+			iexp, exp = i, s
+		}
+	}
+	exports := append([]ExportEntry(nil), exp.Exports...)
+	exports[0].Field = "_renamed_" + exports[0].Field
+	mod.Sections[iexp] = ExportSection{Exports: exports}
+
+	out, err := Encode(&mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all sections but the export one must be identical.
+	beg, end := sectionBounds(t, raw, ExportID)
+	obeg, oend := sectionBounds(t, out, ExportID)
+	if beg != obeg {
+		t.Fatalf("export section moved: got=%d, want=%d", obeg, beg)
+	}
+	if !bytes.Equal(raw[:beg], out[:obeg]) {
+		t.Fatalf("sections before export section differ")
+	}
+	if !bytes.Equal(raw[end:], out[oend:]) {
+		t.Fatalf("sections after export section differ")
+	}
+	if got, want := oend-obeg, end-beg+len("_renamed_"); got != want {
+		t.Fatalf("invalid export section size: got=%d, want=%d", got, want)
+	}
+
+	got, err := Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := got.Sections[iexp].(ExportSection).Exports[0].Field; name != exports[0].Field {
+		t.Fatalf("invalid export name: got=%q, want=%q", name, exports[0].Field)
+	}
+}
+
+func TestKeepRawEditBody(t *testing.T) {
+	raw, err := os.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := DecodeWithOptions(bytes.NewReader(raw), DecodeOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var code CodeSection
+	for i, s := range mod.Sections {
+		if s, ok := s.(CodeSection); ok {
+			code = CodeSection{Bodies: append([]FunctionBody(nil), s.Bodies...)}
+			mod.Sections[i] = code
+		}
+	}
+	// prepend a nop to the last function.
+	last := &code.Bodies[len(code.Bodies)-1]
+	last.Code.Code = append([]byte{Op_nop}, last.Code.Code...)
+
+	out, err := Encode(&mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beg, _ := sectionBounds(t, raw, CodeID)
+	if !bytes.Equal(raw[:beg], out[:beg]) {
+		t.Fatalf("sections before code section differ")
+	}
+	// padded section size is kept.
+	if got, want := out[beg+1:beg+6], raw[beg+1:beg+6]; got[4] != 0 || got[3]&0x80 == 0 {
+		t.Fatalf("section size not padded: got=%x, orig=%x", got, want)
+	}
+	if len(out) != len(raw)+1 {
+		t.Fatalf("invalid module size: got=%d, want=%d", len(out), len(raw)+1)
+	}
+}
+
+// sectionBounds returns the offsets of the beginning and end of the
+// section with the provided id.
+func sectionBounds(t *testing.T, raw []byte, id SectionID) (int, int) {
+	t.Helper()
+	r := bytes.NewReader(raw[8:])
+	for r.Len() > 0 {
+		beg := len(raw) - r.Len()
+		c, _ := r.ReadByte()
+		sz, _, err := uvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Seek(int64(sz), 1)
+		if SectionID(c) == id {
+			return beg, len(raw) - r.Len()
+		}
+	}
+	t.Fatalf("no section with id=%d", id)
+	return 0, 0
+}
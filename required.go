@@ -0,0 +1,201 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+// RequiredFeatures returns the set of proposals used by the module, i.e.
+// the features an engine must support to run it.
+//
+// The module is not validated: the returned set is only meaningful for
+// modules that Validate accepts with some set of features.
+func (m *Module) RequiredFeatures() Features {
+	var (
+		f       Features
+		ntables int
+		nmems   int
+	)
+	valueTypes := func(vts []ValueType) {
+		for _, vt := range vts {
+			f |= vt.feature()
+		}
+	}
+	tableType := func(tt TableType) {
+		ntables++
+		if et := ValueType(tt.ElemType); et != FuncRef {
+			f |= et.feature()
+		}
+	}
+	memoryType := func(mt MemoryType) {
+		nmems++
+		if mt.Limits.Flags&LimitsShared != 0 {
+			f |= FeatureThreads
+		}
+		if mt.Limits.Flags&LimitsI64 != 0 {
+			f |= FeatureMemory64
+		}
+	}
+
+	for _, sec := range m.Sections {
+		switch s := sec.(type) {
+		case TypeSection:
+			for _, ft := range s.Types {
+				valueTypes(ft.Params)
+				valueTypes(ft.Results)
+				if len(ft.Results) > 1 {
+					f |= FeatureMultiValue
+				}
+			}
+		case ImportSection:
+			for _, e := range s.Imports {
+				switch t := e.Type.(type) {
+				case TableType:
+					tableType(t)
+				case MemoryType:
+					memoryType(t)
+				case GlobalType:
+					f |= t.ContentType.feature()
+				case TagType:
+					f |= FeatureExceptions
+				}
+			}
+		case TableSection:
+			for _, tt := range s.Tables {
+				tableType(tt)
+			}
+		case MemorySection:
+			for _, mt := range s.Memories {
+				memoryType(mt)
+			}
+		case GlobalSection:
+			for _, g := range s.Globals {
+				f |= g.Type.ContentType.feature()
+				f |= constExprFeatures(g.Init)
+			}
+		case ExportSection:
+			for _, e := range s.Exports {
+				if e.Kind == TagKind {
+					f |= FeatureExceptions
+				}
+			}
+		case ElementSection:
+			for _, es := range s.Elements {
+				switch {
+				case es.Flags&ElemExprs != 0 || es.isDeclarative():
+					f |= FeatureReferenceTypes
+				case es.Flags != 0 || es.isActive() && es.Index != 0:
+					// the index of tables other than 0 is explicit.
+					f |= FeatureBulkMemory
+				}
+				if et := es.elemType(); et != FuncRef {
+					f |= et.feature()
+				}
+				if es.isActive() {
+					f |= constExprFeatures(es.Offset)
+				}
+				for _, expr := range es.Exprs {
+					f |= constExprFeatures(expr)
+				}
+			}
+		case DataCountSection:
+			f |= FeatureBulkMemory
+		case DataSection:
+			for _, ds := range s.Segments {
+				if ds.Flags != 0 || ds.Index != 0 {
+					// passive segments, and explicit memory indices.
+					f |= FeatureBulkMemory
+				}
+				if ds.Flags&DataPassive == 0 {
+					f |= constExprFeatures(ds.Offset)
+				}
+			}
+		case TagSection:
+			f |= FeatureExceptions
+		case CodeSection:
+			for _, fb := range s.Bodies {
+				for _, le := range fb.Locals {
+					f |= le.Type.feature()
+				}
+				f |= codeFeatures(fb.Code.Code)
+			}
+		}
+	}
+	if ntables > 1 {
+		f |= FeatureReferenceTypes
+	}
+	if nmems > 1 {
+		f |= FeatureMultiMemory
+	}
+	return f
+}
+
+// codeFeatures returns the features used by the instructions of code.
+func codeFeatures(code []byte) Features {
+	var (
+		f  Features
+		cr = newCodeReader(code)
+		in instruction
+	)
+	for cr.next(&in) {
+		info := in.info()
+		f |= info.feature
+		switch info.imm {
+		case immBlockType:
+			switch {
+			case in.block >= 0:
+				f |= FeatureMultiValue
+			case in.block != blockTypeEmpty:
+				f |= ValueType(in.block & 0x7f).feature()
+			}
+		case immSelectT:
+			f |= in.vt.feature()
+		case immMemArg, immMemArgLane:
+			if in.idx&memArgExplicit != 0 && in.mem != 0 {
+				f |= FeatureMultiMemory
+			}
+		}
+		switch in.op {
+		case Op_call_indirect, Op_return_call_indirect:
+			if in.idx2 != 0 {
+				f |= FeatureReferenceTypes
+			}
+		case Op_current_memory, Op_grow_memory:
+			if in.idx != 0 {
+				f |= FeatureMultiMemory
+			}
+		case Op_misc_prefix:
+			var mem bool
+			switch in.sub {
+			case miscMemoryInit:
+				mem = in.idx2 != 0
+			case miscMemoryCopy:
+				mem = in.idx != 0 || in.idx2 != 0
+			case miscMemoryFill:
+				mem = in.idx != 0
+			}
+			if mem {
+				f |= FeatureMultiMemory
+			}
+		}
+	}
+	f |= cr.unsupported
+	return f
+}
+
+// constExprFeatures returns the features used by a constant expression.
+func constExprFeatures(ie InitExpr) Features {
+	var (
+		f  Features
+		cr = newCodeReader(ie.Expr)
+		in instruction
+	)
+	for cr.next(&in) {
+		switch in.op {
+		case Op_i32_add, Op_i32_sub, Op_i32_mul, Op_i64_add, Op_i64_sub, Op_i64_mul:
+			f |= FeatureExtendedConst
+		default:
+			f |= in.info().feature
+		}
+	}
+	return f
+}
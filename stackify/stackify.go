@@ -0,0 +1,459 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package stackify turns control-flow graphs of basic blocks into the
+// structured block, loop and if constructs of WebAssembly.
+//
+// Reducible graphs are translated following the algorithm of Ramsey,
+// "Beyond Relooper" (ICFP 2022), driven by the dominator tree of the graph.
+// Irreducible graphs fall back to a dispatch loop switching on a local
+// variable holding the index of the next block to execute.
+package stackify
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sbinet/wasm"
+)
+
+// Graph is a control-flow graph of basic blocks.
+// The first block added to the graph is its entry.
+type Graph struct {
+	blocks []*Block
+}
+
+// New returns a new empty control-flow graph.
+func New() *Graph {
+	return &Graph{}
+}
+
+// termKind is the kind of instruction terminating a basic block.
+type termKind int
+
+const (
+	termReturn termKind = iota
+	termUnreachable
+	termJump
+	termBranch
+	termSwitch
+)
+
+// Block is a basic block of a control-flow graph.
+//
+// The code of a block must leave the operand stack empty, except for the
+// operands of its terminator: the i32 condition of Branch, the i32 index
+// of Switch and the results of the function for Return.
+// Values flowing between blocks must be passed through local variables.
+type Block struct {
+	g    *Graph
+	id   int
+	code func(e *wasm.Emitter)
+
+	term  termKind
+	succs []*Block // successors; the default target comes last for Switch
+}
+
+// Block adds a basic block to the graph, whose straight-line code is
+// emitted by code. The block returns from the function unless another
+// terminator is set.
+func (g *Graph) Block(code func(e *wasm.Emitter)) *Block {
+	b := &Block{g: g, id: len(g.blocks), code: code}
+	g.blocks = append(g.blocks, b)
+	return b
+}
+
+// Return terminates the block with a return from the function.
+func (b *Block) Return() {
+	b.term = termReturn
+	b.succs = nil
+}
+
+// Unreachable terminates the block with a trap.
+func (b *Block) Unreachable() {
+	b.term = termUnreachable
+	b.succs = nil
+}
+
+// Jump terminates the block with an unconditional jump to dst.
+func (b *Block) Jump(dst *Block) {
+	b.term = termJump
+	b.succs = []*Block{dst}
+}
+
+// Branch terminates the block with a jump to then if the i32 condition
+// left on the stack is not zero, and to els otherwise.
+func (b *Block) Branch(then, els *Block) {
+	b.term = termBranch
+	b.succs = []*Block{then, els}
+}
+
+// Switch terminates the block with a jump to targets[i], where i is the
+// i32 index left on the stack, or to def if i is out of range.
+func (b *Block) Switch(targets []*Block, def *Block) {
+	b.term = termSwitch
+	b.succs = append(append([]*Block{}, targets...), def)
+}
+
+// analysis holds the control-flow analysis of the blocks reachable from
+// the entry of a graph.
+type analysis struct {
+	order []*Block       // blocks in reverse postorder
+	rpo   map[*Block]int // reverse postorder number of each block
+	idom  []int          // immediate dominator of each block, by rpo number
+	preds [][]int        // distinct forward predecessors of each block, by rpo number
+	loop  []bool         // whether a block is the target of a back edge
+	kids  [][]*Block     // children of each block in the dominator tree
+}
+
+func (g *Graph) analyze() (*analysis, error) {
+	if len(g.blocks) == 0 {
+		return nil, fmt.Errorf("stackify: empty graph")
+	}
+	for _, b := range g.blocks {
+		for _, s := range b.succs {
+			if s == nil || s.g != g {
+				return nil, fmt.Errorf("stackify: block %d: successor not in graph", b.id)
+			}
+		}
+	}
+
+	a := &analysis{rpo: make(map[*Block]int)}
+	seen := make([]bool, len(g.blocks))
+	var post []*Block
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b.id] = true
+		for _, s := range b.succs {
+			if !seen[s.id] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(g.blocks[0])
+	for i := len(post) - 1; i >= 0; i-- {
+		a.rpo[post[i]] = len(a.order)
+		a.order = append(a.order, post[i])
+	}
+
+	n := len(a.order)
+	a.preds = make([][]int, n)
+	a.loop = make([]bool, n)
+	allPreds := make([][]int, n)
+	for i, b := range a.order {
+		for _, s := range distinct(b.succs) {
+			j := a.rpo[s]
+			allPreds[j] = append(allPreds[j], i)
+			if j > i {
+				a.preds[j] = append(a.preds[j], i)
+			} else {
+				a.loop[j] = true
+			}
+		}
+	}
+
+	// dominators, following Cooper, Harvey and Kennedy,
+	// "A Simple, Fast Dominance Algorithm".
+	a.idom = make([]int, n)
+	for i := range a.idom {
+		a.idom[i] = -1
+	}
+	a.idom[0] = 0
+	intersect := func(i, j int) int {
+		for i != j {
+			for i > j {
+				i = a.idom[i]
+			}
+			for j > i {
+				j = a.idom[j]
+			}
+		}
+		return i
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < n; i++ {
+			idom := -1
+			for _, p := range allPreds[i] {
+				switch {
+				case a.idom[p] < 0:
+					// not processed yet.
+				case idom < 0:
+					idom = p
+				default:
+					idom = intersect(p, idom)
+				}
+			}
+			if a.idom[i] != idom {
+				a.idom[i] = idom
+				changed = true
+			}
+		}
+	}
+
+	a.kids = make([][]*Block, n)
+	for i := 1; i < n; i++ {
+		p := a.idom[i]
+		a.kids[p] = append(a.kids[p], a.order[i])
+	}
+	return a, nil
+}
+
+// dominates returns whether the block of rpo number i dominates the
+// block of rpo number j.
+func (a *analysis) dominates(i, j int) bool {
+	for j > i {
+		j = a.idom[j]
+	}
+	return i == j
+}
+
+// reducible returns whether the target of every back edge dominates its
+// source.
+func (a *analysis) reducible() bool {
+	for i, b := range a.order {
+		for _, s := range b.succs {
+			if j := a.rpo[s]; j <= i && !a.dominates(j, i) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// merge returns whether the block of rpo number i has several forward
+// predecessors.
+func (a *analysis) merge(i int) bool {
+	return len(a.preds[i]) > 1
+}
+
+// Reducible returns whether the graph, restricted to the blocks reachable
+// from its entry, is reducible.
+// Irreducible graphs are emitted with a dispatch loop.
+func (g *Graph) Reducible() bool {
+	a, err := g.analyze()
+	if err != nil {
+		return false
+	}
+	return a.reducible()
+}
+
+// Emit emits the structured code of the graph into e.
+// Blocks unreachable from the entry are not emitted.
+//
+// The emitted code never falls through: the caller may close the
+// function body with e.End right after.
+func (g *Graph) Emit(e *wasm.Emitter) error {
+	a, err := g.analyze()
+	if err != nil {
+		return err
+	}
+	s := &stackifier{
+		e:      e,
+		a:      a,
+		blocks: make(map[*Block]wasm.Label),
+		loops:  make(map[*Block]wasm.Label),
+	}
+	if a.reducible() {
+		s.tree(a.order[0])
+	} else {
+		s.dispatch()
+	}
+	return e.Err()
+}
+
+type stackifier struct {
+	e *wasm.Emitter
+	a *analysis
+
+	blocks map[*Block]wasm.Label // labels of the blocks followed by a merge node in scope
+	loops  map[*Block]wasm.Label // labels of the loops headed by a block in scope
+	depth  int                   // nesting depth of the emitted constructs
+
+	tmp    uint32 // index of the local holding switch indices
+	hasTmp bool
+}
+
+// scratch returns the index of an i32 local used to hold switch indices.
+func (s *stackifier) scratch() uint32 {
+	if !s.hasTmp {
+		s.tmp = s.e.Local(wasm.I32)
+		s.hasTmp = true
+	}
+	return s.tmp
+}
+
+// tree emits the code of the dominator tree rooted at b.
+func (s *stackifier) tree(b *Block) {
+	i := s.a.rpo[b]
+	var merges []*Block
+	for _, k := range s.a.kids[i] {
+		if s.a.merge(s.a.rpo[k]) {
+			merges = append(merges, k)
+		}
+	}
+	// the merge node with the highest rpo number gets the outermost block.
+	sort.Slice(merges, func(i, j int) bool {
+		return s.a.rpo[merges[i]] > s.a.rpo[merges[j]]
+	})
+
+	if !s.a.loop[i] {
+		s.within(b, merges)
+		return
+	}
+	s.loops[b] = s.e.Loop()
+	s.depth++
+	s.within(b, merges)
+	s.end()
+	delete(s.loops, b)
+	if s.depth == 0 {
+		// the loop never completes normally, but a validator sees the
+		// code following it as reachable.
+		s.e.Unreachable()
+	}
+}
+
+// within emits the code of b and of its children in the dominator tree,
+// where merges are the children that are merge nodes.
+func (s *stackifier) within(b *Block, merges []*Block) {
+	if len(merges) == 0 {
+		s.node(b)
+		return
+	}
+	m := merges[0]
+	s.blocks[m] = s.e.Block()
+	s.depth++
+	s.within(b, merges[1:])
+	s.end()
+	delete(s.blocks, m)
+	s.tree(m)
+}
+
+func (s *stackifier) end() {
+	s.e.End()
+	s.depth--
+}
+
+// node emits the code and the terminator of b.
+func (s *stackifier) node(b *Block) {
+	if b.code != nil {
+		b.code(s.e)
+	}
+	switch b.term {
+	case termReturn:
+		s.e.Return()
+	case termUnreachable:
+		s.e.Unreachable()
+	case termJump:
+		s.branch(b, b.succs[0])
+	case termBranch:
+		then, els := b.succs[0], b.succs[1]
+		if then == els {
+			s.e.Drop()
+			s.branch(b, then)
+			return
+		}
+		// the then arm never falls through: the else arm follows the if.
+		s.e.If()
+		s.depth++
+		s.branch(b, then)
+		s.end()
+		s.branch(b, els)
+	case termSwitch:
+		idx := s.scratch()
+		s.e.LocalSet(idx)
+		s.switchOp(idx, b.succs, func(dst *Block) { s.branch(b, dst) })
+	}
+}
+
+// branch emits the transfer of control from src to dst.
+func (s *stackifier) branch(src, dst *Block) {
+	i, j := s.a.rpo[src], s.a.rpo[dst]
+	switch {
+	case j <= i:
+		s.e.Br(s.loops[dst])
+	case s.a.merge(j):
+		s.e.Br(s.blocks[dst])
+	default:
+		s.tree(dst)
+	}
+}
+
+// switchOp emits a br_table indexed by the local idx to the targets of a
+// switch, the default one last, each followed by the code emitted by jump.
+func (s *stackifier) switchOp(idx uint32, succs []*Block, jump func(dst *Block)) {
+	dsts := distinct(succs)
+	lbls := make(map[*Block]wasm.Label, len(dsts))
+	for i := len(dsts) - 1; i >= 0; i-- {
+		lbls[dsts[i]] = s.e.Block()
+		s.depth++
+	}
+	s.e.LocalGet(idx)
+	targets := make([]wasm.Label, len(succs)-1)
+	for i, dst := range succs[:len(succs)-1] {
+		targets[i] = lbls[dst]
+	}
+	s.e.BrTable(targets, lbls[succs[len(succs)-1]])
+	for _, dst := range dsts {
+		s.end()
+		jump(dst)
+	}
+}
+
+// dispatch emits the graph as a loop switching on a local variable
+// holding the index of the next block to execute.
+func (s *stackifier) dispatch() {
+	var (
+		e     = s.e
+		order = s.a.order
+		next  = e.Local(wasm.I32)
+	)
+	e.I32Const(0).LocalSet(next)
+	loop := e.Loop()
+	s.depth++
+	goTo := func(dst *Block) {
+		e.I32Const(int32(s.a.rpo[dst])).LocalSet(next).Br(loop)
+	}
+	s.switchOp(next, append(append([]*Block{}, order...), order[len(order)-1]), func(b *Block) {
+		if b.code != nil {
+			b.code(e)
+		}
+		switch b.term {
+		case termReturn:
+			e.Return()
+		case termUnreachable:
+			e.Unreachable()
+		case termJump:
+			goTo(b.succs[0])
+		case termBranch:
+			e.If()
+			goTo(b.succs[0])
+			e.End()
+			goTo(b.succs[1])
+		case termSwitch:
+			idx := s.scratch()
+			e.LocalSet(idx)
+			s.switchOp(idx, b.succs, goTo)
+		}
+	})
+	s.end()
+	e.Unreachable()
+}
+
+// distinct returns the blocks of bs without duplicates, in order of
+// first appearance.
+func distinct(bs []*Block) []*Block {
+	o := make([]*Block, 0, len(bs))
+loop:
+	for _, b := range bs {
+		for _, x := range o {
+			if x == b {
+				continue loop
+			}
+		}
+		o = append(o, b)
+	}
+	return o
+}
@@ -0,0 +1,192 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stackify_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/stackify"
+)
+
+// build builds a module exporting the function "f" of type [i32]->[i32],
+// with one i32 local, whose body is the graph made by mk.
+func build(t *testing.T, mk func(g *stackify.Graph)) (*wasm.Module, []byte) {
+	t.Helper()
+	b := wasm.NewBuilder()
+	f := b.Func("f", wasm.FuncType{
+		Params:  []wasm.ValueType{wasm.I32},
+		Results: []wasm.ValueType{wasm.I32},
+	})
+	e := f.Body()
+	e.Local(wasm.I32)
+
+	g := stackify.New()
+	mk(g)
+	if err := g.Emit(e); err != nil {
+		t.Fatalf("could not emit graph: %+v", err)
+	}
+	e.End()
+	b.Export("f", f)
+
+	m, err := b.Build()
+	if err != nil {
+		t.Fatalf("could not build module: %+v", err)
+	}
+	for _, s := range m.Sections {
+		if s, ok := s.(wasm.CodeSection); ok {
+			return m, s.Bodies[0].Code.Code
+		}
+	}
+	t.Fatalf("no code section")
+	return nil, nil
+}
+
+func TestDiamond(t *testing.T) {
+	_, got := build(t, func(g *stackify.Graph) {
+		b0 := g.Block(func(e *wasm.Emitter) { e.LocalGet(0) })
+		b1 := g.Block(func(e *wasm.Emitter) { e.I32Const(1).LocalSet(1) })
+		b2 := g.Block(func(e *wasm.Emitter) { e.I32Const(2).LocalSet(1) })
+		b3 := g.Block(func(e *wasm.Emitter) { e.LocalGet(1) })
+		b0.Branch(b1, b2)
+		b1.Jump(b3)
+		b2.Jump(b3)
+		if !g.Reducible() {
+			t.Fatalf("graph should be reducible")
+		}
+	})
+	want := []byte{
+		0x02, 0x40, // block
+		0x20, 0x00, // local.get 0
+		0x04, 0x40, 0x41, 0x01, 0x21, 0x01, 0x0c, 0x01, 0x0b, // if; b1; br 1; end
+		0x41, 0x02, 0x21, 0x01, 0x0c, 0x00, // b2; br 0
+		0x0b,             // end
+		0x20, 0x01, 0x0f, // b3; return
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid code:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestLoop(t *testing.T) {
+	_, got := build(t, func(g *stackify.Graph) {
+		b0 := g.Block(func(e *wasm.Emitter) { e.I32Const(0).LocalSet(1) })
+		b1 := g.Block(func(e *wasm.Emitter) { e.LocalGet(0).I32Eqz() })
+		b2 := g.Block(func(e *wasm.Emitter) {
+			e.LocalGet(1).LocalGet(0).I32Add().LocalSet(1).LocalGet(0).I32Const(1).I32Sub().LocalSet(0)
+		})
+		b3 := g.Block(func(e *wasm.Emitter) { e.LocalGet(1) })
+		b0.Jump(b1)
+		b1.Branch(b3, b2)
+		b2.Jump(b1)
+	})
+	want := []byte{
+		0x41, 0x00, 0x21, 0x01, // b0
+		0x03, 0x40, // loop
+		0x20, 0x00, 0x45, // b1
+		0x04, 0x40, 0x20, 0x01, 0x0f, 0x0b, // if; b3; return; end
+		0x20, 0x01, 0x20, 0x00, 0x6a, 0x21, 0x01, // b2
+		0x20, 0x00, 0x41, 0x01, 0x6b, 0x21, 0x00,
+		0x0c, 0x00, // br 0
+		0x0b, 0x00, // end; unreachable
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid code:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	_, got := build(t, func(g *stackify.Graph) {
+		b0 := g.Block(func(e *wasm.Emitter) { e.LocalGet(0) })
+		b1 := g.Block(func(e *wasm.Emitter) { e.I32Const(10) })
+		b2 := g.Block(func(e *wasm.Emitter) { e.I32Const(20) })
+		b3 := g.Block(func(e *wasm.Emitter) { e.I32Const(30) })
+		b0.Switch([]*stackify.Block{b1, b2, b1}, b3)
+	})
+	want := []byte{
+		0x20, 0x00, 0x21, 0x02, // local.get 0; local.set 2
+		0x02, 0x40, 0x02, 0x40, 0x02, 0x40, // block; block; block
+		0x20, 0x02, // local.get 2
+		0x0e, 0x03, 0x00, 0x01, 0x00, 0x02, // br_table 0 1 0 2
+		0x0b, 0x41, 0x0a, 0x0f, // end; b1
+		0x0b, 0x41, 0x14, 0x0f, // end; b2
+		0x0b, 0x41, 0x1e, 0x0f, // end; b3
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid code:\ngot= %x\nwant=%x", got, want)
+	}
+}
+
+func TestNested(t *testing.T) {
+	// two nested loops, with a merge node following an if inside the inner loop
+	// and an early exit from the inner loop to the function exit.
+	_, got := build(t, func(g *stackify.Graph) {
+		entry := g.Block(nil)
+		outer := g.Block(func(e *wasm.Emitter) { e.LocalGet(0) })
+		inner := g.Block(func(e *wasm.Emitter) { e.LocalGet(1) })
+		odd := g.Block(func(e *wasm.Emitter) { e.LocalGet(0).I32Const(1).I32And() })
+		inc := g.Block(func(e *wasm.Emitter) { e.LocalGet(1).I32Const(1).I32Add().LocalSet(1) })
+		latch := g.Block(func(e *wasm.Emitter) { e.LocalGet(1).I32Const(100).I32LtU() })
+		dec := g.Block(func(e *wasm.Emitter) { e.LocalGet(0).I32Const(1).I32Sub().LocalSet(0) })
+		exit := g.Block(func(e *wasm.Emitter) { e.LocalGet(1) })
+
+		entry.Jump(outer)
+		outer.Branch(inner, exit)
+		inner.Branch(odd, dec)
+		odd.Branch(inc, latch)
+		inc.Jump(latch)
+		latch.Branch(inner, exit)
+		dec.Jump(outer)
+		if !g.Reducible() {
+			t.Fatalf("graph should be reducible")
+		}
+	})
+	if len(got) == 0 {
+		t.Fatalf("no code")
+	}
+}
+
+func TestIrreducible(t *testing.T) {
+	_, got := build(t, func(g *stackify.Graph) {
+		b0 := g.Block(func(e *wasm.Emitter) { e.LocalGet(0) })
+		b1 := g.Block(func(e *wasm.Emitter) { e.LocalGet(0).I32Const(1).I32Sub().LocalSet(0).LocalGet(0) })
+		b2 := g.Block(func(e *wasm.Emitter) { e.LocalGet(1).I32Const(1).I32Add().LocalSet(1).LocalGet(0) })
+		b3 := g.Block(func(e *wasm.Emitter) { e.LocalGet(1) })
+		b0.Branch(b1, b2)
+		b1.Branch(b2, b3)
+		b2.Branch(b1, b3)
+		if g.Reducible() {
+			t.Fatalf("graph should be irreducible")
+		}
+	})
+	if !bytes.Contains(got, []byte{byte(wasm.Op_br_table)}) {
+		t.Fatalf("no dispatch br_table:\n%x", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	b := wasm.NewBuilder()
+	f := b.Func("f", wasm.FuncType{})
+	e := f.Body()
+
+	g := stackify.New()
+	if err := g.Emit(e); err == nil {
+		t.Fatalf("expected an error for an empty graph")
+	}
+
+	other := stackify.New().Block(nil)
+	g.Block(nil).Jump(other)
+	if err := g.Emit(e); err == nil {
+		t.Fatalf("expected an error for a successor from another graph")
+	}
+
+	g = stackify.New()
+	b0 := g.Block(nil)
+	b0.Branch(g.Block(nil), g.Block(nil))
+	if err := g.Emit(e); err == nil {
+		t.Fatalf("expected an error for a missing branch condition")
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unknownType is the type of operands popped from the polymorphic stack
+// of unreachable code. It matches any type.
+const unknownType ValueType = 0
+
+// ctrlFrame is an entry of the control stack.
+type ctrlFrame struct {
+	op          Opcode      // opcode that opened the frame (Op_block for the function body)
+	params      []ValueType // types of the block parameters
+	results     []ValueType // types of the block results
+	height      int         // height of the operand stack at the start of the block
+	unreachable bool        // whether the rest of the block is unreachable
+}
+
+// labelTypes returns the types of the values expected by a branch to the
+// frame.
+func (f *ctrlFrame) labelTypes() []ValueType {
+	if f.op == Op_loop {
+		return f.params
+	}
+	return f.results
+}
+
+// typeChecker tracks the types of the operand and control stacks of a
+// function body, following the validation algorithm of the specification.
+type typeChecker struct {
+	vals  []ValueType
+	ctrls []ctrlFrame
+}
+
+func (c *typeChecker) reset() {
+	c.vals = c.vals[:0]
+	c.ctrls = c.ctrls[:0]
+}
+
+func (c *typeChecker) push(vt ValueType) {
+	c.vals = append(c.vals, vt)
+}
+
+func (c *typeChecker) pushN(vts []ValueType) {
+	c.vals = append(c.vals, vts...)
+}
+
+func (c *typeChecker) pop() (ValueType, error) {
+	f := &c.ctrls[len(c.ctrls)-1]
+	if len(c.vals) == f.height {
+		if f.unreachable {
+			return unknownType, nil
+		}
+		return unknownType, fmt.Errorf("type mismatch: operand stack underflow")
+	}
+	vt := c.vals[len(c.vals)-1]
+	c.vals = c.vals[:len(c.vals)-1]
+	return vt, nil
+}
+
+// popExpect pops an operand of the provided type.
+func (c *typeChecker) popExpect(want ValueType) (ValueType, error) {
+	got, err := c.pop()
+	if err != nil {
+		return got, fmt.Errorf("type mismatch: expected %v but nothing on stack", want)
+	}
+	if got != want && got != unknownType && want != unknownType {
+		return got, fmt.Errorf("type mismatch: expected %v, got %v", want, got)
+	}
+	if got == unknownType {
+		got = want
+	}
+	return got, nil
+}
+
+// popN pops operands of the provided types, the last one first.
+func (c *typeChecker) popN(vts []ValueType) error {
+	for i := len(vts) - 1; i >= 0; i-- {
+		if _, err := c.popExpect(vts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *typeChecker) pushCtrl(op Opcode, params, results []ValueType) {
+	c.ctrls = append(c.ctrls, ctrlFrame{
+		op:      op,
+		params:  params,
+		results: results,
+		height:  len(c.vals),
+	})
+	c.pushN(params)
+}
+
+func (c *typeChecker) popCtrl() (ctrlFrame, error) {
+	if len(c.ctrls) == 0 {
+		return ctrlFrame{}, fmt.Errorf("control stack underflow")
+	}
+	f := c.ctrls[len(c.ctrls)-1]
+	if err := c.popN(f.results); err != nil {
+		return f, err
+	}
+	if len(c.vals) != f.height {
+		return f, fmt.Errorf("type mismatch: %d extra value(s) on stack at end of block (expected %s)",
+			len(c.vals)-f.height, typesString(f.results),
+		)
+	}
+	c.ctrls = c.ctrls[:len(c.ctrls)-1]
+	return f, nil
+}
+
+// frame returns the control frame targeted by a branch of relative
+// depth n.
+func (c *typeChecker) frame(n uint32) (*ctrlFrame, error) {
+	if int(n) >= len(c.ctrls) {
+		return nil, fmt.Errorf("unknown label %d", n)
+	}
+	return &c.ctrls[len(c.ctrls)-1-int(n)], nil
+}
+
+// setUnreachable marks the rest of the current block as unreachable.
+func (c *typeChecker) setUnreachable() {
+	f := &c.ctrls[len(c.ctrls)-1]
+	c.vals = c.vals[:f.height]
+	f.unreachable = true
+}
+
+// simple type-checks an instruction consuming and producing operands of
+// fixed types.
+func (c *typeChecker) simple(in, out []ValueType) error {
+	if err := c.popN(in); err != nil {
+		return err
+	}
+	c.pushN(out)
+	return nil
+}
+
+func typesString(vts []ValueType) string {
+	s := make([]string, len(vts))
+	for i, vt := range vts {
+		s[i] = vt.String()
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// br type-checks a branch to the frame of relative depth n.
+func (c *typeChecker) br(n uint32) error {
+	f, err := c.frame(n)
+	if err != nil {
+		return err
+	}
+	if err := c.popN(f.labelTypes()); err != nil {
+		return err
+	}
+	c.setUnreachable()
+	return nil
+}
+
+// brIf type-checks a conditional branch to the frame of relative depth n.
+func (c *typeChecker) brIf(n uint32) error {
+	f, err := c.frame(n)
+	if err != nil {
+		return err
+	}
+	if _, err := c.popExpect(I32); err != nil {
+		return err
+	}
+	vts := f.labelTypes()
+	if err := c.popN(vts); err != nil {
+		return err
+	}
+	c.pushN(vts)
+	return nil
+}
+
+// brTable type-checks a branch table to the frames of relative depths
+// ns, with the default target def.
+func (c *typeChecker) brTable(ns []uint32, def uint32) error {
+	if _, err := c.popExpect(I32); err != nil {
+		return err
+	}
+	fdef, err := c.frame(def)
+	if err != nil {
+		return err
+	}
+	arity := len(fdef.labelTypes())
+	for _, n := range ns {
+		f, err := c.frame(n)
+		if err != nil {
+			return err
+		}
+		vts := f.labelTypes()
+		if len(vts) != arity {
+			return fmt.Errorf("type mismatch: br_table targets have inconsistent arities")
+		}
+		// check the operands against the target, leaving them in place.
+		popped := make([]ValueType, len(vts))
+		for i := len(vts) - 1; i >= 0; i-- {
+			vt, err := c.popExpect(vts[i])
+			if err != nil {
+				return err
+			}
+			popped[i] = vt
+		}
+		c.pushN(popped)
+	}
+	if err := c.popN(fdef.labelTypes()); err != nil {
+		return err
+	}
+	c.setUnreachable()
+	return nil
+}
+
+// ret type-checks a return from the function.
+func (c *typeChecker) ret() error {
+	if err := c.popN(c.ctrls[0].results); err != nil {
+		return err
+	}
+	c.setUnreachable()
+	return nil
+}
+
+// selectOp type-checks a select instruction without type annotation.
+func (c *typeChecker) selectOp() error {
+	if _, err := c.popExpect(I32); err != nil {
+		return err
+	}
+	t1, err := c.pop()
+	if err != nil {
+		return err
+	}
+	t2, err := c.popExpect(t1)
+	if err != nil {
+		return err
+	}
+	if t1 == unknownType {
+		t1 = t2
+	}
+	if t1.isRef() {
+		return fmt.Errorf("type mismatch: select of %v operands requires a type annotation", t1)
+	}
+	c.push(t1)
+	return nil
+}
+
+// elseOp type-checks the else opcode of an if block.
+func (c *typeChecker) elseOp() error {
+	f := c.ctrls[len(c.ctrls)-1]
+	if f.op != Op_if {
+		return fmt.Errorf("else without matching if")
+	}
+	if _, err := c.popCtrl(); err != nil {
+		return err
+	}
+	c.pushCtrl(Op_else, f.params, f.results)
+	return nil
+}
+
+// end type-checks the end opcode of a block and returns its frame.
+func (c *typeChecker) end() (ctrlFrame, error) {
+	f, err := c.popCtrl()
+	if err != nil {
+		return f, err
+	}
+	if f.op == Op_if && !equalTypes(f.params, f.results) {
+		return f, fmt.Errorf("type mismatch: if without else must leave its parameters unchanged")
+	}
+	c.pushN(f.results)
+	return f, nil
+}
+
+func equalTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
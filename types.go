@@ -7,7 +7,9 @@ package wasm
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 )
 
 var order = binary.LittleEndian
@@ -41,45 +43,183 @@ func (v *varuint7) read(r io.Reader) (int, error) {
 }
 
 func uvarint(r io.Reader) (uint32, int, error) {
-	var x uint32
+	v, n, err := uvarint64(r)
+	if err != nil {
+		return 0, n, err
+	}
+	if n > 5 || v > 0xffffffff {
+		return 0, n, errOverflow
+	}
+	return uint32(v), n, nil
+}
+
+func uvarint64(r io.Reader) (uint64, int, error) {
+	var x uint64
 	var s uint
-	var buf = make([]byte, 1)
+	var buf [1]byte
 	for i := 0; ; i++ {
-		_, err := r.Read(buf)
+		_, err := io.ReadFull(r, buf[:])
 		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
 			return 0, i, err
 		}
 		b := buf[0]
+		if i == 9 && b > 1 {
+			return 0, i + 1, errOverflow
+		}
+		x |= uint64(b&0x7f) << s
 		if b < 0x80 {
-			if i > 9 || i == 9 && b > 1 {
-				return 0, i, errors.New("wasm: overflow")
-			}
-			return x | uint32(b)<<s, i, nil
+			return x, i + 1, nil
 		}
-		x |= uint32(b&0x7f) << s
 		s += 7
 	}
-	panic("unreachable")
 }
 
 func varint(r io.Reader) (int32, int, error) {
-	uv, n, err := uvarint(r)
-	v := int32(uv >> 1)
-	if uv&1 != 0 {
-		v = ^v
+	v, n, err := varintN(r, 32)
+	return int32(v), n, err
+}
+
+// varintN reads a signed LEB128 value of at most size bits.
+func varintN(r io.Reader, size uint) (int64, int, error) {
+	var x int64
+	var s uint
+	var buf [1]byte
+	max := int(size+6) / 7
+	for i := 0; ; i++ {
+		if i == max {
+			return 0, i, errOverflow
+		}
+		_, err := io.ReadFull(r, buf[:])
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, i, err
+		}
+		b := buf[0]
+		if i == 9 && b != 0x00 && b != 0x7f {
+			return 0, i + 1, errOverflow
+		}
+		x |= int64(b&0x7f) << s
+		s += 7
+		if b < 0x80 {
+			if s < 64 && b&0x40 != 0 {
+				x |= -1 << s
+			}
+			if size < 64 && (x < -1<<(size-1) || x >= 1<<(size-1)) {
+				return 0, i + 1, errOverflow
+			}
+			return x, i + 1, nil
+		}
+	}
+}
+
+var errOverflow = errors.New("wasm: overflow")
+
+// appendUvarint appends the canonical unsigned LEB128 encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// appendPaddedUvarint appends the unsigned LEB128 encoding of v to b,
+// padded to width bytes if v fits.
+func appendPaddedUvarint(b []byte, v uint64, width int) []byte {
+	n := len(appendUvarint(nil, v))
+	if width <= n || width > binary.MaxVarintLen64 || v >= 1<<(7*uint(width)) {
+		return appendUvarint(b, v)
+	}
+	for i := 0; i < width-1; i++ {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// appendVarint appends the canonical signed LEB128 encoding of v to b.
+func appendVarint(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
 	}
-	return v, n, err
 }
 
+// ValueType is the type of a value.
 type ValueType int32
 
+// Value types.
+const (
+	I32 ValueType = ValueType(Op_i32)
+	I64 ValueType = ValueType(Op_i64)
+	F32 ValueType = ValueType(Op_f32)
+	F64 ValueType = ValueType(Op_f64)
+
+	V128      ValueType = ValueType(Op_v128)      // SIMD vector
+	FuncRef   ValueType = ValueType(Op_anyfunc)   // reference to a function
+	ExternRef ValueType = ValueType(Op_externref) // reference to a host value
+)
+
+func (vt ValueType) valid() bool {
+	switch vt {
+	case I32, I64, F32, F64, V128, FuncRef, ExternRef:
+		return true
+	}
+	return false
+}
+
+// isRef returns whether vt is a reference type.
+func (vt ValueType) isRef() bool {
+	return vt == FuncRef || vt == ExternRef
+}
+
+// feature returns the feature introducing the value type.
+func (vt ValueType) feature() Features {
+	switch vt {
+	case V128:
+		return FeatureSIMD
+	case FuncRef, ExternRef:
+		return FeatureReferenceTypes
+	}
+	return FeaturesMVP
+}
+
+func (vt ValueType) String() string {
+	switch vt {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case V128:
+		return "v128"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("ValueType(0x%x)", int32(vt))
+}
+
 type BlockType varint7
 type ElemType varint7
 
+// FuncType describes the signature of a function.
 type FuncType struct {
-	form    uint32      // value for the 'func' type constructor
-	params  []ValueType // parameters of the function
-	results []ValueType // results of the function
+	Params  []ValueType // parameters of the function
+	Results []ValueType // results of the function
 }
 
 // GlobalType describes a global variable
@@ -104,29 +244,98 @@ type MemoryType struct {
 // 1: indicates a Table import or definition
 // 2: indicates a Memory import or definition
 // 3: indicates a Global import or definition
+// 4: indicates a Tag import or definition
 type ExternalKind byte
 
 // 0: indicates a Function import or definition
 // 1: indicates a Table import or definition
 // 2: indicates a Memory import or definition
 // 3: indicates a Global import or definition
+// 4: indicates a Tag import or definition
 const (
 	FunctionKind ExternalKind = 0
-	TableKind                 = 1
-	MemoryKind                = 2
-	GlobalKind                = 3
+	TableKind    ExternalKind = 1
+	MemoryKind   ExternalKind = 2
+	GlobalKind   ExternalKind = 3
+	TagKind      ExternalKind = 4 // exception handling proposal
 )
 
+func (k ExternalKind) String() string {
+	switch k {
+	case FunctionKind:
+		return "function"
+	case TableKind:
+		return "table"
+	case MemoryKind:
+		return "memory"
+	case GlobalKind:
+		return "global"
+	case TagKind:
+		return "tag"
+	}
+	return fmt.Sprintf("ExternalKind(%d)", byte(k))
+}
+
 // ResizableLimits describes the limits of a table or memory
 type ResizableLimits struct {
-	Flags   uint32 // bit 0x1 is set if the maximum field is present
-	Initial uint32 // initial length (in units of table elements or wasm pages)
-	Maximum uint32 // only present if specified by Flags
+	Flags   uint32 // bit 0x1 is set if the maximum field is present, see LimitsShared and LimitsI64 for the others
+	Initial uint64 // initial length (in units of table elements or wasm pages)
+	Maximum uint64 // only present if specified by Flags
+}
+
+// Flags of the limits of memories.
+const (
+	LimitsMax    = 0x1 // the maximum field is present
+	LimitsShared = 0x2 // the memory is shared between threads
+	LimitsI64    = 0x4 // the memory is indexed by i64 values
+)
+
+// indexType returns the type of the addresses of a memory with limits l.
+func (l ResizableLimits) indexType() ValueType {
+	if l.Flags&LimitsI64 != 0 {
+		return I64
+	}
+	return I32
+}
+
+// TagType describes a tag of the exception handling proposal.
+type TagType struct {
+	Attribute byte   // 0: exception
+	Type      uint32 // type index of the signature of the tag
 }
 
 // InitExpr encodes an initializer expression.
-// FIXME(sbinet)
 type InitExpr struct {
-	Expr []byte
-	End  byte
+	Expr []byte // instructions of the expression
+	End  byte   // 0x0b, indicating the end of the expression
+}
+
+// ConstI32 returns an initializer expression evaluating to v.
+func ConstI32(v int32) InitExpr {
+	return InitExpr{Expr: appendVarint([]byte{byte(Op_i32_const)}, int64(v)), End: Op_end}
+}
+
+// ConstI64 returns an initializer expression evaluating to v.
+func ConstI64(v int64) InitExpr {
+	return InitExpr{Expr: appendVarint([]byte{Op_i64_const}, v), End: Op_end}
+}
+
+// ConstF32 returns an initializer expression evaluating to v.
+func ConstF32(v float32) InitExpr {
+	expr := []byte{Op_f32_const, 0, 0, 0, 0}
+	order.PutUint32(expr[1:], math.Float32bits(v))
+	return InitExpr{Expr: expr, End: Op_end}
+}
+
+// ConstF64 returns an initializer expression evaluating to v.
+func ConstF64(v float64) InitExpr {
+	expr := []byte{Op_f64_const, 0, 0, 0, 0, 0, 0, 0, 0}
+	order.PutUint64(expr[1:], math.Float64bits(v))
+	return InitExpr{Expr: expr, End: Op_end}
+}
+
+// ConstGlobal returns an initializer expression evaluating to the value of
+// the (imported) global variable with index idx.
+func ConstGlobal(idx uint32) InitExpr {
+	return InitExpr{Expr: appendUvarint([]byte{Op_get_global}, uint64(idx)), End: Op_end}
 }
@@ -0,0 +1,706 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError describes why a module is invalid.
+type ValidationError struct {
+	Func   int    // index of the invalid function, or -1
+	Offset int    // offset of the invalid instruction in the code of the function body, or -1
+	Op     string // name of the invalid instruction, if any
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Func < 0:
+		return fmt.Sprintf("wasm: invalid module: %v", e.Err)
+	case e.Offset < 0:
+		return fmt.Sprintf("wasm: invalid function %d: %v", e.Func, e.Err)
+	case e.Op == "":
+		return fmt.Sprintf("wasm: invalid function %d: offset %#x: %v", e.Func, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("wasm: invalid function %d: offset %#x (%s): %v", e.Func, e.Offset, e.Op, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Validate checks that the module m is valid, following the validation
+// rules of the specification, with the provided set of features enabled.
+// The first error found is returned as a *ValidationError.
+//
+// The offsets of instructions reported in errors are relative to the
+// start of the code of function bodies, after the declarations of locals.
+func Validate(m *Module, features Features) error {
+	v := &validator{m: m, features: features}
+	if err := v.init(); err != nil {
+		return &ValidationError{Func: -1, Offset: -1, Err: err}
+	}
+	if err := v.module(); err != nil {
+		return &ValidationError{Func: -1, Offset: -1, Err: err}
+	}
+	nimports := len(v.funcs) - len(v.code)
+	for i := range v.code {
+		if err := v.function(nimports + i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validator holds the index spaces of a module under validation.
+type validator struct {
+	m        *Module
+	features Features
+
+	types   []FuncType
+	funcs   []uint32 // type index of each function
+	tables  []TableType
+	mems    []MemoryType
+	globals []GlobalType
+	tags    []TagType
+	elems   []ElemSegment
+	data    []DataSegment
+	code    []FunctionBody
+
+	nimportedGlobals int
+	dataCount        *DataCountSection
+	refs             map[uint32]bool // functions that may be referenced by ref.func
+
+	tc     typeChecker
+	locals []localRun
+}
+
+// localRun is a run of local variables of the same type.
+type localRun struct {
+	end uint64 // index following the last local of the run
+	typ ValueType
+}
+
+// init collects the index spaces of the module.
+func (v *validator) init() error {
+	var nfuncs int
+	for _, sec := range v.m.Sections {
+		switch s := sec.(type) {
+		case TypeSection:
+			v.types = s.Types
+		case ImportSection:
+			for _, imp := range s.Imports {
+				switch t := imp.Type.(type) {
+				case uint32:
+					v.funcs = append(v.funcs, t)
+				case TableType:
+					v.tables = append(v.tables, t)
+				case MemoryType:
+					v.mems = append(v.mems, t)
+				case GlobalType:
+					v.globals = append(v.globals, t)
+					v.nimportedGlobals++
+				case TagType:
+					v.tags = append(v.tags, t)
+				default:
+					return fmt.Errorf("import %s.%s: invalid type %T", imp.Module, imp.Field, imp.Type)
+				}
+			}
+		case FunctionSection:
+			v.funcs = append(v.funcs, s.Types...)
+			nfuncs = len(s.Types)
+		case TableSection:
+			v.tables = append(v.tables, s.Tables...)
+		case MemorySection:
+			v.mems = append(v.mems, s.Memories...)
+		case GlobalSection:
+			for _, g := range s.Globals {
+				v.globals = append(v.globals, g.Type)
+				v.declareRefs(g.Init)
+			}
+		case TagSection:
+			v.tags = append(v.tags, s.Tags...)
+		case ExportSection:
+			for _, e := range s.Exports {
+				if e.Kind == FunctionKind {
+					v.declareRef(e.Index)
+				}
+			}
+		case ElementSection:
+			v.elems = s.Elements
+			for _, es := range s.Elements {
+				for _, f := range es.Elems {
+					v.declareRef(f)
+				}
+				for _, expr := range es.Exprs {
+					v.declareRefs(expr)
+				}
+			}
+		case DataCountSection:
+			v.dataCount = &s
+		case DataSection:
+			v.data = s.Segments
+		case CodeSection:
+			v.code = s.Bodies
+		}
+	}
+	if nfuncs != len(v.code) {
+		return fmt.Errorf("function and code section have inconsistent lengths (%d != %d)", nfuncs, len(v.code))
+	}
+	for i, t := range v.funcs {
+		if int(t) >= len(v.types) {
+			return fmt.Errorf("function %d: unknown type %d", i, t)
+		}
+	}
+	return nil
+}
+
+func (v *validator) declareRef(f uint32) {
+	if v.refs == nil {
+		v.refs = make(map[uint32]bool)
+	}
+	v.refs[f] = true
+}
+
+// declareRefs declares the functions referenced by ref.func in the
+// constant expression ie.
+func (v *validator) declareRefs(ie InitExpr) {
+	cr := newCodeReader(ie.Expr)
+	var in instruction
+	for cr.next(&in) {
+		if in.op == Op_ref_func {
+			v.declareRef(in.idx)
+		}
+	}
+}
+
+// require returns an error if the feature f is not enabled.
+func (v *validator) require(f Features, what string, args ...interface{}) error {
+	if v.features.Has(f) {
+		return nil
+	}
+	return &FeatureError{Feature: f, What: fmt.Sprintf(what, args...)}
+}
+
+// valueType checks that vt is a valid value type.
+func (v *validator) valueType(vt ValueType) error {
+	if !vt.valid() {
+		return fmt.Errorf("invalid value type %v", vt)
+	}
+	return v.require(vt.feature(), "value type %v", vt)
+}
+
+// elemType checks that vt is a valid type for the elements of tables and
+// element segments. Tables of funcref are part of the MVP.
+func (v *validator) elemType(vt ValueType) error {
+	if !vt.isRef() {
+		return fmt.Errorf("invalid element type %v", vt)
+	}
+	if vt == FuncRef {
+		return nil
+	}
+	return v.require(vt.feature(), "element type %v", vt)
+}
+
+// funcType returns the signature of the function with the provided index.
+func (v *validator) funcType(idx uint32) (FuncType, error) {
+	if int(idx) >= len(v.funcs) {
+		return FuncType{}, fmt.Errorf("unknown function %d", idx)
+	}
+	return v.types[v.funcs[idx]], nil
+}
+
+func (v *validator) typ(idx uint32) (FuncType, error) {
+	if int(idx) >= len(v.types) {
+		return FuncType{}, fmt.Errorf("unknown type %d", idx)
+	}
+	return v.types[idx], nil
+}
+
+// localType returns the type of the local variable with the provided
+// index.
+func (v *validator) localType(idx uint32) (ValueType, error) {
+	for _, run := range v.locals {
+		if uint64(idx) < run.end {
+			return run.typ, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown local %d", idx)
+}
+
+// blockType returns the signature of a block type.
+func (v *validator) blockType(bt int64) ([]ValueType, []ValueType, error) {
+	switch {
+	case bt == blockTypeEmpty:
+		return nil, nil, nil
+	case bt < 0:
+		vt := ValueType(bt & 0x7f)
+		if bt < -0x40 || !vt.valid() {
+			return nil, nil, fmt.Errorf("invalid block type 0x%x", byte(bt&0x7f))
+		}
+		if err := v.valueType(vt); err != nil {
+			return nil, nil, err
+		}
+		return nil, []ValueType{vt}, nil
+	}
+	if err := v.require(FeatureMultiValue, "block type with a type index"); err != nil {
+		return nil, nil, err
+	}
+	if bt >= int64(len(v.types)) {
+		return nil, nil, fmt.Errorf("unknown type %d", bt)
+	}
+	ft := v.types[bt]
+	return ft.Params, ft.Results, nil
+}
+
+// function validates the body of the function with the provided index.
+func (v *validator) function(idx int) error {
+	var (
+		ft   = v.types[v.funcs[idx]]
+		fb   = v.code[idx-(len(v.funcs)-len(v.code))]
+		code = fb.Code.Code
+	)
+
+	v.locals = v.locals[:0]
+	n := uint64(0)
+	for _, vt := range ft.Params {
+		n++
+		v.locals = append(v.locals, localRun{end: n, typ: vt})
+	}
+	for _, le := range fb.Locals {
+		if !le.Type.valid() {
+			return &ValidationError{Func: idx, Offset: -1, Err: fmt.Errorf("invalid local type %v", le.Type)}
+		}
+		if err := v.require(le.Type.feature(), "local of type %v", le.Type); err != nil {
+			return &ValidationError{Func: idx, Offset: -1, Err: err}
+		}
+		n += uint64(le.Count)
+		if n > 0xffffffff {
+			return &ValidationError{Func: idx, Offset: -1, Err: fmt.Errorf("too many locals")}
+		}
+		v.locals = append(v.locals, localRun{end: n, typ: le.Type})
+	}
+
+	v.tc.reset()
+	v.tc.pushCtrl(Op_block, nil, ft.Results)
+
+	var (
+		cr = newCodeReader(code)
+		in instruction
+	)
+	for cr.next(&in) {
+		if len(v.tc.ctrls) == 0 {
+			return &ValidationError{Func: idx, Offset: in.off, Op: in.name(),
+				Err: fmt.Errorf("instruction after the end of the function"),
+			}
+		}
+		if err := v.instr(&in); err != nil {
+			return &ValidationError{Func: idx, Offset: in.off, Op: in.name(), Err: err}
+		}
+	}
+	if cr.err != nil {
+		err := cr.err
+		if f := cr.unsupported; f != 0 && !v.features.Has(f) {
+			err = v.require(f, "opcode 0x%02x", code[cr.off])
+		}
+		return &ValidationError{Func: idx, Offset: cr.off, Err: err}
+	}
+	if len(v.tc.ctrls) != 1 {
+		err := fmt.Errorf("unterminated block")
+		if len(v.tc.ctrls) == 0 {
+			err = fmt.Errorf("instruction after the end of the function")
+		}
+		return &ValidationError{Func: idx, Offset: len(code), Op: Opcode(Op_end).String(), Err: err}
+	}
+	if _, err := v.tc.end(); err != nil {
+		return &ValidationError{Func: idx, Offset: len(code), Op: Opcode(Op_end).String(), Err: err}
+	}
+	return nil
+}
+
+// instr validates an instruction.
+func (v *validator) instr(in *instruction) error {
+	info := in.info()
+	if err := v.require(info.feature, "%s", in.name()); err != nil {
+		return err
+	}
+	switch info.feature {
+	case FeatureExceptions:
+		return errUnsupported("exception handling instructions")
+	case FeatureGC:
+		return errUnsupported("gc instructions")
+	}
+
+	tc := &v.tc
+	switch op := in.op; op {
+	case Op_unreachable:
+		tc.setUnreachable()
+		return nil
+
+	case Op_block, Op_loop, Op_if:
+		params, results, err := v.blockType(in.block)
+		if err != nil {
+			return err
+		}
+		if op == Op_if {
+			if _, err := tc.popExpect(I32); err != nil {
+				return err
+			}
+		}
+		if err := tc.popN(params); err != nil {
+			return err
+		}
+		tc.pushCtrl(op, params, results)
+		return nil
+
+	case Op_else:
+		return tc.elseOp()
+
+	case Op_end:
+		_, err := tc.end()
+		return err
+
+	case Op_br:
+		return tc.br(in.idx)
+
+	case Op_br_if:
+		return tc.brIf(in.idx)
+
+	case Op_br_table:
+		return tc.brTable(in.labels, in.idx)
+
+	case Op_return:
+		return tc.ret()
+
+	case Op_call, Op_return_call:
+		ft, err := v.funcType(in.idx)
+		if err != nil {
+			return err
+		}
+		if op == Op_return_call {
+			return v.returnCall(ft)
+		}
+		return tc.simple(ft.Params, ft.Results)
+
+	case Op_call_indirect, Op_return_call_indirect:
+		if in.idx2 != 0 {
+			if err := v.require(FeatureReferenceTypes, "table index %d", in.idx2); err != nil {
+				return err
+			}
+		}
+		if int(in.idx2) >= len(v.tables) {
+			return fmt.Errorf("unknown table %d", in.idx2)
+		}
+		if et := ValueType(v.tables[in.idx2].ElemType); et != FuncRef {
+			return fmt.Errorf("type mismatch: table %d has elements of type %v", in.idx2, et)
+		}
+		ft, err := v.typ(in.idx)
+		if err != nil {
+			return err
+		}
+		if _, err := tc.popExpect(I32); err != nil {
+			return err
+		}
+		if op == Op_return_call_indirect {
+			return v.returnCall(ft)
+		}
+		return tc.simple(ft.Params, ft.Results)
+
+	case Op_drop:
+		_, err := tc.pop()
+		return err
+
+	case Op_select:
+		return tc.selectOp()
+
+	case Op_select_t:
+		if in.idx != 1 {
+			return fmt.Errorf("invalid number of types %d", in.idx)
+		}
+		if err := v.valueType(in.vt); err != nil {
+			return err
+		}
+		return tc.simple([]ValueType{in.vt, in.vt, I32}, []ValueType{in.vt})
+
+	case Op_get_local, Op_set_local, Op_tee_local:
+		vt, err := v.localType(in.idx)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case Op_get_local:
+			return tc.simple(nil, []ValueType{vt})
+		case Op_set_local:
+			return tc.simple([]ValueType{vt}, nil)
+		}
+		return tc.simple([]ValueType{vt}, []ValueType{vt})
+
+	case Op_get_global, Op_set_global:
+		if int(in.idx) >= len(v.globals) {
+			return fmt.Errorf("unknown global %d", in.idx)
+		}
+		g := v.globals[in.idx]
+		if op == Op_get_global {
+			return tc.simple(nil, []ValueType{g.ContentType})
+		}
+		if g.Mutability == 0 {
+			return fmt.Errorf("global %d is immutable", in.idx)
+		}
+		return tc.simple([]ValueType{g.ContentType}, nil)
+
+	case Op_table_get, Op_table_set:
+		et, err := v.table(in.idx)
+		if err != nil {
+			return err
+		}
+		if op == Op_table_get {
+			return tc.simple([]ValueType{I32}, []ValueType{et})
+		}
+		return tc.simple([]ValueType{I32, et}, nil)
+
+	case Op_current_memory, Op_grow_memory:
+		if in.idx != 0 && !v.features.Has(FeatureMultiMemory) {
+			return fmt.Errorf("invalid reserved byte 0x%x", in.idx)
+		}
+		it, err := v.memory(in.idx)
+		if err != nil {
+			return err
+		}
+		if op == Op_current_memory {
+			return tc.simple(nil, []ValueType{it})
+		}
+		return tc.simple([]ValueType{it}, []ValueType{it})
+
+	case Op_ref_null:
+		if !in.vt.isRef() {
+			return fmt.Errorf("invalid reference type %v", in.vt)
+		}
+		return tc.simple(nil, []ValueType{in.vt})
+
+	case Op_ref_is_null:
+		vt, err := tc.pop()
+		if err != nil {
+			return fmt.Errorf("type mismatch: expected a reference but nothing on stack")
+		}
+		if vt != unknownType && !vt.isRef() {
+			return fmt.Errorf("type mismatch: expected a reference, got %v", vt)
+		}
+		tc.push(I32)
+		return nil
+
+	case Op_ref_func:
+		if _, err := v.funcType(in.idx); err != nil {
+			return err
+		}
+		if !v.refs[in.idx] {
+			return fmt.Errorf("undeclared function reference %d", in.idx)
+		}
+		return tc.simple(nil, []ValueType{FuncRef})
+
+	case Op_misc_prefix:
+		return v.miscInstr(in)
+	}
+
+	switch info.imm {
+	case immMemArg, immMemArgLane:
+		it, err := v.memArg(in)
+		if err != nil {
+			return err
+		}
+		if info.imm == immMemArgLane {
+			if n := 16 >> info.align; in.lane >= byte(n) {
+				return fmt.Errorf("invalid lane index %d", in.lane)
+			}
+		}
+		if it != I32 {
+			// the address is the first operand.
+			ins := append([]ValueType{it}, info.in[1:]...)
+			return tc.simple(ins, info.out)
+		}
+	case immLane:
+		if n := laneCount(info.name); in.lane >= n {
+			return fmt.Errorf("invalid lane index %d", in.lane)
+		}
+	case immV128:
+		if in.op == Op_simd_prefix && in.sub == simdShuffle {
+			for _, l := range in.v128 {
+				if l >= 32 {
+					return fmt.Errorf("invalid lane index %d", l)
+				}
+			}
+		}
+	case immReserved:
+		if in.idx != 0 {
+			return fmt.Errorf("invalid reserved byte 0x%x", in.idx)
+		}
+	}
+	return tc.simple(info.in, info.out)
+}
+
+// miscInstr validates an instruction following Op_misc_prefix.
+func (v *validator) miscInstr(in *instruction) error {
+	tc := &v.tc
+	switch in.sub {
+	case miscMemoryInit, miscDataDrop:
+		if v.dataCount == nil {
+			return fmt.Errorf("data count section required")
+		}
+		if int(in.idx) >= len(v.data) {
+			return fmt.Errorf("unknown data segment %d", in.idx)
+		}
+		if in.sub == miscDataDrop {
+			return nil
+		}
+		it, err := v.memory(in.idx2)
+		if err != nil {
+			return err
+		}
+		return tc.simple([]ValueType{it, I32, I32}, nil)
+
+	case miscMemoryCopy:
+		dst, err := v.memory(in.idx)
+		if err != nil {
+			return err
+		}
+		src, err := v.memory(in.idx2)
+		if err != nil {
+			return err
+		}
+		n := dst
+		if src != dst {
+			n = I32
+		}
+		return tc.simple([]ValueType{dst, src, n}, nil)
+
+	case miscMemoryFill:
+		it, err := v.memory(in.idx)
+		if err != nil {
+			return err
+		}
+		return tc.simple([]ValueType{it, I32, it}, nil)
+
+	case miscTableInit, miscElemDrop:
+		if int(in.idx) >= len(v.elems) {
+			return fmt.Errorf("unknown element segment %d", in.idx)
+		}
+		if in.sub == miscElemDrop {
+			return nil
+		}
+		et, err := v.table(in.idx2)
+		if err != nil {
+			return err
+		}
+		if st := v.elems[in.idx].elemType(); st != et {
+			return fmt.Errorf("type mismatch: segment of type %v for table of type %v", st, et)
+		}
+		return tc.simple([]ValueType{I32, I32, I32}, nil)
+
+	case miscTableCopy:
+		dst, err := v.table(in.idx)
+		if err != nil {
+			return err
+		}
+		src, err := v.table(in.idx2)
+		if err != nil {
+			return err
+		}
+		if src != dst {
+			return fmt.Errorf("type mismatch: table of type %v copied to table of type %v", src, dst)
+		}
+		return tc.simple([]ValueType{I32, I32, I32}, nil)
+
+	case miscTableGrow, miscTableSize, miscTableFill:
+		et, err := v.table(in.idx)
+		if err != nil {
+			return err
+		}
+		switch in.sub {
+		case miscTableGrow:
+			return tc.simple([]ValueType{et, I32}, []ValueType{I32})
+		case miscTableSize:
+			return tc.simple(nil, []ValueType{I32})
+		}
+		return tc.simple([]ValueType{I32, et, I32}, nil)
+	}
+	info := in.info()
+	return tc.simple(info.in, info.out)
+}
+
+// returnCall type-checks a tail call to a function of type ft.
+func (v *validator) returnCall(ft FuncType) error {
+	if !equalTypes(ft.Results, v.tc.ctrls[0].results) {
+		return fmt.Errorf("type mismatch: callee results %s differ from the function results %s",
+			typesString(ft.Results), typesString(v.tc.ctrls[0].results),
+		)
+	}
+	if err := v.tc.popN(ft.Params); err != nil {
+		return err
+	}
+	v.tc.setUnreachable()
+	return nil
+}
+
+// table returns the element type of the table with the provided index.
+func (v *validator) table(idx uint32) (ValueType, error) {
+	if int(idx) >= len(v.tables) {
+		return 0, fmt.Errorf("unknown table %d", idx)
+	}
+	return ValueType(v.tables[idx].ElemType), nil
+}
+
+// memory returns the index type of the memory referenced by the memory
+// index or reserved byte idx.
+func (v *validator) memory(idx uint32) (ValueType, error) {
+	if idx != 0 {
+		if err := v.require(FeatureMultiMemory, "memory index %d", idx); err != nil {
+			return 0, err
+		}
+	}
+	if int(idx) >= len(v.mems) {
+		return 0, fmt.Errorf("unknown memory %d", idx)
+	}
+	return v.mems[idx].Limits.indexType(), nil
+}
+
+// memArg validates the immediates of a memory access and returns the index
+// type of the accessed memory.
+func (v *validator) memArg(in *instruction) (ValueType, error) {
+	align := in.idx
+	if align&memArgExplicit != 0 {
+		if err := v.require(FeatureMultiMemory, "explicit memory index"); err != nil {
+			return 0, err
+		}
+		align &^= memArgExplicit
+	}
+	it, err := v.memory(in.mem)
+	if err != nil {
+		return 0, err
+	}
+	max := in.naturalAlignment()
+	switch {
+	case in.op == Op_threads_prefix && align != max:
+		return 0, fmt.Errorf("alignment 2**%d of atomic access is not the natural alignment 2**%d", align, max)
+	case align > max:
+		return 0, fmt.Errorf("alignment 2**%d larger than natural alignment 2**%d", align, max)
+	}
+	if it == I32 && in.offset > 0xffffffff {
+		return 0, fmt.Errorf("offset %d out of range", in.offset)
+	}
+	return it, nil
+}
+
+// laneCount returns the number of lanes of the shape of a vector
+// instruction, from its name.
+func laneCount(name string) byte {
+	for _, shape := range [...]struct {
+		prefix string
+		n      byte
+	}{{"i8x16.", 16}, {"i16x8.", 8}, {"i32x4.", 4}, {"f32x4.", 4}, {"i64x2.", 2}, {"f64x2.", 2}} {
+		if strings.HasPrefix(name, shape.prefix) {
+			return shape.n
+		}
+	}
+	return 0
+}
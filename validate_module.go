@@ -0,0 +1,357 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+)
+
+// module validates the module-level rules: types, imports, limits,
+// initializer expressions, exports, start function and segments.
+func (v *validator) module() error {
+	for i, ft := range v.types {
+		if err := v.funcTypeValid(ft); err != nil {
+			return fmt.Errorf("type %d: %w", i, err)
+		}
+	}
+
+	if len(v.tables) > 1 {
+		if err := v.require(FeatureReferenceTypes, "table 1"); err != nil {
+			return err
+		}
+	}
+	for i, tt := range v.tables {
+		if err := v.elemType(ValueType(tt.ElemType)); err != nil {
+			return fmt.Errorf("table %d: %w", i, err)
+		}
+		if err := limitsValid(tt.Limits, LimitsMax, 0xffffffff); err != nil {
+			return fmt.Errorf("table %d: %w", i, err)
+		}
+	}
+
+	if len(v.mems) > 1 {
+		if err := v.require(FeatureMultiMemory, "memory 1"); err != nil {
+			return err
+		}
+	}
+	for i, mt := range v.mems {
+		if err := v.memoryType(mt); err != nil {
+			return fmt.Errorf("memory %d: %w", i, err)
+		}
+	}
+
+	for i, gt := range v.globals {
+		if err := v.valueType(gt.ContentType); err != nil {
+			return fmt.Errorf("global %d: %w", i, err)
+		}
+		if gt.Mutability > 1 {
+			return fmt.Errorf("global %d: invalid mutability %d", i, gt.Mutability)
+		}
+	}
+
+	for i, tt := range v.tags {
+		if err := v.tagType(tt); err != nil {
+			return fmt.Errorf("tag %d: %w", i, err)
+		}
+	}
+
+	if v.dataCount != nil {
+		if err := v.require(FeatureBulkMemory, "data count section"); err != nil {
+			return err
+		}
+		if int(v.dataCount.Count) != len(v.data) {
+			return fmt.Errorf("data count %d differs from the number of data segments %d", v.dataCount.Count, len(v.data))
+		}
+	}
+
+	for _, sec := range v.m.Sections {
+		var err error
+		switch s := sec.(type) {
+		case GlobalSection:
+			for i, g := range s.Globals {
+				idx := v.nimportedGlobals + i
+				if err = v.constExpr(g.Init, g.Type.ContentType); err != nil {
+					err = fmt.Errorf("global %d: initializer: %w", idx, err)
+					break
+				}
+			}
+		case ExportSection:
+			err = v.exports(s)
+		case StartSection:
+			var ft FuncType
+			ft, err = v.funcType(s.Index)
+			if err == nil && (len(ft.Params) != 0 || len(ft.Results) != 0) {
+				err = fmt.Errorf("invalid type %s -> %s", typesString(ft.Params), typesString(ft.Results))
+			}
+			if err != nil {
+				err = fmt.Errorf("start function: %w", err)
+			}
+		case ElementSection:
+			for i, es := range s.Elements {
+				if err = v.elemSegment(es); err != nil {
+					err = fmt.Errorf("element segment %d: %w", i, err)
+					break
+				}
+			}
+		case DataSection:
+			for i, ds := range s.Segments {
+				if err = v.dataSegment(ds); err != nil {
+					err = fmt.Errorf("data segment %d: %w", i, err)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) funcTypeValid(ft FuncType) error {
+	for _, vt := range ft.Params {
+		if err := v.valueType(vt); err != nil {
+			return fmt.Errorf("parameter: %w", err)
+		}
+	}
+	for _, vt := range ft.Results {
+		if err := v.valueType(vt); err != nil {
+			return fmt.Errorf("result: %w", err)
+		}
+	}
+	if len(ft.Results) > 1 {
+		return v.require(FeatureMultiValue, "type %s -> %s", typesString(ft.Params), typesString(ft.Results))
+	}
+	return nil
+}
+
+// memoryType checks the limits of a memory and the features they require.
+func (v *validator) memoryType(mt MemoryType) error {
+	l := mt.Limits
+	if l.Flags&LimitsShared != 0 {
+		if err := v.require(FeatureThreads, "shared memory"); err != nil {
+			return err
+		}
+		if l.Flags&LimitsMax == 0 {
+			return fmt.Errorf("shared memory must have a maximum size")
+		}
+	}
+	max := uint64(MaxPages)
+	if l.Flags&LimitsI64 != 0 {
+		if err := v.require(FeatureMemory64, "64-bit memory"); err != nil {
+			return err
+		}
+		max = 1 << 48
+	}
+	return limitsValid(l, LimitsMax|LimitsShared|LimitsI64, max)
+}
+
+// tagType checks the signature of an exception tag.
+func (v *validator) tagType(tt TagType) error {
+	if err := v.require(FeatureExceptions, "tag"); err != nil {
+		return err
+	}
+	ft, err := v.typ(tt.Type)
+	if err != nil {
+		return err
+	}
+	if len(ft.Results) != 0 {
+		return fmt.Errorf("invalid tag type %s -> %s", typesString(ft.Params), typesString(ft.Results))
+	}
+	return nil
+}
+
+// limitsValid checks that limits only use the provided flags and are within
+// the range [0, max].
+func limitsValid(l ResizableLimits, flags uint32, max uint64) error {
+	if l.Flags&^flags != 0 {
+		return fmt.Errorf("invalid limits flags 0x%x", l.Flags)
+	}
+	if l.Initial > max {
+		return fmt.Errorf("initial size %d larger than %d", l.Initial, max)
+	}
+	if l.Flags&0x1 == 0 {
+		return nil
+	}
+	if l.Maximum > max {
+		return fmt.Errorf("maximum size %d larger than %d", l.Maximum, max)
+	}
+	if l.Initial > l.Maximum {
+		return fmt.Errorf("initial size %d larger than maximum size %d", l.Initial, l.Maximum)
+	}
+	return nil
+}
+
+func (v *validator) exports(s ExportSection) error {
+	names := make(map[string]bool, len(s.Exports))
+	for _, e := range s.Exports {
+		if names[e.Field] {
+			return fmt.Errorf("duplicate export name %q", e.Field)
+		}
+		names[e.Field] = true
+
+		var n int
+		switch e.Kind {
+		case FunctionKind:
+			n = len(v.funcs)
+		case TableKind:
+			n = len(v.tables)
+		case MemoryKind:
+			n = len(v.mems)
+		case GlobalKind:
+			n = len(v.globals)
+		case TagKind:
+			n = len(v.tags)
+		default:
+			return fmt.Errorf("export %q: invalid kind %d", e.Field, e.Kind)
+		}
+		if int(e.Index) >= n {
+			return fmt.Errorf("export %q: unknown %v %d", e.Field, e.Kind, e.Index)
+		}
+	}
+	return nil
+}
+
+func (v *validator) elemSegment(es ElemSegment) error {
+	switch {
+	case es.Flags > ElemPassive|ElemExplicit|ElemExprs:
+		return fmt.Errorf("invalid flags 0x%x", es.Flags)
+	case es.Flags&ElemExprs != 0 || es.isDeclarative():
+		if err := v.require(FeatureReferenceTypes, "element segment with flags 0x%x", es.Flags); err != nil {
+			return err
+		}
+	case es.Flags != 0:
+		if err := v.require(FeatureBulkMemory, "element segment with flags 0x%x", es.Flags); err != nil {
+			return err
+		}
+	}
+	et := es.elemType()
+	if err := v.elemType(et); err != nil {
+		return err
+	}
+	if es.isActive() {
+		tt, err := v.table(es.Index)
+		if err != nil {
+			return err
+		}
+		if tt != et {
+			return fmt.Errorf("type mismatch: segment of type %v for table of type %v", et, tt)
+		}
+		if err := v.constExpr(es.Offset, I32); err != nil {
+			return fmt.Errorf("offset: %w", err)
+		}
+	}
+	for _, f := range es.Elems {
+		if int(f) >= len(v.funcs) {
+			return fmt.Errorf("unknown function %d", f)
+		}
+	}
+	for i, expr := range es.Exprs {
+		if err := v.constExpr(expr, et); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (v *validator) dataSegment(ds DataSegment) error {
+	switch ds.Flags {
+	case 0, DataExplicit:
+	case DataPassive:
+		return v.require(FeatureBulkMemory, "passive data segment")
+	default:
+		return fmt.Errorf("invalid flags 0x%x", ds.Flags)
+	}
+	it, err := v.memory(ds.Index)
+	if err != nil {
+		return err
+	}
+	if err := v.constExpr(ds.Offset, it); err != nil {
+		return fmt.Errorf("offset: %w", err)
+	}
+	return nil
+}
+
+// constExpr checks that ie is a constant expression of type want.
+// Constant expressions may only read imported immutable globals.
+func (v *validator) constExpr(ie InitExpr, want ValueType) error {
+	var (
+		cr    = newCodeReader(ie.Expr)
+		in    instruction
+		stack []ValueType
+	)
+	for cr.next(&in) {
+		switch in.op {
+		case Op_i32_add, Op_i32_sub, Op_i32_mul, Op_i64_add, Op_i64_sub, Op_i64_mul:
+			if err := v.require(FeatureExtendedConst, "%v in a constant expression", in.op); err != nil {
+				return err
+			}
+			vt := opcodes[in.op].out[0]
+			if n := len(stack); n < 2 || stack[n-1] != vt || stack[n-2] != vt {
+				return fmt.Errorf("type mismatch: %v expects [%v %v], got %s", in.op, vt, vt, typesString(stack))
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		case Op_ref_null:
+			if err := v.require(FeatureReferenceTypes, "%v", in.op); err != nil {
+				return err
+			}
+			if !in.vt.isRef() {
+				return fmt.Errorf("invalid reference type %v", in.vt)
+			}
+			stack = append(stack, in.vt)
+			continue
+		case Op_ref_func:
+			if err := v.require(FeatureReferenceTypes, "%v", in.op); err != nil {
+				return err
+			}
+			if int(in.idx) >= len(v.funcs) {
+				return fmt.Errorf("unknown function %d", in.idx)
+			}
+			stack = append(stack, FuncRef)
+			continue
+		case Op_simd_prefix:
+			if in.sub != simdConst {
+				return fmt.Errorf("%s is not a constant instruction", in.name())
+			}
+			if err := v.require(FeatureSIMD, "%s", in.name()); err != nil {
+				return err
+			}
+			stack = append(stack, V128)
+			continue
+		}
+		switch in.op {
+		case Op_i32_const:
+			stack = append(stack, I32)
+		case Op_i64_const:
+			stack = append(stack, I64)
+		case Op_f32_const:
+			stack = append(stack, F32)
+		case Op_f64_const:
+			stack = append(stack, F64)
+		case Op_get_global:
+			if int(in.idx) >= v.nimportedGlobals {
+				if int(in.idx) < len(v.globals) {
+					return fmt.Errorf("global %d is not imported", in.idx)
+				}
+				return fmt.Errorf("unknown global %d", in.idx)
+			}
+			g := v.globals[in.idx]
+			if g.Mutability != 0 {
+				return fmt.Errorf("global %d is mutable", in.idx)
+			}
+			stack = append(stack, g.ContentType)
+		default:
+			return fmt.Errorf("%s is not a constant instruction", in.name())
+		}
+	}
+	if cr.err != nil {
+		return cr.err
+	}
+	if len(stack) != 1 || stack[0] != want {
+		return fmt.Errorf("type mismatch: expected [%v], got %s", want, typesString(stack))
+	}
+	return nil
+}
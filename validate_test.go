@@ -0,0 +1,331 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/wasmtest"
+)
+
+func TestValidate(t *testing.T) {
+	fnames := []string{
+		"testdata/empty.wasm",
+		"testdata/add.wasm",
+		"testdata/hello.wasm",
+	}
+	for _, fname := range fnames {
+		m, err := wasm.Open(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := wasm.Validate(&m, wasm.FeaturesMVP); err != nil {
+			t.Fatalf("%s: %+v", fname, err)
+		}
+	}
+
+	for _, m := range []*wasm.Module{
+		wasmtest.Build(t, editModule),
+		wasmtest.Build(t, wasmtest.Memory, wasmtest.Table, validateFuncs),
+	} {
+		if err := wasm.Validate(m, wasm.FeaturesMVP); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// validateFuncs adds to b a global and a function exercising most
+// instructions, with the first memory and table of b.
+func validateFuncs(b *wasm.Builder) {
+	g := b.Global(wasm.GlobalType{ContentType: wasm.F64, Mutability: 1}, wasm.ConstF64(1))
+	sig := wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+	f := b.Func("f", sig)
+	e := f.Body()
+	tmp := e.Local(wasm.I64)
+	e.Block(wasm.I32)
+	e.LocalGet(0).I32Load(4).I64ExtendI32S().LocalTee(tmp).I64Const(3).I64Shl().LocalSet(tmp)
+	e.GlobalGet(g).F64Const(0.5).F64Mul().GlobalSet(g)
+	e.LocalGet(0).LocalGet(0).I32Store8(0)
+	outer := e.Block()
+	e.Loop()
+	e.LocalGet(0).I32Eqz().BrIf(outer)
+	e.LocalGet(0).I32Const(1).I32Sub().LocalSet(0)
+	e.End()
+	e.End()
+	e.I32Const(1).MemoryGrow().Drop()
+	e.LocalGet(0).I32Const(0).CallIndirect(sig)
+	e.LocalGet(0).If(wasm.I32)
+	e.Unreachable()
+	e.Else()
+	e.MemorySize()
+	e.End()
+	e.I32Const(7).Select()
+	e.End()
+	e.Return()
+	e.End()
+}
+
+func TestValidateErrors(t *testing.T) {
+	var (
+		i32  = byte(wasm.I32)
+		call = byte(wasm.Op_call)
+		get  = byte(wasm.Op_get_local)
+		c32  = byte(wasm.Op_i32_const)
+		blk  = byte(wasm.Op_block)
+		unr  = byte(wasm.Op_unreachable)
+		br   = byte(wasm.Op_br)
+		drop = byte(wasm.Op_drop)
+		end  = byte(wasm.Op_end)
+	)
+	for _, tc := range []struct {
+		name   string
+		code   []byte
+		mem    bool
+		offset int
+		want   string
+	}{
+		{"empty", nil, false, 0, "expected i32 but nothing on stack"},
+		{"extra", []byte{get, 0, get, 0}, false, 4, "1 extra value(s)"},
+		{"mismatch", []byte{wasm.Op_i64_const, 0}, false, 2, "expected i32, got i64"},
+		{"binop", []byte{c32, 1, wasm.Op_i64_const, 2, wasm.Op_i32_add}, false, 4, "expected i32, got i64"},
+		{"local", []byte{get, 1}, false, 0, "unknown local 1"},
+		{"global", []byte{wasm.Op_get_global, 0}, false, 0, "unknown global 0"},
+		{"func", []byte{call, 2}, false, 0, "unknown function 2"},
+		{"table", []byte{get, 0, get, 0, byte(wasm.Op_call_indirect), 0, 0}, false, 4, "unknown table 0"},
+		{"memory", []byte{get, 0, byte(wasm.Op_i32_load), 2, 0}, false, 2, "unknown memory 0"},
+		{"align", []byte{get, 0, byte(wasm.Op_i32_load), 3, 0}, true, 2, "alignment 2**3 larger than natural alignment 2**2"},
+		{"label", []byte{br, 1}, false, 0, "unknown label 1"},
+		{"block-type", []byte{blk, 0x60, end, get, 0}, false, 0, "invalid block type 0x60"},
+		{"block-type-ref", []byte{blk, 0x70, end, get, 0}, false, 0, "value type funcref requires the reference-types feature"},
+		{"block-result", []byte{blk, i32, end, get, 0}, false, 2, "expected i32 but nothing on stack"},
+		{"unterminated", []byte{blk, 0x40, get, 0}, false, 4, "unterminated block"},
+		{"after-end", []byte{get, 0, end, get, 0}, false, 3, "instruction after the end of the function"},
+		{"else", []byte{blk, 0x40, byte(wasm.Op_else), end, get, 0}, false, 2, "else without matching if"},
+		{"if-no-else", []byte{get, 0, byte(wasm.Op_if), i32, c32, 1, end}, false, 6, "if without else"},
+		{"opcode", []byte{0xff}, false, 0, "invalid opcode 0xff"},
+		{"truncated", []byte{c32}, false, 0, "unexpected EOF"},
+		{"reserved", []byte{byte(wasm.Op_current_memory), 1}, true, 0, "invalid reserved byte 0x1"},
+		{"multi-value", []byte{blk, 0, end, get, 0}, false, 0, "requires the multi-value feature"},
+		// unreachable code is polymorphic, but still type-checked.
+		{"unreachable", []byte{unr, wasm.Op_i64_const, 0, wasm.Op_i32_add}, false, 3, "expected i32, got i64"},
+		{"unreachable-ok", []byte{unr, wasm.Op_i32_add, drop, drop, br, 0}, false, -1, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			if tc.mem {
+				b.Memory(wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+			}
+			b.Func("f0", wasm.FuncType{}).SetBody(nil, nil)
+			f := b.Func("f", wasm.FuncType{
+				Params:  []wasm.ValueType{wasm.I32},
+				Results: []wasm.ValueType{wasm.I32},
+			})
+			f.SetBody(nil, tc.code)
+			m, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = wasm.Validate(m, wasm.FeaturesMVP)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+			var verr *wasm.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if verr.Func != 1 || verr.Offset != tc.offset {
+				t.Fatalf("invalid location: got=(%d, %d), want=(1, %d)", verr.Func, verr.Offset, tc.offset)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateModule(t *testing.T) {
+	var (
+		void  = wasm.FuncType{}
+		i32   = wasm.GlobalType{ContentType: wasm.I32}
+		mut   = wasm.GlobalType{ContentType: wasm.I32, Mutability: 1}
+		limit = func(min, max uint64) wasm.ResizableLimits {
+			return wasm.ResizableLimits{Flags: 1, Initial: min, Maximum: max}
+		}
+		table = func(l wasm.ResizableLimits) wasm.TableType {
+			return wasm.TableType{ElemType: wasm.ElemType(wasm.Op_anyfunc), Limits: l}
+		}
+		nop = func(b *wasm.Builder) *wasm.Func {
+			f := b.Func("f", void)
+			f.SetBody(nil, nil)
+			return f
+		}
+	)
+	for _, tc := range []struct {
+		name  string
+		build func(b *wasm.Builder)
+		edit  func(m *wasm.Module)
+		want  string
+	}{
+		{
+			name: "ok",
+			build: func(b *wasm.Builder) {
+				g := b.ImportGlobal("env", "base", i32)
+				mem := b.Memory(wasm.MemoryType{Limits: limit(1, wasm.MaxPages)})
+				tbl := b.Table(table(limit(1, 1)))
+				f := nop(b)
+				b.Global(i32, wasm.ConstGlobal(g.Index()))
+				b.Elements(tbl, wasm.ConstI32(0), f)
+				b.Data(mem, wasm.ConstGlobal(g.Index()), []byte("hello"))
+				b.Export("f", f)
+				b.Export("mem", mem)
+				b.Start(f)
+			},
+		},
+		{
+			name: "table-limits",
+			build: func(b *wasm.Builder) {
+				b.Table(table(limit(2, 1)))
+			},
+			want: "table 0: initial size 2 larger than maximum size 1",
+		},
+		{
+			name: "memory-limits",
+			build: func(b *wasm.Builder) {
+				b.Memory(wasm.MemoryType{Limits: limit(1, wasm.MaxPages+1)})
+			},
+			want: "memory 0: maximum size 65537 larger than 65536",
+		},
+		{
+			name: "memory-initial",
+			build: func(b *wasm.Builder) {
+				b.ImportMemory("env", "mem", wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: wasm.MaxPages + 1}})
+			},
+			want: "memory 0: initial size 65537 larger than 65536",
+		},
+		{
+			name: "memories",
+			build: func(b *wasm.Builder) {
+				b.ImportMemory("env", "mem", wasm.MemoryType{})
+				b.Memory(wasm.MemoryType{})
+			},
+			want: "memory 1 requires the multi-memory feature",
+		},
+		{
+			name: "tables",
+			build: func(b *wasm.Builder) {
+				b.Table(table(wasm.ResizableLimits{}))
+				b.Table(table(wasm.ResizableLimits{}))
+			},
+			want: "table 1 requires the reference-types feature",
+		},
+		{
+			name: "results",
+			build: func(b *wasm.Builder) {
+				b.Type(wasm.FuncType{Results: []wasm.ValueType{wasm.I32, wasm.I32}})
+			},
+			want: "type 0: type [] -> [i32 i32] requires the multi-value feature",
+		},
+		{
+			name: "export-name",
+			build: func(b *wasm.Builder) {
+				f := nop(b)
+				b.Export("f", f)
+				b.Export("g", f)
+			},
+			edit: func(m *wasm.Module) {
+				// the builder rejects duplicate exports.
+				for _, s := range m.Sections {
+					if s, ok := s.(wasm.ExportSection); ok {
+						s.Exports[1].Field = "f"
+					}
+				}
+			},
+			want: `duplicate export name "f"`,
+		},
+		{
+			name: "start-type",
+			build: func(b *wasm.Builder) {
+				f := b.Func("f", wasm.FuncType{Params: []wasm.ValueType{wasm.I32}})
+				f.SetBody(nil, nil)
+				b.Start(f)
+			},
+			want: "start function: invalid type [i32] -> []",
+		},
+		{
+			name: "global-init",
+			build: func(b *wasm.Builder) {
+				b.Global(i32, wasm.ConstI64(1))
+			},
+			want: "global 0: initializer: type mismatch: expected [i32], got [i64]",
+		},
+		{
+			name: "global-mutable",
+			build: func(b *wasm.Builder) {
+				g := b.ImportGlobal("env", "g", mut)
+				b.Global(i32, wasm.ConstGlobal(g.Index()))
+			},
+			want: "global 1: initializer: global 0 is mutable",
+		},
+		{
+			name: "global-defined",
+			build: func(b *wasm.Builder) {
+				g := b.Global(i32, wasm.ConstI32(0))
+				b.Global(i32, wasm.ConstGlobal(g.Index()))
+			},
+			want: "global 1: initializer: global 0 is not imported",
+		},
+		{
+			name: "elem-offset",
+			build: func(b *wasm.Builder) {
+				b.Elements(b.Table(table(wasm.ResizableLimits{})), wasm.ConstF32(0))
+			},
+			want: "element segment 0: offset: type mismatch: expected [i32], got [f32]",
+		},
+		{
+			name: "data-offset",
+			build: func(b *wasm.Builder) {
+				mem := b.Memory(wasm.MemoryType{})
+				b.Data(mem, wasm.InitExpr{Expr: []byte{byte(wasm.Op_nop)}, End: byte(wasm.Op_end)}, nil)
+			},
+			want: "data segment 0: offset: nop is not a constant instruction",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := wasm.NewBuilder()
+			tc.build(b)
+			m, err := b.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.edit != nil {
+				tc.edit(m)
+			}
+			err = wasm.Validate(m, wasm.FeaturesMVP)
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+			var verr *wasm.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if verr.Func != -1 {
+				t.Fatalf("invalid function: got=%d, want=-1", verr.Func)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("invalid error:\ngot= %v\nwant=%s", err, tc.want)
+			}
+		})
+	}
+}
@@ -5,3 +5,26 @@
 package wasm
 
 var magicWASM = [4]byte{0x00, 0x61, 0x73, 0x6d} // "\0asm"
+
+const (
+	// Version is the version of the binary format produced by the encoder.
+	Version uint32 = 0x1
+
+	// legacyVersion is the version of the pre-MVP binary format.
+	legacyVersion uint32 = 0xd
+)
+
+const (
+	// PageSize is the size in bytes of a page of linear memory.
+	PageSize = 65536
+
+	// MaxPages is the maximum number of pages of a linear memory.
+	MaxPages = 65536
+)
+
+// subsections of the name section
+const (
+	nameModuleID   = 0
+	nameFunctionID = 1
+	nameLocalID    = 2
+)
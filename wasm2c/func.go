@@ -0,0 +1,174 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2c
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/translate"
+)
+
+// The generator is the C back-end of the translation of the function
+// bodies.
+var _ translate.Backend = (*generator)(nil)
+
+// genFunc translates the body of the i-th defined function to the C
+// function name.
+func (g *generator) genFunc(name string, i int) (string, error) {
+	f, err := g.Translate(g, i)
+	if err != nil {
+		return "", err
+	}
+
+	var o strings.Builder
+	fmt.Fprintf(&o, "static %s {\n", g.prototype(name, g.Funcs[g.NumImports+i]))
+	var unread []string
+	for _, v := range f.Vars() {
+		fmt.Fprintf(&o, "\t%s %s = 0;\n", cType(v.Type), v.Name)
+		if !v.Read {
+			unread = append(unread, v.Name)
+		}
+	}
+	for _, v := range unread {
+		fmt.Fprintf(&o, "\t(void)%s;\n", v)
+	}
+	o.WriteString("\twasm_rt_enter(&m->rt);\n")
+	for _, l := range f.Lines() {
+		o.WriteString(l)
+		o.WriteByte('\n')
+	}
+	o.WriteString("}\n")
+	return o.String(), nil
+}
+
+func (g *generator) Stmt(s string) string       { return s + ";" }
+func (g *generator) If(cond string) string      { return "if (" + cond + ") {" }
+func (g *generator) Switch(x string) string     { return "switch (" + x + ") {" }
+func (g *generator) Label(name string) string   { return name + ":;" }
+func (g *generator) Global(idx uint32) string   { return "m->" + translate.GlobalName(idx) }
+func (g *generator) MemorySize() string         { return "m->mem.pages" }
+func (g *generator) MemoryGrow(d string) string { return "wasm_rt_grow(&m->mem, " + d + ")" }
+func (g *generator) Abort() string              { return "abort();" }
+
+func (g *generator) Unreachable() string {
+	return "wasm_rt_trap(&m->rt, WASM_RT_TRAP_UNREACHABLE);"
+}
+
+func (g *generator) Return(v string) []string {
+	if v == "" {
+		return []string{"m->rt.depth--;", "return;"}
+	}
+	return []string{"m->rt.depth--;", "return " + v + ";"}
+}
+
+func (g *generator) Call(idx uint32, args []string) string {
+	return fmt.Sprintf("%s(%s)", translate.FuncName(idx), strings.Join(append([]string{"m"}, args...), ", "))
+}
+
+func (g *generator) CallIndirect(f *translate.Func, idx uint32, elem string, args []string) {
+	ft := g.Types[idx]
+	fn := fmt.Sprintf("((%s)wasm_rt_elem_func(&m->rt, &m->table, %s, %d))", g.funcPtr(ft), elem, g.typeID(idx))
+	f.Call(ft.Results, fmt.Sprintf("%s(%s)", fn, strings.Join(append([]string{"m"}, args...), ", ")))
+}
+
+func (g *generator) Select(f *translate.Func, dst, cond, a, b string) {
+	f.Emit("%s = %s ? %s : %s;", dst, cond, a, b)
+}
+
+func (g *generator) Const(t wasm.ValueType, v uint64) string {
+	switch t {
+	case wasm.I32:
+		return i32Const(int32(v))
+	case wasm.I64:
+		return i64Const(int64(v))
+	case wasm.F32:
+		return f32Const(uint32(v))
+	}
+	return f64Const(v)
+}
+
+func (g *generator) Load(op wasm.Opcode, addr string, off uint32) string {
+	return fmt.Sprintf("%s(&m->rt, &m->mem, %s, %d)", accesses[op], addr, off)
+}
+
+func (g *generator) Store(op wasm.Opcode, addr string, off uint32, v string) string {
+	return fmt.Sprintf("%s(&m->rt, &m->mem, %s, %d, %s)", accesses[op], addr, off, v)
+}
+
+func (g *generator) Numeric(op wasm.Opcode) (translate.Numeric, bool) {
+	n, ok := numerics[op]
+	return translate.Numeric{Type: n.typ, N: n.n, Expr: n.expr}, ok
+}
+
+// cType returns the C type of the values of type t in the generated code.
+func cType(t wasm.ValueType) string {
+	switch t {
+	case wasm.I32:
+		return "uint32_t"
+	case wasm.I64:
+		return "uint64_t"
+	case wasm.F32:
+		return "float"
+	case wasm.F64:
+		return "double"
+	}
+	panic(fmt.Errorf("wasm2c: invalid value type %v", t))
+}
+
+// apiType returns the C type of the values of type t in the API of the
+// generated code, where the integers are signed.
+func apiType(t wasm.ValueType) string {
+	switch t {
+	case wasm.I32:
+		return "int32_t"
+	case wasm.I64:
+		return "int64_t"
+	}
+	return cType(t)
+}
+
+// i32Const returns a C expression of the i32 value v.
+func i32Const(v int32) string {
+	switch {
+	case v >= 0:
+		return fmt.Sprintf("%du", v)
+	case v == math.MinInt32:
+		return "0x80000000u"
+	}
+	return fmt.Sprintf("(uint32_t)%d", v)
+}
+
+// i64Const returns a C expression of the i64 value v.
+func i64Const(v int64) string {
+	switch {
+	case v >= 0:
+		return fmt.Sprintf("UINT64_C(%d)", v)
+	case v == math.MinInt64:
+		return "UINT64_C(0x8000000000000000)"
+	}
+	return fmt.Sprintf("(uint64_t)INT64_C(%d)", v)
+}
+
+// f32Const returns a C expression of the float value with the bits v.
+func f32Const(v uint32) string {
+	x := math.Float32frombits(v)
+	if x != x || math.IsInf(float64(x), 0) || (x == 0 && v != 0) {
+		return fmt.Sprintf("wasm_rt_f32_from_bits(%#xu)", v)
+	}
+	return strconv.FormatFloat(float64(x), 'x', -1, 32) + "f"
+}
+
+// f64Const returns a C expression of the double value with the bits v.
+func f64Const(v uint64) string {
+	x := math.Float64frombits(v)
+	if x != x || math.IsInf(x, 0) || (x == 0 && v != 0) {
+		return fmt.Sprintf("wasm_rt_f64_from_bits(UINT64_C(%#x))", v)
+	}
+	return strconv.FormatFloat(x, 'x', -1, 64)
+}
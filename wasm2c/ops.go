@@ -0,0 +1,176 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2c
+
+import "github.com/sbinet/wasm"
+
+// numeric describes the translation of a numeric instruction.
+type numeric struct {
+	typ  wasm.ValueType // type of the result
+	n    int            // number of operands
+	expr string         // C expression, formatted with the operands
+}
+
+// the integers are held in unsigned variables, for their arithmetic to
+// wrap around, and converted to signed integers by the signed
+// instructions.
+var numerics = map[wasm.Opcode]numeric{
+	wasm.Op_i32_eqz:  {wasm.I32, 1, "%[1]s == 0"},
+	wasm.Op_i32_eq:   {wasm.I32, 2, "%[1]s == %[2]s"},
+	wasm.Op_i32_ne:   {wasm.I32, 2, "%[1]s != %[2]s"},
+	wasm.Op_i32_lt_s: {wasm.I32, 2, "(int32_t)%[1]s < (int32_t)%[2]s"},
+	wasm.Op_i32_lt_u: {wasm.I32, 2, "%[1]s < %[2]s"},
+	wasm.Op_i32_gt_s: {wasm.I32, 2, "(int32_t)%[1]s > (int32_t)%[2]s"},
+	wasm.Op_i32_gt_u: {wasm.I32, 2, "%[1]s > %[2]s"},
+	wasm.Op_i32_le_s: {wasm.I32, 2, "(int32_t)%[1]s <= (int32_t)%[2]s"},
+	wasm.Op_i32_le_u: {wasm.I32, 2, "%[1]s <= %[2]s"},
+	wasm.Op_i32_ge_s: {wasm.I32, 2, "(int32_t)%[1]s >= (int32_t)%[2]s"},
+	wasm.Op_i32_ge_u: {wasm.I32, 2, "%[1]s >= %[2]s"},
+	wasm.Op_i64_eqz:  {wasm.I32, 1, "%[1]s == 0"},
+	wasm.Op_i64_eq:   {wasm.I32, 2, "%[1]s == %[2]s"},
+	wasm.Op_i64_ne:   {wasm.I32, 2, "%[1]s != %[2]s"},
+	wasm.Op_i64_lt_s: {wasm.I32, 2, "(int64_t)%[1]s < (int64_t)%[2]s"},
+	wasm.Op_i64_lt_u: {wasm.I32, 2, "%[1]s < %[2]s"},
+	wasm.Op_i64_gt_s: {wasm.I32, 2, "(int64_t)%[1]s > (int64_t)%[2]s"},
+	wasm.Op_i64_gt_u: {wasm.I32, 2, "%[1]s > %[2]s"},
+	wasm.Op_i64_le_s: {wasm.I32, 2, "(int64_t)%[1]s <= (int64_t)%[2]s"},
+	wasm.Op_i64_le_u: {wasm.I32, 2, "%[1]s <= %[2]s"},
+	wasm.Op_i64_ge_s: {wasm.I32, 2, "(int64_t)%[1]s >= (int64_t)%[2]s"},
+	wasm.Op_i64_ge_u: {wasm.I32, 2, "%[1]s >= %[2]s"},
+	wasm.Op_f32_eq:   {wasm.I32, 2, "%[1]s == %[2]s"},
+	wasm.Op_f32_ne:   {wasm.I32, 2, "%[1]s != %[2]s"},
+	wasm.Op_f32_lt:   {wasm.I32, 2, "%[1]s < %[2]s"},
+	wasm.Op_f32_gt:   {wasm.I32, 2, "%[1]s > %[2]s"},
+	wasm.Op_f32_le:   {wasm.I32, 2, "%[1]s <= %[2]s"},
+	wasm.Op_f32_ge:   {wasm.I32, 2, "%[1]s >= %[2]s"},
+	wasm.Op_f64_eq:   {wasm.I32, 2, "%[1]s == %[2]s"},
+	wasm.Op_f64_ne:   {wasm.I32, 2, "%[1]s != %[2]s"},
+	wasm.Op_f64_lt:   {wasm.I32, 2, "%[1]s < %[2]s"},
+	wasm.Op_f64_gt:   {wasm.I32, 2, "%[1]s > %[2]s"},
+	wasm.Op_f64_le:   {wasm.I32, 2, "%[1]s <= %[2]s"},
+	wasm.Op_f64_ge:   {wasm.I32, 2, "%[1]s >= %[2]s"},
+
+	wasm.Op_i32_clz:    {wasm.I32, 1, "wasm_rt_i32_clz(%[1]s)"},
+	wasm.Op_i32_ctz:    {wasm.I32, 1, "wasm_rt_i32_ctz(%[1]s)"},
+	wasm.Op_i32_popcnt: {wasm.I32, 1, "wasm_rt_i32_popcnt(%[1]s)"},
+	wasm.Op_i32_add:    {wasm.I32, 2, "%[1]s + %[2]s"},
+	wasm.Op_i32_sub:    {wasm.I32, 2, "%[1]s - %[2]s"},
+	wasm.Op_i32_mul:    {wasm.I32, 2, "%[1]s * %[2]s"},
+	wasm.Op_i32_div_s:  {wasm.I32, 2, "wasm_rt_i32_div_s(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i32_div_u:  {wasm.I32, 2, "wasm_rt_i32_div_u(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i32_rem_s:  {wasm.I32, 2, "wasm_rt_i32_rem_s(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i32_rem_u:  {wasm.I32, 2, "wasm_rt_i32_rem_u(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i32_and:    {wasm.I32, 2, "%[1]s & %[2]s"},
+	wasm.Op_i32_or:     {wasm.I32, 2, "%[1]s | %[2]s"},
+	wasm.Op_i32_xor:    {wasm.I32, 2, "%[1]s ^ %[2]s"},
+	wasm.Op_i32_shl:    {wasm.I32, 2, "%[1]s << (%[2]s & 31)"},
+	wasm.Op_i32_shr_s:  {wasm.I32, 2, "wasm_rt_i32_shr_s(%[1]s, %[2]s)"},
+	wasm.Op_i32_shr_u:  {wasm.I32, 2, "%[1]s >> (%[2]s & 31)"},
+	wasm.Op_i32_rotl:   {wasm.I32, 2, "wasm_rt_i32_rotl(%[1]s, %[2]s)"},
+	wasm.Op_i32_rotr:   {wasm.I32, 2, "wasm_rt_i32_rotr(%[1]s, %[2]s)"},
+	wasm.Op_i64_clz:    {wasm.I64, 1, "wasm_rt_i64_clz(%[1]s)"},
+	wasm.Op_i64_ctz:    {wasm.I64, 1, "wasm_rt_i64_ctz(%[1]s)"},
+	wasm.Op_i64_popcnt: {wasm.I64, 1, "wasm_rt_i64_popcnt(%[1]s)"},
+	wasm.Op_i64_add:    {wasm.I64, 2, "%[1]s + %[2]s"},
+	wasm.Op_i64_sub:    {wasm.I64, 2, "%[1]s - %[2]s"},
+	wasm.Op_i64_mul:    {wasm.I64, 2, "%[1]s * %[2]s"},
+	wasm.Op_i64_div_s:  {wasm.I64, 2, "wasm_rt_i64_div_s(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i64_div_u:  {wasm.I64, 2, "wasm_rt_i64_div_u(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i64_rem_s:  {wasm.I64, 2, "wasm_rt_i64_rem_s(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i64_rem_u:  {wasm.I64, 2, "wasm_rt_i64_rem_u(&m->rt, %[1]s, %[2]s)"},
+	wasm.Op_i64_and:    {wasm.I64, 2, "%[1]s & %[2]s"},
+	wasm.Op_i64_or:     {wasm.I64, 2, "%[1]s | %[2]s"},
+	wasm.Op_i64_xor:    {wasm.I64, 2, "%[1]s ^ %[2]s"},
+	wasm.Op_i64_shl:    {wasm.I64, 2, "%[1]s << (%[2]s & 63)"},
+	wasm.Op_i64_shr_s:  {wasm.I64, 2, "wasm_rt_i64_shr_s(%[1]s, %[2]s)"},
+	wasm.Op_i64_shr_u:  {wasm.I64, 2, "%[1]s >> (%[2]s & 63)"},
+	wasm.Op_i64_rotl:   {wasm.I64, 2, "wasm_rt_i64_rotl(%[1]s, %[2]s)"},
+	wasm.Op_i64_rotr:   {wasm.I64, 2, "wasm_rt_i64_rotr(%[1]s, %[2]s)"},
+
+	// the generated code must be compiled without contracting the
+	// multiplications and additions of floats (-ffp-contract=off).
+	wasm.Op_f32_abs:      {wasm.F32, 1, "wasm_rt_f32_abs(%[1]s)"},
+	wasm.Op_f32_neg:      {wasm.F32, 1, "wasm_rt_f32_neg(%[1]s)"},
+	wasm.Op_f32_ceil:     {wasm.F32, 1, "ceilf(%[1]s)"},
+	wasm.Op_f32_floor:    {wasm.F32, 1, "floorf(%[1]s)"},
+	wasm.Op_f32_trunc:    {wasm.F32, 1, "truncf(%[1]s)"},
+	wasm.Op_f32_nearest:  {wasm.F32, 1, "nearbyintf(%[1]s)"},
+	wasm.Op_f32_sqrt:     {wasm.F32, 1, "sqrtf(%[1]s)"},
+	wasm.Op_f32_add:      {wasm.F32, 2, "%[1]s + %[2]s"},
+	wasm.Op_f32_sub:      {wasm.F32, 2, "%[1]s - %[2]s"},
+	wasm.Op_f32_mul:      {wasm.F32, 2, "%[1]s * %[2]s"},
+	wasm.Op_f32_div:      {wasm.F32, 2, "%[1]s / %[2]s"},
+	wasm.Op_f32_min:      {wasm.F32, 2, "(float)wasm_rt_fmin(%[1]s, %[2]s)"},
+	wasm.Op_f32_max:      {wasm.F32, 2, "(float)wasm_rt_fmax(%[1]s, %[2]s)"},
+	wasm.Op_f32_copysign: {wasm.F32, 2, "wasm_rt_f32_copysign(%[1]s, %[2]s)"},
+	wasm.Op_f64_abs:      {wasm.F64, 1, "wasm_rt_f64_abs(%[1]s)"},
+	wasm.Op_f64_neg:      {wasm.F64, 1, "wasm_rt_f64_neg(%[1]s)"},
+	wasm.Op_f64_ceil:     {wasm.F64, 1, "ceil(%[1]s)"},
+	wasm.Op_f64_floor:    {wasm.F64, 1, "floor(%[1]s)"},
+	wasm.Op_f64_trunc:    {wasm.F64, 1, "trunc(%[1]s)"},
+	wasm.Op_f64_nearest:  {wasm.F64, 1, "nearbyint(%[1]s)"},
+	wasm.Op_f64_sqrt:     {wasm.F64, 1, "sqrt(%[1]s)"},
+	wasm.Op_f64_add:      {wasm.F64, 2, "%[1]s + %[2]s"},
+	wasm.Op_f64_sub:      {wasm.F64, 2, "%[1]s - %[2]s"},
+	wasm.Op_f64_mul:      {wasm.F64, 2, "%[1]s * %[2]s"},
+	wasm.Op_f64_div:      {wasm.F64, 2, "%[1]s / %[2]s"},
+	wasm.Op_f64_min:      {wasm.F64, 2, "wasm_rt_fmin(%[1]s, %[2]s)"},
+	wasm.Op_f64_max:      {wasm.F64, 2, "wasm_rt_fmax(%[1]s, %[2]s)"},
+	wasm.Op_f64_copysign: {wasm.F64, 2, "wasm_rt_f64_copysign(%[1]s, %[2]s)"},
+
+	wasm.Op_i32_wrap_i64:        {wasm.I32, 1, "(uint32_t)%[1]s"},
+	wasm.Op_i32_trunc_s_f32:     {wasm.I32, 1, "(uint32_t)wasm_rt_trunc_s(&m->rt, %[1]s, -2147483648.0, 2147483648.0)"},
+	wasm.Op_i32_trunc_u_f32:     {wasm.I32, 1, "(uint32_t)wasm_rt_trunc_u(&m->rt, %[1]s, 4294967296.0)"},
+	wasm.Op_i32_trunc_s_f64:     {wasm.I32, 1, "(uint32_t)wasm_rt_trunc_s(&m->rt, %[1]s, -2147483648.0, 2147483648.0)"},
+	wasm.Op_i32_trunc_u_f64:     {wasm.I32, 1, "(uint32_t)wasm_rt_trunc_u(&m->rt, %[1]s, 4294967296.0)"},
+	wasm.Op_i64_extend_s_i32:    {wasm.I64, 1, "(uint64_t)(int64_t)(int32_t)%[1]s"},
+	wasm.Op_i64_extend_u_i32:    {wasm.I64, 1, "(uint64_t)%[1]s"},
+	wasm.Op_i64_trunc_s_f32:     {wasm.I64, 1, "(uint64_t)wasm_rt_trunc_s(&m->rt, %[1]s, -9223372036854775808.0, 9223372036854775808.0)"},
+	wasm.Op_i64_trunc_u_f32:     {wasm.I64, 1, "wasm_rt_trunc_u(&m->rt, %[1]s, 18446744073709551616.0)"},
+	wasm.Op_i64_trunc_s_f64:     {wasm.I64, 1, "(uint64_t)wasm_rt_trunc_s(&m->rt, %[1]s, -9223372036854775808.0, 9223372036854775808.0)"},
+	wasm.Op_i64_trunc_u_f64:     {wasm.I64, 1, "wasm_rt_trunc_u(&m->rt, %[1]s, 18446744073709551616.0)"},
+	wasm.Op_f32_convert_s_i32:   {wasm.F32, 1, "(float)(int32_t)%[1]s"},
+	wasm.Op_f32_convert_u_i32:   {wasm.F32, 1, "(float)%[1]s"},
+	wasm.Op_f32_convert_s_i64:   {wasm.F32, 1, "(float)(int64_t)%[1]s"},
+	wasm.Op_f32_convert_u_i64:   {wasm.F32, 1, "(float)%[1]s"},
+	wasm.Op_f32_demote_f64:      {wasm.F32, 1, "(float)%[1]s"},
+	wasm.Op_f64_convert_s_i32:   {wasm.F64, 1, "(double)(int32_t)%[1]s"},
+	wasm.Op_f64_convert_u_i32:   {wasm.F64, 1, "(double)%[1]s"},
+	wasm.Op_f64_convert_s_i64:   {wasm.F64, 1, "(double)(int64_t)%[1]s"},
+	wasm.Op_f64_convert_u_i64:   {wasm.F64, 1, "(double)%[1]s"},
+	wasm.Op_f64_promote_f32:     {wasm.F64, 1, "(double)%[1]s"},
+	wasm.Op_i32_reinterpret_f32: {wasm.I32, 1, "wasm_rt_f32_bits(%[1]s)"},
+	wasm.Op_i64_reinterpret_f64: {wasm.I64, 1, "wasm_rt_f64_bits(%[1]s)"},
+	wasm.Op_f32_reinterpret_i32: {wasm.F32, 1, "wasm_rt_f32_from_bits(%[1]s)"},
+	wasm.Op_f64_reinterpret_i64: {wasm.F64, 1, "wasm_rt_f64_from_bits(%[1]s)"},
+}
+
+// accesses holds the functions of the runtime implementing the load and
+// store instructions.
+var accesses = map[wasm.Opcode]string{
+	wasm.Op_i32_load:     "wasm_rt_i32_load",
+	wasm.Op_i64_load:     "wasm_rt_i64_load",
+	wasm.Op_f32_load:     "wasm_rt_f32_load",
+	wasm.Op_f64_load:     "wasm_rt_f64_load",
+	wasm.Op_i32_load8_s:  "wasm_rt_i32_load8_s",
+	wasm.Op_i32_load8_u:  "wasm_rt_i32_load8_u",
+	wasm.Op_i32_load16_s: "wasm_rt_i32_load16_s",
+	wasm.Op_i32_load16_u: "wasm_rt_i32_load16_u",
+	wasm.Op_i64_load8_s:  "wasm_rt_i64_load8_s",
+	wasm.Op_i64_load8_u:  "wasm_rt_i64_load8_u",
+	wasm.Op_i64_load16_s: "wasm_rt_i64_load16_s",
+	wasm.Op_i64_load16_u: "wasm_rt_i64_load16_u",
+	wasm.Op_i64_load32_s: "wasm_rt_i64_load32_s",
+	wasm.Op_i64_load32_u: "wasm_rt_i64_load32_u",
+	wasm.Op_i32_store:    "wasm_rt_i32_store",
+	wasm.Op_i64_store:    "wasm_rt_i64_store",
+	wasm.Op_f32_store:    "wasm_rt_f32_store",
+	wasm.Op_f64_store:    "wasm_rt_f64_store",
+	wasm.Op_i32_store8:   "wasm_rt_i32_store8",
+	wasm.Op_i32_store16:  "wasm_rt_i32_store16",
+	wasm.Op_i64_store8:   "wasm_rt_i64_store8",
+	wasm.Op_i64_store16:  "wasm_rt_i64_store16",
+	wasm.Op_i64_store32:  "wasm_rt_i64_store32",
+}
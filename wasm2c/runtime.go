@@ -0,0 +1,468 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2c
+
+// RuntimeHeader is the name of the runtime header included by the
+// generated code.
+const RuntimeHeader = "wasm-rt.h"
+
+// runtime is the source code of the runtime header.
+const runtime = `/* Code generated by wasm2c. DO NOT EDIT. */
+
+/* Runtime of the modules translated by wasm2c. */
+
+#ifndef WASM_RT_H
+#define WASM_RT_H
+
+#include <math.h>
+#include <setjmp.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#if defined(__GNUC__)
+#define WASM_RT_NORETURN __attribute__((noreturn))
+#else
+#define WASM_RT_NORETURN
+#endif
+
+#define WASM_RT_PAGE_SIZE 65536
+#define WASM_RT_MAX_CALL_DEPTH 10000
+
+/* Traps, returned by the exported functions. */
+enum {
+	WASM_RT_TRAP_NONE,
+	WASM_RT_TRAP_UNREACHABLE,
+	WASM_RT_TRAP_OOB_MEMORY,
+	WASM_RT_TRAP_OOB_TABLE,
+	WASM_RT_TRAP_DIV_BY_ZERO,
+	WASM_RT_TRAP_INT_OVERFLOW,
+	WASM_RT_TRAP_INVALID_CONVERSION,
+	WASM_RT_TRAP_CALL_INDIRECT,
+	WASM_RT_TRAP_UNDEFINED_ELEMENT,
+	WASM_RT_TRAP_UNINITIALIZED_ELEMENT,
+	WASM_RT_TRAP_EXHAUSTION,
+	WASM_RT_TRAP_OUT_OF_MEMORY,
+	WASM_RT_TRAP_HOST
+};
+
+/* wasm_rt_trap_string returns the description of a trap. */
+static inline const char *wasm_rt_trap_string(int trap) {
+	switch (trap) {
+	case WASM_RT_TRAP_NONE: return "no trap";
+	case WASM_RT_TRAP_UNREACHABLE: return "unreachable executed";
+	case WASM_RT_TRAP_OOB_MEMORY: return "out of bounds memory access";
+	case WASM_RT_TRAP_OOB_TABLE: return "out of bounds table access";
+	case WASM_RT_TRAP_DIV_BY_ZERO: return "integer divide by zero";
+	case WASM_RT_TRAP_INT_OVERFLOW: return "integer overflow";
+	case WASM_RT_TRAP_INVALID_CONVERSION: return "invalid conversion to integer";
+	case WASM_RT_TRAP_CALL_INDIRECT: return "indirect call type mismatch";
+	case WASM_RT_TRAP_UNDEFINED_ELEMENT: return "undefined element";
+	case WASM_RT_TRAP_UNINITIALIZED_ELEMENT: return "uninitialized element";
+	case WASM_RT_TRAP_EXHAUSTION: return "call stack exhausted";
+	case WASM_RT_TRAP_OUT_OF_MEMORY: return "out of memory";
+	case WASM_RT_TRAP_HOST: return "aborted by the host";
+	}
+	return "unknown trap";
+}
+
+/* wasm_rt_state holds the state of the calls into an instance. */
+typedef struct wasm_rt_state {
+	jmp_buf *jb; /* target of the traps */
+	int trap;    /* last trap */
+	int depth;   /* depth of the calls */
+} wasm_rt_state;
+
+/* wasm_rt_memory is a linear memory. */
+typedef struct wasm_rt_memory {
+	uint8_t *data;
+	uint64_t size; /* size in bytes */
+	uint32_t pages, max_pages;
+} wasm_rt_memory;
+
+typedef void (*wasm_rt_func)(void);
+
+/* wasm_rt_elem is an element of a table: a function and the index of its
+   type, or a null function. */
+typedef struct wasm_rt_elem {
+	uint32_t type;
+	wasm_rt_func func;
+} wasm_rt_elem;
+
+typedef struct wasm_rt_table {
+	wasm_rt_elem *data;
+	uint32_t size;
+} wasm_rt_table;
+
+/* wasm_rt_trap aborts the execution of the current call into the
+   instance. It may be called by imported functions. */
+WASM_RT_NORETURN static inline void wasm_rt_trap(wasm_rt_state *s, int trap) {
+	s->trap = trap;
+	longjmp(*s->jb, 1);
+}
+
+/* wasm_rt_enter records a call, or traps if the calls are too deep. */
+static inline void wasm_rt_enter(wasm_rt_state *s) {
+	if (++s->depth > WASM_RT_MAX_CALL_DEPTH) {
+		wasm_rt_trap(s, WASM_RT_TRAP_EXHAUSTION);
+	}
+}
+
+static inline void wasm_rt_init_memory(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t pages, uint32_t max_pages) {
+	mem->size = (uint64_t)pages * WASM_RT_PAGE_SIZE;
+	mem->data = calloc(mem->size ? (size_t)mem->size : 1, 1);
+	if (mem->data == NULL) {
+		wasm_rt_trap(s, WASM_RT_TRAP_OUT_OF_MEMORY);
+	}
+	mem->pages = pages;
+	mem->max_pages = max_pages;
+}
+
+/* wasm_rt_grow grows the memory by delta pages and returns its previous
+   size, or 0xffffffff if it cannot grow. */
+static inline uint32_t wasm_rt_grow(wasm_rt_memory *mem, uint32_t delta) {
+	uint32_t old = mem->pages;
+	uint64_t size;
+	uint8_t *data;
+	if (delta > mem->max_pages - old) {
+		return 0xffffffff;
+	}
+	size = (uint64_t)(old + delta) * WASM_RT_PAGE_SIZE;
+	if (size > (size_t)-1) {
+		return 0xffffffff;
+	}
+	data = realloc(mem->data, size ? (size_t)size : 1);
+	if (data == NULL) {
+		return 0xffffffff;
+	}
+	memset(data + mem->size, 0, (size_t)(size - mem->size));
+	mem->data = data;
+	mem->size = size;
+	mem->pages = old + delta;
+	return old;
+}
+
+static inline void wasm_rt_init_data(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t off, const uint8_t *data, uint32_t n) {
+	if ((uint64_t)off + n > mem->size) {
+		wasm_rt_trap(s, WASM_RT_TRAP_OOB_MEMORY);
+	}
+	if (n > 0) {
+		memcpy(mem->data + off, data, n);
+	}
+}
+
+static inline void wasm_rt_init_table(wasm_rt_state *s, wasm_rt_table *t, uint32_t size) {
+	t->data = calloc(size ? size : 1, sizeof(wasm_rt_elem));
+	if (t->data == NULL) {
+		wasm_rt_trap(s, WASM_RT_TRAP_OUT_OF_MEMORY);
+	}
+	t->size = size;
+}
+
+static inline void wasm_rt_init_elems(wasm_rt_state *s, wasm_rt_table *t, uint32_t off, const wasm_rt_elem *elems, uint32_t n) {
+	if ((uint64_t)off + n > t->size) {
+		wasm_rt_trap(s, WASM_RT_TRAP_OOB_TABLE);
+	}
+	if (n > 0) {
+		memcpy(t->data + off, elems, n * sizeof(wasm_rt_elem));
+	}
+}
+
+/* wasm_rt_elem_func returns the function of the i-th element of the
+   table, or traps if it is undefined or not of the given type. */
+static inline wasm_rt_func wasm_rt_elem_func(wasm_rt_state *s, wasm_rt_table *t, uint32_t i, uint32_t type) {
+	if (i >= t->size) {
+		wasm_rt_trap(s, WASM_RT_TRAP_UNDEFINED_ELEMENT);
+	}
+	if (t->data[i].func == NULL) {
+		wasm_rt_trap(s, WASM_RT_TRAP_UNINITIALIZED_ELEMENT);
+	}
+	if (t->data[i].type != type) {
+		wasm_rt_trap(s, WASM_RT_TRAP_CALL_INDIRECT);
+	}
+	return t->data[i].func;
+}
+
+/* wasm_rt_addr returns the address of an access of n bytes at the address
+   a plus the offset off, or traps if it is out of bounds. */
+static inline uint8_t *wasm_rt_addr(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint32_t n) {
+	uint64_t ea = (uint64_t)a + off;
+	if (ea + n > mem->size) {
+		wasm_rt_trap(s, WASM_RT_TRAP_OOB_MEMORY);
+	}
+	return mem->data + ea;
+}
+
+/* the memory is little-endian, whatever the byte order of the host. */
+static inline uint16_t wasm_rt_get16(const uint8_t *p) {
+	return (uint16_t)(p[0] | p[1] << 8);
+}
+
+static inline uint32_t wasm_rt_get32(const uint8_t *p) {
+	return (uint32_t)p[0] | (uint32_t)p[1] << 8 | (uint32_t)p[2] << 16 | (uint32_t)p[3] << 24;
+}
+
+static inline uint64_t wasm_rt_get64(const uint8_t *p) {
+	return (uint64_t)wasm_rt_get32(p) | (uint64_t)wasm_rt_get32(p + 4) << 32;
+}
+
+static inline void wasm_rt_put16(uint8_t *p, uint16_t v) {
+	p[0] = (uint8_t)v;
+	p[1] = (uint8_t)(v >> 8);
+}
+
+static inline void wasm_rt_put32(uint8_t *p, uint32_t v) {
+	wasm_rt_put16(p, (uint16_t)v);
+	wasm_rt_put16(p + 2, (uint16_t)(v >> 16));
+}
+
+static inline void wasm_rt_put64(uint8_t *p, uint64_t v) {
+	wasm_rt_put32(p, (uint32_t)v);
+	wasm_rt_put32(p + 4, (uint32_t)(v >> 32));
+}
+
+static inline uint32_t wasm_rt_f32_bits(float v) {
+	uint32_t u;
+	memcpy(&u, &v, sizeof u);
+	return u;
+}
+
+static inline uint64_t wasm_rt_f64_bits(double v) {
+	uint64_t u;
+	memcpy(&u, &v, sizeof u);
+	return u;
+}
+
+static inline float wasm_rt_f32_from_bits(uint32_t u) {
+	float v;
+	memcpy(&v, &u, sizeof v);
+	return v;
+}
+
+static inline double wasm_rt_f64_from_bits(uint64_t u) {
+	double v;
+	memcpy(&v, &u, sizeof v);
+	return v;
+}
+
+#define WASM_RT_ADDR(n) wasm_rt_addr(s, mem, a, off, n)
+
+static inline uint32_t wasm_rt_i32_load(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_get32(WASM_RT_ADDR(4)); }
+static inline uint64_t wasm_rt_i64_load(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_get64(WASM_RT_ADDR(8)); }
+static inline float wasm_rt_f32_load(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_f32_from_bits(wasm_rt_get32(WASM_RT_ADDR(4))); }
+static inline double wasm_rt_f64_load(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_f64_from_bits(wasm_rt_get64(WASM_RT_ADDR(8))); }
+static inline uint32_t wasm_rt_i32_load8_s(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return (uint32_t)(int32_t)(int8_t)*WASM_RT_ADDR(1); }
+static inline uint32_t wasm_rt_i32_load8_u(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return *WASM_RT_ADDR(1); }
+static inline uint32_t wasm_rt_i32_load16_s(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return (uint32_t)(int32_t)(int16_t)wasm_rt_get16(WASM_RT_ADDR(2)); }
+static inline uint32_t wasm_rt_i32_load16_u(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_get16(WASM_RT_ADDR(2)); }
+static inline uint64_t wasm_rt_i64_load8_s(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return (uint64_t)(int64_t)(int8_t)*WASM_RT_ADDR(1); }
+static inline uint64_t wasm_rt_i64_load8_u(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return *WASM_RT_ADDR(1); }
+static inline uint64_t wasm_rt_i64_load16_s(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return (uint64_t)(int64_t)(int16_t)wasm_rt_get16(WASM_RT_ADDR(2)); }
+static inline uint64_t wasm_rt_i64_load16_u(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_get16(WASM_RT_ADDR(2)); }
+static inline uint64_t wasm_rt_i64_load32_s(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return (uint64_t)(int64_t)(int32_t)wasm_rt_get32(WASM_RT_ADDR(4)); }
+static inline uint64_t wasm_rt_i64_load32_u(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off) { return wasm_rt_get32(WASM_RT_ADDR(4)); }
+
+static inline void wasm_rt_i32_store(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint32_t v) { wasm_rt_put32(WASM_RT_ADDR(4), v); }
+static inline void wasm_rt_i64_store(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint64_t v) { wasm_rt_put64(WASM_RT_ADDR(8), v); }
+static inline void wasm_rt_f32_store(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, float v) { wasm_rt_put32(WASM_RT_ADDR(4), wasm_rt_f32_bits(v)); }
+static inline void wasm_rt_f64_store(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, double v) { wasm_rt_put64(WASM_RT_ADDR(8), wasm_rt_f64_bits(v)); }
+static inline void wasm_rt_i32_store8(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint32_t v) { *WASM_RT_ADDR(1) = (uint8_t)v; }
+static inline void wasm_rt_i32_store16(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint32_t v) { wasm_rt_put16(WASM_RT_ADDR(2), (uint16_t)v); }
+static inline void wasm_rt_i64_store8(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint64_t v) { *WASM_RT_ADDR(1) = (uint8_t)v; }
+static inline void wasm_rt_i64_store16(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint64_t v) { wasm_rt_put16(WASM_RT_ADDR(2), (uint16_t)v); }
+static inline void wasm_rt_i64_store32(wasm_rt_state *s, wasm_rt_memory *mem, uint32_t a, uint32_t off, uint64_t v) { wasm_rt_put32(WASM_RT_ADDR(4), (uint32_t)v); }
+
+#undef WASM_RT_ADDR
+
+static inline uint32_t wasm_rt_i32_div_s(wasm_rt_state *s, uint32_t a, uint32_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	if (a == 0x80000000 && b == 0xffffffff) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INT_OVERFLOW);
+	}
+	return (uint32_t)((int32_t)a / (int32_t)b);
+}
+
+static inline uint32_t wasm_rt_i32_div_u(wasm_rt_state *s, uint32_t a, uint32_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	return a / b;
+}
+
+static inline uint32_t wasm_rt_i32_rem_s(wasm_rt_state *s, uint32_t a, uint32_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	if (b == 0xffffffff) {
+		return 0;
+	}
+	return (uint32_t)((int32_t)a % (int32_t)b);
+}
+
+static inline uint32_t wasm_rt_i32_rem_u(wasm_rt_state *s, uint32_t a, uint32_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	return a % b;
+}
+
+static inline uint64_t wasm_rt_i64_div_s(wasm_rt_state *s, uint64_t a, uint64_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	if (a == 0x8000000000000000 && b == 0xffffffffffffffff) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INT_OVERFLOW);
+	}
+	return (uint64_t)((int64_t)a / (int64_t)b);
+}
+
+static inline uint64_t wasm_rt_i64_div_u(wasm_rt_state *s, uint64_t a, uint64_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	return a / b;
+}
+
+static inline uint64_t wasm_rt_i64_rem_s(wasm_rt_state *s, uint64_t a, uint64_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	if (b == 0xffffffffffffffff) {
+		return 0;
+	}
+	return (uint64_t)((int64_t)a % (int64_t)b);
+}
+
+static inline uint64_t wasm_rt_i64_rem_u(wasm_rt_state *s, uint64_t a, uint64_t b) {
+	if (b == 0) {
+		wasm_rt_trap(s, WASM_RT_TRAP_DIV_BY_ZERO);
+	}
+	return a % b;
+}
+
+static inline uint32_t wasm_rt_i32_shr_s(uint32_t a, uint32_t b) {
+	b &= 31;
+	return a & 0x80000000 ? ~(~a >> b) : a >> b;
+}
+
+static inline uint64_t wasm_rt_i64_shr_s(uint64_t a, uint64_t b) {
+	b &= 63;
+	return a & 0x8000000000000000 ? ~(~a >> b) : a >> b;
+}
+
+static inline uint32_t wasm_rt_i32_rotl(uint32_t a, uint32_t b) { b &= 31; return a << b | a >> ((32 - b) & 31); }
+static inline uint32_t wasm_rt_i32_rotr(uint32_t a, uint32_t b) { b &= 31; return a >> b | a << ((32 - b) & 31); }
+static inline uint64_t wasm_rt_i64_rotl(uint64_t a, uint64_t b) { b &= 63; return a << b | a >> ((64 - b) & 63); }
+static inline uint64_t wasm_rt_i64_rotr(uint64_t a, uint64_t b) { b &= 63; return a >> b | a << ((64 - b) & 63); }
+
+static inline uint32_t wasm_rt_i32_clz(uint32_t v) {
+	uint32_t n = 0;
+	if (v == 0) {
+		return 32;
+	}
+	while (!(v & 0x80000000)) {
+		n++;
+		v <<= 1;
+	}
+	return n;
+}
+
+static inline uint32_t wasm_rt_i32_ctz(uint32_t v) {
+	uint32_t n = 0;
+	if (v == 0) {
+		return 32;
+	}
+	while (!(v & 1)) {
+		n++;
+		v >>= 1;
+	}
+	return n;
+}
+
+static inline uint32_t wasm_rt_i32_popcnt(uint32_t v) {
+	uint32_t n = 0;
+	for (; v != 0; v &= v - 1) {
+		n++;
+	}
+	return n;
+}
+
+static inline uint64_t wasm_rt_i64_clz(uint64_t v) {
+	return v >> 32 ? wasm_rt_i32_clz((uint32_t)(v >> 32)) : 32 + wasm_rt_i32_clz((uint32_t)v);
+}
+
+static inline uint64_t wasm_rt_i64_ctz(uint64_t v) {
+	return (uint32_t)v ? wasm_rt_i32_ctz((uint32_t)v) : 32 + wasm_rt_i32_ctz((uint32_t)(v >> 32));
+}
+
+static inline uint64_t wasm_rt_i64_popcnt(uint64_t v) {
+	return wasm_rt_i32_popcnt((uint32_t)v) + wasm_rt_i32_popcnt((uint32_t)(v >> 32));
+}
+
+static inline float wasm_rt_f32_abs(float v) { return wasm_rt_f32_from_bits(wasm_rt_f32_bits(v) & 0x7fffffff); }
+static inline float wasm_rt_f32_neg(float v) { return wasm_rt_f32_from_bits(wasm_rt_f32_bits(v) ^ 0x80000000); }
+static inline double wasm_rt_f64_abs(double v) { return wasm_rt_f64_from_bits(wasm_rt_f64_bits(v) & 0x7fffffffffffffff); }
+static inline double wasm_rt_f64_neg(double v) { return wasm_rt_f64_from_bits(wasm_rt_f64_bits(v) ^ 0x8000000000000000); }
+
+static inline float wasm_rt_f32_copysign(float a, float b) {
+	return wasm_rt_f32_from_bits((wasm_rt_f32_bits(a) & 0x7fffffff) | (wasm_rt_f32_bits(b) & 0x80000000));
+}
+
+static inline double wasm_rt_f64_copysign(double a, double b) {
+	return wasm_rt_f64_from_bits((wasm_rt_f64_bits(a) & 0x7fffffffffffffff) | (wasm_rt_f64_bits(b) & 0x8000000000000000));
+}
+
+/* the min and max instructions return NaN if either operand is NaN, and
+   -0 is less than +0. */
+static inline double wasm_rt_fmin(double a, double b) {
+	if (a != a || b != b) {
+		return NAN;
+	}
+	if (a == b) {
+		return signbit(a) ? a : b;
+	}
+	return a < b ? a : b;
+}
+
+static inline double wasm_rt_fmax(double a, double b) {
+	if (a != a || b != b) {
+		return NAN;
+	}
+	if (a == b) {
+		return signbit(a) ? b : a;
+	}
+	return a > b ? a : b;
+}
+
+/* wasm_rt_trunc_s truncates v to a signed integer in the range [min, max),
+   or traps. */
+static inline int64_t wasm_rt_trunc_s(wasm_rt_state *s, double v, double min, double max) {
+	if (v != v) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INVALID_CONVERSION);
+	}
+	v = trunc(v);
+	if (v < min || v >= max) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INT_OVERFLOW);
+	}
+	return (int64_t)v;
+}
+
+/* wasm_rt_trunc_u truncates v to an unsigned integer in the range
+   [0, max), or traps. */
+static inline uint64_t wasm_rt_trunc_u(wasm_rt_state *s, double v, double max) {
+	if (v != v) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INVALID_CONVERSION);
+	}
+	v = trunc(v);
+	if (v <= -1 || v >= max) {
+		wasm_rt_trap(s, WASM_RT_TRAP_INT_OVERFLOW);
+	}
+	return (uint64_t)v;
+}
+
+#endif /* WASM_RT_H */
+`
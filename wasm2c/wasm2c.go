@@ -0,0 +1,514 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasm2c translates WebAssembly modules to portable C source code.
+//
+// A module is translated to a header, <prefix>.h, declaring its API, and a
+// source file, <prefix>.c, implementing it, where prefix is the prefix of
+// the names of the generated code. Both include the runtime header,
+// wasm-rt.h, returned by the Runtime function:
+//
+//   - an instance of the module is a struct <prefix>, initialized by the
+//     function <prefix>_init and freed by <prefix>_free;
+//   - its imports are provided by the caller, through the function
+//     pointers and the values of a struct <prefix>_imports;
+//   - its exported functions, memories and globals become functions named
+//     <prefix>_<export name>.
+//
+// The exported functions return 0, or the trap aborting their execution,
+// described by wasm_rt_trap_string. Traps are implemented with setjmp and
+// longjmp. Imported functions may abort their caller by calling
+// wasm_rt_trap(&m->rt, WASM_RT_TRAP_HOST).
+//
+// The generated code is C99, and must be compiled without contracting the
+// floating-point operations, e.g. with -ffp-contract=off. The modules
+// must use only the features of the MVP, and may not import memories or
+// tables.
+package wasm2c
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/translate"
+)
+
+// Options configures the translation of a module.
+type Options struct {
+	// Prefix is the prefix of the names of the generated code, "module"
+	// by default. It must be a C identifier.
+	Prefix string
+}
+
+// Output holds the C code of a translated module.
+type Output struct {
+	Header []byte // <prefix>.h
+	Source []byte // <prefix>.c
+}
+
+// Runtime returns the content of the runtime header, wasm-rt.h, included
+// by the generated code.
+func Runtime() []byte { return []byte(runtime) }
+
+// generator translates a module.
+type generator struct {
+	hdr bytes.Buffer
+	src bytes.Buffer
+
+	prefix string
+
+	*translate.Module
+
+	api      map[string]bool   // names of the functions of the API
+	hosts    map[string]bool   // names of the fields of the imports
+	hfuncs   map[uint32]string // fields of the imports, by imported function index
+	hglobals map[uint32]string // fields of the imports, by imported global index
+}
+
+// Translate translates the module m to C code.
+func Translate(m *wasm.Module, opts Options) (*Output, error) {
+	if err := wasm.Validate(m, wasm.FeaturesMVP); err != nil {
+		return nil, err
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "module"
+	}
+	if ident(prefix) != prefix {
+		return nil, fmt.Errorf("wasm2c: invalid prefix %q", prefix)
+	}
+
+	mod, err := translate.NewModule(m)
+	if err != nil {
+		return nil, fmt.Errorf("wasm2c: %w", err)
+	}
+	g := &generator{
+		Module:   mod,
+		prefix:   prefix,
+		api:      make(map[string]bool),
+		hosts:    map[string]bool{"ctx": true},
+		hfuncs:   make(map[uint32]string),
+		hglobals: make(map[uint32]string),
+	}
+	for _, name := range []string{"imports", "init", "free"} {
+		g.api[prefix+"_"+name] = true
+	}
+	guard := strings.ToUpper(prefix) + "_H"
+	g.hprintf("/* Code generated by wasm2c. DO NOT EDIT. */\n\n")
+	g.hprintf("#ifndef %s\n#define %s\n\n", guard, guard)
+	g.hprintf("#include %q\n\n", RuntimeHeader)
+	g.hprintf("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	g.hprintf("typedef struct %s %s;\n\n", prefix, prefix)
+	g.genImports()
+	g.genModule()
+
+	g.printf("/* Code generated by wasm2c. DO NOT EDIT. */\n\n")
+	g.printf("#include %q\n\n", prefix+".h")
+	g.genPrototypes()
+	if err := g.genInit(); err != nil {
+		return nil, err
+	}
+	g.genExports()
+	if err := g.genFuncs(); err != nil {
+		return nil, err
+	}
+
+	g.hprintf("#ifdef __cplusplus\n}\n#endif\n\n")
+	g.hprintf("#endif /* %s */\n", guard)
+	return &Output{Header: g.hdr.Bytes(), Source: g.src.Bytes()}, nil
+}
+
+// printf writes to the source file.
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.src, format, args...)
+}
+
+// hprintf writes to the header.
+func (g *generator) hprintf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.hdr, format, args...)
+}
+
+// genImports generates the struct of the imports.
+func (g *generator) genImports() {
+	p := g.prefix
+	g.hprintf("/* %s_imports provides the imports of the module. */\n", p)
+	g.hprintf("typedef struct %s_imports {\n", p)
+	g.hprintf("\tvoid *ctx; /* context of the imported functions */\n")
+	var fn, gl uint32
+	for _, e := range g.Imports {
+		name := translate.Unique(g.hosts, ident(e.Module+"_"+e.Field))
+		switch e.Kind {
+		case wasm.FunctionKind:
+			ft := g.Types[e.Type.(uint32)]
+			g.hprintf("\t/* %s implements the function %s imported from %s. */\n", name, quote(e.Field), quote(e.Module))
+			g.hprintf("\t%s (*%s)(%s *m%s);\n", g.apiResult(ft), name, p, apiParams(ft.Params))
+			g.hfuncs[fn] = name
+			fn++
+		case wasm.GlobalKind:
+			gt := e.Type.(wasm.GlobalType)
+			g.hprintf("\t/* %s is the value of the global %s imported from %s. */\n", name, quote(e.Field), quote(e.Module))
+			g.hprintf("\t%s %s;\n", apiType(gt.ContentType), name)
+			g.hglobals[gl] = name
+			gl++
+		}
+	}
+	g.hprintf("} %s_imports;\n\n", p)
+}
+
+// genModule generates the struct of the instances, and the declarations of
+// their initialization.
+func (g *generator) genModule() {
+	p := g.prefix
+	g.hprintf("/* %s is an instance of the module. */\n", p)
+	g.hprintf("struct %s {\n", p)
+	g.hprintf("\tconst %s_imports *imports;\n", p)
+	g.hprintf("\twasm_rt_state rt;\n")
+	g.hprintf("\twasm_rt_memory mem;\n")
+	g.hprintf("\twasm_rt_table table;\n")
+	for i, gt := range g.Globals {
+		g.hprintf("\t%s %s;\n", cType(gt.ContentType), translate.GlobalName(uint32(i)))
+	}
+	g.hprintf("};\n\n")
+
+	g.hprintf("/* %s_init instantiates the module in m with the given imports, which\n", p)
+	g.hprintf("   must outlive the instance, then runs its start function, if any.\n")
+	g.hprintf("   It returns 0, or the trap aborting the instantiation. */\n")
+	g.hprintf("int %s_init(%s *m, const %s_imports *imports);\n\n", p, p, p)
+	g.hprintf("/* %s_free frees the resources of the instance m. */\n", p)
+	g.hprintf("void %s_free(%s *m);\n\n", p, p)
+}
+
+// genPrototypes generates the declarations of the functions of the
+// module, and its data and element segments.
+func (g *generator) genPrototypes() {
+	for i, ft := range g.Funcs {
+		g.printf("static %s;\n", g.prototype(translate.FuncName(uint32(i)), ft))
+	}
+	g.printf("\n")
+	for i, ds := range g.Data {
+		if len(ds.Data) == 0 {
+			continue
+		}
+		g.printf("static const uint8_t data%d[] = {", i)
+		for j, b := range ds.Data {
+			if j%16 == 0 {
+				g.printf("\n\t")
+			} else {
+				g.printf(" ")
+			}
+			g.printf("0x%02x,", b)
+		}
+		g.printf("\n};\n\n")
+	}
+	for i, es := range g.Elems {
+		if len(es.Elems) == 0 {
+			continue
+		}
+		g.printf("static const wasm_rt_elem elems%d[] = {\n", i)
+		for _, idx := range es.Elems {
+			g.printf("\t{%d, (wasm_rt_func)%s},\n", g.typeID(g.FuncTypes[idx]), translate.FuncName(idx))
+		}
+		g.printf("};\n\n")
+	}
+}
+
+// genInit generates the initialization of the instances.
+func (g *generator) genInit() error {
+	p := g.prefix
+	g.printf("int %s_init(%s *m, const %s_imports *imports) {\n", p, p, p)
+	g.printf("\tjmp_buf jb;\n")
+	g.printf("\tmemset(m, 0, sizeof *m);\n")
+	g.printf("\tm->imports = imports;\n")
+	g.printf("\tm->rt.jb = &jb;\n")
+	g.printf("\tif (setjmp(jb) != 0) {\n")
+	g.printf("\t\t%s_free(m);\n", p)
+	g.printf("\t\treturn m->rt.trap;\n")
+	g.printf("\t}\n")
+	if g.Mem != nil {
+		max := uint64(65536)
+		if g.Mem.Limits.Flags&wasm.LimitsMax != 0 {
+			max = g.Mem.Limits.Maximum
+		}
+		g.printf("\twasm_rt_init_memory(&m->rt, &m->mem, %d, %d);\n", g.Mem.Limits.Initial, max)
+	}
+	var nimported uint32
+	for _, e := range g.Imports {
+		if e.Kind == wasm.GlobalKind {
+			gt := g.Globals[nimported]
+			t := gt.ContentType
+			g.printf("\tm->%s = %s;\n", translate.GlobalName(nimported), convert(cType(t), apiType(t), "imports->"+g.hglobals[nimported]))
+			nimported++
+		}
+	}
+	for i, gv := range g.Inits {
+		v, err := g.constExpr(gv.Init)
+		if err != nil {
+			return err
+		}
+		g.printf("\tm->%s = %s;\n", translate.GlobalName(nimported+uint32(i)), v)
+	}
+	if g.Table != nil {
+		g.printf("\twasm_rt_init_table(&m->rt, &m->table, %d);\n", g.Table.Limits.Initial)
+	}
+	for i, es := range g.Elems {
+		off, err := g.constExpr(es.Offset)
+		if err != nil {
+			return err
+		}
+		elems := "NULL"
+		if len(es.Elems) > 0 {
+			elems = fmt.Sprintf("elems%d", i)
+		}
+		g.printf("\twasm_rt_init_elems(&m->rt, &m->table, %s, %s, %d);\n", off, elems, len(es.Elems))
+	}
+	for i, ds := range g.Data {
+		off, err := g.constExpr(ds.Offset)
+		if err != nil {
+			return err
+		}
+		data := "NULL"
+		if len(ds.Data) > 0 {
+			data = fmt.Sprintf("data%d", i)
+		}
+		g.printf("\twasm_rt_init_data(&m->rt, &m->mem, %s, %s, %d);\n", off, data, len(ds.Data))
+	}
+	if g.Start != nil {
+		g.printf("\t%s(m);\n", translate.FuncName(*g.Start))
+	}
+	g.printf("\tm->rt.jb = NULL;\n")
+	g.printf("\treturn 0;\n")
+	g.printf("}\n\n")
+
+	g.printf("void %s_free(%s *m) {\n", p, p)
+	g.printf("\tfree(m->mem.data);\n")
+	g.printf("\tfree(m->table.data);\n")
+	g.printf("\tm->mem.data = NULL;\n")
+	g.printf("\tm->mem.size = 0;\n")
+	g.printf("\tm->mem.pages = 0;\n")
+	g.printf("\tm->table.data = NULL;\n")
+	g.printf("\tm->table.size = 0;\n")
+	g.printf("}\n\n")
+	return nil
+}
+
+// genExports generates the functions of the exports of the module.
+func (g *generator) genExports() {
+	p := g.prefix
+	exports := append([]wasm.ExportEntry(nil), g.Exports...)
+	sort.SliceStable(exports, func(i, j int) bool { return exports[i].Field < exports[j].Field })
+	for _, e := range exports {
+		name := translate.Unique(g.api, ident(p+"_"+e.Field))
+		switch e.Kind {
+		case wasm.FunctionKind:
+			ft := g.Funcs[e.Index]
+			args := []string{"m"}
+			for i, t := range ft.Params {
+				args = append(args, convert(cType(t), apiType(t), translate.LocalName(i)))
+			}
+			decl := fmt.Sprintf("int %s(%s *m%s", name, p, apiParams(ft.Params))
+			if len(ft.Results) > 0 {
+				decl += fmt.Sprintf(", %s *result", apiType(ft.Results[0]))
+			}
+			decl += ")"
+			g.hprintf("/* %s calls the function exported as %s.\n", name, quote(e.Field))
+			g.hprintf("   It returns 0, or the trap aborting its execution. */\n")
+			g.hprintf("%s;\n\n", decl)
+
+			g.printf("%s {\n", decl)
+			g.printf("\tjmp_buf jb, *prev = m->rt.jb;\n")
+			g.printf("\tint depth = m->rt.depth;\n")
+			if len(ft.Results) > 0 {
+				g.printf("\t%s r;\n", cType(ft.Results[0]))
+			}
+			g.printf("\tm->rt.jb = &jb;\n")
+			g.printf("\tif (setjmp(jb) != 0) {\n")
+			g.printf("\t\tm->rt.jb = prev;\n")
+			g.printf("\t\tm->rt.depth = depth;\n")
+			g.printf("\t\treturn m->rt.trap;\n")
+			g.printf("\t}\n")
+			if len(ft.Results) > 0 {
+				g.printf("\tr = %s(%s);\n", translate.FuncName(e.Index), strings.Join(args, ", "))
+			} else {
+				g.printf("\t%s(%s);\n", translate.FuncName(e.Index), strings.Join(args, ", "))
+			}
+			g.printf("\tm->rt.jb = prev;\n")
+			if len(ft.Results) > 0 {
+				g.printf("\tif (result != NULL) {\n")
+				t := ft.Results[0]
+				g.printf("\t\t*result = %s;\n", convert(apiType(t), cType(t), "r"))
+				g.printf("\t}\n")
+			}
+			g.printf("\treturn 0;\n")
+			g.printf("}\n\n")
+		case wasm.MemoryKind:
+			g.hprintf("/* %s returns the memory exported as %s, and stores its\n", name, quote(e.Field))
+			g.hprintf("   size in *size. The returned pointer is invalidated when the memory\n")
+			g.hprintf("   grows. */\n")
+			g.hprintf("uint8_t *%s(%s *m, uint64_t *size);\n\n", name, p)
+			g.printf("uint8_t *%s(%s *m, uint64_t *size) {\n", name, p)
+			g.printf("\t*size = m->mem.size;\n")
+			g.printf("\treturn m->mem.data;\n")
+			g.printf("}\n\n")
+		case wasm.GlobalKind:
+			t := g.Globals[e.Index].ContentType
+			g.hprintf("/* %s returns the value of the global exported as %s. */\n", name, quote(e.Field))
+			g.hprintf("%s %s(%s *m);\n\n", apiType(t), name, p)
+			g.printf("%s %s(%s *m) {\n", apiType(t), name, p)
+			g.printf("\treturn %s;\n", convert(apiType(t), cType(t), "m->"+translate.GlobalName(e.Index)))
+			g.printf("}\n\n")
+		}
+	}
+}
+
+// genFuncs generates the functions of the module.
+func (g *generator) genFuncs() error {
+	for i := 0; i < g.NumImports; i++ {
+		idx := uint32(i)
+		ft := g.Funcs[idx]
+		args := []string{"m"}
+		for j, t := range ft.Params {
+			args = append(args, convert(apiType(t), cType(t), translate.LocalName(j)))
+		}
+		call := fmt.Sprintf("m->imports->%s(%s)", g.hfuncs[idx], strings.Join(args, ", "))
+		g.printf("static %s {\n", g.prototype(translate.FuncName(idx), ft))
+		if len(ft.Results) > 0 {
+			t := ft.Results[0]
+			g.printf("\treturn %s;\n", convert(cType(t), apiType(t), call))
+		} else {
+			g.printf("\t%s;\n", call)
+		}
+		g.printf("}\n\n")
+	}
+	for i := range g.Bodies {
+		idx := uint32(g.NumImports + i)
+		src, err := g.genFunc(translate.FuncName(idx), i)
+		if err != nil {
+			return fmt.Errorf("wasm2c: function %d: %w", idx, err)
+		}
+		if name, ok := g.Names[idx]; ok {
+			g.printf("/* %s is the function %s. */\n", translate.FuncName(idx), quote(name))
+		}
+		g.printf("%s\n", src)
+	}
+	return nil
+}
+
+// prototype returns the declaration of the function name of type ft.
+func (g *generator) prototype(name string, ft wasm.FuncType) string {
+	ps := []string{g.prefix + " *m"}
+	for i, t := range ft.Params {
+		ps = append(ps, cType(t)+" "+translate.LocalName(i))
+	}
+	return fmt.Sprintf("%s %s(%s)", result(ft), name, strings.Join(ps, ", "))
+}
+
+// funcPtr returns the C type of the pointers to the functions of type ft.
+func (g *generator) funcPtr(ft wasm.FuncType) string {
+	ps := []string{g.prefix + " *"}
+	for _, t := range ft.Params {
+		ps = append(ps, cType(t))
+	}
+	return fmt.Sprintf("%s (*)(%s)", result(ft), strings.Join(ps, ", "))
+}
+
+// apiResult returns the C type of the result of the imported functions of
+// type ft.
+func (g *generator) apiResult(ft wasm.FuncType) string {
+	if len(ft.Results) == 0 {
+		return "void"
+	}
+	return apiType(ft.Results[0])
+}
+
+// typeID returns the index identifying the type of index i at run time:
+// the index of the first type equal to it.
+func (g *generator) typeID(i uint32) uint32 {
+	for j := uint32(0); j < i; j++ {
+		if equalTypes(g.Types[j], g.Types[i]) {
+			return j
+		}
+	}
+	return i
+}
+
+func equalTypes(a, b wasm.FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i, t := range a.Params {
+		if b.Params[i] != t {
+			return false
+		}
+	}
+	for i, t := range a.Results {
+		if b.Results[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// convert returns the conversion of the expression expr of the C type
+// from to the C type to.
+func convert(to, from, expr string) string {
+	if to == from {
+		return expr
+	}
+	return "(" + to + ")" + expr
+}
+
+// result returns the C type of the result of the functions of type ft.
+func result(ft wasm.FuncType) string {
+	if len(ft.Results) == 0 {
+		return "void"
+	}
+	return cType(ft.Results[0])
+}
+
+// apiParams returns the list of the parameters of types ts in the API,
+// named l0, l1..., each preceded by a comma.
+func apiParams(ts []wasm.ValueType) string {
+	var o strings.Builder
+	for i, t := range ts {
+		fmt.Fprintf(&o, ", %s %s", apiType(t), translate.LocalName(i))
+	}
+	return o.String()
+}
+
+// constExpr returns the C expression of the value of a constant
+// expression.
+func (g *generator) constExpr(ie wasm.InitExpr) (string, error) {
+	v, err := translate.ConstExpr(g, ie)
+	if err != nil {
+		return "", fmt.Errorf("wasm2c: %w", err)
+	}
+	return v, nil
+}
+
+// ident returns a C identifier derived from name, where the invalid
+// characters are replaced by underscores, prefixed by an underscore if it
+// would start with a digit.
+func ident(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || '0' <= b[0] && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// quote returns the quoted string s, for a C comment.
+func quote(s string) string {
+	return strings.ReplaceAll(strconv.Quote(s), "*/", "*\\/")
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2c_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	wexec "github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+	"github.com/sbinet/wasm/wasm2c"
+)
+
+// driver is the beginning of the main function of the program running
+// the test vectors with the generated code.
+const driver = `#include <stdio.h>
+
+#include "module.h"
+
+static int32_t add3(module *m, int32_t x) {
+	(void)m;
+	return x + 3;
+}
+
+static void show(int trap, uint64_t v) {
+	if (trap != 0) {
+		printf("trap: %s\n", wasm_rt_trap_string(trap));
+		return;
+	}
+	printf("0x%llx\n", (unsigned long long)v);
+}
+
+int main(void) {
+	module_imports imports = {0};
+	module m;
+	uint64_t size;
+	imports.env_add3 = add3;
+	imports.env_base = 2;
+	if (module_init(&m, &imports) != 0) {
+		return 1;
+	}
+	printf("\"%.5s\"\n", (const char *)module_memory(&m, &size) + 16);
+`
+
+func TestTranslate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the compilation of the generated code in short mode")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("C compiler not found")
+	}
+
+	m := wasmtest.Build(t, wasmtest.NewHostModule, wasmtest.NewModule)
+	out, err := wasm2c.Translate(m, wasm2c.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	inst, err := wexec.InstantiateWithOptions(ctx, m, wexec.InstantiateOptions{Imports: wasmtest.Imports()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f64 := func(v float64) uint64 { return math.Float64bits(v) }
+	vectors := []struct {
+		name string
+		args []uint64
+	}{
+		{"fac", []uint64{0}},
+		{"fac", []uint64{20}},
+		{"sum", []uint64{100}},
+		{"switch", []uint64{0}},
+		{"switch", []uint64{2}},
+		{"switch", []uint64{7}},
+		{"switch", []uint64{0xffffffff}},
+		{"swap", []uint64{1, 2}},
+		{"brvalue", []uint64{1}},
+		{"brvalue", []uint64{0}},
+		{"select", []uint64{1}},
+		{"select", []uint64{0}},
+		{"tee", []uint64{3}},
+		{"orminus", []uint64{4}},
+		{"orminus", []uint64{0}},
+		{"fib", []uint64{90}},
+		{"dead", nil},
+		{"load", []uint64{16}},
+		{"load", []uint64{28}},
+		{"load", []uint64{0xffffffff}},
+		{"store", []uint64{32, 0x12345678}},
+		{"dispatch", []uint64{0, 7, 3}},
+		{"dispatch", []uint64{1, 7, 3}},
+		{"dispatch", []uint64{2, 7, 3}},
+		{"dispatch", []uint64{3, 7, 3}},
+		{"dispatch", []uint64{4, 7, 3}},
+		{"incr", nil},
+		{"host", []uint64{36}},
+		{"grow", []uint64{1}},
+		{"grow", []uint64{1}},
+		{"load", []uint64{0x1ffff}},
+		{"trap", []uint64{0}},
+		{"trap", []uint64{1}},
+		{"ping", nil},
+		{"incr", nil},
+		{"divs", []uint64{7, 0xfffffffffffffffe}},
+		{"divs", []uint64{7, 0}},
+		{"divs", []uint64{1 << 63, 0xffffffffffffffff}},
+		{"remu", []uint64{7, 0xfffffffe}},
+		{"bits", []uint64{0x80000001, 33}},
+		{"fops", []uint64{f64(2), f64(-0.5)}},
+		{"fops", []uint64{f64(math.NaN()), f64(1)}},
+		{"trunc", []uint64{f64(-3.9)}},
+		{"trunc", []uint64{f64(3e9)}},
+		{"trunc", []uint64{f64(math.NaN())}},
+		{"conv", []uint64{0xffffffffffffffff}},
+		{"neg", []uint64{0}},
+		{"loopvalue", []uint64{5}},
+	}
+
+	var drv strings.Builder
+	drv.WriteString(driver)
+	for _, v := range vectors {
+		ft := inst.Function(v.name).Type()
+		args := []string{"&m"}
+		for i, a := range v.args {
+			switch ft.Params[i] {
+			case wasm.I32:
+				args = append(args, fmt.Sprintf("(int32_t)UINT32_C(%#x)", uint32(a)))
+			case wasm.I64:
+				args = append(args, fmt.Sprintf("(int64_t)UINT64_C(%#x)", a))
+			case wasm.F32:
+				args = append(args, fmt.Sprintf("wasm_rt_f32_from_bits(UINT32_C(%#x))", uint32(a)))
+			case wasm.F64:
+				args = append(args, fmt.Sprintf("wasm_rt_f64_from_bits(UINT64_C(%#x))", a))
+			}
+		}
+		fn := "module_" + v.name
+		if len(ft.Results) == 0 {
+			fmt.Fprintf(&drv, "\tshow(%s(%s), 0);\n", fn, strings.Join(args, ", "))
+			continue
+		}
+		var typ, bits string
+		switch ft.Results[0] {
+		case wasm.I32:
+			typ, bits = "int32_t", "(uint32_t)r"
+		case wasm.I64:
+			typ, bits = "int64_t", "(uint64_t)r"
+		case wasm.F32:
+			typ, bits = "float", "wasm_rt_f32_bits(r)"
+		case wasm.F64:
+			typ, bits = "double", "wasm_rt_f64_bits(r)"
+		}
+		fmt.Fprintf(&drv, "\t{\n\t\t%s r = 0;\n\t\tint trap = %s(%s, &r);\n\t\tshow(trap, %s);\n\t}\n", typ, fn, strings.Join(args, ", "), bits)
+	}
+	fmt.Fprintf(&drv, "\tshow(0, (uint32_t)module_counter(&m));\n")
+	fmt.Fprintf(&drv, "\tmodule_free(&m);\n\treturn 0;\n}\n")
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		wasm2c.RuntimeHeader: wasm2c.Runtime(),
+		"module.h":           out.Header,
+		"module.c":           out.Source,
+		"main.c":             []byte(drv.String()),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(cc, "-std=c99", "-O2", "-Wall", "-ffp-contract=off", "-o", "prog", "module.c", "main.c", "-lm")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not compile the generated code: %v\n%s\n%s", err, output, out.Source)
+	}
+	cmd = exec.Command(filepath.Join(dir, "prog"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("could not run the generated code: %v\n%s", err, stderr.Bytes())
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != len(vectors)+2 {
+		t.Fatalf("invalid output:\n%s", output)
+	}
+	if got, want := lines[0], `"hello"`; got != want {
+		t.Fatalf("invalid memory: got=%s, want=%s", got, want)
+	}
+	for i, v := range vectors {
+		got := lines[i+1]
+		res, err := inst.Call(ctx, v.name, v.args...)
+		switch {
+		case err != nil:
+			msg := strings.TrimPrefix(got, "trap: ")
+			if msg == got || !strings.Contains(err.Error(), msg) {
+				t.Errorf("%s%#x: got=%s, want trap %v", v.name, v.args, got, err)
+			}
+		default:
+			want := "0x0"
+			if len(res) > 0 {
+				want = fmt.Sprintf("%#x", res[0])
+				// the payloads of the NaNs are not specified.
+				var bits uint64
+				typ := inst.Function(v.name).Type().Results[0]
+				if _, err := fmt.Sscanf(got, "0x%x", &bits); err == nil && isNaN(typ, bits) && isNaN(typ, res[0]) {
+					continue
+				}
+			}
+			if got != want {
+				t.Errorf("%s%#x: got=%s, want=%s", v.name, v.args, got, want)
+			}
+		}
+	}
+	if got, want := lines[len(lines)-1], fmt.Sprintf("%#x", inst.Global("counter").Get()); got != want {
+		t.Errorf("invalid counter: got=%s, want=%s", got, want)
+	}
+}
+
+// isNaN reports whether the bits v of a value of type t are a NaN.
+func isNaN(t wasm.ValueType, v uint64) bool {
+	switch t {
+	case wasm.F32:
+		x := math.Float32frombits(uint32(v))
+		return x != x
+	case wasm.F64:
+		return math.IsNaN(math.Float64frombits(v))
+	}
+	return false
+}
+
+func TestTranslateUnsupported(t *testing.T) {
+	b := wasm.NewBuilder()
+	b.ImportMemory("env", "memory", wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wasm2c.Translate(m, wasm2c.Options{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported import of memory env.memory") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2go
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/translate"
+)
+
+// The generator is the Go back-end of the translation of the function
+// bodies.
+var _ translate.Backend = (*generator)(nil)
+
+// genFunc translates the body of the i-th defined function to the Go
+// method name.
+func (g *generator) genFunc(name string, i int) (string, error) {
+	f, err := g.Translate(g, i)
+	if err != nil {
+		return "", err
+	}
+	ft := g.Funcs[g.NumImports+i]
+
+	var o strings.Builder
+	fmt.Fprintf(&o, "func (m *Module) %s(%s)%s {\n", name, params(ft.Params), results(ft.Results))
+	var (
+		decls  []string
+		unread []string
+	)
+	for _, v := range f.Vars() {
+		decls = append(decls, v.Name+" "+goType(v.Type))
+		if !v.Read {
+			unread = append(unread, v.Name)
+		}
+	}
+	if len(decls) > 0 {
+		fmt.Fprintf(&o, "\tvar (\n\t\t%s\n\t)\n", strings.Join(decls, "\n\t\t"))
+	}
+	for _, v := range unread {
+		fmt.Fprintf(&o, "\t_ = %s\n", v)
+	}
+	o.WriteString("\tm.enter()\n")
+	for _, l := range f.Lines() {
+		o.WriteString(l)
+		o.WriteByte('\n')
+	}
+	o.WriteString("}\n")
+	return o.String(), nil
+}
+
+func (g *generator) Stmt(s string) string       { return s }
+func (g *generator) If(cond string) string      { return "if " + cond + " != 0 {" }
+func (g *generator) Switch(x string) string     { return "switch " + x + " {" }
+func (g *generator) Label(name string) string   { return name + ":" }
+func (g *generator) Global(idx uint32) string   { return "m." + translate.GlobalName(idx) }
+func (g *generator) MemorySize() string         { return "int32(len(m.mem) / pageSize)" }
+func (g *generator) MemoryGrow(d string) string { return "m.grow(" + d + ")" }
+
+func (g *generator) Unreachable() string {
+	return fmt.Sprintf("panic(Trap(%q))", "unreachable executed")
+}
+
+func (g *generator) Abort() string { return fmt.Sprintf("panic(%q)", "unreachable") }
+
+func (g *generator) Return(v string) []string {
+	if v == "" {
+		return []string{"m.depth--", "return"}
+	}
+	return []string{"m.depth--", "return " + v}
+}
+
+func (g *generator) Call(idx uint32, args []string) string {
+	return fmt.Sprintf("m.%s(%s)", translate.FuncName(idx), strings.Join(args, ", "))
+}
+
+func (g *generator) CallIndirect(f *translate.Func, idx uint32, elem string, args []string) {
+	ft := g.Types[idx]
+	f.Emit("if f, ok := m.elem(%s).(%s); ok {", elem, funcType(ft))
+	f.Indent(1)
+	f.Call(ft.Results, "f("+strings.Join(args, ", ")+")")
+	f.Indent(-1)
+	f.Emit("} else {")
+	f.Emit("\tpanic(Trap(%q))", "indirect call type mismatch")
+	f.Emit("}")
+}
+
+func (g *generator) Select(f *translate.Func, dst, cond, a, b string) {
+	if a == dst {
+		f.Emit("if %s == 0 {", cond)
+		f.Emit("\t%s = %s", dst, b)
+		f.Emit("}")
+		return
+	}
+	f.Emit("if %s != 0 {", cond)
+	f.Emit("\t%s = %s", dst, a)
+	f.Emit("} else {")
+	f.Emit("\t%s = %s", dst, b)
+	f.Emit("}")
+}
+
+func (g *generator) Const(t wasm.ValueType, v uint64) string {
+	switch t {
+	case wasm.I32:
+		return strconv.Itoa(int(int32(v)))
+	case wasm.I64:
+		return strconv.FormatInt(int64(v), 10)
+	case wasm.F32:
+		return f32Const(uint32(v))
+	}
+	return f64Const(v)
+}
+
+func (g *generator) Load(op wasm.Opcode, addr string, off uint32) string {
+	return fmt.Sprintf("m.%s(%s, %d)", accesses[op], addr, off)
+}
+
+func (g *generator) Store(op wasm.Opcode, addr string, off uint32, v string) string {
+	return fmt.Sprintf("m.%s(%s, %d, %s)", accesses[op], addr, off, v)
+}
+
+func (g *generator) Numeric(op wasm.Opcode) (translate.Numeric, bool) {
+	n, ok := numerics[op]
+	return translate.Numeric{Type: n.typ, N: n.n, Expr: n.expr}, ok
+}
+
+func goType(t wasm.ValueType) string {
+	switch t {
+	case wasm.I32:
+		return "int32"
+	case wasm.I64:
+		return "int64"
+	case wasm.F32:
+		return "float32"
+	case wasm.F64:
+		return "float64"
+	}
+	panic(fmt.Errorf("wasm2go: invalid value type %v", t))
+}
+
+// params returns the list of the parameters of types ts, named l0, l1...
+func params(ts []wasm.ValueType) string {
+	ps := make([]string, len(ts))
+	for i, t := range ts {
+		ps[i] = translate.LocalName(i) + " " + goType(t)
+	}
+	return strings.Join(ps, ", ")
+}
+
+// results returns the result list of a function whose results have types
+// ts.
+func results(ts []wasm.ValueType) string {
+	if len(ts) == 0 {
+		return ""
+	}
+	return " " + goType(ts[0])
+}
+
+// funcType returns the Go type of the functions of type ft.
+func funcType(ft wasm.FuncType) string {
+	ps := make([]string, len(ft.Params))
+	for i, t := range ft.Params {
+		ps[i] = goType(t)
+	}
+	return "func(" + strings.Join(ps, ", ") + ")" + results(ft.Results)
+}
+
+// f32Const returns a Go expression of the float32 value with the bits v.
+func f32Const(v uint32) string {
+	x := math.Float32frombits(v)
+	if x != x || math.IsInf(float64(x), 0) || (x == 0 && v != 0) {
+		return fmt.Sprintf("math.Float32frombits(%#x)", v)
+	}
+	return strconv.FormatFloat(float64(x), 'g', -1, 32)
+}
+
+// f64Const returns a Go expression of the float64 value with the bits v.
+func f64Const(v uint64) string {
+	x := math.Float64frombits(v)
+	if x != x || math.IsInf(x, 0) || (x == 0 && v != 0) {
+		return fmt.Sprintf("math.Float64frombits(%#x)", v)
+	}
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
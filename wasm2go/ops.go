@@ -0,0 +1,173 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2go
+
+import "github.com/sbinet/wasm"
+
+// numeric describes the translation of a numeric instruction.
+type numeric struct {
+	typ  wasm.ValueType // type of the result
+	n    int            // number of operands
+	expr string         // Go expression, formatted with the operands
+}
+
+var numerics = map[wasm.Opcode]numeric{
+	wasm.Op_i32_eqz:  {wasm.I32, 1, "b2i(%[1]s == 0)"},
+	wasm.Op_i32_eq:   {wasm.I32, 2, "b2i(%[1]s == %[2]s)"},
+	wasm.Op_i32_ne:   {wasm.I32, 2, "b2i(%[1]s != %[2]s)"},
+	wasm.Op_i32_lt_s: {wasm.I32, 2, "b2i(%[1]s < %[2]s)"},
+	wasm.Op_i32_lt_u: {wasm.I32, 2, "b2i(uint32(%[1]s) < uint32(%[2]s))"},
+	wasm.Op_i32_gt_s: {wasm.I32, 2, "b2i(%[1]s > %[2]s)"},
+	wasm.Op_i32_gt_u: {wasm.I32, 2, "b2i(uint32(%[1]s) > uint32(%[2]s))"},
+	wasm.Op_i32_le_s: {wasm.I32, 2, "b2i(%[1]s <= %[2]s)"},
+	wasm.Op_i32_le_u: {wasm.I32, 2, "b2i(uint32(%[1]s) <= uint32(%[2]s))"},
+	wasm.Op_i32_ge_s: {wasm.I32, 2, "b2i(%[1]s >= %[2]s)"},
+	wasm.Op_i32_ge_u: {wasm.I32, 2, "b2i(uint32(%[1]s) >= uint32(%[2]s))"},
+	wasm.Op_i64_eqz:  {wasm.I32, 1, "b2i(%[1]s == 0)"},
+	wasm.Op_i64_eq:   {wasm.I32, 2, "b2i(%[1]s == %[2]s)"},
+	wasm.Op_i64_ne:   {wasm.I32, 2, "b2i(%[1]s != %[2]s)"},
+	wasm.Op_i64_lt_s: {wasm.I32, 2, "b2i(%[1]s < %[2]s)"},
+	wasm.Op_i64_lt_u: {wasm.I32, 2, "b2i(uint64(%[1]s) < uint64(%[2]s))"},
+	wasm.Op_i64_gt_s: {wasm.I32, 2, "b2i(%[1]s > %[2]s)"},
+	wasm.Op_i64_gt_u: {wasm.I32, 2, "b2i(uint64(%[1]s) > uint64(%[2]s))"},
+	wasm.Op_i64_le_s: {wasm.I32, 2, "b2i(%[1]s <= %[2]s)"},
+	wasm.Op_i64_le_u: {wasm.I32, 2, "b2i(uint64(%[1]s) <= uint64(%[2]s))"},
+	wasm.Op_i64_ge_s: {wasm.I32, 2, "b2i(%[1]s >= %[2]s)"},
+	wasm.Op_i64_ge_u: {wasm.I32, 2, "b2i(uint64(%[1]s) >= uint64(%[2]s))"},
+	wasm.Op_f32_eq:   {wasm.I32, 2, "b2i(%[1]s == %[2]s)"},
+	wasm.Op_f32_ne:   {wasm.I32, 2, "b2i(%[1]s != %[2]s)"},
+	wasm.Op_f32_lt:   {wasm.I32, 2, "b2i(%[1]s < %[2]s)"},
+	wasm.Op_f32_gt:   {wasm.I32, 2, "b2i(%[1]s > %[2]s)"},
+	wasm.Op_f32_le:   {wasm.I32, 2, "b2i(%[1]s <= %[2]s)"},
+	wasm.Op_f32_ge:   {wasm.I32, 2, "b2i(%[1]s >= %[2]s)"},
+	wasm.Op_f64_eq:   {wasm.I32, 2, "b2i(%[1]s == %[2]s)"},
+	wasm.Op_f64_ne:   {wasm.I32, 2, "b2i(%[1]s != %[2]s)"},
+	wasm.Op_f64_lt:   {wasm.I32, 2, "b2i(%[1]s < %[2]s)"},
+	wasm.Op_f64_gt:   {wasm.I32, 2, "b2i(%[1]s > %[2]s)"},
+	wasm.Op_f64_le:   {wasm.I32, 2, "b2i(%[1]s <= %[2]s)"},
+	wasm.Op_f64_ge:   {wasm.I32, 2, "b2i(%[1]s >= %[2]s)"},
+
+	wasm.Op_i32_clz:    {wasm.I32, 1, "int32(bits.LeadingZeros32(uint32(%[1]s)))"},
+	wasm.Op_i32_ctz:    {wasm.I32, 1, "int32(bits.TrailingZeros32(uint32(%[1]s)))"},
+	wasm.Op_i32_popcnt: {wasm.I32, 1, "int32(bits.OnesCount32(uint32(%[1]s)))"},
+	wasm.Op_i32_add:    {wasm.I32, 2, "%[1]s + %[2]s"},
+	wasm.Op_i32_sub:    {wasm.I32, 2, "%[1]s - %[2]s"},
+	wasm.Op_i32_mul:    {wasm.I32, 2, "%[1]s * %[2]s"},
+	wasm.Op_i32_div_s:  {wasm.I32, 2, "i32divs(%[1]s, %[2]s)"},
+	wasm.Op_i32_div_u:  {wasm.I32, 2, "i32divu(%[1]s, %[2]s)"},
+	wasm.Op_i32_rem_s:  {wasm.I32, 2, "i32rems(%[1]s, %[2]s)"},
+	wasm.Op_i32_rem_u:  {wasm.I32, 2, "i32remu(%[1]s, %[2]s)"},
+	wasm.Op_i32_and:    {wasm.I32, 2, "%[1]s & %[2]s"},
+	wasm.Op_i32_or:     {wasm.I32, 2, "%[1]s | %[2]s"},
+	wasm.Op_i32_xor:    {wasm.I32, 2, "%[1]s ^ %[2]s"},
+	wasm.Op_i32_shl:    {wasm.I32, 2, "%[1]s << (uint32(%[2]s) & 31)"},
+	wasm.Op_i32_shr_s:  {wasm.I32, 2, "%[1]s >> (uint32(%[2]s) & 31)"},
+	wasm.Op_i32_shr_u:  {wasm.I32, 2, "int32(uint32(%[1]s) >> (uint32(%[2]s) & 31))"},
+	wasm.Op_i32_rotl:   {wasm.I32, 2, "i32rotl(%[1]s, %[2]s)"},
+	wasm.Op_i32_rotr:   {wasm.I32, 2, "i32rotr(%[1]s, %[2]s)"},
+	wasm.Op_i64_clz:    {wasm.I64, 1, "int64(bits.LeadingZeros64(uint64(%[1]s)))"},
+	wasm.Op_i64_ctz:    {wasm.I64, 1, "int64(bits.TrailingZeros64(uint64(%[1]s)))"},
+	wasm.Op_i64_popcnt: {wasm.I64, 1, "int64(bits.OnesCount64(uint64(%[1]s)))"},
+	wasm.Op_i64_add:    {wasm.I64, 2, "%[1]s + %[2]s"},
+	wasm.Op_i64_sub:    {wasm.I64, 2, "%[1]s - %[2]s"},
+	wasm.Op_i64_mul:    {wasm.I64, 2, "%[1]s * %[2]s"},
+	wasm.Op_i64_div_s:  {wasm.I64, 2, "i64divs(%[1]s, %[2]s)"},
+	wasm.Op_i64_div_u:  {wasm.I64, 2, "i64divu(%[1]s, %[2]s)"},
+	wasm.Op_i64_rem_s:  {wasm.I64, 2, "i64rems(%[1]s, %[2]s)"},
+	wasm.Op_i64_rem_u:  {wasm.I64, 2, "i64remu(%[1]s, %[2]s)"},
+	wasm.Op_i64_and:    {wasm.I64, 2, "%[1]s & %[2]s"},
+	wasm.Op_i64_or:     {wasm.I64, 2, "%[1]s | %[2]s"},
+	wasm.Op_i64_xor:    {wasm.I64, 2, "%[1]s ^ %[2]s"},
+	wasm.Op_i64_shl:    {wasm.I64, 2, "%[1]s << (uint64(%[2]s) & 63)"},
+	wasm.Op_i64_shr_s:  {wasm.I64, 2, "%[1]s >> (uint64(%[2]s) & 63)"},
+	wasm.Op_i64_shr_u:  {wasm.I64, 2, "int64(uint64(%[1]s) >> (uint64(%[2]s) & 63))"},
+	wasm.Op_i64_rotl:   {wasm.I64, 2, "i64rotl(%[1]s, %[2]s)"},
+	wasm.Op_i64_rotr:   {wasm.I64, 2, "i64rotr(%[1]s, %[2]s)"},
+
+	// the arithmetic on floats is converted explicitly to its type, for
+	// the compiler not to fuse multiplications and additions.
+	wasm.Op_f32_abs:      {wasm.F32, 1, "f32abs(%[1]s)"},
+	wasm.Op_f32_neg:      {wasm.F32, 1, "f32neg(%[1]s)"},
+	wasm.Op_f32_ceil:     {wasm.F32, 1, "float32(math.Ceil(float64(%[1]s)))"},
+	wasm.Op_f32_floor:    {wasm.F32, 1, "float32(math.Floor(float64(%[1]s)))"},
+	wasm.Op_f32_trunc:    {wasm.F32, 1, "float32(math.Trunc(float64(%[1]s)))"},
+	wasm.Op_f32_nearest:  {wasm.F32, 1, "float32(math.RoundToEven(float64(%[1]s)))"},
+	wasm.Op_f32_sqrt:     {wasm.F32, 1, "float32(math.Sqrt(float64(%[1]s)))"},
+	wasm.Op_f32_add:      {wasm.F32, 2, "float32(%[1]s + %[2]s)"},
+	wasm.Op_f32_sub:      {wasm.F32, 2, "float32(%[1]s - %[2]s)"},
+	wasm.Op_f32_mul:      {wasm.F32, 2, "float32(%[1]s * %[2]s)"},
+	wasm.Op_f32_div:      {wasm.F32, 2, "float32(%[1]s / %[2]s)"},
+	wasm.Op_f32_min:      {wasm.F32, 2, "float32(fmin(float64(%[1]s), float64(%[2]s)))"},
+	wasm.Op_f32_max:      {wasm.F32, 2, "float32(fmax(float64(%[1]s), float64(%[2]s)))"},
+	wasm.Op_f32_copysign: {wasm.F32, 2, "f32copysign(%[1]s, %[2]s)"},
+	wasm.Op_f64_abs:      {wasm.F64, 1, "f64abs(%[1]s)"},
+	wasm.Op_f64_neg:      {wasm.F64, 1, "f64neg(%[1]s)"},
+	wasm.Op_f64_ceil:     {wasm.F64, 1, "math.Ceil(%[1]s)"},
+	wasm.Op_f64_floor:    {wasm.F64, 1, "math.Floor(%[1]s)"},
+	wasm.Op_f64_trunc:    {wasm.F64, 1, "math.Trunc(%[1]s)"},
+	wasm.Op_f64_nearest:  {wasm.F64, 1, "math.RoundToEven(%[1]s)"},
+	wasm.Op_f64_sqrt:     {wasm.F64, 1, "math.Sqrt(%[1]s)"},
+	wasm.Op_f64_add:      {wasm.F64, 2, "float64(%[1]s + %[2]s)"},
+	wasm.Op_f64_sub:      {wasm.F64, 2, "float64(%[1]s - %[2]s)"},
+	wasm.Op_f64_mul:      {wasm.F64, 2, "float64(%[1]s * %[2]s)"},
+	wasm.Op_f64_div:      {wasm.F64, 2, "float64(%[1]s / %[2]s)"},
+	wasm.Op_f64_min:      {wasm.F64, 2, "fmin(%[1]s, %[2]s)"},
+	wasm.Op_f64_max:      {wasm.F64, 2, "fmax(%[1]s, %[2]s)"},
+	wasm.Op_f64_copysign: {wasm.F64, 2, "f64copysign(%[1]s, %[2]s)"},
+
+	wasm.Op_i32_wrap_i64:        {wasm.I32, 1, "int32(%[1]s)"},
+	wasm.Op_i32_trunc_s_f32:     {wasm.I32, 1, "int32(truncs(float64(%[1]s), -1<<31, 1<<31))"},
+	wasm.Op_i32_trunc_u_f32:     {wasm.I32, 1, "int32(truncu(float64(%[1]s), 1<<32))"},
+	wasm.Op_i32_trunc_s_f64:     {wasm.I32, 1, "int32(truncs(%[1]s, -1<<31, 1<<31))"},
+	wasm.Op_i32_trunc_u_f64:     {wasm.I32, 1, "int32(truncu(%[1]s, 1<<32))"},
+	wasm.Op_i64_extend_s_i32:    {wasm.I64, 1, "int64(%[1]s)"},
+	wasm.Op_i64_extend_u_i32:    {wasm.I64, 1, "int64(uint32(%[1]s))"},
+	wasm.Op_i64_trunc_s_f32:     {wasm.I64, 1, "truncs(float64(%[1]s), -1<<63, 1<<63)"},
+	wasm.Op_i64_trunc_u_f32:     {wasm.I64, 1, "int64(truncu(float64(%[1]s), 1<<64))"},
+	wasm.Op_i64_trunc_s_f64:     {wasm.I64, 1, "truncs(%[1]s, -1<<63, 1<<63)"},
+	wasm.Op_i64_trunc_u_f64:     {wasm.I64, 1, "int64(truncu(%[1]s, 1<<64))"},
+	wasm.Op_f32_convert_s_i32:   {wasm.F32, 1, "float32(%[1]s)"},
+	wasm.Op_f32_convert_u_i32:   {wasm.F32, 1, "float32(uint32(%[1]s))"},
+	wasm.Op_f32_convert_s_i64:   {wasm.F32, 1, "float32(%[1]s)"},
+	wasm.Op_f32_convert_u_i64:   {wasm.F32, 1, "u64tof32(uint64(%[1]s))"},
+	wasm.Op_f32_demote_f64:      {wasm.F32, 1, "float32(%[1]s)"},
+	wasm.Op_f64_convert_s_i32:   {wasm.F64, 1, "float64(%[1]s)"},
+	wasm.Op_f64_convert_u_i32:   {wasm.F64, 1, "float64(uint32(%[1]s))"},
+	wasm.Op_f64_convert_s_i64:   {wasm.F64, 1, "float64(%[1]s)"},
+	wasm.Op_f64_convert_u_i64:   {wasm.F64, 1, "float64(uint64(%[1]s))"},
+	wasm.Op_f64_promote_f32:     {wasm.F64, 1, "float64(%[1]s)"},
+	wasm.Op_i32_reinterpret_f32: {wasm.I32, 1, "int32(math.Float32bits(%[1]s))"},
+	wasm.Op_i64_reinterpret_f64: {wasm.I64, 1, "int64(math.Float64bits(%[1]s))"},
+	wasm.Op_f32_reinterpret_i32: {wasm.F32, 1, "math.Float32frombits(uint32(%[1]s))"},
+	wasm.Op_f64_reinterpret_i64: {wasm.F64, 1, "math.Float64frombits(uint64(%[1]s))"},
+}
+
+// accesses holds the helpers of the generated code implementing the load
+// and store instructions.
+var accesses = map[wasm.Opcode]string{
+	wasm.Op_i32_load:     "i32load",
+	wasm.Op_i64_load:     "i64load",
+	wasm.Op_f32_load:     "f32load",
+	wasm.Op_f64_load:     "f64load",
+	wasm.Op_i32_load8_s:  "i32load8s",
+	wasm.Op_i32_load8_u:  "i32load8u",
+	wasm.Op_i32_load16_s: "i32load16s",
+	wasm.Op_i32_load16_u: "i32load16u",
+	wasm.Op_i64_load8_s:  "i64load8s",
+	wasm.Op_i64_load8_u:  "i64load8u",
+	wasm.Op_i64_load16_s: "i64load16s",
+	wasm.Op_i64_load16_u: "i64load16u",
+	wasm.Op_i64_load32_s: "i64load32s",
+	wasm.Op_i64_load32_u: "i64load32u",
+	wasm.Op_i32_store:    "i32store",
+	wasm.Op_i64_store:    "i64store",
+	wasm.Op_f32_store:    "f32store",
+	wasm.Op_f64_store:    "f64store",
+	wasm.Op_i32_store8:   "i32store8",
+	wasm.Op_i32_store16:  "i32store16",
+	wasm.Op_i64_store8:   "i64store8",
+	wasm.Op_i64_store16:  "i64store16",
+	wasm.Op_i64_store32:  "i64store32",
+}
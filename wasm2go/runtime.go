@@ -0,0 +1,321 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2go
+
+// runtime is the source code of the helpers of the generated code,
+// appended to every translated module.
+const runtime = `
+// Trap is the error returned by the exported functions of Module when
+// their execution traps.
+type Trap string
+
+func (t Trap) Error() string { return string(t) }
+
+const (
+	pageSize     = 65536 // size of a page of the memory, in bytes
+	maxCallDepth = 10000 // maximum depth of the calls into the module
+)
+
+// catch recovers from a trap, or from a host function panicking with an
+// error, into *err and restores the depth of the calls.
+func (m *Module) catch(err *error, depth int) {
+	switch e := recover().(type) {
+	case nil:
+	case runtime.Error:
+		panic(e)
+	case error:
+		m.depth = depth
+		*err = e
+	default:
+		panic(e)
+	}
+}
+
+// enter records a call to a function of the module, or traps if the
+// calls are too deep.
+func (m *Module) enter() {
+	m.depth++
+	if m.depth > maxCallDepth {
+		panic(Trap("call stack exhausted"))
+	}
+}
+
+// initElems copies elems into the table, starting at off.
+func (m *Module) initElems(off int32, elems ...interface{}) {
+	if uint64(uint32(off))+uint64(len(elems)) > uint64(len(m.table)) {
+		panic(Trap("out of bounds table access"))
+	}
+	copy(m.table[uint32(off):], elems)
+}
+
+// initData copies data into the memory, starting at off.
+func (m *Module) initData(off int32, data string) {
+	if uint64(uint32(off))+uint64(len(data)) > uint64(len(m.mem)) {
+		panic(Trap("out of bounds memory access"))
+	}
+	copy(m.mem[uint32(off):], data)
+}
+
+// elem returns the i-th element of the table, or traps if it is out of
+// bounds or uninitialized.
+func (m *Module) elem(i int32) interface{} {
+	if uint64(uint32(i)) >= uint64(len(m.table)) {
+		panic(Trap("undefined element"))
+	}
+	f := m.table[uint32(i)]
+	if f == nil {
+		panic(Trap("uninitialized element"))
+	}
+	return f
+}
+
+// addr returns the effective address of an access of n bytes at the
+// address a plus the offset off, or traps if it is out of bounds.
+func (m *Module) addr(a int32, off uint32, n uint64) uint64 {
+	ea := uint64(uint32(a)) + uint64(off)
+	if ea+n > uint64(len(m.mem)) {
+		panic(Trap("out of bounds memory access"))
+	}
+	return ea
+}
+
+func (m *Module) i32load(a int32, off uint32) int32 {
+	return int32(binary.LittleEndian.Uint32(m.mem[m.addr(a, off, 4):]))
+}
+
+func (m *Module) i64load(a int32, off uint32) int64 {
+	return int64(binary.LittleEndian.Uint64(m.mem[m.addr(a, off, 8):]))
+}
+
+func (m *Module) f32load(a int32, off uint32) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(m.mem[m.addr(a, off, 4):]))
+}
+
+func (m *Module) f64load(a int32, off uint32) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(m.mem[m.addr(a, off, 8):]))
+}
+
+func (m *Module) i32load8s(a int32, off uint32) int32 { return int32(int8(m.mem[m.addr(a, off, 1)])) }
+func (m *Module) i32load8u(a int32, off uint32) int32 { return int32(m.mem[m.addr(a, off, 1)]) }
+
+func (m *Module) i32load16s(a int32, off uint32) int32 {
+	return int32(int16(binary.LittleEndian.Uint16(m.mem[m.addr(a, off, 2):])))
+}
+
+func (m *Module) i32load16u(a int32, off uint32) int32 {
+	return int32(binary.LittleEndian.Uint16(m.mem[m.addr(a, off, 2):]))
+}
+
+func (m *Module) i64load8s(a int32, off uint32) int64 { return int64(int8(m.mem[m.addr(a, off, 1)])) }
+func (m *Module) i64load8u(a int32, off uint32) int64 { return int64(m.mem[m.addr(a, off, 1)]) }
+
+func (m *Module) i64load16s(a int32, off uint32) int64 {
+	return int64(int16(binary.LittleEndian.Uint16(m.mem[m.addr(a, off, 2):])))
+}
+
+func (m *Module) i64load16u(a int32, off uint32) int64 {
+	return int64(binary.LittleEndian.Uint16(m.mem[m.addr(a, off, 2):]))
+}
+
+func (m *Module) i64load32s(a int32, off uint32) int64 {
+	return int64(int32(binary.LittleEndian.Uint32(m.mem[m.addr(a, off, 4):])))
+}
+
+func (m *Module) i64load32u(a int32, off uint32) int64 {
+	return int64(binary.LittleEndian.Uint32(m.mem[m.addr(a, off, 4):]))
+}
+
+func (m *Module) i32store(a int32, off uint32, v int32) {
+	binary.LittleEndian.PutUint32(m.mem[m.addr(a, off, 4):], uint32(v))
+}
+
+func (m *Module) i64store(a int32, off uint32, v int64) {
+	binary.LittleEndian.PutUint64(m.mem[m.addr(a, off, 8):], uint64(v))
+}
+
+func (m *Module) f32store(a int32, off uint32, v float32) {
+	binary.LittleEndian.PutUint32(m.mem[m.addr(a, off, 4):], math.Float32bits(v))
+}
+
+func (m *Module) f64store(a int32, off uint32, v float64) {
+	binary.LittleEndian.PutUint64(m.mem[m.addr(a, off, 8):], math.Float64bits(v))
+}
+
+func (m *Module) i32store8(a int32, off uint32, v int32) { m.mem[m.addr(a, off, 1)] = byte(v) }
+
+func (m *Module) i32store16(a int32, off uint32, v int32) {
+	binary.LittleEndian.PutUint16(m.mem[m.addr(a, off, 2):], uint16(v))
+}
+
+func (m *Module) i64store8(a int32, off uint32, v int64) { m.mem[m.addr(a, off, 1)] = byte(v) }
+
+func (m *Module) i64store16(a int32, off uint32, v int64) {
+	binary.LittleEndian.PutUint16(m.mem[m.addr(a, off, 2):], uint16(v))
+}
+
+func (m *Module) i64store32(a int32, off uint32, v int64) {
+	binary.LittleEndian.PutUint32(m.mem[m.addr(a, off, 4):], uint32(v))
+}
+
+// grow grows the memory by delta pages and returns its previous size, or
+// -1 if it cannot grow.
+func (m *Module) grow(delta int32) int32 {
+	n := len(m.mem) / pageSize
+	if uint64(uint32(delta)) > uint64(m.max-n) {
+		return -1
+	}
+	m.mem = append(m.mem, make([]byte, int(uint32(delta))*pageSize)...)
+	return int32(n)
+}
+
+func b2i(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func i32divs(a, b int32) int32 {
+	switch {
+	case b == 0:
+		panic(Trap("integer divide by zero"))
+	case a == math.MinInt32 && b == -1:
+		panic(Trap("integer overflow"))
+	}
+	return a / b
+}
+
+func i32divu(a, b int32) int32 {
+	if b == 0 {
+		panic(Trap("integer divide by zero"))
+	}
+	return int32(uint32(a) / uint32(b))
+}
+
+func i32rems(a, b int32) int32 {
+	switch b {
+	case 0:
+		panic(Trap("integer divide by zero"))
+	case -1:
+		return 0
+	}
+	return a % b
+}
+
+func i32remu(a, b int32) int32 {
+	if b == 0 {
+		panic(Trap("integer divide by zero"))
+	}
+	return int32(uint32(a) % uint32(b))
+}
+
+func i64divs(a, b int64) int64 {
+	switch {
+	case b == 0:
+		panic(Trap("integer divide by zero"))
+	case a == math.MinInt64 && b == -1:
+		panic(Trap("integer overflow"))
+	}
+	return a / b
+}
+
+func i64divu(a, b int64) int64 {
+	if b == 0 {
+		panic(Trap("integer divide by zero"))
+	}
+	return int64(uint64(a) / uint64(b))
+}
+
+func i64rems(a, b int64) int64 {
+	switch b {
+	case 0:
+		panic(Trap("integer divide by zero"))
+	case -1:
+		return 0
+	}
+	return a % b
+}
+
+func i64remu(a, b int64) int64 {
+	if b == 0 {
+		panic(Trap("integer divide by zero"))
+	}
+	return int64(uint64(a) % uint64(b))
+}
+
+func i32rotl(a, b int32) int32 { return int32(bits.RotateLeft32(uint32(a), int(b&31))) }
+func i32rotr(a, b int32) int32 { return int32(bits.RotateLeft32(uint32(a), -int(b&31))) }
+func i64rotl(a, b int64) int64 { return int64(bits.RotateLeft64(uint64(a), int(b&63))) }
+func i64rotr(a, b int64) int64 { return int64(bits.RotateLeft64(uint64(a), -int(b&63))) }
+
+func f32abs(v float32) float32 { return math.Float32frombits(math.Float32bits(v) &^ (1 << 31)) }
+func f32neg(v float32) float32 { return math.Float32frombits(math.Float32bits(v) ^ (1 << 31)) }
+func f64abs(v float64) float64 { return math.Float64frombits(math.Float64bits(v) &^ (1 << 63)) }
+func f64neg(v float64) float64 { return math.Float64frombits(math.Float64bits(v) ^ (1 << 63)) }
+
+func f32copysign(a, b float32) float32 {
+	const sign = 1 << 31
+	return math.Float32frombits(math.Float32bits(a)&^sign | math.Float32bits(b)&sign)
+}
+
+func f64copysign(a, b float64) float64 {
+	const sign = 1 << 63
+	return math.Float64frombits(math.Float64bits(a)&^sign | math.Float64bits(b)&sign)
+}
+
+// fmin and fmax follow the semantics of the min and max instructions:
+// NaN if either operand is NaN, and -0 is less than +0.
+func fmin(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Min(a, b)
+}
+
+func fmax(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Max(a, b)
+}
+
+// truncs truncates v to a signed integer in the range [min, max), or traps.
+func truncs(v, min, max float64) int64 {
+	if v != v {
+		panic(Trap("invalid conversion to integer"))
+	}
+	v = math.Trunc(v)
+	if v < min || v >= max {
+		panic(Trap("integer overflow"))
+	}
+	return int64(v)
+}
+
+// truncu truncates v to an unsigned integer in the range [0, max), or traps.
+func truncu(v, max float64) uint64 {
+	if v != v {
+		panic(Trap("invalid conversion to integer"))
+	}
+	v = math.Trunc(v)
+	if v <= -1 || v >= max {
+		panic(Trap("integer overflow"))
+	}
+	if v >= 1<<63 {
+		return uint64(v-(1<<63)) | 1<<63
+	}
+	return uint64(v)
+}
+
+// u64tof32 converts v to the nearest float32, rounding ties to even.
+// Converting to float64 first would round twice.
+func u64tof32(v uint64) float32 {
+	if v < 1<<63 {
+		return float32(int64(v))
+	}
+	// halve v, keeping the lowest bit sticky for the rounding.
+	f := float32(int64(v>>1 | v&1))
+	return f * 2
+}
+`
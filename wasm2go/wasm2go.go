@@ -0,0 +1,313 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasm2go translates WebAssembly modules to Go source code.
+//
+// A module is translated to a package declaring a Module type, whose
+// values are instances of the module created by the New function of the
+// package:
+//
+//   - each function of the module becomes a method of Module;
+//   - its linear memory becomes a []byte, and its globals fields of Module;
+//   - its imports are provided by the caller, through the methods of the
+//     Imports interface of the package;
+//   - its exported functions, memories and globals become exported methods
+//     of Module, named after their export names.
+//
+// The exported functions return a Trap error when their execution traps,
+// with the message of the corresponding trap of the exec package. Imported
+// functions may abort their caller by panicking with an error, returned
+// by the exported function called.
+//
+// The generated code only depends on the standard library. The modules
+// must use only the features of the MVP, and may not import memories or
+// tables.
+package wasm2go
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sbinet/wasm"
+	"github.com/sbinet/wasm/internal/translate"
+)
+
+// Options configures the translation of a module.
+type Options struct {
+	// Package is the name of the package of the generated code, "module"
+	// by default.
+	Package string
+}
+
+// generator translates a module.
+type generator struct {
+	buf bytes.Buffer
+
+	*translate.Module
+
+	methods  map[string]bool   // names of the exported methods of Module
+	hosts    map[string]bool   // names of the methods of Imports
+	hfuncs   map[uint32]string // methods of Imports, by imported function index
+	hglobals map[uint32]string // methods of Imports, by imported global index
+}
+
+// Translate translates the module m to the source code of a Go package.
+func Translate(m *wasm.Module, opts Options) ([]byte, error) {
+	if err := wasm.Validate(m, wasm.FeaturesMVP); err != nil {
+		return nil, err
+	}
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "module"
+	}
+
+	mod, err := translate.NewModule(m)
+	if err != nil {
+		return nil, fmt.Errorf("wasm2go: %w", err)
+	}
+	g := &generator{
+		Module:   mod,
+		methods:  make(map[string]bool),
+		hosts:    make(map[string]bool),
+		hfuncs:   make(map[uint32]string),
+		hglobals: make(map[uint32]string),
+	}
+
+	g.printf("// Code generated by wasm2go. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\t\"encoding/binary\"\n\t\"math\"\n\t\"math/bits\"\n\t\"runtime\"\n)\n\n")
+	g.genImports()
+	g.genModule()
+	if err := g.genInit(); err != nil {
+		return nil, err
+	}
+	g.genExports()
+	if err := g.genFuncs(); err != nil {
+		return nil, err
+	}
+	g.buf.WriteString(runtime)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("wasm2go: invalid generated code: %w", err)
+	}
+	return src, nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// genImports generates the Imports interface.
+func (g *generator) genImports() {
+	g.printf("// Imports provides the imports of the module.\n")
+	g.printf("type Imports interface {\n")
+	var fn, gl uint32
+	for i, e := range g.Imports {
+		if i > 0 {
+			g.printf("\n")
+		}
+		name := translate.Unique(g.hosts, exported(e.Module)+exported(e.Field))
+		switch e.Kind {
+		case wasm.FunctionKind:
+			ft := g.Types[e.Type.(uint32)]
+			g.printf("\t// %s implements the function %q imported from %q.\n", name, e.Field, e.Module)
+			g.printf("\t%s(%s)%s\n", name, params(ft.Params), results(ft.Results))
+			g.hfuncs[fn] = name
+			fn++
+		case wasm.GlobalKind:
+			gt := e.Type.(wasm.GlobalType)
+			g.printf("\t// %s returns the value of the global %q imported from %q.\n", name, e.Field, e.Module)
+			g.printf("\t%s() %s\n", name, goType(gt.ContentType))
+			g.hglobals[gl] = name
+			gl++
+		}
+	}
+	g.printf("}\n\n")
+}
+
+// genModule generates the Module type and its constructor.
+func (g *generator) genModule() {
+	g.printf("// Module is an instance of the module.\n")
+	g.printf("type Module struct {\n")
+	g.printf("\timports Imports\n")
+	g.printf("\tmem     []byte\n")
+	g.printf("\tmax     int // maximum number of pages of the memory\n")
+	g.printf("\ttable   []interface{}\n")
+	g.printf("\tdepth   int // depth of the calls into the module\n")
+	for i, gt := range g.Globals {
+		g.printf("\t%s %s\n", translate.GlobalName(uint32(i)), goType(gt.ContentType))
+	}
+	g.printf("}\n\n")
+
+	g.printf("// New instantiates the module with the given imports, then runs its\n")
+	g.printf("// start function, if any.\n")
+	g.printf("func New(imports Imports) (*Module, error) {\n")
+	g.printf("\tm := &Module{imports: imports}\n")
+	g.printf("\tif err := m.init(); err != nil {\n\t\treturn nil, err\n\t}\n")
+	g.printf("\treturn m, nil\n")
+	g.printf("}\n\n")
+}
+
+// genInit generates the initialization of the instances.
+func (g *generator) genInit() error {
+	g.printf("func (m *Module) init() (err error) {\n")
+	g.printf("\tdefer m.catch(&err, 0)\n")
+	if g.Mem != nil {
+		max := uint64(65536)
+		if g.Mem.Limits.Flags&wasm.LimitsMax != 0 {
+			max = g.Mem.Limits.Maximum
+		}
+		g.printf("\tm.mem = make([]byte, %d*pageSize)\n", g.Mem.Limits.Initial)
+		g.printf("\tm.max = %d\n", max)
+	}
+	var nimported uint32
+	for _, e := range g.Imports {
+		if e.Kind == wasm.GlobalKind {
+			g.printf("\tm.%s = m.imports.%s()\n", translate.GlobalName(nimported), g.hglobals[nimported])
+			nimported++
+		}
+	}
+	for i, gv := range g.Inits {
+		v, err := g.constExpr(gv.Init)
+		if err != nil {
+			return err
+		}
+		g.printf("\tm.%s = %s\n", translate.GlobalName(nimported+uint32(i)), v)
+	}
+	if g.Table != nil {
+		g.printf("\tm.table = make([]interface{}, %d)\n", g.Table.Limits.Initial)
+	}
+	for _, es := range g.Elems {
+		off, err := g.constExpr(es.Offset)
+		if err != nil {
+			return err
+		}
+		elems := make([]string, len(es.Elems))
+		for i, idx := range es.Elems {
+			elems[i] = "m." + translate.FuncName(idx)
+		}
+		g.printf("\tm.initElems(%s, %s)\n", off, strings.Join(elems, ", "))
+	}
+	for _, ds := range g.Data {
+		off, err := g.constExpr(ds.Offset)
+		if err != nil {
+			return err
+		}
+		g.printf("\tm.initData(%s, %q)\n", off, ds.Data)
+	}
+	if g.Start != nil {
+		g.printf("\tm.%s()\n", translate.FuncName(*g.Start))
+	}
+	g.printf("\treturn nil\n")
+	g.printf("}\n\n")
+	return nil
+}
+
+// genExports generates the methods of the exports of the module.
+func (g *generator) genExports() {
+	exports := append([]wasm.ExportEntry(nil), g.Exports...)
+	sort.SliceStable(exports, func(i, j int) bool { return exports[i].Field < exports[j].Field })
+	for _, e := range exports {
+		name := translate.Unique(g.methods, exported(e.Field))
+		switch e.Kind {
+		case wasm.FunctionKind:
+			ft := g.Funcs[e.Index]
+			args := make([]string, len(ft.Params))
+			for i := range args {
+				args[i] = translate.LocalName(i)
+			}
+			g.printf("// %s calls the function exported as %q.\n", name, e.Field)
+			if len(ft.Results) == 0 {
+				g.printf("func (m *Module) %s(%s) (err error) {\n", name, params(ft.Params))
+				g.printf("\tdefer m.catch(&err, m.depth)\n")
+				g.printf("\tm.%s(%s)\n", translate.FuncName(e.Index), strings.Join(args, ", "))
+				g.printf("\treturn nil\n")
+			} else {
+				g.printf("func (m *Module) %s(%s) (r %s, err error) {\n", name, params(ft.Params), goType(ft.Results[0]))
+				g.printf("\tdefer m.catch(&err, m.depth)\n")
+				g.printf("\treturn m.%s(%s), nil\n", translate.FuncName(e.Index), strings.Join(args, ", "))
+			}
+			g.printf("}\n\n")
+		case wasm.MemoryKind:
+			g.printf("// %s returns the memory exported as %q.\n", name, e.Field)
+			g.printf("// The returned slice is invalidated when the memory grows.\n")
+			g.printf("func (m *Module) %s() []byte { return m.mem }\n\n", name)
+		case wasm.GlobalKind:
+			g.printf("// %s returns the value of the global exported as %q.\n", name, e.Field)
+			g.printf("func (m *Module) %s() %s { return m.%s }\n\n", name, goType(g.Globals[e.Index].ContentType), translate.GlobalName(e.Index))
+		}
+	}
+}
+
+// genFuncs generates the methods of the functions of the module.
+func (g *generator) genFuncs() error {
+	for i := 0; i < g.NumImports; i++ {
+		idx := uint32(i)
+		ft := g.Funcs[idx]
+		args := make([]string, len(ft.Params))
+		for j := range args {
+			args[j] = translate.LocalName(j)
+		}
+		ret := ""
+		if len(ft.Results) > 0 {
+			ret = "return "
+		}
+		g.printf("func (m *Module) %s(%s)%s {\n", translate.FuncName(idx), params(ft.Params), results(ft.Results))
+		g.printf("\t%sm.imports.%s(%s)\n", ret, g.hfuncs[idx], strings.Join(args, ", "))
+		g.printf("}\n\n")
+	}
+	for i := range g.Bodies {
+		idx := uint32(g.NumImports + i)
+		src, err := g.genFunc(translate.FuncName(idx), i)
+		if err != nil {
+			return fmt.Errorf("wasm2go: function %d: %w", idx, err)
+		}
+		if name, ok := g.Names[idx]; ok {
+			g.printf("// %s is the function %q.\n", translate.FuncName(idx), name)
+		}
+		g.printf("%s\n", src)
+	}
+	return nil
+}
+
+// constExpr returns the Go expression of the value of a constant
+// expression.
+func (g *generator) constExpr(ie wasm.InitExpr) (string, error) {
+	v, err := translate.ConstExpr(g, ie)
+	if err != nil {
+		return "", fmt.Errorf("wasm2go: %w", err)
+	}
+	return v, nil
+}
+
+// exported returns an exported Go identifier derived from name.
+func exported(name string) string {
+	var (
+		b  strings.Builder
+		up = true
+	)
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			up = true
+			continue
+		}
+		if b.Len() == 0 && !unicode.IsUpper(unicode.ToUpper(r)) {
+			b.WriteByte('X')
+		}
+		if up {
+			r = unicode.ToUpper(r)
+			up = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
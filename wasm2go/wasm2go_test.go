@@ -0,0 +1,230 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm2go_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sbinet/wasm"
+	wexec "github.com/sbinet/wasm/exec"
+	"github.com/sbinet/wasm/internal/wasmtest"
+	"github.com/sbinet/wasm/wasm2go"
+)
+
+// driver is the main function of the program running the test vectors
+// with the generated code.
+const driver = `package main
+
+import (
+	"fmt"
+	"math"
+)
+
+type imports struct{}
+
+func (imports) EnvAdd3(x int32) int32 { return x + 3 }
+func (imports) EnvBase() int32        { return 2 }
+
+func show(r interface{}, err error) {
+	if err != nil {
+		fmt.Printf("trap: %v\n", err)
+		return
+	}
+	var v uint64
+	switch r := r.(type) {
+	case int32:
+		v = uint64(uint32(r))
+	case int64:
+		v = uint64(r)
+	case float32:
+		v = uint64(math.Float32bits(r))
+	case float64:
+		v = math.Float64bits(r)
+	}
+	fmt.Printf("%#x\n", v)
+}
+
+func main() {
+	m, err := New(imports{})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%q\n", m.Memory()[16:21])
+`
+
+func TestTranslate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the compilation of the generated code in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	m := wasmtest.Build(t, wasmtest.NewHostModule, wasmtest.NewModule)
+	src, err := wasm2go.Translate(m, wasm2go.Options{Package: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	inst, err := wexec.InstantiateWithOptions(ctx, m, wexec.InstantiateOptions{Imports: wasmtest.Imports()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f64 := func(v float64) uint64 { return math.Float64bits(v) }
+	vectors := []struct {
+		name   string
+		method string
+		args   []uint64
+	}{
+		{"fac", "Fac", []uint64{0}},
+		{"fac", "Fac", []uint64{20}},
+		{"sum", "Sum", []uint64{100}},
+		{"switch", "Switch", []uint64{0}},
+		{"switch", "Switch", []uint64{2}},
+		{"switch", "Switch", []uint64{7}},
+		{"switch", "Switch", []uint64{0xffffffff}},
+		{"swap", "Swap", []uint64{1, 2}},
+		{"brvalue", "Brvalue", []uint64{1}},
+		{"brvalue", "Brvalue", []uint64{0}},
+		{"select", "Select", []uint64{1}},
+		{"select", "Select", []uint64{0}},
+		{"tee", "Tee", []uint64{3}},
+		{"orminus", "Orminus", []uint64{4}},
+		{"orminus", "Orminus", []uint64{0}},
+		{"fib", "Fib", []uint64{90}},
+		{"dead", "Dead", nil},
+		{"load", "Load", []uint64{16}},
+		{"load", "Load", []uint64{28}},
+		{"load", "Load", []uint64{0xffffffff}},
+		{"store", "Store", []uint64{32, 0x12345678}},
+		{"dispatch", "Dispatch", []uint64{0, 7, 3}},
+		{"dispatch", "Dispatch", []uint64{1, 7, 3}},
+		{"dispatch", "Dispatch", []uint64{2, 7, 3}},
+		{"dispatch", "Dispatch", []uint64{3, 7, 3}},
+		{"dispatch", "Dispatch", []uint64{4, 7, 3}},
+		{"incr", "Incr", nil},
+		{"host", "Host", []uint64{36}},
+		{"grow", "Grow", []uint64{1}},
+		{"grow", "Grow", []uint64{1}},
+		{"load", "Load", []uint64{0x1ffff}},
+		{"trap", "Trap", []uint64{0}},
+		{"trap", "Trap", []uint64{1}},
+		{"ping", "Ping", nil},
+		{"incr", "Incr", nil},
+		{"divs", "Divs", []uint64{7, 0xfffffffffffffffe}},
+		{"divs", "Divs", []uint64{7, 0}},
+		{"divs", "Divs", []uint64{1 << 63, 0xffffffffffffffff}},
+		{"remu", "Remu", []uint64{7, 0xfffffffe}},
+		{"bits", "Bits", []uint64{0x80000001, 33}},
+		{"fops", "Fops", []uint64{f64(2), f64(-0.5)}},
+		{"fops", "Fops", []uint64{f64(math.NaN()), f64(1)}},
+		{"trunc", "Trunc", []uint64{f64(-3.9)}},
+		{"trunc", "Trunc", []uint64{f64(3e9)}},
+		{"trunc", "Trunc", []uint64{f64(math.NaN())}},
+		{"conv", "Conv", []uint64{0xffffffffffffffff}},
+		{"neg", "Neg", []uint64{0}},
+		{"loopvalue", "Loopvalue", []uint64{5}},
+	}
+
+	var drv strings.Builder
+	drv.WriteString(driver)
+	for _, v := range vectors {
+		ft := inst.Function(v.name).Type()
+		args := make([]string, len(v.args))
+		for i, a := range v.args {
+			switch ft.Params[i] {
+			case wasm.I32:
+				args[i] = fmt.Sprintf("%d", int32(a))
+			case wasm.I64:
+				args[i] = fmt.Sprintf("%d", int64(a))
+			case wasm.F32:
+				args[i] = fmt.Sprintf("math.Float32frombits(%#x)", uint32(a))
+			case wasm.F64:
+				args[i] = fmt.Sprintf("math.Float64frombits(%#x)", a)
+			}
+		}
+		call := fmt.Sprintf("m.%s(%s)", v.method, strings.Join(args, ", "))
+		if len(ft.Results) == 0 {
+			fmt.Fprintf(&drv, "\tshow(nil, %s)\n", call)
+		} else {
+			fmt.Fprintf(&drv, "\tshow(%s)\n", call)
+		}
+	}
+	fmt.Fprintf(&drv, "\tshow(m.Counter(), nil)\n}\n")
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"go.mod":    []byte("module wasm2gotest\n"),
+		"module.go": src,
+		"main.go":   []byte(drv.String()),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=", "GOWORK=off")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("could not run the generated code: %v\n%s\n%s", err, stderr.Bytes(), src)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != len(vectors)+2 {
+		t.Fatalf("invalid output:\n%s", output)
+	}
+	if got, want := lines[0], `"hello"`; got != want {
+		t.Fatalf("invalid memory: got=%s, want=%s", got, want)
+	}
+	for i, v := range vectors {
+		got := lines[i+1]
+		res, err := inst.Call(ctx, v.name, v.args...)
+		switch {
+		case err != nil:
+			msg := strings.TrimPrefix(got, "trap: ")
+			if msg == got || !strings.Contains(err.Error(), msg) {
+				t.Errorf("%s%#x: got=%s, want trap %v", v.name, v.args, got, err)
+			}
+		default:
+			want := "0x0"
+			if len(res) > 0 {
+				want = fmt.Sprintf("%#x", res[0])
+			}
+			if got != want {
+				t.Errorf("%s%#x: got=%s, want=%s", v.name, v.args, got, want)
+			}
+		}
+	}
+	if got, want := lines[len(lines)-1], fmt.Sprintf("%#x", inst.Global("counter").Get()); got != want {
+		t.Errorf("invalid counter: got=%s, want=%s", got, want)
+	}
+}
+
+func TestTranslateUnsupported(t *testing.T) {
+	b := wasm.NewBuilder()
+	b.ImportMemory("env", "memory", wasm.MemoryType{Limits: wasm.ResizableLimits{Initial: 1}})
+	m, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wasm2go.Translate(m, wasm2go.Options{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported import of memory env.memory") {
+		t.Fatalf("invalid error: %v", err)
+	}
+}